@@ -0,0 +1,48 @@
+// cmd/bulk.go - "bulk" subcommand: run a bulk action with the admin's kubeconfig and exit
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const bulkUsage = `usage: hobbyfarm-vm-provisioner bulk <release|reprovision-failed|delete> -selector <labels> [-dry-run]
+
+  release              release every VM of the matching requests and TrainingVMs
+  reprovision-failed   send failed requests back through allocation
+  delete               tear down and delete matching requests, TrainingVMs and cloud instances
+
+example: hobbyfarm-vm-provisioner bulk release -selector event-id=kubecon-2025 -dry-run`
+
+func runBulkCommand(args []string) int {
+    if len(args) == 0 {
+        fmt.Fprintln(os.Stderr, bulkUsage)
+        return 2
+    }
+
+    flags := flag.NewFlagSet("bulk", flag.ContinueOnError)
+    flags.Usage = func() { fmt.Fprintln(os.Stderr, bulkUsage) }
+    selector := flags.String("selector", "", "label selector, e.g. event-id=kubecon-2025")
+    dryRun := flags.Bool("dry-run", false, "list matching resources without changing them")
+    if err := flags.Parse(args[1:]); err != nil {
+        return 2
+    }
+
+    result, err := internal.RunBulkAction(internal.InitKubeClient(), args[0], *selector, *dryRun)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+        return 1
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(result)
+    if len(result.Errors) > 0 {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,74 @@
+// cmd/components.go - Run the webhook or an Ansible worker alone, for a split deployment
+package main
+
+import (
+    "context"
+    "log"
+    "os"
+    "os/signal"
+    "syscall"
+
+    "hobbyfarm-vm-provisioner/internal"
+
+    "k8s.io/client-go/dynamic"
+)
+
+// Run COMPONENT=webhook or COMPONENT=worker until SIGTERM; returns the exit code.
+// The controller component goes through main like a full provisioner, its
+// stages narrowed to everything but provisioning.
+func runComponent(client dynamic.Interface, component string) int {
+    ctx, cancel := context.WithCancel(context.Background())
+    defer cancel()
+
+    // Scenarios and Courses for the webhook's defaults and the workers' playbooks
+    internal.StartHobbyFarmCache(ctx, client)
+    internal.StartAPIAvailabilityChecks(ctx, client)
+
+    webhookPort := internal.Setting("WEBHOOK_PORT")
+    if webhookPort == "" {
+        webhookPort = "8443"
+    }
+    // The webhook component is its server; a worker serves /health and /metrics on it when enabled
+    serve := component == internal.ComponentWebhook || internal.Setting("ENABLE_WEBHOOK") == "true"
+
+    if component == internal.ComponentWorker {
+        if err := internal.StartEventBus(ctx); err != nil {
+            log.Printf("❌ %v", err)
+            return 1
+        }
+        go internal.RunShardMembership(ctx, client)
+
+        worker := internal.NewKratixController(client)
+        worker.RegisterStageHandlers()
+        go runControllerWithRetry(ctx, "Provisioning Worker", worker.WatchProvisioningWork)
+    }
+
+    sigChan := make(chan os.Signal, 1)
+    signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
+
+    serverErr := make(chan error, 1)
+    if serve {
+        log.Printf("🌐 Starting %s component, serving on port %s...", component, webhookPort)
+        go func() { serverErr <- startWebhookServer(ctx, client, webhookPort) }()
+    } else {
+        log.Printf("🛠️ Starting %s component...", component)
+    }
+
+    select {
+    case err := <-serverErr:
+        if err != nil {
+            log.Printf("❌ Webhook server error: %v", err)
+            return 1
+        }
+        return 0
+    case <-sigChan:
+    }
+    log.Printf("🛑 Shutdown signal received, stopping %s component...", component)
+    cancel()
+    // Let the webhook answer in-flight admission reviews before exiting
+    if serve {
+        <-serverErr
+    }
+    log.Printf("✅ %s component stopped gracefully", component)
+    return 0
+}
@@ -0,0 +1,20 @@
+// cmd/config_schema.go - "config-schema" subcommand: print the JSON Schema of the --config file and exit
+package main
+
+import (
+    "encoding/json"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+// For editors and CI, e.g.
+// hobbyfarm-vm-provisioner config-schema > provisioner-config.schema.json
+func runConfigSchemaCommand() int {
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    if err := encoder.Encode(internal.ConfigFileSchema()); err != nil {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,64 @@
+// cmd/dead_letter.go - "dead-letter" subcommand: list the requests out of retries, or re-drive them once the cause is fixed
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const deadLetterUsage = `usage: hobbyfarm-vm-provisioner dead-letter [list]
+       hobbyfarm-vm-provisioner dead-letter redrive <request>
+       hobbyfarm-vm-provisioner dead-letter redrive -reason <failureReason>
+
+Failed requests are retried FAILED_REQUEST_RETRIES times with backoff; then,
+or at once for CloudQuota, TenantRefused and AdoptionRefused, they are
+labeled provisioning.hobbyfarm.io/dead-letter=true and left alone. redrive
+sends them back to allocation with a fresh retry budget. Delete them with
+bulk delete -selector provisioning.hobbyfarm.io/dead-letter=true.
+
+example: hobbyfarm-vm-provisioner dead-letter redrive -reason CloudQuota`
+
+func runDeadLetterCommand(args []string) int {
+    action := "list"
+    if len(args) > 0 {
+        action, args = args[0], args[1:]
+    }
+
+    var result interface{}
+    var err error
+    switch action {
+    case "list":
+        result, err = internal.ListDeadLetters(internal.InitKubeClient())
+    case "redrive":
+        flags := flag.NewFlagSet("dead-letter redrive", flag.ContinueOnError)
+        flags.Usage = func() { fmt.Fprintln(os.Stderr, deadLetterUsage) }
+        reason := flags.String("reason", "", "re-drive every dead-lettered request that failed for this reason")
+        if err := flags.Parse(args); err != nil {
+            return 2
+        }
+        if flags.NArg() > 1 || (flags.NArg() == 1) == (*reason != "") {
+            fmt.Fprintln(os.Stderr, deadLetterUsage)
+            return 2
+        }
+        result, err = internal.RedriveDeadLetters(internal.InitKubeClient(), flags.Arg(0), *reason)
+    default:
+        fmt.Fprintln(os.Stderr, deadLetterUsage)
+        return 2
+    }
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+        return 1
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(result)
+    if redrive, ok := result.(*internal.DeadLetterRedrive); ok && len(redrive.Errors) > 0 {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,47 @@
+// cmd/detect.go - "detect-packages" subcommand: simulate package detection over the catalog and exit
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const detectUsage = `usage: hobbyfarm-vm-provisioner detect-packages [-format matrix|json]
+
+Runs package detection over every Course and Scenario in the cluster without
+provisioning anything. Scenarios falling through to the default packages are
+marked with "!", courses none of whose scenarios matched a rule are listed last.
+
+example: hobbyfarm-vm-provisioner detect-packages -format json`
+
+func runDetectCommand(args []string) int {
+    flags := flag.NewFlagSet("detect-packages", flag.ContinueOnError)
+    flags.Usage = func() { fmt.Fprintln(os.Stderr, detectUsage) }
+    format := flags.String("format", "matrix", "matrix for a table, json for the full report")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+    if *format != "matrix" && *format != "json" {
+        fmt.Fprintln(os.Stderr, detectUsage)
+        return 2
+    }
+
+    report, err := internal.RunPackageDetectionSimulation(internal.InitKubeClient())
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+        return 1
+    }
+
+    if *format == "json" {
+        encoder := json.NewEncoder(os.Stdout)
+        encoder.SetIndent("", "  ")
+        encoder.Encode(report)
+    } else {
+        fmt.Print(report.Matrix())
+    }
+    return 0
+}
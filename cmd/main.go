@@ -2,6 +2,7 @@
 package main
 
 import (
+    "flag"
     "log"
     "os"
     "time"
@@ -12,46 +13,116 @@ import (
     "hobbyfarm-vm-provisioner/internal"
     
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
     "k8s.io/client-go/dynamic"
 )
 
 func main() {
+    // The configuration file applies to the controllers and the subcommands alike
+    configPath := flag.String("config", "", "YAML configuration file; environment variables override its settings")
+    flag.Parse()
+    if *configPath != "" {
+        if err := internal.LoadConfigFile(*configPath); err != nil {
+            log.Fatalf("❌ %v", err)
+        }
+        log.Printf("📄 Configuration loaded from %s", *configPath)
+    }
+
+    // Admin subcommands run once and exit instead of starting the controllers
+    if args := flag.Args(); len(args) > 0 {
+        switch args[0] {
+        case "bulk":
+            os.Exit(runBulkCommand(args[1:]))
+        case "release":
+            os.Exit(runReleaseCommand(args[1:]))
+        case "dead-letter":
+            os.Exit(runDeadLetterCommand(args[1:]))
+        case "validate":
+            os.Exit(runValidateCommand())
+        case "rbac":
+            os.Exit(runRBACCommand(args[1:]))
+        case "migrate":
+            os.Exit(runMigrateCommand(args[1:]))
+        case "detect-packages":
+            os.Exit(runDetectCommand(args[1:]))
+        case "config-schema":
+            os.Exit(runConfigSchemaCommand())
+        }
+    }
+
     log.Println("🎓 Starting HobbyFarm Hybrid VM Provisioner with Kratix Integration v3.0...")
+    log.Printf("🏷️ Build and configuration: %s", internal.VersionSummary())
     
     // Initialize Kubernetes client
     client := internal.InitKubeClient()
+
+    // Find configuration problems now rather than mid-class
+    report := internal.ValidateConfig(client)
+    log.Printf("🔎 Configuration check:\n%s", report)
+    if report.Fatal() {
+        log.Fatalf("❌ Invalid configuration, fix the problems above or set CONFIG_VALIDATION=warn")
+    }
+    
+    // The webhook and the Ansible workers can run as Deployments of their own
+    if component := internal.Component(); component == internal.ComponentWebhook || component == internal.ComponentWorker {
+        os.Exit(runComponent(client, component))
+    }
+    
+    // On a rebuilt cluster, bring back requests and allocations before any
+    // controller looks at the pools; a retry resumes an interrupted import
+    if err := internal.RestoreStateOnBootstrap(client); err != nil {
+        log.Fatalf("❌ State import failed: %v", err)
+    }
     
     // Create controllers
     hobbyFarmController := internal.NewHobbyFarmController(client)
     kratixController := internal.NewKratixController(client)
     hobbyFarmKratixIntegration := internal.NewHobbyFarmKratixIntegration(client)
+    deprovisionController := internal.NewDeprovisionController(client)
     
     // Setup graceful shutdown
     ctx, cancel := context.WithCancel(context.Background())
     defer cancel()
     
+    // Stages run on events between the loops' passes when EVENT_BUS is set;
+    // each mode registers the stages of the controllers it starts
+    if err := internal.StartEventBus(ctx); err != nil {
+        log.Fatalf("❌ %v", err)
+    }
+    
+    // Scenarios and Courses read by the webhook and every controller, from one watch
+    internal.StartHobbyFarmCache(ctx, client)
+    
+    // Subsystems whose CRDs are missing idle until they are installed
+    internal.StartAPIAvailabilityChecks(ctx, client)
+    
+    // With SHARD_COUNT > 1, claim the shard of sessions this replica reconciles
+    go internal.RunShardMembership(ctx, client)
+    
     // Handle shutdown signals
     sigChan := make(chan os.Signal, 1)
     signal.Notify(sigChan, syscall.SIGINT, syscall.SIGTERM)
     
     // Start webhook server if enabled
-    webhookPort := os.Getenv("WEBHOOK_PORT")
+    webhookPort := internal.Setting("WEBHOOK_PORT")
     if webhookPort == "" {
         webhookPort = "8443"
     }
     
-    if os.Getenv("ENABLE_WEBHOOK") == "true" {
+    webhookDone := make(chan struct{})
+    if internal.Setting("ENABLE_WEBHOOK") == "true" {
         log.Println("🌐 Starting webhook server...")
         go func() {
-            if err := startWebhookServer(client, webhookPort); err != nil {
+            defer close(webhookDone)
+            if err := startWebhookServer(ctx, client, webhookPort); err != nil {
                 log.Printf("❌ Webhook server error: %v", err)
             }
         }()
+    } else {
+        close(webhookDone)
     }
     
     // Determine integration mode
-    integrationMode := os.Getenv("INTEGRATION_MODE")
+    integrationMode := internal.Setting("INTEGRATION_MODE")
     if integrationMode == "" {
         integrationMode = "hybrid" // Default: both HobbyFarm and Kratix
     }
@@ -62,7 +133,7 @@ func main() {
     switch integrationMode {
     case "hobbyfarm-only":
         log.Println("🎓 Starting HobbyFarm-only mode...")
-        startHobbyFarmOnlyMode(ctx, hobbyFarmController)
+        startHobbyFarmOnlyMode(ctx, client, hobbyFarmController)
         
     case "kratix-only":
         log.Println("🎯 Starting Kratix-only mode...")
@@ -70,12 +141,44 @@ func main() {
         
     case "hybrid":
         log.Println("🔗 Starting Hybrid mode (HobbyFarm + Kratix)...")
-        startHybridMode(ctx, hobbyFarmController, kratixController, hobbyFarmKratixIntegration)
+        startHybridMode(ctx, client, hobbyFarmController, kratixController, hobbyFarmKratixIntegration)
         
     default:
         log.Fatalf("❌ Unknown integration mode: %s", integrationMode)
     }
     
+    // Deprovision VMs of finished or deleted HobbyFarm Sessions
+    if integrationMode != "kratix-only" {
+        go func() {
+            runControllerWithRetry(ctx, "Session Deprovision Controller", func() {
+                deprovisionController.WatchSessionsForDeprovisioning()
+            })
+        }()
+    }
+    
+    // Trace claims redirected by the webhook to their VMs and bind them once ready
+    if internal.Setting("ENABLE_WEBHOOK") == "true" {
+        claimBindingController := internal.NewClaimBindingController(client)
+        go func() {
+            runControllerWithRetry(ctx, "VirtualMachineClaim Binding Controller", func() {
+                claimBindingController.WatchRedirectedClaims()
+            })
+        }()
+    }
+    
+    // Import the EC2 keypair from the managed SSH key before cloud VMs are created
+    if internal.Setting("ENABLE_EC2_FALLBACK") != "false" {
+        go func() {
+            if err := internal.EnsureCloudKeyPair(client); err != nil {
+                log.Printf("❌ EC2 keypair setup failed, cloud VMs may be unreachable: %v", err)
+                return
+            }
+            if err := internal.ValidateCloudKeyPair(client, 5*time.Minute); err != nil {
+                log.Printf("❌ EC2 keypair validation failed: %v", err)
+            }
+        }()
+    }
+    
     // Start common services
     startCommonServices(ctx, client)
     
@@ -89,13 +192,16 @@ func main() {
     // Cancel context to stop all goroutines
     cancel()
     
+    // Let the webhook answer in-flight admission reviews before exiting
+    <-webhookDone
+    
     // Give goroutines time to cleanup
     time.Sleep(2 * time.Second)
     log.Println("✅ HobbyFarm Provisioner stopped gracefully")
 }
 
 // HobbyFarm-only mode
-func startHobbyFarmOnlyMode(ctx context.Context, hobbyFarmController *internal.HobbyFarmController) {
+func startHobbyFarmOnlyMode(ctx context.Context, client dynamic.Interface, hobbyFarmController *internal.HobbyFarmController) {
     // Original HobbyFarm Session Controller
     go func() {
         log.Println("🎯 Starting HobbyFarm Session Controller...")
@@ -108,7 +214,6 @@ func startHobbyFarmOnlyMode(ctx context.Context, hobbyFarmController *internal.H
     go func() {
         log.Println("🔄 Starting HobbyFarm VM allocator...")
         runControllerWithRetry(ctx, "HobbyFarm VM Allocator", func() {
-            client := internal.InitKubeClient()
             enhancedAllocator := internal.NewEnhancedVMAllocator(client)
             ticker := time.NewTicker(10 * time.Second)
             defer ticker.Stop()
@@ -119,6 +224,7 @@ func startHobbyFarmOnlyMode(ctx context.Context, hobbyFarmController *internal.H
                     return
                 case <-ticker.C:
                     enhancedAllocator.AllocateTrainingVMs()
+                    internal.Heartbeat(client, internal.HeartbeatVMAllocator)
                 }
             }
         })
@@ -128,6 +234,7 @@ func startHobbyFarmOnlyMode(ctx context.Context, hobbyFarmController *internal.H
 // Kratix-only mode
 func startKratixOnlyMode(ctx context.Context, kratixController *internal.KratixController) {
     // Kratix Promise VM Provisioning Controller
+    kratixController.RegisterStageHandlers()
     go func() {
         log.Println("🎯 Starting Kratix Promise Controller...")
         runControllerWithRetry(ctx, "Kratix Promise Controller", func() {
@@ -137,9 +244,9 @@ func startKratixOnlyMode(ctx context.Context, kratixController *internal.KratixC
 }
 
 // Hybrid mode (both HobbyFarm and Kratix)
-func startHybridMode(ctx context.Context, hobbyFarmController *internal.HobbyFarmController, kratixController *internal.KratixController, integration *internal.HobbyFarmKratixIntegration) {
+func startHybridMode(ctx context.Context, client dynamic.Interface, hobbyFarmController *internal.HobbyFarmController, kratixController *internal.KratixController, integration *internal.HobbyFarmKratixIntegration) {
     // Option 1: HobbyFarm creates TrainingVMs (Original behavior)
-    if os.Getenv("HOBBYFARM_DIRECT_MODE") == "true" {
+    if internal.Setting("HOBBYFARM_DIRECT_MODE") == "true" {
         log.Println("🎓 Hybrid Mode: HobbyFarm Direct (Sessions → TrainingVMs)")
         go func() {
             runControllerWithRetry(ctx, "HobbyFarm Session Controller", func() {
@@ -149,7 +256,6 @@ func startHybridMode(ctx context.Context, hobbyFarmController *internal.HobbyFar
         
         go func() {
             runControllerWithRetry(ctx, "HobbyFarm VM Allocator", func() {
-                client := internal.InitKubeClient()
                 enhancedAllocator := internal.NewEnhancedVMAllocator(client)
                 ticker := time.NewTicker(10 * time.Second)
                 defer ticker.Stop()
@@ -160,6 +266,7 @@ func startHybridMode(ctx context.Context, hobbyFarmController *internal.HobbyFar
                         return
                     case <-ticker.C:
                         enhancedAllocator.AllocateTrainingVMs()
+                        internal.Heartbeat(client, internal.HeartbeatVMAllocator)
                     }
                 }
             })
@@ -168,6 +275,9 @@ func startHybridMode(ctx context.Context, hobbyFarmController *internal.HobbyFar
         // Option 2: HobbyFarm → Kratix → VMs (New Promise-based behavior)
         log.Println("🔗 Hybrid Mode: HobbyFarm → Kratix Promises (Sessions → VMProvisioningRequests)")
         
+        kratixController.RegisterStageHandlers()
+        integration.RegisterStageHandlers()
+        
         // HobbyFarm → Kratix Integration
         go func() {
             runControllerWithRetry(ctx, "HobbyFarm → Kratix Integration", func() {
@@ -197,13 +307,53 @@ func startCommonServices(ctx context.Context, client dynamic.Interface) {
             case <-ctx.Done():
                 return
             case <-ticker.C:
+                // Cleanup looks at the whole cluster, one shard runs it
+                if !internal.RunsClusterWideWork() {
+                    continue
+                }
                 log.Println("🧹 Running periodic cleanup...")
                 cleanupOrphanedResources(client)
                 internal.CleanupFailedEC2Instances(client)
+                internal.ReapIdleCloudInstances(client)
+                internal.ScaleDownOffHours(client)
+                internal.CleanupExpiredArtifacts()
+                internal.Heartbeat(client, internal.HeartbeatCleanup)
             }
         }
     }()
     
+    // Keep approved discovered hosts in the static pools, propose new ones
+    poolDiscovery := internal.NewPoolDiscovery(client)
+    go func() {
+        runControllerWithRetry(ctx, "Static Pool Discovery", func() {
+            poolDiscovery.WatchPoolCandidates()
+        })
+    }()
+    
+    // One CourseProvisioningStatus per class for instructors
+    courseStatus := internal.NewCourseStatusController(client)
+    go func() {
+        runControllerWithRetry(ctx, "Course Status Aggregation", func() {
+            courseStatus.WatchCourseStatuses()
+        })
+    }()
+    
+    // Follow the static pools from NetBox when an instance is configured
+    if internal.Setting("NETBOX_URL") != "" {
+        netboxSync := internal.NewNetBoxSync(client)
+        go func() {
+            runControllerWithRetry(ctx, "NetBox Inventory Sync", func() {
+                netboxSync.WatchNetBoxInventory()
+            })
+        }()
+    }
+    
+    // Static VMs removed from the pool config while in use drain until released
+    go internal.RunStaticPoolDrains(ctx, client)
+    
+    // Snapshot provisioner state to S3/MinIO for rebuilding a lost cluster
+    go internal.RunStateSnapshots(ctx, client)
+    
     // Health monitoring
     go func() {
         log.Println("💓 Starting health monitoring...")
@@ -213,13 +363,12 @@ func startCommonServices(ctx context.Context, client dynamic.Interface) {
     // Resource discovery
     go func() {
         log.Println("🔍 Starting resource discovery...")
-        runResourceDiscovery(ctx, client)
+        internal.RunResourceDiscovery(ctx, client)
     }()
 }
 
-func startWebhookServer(client dynamic.Interface, port string) error {
-    internal.StartWebhookServer(client, port)
-    return nil
+func startWebhookServer(ctx context.Context, client dynamic.Interface, port string) error {
+    return internal.RunWebhookServer(ctx, client, port)
 }
 
 func runControllerWithRetry(ctx context.Context, name string, controllerFunc func()) {
@@ -237,6 +386,7 @@ func runControllerWithRetry(ctx context.Context, name string, controllerFunc fun
                     if r := recover(); r != nil {
                         retryCount++
                         log.Printf("❌ %s crashed (attempt %d/%d): %v", name, retryCount, maxRetries, r)
+                        internal.RecordLastError(name, "crashed (attempt %d/%d): %v", retryCount, maxRetries, r)
                         
                         if retryCount >= maxRetries {
                             log.Printf("💀 %s exceeded max retries, stopping", name)
@@ -344,7 +494,7 @@ func cleanupOrphanedVMProvisioningRequests(client dynamic.Interface) {
         
         // Only process requests created from HobbyFarm integration
         if labels != nil && labels["source"] == "hobbyfarm-integration" {
-            sessionName := labels["hobbyfarm.io/session"]
+            sessionName := internal.GetHobbyFarmSessionFromRequest(&req)
             if sessionName != "" && !activeSessions[sessionName] {
                 // Check age before cleanup
                 creationTime := req.GetCreationTimestamp()
@@ -376,185 +526,11 @@ func runHealthMonitoring(ctx context.Context, client dynamic.Interface) {
         case <-ctx.Done():
             return
         case <-ticker.C:
-            performHealthCheck(client)
+            internal.PerformHealthCheck(client)
         }
     }
 }
 
-func performHealthCheck(client dynamic.Interface) {
-    // Check static VM pool health
-    staticVMsUp := 0
-    staticVMsTotal := len(internal.GetVMPool())
-    
-    for _, vmIP := range internal.GetVMPool() {
-        if internal.IsVMReachable(vmIP) {
-            staticVMsUp++
-        }
-    }
-    
-    // Check TrainingVMs
-    trainingVMs, err := client.Resource(internal.GetTrainingVMGVR()).Namespace("default").List(context.TODO(), metav1.ListOptions{})
-    if err != nil {
-        log.Printf("⚠️ Health check failed to list TrainingVMs: %v", err)
-        return
-    }
-    
-    trainingVMStats := map[string]int{
-        "pending":      0,
-        "allocated":    0,
-        "provisioned":  0,
-        "failed":       0,
-    }
-    
-    for _, tvm := range trainingVMs.Items {
-        state, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
-        provisioned, _, _ := unstructured.NestedBool(tvm.Object, "status", "provisioned")
-        
-        if state == "allocated" && provisioned {
-            trainingVMStats["provisioned"]++
-        } else if state == "allocated" {
-            trainingVMStats["allocated"]++
-        } else if state == "failed" {
-            trainingVMStats["failed"]++
-        } else {
-            trainingVMStats["pending"]++
-        }
-    }
-    
-    // Check VMProvisioningRequests (Kratix)
-    vmProvisioningRequestGVR := internal.GetVMProvisioningRequestGVR()
-    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
-    if err != nil {
-        log.Printf("⚠️ Health check failed to list VMProvisioningRequests: %v", err)
-        return
-    }
-    
-    kratixStats := map[string]int{
-        "pending":      0,
-        "allocated":    0,
-        "provisioning": 0,
-        "ready":        0,
-        "failed":       0,
-    }
-    
-    for _, req := range requests.Items {
-        state, _, _ := unstructured.NestedString(req.Object, "status", "state")
-        if state == "" {
-            state = "pending"
-        }
-        kratixStats[state]++
-    }
-    
-    // Log health summary periodically (every 5th check)
-    if time.Now().Minute()%5 == 0 {
-        log.Printf("💓 Health Summary:")
-        log.Printf("   📊 Static VMs: %d/%d up", staticVMsUp, staticVMsTotal)
-        log.Printf("   📊 TrainingVMs: pending=%d, allocated=%d, provisioned=%d, failed=%d", 
-            trainingVMStats["pending"], trainingVMStats["allocated"], trainingVMStats["provisioned"], trainingVMStats["failed"])
-        log.Printf("   📊 Kratix Requests: pending=%d, allocated=%d, provisioning=%d, ready=%d, failed=%d", 
-            kratixStats["pending"], kratixStats["allocated"], kratixStats["provisioning"], kratixStats["ready"], kratixStats["failed"])
-    }
-}
-
-func runResourceDiscovery(ctx context.Context, client dynamic.Interface) {
-    ticker := time.NewTicker(30 * time.Second)
-    defer ticker.Stop()
-    
-    lastSessionCount := 0
-    lastVMCount := 0
-    lastKratixCount := 0
-    
-    for {
-        select {
-        case <-ctx.Done():
-            return
-        case <-ticker.C:
-            // Discover Sessions
-            sessionCount := discoverSessions(client)
-            if sessionCount != lastSessionCount {
-                log.Printf("🔍 Session count changed: %d -> %d", lastSessionCount, sessionCount)
-                lastSessionCount = sessionCount
-            }
-            
-            // Discover VirtualMachines
-            vmCount := discoverVirtualMachines(client)
-            if vmCount != lastVMCount {
-                log.Printf("🔍 VirtualMachine count changed: %d -> %d", lastVMCount, vmCount)
-                lastVMCount = vmCount
-            }
-            
-            // Discover VMProvisioningRequests
-            kratixCount := discoverVMProvisioningRequests(client)
-            if kratixCount != lastKratixCount {
-                log.Printf("🔍 VMProvisioningRequest count changed: %d -> %d", lastKratixCount, kratixCount)
-                lastKratixCount = kratixCount
-            }
-        }
-    }
-}
-
-func discoverSessions(client dynamic.Interface) int {
-    sessions, err := client.Resource(internal.GetSessionGVR()).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
-    if err != nil {
-        return 0
-    }
-    
-    if len(sessions.Items) > 0 {
-        log.Printf("🔍 Found %d Sessions in hobbyfarm-system", len(sessions.Items))
-        for _, session := range sessions.Items {
-            user, _, _ := unstructured.NestedString(session.Object, "spec", "user")
-            scenario, _, _ := unstructured.NestedString(session.Object, "spec", "scenario")
-            log.Printf("  📋 Session: %s, User: %s, Scenario: %s", session.GetName(), user, scenario)
-        }
-    }
-    
-    return len(sessions.Items)
-}
-
-func discoverVirtualMachines(client dynamic.Interface) int {
-    virtualMachineGVR := internal.GetVirtualMachineGVR()
-    
-    vms, err := client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
-    if err != nil {
-        return 0
-    }
-    
-    if len(vms.Items) > 0 {
-        log.Printf("🔍 Found %d VirtualMachines in hobbyfarm-system", len(vms.Items))
-        for _, vm := range vms.Items {
-            user, _, _ := unstructured.NestedString(vm.Object, "spec", "user")
-            status, _, _ := unstructured.NestedString(vm.Object, "status", "status")
-            publicIP, _, _ := unstructured.NestedString(vm.Object, "status", "public_ip")
-            log.Printf("  📋 VirtualMachine: %s, User: %s, Status: %s, IP: %s", vm.GetName(), user, status, publicIP)
-        }
-    }
-    
-    return len(vms.Items)
-}
-
-func discoverVMProvisioningRequests(client dynamic.Interface) int {
-    vmProvisioningRequestGVR := internal.GetVMProvisioningRequestGVR()
-    
-    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
-    if err != nil {
-        return 0
-    }
-    
-    if len(requests.Items) > 0 {
-        log.Printf("🔍 Found %d VMProvisioningRequests", len(requests.Items))
-        for _, req := range requests.Items {
-            user, _, _ := unstructured.NestedString(req.Object, "spec", "user")
-            session, _, _ := unstructured.NestedString(req.Object, "spec", "session")
-            state, _, _ := unstructured.NestedString(req.Object, "status", "state")
-            vmIP, _, _ := unstructured.NestedString(req.Object, "status", "vmIP")
-            log.Printf("  📋 VMProvisioningRequest: %s, User: %s, Session: %s, State: %s, IP: %s", 
-                req.GetName(), user, session, state, vmIP)
-        }
-    }
-    
-    return len(requests.Items)
-}
-
 func logStartupSummary(integrationMode, webhookPort string) {
     log.Println("🎉 =============================================")
     log.Println("🎉 HobbyFarm Hybrid Provisioner with Kratix")
@@ -567,18 +543,21 @@ func logStartupSummary(integrationMode, webhookPort string) {
     case "kratix-only":
         log.Println("🎯 Kratix VMProvisioningRequest → VM Allocation")
     case "hybrid":
-        if os.Getenv("HOBBYFARM_DIRECT_MODE") == "true" {
+        if internal.Setting("HOBBYFARM_DIRECT_MODE") == "true" {
             log.Println("🔗 HobbyFarm Session → TrainingVM → Allocation")
         } else {
             log.Println("🔗 HobbyFarm Session → Kratix VMProvisioningRequest → VM")
         }
     }
     
+    if integrationMode != "kratix-only" {
+        log.Println("🧹 Session deprovisioning on finish/delete")
+    }
     log.Println("🧹 Orphaned resource cleanup")
     log.Println("💓 Health monitoring")
     log.Println("🔍 Resource discovery")
     
-    if os.Getenv("ENABLE_WEBHOOK") == "true" {
+    if internal.Setting("ENABLE_WEBHOOK") == "true" {
         log.Printf("🌐 Webhook server: Port %s", webhookPort)
     }
     
@@ -0,0 +1,42 @@
+// cmd/migrate.go - "migrate" subcommand: move in-flight TrainingVMs to the Kratix flow and exit
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const migrateUsage = `usage: hobbyfarm-vm-provisioner migrate [-dry-run]
+
+Turns the TrainingVM of every running session into a VMProvisioningRequest that
+keeps its VM, so HOBBYFARM_DIRECT_MODE can be switched off mid-event. Sessions
+already finished are left to deprovisioning. Safe to run again after a failure.
+
+example: hobbyfarm-vm-provisioner migrate -dry-run`
+
+func runMigrateCommand(args []string) int {
+    flags := flag.NewFlagSet("migrate", flag.ContinueOnError)
+    flags.Usage = func() { fmt.Fprintln(os.Stderr, migrateUsage) }
+    dryRun := flags.Bool("dry-run", false, "list the TrainingVMs that would be migrated without changing them")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+
+    result, err := internal.RunTrainingVMMigration(internal.InitKubeClient(), *dryRun)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+        return 1
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(result)
+    if len(result.Errors) > 0 {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,31 @@
+// cmd/rbac.go - "rbac" subcommand: print the minimal Roles and ClusterRole for the enabled features
+package main
+
+import (
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const rbacUsage = `usage: hobbyfarm-vm-provisioner rbac [-service-account <name>] [-namespace <namespace>]
+
+Prints Role, ClusterRole and binding manifests covering the features enabled by the
+current environment (INTEGRATION_MODE, ENABLE_EC2_FALLBACK, ENABLE_WEBHOOK, VM_DNS_DOMAIN).
+Run it with the deployment's environment to get the permissions that deployment needs.
+
+example: ENABLE_WEBHOOK=true hobbyfarm-vm-provisioner rbac | kubectl apply -f -`
+
+func runRBACCommand(args []string) int {
+    flags := flag.NewFlagSet("rbac", flag.ContinueOnError)
+    flags.Usage = func() { fmt.Fprintln(os.Stderr, rbacUsage) }
+    serviceAccount := flags.String("service-account", "hobbyfarm-provisioner", "ServiceAccount the provisioner runs as")
+    namespace := flags.String("namespace", "default", "namespace of the ServiceAccount")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+
+    fmt.Print(internal.GenerateRBACManifests(*serviceAccount, *namespace))
+    return 0
+}
@@ -0,0 +1,43 @@
+// cmd/release.go - "release" subcommand: give back the VM of one request with the admin's kubeconfig and exit
+package main
+
+import (
+    "encoding/json"
+    "flag"
+    "fmt"
+    "os"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+const releaseUsage = `usage: hobbyfarm-vm-provisioner release [-note <why>] <request>
+
+Cleans up and gives back the VM of one VMProvisioningRequest, whatever its
+session's state. The request is kept as released, with releasedAt, for
+RELEASED_RETENTION_DAYS.
+
+example: hobbyfarm-vm-provisioner release -note "learner reported a broken disk" alice-k8s`
+
+func runReleaseCommand(args []string) int {
+    flags := flag.NewFlagSet("release", flag.ContinueOnError)
+    flags.Usage = func() { fmt.Fprintln(os.Stderr, releaseUsage) }
+    note := flags.String("note", "", "why the VM is released, recorded in the request's Event")
+    if err := flags.Parse(args); err != nil {
+        return 2
+    }
+    if flags.NArg() != 1 {
+        fmt.Fprintln(os.Stderr, releaseUsage)
+        return 2
+    }
+
+    releasedIP, err := internal.ReleaseRequest(internal.InitKubeClient(), flags.Arg(0), *note)
+    if err != nil {
+        fmt.Fprintf(os.Stderr, "❌ %v\n", err)
+        return 1
+    }
+
+    encoder := json.NewEncoder(os.Stdout)
+    encoder.SetIndent("", "  ")
+    encoder.Encode(map[string]string{"request": flags.Arg(0), "releasedIP": releasedIP, "state": "released"})
+    return 0
+}
@@ -0,0 +1,18 @@
+// cmd/validate.go - "validate" subcommand: run the startup configuration checks and exit
+package main
+
+import (
+    "fmt"
+
+    "hobbyfarm-vm-provisioner/internal"
+)
+
+// Exit status 1 when any check fails, whatever CONFIG_VALIDATION says
+func runValidateCommand() int {
+    report := internal.ValidateConfig(internal.InitKubeClient())
+    fmt.Println(report)
+    if report.Failed() {
+        return 1
+    }
+    return 0
+}
@@ -0,0 +1,177 @@
+// internal/admin_auth.go - Admin endpoints on their own listener, each call authenticated by TokenReview and authorized by SubjectAccessReview
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    tokenReviewGVR = schema.GroupVersionResource{
+        Group:    "authentication.k8s.io",
+        Version:  "v1",
+        Resource: "tokenreviews",
+    }
+    subjectAccessReviewGVR = schema.GroupVersionResource{
+        Group:    "authorization.k8s.io",
+        Version:  "v1",
+        Resource: "subjectaccessreviews",
+    }
+)
+
+// Port of the admin listener, 0 serves no admin endpoints. It is not behind the
+// webhook Service; admins reach it with kubectl port-forward.
+func getAdminPort() string {
+    if port := Setting("ADMIN_PORT"); port != "" {
+        return port
+    }
+    return "8444"
+}
+
+// Workers serve /health and /metrics only, never admin endpoints
+func adminEnabled() bool {
+    return getAdminPort() != "0" && Component() != ComponentWorker
+}
+
+// Who made an admin call, as the API server knows the caller's token
+type adminCaller struct {
+    Username string
+    UID      string
+    Groups   []string
+    Extra    map[string]interface{}
+}
+
+// Ask the API server who the bearer token belongs to; false when it's not valid
+func authenticateAdmin(client dynamic.Interface, token string) (adminCaller, bool, error) {
+    review := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "authentication.k8s.io/v1",
+            "kind":       "TokenReview",
+            "spec":       map[string]interface{}{"token": token},
+        },
+    }
+    result, err := client.Resource(tokenReviewGVR).Create(context.TODO(), review, metav1.CreateOptions{})
+    if err != nil {
+        return adminCaller{}, false, err
+    }
+    if authenticated, _, _ := unstructured.NestedBool(result.Object, "status", "authenticated"); !authenticated {
+        return adminCaller{}, false, nil
+    }
+    caller := adminCaller{}
+    caller.Username, _, _ = unstructured.NestedString(result.Object, "status", "user", "username")
+    caller.UID, _, _ = unstructured.NestedString(result.Object, "status", "user", "uid")
+    caller.Groups, _, _ = unstructured.NestedStringSlice(result.Object, "status", "user", "groups")
+    caller.Extra, _, _ = unstructured.NestedMap(result.Object, "status", "user", "extra")
+    return caller, true, nil
+}
+
+// Ask the API server whether the caller may use the path with the verb, e.g.
+// "post" on /bulk. Admins are granted it as a nonResourceURL in a ClusterRole.
+func authorizeAdmin(client dynamic.Interface, caller adminCaller, path, verb string) (bool, error) {
+    spec := map[string]interface{}{
+        "user": caller.Username,
+        "uid":  caller.UID,
+        "nonResourceAttributes": map[string]interface{}{
+            "path": path,
+            "verb": verb,
+        },
+    }
+    if len(caller.Groups) > 0 {
+        groups := make([]interface{}, len(caller.Groups))
+        for i, group := range caller.Groups {
+            groups[i] = group
+        }
+        spec["groups"] = groups
+    }
+    if len(caller.Extra) > 0 {
+        spec["extra"] = caller.Extra
+    }
+    review := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "authorization.k8s.io/v1",
+            "kind":       "SubjectAccessReview",
+            "spec":       spec,
+        },
+    }
+    result, err := client.Resource(subjectAccessReviewGVR).Create(context.TODO(), review, metav1.CreateOptions{})
+    if err != nil {
+        return false, err
+    }
+    allowed, _, _ := unstructured.NestedBool(result.Object, "status", "allowed")
+    return allowed, nil
+}
+
+// Answer 401 without a valid bearer token and 403 when RBAC doesn't grant the
+// caller the path with the request's method as verb
+func requireAdmin(client dynamic.Interface, next http.Handler) http.Handler {
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+        if token == "" || token == r.Header.Get("Authorization") {
+            w.Header().Set("WWW-Authenticate", "Bearer")
+            http.Error(w, "bearer token required", http.StatusUnauthorized)
+            return
+        }
+        caller, authenticated, err := authenticateAdmin(client, token)
+        if err != nil {
+            log.Printf("⚠️ Could not review the token of %s %s: %v", r.Method, r.URL.Path, err)
+            http.Error(w, "could not review the token", http.StatusServiceUnavailable)
+            return
+        }
+        if !authenticated {
+            log.Printf("🔒 Refused %s %s from %s: token not valid", r.Method, r.URL.Path, r.RemoteAddr)
+            http.Error(w, "token not valid", http.StatusUnauthorized)
+            return
+        }
+        verb := strings.ToLower(r.Method)
+        allowed, err := authorizeAdmin(client, caller, r.URL.Path, verb)
+        if err != nil {
+            log.Printf("⚠️ Could not authorize %s for %s %s: %v", caller.Username, r.Method, r.URL.Path, err)
+            http.Error(w, "could not authorize the call", http.StatusServiceUnavailable)
+            return
+        }
+        if !allowed {
+            log.Printf("🔒 Refused %s %s: %s may not %s it", r.Method, r.URL.Path, caller.Username, verb)
+            http.Error(w, fmt.Sprintf("%s may not %s %s", caller.Username, verb, r.URL.Path), http.StatusForbidden)
+            return
+        }
+        log.Printf("🛡️ Admin call %s %s by %s", r.Method, r.URL.RequestURI(), caller.Username)
+        next.ServeHTTP(w, r)
+    })
+}
+
+// Endpoints that change pools and requests across sessions, served only on the admin listener
+func (ws *WebhookServer) adminMux() *http.ServeMux {
+    mux := http.NewServeMux()
+    mux.HandleFunc("/bulk", ws.bulkHandler)
+    mux.HandleFunc("/maintenance", ws.maintenanceHandler)
+    mux.HandleFunc("/reallocate", ws.reallocateHandler)
+    mux.HandleFunc("/pool-candidates", ws.poolCandidatesHandler)
+    mux.HandleFunc("/key-rotation", ws.keyRotationHandler)
+    mux.HandleFunc("/release", ws.releaseHandler)
+    mux.HandleFunc("/playbook-canary", ws.playbookCanaryHandler)
+    mux.HandleFunc("/dead-letter", ws.deadLetterHandler)
+    mux.HandleFunc("/events", ws.eventsHandler)
+    return mux
+}
+
+// The admin port is a number other than the webhook's
+func validateAdminListener(check *ConfigCheck) {
+    if !adminEnabled() || Setting("ENABLE_WEBHOOK") != "true" {
+        return
+    }
+    webhookPort := Setting("WEBHOOK_PORT")
+    if webhookPort == "" {
+        webhookPort = "8443"
+    }
+    if getAdminPort() == webhookPort {
+        check.fail("ADMIN_PORT %s is the webhook's port, admin endpoints need their own listener", getAdminPort())
+    }
+}
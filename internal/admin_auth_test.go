@@ -0,0 +1,148 @@
+// internal/admin_auth_test.go - Admin endpoints refused without a reviewed token and RBAC grant, and absent from the webhook listener
+package internal
+
+import (
+    "net/http"
+    "net/http/httptest"
+    "testing"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+// A fake API server knowing one token, of alice, who may post to the paths in granted
+func newAdminClient(granted ...string) (*dynamicfake.FakeDynamicClient, *[]map[string]interface{}) {
+    client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), harnessListKinds())
+    reviews := &[]map[string]interface{}{}
+    client.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        review := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured)
+        token, _, _ := unstructured.NestedString(review.Object, "spec", "token")
+        status := map[string]interface{}{"authenticated": false}
+        if token == "alice-token" {
+            status = map[string]interface{}{
+                "authenticated": true,
+                "user": map[string]interface{}{
+                    "username": "alice",
+                    "groups":   []interface{}{"operators"},
+                },
+            }
+        }
+        review = review.DeepCopy()
+        review.Object["status"] = status
+        return true, review, nil
+    })
+    client.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        review := action.(k8stesting.CreateAction).GetObject().(*unstructured.Unstructured).DeepCopy()
+        spec, _, _ := unstructured.NestedMap(review.Object, "spec")
+        *reviews = append(*reviews, spec)
+        path, _, _ := unstructured.NestedString(spec, "nonResourceAttributes", "path")
+        verb, _, _ := unstructured.NestedString(spec, "nonResourceAttributes", "verb")
+        allowed := false
+        for _, grant := range granted {
+            allowed = allowed || (grant == path && verb == "post")
+        }
+        review.Object["status"] = map[string]interface{}{"allowed": allowed}
+        return true, review, nil
+    })
+    return client, reviews
+}
+
+func adminCall(handler http.Handler, method, path, token string) int {
+    request := httptest.NewRequest(method, path, nil)
+    if token != "" {
+        request.Header.Set("Authorization", "Bearer "+token)
+    }
+    recorder := httptest.NewRecorder()
+    handler.ServeHTTP(recorder, request)
+    return recorder.Code
+}
+
+func TestAdminCallsNeedReviewedTokenAndGrant(t *testing.T) {
+    client, reviews := newAdminClient("/bulk")
+    reached := 0
+    handler := requireAdmin(client, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        reached++
+    }))
+
+    if code := adminCall(handler, http.MethodPost, "/bulk", ""); code != http.StatusUnauthorized {
+        t.Fatalf("no token answered %d, want 401", code)
+    }
+    if code := adminCall(handler, http.MethodPost, "/bulk", "stolen"); code != http.StatusUnauthorized {
+        t.Fatalf("invalid token answered %d, want 401", code)
+    }
+    if code := adminCall(handler, http.MethodGet, "/bulk", "alice-token"); code != http.StatusForbidden {
+        t.Fatalf("verb not granted answered %d, want 403", code)
+    }
+    if reached != 0 {
+        t.Fatalf("handler reached %d times before a granted call", reached)
+    }
+    if code := adminCall(handler, http.MethodPost, "/bulk?action=release", "alice-token"); code != http.StatusOK || reached != 1 {
+        t.Fatalf("granted call answered %d, handler reached %d times", code, reached)
+    }
+
+    last := (*reviews)[len(*reviews)-1]
+    if user, _, _ := unstructured.NestedString(last, "user"); user != "alice" {
+        t.Fatalf("access reviewed for %q, want alice", user)
+    }
+    if groups, _, _ := unstructured.NestedStringSlice(last, "groups"); len(groups) != 1 || groups[0] != "operators" {
+        t.Fatalf("access reviewed with groups %v, want the token's", groups)
+    }
+}
+
+func TestAdminEndpointsOnlyOnAdminListener(t *testing.T) {
+    client, _ := newAdminClient("/bulk")
+    ws := NewWebhookServer(client, "0")
+    if ws.admin == nil {
+        t.Fatal("no admin listener with the default ADMIN_PORT")
+    }
+    for _, path := range []string{"/bulk", "/maintenance", "/reallocate", "/pool-candidates", "/key-rotation", "/release", "/playbook-canary", "/dead-letter", "/events"} {
+        if code := adminCall(ws.server.Handler, http.MethodPost, path, "alice-token"); code != http.StatusNotFound {
+            t.Fatalf("%s on the webhook listener answered %d, want 404", path, code)
+        }
+        if code := adminCall(ws.admin.Handler, http.MethodPost, path, ""); code != http.StatusUnauthorized {
+            t.Fatalf("%s on the admin listener without a token answered %d, want 401", path, code)
+        }
+    }
+
+    t.Setenv("ADMIN_PORT", "0")
+    if ws := NewWebhookServer(client, "0"); ws.admin != nil {
+        t.Fatal("admin listener built with ADMIN_PORT 0")
+    }
+}
+
+func TestDeadLetterRedriveNeedsAdminGrant(t *testing.T) {
+    client, _ := newAdminClient("/bulk")
+    ws := NewWebhookServer(client, "0")
+    if code := adminCall(ws.admin.Handler, http.MethodPost, "/dead-letter?request=req-1", "alice-token"); code != http.StatusForbidden {
+        t.Fatalf("redrive without a grant on /dead-letter answered %d, want 403", code)
+    }
+
+    client, _ = newAdminClient("/dead-letter")
+    ws = NewWebhookServer(client, "0")
+    // Past the grant the handler answers: req-1 is not on the dead-letter list
+    if code := adminCall(ws.admin.Handler, http.MethodPost, "/dead-letter?request=req-1", "alice-token"); code != http.StatusBadRequest {
+        t.Fatalf("granted redrive answered %d, want the handler's 400", code)
+    }
+}
+
+// Whatever is added to the webhook listener, only /mutate and /callback take
+// anything but GET there; state changes belong on the admin listener
+func TestWebhookListenerHandlersOnlyRead(t *testing.T) {
+    client, _ := newAdminClient()
+    ws := NewWebhookServer(client, "0")
+    for path := range ws.webhookRoutes() {
+        if code := adminCall(ws.server.Handler, http.MethodGet, path, ""); code == http.StatusNotFound {
+            t.Fatalf("GET %s on the webhook listener answered %d, the route is not served as listed", path, code)
+        }
+        if path == "/mutate" || path == "/callback" {
+            continue
+        }
+        for _, method := range []string{http.MethodPost, http.MethodPut, http.MethodPatch, http.MethodDelete} {
+            if code := adminCall(ws.server.Handler, method, path, "alice-token"); code != http.StatusMethodNotAllowed {
+                t.Fatalf("%s %s on the webhook listener answered %d, want 405; state changes go on the admin listener", method, path, code)
+            }
+        }
+    }
+}
@@ -0,0 +1,157 @@
+// internal/adopt_vm.go - Requests that provision a machine supplied out of band instead of one from the pools
+package internal
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// status.vmType of a request provisioning the machine named in spec.adoptVM
+const vmTypeAdopted = "adopted"
+
+// The machine a request adopts: an address, or an EC2 instance whose address is looked up
+type adoptedVM struct {
+    IP         string
+    InstanceID string
+    Region     string
+}
+
+// spec.adoptVM of a request, false when it doesn't adopt a machine
+func getRequestAdoptedVM(request *unstructured.Unstructured) (adoptedVM, bool) {
+    ip, _, _ := unstructured.NestedString(request.Object, "spec", "adoptVM", "ip")
+    instanceID, _, _ := unstructured.NestedString(request.Object, "spec", "adoptVM", "instanceId")
+    region, _, _ := unstructured.NestedString(request.Object, "spec", "adoptVM", "region")
+    vm := adoptedVM{IP: strings.TrimSpace(ip), InstanceID: strings.TrimSpace(instanceID), Region: strings.TrimSpace(region)}
+    return vm, vm.IP != "" || vm.InstanceID != ""
+}
+
+// Networks spec.adoptVM may name machines in, e.g. 10.20.0.0/16. Adoption is
+// refused while none are configured, since a request could otherwise point
+// Ansible at any host the provisioner reaches.
+func getAdoptVMCIDRs() []string {
+    return splitEnvList("ADOPT_VM_CIDRS")
+}
+
+// Why the machine at ip may not be adopted by the request, empty when it may.
+// Pooled VMs are allocated, never adopted: adopting one would bypass tenant
+// isolation, maintenance and the pool's own bookkeeping.
+func (kc *KratixController) adoptionRefusal(request *unstructured.Unstructured, ip string) string {
+    address := net.ParseIP(ip)
+    if address == nil {
+        return fmt.Sprintf("%q is not an IP address", ip)
+    }
+    allowed := false
+    for _, cidr := range getAdoptVMCIDRs() {
+        if _, network, err := net.ParseCIDR(cidr); err == nil && network.Contains(address) {
+            allowed = true
+            break
+        }
+    }
+    if !allowed {
+        return fmt.Sprintf("VM %s is outside ADOPT_VM_CIDRS", ip)
+    }
+    if isVMInMaintenance(kc.client, ip) {
+        return fmt.Sprintf("VM %s is in maintenance", ip)
+    }
+    if !staticVMAllowedForTenant(request, ip) {
+        return fmt.Sprintf("VM %s belongs to another tenant's environment", ip)
+    }
+    for _, environment := range loadVMEnvironments() {
+        for _, staticIP := range environment.StaticVMs {
+            if staticIP == ip {
+                return fmt.Sprintf("VM %s is a static VM of environment %s", ip, environment.Name)
+            }
+        }
+    }
+    // Two requests provisioning one machine would overwrite each other's sessions
+    if kc.usedIPs[ip] {
+        return fmt.Sprintf("VM %s is held by another request", ip)
+    }
+    return ""
+}
+
+// Whether the request's VM was adopted rather than allocated. Adopted machines
+// belong to someone else: they are never terminated, drained or returned to a pool.
+func isAdoptedRequest(request *unstructured.Unstructured) bool {
+    vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+    return vmType == vmTypeAdopted
+}
+
+// Public address of an instance, or its private one, through the aws CLI; empty
+// while the instance has none yet. Any instance the credentials can see works,
+// not only those Crossplane manages.
+func (ar *AnsibleRunner) lookupInstanceIP(region, instanceID string) (string, error) {
+    args := []string{"ec2", "describe-instances", "--instance-ids", instanceID, "--output", "text",
+        "--query", "Reservations[0].Instances[0].[PublicIpAddress,PrivateIpAddress]"}
+    if region != "" {
+        args = append(args, "--region", region)
+    }
+    output, err := ar.exec.CombinedOutput(nil, "aws", args...)
+    if err != nil {
+        return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    for _, address := range strings.Fields(string(output)) {
+        if address != "None" {
+            return address, nil
+        }
+    }
+    return "", nil
+}
+
+// Take the machine named in spec.adoptVM instead of allocating one, so the usual
+// SSH detection, provisioning, verification and status reporting run against it
+func (kc *KratixController) adoptVM(requestName string, request *unstructured.Unstructured, vm adoptedVM) {
+    ip := vm.IP
+    if ip == "" {
+        found, err := kc.cloud.LookupInstanceIP(vm.Region, vm.InstanceID)
+        if err != nil {
+            log.Printf("❌ Could not look up instance %s to adopt for %s: %v", vm.InstanceID, requestName, err)
+            kc.failRequest(requestName, "", failureAdoptionRefused, fmt.Sprintf("instance %s not found: %v", vm.InstanceID, err))
+            return
+        }
+        if found == "" {
+            log.Printf("⏳ Instance %s has no address yet, %s stays queued", vm.InstanceID, requestName)
+            return
+        }
+        ip = found
+    }
+    if refusal := kc.adoptionRefusal(request, ip); refusal != "" {
+        log.Printf("🚫 Request %s refused: %s", requestName, refusal)
+        kc.failRequest(requestName, "", failureAdoptionRefused, refusal)
+        return
+    }
+
+    now := time.Now()
+    status := map[string]interface{}{
+        "state":       "allocated",
+        "provisioned": false,
+        "vmIP":        ip,
+        "vmType":      vmTypeAdopted,
+        "allocatedAt": now.Format(time.RFC3339),
+    }
+    for field, value := range firstAllocationStatus(request, now) {
+        status[field] = value
+    }
+    if vm.InstanceID != "" {
+        status["instanceId"] = vm.InstanceID
+        if vm.Region != "" {
+            status["region"] = vm.Region
+            status["consoleURL"] = cloudConsoleURL(vm.Region, vm.InstanceID)
+        }
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("❌ Failed to adopt VM %s for %s: %v", ip, requestName, err)
+        return
+    }
+    kc.usedIPs[ip] = true
+    log.Printf("🤝 Adopted VM %s for request %s", ip, requestName)
+    recordEvent(kc.client, request, eventTypeNormal, "VMAdopted", fmt.Sprintf("Provisioning VM %s supplied with the request", ip))
+    publishStage(stageProvisioning, requestName)
+}
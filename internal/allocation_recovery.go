@@ -0,0 +1,100 @@
+// internal/allocation_recovery.go - Rebuild in-memory allocation state from the cluster on startup
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// Collect every IP held by a VMProvisioningRequest or a TrainingVM, so both
+// flows sharing the static pool see each other's allocations
+func collectAllocatedIPs(client dynamic.Interface) (map[string]bool, error) {
+    return provisioner.AllocatedIPs(context.TODO(), client)
+}
+
+// Find the cloud instance already created for a Kratix request, if any
+func findCloudInstanceForRequest(client dynamic.Interface, requestName string) (*unstructured.Unstructured, error) {
+    instances, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("kratix-request=%s", requestName),
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(instances.Items) == 0 {
+        return nil, nil
+    }
+    return &instances.Items[0], nil
+}
+
+// Rebuild usedIPs and processedRequests from cluster state instead of trusting
+// the empty maps of a freshly started process
+func (kc *KratixController) RecoverAllocations() {
+    log.Println("♻️ Recovering allocations from cluster state...")
+
+    // Starting without the allocations during an outage would hand out held VMs again
+    var usedIPs map[string]bool
+    err := waitForAPIServer("recovering allocations", func() (err error) {
+        usedIPs, err = collectAllocatedIPs(kc.client)
+        return err
+    })
+    if err != nil {
+        log.Printf("⚠️ Allocation recovery failed, starting with empty state: %v", err)
+        return
+    }
+    kc.usedIPs = usedIPs
+
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    // Requests with an instance, from one list rather than one per request
+    withInstance := map[string]bool{}
+    if instances, err := kc.client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "kratix-request",
+    }); err == nil {
+        for _, instance := range instances.Items {
+            withInstance[instance.GetLabels()["kratix-request"]] = true
+        }
+    }
+
+    cloudInstances := 0
+    for _, request := range requests.Items {
+        // Requests with a state were initialized before the restart
+        if state, _, _ := unstructured.NestedString(request.Object, "status", "state"); state != "" {
+            kc.processedRequests.add(request.GetName())
+        }
+        if withInstance[request.GetName()] {
+            cloudInstances++
+        }
+    }
+
+    log.Printf("♻️ Recovered %d allocated IPs, %d initialized requests, %d existing cloud instances",
+        len(kc.usedIPs), kc.processedRequests.len(), cloudInstances)
+}
+
+// Mark Sessions that already have a VMProvisioningRequest as processed
+func (hki *HobbyFarmKratixIntegration) RecoverProcessedSessions() {
+    requests, err := hki.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "source=hobbyfarm-integration",
+    })
+    if err != nil {
+        log.Printf("⚠️ Could not recover processed sessions: %v", err)
+        return
+    }
+
+    for i := range requests.Items {
+        if sessionName := GetHobbyFarmSessionFromRequest(&requests.Items[i]); sessionName != "" {
+            hki.processedSessions.add(sessionTrackingKey(hobbyFarmNamespace(), sessionName))
+        }
+    }
+
+    log.Printf("♻️ Recovered %d processed HobbyFarm sessions", hki.processedSessions.len())
+}
@@ -0,0 +1,110 @@
+// internal/ansible_results.go - Parse Ansible JSON callback output into per-playbook recaps
+package internal
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Per-playbook recap stored in the request status
+type PlaybookRecap struct {
+	Playbook    string   `json:"playbook"`
+	Ok          int      `json:"ok"`
+	Changed     int      `json:"changed"`
+	Failed      int      `json:"failed"`
+	Unreachable int      `json:"unreachable"`
+	Skipped     int      `json:"skipped"`
+	FailedTasks []string `json:"failedTasks,omitempty"`
+}
+
+// Subset of the ansible.posix json stdout callback format we care about
+type ansibleJSONOutput struct {
+	Plays []struct {
+		Tasks []struct {
+			Task struct {
+				Name string `json:"name"`
+			} `json:"task"`
+			Hosts map[string]struct {
+				Failed      bool   `json:"failed"`
+				Unreachable bool   `json:"unreachable"`
+				Msg         string `json:"msg"`
+			} `json:"hosts"`
+		} `json:"tasks"`
+	} `json:"plays"`
+	Stats map[string]struct {
+		Ok          int `json:"ok"`
+		Changed     int `json:"changed"`
+		Failures    int `json:"failures"`
+		Unreachable int `json:"unreachable"`
+		Skipped     int `json:"skipped"`
+	} `json:"stats"`
+}
+
+// Parse JSON callback output. Anything printed before the JSON document
+// (config file notices, warnings) is skipped.
+func parseAnsibleJSONOutput(playbook string, output []byte) (*PlaybookRecap, error) {
+	start := bytes.IndexByte(output, '{')
+	if start < 0 {
+		return nil, fmt.Errorf("no JSON document in ansible output")
+	}
+
+	var parsed ansibleJSONOutput
+	if err := json.NewDecoder(bytes.NewReader(output[start:])).Decode(&parsed); err != nil {
+		return nil, fmt.Errorf("failed to parse ansible JSON output: %v", err)
+	}
+
+	recap := &PlaybookRecap{Playbook: playbook}
+	for _, stats := range parsed.Stats {
+		recap.Ok += stats.Ok
+		recap.Changed += stats.Changed
+		recap.Failed += stats.Failures
+		recap.Unreachable += stats.Unreachable
+		recap.Skipped += stats.Skipped
+	}
+
+	seen := make(map[string]bool)
+	for _, play := range parsed.Plays {
+		for _, task := range play.Tasks {
+			for _, result := range task.Hosts {
+				if (result.Failed || result.Unreachable) && !seen[task.Task.Name] {
+					seen[task.Task.Name] = true
+					recap.FailedTasks = append(recap.FailedTasks, task.Task.Name)
+				}
+			}
+		}
+	}
+
+	return recap, nil
+}
+
+// One-line summary used in logs and error messages
+func (r *PlaybookRecap) String() string {
+	summary := fmt.Sprintf("%s: ok=%d changed=%d failed=%d unreachable=%d skipped=%d",
+		r.Playbook, r.Ok, r.Changed, r.Failed, r.Unreachable, r.Skipped)
+	if len(r.FailedTasks) > 0 {
+		summary += fmt.Sprintf(" (failed tasks: %s)", strings.Join(r.FailedTasks, ", "))
+	}
+	return summary
+}
+
+// Convert recaps to the unstructured form used in status patches
+func playbookRecapsToStatus(recaps []*PlaybookRecap) []interface{} {
+	results := make([]interface{}, 0, len(recaps))
+	for _, recap := range recaps {
+		entry := map[string]interface{}{
+			"playbook":    recap.Playbook,
+			"ok":          recap.Ok,
+			"changed":     recap.Changed,
+			"failed":      recap.Failed,
+			"unreachable": recap.Unreachable,
+			"skipped":     recap.Skipped,
+		}
+		if len(recap.FailedTasks) > 0 {
+			entry["failedTasks"] = append([]string(nil), recap.FailedTasks...)
+		}
+		results = append(results, entry)
+	}
+	return results
+}
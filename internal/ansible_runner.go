@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"log"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"time"
@@ -20,6 +19,9 @@ type AnsibleRunner struct {
 	playbookPath  string
 	sshKeyPath    string
 	client        dynamic.Interface
+	// Where ansible-playbook and other local programs run, and how VMs are reached
+	exec Executor
+	ssh  SSHClient
 }
 
 type ProvisioningConfig struct {
@@ -27,15 +29,48 @@ type ProvisioningConfig struct {
 	Variables    map[string]string
 	Packages     []string
 	Requirements []string
+	// Key of the inventory template ConfigMap to render; empty uses inventory.tmpl
+	InventoryTemplate string
+	// Container image to run ansible-playbook in; empty uses ANSIBLE_EE_IMAGE
+	ExecutionEnvironment string
+	// ansible-core version of the venv runtime's virtualenv; empty uses ANSIBLE_VERSION
+	AnsibleVersion string
+	// Extra EBS disks of a cloud VM, formatted and mounted by data-volumes.yaml
+	DataVolumes []cloudDataVolume
+	// Variables marked secret by the scenario, redacted like *password*/*token* ones
+	SecretVariables []string
+	// secretRef variables resolved from their Secrets, passed to Ansible through the environment
+	ResolvedSecrets map[string]string
+	// OS family, version and architecture detected on the VM
+	Platform vmPlatform
+	// Extracted playbook bundle of the request's environment; empty uses the image's playbooks
+	PlaybookDir    string
+	PlaybookBundle *playbookBundle
 }
 
+// Playbooks shipped with the provisioner image
+const defaultPlaybookPath = "./ansible/playbooks"
+
 func NewAnsibleRunner(client dynamic.Interface) *AnsibleRunner {
+	return newAnsibleRunnerWith(client, localExecutor{}, opensshClient{keyPath: defaultSSHKeyPath()})
+}
+
+// Private key the provisioner logs in to VMs with
+func defaultSSHKeyPath() string {
 	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".ssh/id_rsa")
+}
+
+// A runner whose commands go to the given executor and SSH client instead of
+// exec.Command, e.g. a Job-based executor or a fake
+func newAnsibleRunnerWith(client dynamic.Interface, executor Executor, ssh SSHClient) *AnsibleRunner {
 	return &AnsibleRunner{
 		inventoryPath: "./ansible/inventories/hosts",
-		playbookPath:  "./ansible/playbooks",
-		sshKeyPath:    filepath.Join(homeDir, ".ssh/id_rsa"),
+		playbookPath:  defaultPlaybookPath,
+		sshKeyPath:    defaultSSHKeyPath(),
 		client:        client,
+		exec:          executor,
+		ssh:           ssh,
 	}
 }
 
@@ -57,6 +92,24 @@ func (ar *AnsibleRunner) RunPlaybook(vmIP string, sessionName string, scenario s
 		return err
 	}
 
+	// Whichever object the playbooks came from, Session variables override the Scenario's
+	config.Variables = ar.extraVars(sessionName, scenario, nil)
+	if err := resolveSecretVariables(ar.client, config); err != nil {
+		return err
+	}
+
+	// Proxy, timezone, locale and CA bundle of the VM's environment
+	env, _ := environmentForIP(vmIP)
+	injectSystemSettings(config, env)
+
+	// Extra disks requested through the TrainingVM's scenario annotations
+	if isPublicIP(vmIP) {
+		if trainingVM, err := ar.client.Resource(trainingVMGVR).Namespace("default").Get(context.TODO(), trainingVMNameForSession(sessionName), metav1.GetOptions{}); err == nil {
+			config.DataVolumes = parseCloudStorage(trainingVM.GetAnnotations()).DataVolumes
+			withDataVolumesPlaybook(config)
+		}
+	}
+
 	log.Printf("🎯 Provisioning config for session %s: playbooks=%v, packages=%v", sessionName, config.Playbooks, config.Packages)
 
 	// Detect SSH user for this VM (existing user)
@@ -66,22 +119,38 @@ func (ar *AnsibleRunner) RunPlaybook(vmIP string, sessionName string, scenario s
 	}
 	log.Printf("🔍 Using existing SSH user: %s for %s (session: %s)", sshUser, vmIP, sessionName)
 
+	// Pick apt or dnf task paths for this VM's OS
+	if config.Platform, err = ar.detectVMPlatform(vmIP, sshUser); err != nil {
+		return err
+	}
+
 	// Create dynamic inventory with session-specific variables but existing user
 	inventoryContent := ar.buildInventory(vmIP, sshUser, sessionName, config)
 
 	// Write temporary inventory file
 	tmpInventory := fmt.Sprintf("/tmp/ansible_inventory_%s", sessionName)
-	if err := os.WriteFile(tmpInventory, []byte(inventoryContent), 0644); err != nil {
+	if err := os.WriteFile(tmpInventory, []byte(inventoryContent), 0600); err != nil {
 		return fmt.Errorf("failed to write inventory: %v", err)
 	}
 	defer os.Remove(tmpInventory)
 
-	// Run multiple playbooks in sequence
-	for _, playbook := range config.Playbooks {
-		log.Printf("🎭 Running playbook %s for session %s on existing user %s", playbook, sessionName, sshUser)
-		if err := ar.runSinglePlaybook(tmpInventory, playbook, sessionName, config); err != nil {
-			return fmt.Errorf("playbook %s failed: %v", playbook, err)
+	// Record the owning session on the VM before anything of the session lands on it
+	if err := ar.writeVMMetadata(vmIP, sshUser, ar.trainingVMMetadata(sessionName, scenario)); err != nil {
+		log.Printf("⚠️ Could not write metadata of session %s to VM %s: %v", sessionName, vmIP, err)
+	}
+
+	// Run multiple playbooks in sequence, holding the VM's lock against the Kratix controller
+	err = withVMLock(ar.client, vmIP, "allocator:"+sessionName, func() error {
+		for _, playbook := range config.Playbooks {
+			log.Printf("🎭 Running playbook %s for session %s on existing user %s", playbook, sessionName, sshUser)
+			if err := ar.runSinglePlaybook(tmpInventory, playbook, sessionName, config); err != nil {
+				return fmt.Errorf("playbook %s failed: %v", playbook, err)
+			}
 		}
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
 	log.Printf("✅ All playbooks completed for session %s on VM %s (user: %s)", sessionName, vmIP, sshUser)
@@ -110,20 +179,8 @@ func (ar *AnsibleRunner) waitForEC2ReadyFixed(vmIP string) error {
 
 // Simplified SSH test that actually works
 func (ar *AnsibleRunner) testSSHSimple(vmIP string) bool {
-	users := []string{"ubuntu", "ec2-user", "admin"}
-	
-	for _, user := range users {
-		cmd := exec.Command("ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "ConnectTimeout=15",
-			"-o", "BatchMode=yes",
-			"-i", ar.sshKeyPath,
-			fmt.Sprintf("%s@%s", user, vmIP),
-			"echo", "SSH_TEST_SUCCESS",
-		)
-		
-		output, err := cmd.CombinedOutput()
+	for _, user := range sshUserCandidates(ar.client, vmIP) {
+		output, err := ar.ssh.CombinedOutput(user, vmIP, 15*time.Second, "echo", "SSH_TEST_SUCCESS")
 		if err == nil && strings.Contains(string(output), "SSH_TEST_SUCCESS") {
 			log.Printf("🔍 SSH test successful with user %s for %s", user, vmIP)
 			return true
@@ -134,28 +191,8 @@ func (ar *AnsibleRunner) testSSHSimple(vmIP string) bool {
 }
 
 func (ar *AnsibleRunner) detectSSHUser(vmIP string) (string, error) {
-	users := []string{"ubuntu", "ec2-user", "admin", "kube"}
-	
-	if isPublicIP(vmIP) {
-		// For EC2, try common users
-		users = []string{"ubuntu", "ec2-user", "admin"}
-	} else {
-		// For local VMs, try kube first
-		users = []string{"kube", "ubuntu", "admin"}
-	}
-
-	for _, user := range users {
-		cmd := exec.Command("ssh",
-			"-o", "StrictHostKeyChecking=no",
-			"-o", "UserKnownHostsFile=/dev/null",
-			"-o", "ConnectTimeout=15",
-			"-o", "BatchMode=yes",
-			"-i", ar.sshKeyPath,
-			fmt.Sprintf("%s@%s", user, vmIP),
-			"echo", "success",
-		)
-
-		if err := cmd.Run(); err == nil {
+	for _, user := range sshUserCandidates(ar.client, vmIP) {
+		if _, err := ar.ssh.Output(user, vmIP, 15*time.Second, "echo", "success"); err == nil {
 			log.Printf("🔍 Detected existing SSH user for %s: %s", vmIP, user)
 			return user, nil
 		}
@@ -193,7 +230,7 @@ func (ar *AnsibleRunner) getSessionProvisioningConfig(sessionName string) (*Prov
 		return nil, err
 	}
 
-	return ar.extractProvisioningFromAnnotations(session.GetAnnotations())
+	return ar.extractProvisioningFromAnnotations(session.GetAnnotations(), DetectionInput{Session: sessionName})
 }
 
 func (ar *AnsibleRunner) getScenarioProvisioningConfig(scenario string) (*ProvisioningConfig, error) {
@@ -201,19 +238,16 @@ func (ar *AnsibleRunner) getScenarioProvisioningConfig(scenario string) (*Provis
 		return nil, fmt.Errorf("no scenario specified")
 	}
 
-	scenarioObj, err := ar.client.Resource(scenarioGVR).Namespace("default").Get(
-		context.TODO(), scenario, metav1.GetOptions{})
+	scenarioObj, err := getScenario(ar.client, scenario, "default")
 	if err != nil {
 		return nil, err
 	}
 
-	return ar.extractProvisioningFromAnnotations(scenarioObj.GetAnnotations())
+	return ar.extractProvisioningFromAnnotations(scenarioObj.GetAnnotations(), DetectionInput{Scenario: scenario})
 }
 
-func (ar *AnsibleRunner) extractProvisioningFromAnnotations(annotations map[string]string) (*ProvisioningConfig, error) {
-	config := &ProvisioningConfig{
-		Variables: make(map[string]string),
-	}
+func (ar *AnsibleRunner) extractProvisioningFromAnnotations(annotations map[string]string, packageInput DetectionInput) (*ProvisioningConfig, error) {
+	config := &ProvisioningConfig{}
 
 	// Extract playbooks
 	if playbooks, exists := annotations["provisioning.hobbyfarm.io/playbooks"]; exists {
@@ -224,11 +258,9 @@ func (ar *AnsibleRunner) extractProvisioningFromAnnotations(annotations map[stri
 	}
 
 	// Extract packages
-	if packages, exists := annotations["provisioning.hobbyfarm.io/packages"]; exists {
-		config.Packages = strings.Split(packages, ",")
-		for i := range config.Packages {
-			config.Packages[i] = strings.TrimSpace(config.Packages[i])
-		}
+	packageInput.Annotations = annotations
+	if detection := detectPackages(packageInput); detection.Rule != packageRuleDefault {
+		config.Packages = detection.Packages
 	}
 
 	// Extract requirements
@@ -239,23 +271,29 @@ func (ar *AnsibleRunner) extractProvisioningFromAnnotations(annotations map[stri
 		}
 	}
 
-	// Extract variables (key=value format, one per line)
-	if variables, exists := annotations["provisioning.hobbyfarm.io/variables"]; exists {
-		lines := strings.Split(variables, "\n")
-		for _, line := range lines {
-			line = strings.TrimSpace(line)
-			if line == "" {
-				continue
-			}
-			parts := strings.SplitN(line, "=", 2)
-			if len(parts) == 2 {
-				key := strings.TrimSpace(parts[0])
-				value := strings.TrimSpace(parts[1])
-				config.Variables[key] = value
-			}
-		}
+	// Extract inventory template
+	if template, exists := annotations["provisioning.hobbyfarm.io/inventory-template"]; exists {
+		config.InventoryTemplate = strings.TrimSpace(template)
+	}
+
+	// Extract execution environment image
+	if image, exists := annotations["provisioning.hobbyfarm.io/execution-environment"]; exists {
+		config.ExecutionEnvironment = strings.TrimSpace(image)
+	}
+
+	// Extract Ansible version
+	if version, exists := annotations["provisioning.hobbyfarm.io/ansible-version"]; exists {
+		config.AnsibleVersion = strings.TrimSpace(version)
 	}
 
+	// Extract variables to keep out of logs and artifacts
+	if secrets, exists := annotations[secretVariablesAnnotation]; exists {
+		config.SecretVariables = splitList(secrets)
+	}
+
+	// Extract variables (key=value lines or one vars.provisioning.hobbyfarm.io/<name> each)
+	config.Variables = variablesFromAnnotations(annotations)
+
 	// If no playbooks specified, return nil to try scenario or use default
 	if len(config.Playbooks) == 0 {
 		return nil, fmt.Errorf("no playbooks specified in annotations")
@@ -264,78 +302,101 @@ func (ar *AnsibleRunner) extractProvisioningFromAnnotations(annotations map[stri
 	return config, nil
 }
 
-// MODIFIED: Build inventory for existing user instead of session user
+// Render the inventory for the detected (existing) SSH user from the configured template
 func (ar *AnsibleRunner) buildInventory(vmIP string, sshUser string, sessionName string, config *ProvisioningConfig) string {
-	var inventory strings.Builder
-
-	// Base inventory with detected SSH user (existing user)
-	inventory.WriteString(fmt.Sprintf(`[target]
-%s ansible_user=%s ansible_ssh_private_key_file=%s ansible_ssh_common_args='-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'
-
-[all:vars]
-ansible_python_interpreter=/usr/bin/python3
-session_name=%s
-`, vmIP, sshUser, ar.sshKeyPath, sessionName))
-
-	// Add session-specific variables
-	for key, value := range config.Variables {
-		inventory.WriteString(fmt.Sprintf("%s=%s\n", key, value))
-	}
-
-	// Add package list if specified
-	if len(config.Packages) > 0 {
-		inventory.WriteString(fmt.Sprintf("session_packages=%s\n", strings.Join(config.Packages, ",")))
+	data := inventoryData{
+		Hosts:        []inventoryHost{{Name: sessionName, IP: vmIP, User: sshUser}},
+		SessionName:  sessionName,
+		SSHKeyPath:   ar.sshKeyPath,
+		PlatformVars: config.Platform.inventoryVars(),
+		Variables:    config.Variables,
+		Packages:     config.Packages,
+		Requirements: config.Requirements,
 	}
 
-	// Add requirements if specified
-	if len(config.Requirements) > 0 {
-		inventory.WriteString(fmt.Sprintf("session_requirements=%s\n", strings.Join(config.Requirements, ",")))
+	inventory, err := renderInventory(ar.inventoryTemplate(config.InventoryTemplate), data)
+	if err != nil {
+		log.Printf("⚠️ %v, using the built-in inventory template for session %s", err, sessionName)
+		inventory, _ = renderInventory(defaultInventoryTemplate, data)
 	}
-
-	return inventory.String()
+	return inventory
 }
 
 func (ar *AnsibleRunner) runSinglePlaybook(inventory, playbook, sessionName string, config *ProvisioningConfig) error {
+	_, _, err := ar.runSinglePlaybookWithRecap(inventory, playbook, sessionName, config)
+	return err
+}
+
+// Run a playbook and return its parsed recap and raw output; the recap may be
+// nil if the JSON callback output could not be parsed
+func (ar *AnsibleRunner) runSinglePlaybookWithRecap(inventory, playbook, sessionName string, config *ProvisioningConfig) (*PlaybookRecap, []byte, error) {
 	playbookPath := filepath.Join(ar.playbookPath, playbook)
+	if config.PlaybookDir != "" {
+		playbookPath = filepath.Join(config.PlaybookDir, playbook)
+	}
 
 	// Check if playbook exists
 	if _, err := os.Stat(playbookPath); os.IsNotExist(err) {
-		return fmt.Errorf("playbook %s does not exist", playbookPath)
+		return nil, nil, fmt.Errorf("playbook %s does not exist", playbookPath)
 	}
 
-	cmd := exec.Command("ansible-playbook",
-		"-i", inventory,
-		playbookPath,
-		"-v",
-		"--timeout=90",
-	)
+	args := []string{"-v", "--timeout=90"}
 
 	// Add extra variables from config
 	for key, value := range config.Variables {
-		cmd.Args = append(cmd.Args, "-e", fmt.Sprintf("%s=%s", key, value))
+		args = append(args, "-e", fmt.Sprintf("%s=%s", key, value))
 	}
 
 	// Add session name as extra variable
-	cmd.Args = append(cmd.Args, "-e", fmt.Sprintf("session_name=%s", sessionName))
+	args = append(args, "-e", fmt.Sprintf("session_name=%s", sessionName))
+
+	// Lists can't be passed as key=value, so the data volumes go in as JSON
+	if len(config.DataVolumes) > 0 {
+		args = append(args, "-e", dataVolumesExtraVars(config.DataVolumes))
+	}
+
+	// Secret values reach Ansible through the environment, never the inventory
+	if len(config.ResolvedSecrets) > 0 {
+		args = append(args, "-e", config.secretExtraVars())
+	}
 
-	// Set environment variables for Ansible
-	cmd.Env = append(os.Environ(),
+	// Environment variables for Ansible
+	ansibleEnv := []string{
 		"ANSIBLE_HOST_KEY_CHECKING=False",
 		"ANSIBLE_SSH_RETRIES=5",
 		"ANSIBLE_TIMEOUT=90",
-	)
+		"ANSIBLE_STDOUT_CALLBACK=json", // Structured output for per-task status
+	}
+
+	// Run locally or inside an execution environment container
+	name, commandArgs, commandEnv, err := ar.buildPlaybookCommand(inventory, playbookPath, args, ansibleEnv, config)
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot run playbook %s: %v", playbook, err)
+	}
 
 	// Capture output for better debugging
-	output, err := cmd.CombinedOutput()
+	output, err := ar.exec.CombinedOutput(commandEnv, name, commandArgs...)
+
+	recap, parseErr := parseAnsibleJSONOutput(playbook, output)
+	if parseErr != nil {
+		log.Printf("⚠️ Could not parse Ansible results for %s: %v", playbook, parseErr)
+	}
 
 	if err != nil {
-		log.Printf("❌ Ansible output for %s (session %s):\n%s", playbook, sessionName, string(output))
-		return fmt.Errorf("ansible playbook %s failed: %v", playbook, err)
+		log.Printf("❌ Ansible output for %s (session %s):\n%s", playbook, sessionName, ar.sanitizeForLog(output, config))
+		if recap != nil && len(recap.FailedTasks) > 0 {
+			return recap, output, fmt.Errorf("ansible playbook %s failed at task(s) %s: %v", playbook, strings.Join(recap.FailedTasks, ", "), err)
+		}
+		return recap, output, fmt.Errorf("ansible playbook %s failed: %v", playbook, err)
 	}
 
 	log.Printf("✅ Playbook %s completed successfully for session %s", playbook, sessionName)
-	log.Printf("📝 Ansible output:\n%s", string(output))
-	return nil
+	if recap != nil {
+		log.Printf("📝 Ansible recap: %s", recap)
+	} else {
+		log.Printf("📝 Ansible output:\n%s", ar.sanitizeForLog(output, config))
+	}
+	return recap, output, nil
 }
 
 // MODIFIED: Session cleanup function - only clean up session workspace, not user
@@ -348,45 +409,32 @@ func (ar *AnsibleRunner) CleanupSession(vmIP string, sessionName string) error {
 		return fmt.Errorf("failed to detect SSH user for cleanup: %v", err)
 	}
 
+	// Never clean another session's VM
+	if err := ar.verifyVMOwner(vmIP, sshUser, sessionName); err != nil {
+		return err
+	}
+
 	log.Printf("🧹 Cleaning up session workspace for session %s (user: %s)", sessionName, sshUser)
 
 	// Create cleanup command to remove session workspace
 	cleanupCmd := fmt.Sprintf("rm -rf /home/%s/workspace/%s", sshUser, sessionName)
 	
-	cmd := exec.Command("ssh",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "ConnectTimeout=30",
-		"-i", ar.sshKeyPath,
-		fmt.Sprintf("%s@%s", sshUser, vmIP),
-		cleanupCmd,
-	)
-
-	output, err := cmd.CombinedOutput()
+	output, err := ar.ssh.CombinedOutput(sshUser, vmIP, 30*time.Second, cleanupCmd)
 
 	if err != nil {
-		log.Printf("❌ Session workspace cleanup failed for %s:\n%s", sessionName, string(output))
+		log.Printf("❌ Session workspace cleanup failed for %s:\n%s", sessionName, ar.sanitizeForLog(output, nil))
 		return fmt.Errorf("session workspace cleanup failed: %v", err)
 	}
 
 	log.Printf("✅ Session %s workspace cleanup completed successfully", sessionName)
-	log.Printf("📝 Cleanup output:\n%s", string(output))
+	log.Printf("📝 Cleanup output:\n%s", ar.sanitizeForLog(output, nil))
 	
 	// Also stop any session-specific services
-	serviceCleanupCmd := fmt.Sprintf("sudo systemctl stop wso2-%s 2>/dev/null || true; sudo systemctl disable wso2-%s 2>/dev/null || true; sudo rm -f /etc/systemd/system/wso2-%s.service 2>/dev/null || true; sudo systemctl daemon-reload 2>/dev/null || true", sessionName, sessionName, sessionName)
+	serviceCleanupCmd := fmt.Sprintf("sudo systemctl stop wso2-%s 2>/dev/null || true; sudo systemctl disable wso2-%s 2>/dev/null || true; sudo rm -f /etc/systemd/system/wso2-%s.service 2>/dev/null || true; sudo systemctl daemon-reload 2>/dev/null || true; sudo rm -f %s", sessionName, sessionName, sessionName, vmMetadataPath)
 	
-	serviceCmd := exec.Command("ssh",
-		"-o", "StrictHostKeyChecking=no",
-		"-o", "UserKnownHostsFile=/dev/null",
-		"-o", "ConnectTimeout=30",
-		"-i", ar.sshKeyPath,
-		fmt.Sprintf("%s@%s", sshUser, vmIP),
-		serviceCleanupCmd,
-	)
-
-	serviceOutput, serviceErr := serviceCmd.CombinedOutput()
+	serviceOutput, serviceErr := ar.ssh.CombinedOutput(sshUser, vmIP, 30*time.Second, serviceCleanupCmd)
 	if serviceErr != nil {
-		log.Printf("⚠️ Service cleanup had issues (non-critical): %s", string(serviceOutput))
+		log.Printf("⚠️ Service cleanup had issues (non-critical): %s", ar.sanitizeForLog(serviceOutput, nil))
 	} else {
 		log.Printf("✅ Session services cleanup completed")
 	}
@@ -406,20 +454,11 @@ func (ar *AnsibleRunner) WaitForSSH(vmIP string, timeout time.Duration) error {
 }
 
 func (ar *AnsibleRunner) waitForLocalSSH(vmIP string, deadline time.Time) error {
-	users := []string{"kube", "ubuntu", "admin"}
-	
+	users := sshUserCandidates(ar.client, vmIP)
+
 	for time.Now().Before(deadline) {
 		for _, user := range users {
-			cmd := exec.Command("ssh",
-				"-o", "StrictHostKeyChecking=no",
-				"-o", "UserKnownHostsFile=/dev/null",
-				"-o", "ConnectTimeout=5",
-				"-i", ar.sshKeyPath,
-				fmt.Sprintf("%s@%s", user, vmIP),
-				"echo", "ready",
-			)
-
-			if err := cmd.Run(); err == nil {
+			if _, err := ar.ssh.Output(user, vmIP, 5*time.Second, "echo", "ready"); err == nil {
 				log.Printf("✅ SSH is ready on static VM %s with user %s", vmIP, user)
 				return nil
 			}
@@ -437,8 +476,8 @@ func (ar *AnsibleRunner) waitForEC2Ready(vmIP string) error {
 }
 
 func (ar *AnsibleRunner) pingTest(vmIP string) bool {
-	cmd := exec.Command("ping", "-c", "1", "-W", "3", vmIP)
-	return cmd.Run() == nil
+	_, err := ar.exec.CombinedOutput(nil, "ping", "-c", "1", "-W", "3", vmIP)
+	return err == nil
 }
 
 func (ar *AnsibleRunner) sshTest(vmIP string) bool {
@@ -0,0 +1,274 @@
+// internal/ansible_runner_test.go - Commands the runner sends over SSH and to ansible-playbook
+package internal
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// One command run over the fake SSH client
+type sshCall struct {
+	User    string
+	Command string
+}
+
+// SSH client answering from a table instead of the network. Only users in
+// logins can log in; a command gets the output of the first reply whose key
+// it starts with.
+type fakeSSHClient struct {
+	mu      sync.Mutex
+	logins  map[string]bool
+	replies map[string]string
+	calls   []sshCall
+}
+
+func (c *fakeSSHClient) Output(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	joined := strings.Join(command, " ")
+	c.calls = append(c.calls, sshCall{User: user, Command: joined})
+	if !c.logins[user] {
+		return nil, fmt.Errorf("%s@%s: Permission denied (publickey)", user, vmIP)
+	}
+	for prefix, reply := range c.replies {
+		if strings.HasPrefix(joined, prefix) {
+			return []byte(reply), nil
+		}
+	}
+	return nil, nil
+}
+
+func (c *fakeSSHClient) CombinedOutput(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error) {
+	return c.Output(user, vmIP, connectTimeout, command...)
+}
+
+func (c *fakeSSHClient) commands() []string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	var commands []string
+	for _, call := range c.calls {
+		commands = append(commands, call.Command)
+	}
+	return commands
+}
+
+// One program run through the fake executor
+type execCall struct {
+	Env  []string
+	Name string
+	Args []string
+}
+
+type fakeExecutor struct {
+	mu    sync.Mutex
+	calls []execCall
+}
+
+func (e *fakeExecutor) CombinedOutput(env []string, name string, args ...string) ([]byte, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	e.calls = append(e.calls, execCall{Env: env, Name: name, Args: args})
+	return nil, nil
+}
+
+func newFakeRunner(ssh *fakeSSHClient) (*AnsibleRunner, *fakeExecutor) {
+	executor := &fakeExecutor{}
+	return newAnsibleRunnerWith(nil, executor, ssh), executor
+}
+
+func TestDetectSSHUser(t *testing.T) {
+	useStaticPool(t, "10.0.0.5")
+	ssh := &fakeSSHClient{logins: map[string]bool{"ubuntu": true}}
+	ar, _ := newFakeRunner(ssh)
+
+	user, err := ar.detectSSHUser("10.0.0.5")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user != "ubuntu" {
+		t.Fatalf("detected %q, want ubuntu", user)
+	}
+	// The environment's user is tried first
+	if len(ssh.calls) != 2 || ssh.calls[0].User != "kube" || ssh.calls[1].User != "ubuntu" {
+		t.Fatalf("tried %v, want kube then ubuntu", ssh.calls)
+	}
+
+	ssh = &fakeSSHClient{logins: map[string]bool{}}
+	ar, _ = newFakeRunner(ssh)
+	if user, err := ar.detectSSHUser("10.0.0.5"); err == nil {
+		t.Fatalf("detected %q on a VM no user can log in to", user)
+	}
+}
+
+func TestDetectVMPlatform(t *testing.T) {
+	cases := []struct {
+		name    string
+		release string
+		want    vmPlatform
+		wantErr bool
+	}{
+		{
+			name:    "ubuntu",
+			release: "x86_64\nNAME=\"Ubuntu\"\nID=ubuntu\nID_LIKE=debian\nVERSION_ID=\"22.04\"\n",
+			want:    vmPlatform{Family: "debian", Distribution: "ubuntu", Version: "22.04", Arch: "amd64", PackageManager: "apt"},
+		},
+		{
+			name:    "rocky on arm",
+			release: "aarch64\nID=\"rocky\"\nID_LIKE=\"rhel centos fedora\"\nVERSION_ID=\"9.3\"\n",
+			want:    vmPlatform{Family: "redhat", Distribution: "rocky", Version: "9.3", Arch: "arm64", PackageManager: "dnf"},
+		},
+		{
+			name:    "unsupported",
+			release: "x86_64\nID=alpine\nVERSION_ID=3.19.0\n",
+			wantErr: true,
+		},
+	}
+	for _, tc := range cases {
+		t.Run(tc.name, func(t *testing.T) {
+			ssh := &fakeSSHClient{logins: map[string]bool{"kube": true}, replies: map[string]string{"uname -m": tc.release}}
+			ar, _ := newFakeRunner(ssh)
+
+			platform, err := ar.detectVMPlatform("10.0.0.5", "kube")
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("detected %v, want an error", platform)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if platform != tc.want {
+				t.Fatalf("detected %+v, want %+v", platform, tc.want)
+			}
+			if commands := ssh.commands(); len(commands) != 1 || commands[0] != "uname -m; cat /etc/os-release" {
+				t.Fatalf("ran %q", commands)
+			}
+		})
+	}
+}
+
+func TestCleanupSession(t *testing.T) {
+	useStaticPool(t, "10.0.0.5")
+	ssh := &fakeSSHClient{
+		logins:  map[string]bool{"kube": true},
+		replies: map[string]string{"cat " + vmMetadataPath: `{"session":"session-1"}`},
+	}
+	ar, _ := newFakeRunner(ssh)
+
+	if err := ar.CleanupSession("10.0.0.5", "session-1"); err != nil {
+		t.Fatal(err)
+	}
+	commands := ssh.commands()
+	if !slices.Contains(commands, "rm -rf /home/kube/workspace/session-1") {
+		t.Fatalf("workspace not removed, ran %q", commands)
+	}
+	last := commands[len(commands)-1]
+	for _, part := range []string{"systemctl stop wso2-session-1", "rm -f /etc/systemd/system/wso2-session-1.service", "rm -f " + vmMetadataPath} {
+		if !strings.Contains(last, part) {
+			t.Errorf("service cleanup %q lacks %q", last, part)
+		}
+	}
+}
+
+func TestCleanupSessionRefusesAnotherSessionsVM(t *testing.T) {
+	useStaticPool(t, "10.0.0.5")
+	ssh := &fakeSSHClient{
+		logins:  map[string]bool{"kube": true},
+		replies: map[string]string{"cat " + vmMetadataPath: `{"session":"session-2"}`},
+	}
+	ar, _ := newFakeRunner(ssh)
+
+	if err := ar.CleanupSession("10.0.0.5", "session-1"); err == nil {
+		t.Fatal("cleaned a VM that belongs to session-2")
+	}
+	for _, command := range ssh.commands() {
+		if strings.Contains(command, "rm ") {
+			t.Fatalf("ran %q on another session's VM", command)
+		}
+	}
+}
+
+// A runner whose playbook directory holds base.yaml
+func runnerWithPlaybook(t *testing.T) (*AnsibleRunner, *fakeExecutor) {
+	ar, executor := newFakeRunner(&fakeSSHClient{})
+	ar.playbookPath = t.TempDir()
+	if err := os.WriteFile(filepath.Join(ar.playbookPath, "base.yaml"), []byte("- hosts: all\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return ar, executor
+}
+
+func TestPlaybookCommandLocal(t *testing.T) {
+	t.Setenv("ANSIBLE_EE_RUNTIME", eeRuntimeLocal)
+	t.Setenv("ANSIBLE_PYTHON", "")
+	ar, executor := runnerWithPlaybook(t)
+	config := &ProvisioningConfig{Variables: map[string]string{}, ResolvedSecrets: map[string]string{"db_password": "s3cret"}}
+
+	if _, _, err := ar.runSinglePlaybookWithRecap("/tmp/inventory", "base.yaml", "session-1", config); err != nil {
+		t.Fatal(err)
+	}
+	call := executor.calls[0]
+	if call.Name != "ansible-playbook" {
+		t.Fatalf("ran %s, want ansible-playbook", call.Name)
+	}
+	if !slices.Equal(call.Args[:3], []string{"-i", "/tmp/inventory", filepath.Join(ar.playbookPath, "base.yaml")}) {
+		t.Fatalf("arguments %q", call.Args)
+	}
+	if !slices.Contains(call.Args, "session_name=session-1") {
+		t.Errorf("session name not passed in %q", call.Args)
+	}
+	if !slices.Contains(call.Env, secretEnvName("db_password")+"=s3cret") {
+		t.Errorf("secret not in the environment %q", call.Env)
+	}
+	if strings.Contains(strings.Join(call.Args, " "), "s3cret") {
+		t.Errorf("secret value on the command line %q", call.Args)
+	}
+}
+
+func TestPlaybookCommandInExecutionEnvironment(t *testing.T) {
+	t.Setenv("ANSIBLE_EE_RUNTIME", eeRuntimePodman)
+	ar, executor := runnerWithPlaybook(t)
+	config := &ProvisioningConfig{
+		Variables:            map[string]string{},
+		ExecutionEnvironment: "registry.example.com/ee:1.0",
+		ResolvedSecrets:      map[string]string{"db_password": "s3cret"},
+	}
+
+	if _, _, err := ar.runSinglePlaybookWithRecap("/tmp/inventory", "base.yaml", "session-1", config); err != nil {
+		t.Fatal(err)
+	}
+	call := executor.calls[0]
+	if call.Name != "podman" {
+		t.Fatalf("ran %s, want podman", call.Name)
+	}
+	if !slices.Equal(call.Args[:4], []string{"run", "--rm", "--network", "host"}) {
+		t.Fatalf("arguments %q", call.Args)
+	}
+	args := strings.Join(call.Args, " ")
+	for _, part := range []string{
+		"-v /tmp/inventory:/runner/inventory:ro",
+		"-v " + ar.playbookPath + ":/runner/project:ro",
+		"-v " + ar.sshKeyPath + ":/runner/ssh_key:ro",
+		"-e " + secretEnvName("db_password") + " ",
+		"registry.example.com/ee:1.0 ansible-playbook -i /runner/inventory /runner/project/base.yaml",
+		"-e ansible_ssh_private_key_file=/runner/ssh_key",
+	} {
+		if !strings.Contains(args, part) {
+			t.Errorf("container command lacks %q: %s", part, args)
+		}
+	}
+	// The value only reaches the container through the runtime's environment
+	if strings.Contains(args, "s3cret") {
+		t.Errorf("secret value on the command line: %s", args)
+	}
+	if !slices.Contains(call.Env, secretEnvName("db_password")+"=s3cret") {
+		t.Errorf("secret not in the runtime's environment %q", call.Env)
+	}
+}
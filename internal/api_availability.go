@@ -0,0 +1,236 @@
+// internal/api_availability.go - Degraded modes while Kratix, Crossplane or the training CRDs are not installed
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/discovery"
+    "k8s.io/client-go/dynamic"
+)
+
+// Optional APIs, each the condition type prefix of its entry in the status ConfigMap
+const (
+    apiKratix     = "Kratix"
+    apiCrossplane = "Crossplane"
+    apiTraining   = "TrainingCRDs"
+)
+
+// ConfigMap holding one <API>Available condition per optional API and the startup report
+const apiStatusConfigMap = "hobbyfarm-provisioner-status"
+
+// An API the provisioner can run without, and what stops while it is missing
+type optionalAPI struct {
+    name      string
+    disables  string
+    resources []schema.GroupVersionResource
+}
+
+var optionalAPIs = []optionalAPI{
+    {
+        name:      apiKratix,
+        disables:  "VMProvisioningRequest controller, HobbyFarm integration and course statuses",
+        resources: []schema.GroupVersionResource{vmProvisioningRequestGVR},
+    },
+    {
+        name:      apiCrossplane,
+        disables:  "cloud fallback and cloud instance cleanup",
+        resources: []schema.GroupVersionResource{ec2TrainingVMGVR, ec2InstanceGVR},
+    },
+    {
+        name:      apiTraining,
+        disables:  "TrainingVM controller and allocator, course statuses and pool events",
+        resources: []schema.GroupVersionResource{trainingVMGVR, courseStatusGVR, staticVMPoolGVR},
+    },
+}
+
+func getAPICheckInterval() time.Duration {
+    if seconds, err := strconv.Atoi(Setting("API_CHECK_SECONDS")); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    return 60 * time.Second
+}
+
+// Missing resources of each API as of the last check. Without a discovery client,
+// as with a fake client, or before the first check every API counts as installed.
+var apiAvailability = struct {
+    sync.RWMutex
+    discovery discovery.DiscoveryInterface
+    missing   map[string][]string
+}{missing: map[string][]string{}}
+
+// Keep the first discovery client; InitKubeClient runs again for some loops
+func setAPIDiscovery(discoveryClient discovery.DiscoveryInterface) {
+    apiAvailability.Lock()
+    defer apiAvailability.Unlock()
+    if apiAvailability.discovery == nil {
+        apiAvailability.discovery = discoveryClient
+    }
+}
+
+// Whether every resource of the named API was served at the last check
+func apiAvailable(name string) bool {
+    apiAvailability.RLock()
+    defer apiAvailability.RUnlock()
+    return len(apiAvailability.missing[name]) == 0
+}
+
+// Resources of api the API server doesn't serve. A failed discovery other than
+// a missing group version is reported as an error, keeping the previous state.
+func discoverMissingResources(discoveryClient discovery.DiscoveryInterface, api optionalAPI) ([]string, error) {
+    var missing []string
+    served := map[string]map[string]bool{}
+    for _, gvr := range api.resources {
+        groupVersion := gvr.GroupVersion().String()
+        if served[groupVersion] == nil {
+            served[groupVersion] = map[string]bool{}
+            resources, err := discoveryClient.ServerResourcesForGroupVersion(groupVersion)
+            if err != nil && !apierrors.IsNotFound(err) {
+                return nil, err
+            }
+            if resources != nil {
+                for _, resource := range resources.APIResources {
+                    served[groupVersion][resource.Name] = true
+                }
+            }
+        }
+        if !served[groupVersion][gvr.Resource] {
+            missing = append(missing, gvr.Resource+"."+gvr.Group)
+        }
+    }
+    return missing, nil
+}
+
+// Check every optional API, log and record each one that went missing or came back
+func checkAPIAvailability(client dynamic.Interface) {
+    apiAvailability.RLock()
+    discoveryClient := apiAvailability.discovery
+    apiAvailability.RUnlock()
+    if discoveryClient == nil {
+        return
+    }
+
+    changed := false
+    for _, api := range optionalAPIs {
+        missing, err := discoverMissingResources(discoveryClient, api)
+        if err != nil {
+            log.Printf("⚠️ Could not discover %s APIs: %v", api.name, err)
+            continue
+        }
+
+        apiAvailability.Lock()
+        previous, checked := apiAvailability.missing[api.name]
+        apiAvailability.missing[api.name] = missing
+        apiAvailability.Unlock()
+
+        switch {
+        case len(missing) > 0 && (!checked || len(previous) == 0):
+            log.Printf("🔌 %s not installed (%s): %s disabled", api.name, strings.Join(missing, ", "), api.disables)
+            changed = true
+        case len(missing) == 0 && len(previous) > 0:
+            log.Printf("🔌 %s installed, re-enabling %s", api.name, api.disables)
+            changed = true
+        case !checked:
+            changed = true
+        }
+    }
+    if changed {
+        if err := writeAPIConditions(client); err != nil {
+            log.Printf("⚠️ Could not record API availability in ConfigMap %s: %v", apiStatusConfigMap, err)
+        }
+    }
+}
+
+// Record one <API>Available condition per API in the status ConfigMap, for
+// kubectl get configmap hobbyfarm-provisioner-status -o jsonpath='{.data.conditions}'
+func writeAPIConditions(client dynamic.Interface) error {
+    resource := client.Resource(configMapGVR).Namespace("default")
+    var conditions []interface{}
+    existing, err := resource.Get(context.TODO(), apiStatusConfigMap, metav1.GetOptions{})
+    if err == nil {
+        data, _, _ := unstructured.NestedString(existing.Object, "data", "conditions")
+        json.Unmarshal([]byte(data), &conditions)
+    } else if !apierrors.IsNotFound(err) {
+        return err
+    }
+
+    apiAvailability.RLock()
+    for _, api := range optionalAPIs {
+        if missing := apiAvailability.missing[api.name]; len(missing) > 0 {
+            conditions = mergeCondition(conditions, api.name+"Available", false, "CRDsMissing",
+                fmt.Sprintf("%s not served, %s disabled", strings.Join(missing, ", "), api.disables))
+        } else {
+            conditions = mergeCondition(conditions, api.name+"Available", true, "CRDsInstalled", "")
+        }
+    }
+    apiAvailability.RUnlock()
+
+    data, _ := json.Marshal(conditions)
+    return patchProvisionerStatus(client, map[string]interface{}{"conditions": string(data)})
+}
+
+// Merge keys into the status ConfigMap, creating it on first use
+func patchProvisionerStatus(client dynamic.Interface, data map[string]interface{}) error {
+    resource := client.Resource(configMapGVR).Namespace("default")
+    patchBytes, _ := json.Marshal(map[string]interface{}{"data": data})
+    _, err := resource.Patch(context.TODO(), apiStatusConfigMap, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if !apierrors.IsNotFound(err) {
+        return err
+    }
+    configMap := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "v1",
+            "kind":       "ConfigMap",
+            "metadata": map[string]interface{}{
+                "name":      apiStatusConfigMap,
+                "namespace": "default",
+                "labels":    map[string]interface{}{"app": "hobbyfarm-provisioner"},
+            },
+            "data": data,
+        },
+    }
+    _, err = resource.Create(context.TODO(), configMap, metav1.CreateOptions{})
+    return err
+}
+
+// Check once before the controllers start, then every API_CHECK_SECONDS until
+// ctx is done, so subsystems resume on their own once the CRDs are installed
+func StartAPIAvailabilityChecks(ctx context.Context, client dynamic.Interface) {
+    checkAPIAvailability(client)
+    go func() {
+        ticker := time.NewTicker(getAPICheckInterval())
+        defer ticker.Stop()
+        for {
+            select {
+            case <-ctx.Done():
+                return
+            case <-ticker.C:
+                checkAPIAvailability(client)
+            }
+        }
+    }()
+}
+
+func writeAPIAvailabilityMetrics(w io.Writer) {
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_api_available 1 when every CRD of the optional API is installed, 0 while its subsystems are disabled")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_api_available gauge")
+    for _, api := range optionalAPIs {
+        available := 0
+        if apiAvailable(api.name) {
+            available = 1
+        }
+        fmt.Fprintf(w, "hobbyfarm_provisioner_api_available{api=%q} %d\n", api.name, available)
+    }
+}
@@ -0,0 +1,105 @@
+// internal/api_availability_test.go - Subsystems idle while their CRDs are missing and resume once installed
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "testing"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    fakediscovery "k8s.io/client-go/discovery/fake"
+    clienttesting "k8s.io/client-go/testing"
+)
+
+// Discovery serving the given resources, grouped by group version
+func apiDiscovery(gvrs ...schema.GroupVersionResource) *fakediscovery.FakeDiscovery {
+    lists := map[string]*metav1.APIResourceList{}
+    var resources []*metav1.APIResourceList
+    for _, gvr := range gvrs {
+        groupVersion := gvr.GroupVersion().String()
+        if lists[groupVersion] == nil {
+            lists[groupVersion] = &metav1.APIResourceList{GroupVersion: groupVersion}
+            resources = append(resources, lists[groupVersion])
+        }
+        lists[groupVersion].APIResources = append(lists[groupVersion].APIResources, metav1.APIResource{Name: gvr.Resource, Namespaced: true})
+    }
+    return &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: resources}}
+}
+
+// Check availability through discoveryClient until the test ends
+func useAPIDiscovery(t *testing.T, discoveryClient *fakediscovery.FakeDiscovery) {
+    apiAvailability.Lock()
+    previous, previousMissing := apiAvailability.discovery, apiAvailability.missing
+    apiAvailability.discovery = discoveryClient
+    apiAvailability.missing = map[string][]string{}
+    apiAvailability.Unlock()
+    t.Cleanup(func() {
+        apiAvailability.Lock()
+        defer apiAvailability.Unlock()
+        apiAvailability.discovery, apiAvailability.missing = previous, previousMissing
+    })
+}
+
+// Status of the <API>Available condition in the status ConfigMap, empty when absent
+func apiCondition(t *testing.T, h *testHarness, api string) string {
+    t.Helper()
+    configMap, err := h.client.Resource(configMapGVR).Namespace("default").Get(context.TODO(), apiStatusConfigMap, metav1.GetOptions{})
+    if err != nil {
+        t.Fatalf("status ConfigMap: %v", err)
+    }
+    data, _, _ := unstructured.NestedString(configMap.Object, "data", "conditions")
+    var conditions []map[string]interface{}
+    if err := json.Unmarshal([]byte(data), &conditions); err != nil {
+        t.Fatalf("conditions %q: %v", data, err)
+    }
+    for _, condition := range conditions {
+        if condition["type"] == api+"Available" {
+            status, _ := condition["status"].(string)
+            return status
+        }
+    }
+    return ""
+}
+
+func TestKratixMissingDisablesController(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    h := newTestHarness(t)
+    discoveryClient := apiDiscovery(trainingVMGVR, courseStatusGVR, staticVMPoolGVR, ec2TrainingVMGVR, ec2InstanceGVR)
+    useAPIDiscovery(t, discoveryClient)
+
+    checkAPIAvailability(h.client)
+    if apiAvailable(apiKratix) {
+        t.Fatal("Kratix counted as installed without its CRD")
+    }
+    if !apiAvailable(apiCrossplane) || !apiAvailable(apiTraining) {
+        t.Fatal("installed APIs counted as missing")
+    }
+    if status := apiCondition(t, h, apiKratix); status != "False" {
+        t.Fatalf("KratixAvailable is %q, want False", status)
+    }
+    if status := apiCondition(t, h, apiTraining); status != "True" {
+        t.Fatalf("TrainingCRDsAvailable is %q, want True", status)
+    }
+
+    // The controller leaves requests alone while the API is missing
+    if err := h.submitRequest("req-1", "alice", "session-1"); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "")
+
+    // The CRD appears: the next check re-enables the controller
+    discoveryClient.Resources = apiDiscovery(vmProvisioningRequestGVR, trainingVMGVR, courseStatusGVR,
+        staticVMPoolGVR, ec2TrainingVMGVR, ec2InstanceGVR).Resources
+    checkAPIAvailability(h.client)
+    if !apiAvailable(apiKratix) {
+        t.Fatal("Kratix still counted as missing after its CRD was installed")
+    }
+    if status := apiCondition(t, h, apiKratix); status != "True" {
+        t.Fatalf("KratixAvailable is %q, want True", status)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "allocated")
+}
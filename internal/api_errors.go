@@ -0,0 +1,189 @@
+// internal/api_errors.go - API server outages told apart from problems with one resource, with retries and paused loops while the cluster is away
+package internal
+
+import (
+    "context"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net"
+    "net/http"
+    "strconv"
+    "sync"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    utilnet "k8s.io/apimachinery/pkg/util/net"
+    "k8s.io/apimachinery/pkg/util/wait"
+    "k8s.io/client-go/util/retry"
+)
+
+// Attempts of a status patch or create before its error is returned
+func getAPIRetryAttempts() int {
+    if attempts, err := strconv.Atoi(Setting("API_RETRY_ATTEMPTS")); err == nil && attempts > 0 {
+        return attempts
+    }
+    return 4
+}
+
+// 250ms, 500ms, 1s between attempts: long enough to ride out an API server restart's
+// first seconds, short enough not to stall a loop
+func apiRetryBackoff() wait.Backoff {
+    return wait.Backoff{
+        Steps:    getAPIRetryAttempts(),
+        Duration: 250 * time.Millisecond,
+        Factor:   2,
+        Jitter:   0.2,
+    }
+}
+
+// Whether err means the API server could not be reached or could not answer,
+// rather than that something is wrong with the resource asked for. Only these
+// are retried, and none of them may fail a request.
+func isClusterUnavailable(err error) bool {
+    if err == nil {
+        return false
+    }
+    if apierrors.IsServerTimeout(err) || apierrors.IsTimeout(err) || apierrors.IsTooManyRequests(err) ||
+        apierrors.IsServiceUnavailable(err) || apierrors.IsUnexpectedServerError(err) {
+        return true
+    }
+    if utilnet.IsConnectionRefused(err) || utilnet.IsConnectionReset(err) || utilnet.IsProbableEOF(err) {
+        return true
+    }
+    var netErr net.Error
+    if errors.As(err, &netErr) {
+        return true
+    }
+    return errors.Is(err, context.DeadlineExceeded)
+}
+
+// An error during an outage counts as the outage, even when it was wrapped with
+// %v on its way up and can no longer be classified
+func apiOutage(err error) bool {
+    return isClusterUnavailable(err) || !clusterReachable()
+}
+
+// Run fn again on outage errors, backing off between attempts
+func retryAPI(fn func() error) error {
+    return retry.OnError(apiRetryBackoff(), isClusterUnavailable, fn)
+}
+
+// Whether the API server answered the last request, fed by every request the
+// client sends. Without a transport watching, as with a fake client, it
+// always counts as reachable.
+var clusterAvailability = struct {
+    sync.Mutex
+    unreachableSince time.Time
+    lastError        string
+    outages          int64
+}{}
+
+func clusterReachable() bool {
+    clusterAvailability.Lock()
+    defer clusterAvailability.Unlock()
+    return clusterAvailability.unreachableSince.IsZero()
+}
+
+func markClusterUnreachable(err error) {
+    clusterAvailability.Lock()
+    defer clusterAvailability.Unlock()
+    clusterAvailability.lastError = err.Error()
+    if !clusterAvailability.unreachableSince.IsZero() {
+        return
+    }
+    clusterAvailability.unreachableSince = time.Now()
+    clusterAvailability.outages++
+    log.Printf("🔌 API server unreachable, pausing controllers until it answers: %v", err)
+}
+
+func markClusterReachable() {
+    clusterAvailability.Lock()
+    defer clusterAvailability.Unlock()
+    if clusterAvailability.unreachableSince.IsZero() {
+        return
+    }
+    log.Printf("🔌 API server reachable again after %v, resuming controllers", time.Since(clusterAvailability.unreachableSince).Round(time.Second))
+    clusterAvailability.unreachableSince = time.Time{}
+    clusterAvailability.lastError = ""
+}
+
+// Watch every request for the API server going away and coming back. 503 and
+// 504 are the API server itself struggling, e.g. with etcd; anything else it
+// answers means it is there.
+type clusterAvailabilityTransport struct {
+    next http.RoundTripper
+}
+
+func watchClusterAvailability(next http.RoundTripper) http.RoundTripper {
+    return clusterAvailabilityTransport{next: next}
+}
+
+func (t clusterAvailabilityTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.next.RoundTrip(req)
+    switch {
+    case err != nil:
+        // A caller giving up is not the API server's doing
+        if req.Context().Err() == nil {
+            markClusterUnreachable(err)
+        }
+    case resp.StatusCode == http.StatusServiceUnavailable || resp.StatusCode == http.StatusGatewayTimeout:
+        markClusterUnreachable(fmt.Errorf("%s %s: HTTP %d", req.Method, req.URL.Path, resp.StatusCode))
+    default:
+        markClusterReachable()
+    }
+    return resp, err
+}
+
+// Wait until the API server answers fn, for state a controller can't start
+// without. Errors other than an outage are returned at once.
+func waitForAPIServer(what string, fn func() error) error {
+    delay := time.Second
+    for {
+        err := retryAPI(fn)
+        if err == nil || !isClusterUnavailable(err) {
+            return err
+        }
+        log.Printf("⏳ API server unavailable while %s, retrying in %v: %v", what, delay, err)
+        time.Sleep(delay)
+        if delay < 30*time.Second {
+            delay *= 2
+        }
+    }
+}
+
+// The ongoing outage, in /stats
+type apiServerOutage struct {
+    UnreachableSince string `json:"unreachableSince"`
+    LastError        string `json:"lastError"`
+}
+
+func currentAPIServerOutage() *apiServerOutage {
+    clusterAvailability.Lock()
+    defer clusterAvailability.Unlock()
+    if clusterAvailability.unreachableSince.IsZero() {
+        return nil
+    }
+    return &apiServerOutage{
+        UnreachableSince: clusterAvailability.unreachableSince.UTC().Format(time.RFC3339),
+        LastError:        clusterAvailability.lastError,
+    }
+}
+
+func writeClusterAvailabilityMetrics(w io.Writer) {
+    clusterAvailability.Lock()
+    reachable := 1
+    if !clusterAvailability.unreachableSince.IsZero() {
+        reachable = 0
+    }
+    outages := clusterAvailability.outages
+    clusterAvailability.Unlock()
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_api_server_reachable 1 while the API server answers, 0 while controllers are paused")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_api_server_reachable gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_api_server_reachable %d\n", reachable)
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_api_server_outages_total Times the API server became unreachable")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_api_server_outages_total counter")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_api_server_outages_total %d\n", outages)
+}
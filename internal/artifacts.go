@@ -0,0 +1,156 @@
+// internal/artifacts.go - Upload provisioning artifacts (logs, inventories, results) to S3/MinIO
+package internal
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+)
+
+// Artifacts collected during a single provisioning run
+type provisioningArtifacts struct {
+    requestName string
+    startedAt   time.Time
+    files       map[string][]byte
+}
+
+func newProvisioningArtifacts(requestName string) *provisioningArtifacts {
+    return &provisioningArtifacts{
+        requestName: requestName,
+        startedAt:   time.Now().UTC(),
+        files:       make(map[string][]byte),
+    }
+}
+
+// Artifact upload is enabled by setting ARTIFACTS_BUCKET
+func artifactsEnabled() bool {
+    return Setting("ARTIFACTS_BUCKET") != ""
+}
+
+func getArtifactsPrefix() string {
+    if prefix := strings.Trim(Setting("ARTIFACTS_PREFIX"), "/"); prefix != "" {
+        return prefix
+    }
+    return "provisioning-runs"
+}
+
+func getArtifactsRetentionDays() int {
+    if days, err := strconv.Atoi(Setting("ARTIFACTS_RETENTION_DAYS")); err == nil && days > 0 {
+        return days
+    }
+    return 14
+}
+
+// Artifacts leave the cluster, so they are redacted whatever SECURITY_REVIEW_MODE says
+func (pa *provisioningArtifacts) addInventory(content string, config *ProvisioningConfig) {
+    pa.files["inventory.ini"] = []byte(redactInventory(content, config))
+}
+
+func (pa *provisioningArtifacts) addPlaybookLog(playbook string, output []byte, config *ProvisioningConfig) {
+    pa.files[fmt.Sprintf("logs/%s.log", strings.TrimSuffix(playbook, filepath.Ext(playbook)))] = []byte(redactSecrets(string(output), config, ""))
+}
+
+func (pa *provisioningArtifacts) addResults(recaps []*PlaybookRecap, provisioningErr error) {
+    results := map[string]interface{}{
+        "request":   pa.requestName,
+        "startedAt": pa.startedAt.Format(time.RFC3339),
+        "playbooks": recaps,
+        "succeeded": provisioningErr == nil,
+    }
+    if provisioningErr != nil {
+        results["error"] = provisioningErr.Error()
+    }
+    data, _ := json.MarshalIndent(results, "", "  ")
+    pa.files["results.json"] = data
+}
+
+// Upload the run's artifacts and return their URL. Keys are laid out as
+// <prefix>/<date>/<request>/<run> so retention can sweep whole days.
+func (pa *provisioningArtifacts) upload() (string, error) {
+    bucket := Setting("ARTIFACTS_BUCKET")
+    runKey := fmt.Sprintf("%s/%s/%s/%s", getArtifactsPrefix(), pa.startedAt.Format("2006-01-02"),
+        pa.requestName, pa.startedAt.Format("150405"))
+
+    tmpDir, err := os.MkdirTemp("", "provisioning-artifacts-")
+    if err != nil {
+        return "", fmt.Errorf("failed to create artifact dir: %v", err)
+    }
+    defer os.RemoveAll(tmpDir)
+
+    for name, data := range pa.files {
+        path := filepath.Join(tmpDir, name)
+        if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+            return "", fmt.Errorf("failed to create artifact dir: %v", err)
+        }
+        if err := os.WriteFile(path, data, 0600); err != nil {
+            return "", fmt.Errorf("failed to write artifact %s: %v", name, err)
+        }
+    }
+
+    destination := fmt.Sprintf("s3://%s/%s/", bucket, runKey)
+    cmd := outboundCommand("aws", artifactsCLIArgs("s3", "cp", "--recursive", "--only-show-errors", tmpDir, destination)...)
+    if output, err := cmd.CombinedOutput(); err != nil {
+        return "", fmt.Errorf("artifact upload failed: %v: %s", err, strings.TrimSpace(string(output)))
+    }
+
+    // Link MinIO artifacts by HTTP URL, AWS ones by s3:// URL
+    if endpoint := Setting("ARTIFACTS_ENDPOINT"); endpoint != "" {
+        return fmt.Sprintf("%s/%s/%s/", strings.TrimSuffix(endpoint, "/"), bucket, runKey), nil
+    }
+    return destination, nil
+}
+
+// Prepend --endpoint-url for S3-compatible stores such as MinIO
+func artifactsCLIArgs(args ...string) []string {
+    if endpoint := Setting("ARTIFACTS_ENDPOINT"); endpoint != "" {
+        return append([]string{"--endpoint-url", endpoint}, args...)
+    }
+    return args
+}
+
+// Delete day prefixes older than the retention period
+func CleanupExpiredArtifacts() {
+    if !artifactsEnabled() {
+        return
+    }
+
+    bucket := Setting("ARTIFACTS_BUCKET")
+    base := fmt.Sprintf("s3://%s/%s/", bucket, getArtifactsPrefix())
+    cutoff := time.Now().UTC().AddDate(0, 0, -getArtifactsRetentionDays())
+    removeExpiredDayPrefixes(base, cutoff, artifactsCLIArgs)
+}
+
+// Delete the <date>/ prefixes under base from before cutoff; state snapshots use
+// the same layout
+func removeExpiredDayPrefixes(base string, cutoff time.Time, cliArgs func(...string) []string) {
+    output, err := outboundCommand("aws", cliArgs("s3", "ls", base)...).Output()
+    if err != nil {
+        log.Printf("⚠️ Could not list %s: %v", base, err)
+        return
+    }
+
+    scanner := bufio.NewScanner(strings.NewReader(string(output)))
+    for scanner.Scan() {
+        // Prefix lines look like "PRE 2024-01-31/"
+        fields := strings.Fields(scanner.Text())
+        if len(fields) != 2 || fields[0] != "PRE" {
+            continue
+        }
+        day, err := time.Parse("2006-01-02", strings.TrimSuffix(fields[1], "/"))
+        if err != nil || !day.Before(cutoff) {
+            continue
+        }
+
+        log.Printf("🧹 Removing %s%s", base, fields[1])
+        cmd := outboundCommand("aws", cliArgs("s3", "rm", "--recursive", "--only-show-errors", base+fields[1])...)
+        if output, err := cmd.CombinedOutput(); err != nil {
+            log.Printf("❌ Failed to remove %s%s: %v: %s", base, fields[1], err, strings.TrimSpace(string(output)))
+        }
+    }
+}
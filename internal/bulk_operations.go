@@ -0,0 +1,217 @@
+// internal/bulk_operations.go - Release, reprovision or delete everything of an event or course by label selector
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/labels"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// Bulk actions, applied to VMProvisioningRequests, TrainingVMs and cloud instances
+// matching a label selector such as "event-id=kubecon-2025" (see PASSTHROUGH_LABELS)
+const (
+    BulkRelease           = "release"            // Give back every VM, requests are kept as released
+    BulkReprovisionFailed = "reprovision-failed" // Send failed requests back through allocation
+    BulkDelete            = "delete"             // Tear down and delete all three kinds of resource
+)
+
+type BulkResult struct {
+    Action   string   `json:"action"`
+    Selector string   `json:"selector"`
+    DryRun   bool     `json:"dryRun"`
+    Affected []string `json:"affected"` // <kind>/<name>
+    Errors   []string `json:"errors,omitempty"`
+}
+
+func (result *BulkResult) record(kind, name string, err error) {
+    if err != nil {
+        result.Errors = append(result.Errors, fmt.Sprintf("%s/%s: %v", kind, name, err))
+        return
+    }
+    result.Affected = append(result.Affected, kind+"/"+name)
+}
+
+// Run a bulk action. An empty selector is refused so a typo can't release every VM
+// in the cluster; with dryRun the matching resources are listed but left untouched.
+func RunBulkAction(client dynamic.Interface, action, selector string, dryRun bool) (*BulkResult, error) {
+    if selector == "" {
+        return nil, fmt.Errorf("a label selector is required")
+    }
+    if _, err := labels.Parse(selector); err != nil {
+        return nil, fmt.Errorf("invalid label selector %q: %v", selector, err)
+    }
+
+    result := &BulkResult{Action: action, Selector: selector, DryRun: dryRun, Affected: []string{}}
+    dc := NewDeprovisionController(client)
+
+    log.Printf("📦 Bulk %s of resources matching %s (dry run: %v)", action, selector, dryRun)
+
+    switch action {
+    case BulkRelease:
+        bulkReleaseRequests(dc, selector, dryRun, result)
+        bulkDeleteTrainingVMs(dc, selector, dryRun, result)
+    case BulkReprovisionFailed:
+        bulkReprovisionFailedRequests(client, selector, dryRun, result)
+    case BulkDelete:
+        bulkDeleteRequests(dc, selector, dryRun, result)
+        bulkDeleteTrainingVMs(dc, selector, dryRun, result)
+        bulkDeleteCloudInstances(client, selector, dryRun, result)
+    default:
+        return nil, fmt.Errorf("unknown bulk action %q, expected %s, %s or %s", action, BulkRelease, BulkReprovisionFailed, BulkDelete)
+    }
+
+    log.Printf("✅ Bulk %s of %s: %d affected, %d errors", action, selector, len(result.Affected), len(result.Errors))
+    return result, nil
+}
+
+// Kinds as they appear in BulkResult
+var bulkKinds = map[string]schema.GroupVersionResource{
+    "vmprovisioningrequest": vmProvisioningRequestGVR,
+    "trainingvm":            trainingVMGVR,
+    "ec2trainingvm":         ec2TrainingVMGVR,
+}
+
+func listBySelector(client dynamic.Interface, kind, selector string, result *BulkResult) []unstructured.Unstructured {
+    items, err := client.Resource(bulkKinds[kind]).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: selector,
+    })
+    if err != nil {
+        // TrainingVM and EC2TrainingVM CRDs are absent in kratix-only installs
+        if !errors.IsNotFound(err) {
+            result.Errors = append(result.Errors, fmt.Sprintf("list %s: %v", kind, err))
+        }
+        return nil
+    }
+    return items.Items
+}
+
+// The session whose workspace and Secrets belong to the request; requests created
+// outside the integration are named after their session
+func bulkSessionName(request *unstructured.Unstructured) string {
+    if sessionName := GetHobbyFarmSessionFromRequest(request); sessionName != "" {
+        return sessionName
+    }
+    return request.GetName()
+}
+
+func bulkReleaseRequests(dc *DeprovisionController, selector string, dryRun bool, result *BulkResult) {
+    for _, request := range listBySelector(dc.client, "vmprovisioningrequest", selector, result) {
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state == provisioner.StateReleased {
+            continue
+        }
+        if dryRun {
+            result.record("vmprovisioningrequest", request.GetName(), nil)
+            continue
+        }
+        dc.teardownRequestVM(&request, bulkSessionName(&request))
+        result.record("vmprovisioningrequest", request.GetName(), dc.releaseRequest(request.GetName(), provisioner.ReleaseBulk))
+    }
+}
+
+func bulkReprovisionFailedRequests(client dynamic.Interface, selector string, dryRun bool, result *BulkResult) {
+    for _, request := range listBySelector(client, "vmprovisioningrequest", selector, result) {
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state != "failed" {
+            continue
+        }
+        requestName := request.GetName()
+        if dryRun {
+            result.record("vmprovisioningrequest", requestName, nil)
+            continue
+        }
+
+        result.record("vmprovisioningrequest", requestName, redriveFailedRequest(client, &request))
+    }
+}
+
+func bulkDeleteRequests(dc *DeprovisionController, selector string, dryRun bool, result *BulkResult) {
+    for _, request := range listBySelector(dc.client, "vmprovisioningrequest", selector, result) {
+        requestName := request.GetName()
+        if dryRun {
+            result.record("vmprovisioningrequest", requestName, nil)
+            continue
+        }
+        dc.teardownRequestVM(&request, bulkSessionName(&request))
+        err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Delete(
+            context.TODO(), requestName, metav1.DeleteOptions{})
+        if errors.IsNotFound(err) {
+            err = nil
+        }
+        result.record("vmprovisioningrequest", requestName, err)
+    }
+}
+
+// Releasing a TrainingVM deletes it, so release and delete are the same for direct mode
+func bulkDeleteTrainingVMs(dc *DeprovisionController, selector string, dryRun bool, result *BulkResult) {
+    for _, tvm := range listBySelector(dc.client, "trainingvm", selector, result) {
+        name := tvm.GetName()
+        if dryRun {
+            result.record("trainingvm", name, nil)
+            continue
+        }
+        sessionName := objectSession(&tvm)
+        if sessionName == "" {
+            sessionName = name
+        }
+        dc.teardownTrainingVM(&tvm, sessionName)
+        err := dc.client.Resource(trainingVMGVR).Namespace("default").Delete(
+            context.TODO(), name, metav1.DeleteOptions{})
+        if errors.IsNotFound(err) {
+            err = nil
+        }
+        result.record("trainingvm", name, err)
+    }
+}
+
+// Cloud instances left behind once their requests and TrainingVMs are gone
+func bulkDeleteCloudInstances(client dynamic.Interface, selector string, dryRun bool, result *BulkResult) {
+    for _, instance := range listBySelector(client, "ec2trainingvm", selector, result) {
+        name := instance.GetName()
+        if instance.GetDeletionTimestamp() != nil {
+            continue
+        }
+        if dryRun {
+            result.record("ec2trainingvm", name, nil)
+            continue
+        }
+        err := client.Resource(ec2TrainingVMGVR).Namespace("default").Delete(
+            context.TODO(), name, metav1.DeleteOptions{})
+        if errors.IsNotFound(err) {
+            err = nil
+        }
+        result.record("ec2trainingvm", name, err)
+    }
+}
+
+// POST /bulk?action=<release|reprovision-failed|delete>&selector=<labels>[&dryRun=true], on the admin listener
+func (ws *WebhookServer) bulkHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := r.URL.Query()
+    result, err := RunBulkAction(ws.client, query.Get("action"), query.Get("selector"), query.Get("dryRun") == "true")
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusBadRequest)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if len(result.Errors) > 0 {
+        w.WriteHeader(http.StatusMultiStatus)
+    }
+    json.NewEncoder(w).Encode(result)
+}
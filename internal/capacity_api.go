@@ -0,0 +1,160 @@
+// internal/capacity_api.go - Seats available now per environment, for the HobbyFarm UI or a fronting portal
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "net/http"
+    "sort"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Origin of the UI allowed to call /capacity from a browser, e.g. https://learn.example.com
+func getCapacityAPIAllowedOrigin() string {
+    return Setting("CAPACITY_API_ALLOWED_ORIGIN")
+}
+
+// Capacity of one environment. Available is what a new session can get right now:
+// free static VMs plus cloud headroom, less the requests already queued for them.
+type environmentCapacity struct {
+    Environment  string `json:"environment"`
+    Scenario     string `json:"scenario,omitempty"`
+    StaticTotal  int    `json:"staticTotal"`
+    StaticFree   int    `json:"staticFree"`
+    Queued       int    `json:"queued"`
+    CloudEnabled bool   `json:"cloudEnabled"`
+    // Cloud instances that may still be launched; absent when no quota caps them
+    CloudHeadroom *int `json:"cloudHeadroom,omitempty"`
+    Available     int  `json:"available"`
+    // Cloud fallback without a quota: sessions always get a seat, Available counts only static VMs
+    Unlimited bool `json:"unlimited"`
+}
+
+// Capacity of an environment from the allocated IPs, drained VMs and queued requests
+func environmentCapacityOf(client dynamic.Interface, env vmEnvironment, usedIPs map[string]bool, maintenance map[string]string, queued int) environmentCapacity {
+    capacity := environmentCapacity{Environment: env.Name, Queued: queued}
+    for _, ip := range env.StaticVMs {
+        if _, drained := maintenance[ip]; drained {
+            continue
+        }
+        capacity.StaticTotal++
+        if !usedIPs[ip] {
+            capacity.StaticFree++
+        }
+    }
+
+    capacity.CloudEnabled = Setting("ENABLE_EC2_FALLBACK") != "false" && env.cloudFallbackAllowed()
+    t, tenanted := getTenant(environmentTenant(env.Name))
+    headroom := -1 // unlimited
+    if capacity.CloudEnabled && tenanted && t.MaxCloudInstances > 0 {
+        if instances, err := tenantCloudInstances(client, t.Name); err == nil {
+            headroom = max(t.MaxCloudInstances-instances, 0)
+        } else {
+            headroom = 0
+        }
+    }
+    if capacity.CloudEnabled && headroom >= 0 {
+        capacity.CloudHeadroom = &headroom
+    }
+    capacity.Unlimited = capacity.CloudEnabled && headroom < 0
+
+    capacity.Available = capacity.StaticFree
+    if capacity.CloudHeadroom != nil {
+        capacity.Available += *capacity.CloudHeadroom
+    }
+    capacity.Available = max(capacity.Available-queued, 0)
+
+    // The tenant's VM quota caps static and cloud together
+    if tenanted && t.MaxVMs > 0 {
+        if held, err := tenantHeldVMs(client, t.Name); err == nil {
+            capacity.Available = min(capacity.Available, max(t.MaxVMs-held, 0))
+            capacity.Unlimited = false
+        }
+    }
+    return capacity
+}
+
+// Capacity of every configured environment, sorted by name
+func collectCapacity(client dynamic.Interface) ([]environmentCapacity, error) {
+    usedIPs, err := collectAllocatedIPs(client)
+    if err != nil {
+        return nil, err
+    }
+    maintenance := getMaintenanceVMs(client)
+
+    queued := map[string]int{}
+    if requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{}); err == nil {
+        for i := range requests.Items {
+            request := &requests.Items[i]
+            state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+            vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            if (state == "" || state == "pending") && vmIP == "" {
+                queued[getObjectEnvironment(request)]++
+            }
+        }
+    }
+
+    environments := loadVMEnvironments()
+    names := make([]string, 0, len(environments))
+    for name := range environments {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    capacities := make([]environmentCapacity, 0, len(names))
+    for _, name := range names {
+        capacities = append(capacities, environmentCapacityOf(client, environments[name], usedIPs, maintenance, queued[name]))
+    }
+    return capacities, nil
+}
+
+// Environment a new session of scenario would be provisioned in
+func scenarioCapacityEnvironment(client dynamic.Interface, scenario string) string {
+    if name := scenarioEnvironment(client, scenario); name != "" {
+        return name
+    }
+    return tenantDefaultEnvironment(scenarioTenant(client, scenario))
+}
+
+// GET /capacity lists every environment, ?environment=<name> or ?scenario=<name>
+// returns the one a new session would use, for "X seats available now"
+func (ws *WebhookServer) capacityHandler(w http.ResponseWriter, r *http.Request) {
+    if origin := getCapacityAPIAllowedOrigin(); origin != "" {
+        w.Header().Set("Access-Control-Allow-Origin", origin)
+        w.Header().Set("Vary", "Origin")
+    }
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    capacities, err := collectCapacity(ws.client)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    query := r.URL.Query()
+    scenario := query.Get("scenario")
+    environment := query.Get("environment")
+    if scenario != "" && environment == "" {
+        environment = scenarioCapacityEnvironment(ws.client, scenario)
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if environment == "" {
+        json.NewEncoder(w).Encode(capacities)
+        return
+    }
+    for _, capacity := range capacities {
+        if capacity.Environment == environment {
+            capacity.Scenario = scenario
+            json.NewEncoder(w).Encode(capacity)
+            return
+        }
+    }
+    http.Error(w, "unknown environment "+environment, http.StatusNotFound)
+}
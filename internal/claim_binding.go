@@ -0,0 +1,277 @@
+// internal/claim_binding.go - Trace redirected VirtualMachineClaims to their VMs and bind them back
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Claim metadata set by the webhook and the binding controller
+const (
+    claimRedirectedLabel         = "hobbyfarm.io/redirected"
+    claimProvisionerRequestAnnot = "hobbyfarm.io/provisioner-request"
+    claimBoundVMAnnotation       = "hobbyfarm.io/bound-vm"
+    claimBoundAtAnnotation       = "hobbyfarm.io/bound-at"
+    // Recorded on every resource derived from a redirected claim
+    claimUIDAnnotation = "hobbyfarm.io/claim-uid"
+)
+
+type ClaimBindingController struct {
+    client dynamic.Interface
+}
+
+func NewClaimBindingController(client dynamic.Interface) *ClaimBindingController {
+    return &ClaimBindingController{client: client}
+}
+
+// The claim UID only exists once the API server has persisted the claim, after
+// admission, so tracing and binding run as a loop rather than in the webhook
+func (cbc *ClaimBindingController) WatchRedirectedClaims() {
+    log.Println("🔗 Starting VirtualMachineClaim binding controller...")
+
+    for {
+        if clusterReachable() {
+            cbc.reconcileRedirectedClaims()
+        }
+        Heartbeat(cbc.client, HeartbeatClaimBinding)
+        time.Sleep(10 * time.Second)
+    }
+}
+
+func (cbc *ClaimBindingController) reconcileRedirectedClaims() {
+    claims, err := cbc.client.Resource(virtualMachineClaimGVR).Namespace("").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: claimRedirectedLabel + "=true",
+    })
+    if err != nil {
+        log.Printf("⚠️ Could not list redirected VirtualMachineClaims: %v", err)
+        recordLastError(HeartbeatClaimBinding, "listing VirtualMachineClaims: %v", err)
+        return
+    }
+
+    for i := range claims.Items {
+        claim := &claims.Items[i]
+        if claim.GetAnnotations()[claimBoundVMAnnotation] != "" {
+            continue
+        }
+        if err := cbc.reconcileClaim(claim); err != nil {
+            log.Printf("⚠️ Failed to reconcile VirtualMachineClaim %s/%s: %v", claim.GetNamespace(), claim.GetName(), err)
+        }
+    }
+}
+
+// Stamp the claim UID on the claim's derived resources and bind the claim once its VM is ready
+func (cbc *ClaimBindingController) reconcileClaim(claim *unstructured.Unstructured) error {
+    claimUID := string(claim.GetUID())
+    session := claim.GetLabels()["hobbyfarm.io/session"]
+    if session == "" {
+        session = claim.GetAnnotations()["hobbyfarm.io/session"]
+    }
+    if session == "" {
+        return fmt.Errorf("claim has no session")
+    }
+
+    if vmRequestName := claim.GetAnnotations()[claimProvisionerRequestAnnot]; vmRequestName != "" {
+        cbc.stampClaimUID(webhookVMRequestGVR, claim.GetNamespace(), vmRequestName, claimUID)
+    }
+
+    vmIP, hostname := cbc.traceKratixRequest(session, claimUID)
+    if vmIP == "" {
+        vmIP = cbc.traceTrainingVMs(session, claimUID)
+    }
+    if vmIP == "" {
+        return nil // Not ready yet
+    }
+
+    vm, err := cbc.findHobbyFarmVM(claim, vmIP, hostname)
+    if err != nil || vm == nil {
+        return err
+    }
+    cbc.stampClaimUID(virtualMachineGVR, vm.GetNamespace(), vm.GetName(), claimUID)
+
+    return cbc.bindClaim(claim, vm)
+}
+
+// Stamp the Kratix request, its cloud instance and DNS record; returns the address once ready
+func (cbc *ClaimBindingController) traceKratixRequest(session, claimUID string) (string, string) {
+    request, err := findRequestForSession(cbc.client, session)
+    if err != nil {
+        return "", ""
+    }
+    cbc.stampClaimUID(vmProvisioningRequestGVR, "default", request.GetName(), claimUID)
+
+    if instance, err := findCloudInstanceForRequest(cbc.client, request.GetName()); err == nil && instance != nil {
+        cbc.stampClaimUID(ec2TrainingVMGVR, "default", instance.GetName(), claimUID)
+    }
+    cbc.stampClaimUID(dnsEndpointGVR, "default", request.GetName()+"-vm-dns", claimUID)
+
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    if state != "ready" {
+        return "", ""
+    }
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    hostname, _, _ := unstructured.NestedString(request.Object, "status", "hostname")
+    return vmIP, hostname
+}
+
+// Stamp TrainingVMs (direct mode) and their EC2 fallbacks; returns the address once provisioned
+func (cbc *ClaimBindingController) traceTrainingVMs(session, claimUID string) string {
+    trainingVMs, err := cbc.client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: sessionLabelSelector(session),
+    })
+    if err != nil {
+        return ""
+    }
+
+    readyIP := ""
+    for _, trainingVM := range trainingVMs.Items {
+        cbc.stampClaimUID(trainingVMGVR, "default", trainingVM.GetName(), claimUID)
+        cbc.stampClaimUID(ec2TrainingVMGVR, "default", ec2InstanceName(trainingVM.GetName()), claimUID)
+
+        provisioned, _, _ := unstructured.NestedBool(trainingVM.Object, "status", "provisioned")
+        vmIP, _, _ := unstructured.NestedString(trainingVM.Object, "status", "vmIP")
+        if provisioned && vmIP != "" && readyIP == "" {
+            readyIP = vmIP
+        }
+    }
+    return readyIP
+}
+
+// Record the claim UID on a derived resource, skipping ones that are missing or already stamped
+func (cbc *ClaimBindingController) stampClaimUID(gvr schema.GroupVersionResource, namespace, name, claimUID string) {
+    object, err := cbc.client.Resource(gvr).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+    if err != nil || object.GetAnnotations()[claimUIDAnnotation] == claimUID {
+        return
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{
+                claimUIDAnnotation: claimUID,
+            },
+        },
+    })
+    if _, err := cbc.client.Resource(gvr).Namespace(namespace).Patch(
+        context.TODO(), name, types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+        log.Printf("⚠️ Failed to record claim UID on %s %s: %v", gvr.Resource, name, err)
+    }
+}
+
+// The HobbyFarm VirtualMachine the integration pointed at this VM, by address or else by user
+func (cbc *ClaimBindingController) findHobbyFarmVM(claim *unstructured.Unstructured, vmIP, hostname string) (*unstructured.Unstructured, error) {
+    vms, err := cbc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return nil, err
+    }
+
+    claimUser, _, _ := unstructured.NestedString(claim.Object, "spec", "user_id")
+    var userMatch *unstructured.Unstructured
+    for i := range vms.Items {
+        vm := &vms.Items[i]
+        status, _, _ := unstructured.NestedString(vm.Object, "status", "status")
+        if status != "ready" {
+            continue
+        }
+        publicIP, _, _ := unstructured.NestedString(vm.Object, "status", "public_ip")
+        vmHostname, _, _ := unstructured.NestedString(vm.Object, "status", "hostname")
+        if publicIP == vmIP || (hostname != "" && vmHostname == hostname) {
+            return vm, nil
+        }
+        if vmUser, _, _ := unstructured.NestedString(vm.Object, "spec", "user"); claimUser != "" && vmUser == claimUser && userMatch == nil {
+            userMatch = vm
+        }
+    }
+    return userMatch, nil
+}
+
+// Write the VM into the claim's spec.vm entries and mark the claim bound and ready
+func (cbc *ClaimBindingController) bindClaim(claim, vm *unstructured.Unstructured) error {
+    vmName := vm.GetName()
+
+    claimVMs, _, _ := unstructured.NestedMap(claim.Object, "spec", "vm")
+    vmPatch := map[string]interface{}{}
+    for key, value := range claimVMs {
+        entry, _ := value.(map[string]interface{})
+        if vmID, _ := entry["vm_id"].(string); vmID == "" {
+            vmPatch[key] = map[string]interface{}{"vm_id": vmName}
+            break // One provisioned VM per session
+        }
+    }
+
+    patch := map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{
+                claimBoundVMAnnotation: vmName,
+                claimBoundAtAnnotation: time.Now().Format(time.RFC3339),
+            },
+        },
+    }
+    if len(vmPatch) > 0 {
+        patch["spec"] = map[string]interface{}{"vm": vmPatch}
+    }
+    patchBytes, _ := json.Marshal(patch)
+    if _, err := cbc.client.Resource(virtualMachineClaimGVR).Namespace(claim.GetNamespace()).Patch(
+        context.TODO(), claim.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+        return fmt.Errorf("failed to bind VM %s: %v", vmName, err)
+    }
+
+    statusBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "bound": true,
+            "ready": true,
+        },
+    })
+    if err := patchStatus(cbc.client, virtualMachineClaimGVR, claim.GetNamespace(), claim.GetName(), statusBytes); err != nil {
+        return fmt.Errorf("failed to mark claim ready: %v", err)
+    }
+
+    // Point the VM back at its claim the way HobbyFarm's own binding does
+    vmClaimBytes, _ := json.Marshal(map[string]interface{}{
+        "spec": map[string]interface{}{
+            "vm_claim_id": claim.GetName(),
+        },
+    })
+    if _, err := cbc.client.Resource(virtualMachineGVR).Namespace(vm.GetNamespace()).Patch(
+        context.TODO(), vmName, types.MergePatchType, vmClaimBytes, metav1.PatchOptions{}); err != nil {
+        log.Printf("⚠️ Failed to link VirtualMachine %s to claim %s: %v", vmName, claim.GetName(), err)
+    }
+
+    log.Printf("🔗 Bound VirtualMachineClaim %s/%s to VirtualMachine %s", claim.GetNamespace(), claim.GetName(), vmName)
+    return nil
+}
+
+// JSON patch admitting a redirected claim with the label and annotation the binding
+// controller looks for; "/" in keys is escaped as "~1" per RFC 6901
+func claimRedirectPatch(claim *unstructured.Unstructured, vmRequestName string) ([]byte, error) {
+    escape := func(key string) string {
+        return strings.ReplaceAll(strings.ReplaceAll(key, "~", "~0"), "/", "~1")
+    }
+
+    var operations []map[string]interface{}
+    if claim.GetLabels() == nil {
+        operations = append(operations, map[string]interface{}{
+            "op": "add", "path": "/metadata/labels", "value": map[string]string{},
+        })
+    }
+    operations = append(operations, map[string]interface{}{
+        "op": "add", "path": "/metadata/labels/" + escape(claimRedirectedLabel), "value": "true",
+    })
+    if claim.GetAnnotations() == nil {
+        operations = append(operations, map[string]interface{}{
+            "op": "add", "path": "/metadata/annotations", "value": map[string]string{},
+        })
+    }
+    operations = append(operations, map[string]interface{}{
+        "op": "add", "path": "/metadata/annotations/" + escape(claimProvisionerRequestAnnot), "value": vmRequestName,
+    })
+    return json.Marshal(operations)
+}
@@ -0,0 +1,96 @@
+// internal/client_throttling.go - How often the Kubernetes client waits on its own rate limit or is throttled by the API server
+package internal
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "sync/atomic"
+    "time"
+
+    "k8s.io/client-go/util/flowcontrol"
+)
+
+// Waits shorter than this are the token bucket pacing a burst, not throttling
+const clientThrottleThreshold = 10 * time.Millisecond
+
+var clientThrottling struct {
+    requests        atomic.Int64
+    throttled       atomic.Int64
+    waitNanos       atomic.Int64
+    serverThrottled atomic.Int64
+}
+
+// Token bucket recording how long each request waited for a token
+type meteredRateLimiter struct {
+    flowcontrol.RateLimiter
+}
+
+func newMeteredRateLimiter(qps float32, burst int) flowcontrol.RateLimiter {
+    return meteredRateLimiter{flowcontrol.NewTokenBucketRateLimiter(qps, burst)}
+}
+
+func (limiter meteredRateLimiter) Accept() {
+    start := time.Now()
+    limiter.RateLimiter.Accept()
+    recordClientWait(time.Since(start))
+}
+
+func (limiter meteredRateLimiter) Wait(ctx context.Context) error {
+    start := time.Now()
+    err := limiter.RateLimiter.Wait(ctx)
+    recordClientWait(time.Since(start))
+    return err
+}
+
+func recordClientWait(wait time.Duration) {
+    clientThrottling.requests.Add(1)
+    if wait < clientThrottleThreshold {
+        return
+    }
+    clientThrottling.throttled.Add(1)
+    clientThrottling.waitNanos.Add(int64(wait))
+    if wait > time.Second {
+        log.Printf("🚦 Kubernetes client waited %v on its rate limit, consider raising KUBE_CLIENT_QPS", wait.Round(time.Millisecond))
+    }
+}
+
+// Count 429 answers, the API server's priority and fairness pushing back
+type serverThrottlingTransport struct {
+    next http.RoundTripper
+}
+
+func countServerThrottling(next http.RoundTripper) http.RoundTripper {
+    return serverThrottlingTransport{next: next}
+}
+
+func (t serverThrottlingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+    resp, err := t.next.RoundTrip(req)
+    if err == nil && resp.StatusCode == http.StatusTooManyRequests {
+        clientThrottling.serverThrottled.Add(1)
+    }
+    return resp, err
+}
+
+func writeClientThrottlingMetrics(w io.Writer) {
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_client_qps Requests per second the Kubernetes client is limited to")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_client_qps gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_client_qps %g\n", getKubeClientQPS())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_client_burst Requests the Kubernetes client may send above its QPS in a burst")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_client_burst gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_client_burst %d\n", getKubeClientBurst())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_client_requests_total Requests sent through the client rate limiter")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_client_requests_total counter")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_client_requests_total %d\n", clientThrottling.requests.Load())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_client_throttled_total Requests delayed by the client rate limiter")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_client_throttled_total counter")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_client_throttled_total %d\n", clientThrottling.throttled.Load())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_client_throttle_seconds_total Time requests spent waiting on the client rate limiter")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_client_throttle_seconds_total counter")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_client_throttle_seconds_total %g\n", time.Duration(clientThrottling.waitNanos.Load()).Seconds())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_kube_api_throttled_total 429 responses from the API server")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_kube_api_throttled_total counter")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_kube_api_throttled_total %d\n", clientThrottling.serverThrottled.Load())
+}
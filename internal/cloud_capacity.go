@@ -0,0 +1,294 @@
+// internal/cloud_capacity.go - Retry cloud instances in another subnet/AZ or instance type on capacity errors
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "regexp"
+    "strconv"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    // Crossplane managed EC2 instance composed for each EC2TrainingVM claim
+    ec2InstanceGVR = schema.GroupVersionResource{
+        Group:    "ec2.aws.upbound.io",
+        Version:  "v1beta1",
+        Resource: "instances",
+    }
+)
+
+// Claim annotations tracking which placement candidate is in use and why it was chosen
+const (
+    placementAttemptAnnotation      = "hobbyfarm.io/placement-attempt"
+    placementReasonAnnotation       = "hobbyfarm.io/placement-reason"
+    placementChangedAtAnnotation    = "hobbyfarm.io/placement-changed-at"
+    requestedInstanceTypeAnnotation = "hobbyfarm.io/requested-instance-type"
+)
+
+// Time Crossplane gets to retry with a new placement before its conditions are trusted again
+const placementSettleTime = time.Minute
+
+// AWS error codes meaning "not here, not now" rather than a broken request
+var cloudCapacityErrorCodes = []string{
+    "InsufficientInstanceCapacity",
+    "InstanceLimitExceeded",
+    "VcpuLimitExceeded",
+    "InsufficientFreeAddressesInSubnet",
+    "Unsupported",
+}
+
+// Where and as what a cloud instance is created; empty fields keep the composition default
+type cloudPlacement struct {
+    SubnetID     string
+    InstanceType string
+}
+
+// Split a comma separated list, dropping blanks
+func splitList(value string) []string {
+    var values []string
+    for _, item := range strings.Split(value, ",") {
+        if trimmed := strings.TrimSpace(item); trimmed != "" {
+            values = append(values, trimmed)
+        }
+    }
+    return values
+}
+
+func splitEnvList(name string) []string {
+    return splitList(Setting(name))
+}
+
+// Subnets (one per AZ) tried in order, from CLOUD_FALLBACK_SUBNETS
+func getFallbackSubnets() []string {
+    return splitEnvList("CLOUD_FALLBACK_SUBNETS")
+}
+
+// Instance types tried after the requested one, from CLOUD_FALLBACK_INSTANCE_TYPES
+func getFallbackInstanceTypes() []string {
+    return splitEnvList("CLOUD_FALLBACK_INSTANCE_TYPES")
+}
+
+// Candidate placements: the requested type in every subnet first, then each fallback type
+func buildCloudPlacements(instanceType string) []cloudPlacement {
+    subnets := getFallbackSubnets()
+    if len(subnets) == 0 {
+        subnets = []string{""}
+    }
+
+    instanceTypes := []string{instanceType}
+    for _, fallback := range getFallbackInstanceTypes() {
+        if fallback != instanceType {
+            instanceTypes = append(instanceTypes, fallback)
+        }
+    }
+
+    var placements []cloudPlacement
+    for _, candidateType := range instanceTypes {
+        for _, subnet := range subnets {
+            placements = append(placements, cloudPlacement{SubnetID: subnet, InstanceType: candidateType})
+        }
+    }
+    return placements
+}
+
+// "api error InsufficientInstanceCapacity: ..." as the AWS SDK v2 words it
+var awsAPIErrorPattern = regexp.MustCompile(`api error ([A-Za-z][\w.]*):`)
+
+// "InsufficientInstanceCapacity: ..." anywhere in older or wrapped messages
+var awsErrorCodePattern = regexp.MustCompile(`\b([A-Z][\w.]*):\s`)
+
+// AWS error codes in a condition message: the SDK's api error when there is
+// one, else every word in code position
+func awsErrorCodes(message string) []string {
+    if match := awsAPIErrorPattern.FindStringSubmatch(message); match != nil {
+        return []string{match[1]}
+    }
+    var codes []string
+    for _, match := range awsErrorCodePattern.FindAllStringSubmatch(message, -1) {
+        codes = append(codes, match[1])
+    }
+    return codes
+}
+
+// Whether the message carries one of the capacity codes, compared exactly so
+// e.g. UnsupportedOperation is not taken for Unsupported
+func isCloudCapacityError(message string) bool {
+    for _, found := range awsErrorCodes(message) {
+        for _, code := range cloudCapacityErrorCodes {
+            if found == code {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// Return the first capacity/quota error in the given conditions
+func findCapacityErrorCondition(object *unstructured.Unstructured) string {
+    conditions, _, _ := unstructured.NestedSlice(object.Object, "status", "conditions")
+    for _, c := range conditions {
+        condition, ok := c.(map[string]interface{})
+        if !ok || condition["status"] != "False" {
+            continue
+        }
+        if message, _ := condition["message"].(string); isCloudCapacityError(message) {
+            return message
+        }
+    }
+    return ""
+}
+
+// Creation errors land on the composed managed Instance, so check it as well as the claim
+func getCloudCapacityError(client dynamic.Interface, claim *unstructured.Unstructured) string {
+    if message := findCapacityErrorCondition(claim); message != "" {
+        return message
+    }
+
+    instances, err := client.Resource(ec2InstanceGVR).List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("crossplane.io/claim-name=%s,crossplane.io/claim-namespace=%s", claim.GetName(), claim.GetNamespace()),
+    })
+    if err != nil {
+        return ""
+    }
+    for i := range instances.Items {
+        if message := findCapacityErrorCondition(&instances.Items[i]); message != "" {
+            return message
+        }
+    }
+    return ""
+}
+
+// Set the first placement on a new claim so later attempts can be counted from it
+func applyInitialPlacement(claim *unstructured.Unstructured, instanceType string) {
+    placement := buildCloudPlacements(instanceType)[0]
+    if placement.SubnetID != "" {
+        unstructured.SetNestedField(claim.Object, placement.SubnetID, "spec", "subnetId")
+    }
+
+    annotations := claim.GetAnnotations()
+    if annotations == nil {
+        annotations = map[string]string{}
+    }
+    annotations[placementAttemptAnnotation] = "0"
+    annotations[requestedInstanceTypeAnnotation] = instanceType
+    annotations[placementChangedAtAnnotation] = time.Now().Format(time.RFC3339)
+    claim.SetAnnotations(annotations)
+}
+
+func getRequestedInstanceType(claim *unstructured.Unstructured) string {
+    if requestedType := claim.GetAnnotations()[requestedInstanceTypeAnnotation]; requestedType != "" {
+        return requestedType
+    }
+    instanceType, _, _ := unstructured.NestedString(claim.Object, "spec", "instanceType")
+    return instanceType
+}
+
+// Conditions still describe the previous placement until Crossplane has retried
+func placementSettled(claim *unstructured.Unstructured) bool {
+    changedAt, err := time.Parse(time.RFC3339, claim.GetAnnotations()[placementChangedAtAnnotation])
+    return err != nil || time.Since(changedAt) > placementSettleTime
+}
+
+// Move a claim that hit a capacity/quota error to its next placement candidate.
+// Returns the new placement, or ok=false when every candidate has been tried.
+func advanceCloudPlacement(client dynamic.Interface, claim *unstructured.Unstructured, reason string) (cloudPlacement, bool, error) {
+    annotations := claim.GetAnnotations()
+    attempt, _ := strconv.Atoi(annotations[placementAttemptAnnotation])
+    requestedType := getRequestedInstanceType(claim)
+
+    placements := buildCloudPlacements(requestedType)
+    next := attempt + 1
+    if next >= len(placements) {
+        return cloudPlacement{}, false, nil
+    }
+
+    // Each retry is a new RunInstances call and spends a token like a fresh creation
+    if !allowCloudInstanceCreation() {
+        return cloudPlacement{}, true, errCloudRateLimited
+    }
+
+    placement := placements[next]
+    spec := map[string]interface{}{
+        "instanceType": placement.InstanceType,
+    }
+    if placement.SubnetID != "" {
+        spec["subnetId"] = placement.SubnetID
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{
+                placementAttemptAnnotation:      strconv.Itoa(next),
+                placementReasonAnnotation:       reason,
+                placementChangedAtAnnotation:    time.Now().Format(time.RFC3339),
+                requestedInstanceTypeAnnotation: requestedType,
+            },
+        },
+        "spec": spec,
+    })
+
+    _, err := client.Resource(ec2TrainingVMGVR).Namespace(claim.GetNamespace()).Patch(
+        context.TODO(), claim.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if err != nil {
+        return cloudPlacement{}, true, fmt.Errorf("failed to move %s to subnet %s/%s: %v", claim.GetName(), placement.SubnetID, placement.InstanceType, err)
+    }
+
+    log.Printf("🔀 Cloud instance %s hit a capacity error, retrying as %s in subnet %s (attempt %d/%d)",
+        claim.GetName(), placement.InstanceType, placement.SubnetID, next+1, len(placements))
+    return placement, true, nil
+}
+
+// Handle capacity/quota errors of a Kratix cloud instance, recording the substitution in
+// the request status or failing the request once all placements are exhausted
+func (kc *KratixController) handleCloudCapacityError(requestName string, claim *unstructured.Unstructured) {
+    if !placementSettled(claim) {
+        return
+    }
+
+    reason := getCloudCapacityError(kc.client, claim)
+    if reason == "" {
+        return
+    }
+
+    placement, ok, err := advanceCloudPlacement(kc.client, claim, reason)
+    if err == errCloudRateLimited {
+        return // Retried next cycle
+    }
+    if err != nil {
+        log.Printf("❌ %v", err)
+        return
+    }
+    if !ok {
+        log.Printf("❌ No placement left for cloud instance of %s: %s", requestName, reason)
+        if err := kc.client.Resource(ec2TrainingVMGVR).Namespace(claim.GetNamespace()).Delete(
+            context.TODO(), claim.GetName(), metav1.DeleteOptions{}); err != nil {
+            log.Printf("⚠️ Failed to delete unplaceable cloud instance %s: %v", claim.GetName(), err)
+        }
+        kc.failRequest(requestName, "", cloudFailureReason(reason), fmt.Sprintf("cloud capacity exhausted: %s", reason))
+        return
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "cloudPlacement": map[string]interface{}{
+                "instanceType":          placement.InstanceType,
+                "requestedInstanceType": getRequestedInstanceType(claim),
+                "subnetId":              placement.SubnetID,
+                "substitutionReason":    reason,
+            },
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record cloud placement for %s: %v", requestName, err)
+    }
+}
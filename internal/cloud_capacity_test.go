@@ -0,0 +1,24 @@
+// internal/cloud_capacity_test.go - Which AWS errors count as capacity errors
+package internal
+
+import "testing"
+
+func TestIsCloudCapacityError(t *testing.T) {
+    cases := []struct {
+        message string
+        want    bool
+    }{
+        {"create failed: operation error EC2: RunInstances, https response error StatusCode: 500, api error InsufficientInstanceCapacity: We currently do not have sufficient t3.large capacity", true},
+        {"cannot create Instance: VcpuLimitExceeded: You have requested more vCPU capacity than your current vCPU limit", true},
+        {"Unsupported: Your requested instance type (t4g.large) is not supported in your requested Availability Zone", true},
+        {"create failed: operation error EC2: RunInstances, api error UnsupportedOperation: The instance configuration is not supported", false},
+        {"create failed: operation error EC2: RunInstances, api error InvalidParameterValue: Unsupported: value in field", false},
+        {"cannot create Instance: InvalidAMIID.NotFound: The image id does not exist", false},
+        {"Synced: ReconcileSuccess", false},
+    }
+    for _, tc := range cases {
+        if got := isCloudCapacityError(tc.message); got != tc.want {
+            t.Errorf("isCloudCapacityError(%q) = %v, want %v (codes %q)", tc.message, got, tc.want, awsErrorCodes(tc.message))
+        }
+    }
+}
@@ -0,0 +1,116 @@
+// internal/cloud_console.go - Console access details of cloud VMs, and their boot log when SSH never comes up
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Lines of console output attached to an SSH failure
+const consoleOutputLines = 50
+
+// CLOUD_CONSOLE_OUTPUT=true fetches the instance's console output when SSH never
+// becomes ready. Off by default: it needs ec2:GetConsoleOutput and the aws CLI.
+func consoleOutputEnabled() bool {
+    return Setting("CLOUD_CONSOLE_OUTPUT") == "true"
+}
+
+// The instance's page in the EC2 console, from which the serial console connects
+func cloudConsoleURL(region, instanceID string) string {
+    if region == "" || instanceID == "" {
+        return ""
+    }
+    return fmt.Sprintf("https://%s.console.aws.amazon.com/ec2/home?region=%s#InstanceDetails:instanceId=%s", region, region, instanceID)
+}
+
+// Instance ID, availability zone, region and console URL of an EC2TrainingVM, as
+// status fields for the request or TrainingVM it backs. Empty ones are left out.
+func cloudConsoleStatus(ec2vm *unstructured.Unstructured) map[string]interface{} {
+    instanceID, _, _ := unstructured.NestedString(ec2vm.Object, "status", "instanceId")
+    zone, _, _ := unstructured.NestedString(ec2vm.Object, "status", "availabilityZone")
+    region, _, _ := unstructured.NestedString(ec2vm.Object, "spec", "region")
+    if region == "" && len(zone) > 1 {
+        // us-east-1a is in us-east-1
+        region = zone[:len(zone)-1]
+    }
+
+    status := map[string]interface{}{}
+    for field, value := range map[string]string{
+        "instanceId":       instanceID,
+        "availabilityZone": zone,
+        "region":           region,
+        "consoleURL":       cloudConsoleURL(region, instanceID),
+    } {
+        if value != "" {
+            status[field] = value
+        }
+    }
+    return status
+}
+
+// The last n lines of text, without trailing blank lines
+func lastLines(text string, n int) string {
+    lines := strings.Split(strings.TrimRight(text, "\n"), "\n")
+    if len(lines) > n {
+        lines = lines[len(lines)-n:]
+    }
+    return strings.Join(lines, "\n")
+}
+
+// Latest console output of an instance through the aws CLI, secrets redacted
+func (ar *AnsibleRunner) fetchConsoleOutput(region, instanceID string) (string, error) {
+    args := []string{"ec2", "get-console-output", "--instance-id", instanceID, "--latest", "--output", "text", "--query", "Output"}
+    if region != "" {
+        args = append(args, "--region", region)
+    }
+    output, err := ar.exec.CombinedOutput(outboundEnv(), "aws", args...)
+    if err != nil {
+        return "", fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    text := strings.TrimSpace(string(output))
+    if text == "" || text == "None" {
+        return "", fmt.Errorf("no console output yet for %s", instanceID)
+    }
+    return redactSecrets(lastLines(text, consoleOutputLines), nil, ar.sshKeyPath), nil
+}
+
+// After SSH never came up on a cloud VM, put the tail of its console output in the
+// request's Provisioned condition, where a kernel panic or cloud-init error shows
+func (kc *KratixController) attachConsoleOutput(requestName, sshError string) {
+    if !consoleOutputEnabled() {
+        return
+    }
+    request, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        return
+    }
+    instanceID, _, _ := unstructured.NestedString(request.Object, "status", "instanceId")
+    region, _, _ := unstructured.NestedString(request.Object, "status", "region")
+    if instanceID == "" {
+        return
+    }
+
+    output, err := kc.cloud.ConsoleOutput(region, instanceID)
+    if err != nil {
+        log.Printf("⚠️ Could not fetch console output of %s for %s: %v", instanceID, requestName, err)
+        return
+    }
+    message := fmt.Sprintf("SSH not ready: %s\n\nLast %d lines of console output of %s:\n%s", sshError, consoleOutputLines, instanceID, output)
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    conditions = mergeCondition(conditions, conditionProvisioned, false, "SSHNotReady", message)
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "conditions": conditions,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to attach console output to %s: %v", requestName, err)
+    }
+}
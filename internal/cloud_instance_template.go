@@ -0,0 +1,134 @@
+// internal/cloud_instance_template.go - Single builder for the EC2TrainingVMs of both fallback paths
+package internal
+
+import (
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Instance settings shared by every cloud VM, so TrainingVM and Kratix fallbacks
+// produce identical machines
+type cloudInstanceTemplate struct {
+    Region           string
+    InstanceType     string
+    AMI              string
+    KeyName          string
+    SecurityGroupIDs []string
+}
+
+// Per-VM values layered on the template; empty overrides keep the template value
+type cloudInstanceSpec struct {
+    Name         string
+    User         string
+    Session      string
+    Scenario     string
+    InstanceType string
+    Region       string
+    Labels       map[string]string
+    Storage      cloudStorage
+    // Crossplane ProviderConfig to launch with, empty for the composition's default
+    ProviderConfig string
+    // Object whose passthrough labels/annotations are copied for billing
+    Source *unstructured.Unstructured
+}
+
+func getCloudRegion() string {
+    if region := Setting("EC2_REGION"); region != "" {
+        return region
+    }
+    return "us-east-1"
+}
+
+// Template from EC2_* settings, defaulting to the values baked into the composition
+func loadCloudInstanceTemplate() cloudInstanceTemplate {
+    template := cloudInstanceTemplate{
+        Region:           getCloudRegion(),
+        InstanceType:     Setting("EC2_INSTANCE_TYPE"),
+        AMI:              Setting("EC2_AMI"),
+        KeyName:          getCloudKeyPairName(),
+        SecurityGroupIDs: splitEnvList("EC2_SECURITY_GROUP_IDS"),
+    }
+    if template.InstanceType == "" {
+        template.InstanceType = "t3.micro"
+    }
+    if template.AMI == "" {
+        template.AMI = "ami-0c02fb55956c7d316" // Ubuntu 20.04 LTS
+    }
+    if len(template.SecurityGroupIDs) == 0 {
+        template.SecurityGroupIDs = []string{"sg-0bfde988b4d5f8110"}
+    }
+    return template
+}
+
+// Build the EC2TrainingVM claim for a cloud VM from the shared template
+func buildCloudInstance(spec cloudInstanceSpec) *unstructured.Unstructured {
+    template := loadCloudInstanceTemplate()
+
+    instanceType := template.InstanceType
+    if spec.InstanceType != "" {
+        instanceType = spec.InstanceType
+    }
+    region := template.Region
+    if spec.Region != "" {
+        region = spec.Region
+    }
+
+    labels := map[string]interface{}{
+        "session": safeLabelValue(spec.Session),
+    }
+    for key, value := range spec.Labels {
+        labels[key] = value
+    }
+
+    securityGroupIDs := make([]interface{}, len(template.SecurityGroupIDs))
+    for i, id := range template.SecurityGroupIDs {
+        securityGroupIDs[i] = id
+    }
+
+    instance := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "training.example.com/v1",
+            "kind":       "EC2TrainingVM",
+            "metadata": map[string]interface{}{
+                "name":      spec.Name,
+                "namespace": "default",
+                "labels":    labels,
+            },
+            "spec": map[string]interface{}{
+                "user":             spec.User,
+                "session":          spec.Session,
+                "instanceType":     instanceType,
+                "region":           region,
+                "ami":              template.AMI,
+                "keyName":          template.KeyName,
+                "securityGroupIds": securityGroupIDs,
+            },
+        },
+    }
+
+    // Tagged on the instance next to its session and user
+    unstructured.SetNestedField(instance.Object, provisionerVersion, "spec", "provisionerVersion")
+    stampProvenance(instance)
+    if spec.Scenario != "" {
+        unstructured.SetNestedField(instance.Object, spec.Scenario, "spec", "scenario")
+    }
+    if spec.ProviderConfig != "" {
+        unstructured.SetNestedField(instance.Object, spec.ProviderConfig, "spec", "providerConfigName")
+    }
+
+    // Root volume size and extra EBS disks requested by the scenario
+    applyCloudStorage(instance, spec.Storage)
+
+    // Passthrough metadata goes on the claim and, tagged by the Composition, on the EC2 instance
+    if spec.Source != nil {
+        applyPassthroughMetadata(instance, spec.Source)
+        if tags := passthroughTags(spec.Source); len(tags) > 0 {
+            unstructured.SetNestedMap(instance.Object, tags, "spec", "tags")
+        }
+    }
+
+    // Start at the first subnet/instance type candidate, later ones are used on capacity errors
+    applyInitialPlacement(instance, instanceType)
+
+    return instance
+}
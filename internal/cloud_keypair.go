@@ -0,0 +1,240 @@
+// internal/cloud_keypair.go - Import the managed SSH key as the EC2 keypair used by cloud VMs
+package internal
+
+import (
+    "context"
+    "crypto/md5"
+    "crypto/sha256"
+    "encoding/base64"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    // Crossplane managed EC2 keypair, cluster scoped
+    ec2KeyPairGVR = schema.GroupVersionResource{
+        Group:    "ec2.aws.upbound.io",
+        Version:  "v1beta1",
+        Resource: "keypairs",
+    }
+)
+
+// Secret holding the SSH key Ansible uses (mounted at ~/.ssh)
+func getSSHKeySecretName() string {
+    if name := Setting("SSH_KEY_SECRET"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-ssh"
+}
+
+// EC2_KEYPAIR_NAME wins; otherwise PROVISIONER_ENVIRONMENT gives each environment
+// (dev, staging, prod) its own hobbyfarm-<env> keypair
+func getCloudKeyPairName() string {
+    if name := Setting("EC2_KEYPAIR_NAME"); name != "" {
+        return name
+    }
+    if environment := Setting("PROVISIONER_ENVIRONMENT"); environment != "" {
+        return "hobbyfarm-" + environment
+    }
+    return "hobbyfarm-keypair"
+}
+
+// Derive the OpenSSH public key ("ssh-rsa AAAA...") of a private key
+func derivePublicKey(privateKey []byte) (string, error) {
+    keyFile, err := os.CreateTemp("", "keypair-*")
+    if err != nil {
+        return "", err
+    }
+    defer os.Remove(keyFile.Name())
+
+    if _, err := keyFile.Write(privateKey); err != nil {
+        keyFile.Close()
+        return "", err
+    }
+    keyFile.Close()
+    os.Chmod(keyFile.Name(), 0600)
+
+    return derivePublicKeyFromFile(keyFile.Name())
+}
+
+func derivePublicKeyFromFile(path string) (string, error) {
+    output, err := exec.Command("ssh-keygen", "-y", "-f", path).Output()
+    if err != nil {
+        return "", fmt.Errorf("failed to derive public key from %s: %v", path, err)
+    }
+    return normalizePublicKey(string(output)), nil
+}
+
+// Keep only "<type> <base64>", dropping the comment
+func normalizePublicKey(publicKey string) string {
+    fields := strings.Fields(publicKey)
+    if len(fields) < 2 {
+        return strings.TrimSpace(publicKey)
+    }
+    return fields[0] + " " + fields[1]
+}
+
+// Fingerprint as EC2 reports it for imported keys: MD5 of the key blob for RSA,
+// base64 SHA256 for ED25519
+func awsImportedKeyFingerprint(publicKey string) (string, error) {
+    fields := strings.Fields(publicKey)
+    if len(fields) < 2 {
+        return "", fmt.Errorf("malformed public key")
+    }
+    blob, err := base64.StdEncoding.DecodeString(fields[1])
+    if err != nil {
+        return "", fmt.Errorf("malformed public key: %v", err)
+    }
+
+    if fields[0] == "ssh-ed25519" {
+        sum := sha256.Sum256(blob)
+        return base64.StdEncoding.EncodeToString(sum[:]), nil
+    }
+
+    sum := md5.Sum(blob)
+    hexPairs := make([]string, len(sum))
+    for i, b := range sum {
+        hexPairs[i] = fmt.Sprintf("%02x", b)
+    }
+    return strings.Join(hexPairs, ":"), nil
+}
+
+// Read the managed key Secret and check it is the key Ansible actually uses
+func loadManagedPublicKey(client dynamic.Interface) (string, error) {
+    secretName := getSSHKeySecretName()
+    secret, err := client.Resource(secretGVR).Namespace("default").Get(context.TODO(), secretName, metav1.GetOptions{})
+    if err != nil {
+        return "", fmt.Errorf("failed to read SSH key secret %s: %v", secretName, err)
+    }
+
+    data, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+    privateKey, err := base64.StdEncoding.DecodeString(data["id_rsa"])
+    if err != nil || len(strings.TrimSpace(string(privateKey))) == 0 {
+        return "", fmt.Errorf("SSH key secret %s has no id_rsa", secretName)
+    }
+
+    publicKey, err := derivePublicKey(privateKey)
+    if err != nil {
+        return "", err
+    }
+
+    // A stale id_rsa.pub would import a key nobody holds the private half of
+    if storedPub, err := base64.StdEncoding.DecodeString(data["id_rsa.pub"]); err == nil && strings.TrimSpace(string(storedPub)) != "" {
+        if normalizePublicKey(string(storedPub)) != publicKey {
+            return "", fmt.Errorf("id_rsa.pub in secret %s does not match id_rsa", secretName)
+        }
+    }
+
+    homeDir, _ := os.UserHomeDir()
+    ansibleKeyPath := filepath.Join(homeDir, ".ssh/id_rsa")
+    ansiblePublicKey, err := derivePublicKeyFromFile(ansibleKeyPath)
+    if err != nil {
+        return "", err
+    }
+    if ansiblePublicKey != publicKey {
+        return "", fmt.Errorf("SSH key secret %s does not match the Ansible key %s", secretName, ansibleKeyPath)
+    }
+
+    return publicKey, nil
+}
+
+// Create or import the EC2 keypair from the managed SSH key Secret. An existing AWS
+// keypair of the same name is adopted through the external-name annotation.
+func EnsureCloudKeyPair(client dynamic.Interface) error {
+    keyName := getCloudKeyPairName()
+
+    publicKey, err := loadManagedPublicKey(client)
+    if err != nil {
+        return err
+    }
+
+    existing, err := client.Resource(ec2KeyPairGVR).Get(context.TODO(), keyName, metav1.GetOptions{})
+    if err == nil {
+        currentKey, _, _ := unstructured.NestedString(existing.Object, "spec", "forProvider", "publicKey")
+        if normalizePublicKey(currentKey) != publicKey {
+            return fmt.Errorf("EC2 keypair %s was imported from a different key, delete it or set EC2_KEYPAIR_NAME", keyName)
+        }
+        log.Printf("🔑 EC2 keypair %s already managed", keyName)
+        return nil
+    }
+    if !errors.IsNotFound(err) {
+        return fmt.Errorf("failed to read EC2 keypair %s: %v", keyName, err)
+    }
+
+    keyPair := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "ec2.aws.upbound.io/v1beta1",
+            "kind":       "KeyPair",
+            "metadata": map[string]interface{}{
+                "name": keyName,
+                "labels": map[string]interface{}{
+                    "app": "hobbyfarm-provisioner",
+                },
+                "annotations": map[string]interface{}{
+                    "crossplane.io/external-name": keyName,
+                },
+            },
+            "spec": map[string]interface{}{
+                "forProvider": map[string]interface{}{
+                    "region":    getCloudRegion(),
+                    "publicKey": publicKey,
+                },
+                "providerConfigRef": map[string]interface{}{
+                    "name": "aws-provider",
+                },
+            },
+        },
+    }
+
+    stampProvenance(keyPair)
+    if _, err := client.Resource(ec2KeyPairGVR).Create(context.TODO(), keyPair, metav1.CreateOptions{}); err != nil {
+        return fmt.Errorf("failed to create EC2 keypair %s: %v", keyName, err)
+    }
+
+    log.Printf("🔑 Importing EC2 keypair %s from secret %s", keyName, getSSHKeySecretName())
+    return nil
+}
+
+// Wait for AWS to report the keypair fingerprint and compare it with the managed key,
+// which catches an adopted keypair that was created from some other key
+func ValidateCloudKeyPair(client dynamic.Interface, timeout time.Duration) error {
+    keyName := getCloudKeyPairName()
+
+    publicKey, err := loadManagedPublicKey(client)
+    if err != nil {
+        return err
+    }
+    expected, err := awsImportedKeyFingerprint(publicKey)
+    if err != nil {
+        return err
+    }
+
+    deadline := time.Now().Add(timeout)
+    for time.Now().Before(deadline) {
+        keyPair, err := client.Resource(ec2KeyPairGVR).Get(context.TODO(), keyName, metav1.GetOptions{})
+        if err == nil {
+            fingerprint, _, _ := unstructured.NestedString(keyPair.Object, "status", "atProvider", "fingerprint")
+            if fingerprint != "" {
+                if fingerprint != expected {
+                    return fmt.Errorf("EC2 keypair %s fingerprint %s does not match the Ansible key (%s)", keyName, fingerprint, expected)
+                }
+                log.Printf("✅ EC2 keypair %s matches the Ansible SSH key", keyName)
+                return nil
+            }
+        }
+        time.Sleep(10 * time.Second)
+    }
+
+    return fmt.Errorf("EC2 keypair %s not reported by AWS within %v", keyName, timeout)
+}
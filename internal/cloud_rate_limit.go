@@ -0,0 +1,51 @@
+// internal/cloud_rate_limit.go - Token bucket limiting cloud instance creation
+package internal
+
+import (
+    "errors"
+    "log"
+    "strconv"
+    "sync"
+
+    "golang.org/x/time/rate"
+)
+
+// Returned when creation is deferred; the request stays pending and is retried
+var errCloudRateLimited = errors.New("cloud instance creation rate limited")
+
+// Shared by the Kratix and TrainingVM flows since both create instances in the same
+// account; created on first use, once the configuration file is loaded
+var cloudCreationLimiter struct {
+    sync.Once
+    limiter *rate.Limiter
+}
+
+func getCloudCreatePerMinute() int {
+    if perMinute, err := strconv.Atoi(Setting("CLOUD_CREATE_PER_MINUTE")); err == nil && perMinute > 0 {
+        return perMinute
+    }
+    return 10
+}
+
+func getCloudCreateBurst() int {
+    if burst, err := strconv.Atoi(Setting("CLOUD_CREATE_BURST")); err == nil && burst > 0 {
+        return burst
+    }
+    return 5
+}
+
+// CLOUD_CREATE_PER_MINUTE refills the bucket, CLOUD_CREATE_BURST caps back-to-back creations
+func newCloudCreationLimiter() *rate.Limiter {
+    perMinute := getCloudCreatePerMinute()
+    burst := getCloudCreateBurst()
+    log.Printf("☁️ Cloud instance creation limited to %d/min (burst %d)", perMinute, burst)
+    return rate.NewLimiter(rate.Limit(float64(perMinute)/60), burst)
+}
+
+// Take a token for one instance creation without blocking the controller loop
+func allowCloudInstanceCreation() bool {
+    cloudCreationLimiter.Do(func() {
+        cloudCreationLimiter.limiter = newCloudCreationLimiter()
+    })
+    return cloudCreationLimiter.limiter.Allow()
+}
@@ -0,0 +1,259 @@
+// internal/cloud_reuse.go - Hand released cloud instances to the next request instead of terminating them
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+const (
+    // Set on released instances waiting for another request
+    cloudPoolLabel = "hobbyfarm.io/cloud-pool"
+    cloudPoolIdle  = "idle"
+
+    reuseCountAnnotation = "hobbyfarm.io/reuse-count"
+    idleSinceAnnotation  = "hobbyfarm.io/idle-since"
+)
+
+// CLOUD_INSTANCE_REUSE=true keeps released instances running for the next request
+func cloudInstanceReuseEnabled() bool {
+    return Setting("CLOUD_INSTANCE_REUSE") == "true"
+}
+
+// Sessions an instance may serve after its first one
+func getCloudReuseMaxCount() int {
+    if count, err := strconv.Atoi(Setting("CLOUD_REUSE_MAX_COUNT")); err == nil && count >= 0 {
+        return count
+    }
+    return 3
+}
+
+// Instances older than this are terminated on release, however often they were used
+func getCloudReuseMaxAge() time.Duration {
+    if hours, err := strconv.Atoi(Setting("CLOUD_REUSE_MAX_AGE_HOURS")); err == nil && hours > 0 {
+        return time.Duration(hours) * time.Hour
+    }
+    return 8 * time.Hour
+}
+
+// How long a released instance waits for a request before it is terminated
+func getCloudReuseIdleTimeout() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("CLOUD_REUSE_IDLE_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 30 * time.Minute
+}
+
+func instanceReuseCount(instance *unstructured.Unstructured) int {
+    count, _ := strconv.Atoi(instance.GetAnnotations()[reuseCountAnnotation])
+    return count
+}
+
+// Why the instance can't serve another session, empty when it can
+func cloudReuseRefusal(instance *unstructured.Unstructured) string {
+    if count := instanceReuseCount(instance); count >= getCloudReuseMaxCount() {
+        return fmt.Sprintf("reused %d times, limit is %d", count, getCloudReuseMaxCount())
+    }
+    if age := time.Since(instance.GetCreationTimestamp().Time); age >= getCloudReuseMaxAge() {
+        return fmt.Sprintf("running for %v, limit is %v", age.Round(time.Minute), getCloudReuseMaxAge())
+    }
+    state, _, _ := unstructured.NestedString(instance.Object, "status", "state")
+    vmIP, _, _ := unstructured.NestedString(instance.Object, "status", "vmIP")
+    if state != "running" || vmIP == "" {
+        return fmt.Sprintf("instance is %s", state)
+    }
+    if outsideBusinessHours(cloudInstanceEnvironment(instance)) {
+        return "outside business hours"
+    }
+    if instance.GetLabels()[exposedPortsLabel] == "true" {
+        return "ports were opened for its session"
+    }
+    return ""
+}
+
+// Keep a released instance running for the next request once its session
+// workspace is gone. Returns false when the caller should terminate it instead.
+func (dc *DeprovisionController) releaseCloudInstance(instance *unstructured.Unstructured, sessionName string) bool {
+    if !cloudInstanceReuseEnabled() {
+        return false
+    }
+    if refusal := cloudReuseRefusal(instance); refusal != "" {
+        log.Printf("☁️ Not keeping cloud instance %s for reuse: %s", instance.GetName(), refusal)
+        return false
+    }
+
+    vmIP, _, _ := unstructured.NestedString(instance.Object, "status", "vmIP")
+    if !isVMReachable(vmIP) {
+        return false
+    }
+    if err := dc.ansibleRunner.CleanupSession(vmIP, sessionName); err != nil {
+        log.Printf("⚠️ Workspace cleanup of session %s failed on %s, terminating instead: %v", sessionName, instance.GetName(), err)
+        return false
+    }
+
+    // The resourceVersion makes the patch fail if the instance changed since it was read
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "resourceVersion": instance.GetResourceVersion(),
+            "labels": map[string]interface{}{
+                "kratix-request": nil,
+                "session":        nil,
+                cloudPoolLabel:   cloudPoolIdle,
+            },
+            "annotations": map[string]interface{}{
+                idleSinceAnnotation: time.Now().Format(time.RFC3339),
+            },
+        },
+    })
+    _, err := dc.client.Resource(ec2TrainingVMGVR).Namespace("default").Patch(
+        context.TODO(), instance.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if err != nil {
+        log.Printf("⚠️ Could not keep cloud instance %s for reuse: %v", instance.GetName(), err)
+        return false
+    }
+
+    log.Printf("♻️ Cloud instance %s (%s) of session %s kept for reuse", instance.GetName(), vmIP, sessionName)
+    return true
+}
+
+// Whether the idle instance was built like the one the request would get
+func cloudInstanceMatches(instance, wanted *unstructured.Unstructured) bool {
+    instanceType := instance.GetAnnotations()[requestedInstanceTypeAnnotation]
+    if instanceType == "" {
+        instanceType, _, _ = unstructured.NestedString(instance.Object, "spec", "instanceType")
+    }
+    wantedType, _, _ := unstructured.NestedString(wanted.Object, "spec", "instanceType")
+    if instanceType != wantedType {
+        return false
+    }
+
+    // Compared as JSON, numbers read back from the API are int64 whatever they were built as
+    for _, field := range []string{"region", "ami", "rootVolumeSize", "dataVolumes", "providerConfigName", "securityGroupIds"} {
+        haveField, _, _ := unstructured.NestedFieldNoCopy(instance.Object, "spec", field)
+        wantField, _, _ := unstructured.NestedFieldNoCopy(wanted.Object, "spec", field)
+        have, _ := json.Marshal(haveField)
+        want, _ := json.Marshal(wantField)
+        if string(have) != string(want) {
+            return false
+        }
+    }
+    return true
+}
+
+// Hand a matching idle instance to the request. Returns its name, empty when
+// none was claimed and a new instance has to be created.
+func (kc *KratixController) claimIdleCloudInstance(requestName, user, session string, wanted *unstructured.Unstructured) string {
+    if !cloudInstanceReuseEnabled() {
+        return ""
+    }
+    instances, err := kc.client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("%s=%s", cloudPoolLabel, cloudPoolIdle),
+    })
+    if err != nil {
+        return ""
+    }
+
+    for i := range instances.Items {
+        instance := &instances.Items[i]
+        if cloudReuseRefusal(instance) != "" || !cloudInstanceMatches(instance, wanted) ||
+            instance.GetLabels()[tenantLabel] != wanted.GetLabels()[tenantLabel] {
+            continue
+        }
+
+        // Unset on the wanted instance clears the previous session's scenario
+        var scenario interface{}
+        if value, found, _ := unstructured.NestedString(wanted.Object, "spec", "scenario"); found {
+            scenario = value
+        }
+        // Likewise the previous session's passthrough tags, so billing follows the new one
+        tags := map[string]interface{}{}
+        previous, _, _ := unstructured.NestedStringMap(instance.Object, "spec", "tags")
+        for key := range previous {
+            tags[key] = nil
+        }
+        wantedTags, _, _ := unstructured.NestedStringMap(wanted.Object, "spec", "tags")
+        for key, value := range wantedTags {
+            tags[key] = value
+        }
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "metadata": map[string]interface{}{
+                "resourceVersion": instance.GetResourceVersion(),
+                "labels": map[string]interface{}{
+                    "kratix-request": requestName,
+                    "session":        session,
+                    cloudPoolLabel:   nil,
+                },
+                "annotations": map[string]interface{}{
+                    idleSinceAnnotation:  nil,
+                    reuseCountAnnotation: strconv.Itoa(instanceReuseCount(instance) + 1),
+                },
+            },
+            // Retags the instance for its new session
+            "spec": map[string]interface{}{
+                "user":               user,
+                "session":            session,
+                "scenario":           scenario,
+                "provisionerVersion": provisionerVersion,
+                "tags":               tags,
+            },
+        })
+        _, err := kc.client.Resource(ec2TrainingVMGVR).Namespace("default").Patch(
+            context.TODO(), instance.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+        if err != nil {
+            log.Printf("⚠️ Could not claim idle cloud instance %s for %s: %v", instance.GetName(), requestName, err)
+            continue
+        }
+
+        log.Printf("♻️ Reusing cloud instance %s for Kratix request %s (use %d)",
+            instance.GetName(), requestName, instanceReuseCount(instance)+2)
+        return instance.GetName()
+    }
+    return ""
+}
+
+// Terminate idle instances nobody claimed in time or that grew too old
+func ReapIdleCloudInstances(client dynamic.Interface) {
+    if !apiAvailable(apiCrossplane) {
+        return
+    }
+    instances, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("%s=%s", cloudPoolLabel, cloudPoolIdle),
+    })
+    if err != nil {
+        return
+    }
+
+    for _, instance := range instances.Items {
+        reason := ""
+        idleSince, err := time.Parse(time.RFC3339, instance.GetAnnotations()[idleSinceAnnotation])
+        if err != nil || time.Since(idleSince) > getCloudReuseIdleTimeout() {
+            reason = fmt.Sprintf("idle for more than %v", getCloudReuseIdleTimeout())
+        } else if !cloudInstanceReuseEnabled() {
+            reason = "instance reuse disabled"
+        } else if time.Since(instance.GetCreationTimestamp().Time) >= getCloudReuseMaxAge() {
+            reason = fmt.Sprintf("older than %v", getCloudReuseMaxAge())
+        } else if outsideBusinessHours(cloudInstanceEnvironment(&instance)) {
+            reason = "outside business hours"
+        }
+        if reason == "" {
+            continue
+        }
+
+        err = client.Resource(ec2TrainingVMGVR).Namespace("default").Delete(context.TODO(), instance.GetName(), metav1.DeleteOptions{})
+        if err != nil && !errors.IsNotFound(err) {
+            log.Printf("❌ Failed to terminate idle cloud instance %s: %v", instance.GetName(), err)
+            continue
+        }
+        log.Printf("🗑️ Terminated idle cloud instance %s: %s", instance.GetName(), reason)
+    }
+}
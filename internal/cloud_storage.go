@@ -0,0 +1,204 @@
+// internal/cloud_storage.go - Root volume sizing and extra EBS data disks for cloud VMs
+package internal
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Scenario annotations, e.g. root-volume-size: "30", data-volumes: "50:/var/lib/docker,20:/data:gp3"
+const (
+    rootVolumeSizeAnnotation = "provisioning.hobbyfarm.io/root-volume-size"
+    dataVolumesAnnotation    = "provisioning.hobbyfarm.io/data-volumes"
+)
+
+// Playbook that formats and mounts the data volumes, run before the scenario playbooks
+const dataVolumesPlaybook = "data-volumes.yaml"
+
+// An extra EBS volume, sizes in GiB
+type cloudDataVolume struct {
+    Size       int
+    MountPoint string
+    VolumeType string
+}
+
+// Disk layout requested for a cloud VM; zero values keep the AMI defaults
+type cloudStorage struct {
+    RootVolumeSize int
+    DataVolumes    []cloudDataVolume
+}
+
+// Upper bound for any single volume, guarding against typos like 5000 for 50
+func getCloudMaxVolumeSize() int {
+    if size, err := strconv.Atoi(Setting("CLOUD_MAX_VOLUME_GIB")); err == nil && size > 0 {
+        return size
+    }
+    return 200
+}
+
+func parseVolumeSize(value string) (int, error) {
+    size, err := strconv.Atoi(strings.TrimSpace(value))
+    if err != nil || size <= 0 {
+        return 0, fmt.Errorf("invalid volume size %q", value)
+    }
+    if max := getCloudMaxVolumeSize(); size > max {
+        return 0, fmt.Errorf("volume size %dGiB exceeds CLOUD_MAX_VOLUME_GIB (%d)", size, max)
+    }
+    return size, nil
+}
+
+// Read the storage annotations of a Scenario or TrainingVM, skipping invalid entries
+func parseCloudStorage(annotations map[string]string) cloudStorage {
+    var storage cloudStorage
+
+    if value, exists := annotations[rootVolumeSizeAnnotation]; exists {
+        if size, err := parseVolumeSize(value); err == nil {
+            storage.RootVolumeSize = size
+        } else {
+            log.Printf("⚠️ Ignoring %s: %v", rootVolumeSizeAnnotation, err)
+        }
+    }
+
+    for i, entry := range splitList(annotations[dataVolumesAnnotation]) {
+        parts := strings.Split(entry, ":")
+        size, err := parseVolumeSize(parts[0])
+        if err != nil {
+            log.Printf("⚠️ Ignoring data volume %q: %v", entry, err)
+            continue
+        }
+
+        volume := cloudDataVolume{
+            Size:       size,
+            MountPoint: fmt.Sprintf("/mnt/data%d", i),
+            VolumeType: "gp3",
+        }
+        if len(parts) > 1 && strings.HasPrefix(parts[1], "/") {
+            volume.MountPoint = parts[1]
+        }
+        if len(parts) > 2 && parts[2] != "" {
+            volume.VolumeType = parts[2]
+        }
+        storage.DataVolumes = append(storage.DataVolumes, volume)
+    }
+
+    return storage
+}
+
+// Storage requested by a Scenario
+func getScenarioCloudStorage(client dynamic.Interface, scenario string) cloudStorage {
+    return parseCloudStorage(getScenarioAnnotations(client, scenario))
+}
+
+// spec.cloudFallback of a VMProvisioningRequest, with the scenario's disk layout
+func buildCloudFallbackSpec(storage cloudStorage) map[string]interface{} {
+    // Instance type and region come from the provisioner's cloud instance template
+    spec := map[string]interface{}{
+        "enabled":  true,
+        "provider": "aws",
+    }
+    if storage.RootVolumeSize > 0 {
+        spec["rootVolumeSize"] = int64(storage.RootVolumeSize)
+    }
+    if len(storage.DataVolumes) > 0 {
+        volumes := make([]interface{}, len(storage.DataVolumes))
+        for i, volume := range storage.DataVolumes {
+            volumes[i] = map[string]interface{}{
+                "size":       int64(volume.Size),
+                "mountPoint": volume.MountPoint,
+                "volumeType": volume.VolumeType,
+            }
+        }
+        spec["dataVolumes"] = volumes
+    }
+    return spec
+}
+
+// Disk layout recorded in a VMProvisioningRequest's spec.cloudFallback
+func getRequestCloudStorage(request *unstructured.Unstructured) cloudStorage {
+    var storage cloudStorage
+    if size, found, _ := unstructured.NestedInt64(request.Object, "spec", "cloudFallback", "rootVolumeSize"); found {
+        storage.RootVolumeSize = int(size)
+    }
+
+    volumes, _, _ := unstructured.NestedSlice(request.Object, "spec", "cloudFallback", "dataVolumes")
+    for i, v := range volumes {
+        volume, ok := v.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        size, _, _ := unstructured.NestedInt64(volume, "size")
+        if size <= 0 {
+            continue
+        }
+        mountPoint, _, _ := unstructured.NestedString(volume, "mountPoint")
+        volumeType, _, _ := unstructured.NestedString(volume, "volumeType")
+        if mountPoint == "" {
+            mountPoint = fmt.Sprintf("/mnt/data%d", i)
+        }
+        if volumeType == "" {
+            volumeType = "gp3"
+        }
+        storage.DataVolumes = append(storage.DataVolumes, cloudDataVolume{
+            Size:       int(size),
+            MountPoint: mountPoint,
+            VolumeType: volumeType,
+        })
+    }
+    return storage
+}
+
+// Set rootVolumeSize and dataVolumes on an EC2TrainingVM claim. Data volumes are
+// attached as /dev/sdf, /dev/sdg, ... and deleted with the instance.
+func applyCloudStorage(claim *unstructured.Unstructured, storage cloudStorage) {
+    if storage.RootVolumeSize > 0 {
+        unstructured.SetNestedField(claim.Object, int64(storage.RootVolumeSize), "spec", "rootVolumeSize")
+    }
+    if len(storage.DataVolumes) == 0 {
+        return
+    }
+
+    devices := make([]interface{}, len(storage.DataVolumes))
+    for i, volume := range storage.DataVolumes {
+        devices[i] = map[string]interface{}{
+            "deviceName":          fmt.Sprintf("/dev/sd%c", 'f'+i),
+            "volumeSize":          int64(volume.Size),
+            "volumeType":          volume.VolumeType,
+            "deleteOnTermination": true,
+        }
+    }
+    unstructured.SetNestedSlice(claim.Object, devices, "spec", "dataVolumes")
+}
+
+// Extra-vars JSON for data-volumes.yaml. Disks are matched by size since NVMe
+// instances do not expose the /dev/sdX names they were attached as.
+func dataVolumesExtraVars(volumes []cloudDataVolume) string {
+    vars := make([]map[string]interface{}, len(volumes))
+    for i, volume := range volumes {
+        vars[i] = map[string]interface{}{
+            "size_gib":    volume.Size,
+            "mount_point": volume.MountPoint,
+            "label":       fmt.Sprintf("hfdata%d", i),
+        }
+    }
+    data, _ := json.Marshal(map[string]interface{}{"data_volumes": vars})
+    return string(data)
+}
+
+// Prepend the disk setup playbook so scenario playbooks (Docker, k8s) land on the data volumes
+func withDataVolumesPlaybook(config *ProvisioningConfig) {
+    if len(config.DataVolumes) == 0 {
+        return
+    }
+    for _, playbook := range config.Playbooks {
+        if playbook == dataVolumesPlaybook {
+            return
+        }
+    }
+    config.Playbooks = append([]string{dataVolumesPlaybook}, config.Playbooks...)
+}
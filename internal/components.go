@@ -0,0 +1,76 @@
+// internal/components.go - Which part of the provisioner this process runs, for deploying the reconcilers, webhook and Ansible workers apart
+package internal
+
+import (
+    "log"
+    "strings"
+    "time"
+)
+
+// Components, from COMPONENT. A split deployment runs one Deployment of each;
+// they meet only in the cluster: the controller allocates and leaves a request
+// allocated, a worker provisions it and records the outcome in its status.
+const (
+    ComponentAll        = "all"        // everything in one pod, as before the split
+    ComponentController = "controller" // reconcilers: detection, allocation, status, cleanup
+    ComponentWebhook    = "webhook"    // the admission webhook and HTTP API alone
+    ComponentWorker     = "worker"     // Ansible runs of allocated requests
+)
+
+func Component() string {
+    if component := strings.ToLower(Setting("COMPONENT")); component != "" {
+        return component
+    }
+    return ComponentAll
+}
+
+// Whether this process's component runs stage; the controller leaves
+// provisioning to the workers, which run nothing else
+func componentRunsStage(stage string) bool {
+    switch Component() {
+    case ComponentWorker:
+        return stage == stageProvisioning
+    case ComponentController:
+        return stage != stageProvisioning
+    case ComponentWebhook:
+        return false
+    }
+    return true
+}
+
+// Provision allocated requests and keep ready VMs converged, nothing else.
+// Workers split requests by their own shard Leases, so SHARD_COUNT of the worker
+// Deployment is its replica count, independent of the controller's.
+func (kc *KratixController) WatchProvisioningWork() {
+    log.Println("🛠️ Starting provisioning worker...")
+    for {
+        if apiAvailable(apiKratix) && clusterReachable() {
+            kc.passMu.Lock()
+            stagePass(stageProvisioning, kc.updateVMStatus)
+            kc.checkDrift()
+            kc.passMu.Unlock()
+        }
+        Heartbeat(kc.client, HeartbeatProvisioningWorker)
+        time.Sleep(10 * time.Second)
+    }
+}
+
+// The component is known, and a split one has what it needs from the others
+func validateComponent(check *ConfigCheck) {
+    switch component := Component(); component {
+    case ComponentAll:
+    case ComponentController, ComponentWorker:
+        if getEventBusBackend() == "memory" {
+            check.warn("EVENT_BUS=memory doesn't reach the other components, use nats or leave it empty")
+        }
+        if component == ComponentWorker && Setting("INTEGRATION_MODE") == "hobbyfarm-only" {
+            check.warn("workers provision VMProvisioningRequests, which hobbyfarm-only mode doesn't create")
+        }
+    case ComponentWebhook:
+        if Setting("WEBHOOK_CERT_DIR") == "" {
+            check.warn("the webhook component serves plain HTTP without WEBHOOK_CERT_DIR, the API server only calls HTTPS webhooks")
+        }
+    default:
+        check.fail("COMPONENT %q is not one of %s, %s, %s, %s", component, ComponentAll, ComponentController, ComponentWebhook, ComponentWorker)
+    }
+}
@@ -0,0 +1,483 @@
+// internal/config_file.go - One YAML configuration file for every setting, checked against a schema, environment variables overriding it
+package internal
+
+import (
+    "bytes"
+    "encoding/json"
+    "fmt"
+    "os"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "sigs.k8s.io/yaml"
+)
+
+// Kinds of value a setting takes in the configuration file
+const (
+    settingString   = "string"
+    settingInteger  = "integer"
+    settingNumber   = "number"
+    settingBoolean  = "boolean"
+    settingDuration = "duration"
+    settingList     = "list"
+)
+
+// A setting of the configuration file: where it sits in the file and the
+// environment variable that overrides it. Integers and numbers must be at least
+// minimum, and at most maximum when that is set.
+type configSetting struct {
+    path        string
+    env         string
+    kind        string
+    enum        []string
+    minimum     float64
+    maximum     float64
+    description string
+}
+
+// The schema of the configuration file. Secrets, such as NETBOX_TOKEN and
+// PACKAGE_DETECTOR_TOKEN, stay environment variables set from Secrets and have
+// no place in it.
+var configSettings = []configSetting{
+    {path: "component", env: "COMPONENT", kind: settingString, enum: []string{"all", "controller", "webhook", "worker"}, description: "Part of the provisioner this process runs, all of it by default"},
+    {path: "mode", env: "INTEGRATION_MODE", kind: settingString, enum: []string{"hybrid", "hobbyfarm-only", "kratix-only"}, description: "Which pathways run"},
+    {path: "configValidation", env: "CONFIG_VALIDATION", kind: settingString, enum: []string{"strict", "warn"}, description: "warn starts despite configuration problems"},
+    {path: "logLevel", env: "LOG_LEVEL", kind: settingString, enum: []string{"info", "debug"}},
+    {path: "environment", env: "PROVISIONER_ENVIRONMENT", kind: settingString, description: "dev, staging, prod; names the EC2 keypair"},
+
+    {path: "namespaces.hobbyfarm", env: "HOBBYFARM_NAMESPACE", kind: settingString, description: "Namespace of HobbyFarm's Sessions, VirtualMachines and Scenarios"},
+
+    {path: "features.webhook", env: "ENABLE_WEBHOOK", kind: settingBoolean},
+    {path: "features.cloudFallback", env: "ENABLE_EC2_FALLBACK", kind: settingBoolean},
+    {path: "features.hobbyFarmDirectMode", env: "HOBBYFARM_DIRECT_MODE", kind: settingBoolean, description: "HobbyFarm to TrainingVMs instead of Kratix"},
+    {path: "features.driftDetection", env: "DRIFT_DETECTION", kind: settingBoolean},
+    {path: "features.diskGuard", env: "DISK_GUARD", kind: settingBoolean},
+    {path: "features.playbookResume", env: "PLAYBOOK_RESUME", kind: settingBoolean},
+    {path: "features.sessionTimeDisplay", env: "SESSION_TIME_DISPLAY", kind: settingBoolean},
+    {path: "features.securityReviewMode", env: "SECURITY_REVIEW_MODE", kind: settingBoolean, description: "Redact secrets from logs"},
+    {path: "features.cloudConsoleOutput", env: "CLOUD_CONSOLE_OUTPUT", kind: settingBoolean},
+    {path: "features.cloudInstanceReuse", env: "CLOUD_INSTANCE_REUSE", kind: settingBoolean},
+    {path: "features.stateRestore", env: "STATE_RESTORE", kind: settingBoolean},
+
+    {path: "pool.staticVMs", env: "STATIC_VM_POOL", kind: settingList, description: "IPs of the default environment's static VMs"},
+    {path: "pool.environmentsFile", env: "VM_ENVIRONMENTS_FILE", kind: settingString},
+    {path: "pool.maintenanceConfigMap", env: "MAINTENANCE_CONFIGMAP", kind: settingString},
+    {path: "pool.lockLeaseSeconds", env: "VM_LOCK_LEASE_SECONDS", kind: settingInteger, minimum: 1},
+    {path: "pool.discovery.candidatesConfigMap", env: "POOL_CANDIDATES_CONFIGMAP", kind: settingString},
+    {path: "pool.discovery.leasesFile", env: "POOL_DISCOVERY_LEASES_FILE", kind: settingString},
+    {path: "pool.discovery.environment", env: "POOL_DISCOVERY_ENVIRONMENT", kind: settingString},
+    {path: "pool.discovery.intervalMinutes", env: "POOL_DISCOVERY_INTERVAL_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "pool.discovery.cidrs", env: "POOL_DISCOVERY_CIDRS", kind: settingList},
+    {path: "pool.adoptCIDRs", env: "ADOPT_VM_CIDRS", kind: settingList, description: "Networks spec.adoptVM may name machines in, adoption is refused while empty"},
+
+    {path: "timeouts.maxAllocationHours", env: "MAX_ALLOCATION_HOURS", kind: settingInteger, minimum: 1},
+    {path: "timeouts.readinessGateMinutes", env: "READINESS_GATE_TIMEOUT_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "timeouts.heartbeatStaleMinutes", env: "HEARTBEAT_STALE_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "timeouts.queueDefaultSessionMinutes", env: "QUEUE_DEFAULT_SESSION_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "timeouts.requestHook", env: "REQUEST_HOOK_TIMEOUT", kind: settingDuration},
+    {path: "timeouts.packageDetector", env: "PACKAGE_DETECTOR_TIMEOUT", kind: settingDuration},
+    {path: "timeouts.apiCheckSeconds", env: "API_CHECK_SECONDS", kind: settingInteger, minimum: 1},
+    {path: "timeouts.apiRetryAttempts", env: "API_RETRY_ATTEMPTS", kind: settingInteger, minimum: 1},
+    {path: "timeouts.driftCheckMinutes", env: "DRIFT_CHECK_INTERVAL_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "timeouts.courseStatusSeconds", env: "COURSE_STATUS_INTERVAL_SECONDS", kind: settingInteger, minimum: 1},
+    {path: "timeouts.keyRotationSyncMinutes", env: "KEY_ROTATION_SYNC_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "timeouts.releasedRetentionDays", env: "RELEASED_RETENTION_DAYS", kind: settingInteger, description: "Days released requests are kept for auditing, 0 until their session is deleted"},
+
+    {path: "cloud.region", env: "EC2_REGION", kind: settingString},
+    {path: "cloud.instanceType", env: "EC2_INSTANCE_TYPE", kind: settingString},
+    {path: "cloud.ami", env: "EC2_AMI", kind: settingString},
+    {path: "cloud.vpcID", env: "EC2_VPC_ID", kind: settingString},
+    {path: "cloud.securityGroupIDs", env: "EC2_SECURITY_GROUP_IDS", kind: settingList},
+    {path: "cloud.keyPairName", env: "EC2_KEYPAIR_NAME", kind: settingString},
+    {path: "cloud.fallbackSubnets", env: "CLOUD_FALLBACK_SUBNETS", kind: settingList, description: "One subnet per AZ, tried in order"},
+    {path: "cloud.fallbackInstanceTypes", env: "CLOUD_FALLBACK_INSTANCE_TYPES", kind: settingList},
+    {path: "cloud.sshUsers", env: "CLOUD_SSH_USERS", kind: settingList, description: "<AMI or provider>=<user> entries"},
+    {path: "cloud.maxVolumeGiB", env: "CLOUD_MAX_VOLUME_GIB", kind: settingInteger, minimum: 1},
+    {path: "cloud.createPerMinute", env: "CLOUD_CREATE_PER_MINUTE", kind: settingInteger, minimum: 1},
+    {path: "cloud.createBurst", env: "CLOUD_CREATE_BURST", kind: settingInteger, minimum: 1},
+    {path: "cloud.exposedPortsCIDR", env: "EXPOSED_PORTS_CIDR", kind: settingString},
+    {path: "cloud.reuse.maxCount", env: "CLOUD_REUSE_MAX_COUNT", kind: settingInteger},
+    {path: "cloud.reuse.maxAgeHours", env: "CLOUD_REUSE_MAX_AGE_HOURS", kind: settingInteger, minimum: 1},
+    {path: "cloud.reuse.idleMinutes", env: "CLOUD_REUSE_IDLE_MINUTES", kind: settingInteger, minimum: 1},
+
+    {path: "ansible.runtime", env: "ANSIBLE_EE_RUNTIME", kind: settingString, enum: []string{"local", "venv", "podman", "docker"}},
+    {path: "ansible.image", env: "ANSIBLE_EE_IMAGE", kind: settingString},
+    {path: "ansible.playbookBin", env: "ANSIBLE_PLAYBOOK_BIN", kind: settingString},
+    {path: "ansible.python", env: "ANSIBLE_PYTHON", kind: settingString},
+    {path: "ansible.venvDir", env: "ANSIBLE_VENV_DIR", kind: settingString},
+    {path: "ansible.version", env: "ANSIBLE_VERSION", kind: settingString},
+    {path: "ansible.venvPackages", env: "ANSIBLE_VENV_PACKAGES", kind: settingList},
+    {path: "ansible.alwaysRerunPlaybooks", env: "ALWAYS_RERUN_PLAYBOOKS", kind: settingList},
+    {path: "ansible.inventoryTemplateConfigMap", env: "INVENTORY_TEMPLATE_CONFIGMAP", kind: settingString},
+    {path: "ansible.sshKeySecret", env: "SSH_KEY_SECRET", kind: settingString},
+    {path: "ansible.secretRefNamespaces", env: "SECRET_REF_NAMESPACES", kind: settingList},
+    {path: "ansible.playbookBundleCache", env: "PLAYBOOK_BUNDLE_CACHE", kind: settingString},
+    {path: "ansible.playbookBundleEndpoint", env: "PLAYBOOK_BUNDLE_ENDPOINT", kind: settingString},
+    {path: "ansible.playbookCanarySessions", env: "PLAYBOOK_CANARY_SESSIONS", kind: settingInteger, description: "Sessions of an environment that try a changed playbook bundle before the others get it, 0 for none"},
+    {path: "ansible.playbookCanaryMaxFailureIncrease", env: "PLAYBOOK_CANARY_MAX_FAILURE_INCREASE", kind: settingNumber, maximum: 1, description: "How much higher than the previous bundle's a canary's failure rate may be and still be promoted"},
+    {path: "ansible.playbookCanaryConfigMap", env: "PLAYBOOK_CANARY_CONFIGMAP", kind: settingString},
+    {path: "ansible.diskGuardMinFreeMB", env: "DISK_GUARD_MIN_FREE_MB", kind: settingInteger, minimum: 1},
+    {path: "ansible.diskGuardMaxWorkspaces", env: "DISK_GUARD_MAX_WORKSPACES", kind: settingInteger, minimum: 1},
+
+    {path: "provisioning.callbackURL", env: "PROVISIONING_CALLBACK_URL", kind: settingString},
+    {path: "provisioning.httpProxy", env: "PROVISIONING_HTTP_PROXY", kind: settingString},
+    {path: "provisioning.httpsProxy", env: "PROVISIONING_HTTPS_PROXY", kind: settingString},
+    {path: "provisioning.noProxy", env: "PROVISIONING_NO_PROXY", kind: settingString},
+    {path: "provisioning.aptProxy", env: "PROVISIONING_APT_PROXY", kind: settingString},
+    {path: "provisioning.timezone", env: "PROVISIONING_TIMEZONE", kind: settingString},
+    {path: "provisioning.locale", env: "PROVISIONING_LOCALE", kind: settingString},
+    {path: "provisioning.caBundleFile", env: "PROVISIONING_CA_BUNDLE_FILE", kind: settingString},
+    {path: "provisioning.profilesFile", env: "PROVISIONING_PROFILES_FILE", kind: settingString},
+    {path: "provisioning.tenantsFile", env: "TENANTS_FILE", kind: settingString},
+    {path: "provisioning.requestHooksFile", env: "REQUEST_HOOKS_FILE", kind: settingString},
+    {path: "provisioning.failedRequestRetries", env: "FAILED_REQUEST_RETRIES", kind: settingInteger, description: "Automatic retries of a failed request before it is dead-lettered"},
+    {path: "provisioning.packageDetectorURL", env: "PACKAGE_DETECTOR_URL", kind: settingString, description: "HTTP endpoint asked for the packages of scenarios without a packages annotation"},
+    {path: "provisioning.overlayMode", env: "OVERLAY_MODE", kind: settingString, enum: []string{"direct", "wireguard", "tailscale"}},
+    {path: "provisioning.overlaySecret", env: "OVERLAY_SECRET_NAME", kind: settingString},
+    {path: "provisioning.userMetadataFields", env: "USER_METADATA_FIELDS", kind: settingList},
+    {path: "provisioning.vmOnRequestLoss", env: "VM_ON_REQUEST_LOSS", kind: settingString, enum: []string{"reset", "taint", "off"}, description: "What becomes of a HobbyFarm VirtualMachine whose request is deleted or dead-lettered mid-session"},
+    {path: "provisioning.vmStatusOwnedFields", env: "VM_STATUS_OWNED_FIELDS", kind: settingList, description: "HobbyFarm VirtualMachine status fields the provisioner writes, the rest are left to HobbyFarm"},
+    {path: "provisioning.passthroughLabels", env: "PASSTHROUGH_LABELS", kind: settingString},
+    {path: "provisioning.passthroughAnnotations", env: "PASSTHROUGH_ANNOTATIONS", kind: settingString},
+
+    {path: "outbound.caBundleFile", env: "OUTBOUND_CA_BUNDLE_FILE", kind: settingString, description: "PEM certificates trusted on top of the system roots by every outbound call, e.g. of a TLS-intercepting proxy"},
+    {path: "outbound.httpProxy", env: "OUTBOUND_HTTP_PROXY", kind: settingString},
+    {path: "outbound.httpsProxy", env: "OUTBOUND_HTTPS_PROXY", kind: settingString},
+    {path: "outbound.noProxy", env: "OUTBOUND_NO_PROXY", kind: settingString},
+
+    {path: "webhook.port", env: "WEBHOOK_PORT", kind: settingInteger, minimum: 1, maximum: 65535},
+    {path: "webhook.certDir", env: "WEBHOOK_CERT_DIR", kind: settingString},
+    {path: "webhook.mtlsClientCAFile", env: "MTLS_CLIENT_CA_FILE", kind: settingString},
+    {path: "webhook.mtlsAllowedSANs", env: "MTLS_ALLOWED_SANS", kind: settingList},
+    {path: "webhook.mtlsExemptPaths", env: "MTLS_EXEMPT_PATHS", kind: settingList},
+    {path: "webhook.adminPort", env: "ADMIN_PORT", kind: settingInteger, maximum: 65535, description: "Port of the admin endpoints such as /bulk, each call authorized by TokenReview and SubjectAccessReview; 0 serves none"},
+    {path: "webhook.capacityAllowedOrigin", env: "CAPACITY_API_ALLOWED_ORIGIN", kind: settingString},
+
+    {path: "client.qps", env: "KUBE_CLIENT_QPS", kind: settingNumber, minimum: 1},
+    {path: "client.burst", env: "KUBE_CLIENT_BURST", kind: settingInteger, minimum: 1},
+    {path: "client.trackingCacheTTL", env: "TRACKING_CACHE_TTL", kind: settingDuration},
+    {path: "client.trackingCacheMaxEntries", env: "TRACKING_CACHE_MAX_ENTRIES", kind: settingInteger, minimum: 1},
+    {path: "client.discoveryLogLimit", env: "DISCOVERY_LOG_LIMIT", kind: settingInteger},
+
+    {path: "sharding.count", env: "SHARD_COUNT", kind: settingInteger, minimum: 1},
+    {path: "sharding.leaseSeconds", env: "SHARD_LEASE_SECONDS", kind: settingInteger, minimum: 1},
+
+    {path: "eventBus.backend", env: "EVENT_BUS", kind: settingString, enum: []string{"memory", "nats"}, description: "Stage events between detection, allocation, provisioning and status updates, empty leaves them to the loops"},
+    {path: "eventBus.natsURL", env: "EVENT_BUS_NATS_URL", kind: settingString},
+    {path: "eventBus.natsSubject", env: "EVENT_BUS_NATS_SUBJECT", kind: settingString},
+    {path: "eventBus.stages", env: "EVENT_BUS_STAGES", kind: settingList, description: "Stages this deployment runs over NATS, all by default"},
+    {path: "eventBus.buffer", env: "EVENT_BUS_BUFFER", kind: settingInteger, minimum: 1},
+    {path: "eventBus.maxAttempts", env: "EVENT_BUS_MAX_ATTEMPTS", kind: settingInteger, minimum: 1},
+
+    {path: "scaleDown.businessHours", env: "SCALE_DOWN_BUSINESS_HOURS", kind: settingString},
+    {path: "scaleDown.timezone", env: "SCALE_DOWN_TIMEZONE", kind: settingString},
+    {path: "scaleDown.activeVMs", env: "SCALE_DOWN_ACTIVE_VMS", kind: settingBoolean},
+
+    {path: "readySLO.target", env: "READY_SLO_TARGET", kind: settingDuration},
+    {path: "readySLO.objective", env: "READY_SLO_OBJECTIVE", kind: settingNumber, maximum: 1},
+    {path: "readySLO.days", env: "READY_SLO_DAYS", kind: settingInteger, minimum: 1},
+    {path: "readySLO.configMap", env: "READY_SLO_CONFIGMAP", kind: settingString},
+    {path: "sshFixMetricsConfigMap", env: "SSH_FIX_METRICS_CONFIGMAP", kind: settingString},
+    {path: "sshFixFlapThreshold", env: "SSH_FIX_FLAP_THRESHOLD", kind: settingInteger, description: "Corrections of one VM's ssh_username before it is flagged as flapping and left alone, 0 never"},
+
+    {path: "dns.domain", env: "VM_DNS_DOMAIN", kind: settingString},
+    {path: "dns.ttl", env: "VM_DNS_TTL", kind: settingInteger, minimum: 1},
+
+    {path: "state.bucket", env: "STATE_BUCKET", kind: settingString},
+    {path: "state.prefix", env: "STATE_PREFIX", kind: settingString},
+    {path: "state.endpoint", env: "STATE_ENDPOINT", kind: settingString},
+    {path: "state.snapshotMinutes", env: "STATE_SNAPSHOT_MINUTES", kind: settingInteger, minimum: 1},
+    {path: "state.retentionDays", env: "STATE_RETENTION_DAYS", kind: settingInteger, minimum: 1},
+    {path: "state.restoreKey", env: "STATE_RESTORE_KEY", kind: settingString},
+
+    {path: "artifacts.bucket", env: "ARTIFACTS_BUCKET", kind: settingString},
+    {path: "artifacts.prefix", env: "ARTIFACTS_PREFIX", kind: settingString},
+    {path: "artifacts.endpoint", env: "ARTIFACTS_ENDPOINT", kind: settingString},
+    {path: "artifacts.retentionDays", env: "ARTIFACTS_RETENTION_DAYS", kind: settingInteger, minimum: 1},
+
+    {path: "netbox.url", env: "NETBOX_URL", kind: settingString},
+    {path: "netbox.objects", env: "NETBOX_OBJECTS", kind: settingString, enum: []string{"virtual-machines", "devices"}},
+    {path: "netbox.role", env: "NETBOX_ROLE", kind: settingString},
+    {path: "netbox.tags", env: "NETBOX_TAG", kind: settingList},
+    {path: "netbox.environment", env: "NETBOX_ENVIRONMENT", kind: settingString},
+    {path: "netbox.syncIntervalMinutes", env: "NETBOX_SYNC_INTERVAL_MINUTES", kind: settingInteger, minimum: 1},
+}
+
+// Values of the loaded configuration file by environment variable name
+var configFile = struct {
+    sync.RWMutex
+    path   string
+    values map[string]string
+}{values: map[string]string{}}
+
+// A setting's value: the environment variable when set, else the configuration
+// file's, else empty so the setting's getter applies its default
+func Setting(name string) string {
+    value, _ := lookupSetting(name)
+    return value
+}
+
+// A setting's value and whether either source sets it, even to nothing
+func lookupSetting(name string) (string, bool) {
+    if value, set := os.LookupEnv(name); set && value != "" {
+        return value, true
+    }
+    configFile.RLock()
+    defer configFile.RUnlock()
+    if value, set := configFile.values[name]; set {
+        return value, true
+    }
+    return os.LookupEnv(name)
+}
+
+// Load and check the configuration file given with --config. Any problem fails
+// the whole file, listing every problem, so nothing starts on half a configuration.
+func LoadConfigFile(path string) error {
+    data, err := os.ReadFile(path)
+    if err != nil {
+        return err
+    }
+    values, problems := parseConfigFile(data)
+    if len(problems) > 0 {
+        return fmt.Errorf("invalid configuration file %s:\n  %s", path, strings.Join(problems, "\n  "))
+    }
+
+    configFile.Lock()
+    configFile.path = path
+    configFile.values = values
+    configFile.Unlock()
+    return nil
+}
+
+// The settings in data by environment variable name, or what is wrong with them
+func parseConfigFile(data []byte) (map[string]string, []string) {
+    jsonData, err := yaml.YAMLToJSONStrict(data)
+    if err != nil {
+        return nil, []string{err.Error()}
+    }
+    var document map[string]interface{}
+    decoder := json.NewDecoder(bytes.NewReader(jsonData))
+    decoder.UseNumber()
+    if err := decoder.Decode(&document); err != nil {
+        return nil, []string{"the file must be a YAML mapping: " + err.Error()}
+    }
+
+    schema := make(map[string]configSetting, len(configSettings))
+    for _, setting := range configSettings {
+        schema[setting.path] = setting
+    }
+
+    values := map[string]string{}
+    var problems []string
+    var walk func(prefix string, node map[string]interface{})
+    walk = func(prefix string, node map[string]interface{}) {
+        for key, value := range node {
+            path := prefix + key
+            if section, isSection := value.(map[string]interface{}); isSection {
+                if _, isSetting := schema[path]; !isSetting && hasSettingsUnder(path) {
+                    walk(path+".", section)
+                    continue
+                }
+            }
+            setting, known := schema[path]
+            switch {
+            case !known && hasSettingsUnder(path):
+                problems = append(problems, fmt.Sprintf("%s: expected a mapping of settings", path))
+                continue
+            case !known:
+                problems = append(problems, fmt.Sprintf("%s: unknown setting", path))
+                continue
+            }
+            if value == nil {
+                continue
+            }
+            text, err := setting.parse(value)
+            if err != nil {
+                problems = append(problems, fmt.Sprintf("%s: %v", path, err))
+                continue
+            }
+            values[setting.env] = text
+        }
+    }
+    walk("", document)
+    sort.Strings(problems)
+    return values, problems
+}
+
+func hasSettingsUnder(section string) bool {
+    for _, setting := range configSettings {
+        if strings.HasPrefix(setting.path, section+".") {
+            return true
+        }
+    }
+    return false
+}
+
+// The value as the environment variable would spell it, checked against the setting
+func (setting configSetting) parse(value interface{}) (string, error) {
+    switch setting.kind {
+    case settingString:
+        text, ok := value.(string)
+        if !ok {
+            return "", fmt.Errorf("expected a string")
+        }
+        if len(setting.enum) > 0 && !containsString(setting.enum, text) {
+            return "", fmt.Errorf("%q is not one of %s", text, strings.Join(setting.enum, ", "))
+        }
+        return text, nil
+    case settingBoolean:
+        flag, ok := value.(bool)
+        if !ok {
+            return "", fmt.Errorf("expected true or false")
+        }
+        return strconv.FormatBool(flag), nil
+    case settingInteger, settingNumber:
+        number, ok := value.(json.Number)
+        if !ok {
+            return "", fmt.Errorf("expected a number")
+        }
+        parsed, err := number.Float64()
+        if err != nil || (setting.kind == settingInteger && parsed != float64(int64(parsed))) {
+            return "", fmt.Errorf("expected %s %s", article(setting.kind), setting.kind)
+        }
+        if parsed < setting.minimum || (setting.maximum != 0 && parsed > setting.maximum) {
+            return "", fmt.Errorf("%s is out of range %s", number, setting.rangeText())
+        }
+        return number.String(), nil
+    case settingDuration:
+        text, ok := value.(string)
+        if !ok {
+            return "", fmt.Errorf("expected a duration such as 30s or 5m")
+        }
+        if d, err := time.ParseDuration(text); err != nil || d <= 0 {
+            return "", fmt.Errorf("%q is not a positive duration such as 30s or 5m", text)
+        }
+        return text, nil
+    case settingList:
+        items, ok := value.([]interface{})
+        if !ok {
+            return "", fmt.Errorf("expected a list")
+        }
+        var texts []string
+        for _, item := range items {
+            text, ok := item.(string)
+            if !ok || strings.Contains(text, ",") {
+                return "", fmt.Errorf("list items must be strings without commas")
+            }
+            texts = append(texts, text)
+        }
+        return strings.Join(texts, ","), nil
+    }
+    return "", fmt.Errorf("unsupported setting kind %s", setting.kind)
+}
+
+func (setting configSetting) rangeText() string {
+    if setting.maximum != 0 {
+        return fmt.Sprintf("%g to %g", setting.minimum, setting.maximum)
+    }
+    return fmt.Sprintf("%g and up", setting.minimum)
+}
+
+func article(kind string) string {
+    if kind == settingInteger {
+        return "an"
+    }
+    return "a"
+}
+
+func containsString(values []string, value string) bool {
+    for _, candidate := range values {
+        if candidate == value {
+            return true
+        }
+    }
+    return false
+}
+
+// The configuration file's schema as JSON Schema, for editors and CI checks
+func ConfigFileSchema() map[string]interface{} {
+    root := map[string]interface{}{
+        "$schema":              "https://json-schema.org/draft/2020-12/schema",
+        "title":                "hobbyfarm-vm-provisioner configuration",
+        "type":                 "object",
+        "additionalProperties": false,
+        "properties":           map[string]interface{}{},
+    }
+    for _, setting := range configSettings {
+        parts := strings.Split(setting.path, ".")
+        node := root
+        for _, part := range parts[:len(parts)-1] {
+            properties := node["properties"].(map[string]interface{})
+            child, exists := properties[part].(map[string]interface{})
+            if !exists {
+                child = map[string]interface{}{
+                    "type":                 "object",
+                    "additionalProperties": false,
+                    "properties":           map[string]interface{}{},
+                }
+                properties[part] = child
+            }
+            node = child
+        }
+        node["properties"].(map[string]interface{})[parts[len(parts)-1]] = setting.jsonSchema()
+    }
+    return root
+}
+
+func (setting configSetting) jsonSchema() map[string]interface{} {
+    description := "Overridden by " + setting.env
+    if setting.description != "" {
+        description = setting.description + ". " + description
+    }
+    schema := map[string]interface{}{"description": description}
+    switch setting.kind {
+    case settingDuration:
+        schema["type"] = "string"
+        schema["pattern"] = `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`
+    case settingList:
+        schema["type"] = "array"
+        schema["items"] = map[string]interface{}{"type": "string", "pattern": "^[^,]*$"}
+    case settingInteger, settingNumber:
+        schema["type"] = setting.kind
+        schema["minimum"] = setting.minimum
+        if setting.maximum != 0 {
+            schema["maximum"] = setting.maximum
+        }
+    default:
+        schema["type"] = setting.kind
+    }
+    if len(setting.enum) > 0 {
+        schema["enum"] = setting.enum
+    }
+    return schema
+}
+
+// Settings taken from the file and those an environment variable overrides, for the startup log
+func configFileSummary() (string, int, []string) {
+    configFile.RLock()
+    defer configFile.RUnlock()
+    var overridden []string
+    for name := range configFile.values {
+        if value, set := os.LookupEnv(name); set && value != "" {
+            overridden = append(overridden, name)
+        }
+    }
+    sort.Strings(overridden)
+    return configFile.path, len(configFile.values), overridden
+}
+
+func validateConfigFile(check *ConfigCheck) {
+    path, count, overridden := configFileSummary()
+    if path == "" {
+        return
+    }
+    if count == 0 {
+        check.warn("configuration file %s sets nothing", path)
+    }
+    if len(overridden) > 0 {
+        check.warn("environment overrides %s of configuration file %s", strings.Join(overridden, ", "), path)
+    }
+}
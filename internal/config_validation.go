@@ -0,0 +1,357 @@
+// internal/config_validation.go - Check the configuration at startup and fail fast with one report
+package internal
+
+import (
+    "context"
+    "crypto/tls"
+    "crypto/x509"
+    "encoding/json"
+    "fmt"
+    "net"
+    "net/url"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    // Crossplane AWS provider config the EC2 claims reference, cluster scoped
+    awsProviderConfigGVR = schema.GroupVersionResource{
+        Group:    "aws.upbound.io",
+        Version:  "v1beta1",
+        Resource: "providerconfigs",
+    }
+)
+
+const awsProviderConfigName = "aws-provider"
+
+// Playbooks the provisioner runs on its own, whatever the scenario asks for
+var requiredPlaybooks = []string{"base.yaml", "dynamic.yaml", dataVolumesPlaybook, callbackPlaybook, "overlay-join.yaml", sessionTimePlaybook}
+
+// Directory holding tls.crt and tls.key for the webhook server; empty serves plain HTTP
+func getWebhookCertDir() string {
+    return Setting("WEBHOOK_CERT_DIR")
+}
+
+// CONFIG_VALIDATION=warn logs problems but starts anyway
+func configValidationStrict() bool {
+    return Setting("CONFIG_VALIDATION") != "warn"
+}
+
+type ConfigCheck struct {
+    Name     string
+    Problems []string
+    Warnings []string
+}
+
+type ConfigReport struct {
+    Checks []*ConfigCheck
+}
+
+func (report *ConfigReport) check(name string) *ConfigCheck {
+    check := &ConfigCheck{Name: name}
+    report.Checks = append(report.Checks, check)
+    return check
+}
+
+func (check *ConfigCheck) fail(format string, args ...interface{}) {
+    check.Problems = append(check.Problems, fmt.Sprintf(format, args...))
+}
+
+func (check *ConfigCheck) warn(format string, args ...interface{}) {
+    check.Warnings = append(check.Warnings, fmt.Sprintf(format, args...))
+}
+
+func (report *ConfigReport) Failed() bool {
+    for _, check := range report.Checks {
+        if len(check.Problems) > 0 {
+            return true
+        }
+    }
+    return false
+}
+
+// Whether startup should stop on this report
+func (report *ConfigReport) Fatal() bool {
+    return report.Failed() && configValidationStrict()
+}
+
+func (report *ConfigReport) String() string {
+    var lines []string
+    for _, check := range report.Checks {
+        switch {
+        case len(check.Problems) > 0:
+            lines = append(lines, "❌ "+check.Name)
+        case len(check.Warnings) > 0:
+            lines = append(lines, "⚠️ "+check.Name)
+        default:
+            lines = append(lines, "✅ "+check.Name)
+        }
+        for _, problem := range check.Problems {
+            lines = append(lines, "    - "+problem)
+        }
+        for _, warning := range check.Warnings {
+            lines = append(lines, "    - "+warning)
+        }
+    }
+    return strings.Join(lines, "\n")
+}
+
+// Run every check; nothing stops at the first problem so the report lists them all
+func ValidateConfig(client dynamic.Interface) *ConfigReport {
+    report := &ConfigReport{}
+    validateConfigFile(report.check("Configuration file"))
+    validateComponent(report.check("Component"))
+    validatePools(report.check("Static VM pools"))
+    validatePoolSharing(report.check("Pool sharing"))
+    // The webhook component runs no Ansible and mounts no SSH key or playbooks
+    if Component() != ComponentWebhook {
+        validateSSHKey(report.check("SSH key"))
+        validatePlaybooks(report.check("Playbooks"))
+        validatePlaybookBundles(report.check("Playbook bundles"))
+        validatePlaybookCanary(report.check("Playbook canary"))
+    }
+    validateCloudProvider(client, report.check("Cloud provider"))
+    validateSSHUsers(report.check("SSH users"))
+    validateWebhookCerts(report.check("Webhook certificate"))
+    validateMTLS(report.check("Mutual TLS"))
+    validateAdminListener(report.check("Admin listener"))
+    validateOutbound(report.check("Outbound proxy and CAs"))
+    validateSharding(report.check("Sharding"))
+    validateEventBus(report.check("Event bus"))
+    validateNetBox(report.check("NetBox"))
+    validateSystemSettings(report.check("VM system settings"))
+    validateTenants(report.check("Tenants"))
+    validateScaleDownSchedule(report.check("Scale-down schedule"))
+    validateUserMetadata(report.check("User metadata"))
+    validateVMStatusFields(report.check("VirtualMachine status fields"))
+    validateProvisioningProfiles(report.check("Provisioning profiles"))
+    validateRequestHooks(report.check("Request hooks"))
+    validatePackageDetectors(report.check("Package detectors"))
+    validateStateSnapshots(report.check("State snapshots"))
+    validateReadySLO(report.check("Ready SLO"))
+    validatePermissions(client, report.check("RBAC permissions"))
+    return report
+}
+
+// STATIC_VM_POOL and every environment pool hold valid, unique IPs
+func validatePools(check *ConfigCheck) {
+    for _, ip := range splitEnvList("STATIC_VM_POOL") {
+        if net.ParseIP(ip) == nil {
+            check.fail("STATIC_VM_POOL: %q is not an IP address", ip)
+        }
+    }
+    for _, cidr := range getDiscoveryCIDRs() {
+        if _, err := cidrHosts(cidr); err != nil {
+            check.fail("POOL_DISCOVERY_CIDRS: %v", err)
+        }
+    }
+    for _, cidr := range getAdoptVMCIDRs() {
+        if _, _, err := net.ParseCIDR(cidr); err != nil {
+            check.fail("ADOPT_VM_CIDRS: %q is not a CIDR", cidr)
+        }
+    }
+
+    data, err := os.ReadFile(getEnvironmentsFile())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            check.fail("cannot read %s: %v", getEnvironmentsFile(), err)
+        }
+        return
+    }
+    environments := map[string]vmEnvironment{}
+    if err := json.Unmarshal(data, &environments); err != nil {
+        check.fail("%s is not valid JSON: %v", getEnvironmentsFile(), err)
+        return
+    }
+
+    owner := map[string]string{}
+    for name, environment := range environments {
+        for _, ip := range environment.StaticVMs {
+            if net.ParseIP(ip) == nil {
+                check.fail("environment %s: %q is not an IP address", name, ip)
+                continue
+            }
+            if other, taken := owner[ip]; taken {
+                check.fail("%s is in the pools of both %s and %s", ip, other, name)
+            }
+            owner[ip] = name
+        }
+        if environment.WSEndpoint != "" {
+            if endpoint, err := url.Parse(environment.WSEndpoint); err != nil || endpoint.Host == "" {
+                check.fail("environment %s: invalid ws_endpoint %q", name, environment.WSEndpoint)
+            }
+        }
+    }
+}
+
+// The key Ansible and the SSH probes use is mounted and looks like a private key
+func validateSSHKey(check *ConfigCheck) {
+    keyPath := NewAnsibleRunner(nil).sshKeyPath
+    data, err := os.ReadFile(keyPath)
+    if err != nil {
+        check.fail("cannot read %s (mounted from Secret %s): %v", keyPath, getSSHKeySecretName(), err)
+        return
+    }
+    if !strings.Contains(string(data), "PRIVATE KEY") {
+        check.fail("%s is not a PEM or OpenSSH private key", keyPath)
+    }
+}
+
+// Internal playbooks exist, and so does whatever runs them
+func validatePlaybooks(check *ConfigCheck) {
+    for _, playbook := range requiredPlaybooks {
+        if _, err := os.Stat(filepath.Join(defaultPlaybookPath, playbook)); err != nil {
+            check.fail("%s missing from %s", playbook, defaultPlaybookPath)
+        }
+    }
+
+    var runners []string
+    switch runtime := getEERuntime(); runtime {
+    case eeRuntimeLocal:
+        runners = []string{getAnsiblePlaybookBin()}
+        if python := Setting("ANSIBLE_PYTHON"); python != "" {
+            runners = append(runners, python)
+        }
+    case eeRuntimeVenv:
+        runners = []string{getAnsiblePython()}
+        if Setting("ANSIBLE_VERSION") == "" {
+            check.warn("ANSIBLE_VERSION not set, virtualenvs get the latest ansible-core unless the scenario pins one")
+        }
+    default:
+        runners = []string{runtime}
+    }
+    for _, runner := range runners {
+        if _, err := exec.LookPath(runner); err != nil {
+            check.fail("%s not found on PATH", runner)
+        }
+    }
+}
+
+// With cloud fallback on, the Crossplane provider config and its credentials Secret exist
+func validateCloudProvider(client dynamic.Interface, check *ConfigCheck) {
+    if Setting("ENABLE_EC2_FALLBACK") == "false" {
+        return
+    }
+    if client == nil {
+        check.warn("no cluster connection, provider config not checked")
+        return
+    }
+
+    ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+    defer cancel()
+
+    providerConfig, err := client.Resource(awsProviderConfigGVR).Get(ctx, awsProviderConfigName, metav1.GetOptions{})
+    if err != nil {
+        check.fail("Crossplane ProviderConfig %s: %v", awsProviderConfigName, err)
+        return
+    }
+
+    source, _, _ := unstructured.NestedString(providerConfig.Object, "spec", "credentials", "source")
+    if source != "Secret" {
+        return // IRSA, WebIdentity and friends carry no Secret to check
+    }
+    namespace, _, _ := unstructured.NestedString(providerConfig.Object, "spec", "credentials", "secretRef", "namespace")
+    name, _, _ := unstructured.NestedString(providerConfig.Object, "spec", "credentials", "secretRef", "name")
+    key, _, _ := unstructured.NestedString(providerConfig.Object, "spec", "credentials", "secretRef", "key")
+
+    secret, err := client.Resource(secretGVR).Namespace(namespace).Get(ctx, name, metav1.GetOptions{})
+    if err != nil {
+        check.fail("credentials Secret %s/%s of ProviderConfig %s: %v", namespace, name, awsProviderConfigName, err)
+        return
+    }
+    if value, _, _ := unstructured.NestedString(secret.Object, "data", key); value == "" {
+        check.fail("credentials Secret %s/%s has no %s key", namespace, name, key)
+    }
+}
+
+// The API server only calls admission webhooks over HTTPS
+func validateWebhookCerts(check *ConfigCheck) {
+    if Setting("ENABLE_WEBHOOK") != "true" {
+        return
+    }
+    certDir := getWebhookCertDir()
+    if certDir == "" {
+        check.warn("WEBHOOK_CERT_DIR not set, serving plain HTTP; the API server will not call /mutate")
+        return
+    }
+
+    certFile, keyFile := filepath.Join(certDir, "tls.crt"), filepath.Join(certDir, "tls.key")
+    pair, err := tls.LoadX509KeyPair(certFile, keyFile)
+    if err != nil {
+        check.fail("cannot load %s and %s: %v", certFile, keyFile, err)
+        return
+    }
+    cert, err := x509.ParseCertificate(pair.Certificate[0])
+    if err != nil {
+        check.fail("cannot parse %s: %v", certFile, err)
+        return
+    }
+    if time.Now().After(cert.NotAfter) {
+        check.fail("%s expired on %s", certFile, cert.NotAfter.Format(time.RFC3339))
+    } else if time.Until(cert.NotAfter) < 7*24*time.Hour {
+        check.warn("%s expires on %s", certFile, cert.NotAfter.Format(time.RFC3339))
+    }
+}
+
+// With NETBOX_URL set, the API answers the pool query with the configured token
+func validateNetBox(check *ConfigCheck) {
+    if !netboxEnabled() {
+        return
+    }
+    if endpoint, err := url.Parse(getNetBoxURL()); err != nil || endpoint.Host == "" {
+        check.fail("invalid NETBOX_URL %q", getNetBoxURL())
+        return
+    }
+    if Setting("NETBOX_TOKEN") == "" {
+        check.warn("NETBOX_TOKEN not set, querying NetBox anonymously")
+    }
+    if Setting("NETBOX_ROLE") == "" && Setting("NETBOX_TAG") == "" {
+        check.warn("neither NETBOX_ROLE nor NETBOX_TAG set, every active host in NetBox joins the pools")
+    }
+
+    query := getNetBoxQuery()
+    query.Set("limit", "1")
+    if _, err := netboxGet(getNetBoxURL() + getNetBoxObjectsPath() + "?" + query.Encode()); err != nil {
+        check.fail("NetBox query failed: %v", err)
+    }
+}
+
+// Proxies parse as URLs and the CA bundle is a readable PEM file, for the default
+// settings and every environment overriding them
+func validateSystemSettings(check *ConfigCheck) {
+    environments := loadVMEnvironments()
+    names := make([]string, 0, len(environments))
+    for name := range environments {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, name := range names {
+        env := withSystemSettingDefaults(environments[name])
+        for setting, proxy := range map[string]string{"http proxy": env.HTTPProxy, "https proxy": env.HTTPSProxy, "APT proxy": env.APTProxy} {
+            if proxy == "" {
+                continue
+            }
+            if endpoint, err := url.Parse(proxy); err != nil || endpoint.Host == "" {
+                check.fail("environment %s: invalid %s %q", name, setting, proxy)
+            }
+        }
+        if env.CABundleFile != "" {
+            data, err := os.ReadFile(env.CABundleFile)
+            if err != nil {
+                check.fail("environment %s: cannot read CA bundle: %v", name, err)
+            } else if !strings.Contains(string(data), "BEGIN CERTIFICATE") {
+                check.fail("environment %s: %s holds no PEM certificate", name, env.CABundleFile)
+            }
+        }
+    }
+}
@@ -0,0 +1,201 @@
+// internal/course_status.go - One CourseProvisioningStatus per class, aggregated from its requests
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "regexp"
+    "sort"
+    "strconv"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+var invalidNameChars = regexp.MustCompile(`[^a-z0-9-]+`)
+
+func getCourseStatusInterval() time.Duration {
+    if seconds, err := strconv.Atoi(Setting("COURSE_STATUS_INTERVAL_SECONDS")); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    return 30 * time.Second
+}
+
+// The course label set from the HobbyFarm Session, the scenario for requests
+// created without one
+func requestCourse(request *unstructured.Unstructured) string {
+    if course := request.GetLabels()[provisioner.CourseLabel]; course != "" {
+        return course
+    }
+    scenario, _, _ := unstructured.NestedString(request.Object, "spec", "scenario")
+    return scenario
+}
+
+// Object name of a course's status, a DNS subdomain derived from the course
+func courseStatusName(course string) string {
+    name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(course), "-"), "-")
+    if len(name) > 240 {
+        name = name[:240]
+    }
+    return "course-" + name
+}
+
+type courseAggregate struct {
+    status       provisioner.CourseProvisioningStatus
+    readyTotal   time.Duration
+    readyCounted int64
+}
+
+func (aggregate *courseAggregate) add(request *unstructured.Unstructured) {
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    switch state {
+    case provisioner.StateReleased:
+        aggregate.status.Released++
+        return
+    case provisioner.StateReady:
+        aggregate.status.Ready++
+        readyAt, _, _ := unstructured.NestedString(request.Object, "status", "readyAt")
+        if ready, err := time.Parse(time.RFC3339, readyAt); err == nil {
+            aggregate.readyTotal += ready.Sub(request.GetCreationTimestamp().Time)
+            aggregate.readyCounted++
+        }
+    case provisioner.StateFailed:
+        aggregate.status.Failed++
+        session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+        user, _, _ := unstructured.NestedString(request.Object, "spec", "user")
+        lastError, _, _ := unstructured.NestedString(request.Object, "status", "lastError")
+        failureReason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+        aggregate.status.FailedSessions = append(aggregate.status.FailedSessions, provisioner.CourseFailedSession{
+            Session:       session,
+            Request:       request.GetName(),
+            User:          user,
+            LastError:     lastError,
+            FailureReason: failureReason,
+        })
+    default:
+        aggregate.status.Pending++
+    }
+    aggregate.status.Total++
+}
+
+type CourseStatusController struct {
+    client dynamic.Interface
+}
+
+func NewCourseStatusController(client dynamic.Interface) *CourseStatusController {
+    return &CourseStatusController{client: client}
+}
+
+func (cs *CourseStatusController) WatchCourseStatuses() {
+    log.Printf("📊 Starting course status aggregation every %v", getCourseStatusInterval())
+
+    for {
+        cs.aggregate()
+        Heartbeat(cs.client, HeartbeatCourseStatus)
+        time.Sleep(getCourseStatusInterval())
+    }
+}
+
+func (cs *CourseStatusController) aggregate() {
+    if !apiAvailable(apiKratix) || !apiAvailable(apiTraining) || !clusterReachable() {
+        return
+    }
+    requests, err := cs.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        log.Printf("⚠️ Could not list requests for course status: %v", err)
+        recordLastError(HeartbeatCourseStatus, "listing VMProvisioningRequests: %v", err)
+        return
+    }
+
+    courses := map[string]*courseAggregate{}
+    for i := range requests.Items {
+        course := requestCourse(&requests.Items[i])
+        if course == "" {
+            continue
+        }
+        if courses[course] == nil {
+            courses[course] = &courseAggregate{}
+        }
+        courses[course].add(&requests.Items[i])
+    }
+
+    now := time.Now().Format(time.RFC3339)
+    current := map[string]bool{}
+    for course, aggregate := range courses {
+        if aggregate.readyCounted > 0 {
+            aggregate.status.AverageReadySeconds = int64((aggregate.readyTotal / time.Duration(aggregate.readyCounted)).Seconds())
+        }
+        sort.Slice(aggregate.status.FailedSessions, func(i, j int) bool {
+            return aggregate.status.FailedSessions[i].Session < aggregate.status.FailedSessions[j].Session
+        })
+        aggregate.status.UpdatedAt = now
+        aggregate.status.DisplayName = courseDisplayName(cs.client, course)
+
+        name := courseStatusName(course)
+        current[name] = true
+        if err := cs.writeCourseStatus(name, course, aggregate.status); err != nil {
+            log.Printf("⚠️ Could not update course status %s: %v", name, err)
+        }
+    }
+
+    // A course whose requests are all gone has nothing left to monitor
+    existing, err := cs.client.Resource(courseStatusGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "app=hobbyfarm-provisioner",
+    })
+    if err != nil {
+        return
+    }
+    for _, status := range existing.Items {
+        if !current[status.GetName()] {
+            cs.client.Resource(courseStatusGVR).Namespace("default").Delete(context.TODO(), status.GetName(), metav1.DeleteOptions{})
+        }
+    }
+}
+
+func (cs *CourseStatusController) writeCourseStatus(name, course string, status provisioner.CourseProvisioningStatus) error {
+    _, err := cs.client.Resource(courseStatusGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        courseStatus := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "training.example.com/v1",
+                "kind":       "CourseProvisioningStatus",
+                "metadata": map[string]interface{}{
+                    "name":      name,
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "spec": map[string]interface{}{
+                    "course": course,
+                },
+            },
+        }
+        stampProvenance(courseStatus)
+        _, err = cs.client.Resource(courseStatusGVR).Namespace("default").Create(context.TODO(), courseStatus, metav1.CreateOptions{})
+    }
+    if err != nil {
+        return err
+    }
+
+    // A merge patch keeps fields it doesn't mention; null clears the failed
+    // sessions and average once they no longer apply
+    statusBytes, _ := json.Marshal(status)
+    statusMap := map[string]interface{}{}
+    json.Unmarshal(statusBytes, &statusMap)
+    for _, field := range []string{"failedSessions", "averageReadySeconds"} {
+        if _, set := statusMap[field]; !set {
+            statusMap[field] = nil
+        }
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": statusMap})
+    return patchStatus(cs.client, courseStatusGVR, "default", name, patchBytes)
+}
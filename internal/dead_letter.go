@@ -0,0 +1,275 @@
+// internal/dead_letter.go - Failed requests retried with backoff, then kept in a dead-letter list until an operator re-drives them
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+const deadLetterLabel = provisioner.DeadLetterLabel
+
+// Automatic retries of a failed request before it is dead-lettered; 0 dead-letters
+// every failure at once
+func getFailedRequestRetries() int64 {
+    if retries, err := strconv.ParseInt(Setting("FAILED_REQUEST_RETRIES"), 10, 64); err == nil && retries >= 0 {
+        return retries
+    }
+    return 2
+}
+
+// Wait after a failure before the next retry: 2, 4, 8 minutes and so on, at most an hour
+func failedRetryDelay(retryCount int64) time.Duration {
+    delay := 2 * time.Minute
+    for i := int64(0); i < retryCount && delay < time.Hour; i++ {
+        delay *= 2
+    }
+    if delay > time.Hour {
+        delay = time.Hour
+    }
+    return delay
+}
+
+// Failures an operator has to fix first, a quota or the tenant and adoption
+// configuration; retrying them only fails again
+func retryableFailure(reason string) bool {
+    switch reason {
+    case failureCloudQuota, failureTenantRefused, failureAdoptionRefused:
+        return false
+    }
+    return true
+}
+
+func isDeadLettered(request *unstructured.Unstructured) bool {
+    return request.GetLabels()[deadLetterLabel] == "true"
+}
+
+// Retry failed requests whose backoff is over and dead-letter the ones out of
+// retries. Failures from before failedAt was recorded are dead-lettered too, so
+// an upgrade doesn't retry requests that failed long ago.
+func (kc *KratixController) retryFailedRequests() {
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    maxRetries := getFailedRequestRetries()
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state != provisioner.StateFailed || isDeadLettered(request) || !ownsRequest(request) {
+            continue
+        }
+        reason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+        retries, _, _ := unstructured.NestedInt64(request.Object, "status", "retryCount")
+        failedAtValue, _, _ := unstructured.NestedString(request.Object, "status", "failedAt")
+        failedAt, err := time.Parse(time.RFC3339, failedAtValue)
+
+        switch {
+        case err != nil:
+            kc.deadLetter(request, "failed before failures were retried")
+        case !retryableFailure(reason):
+            kc.deadLetter(request, fmt.Sprintf("%s is not retried", reason))
+        case retries >= maxRetries:
+            kc.deadLetter(request, fmt.Sprintf("failed again after %d retries", retries))
+        case time.Since(failedAt) >= failedRetryDelay(retries):
+            log.Printf("🔁 Retrying failed request %s (%s), retry %d of %d", request.GetName(), reason, retries+1, maxRetries)
+            if err := retryFailedRequest(kc.client, request, retries+1); err != nil {
+                log.Printf("⚠️ Could not retry request %s: %v", request.GetName(), err)
+            }
+        }
+    }
+}
+
+// Label the request so cleanup keeps it and operators find it, with an Event saying why
+func (kc *KratixController) deadLetter(request *unstructured.Unstructured, why string) {
+    requestName := request.GetName()
+    patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:"true"}}}`, deadLetterLabel))
+    if _, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
+        context.TODO(), requestName, types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+        log.Printf("⚠️ Could not dead-letter request %s: %v", requestName, err)
+        return
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{"deadLetteredAt": time.Now().Format(time.RFC3339)},
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Could not record when request %s was dead-lettered: %v", requestName, err)
+    }
+
+    lastError, _, _ := unstructured.NestedString(request.Object, "status", "lastError")
+    log.Printf("🪦 Request %s dead-lettered, %s: %s", requestName, why, lastError)
+    recordEvent(kc.client, request, eventTypeWarning, "DeadLettered", fmt.Sprintf("%s; re-drive it once the cause is fixed: %s", why, lastError))
+}
+
+// Send a failed request back for another attempt. A playbook failure resumes on
+// the same VM from the playbook that failed; otherwise a failed cloud instance is
+// not reused and the retry gets a fresh one.
+func retryFailedRequest(client dynamic.Interface, request *unstructured.Unstructured, retryCount int64) error {
+    requestName := request.GetName()
+    if playbookResumeEnabled() && resumableOnSameVM(client, request) {
+        return resetRequestForResume(client, requestName, retryCount)
+    }
+    if instance, err := findCloudInstanceForRequest(client, requestName); err == nil && instance != nil {
+        if err := client.Resource(ec2TrainingVMGVR).Namespace("default").Delete(
+            context.TODO(), instance.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+            return fmt.Errorf("failed to delete cloud instance %s: %v", instance.GetName(), err)
+        }
+    }
+    return resetRequestToPending(client, requestName, retryCount)
+}
+
+// Retry a failed request on an operator's say, with a fresh retry budget
+func redriveFailedRequest(client dynamic.Interface, request *unstructured.Unstructured) error {
+    if err := retryFailedRequest(client, request, 0); err != nil {
+        return err
+    }
+    clearDeadLetter(client, request)
+    return nil
+}
+
+func clearDeadLetter(client dynamic.Interface, request *unstructured.Unstructured) {
+    if !isDeadLettered(request) {
+        return
+    }
+    patch := []byte(fmt.Sprintf(`{"metadata":{"labels":{%q:null}}}`, deadLetterLabel))
+    if _, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
+        context.TODO(), request.GetName(), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+        log.Printf("⚠️ Could not take request %s off the dead-letter list: %v", request.GetName(), err)
+    }
+}
+
+// A dead-lettered request as GET /dead-letter and the dead-letter subcommand list it
+type DeadLetter struct {
+    Request  string `json:"request"`
+    Session  string `json:"session,omitempty"`
+    User     string `json:"user,omitempty"`
+    Scenario string `json:"scenario,omitempty"`
+    // failed, or released once its session ended; only failed ones can be re-driven
+    State          string `json:"state"`
+    FailureReason  string `json:"failureReason,omitempty"`
+    LastError      string `json:"lastError,omitempty"`
+    RetryCount     int64  `json:"retryCount"`
+    FailedAt       string `json:"failedAt,omitempty"`
+    DeadLetteredAt string `json:"deadLetteredAt,omitempty"`
+}
+
+// Dead-lettered requests, the longest-standing first
+func ListDeadLetters(client dynamic.Interface) ([]DeadLetter, error) {
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: deadLetterLabel + "=true",
+    })
+    if err != nil {
+        return nil, err
+    }
+    letters := make([]DeadLetter, 0, len(requests.Items))
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        letter := DeadLetter{Request: request.GetName(), Session: GetHobbyFarmSessionFromRequest(request)}
+        if letter.Session == "" {
+            letter.Session, _, _ = unstructured.NestedString(request.Object, "spec", "session")
+        }
+        letter.User, _, _ = unstructured.NestedString(request.Object, "spec", "user")
+        letter.Scenario, _, _ = unstructured.NestedString(request.Object, "spec", "scenario")
+        letter.State, _, _ = unstructured.NestedString(request.Object, "status", "state")
+        letter.FailureReason, _, _ = unstructured.NestedString(request.Object, "status", "failureReason")
+        letter.LastError, _, _ = unstructured.NestedString(request.Object, "status", "lastError")
+        letter.RetryCount, _, _ = unstructured.NestedInt64(request.Object, "status", "retryCount")
+        letter.FailedAt, _, _ = unstructured.NestedString(request.Object, "status", "failedAt")
+        letter.DeadLetteredAt, _, _ = unstructured.NestedString(request.Object, "status", "deadLetteredAt")
+        letters = append(letters, letter)
+    }
+    sort.Slice(letters, func(i, j int) bool { return letters[i].DeadLetteredAt < letters[j].DeadLetteredAt })
+    return letters, nil
+}
+
+type DeadLetterRedrive struct {
+    Redriven []string `json:"redriven"`
+    // <request>: why it was left on the list
+    Skipped []string `json:"skipped,omitempty"`
+    Errors  []string `json:"errors,omitempty"`
+}
+
+// Re-drive one dead-lettered request, or all that failed for reason, e.g. every
+// CloudQuota failure once the quota was raised. Requests whose session ended were
+// released and stay on the list for the record.
+func RedriveDeadLetters(client dynamic.Interface, requestName, reason string) (*DeadLetterRedrive, error) {
+    if (requestName == "") == (reason == "") {
+        return nil, fmt.Errorf("name either a request or a failure reason to re-drive")
+    }
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: deadLetterLabel + "=true",
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    result := &DeadLetterRedrive{Redriven: []string{}}
+    found := false
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        failureReason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+        if requestName != "" && request.GetName() != requestName || reason != "" && failureReason != reason {
+            continue
+        }
+        found = true
+        if state != provisioner.StateFailed {
+            result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s, its session is over", request.GetName(), state))
+            continue
+        }
+        if err := redriveFailedRequest(client, request); err != nil {
+            result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", request.GetName(), err))
+            continue
+        }
+        result.Redriven = append(result.Redriven, request.GetName())
+    }
+    if requestName != "" && !found {
+        return nil, fmt.Errorf("request %s is not on the dead-letter list", requestName)
+    }
+    log.Printf("📬 Re-drove %d dead-lettered requests, %d skipped, %d errors", len(result.Redriven), len(result.Skipped), len(result.Errors))
+    return result, nil
+}
+
+// GET /dead-letter lists the dead-lettered requests;
+// POST /dead-letter?request=<name> or ?reason=<failureReason> re-drives them.
+// Both only on the admin listener.
+func (ws *WebhookServer) deadLetterHandler(w http.ResponseWriter, r *http.Request) {
+    var response interface{}
+    var err error
+    switch r.Method {
+    case http.MethodGet:
+        response, err = ListDeadLetters(ws.client)
+    case http.MethodPost:
+        response, err = RedriveDeadLetters(ws.client, r.URL.Query().Get("request"), r.URL.Query().Get("reason"))
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    if redrive, ok := response.(*DeadLetterRedrive); ok && len(redrive.Errors) > 0 {
+        w.WriteHeader(http.StatusMultiStatus)
+    }
+    json.NewEncoder(w).Encode(response)
+}
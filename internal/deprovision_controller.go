@@ -0,0 +1,289 @@
+// internal/deprovision_controller.go - Tear down session VMs when a HobbyFarm Session finishes or is deleted
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+type DeprovisionController struct {
+    client        dynamic.Interface
+    ansibleRunner *AnsibleRunner
+}
+
+func NewDeprovisionController(client dynamic.Interface) *DeprovisionController {
+    return &DeprovisionController{
+        client:        client,
+        ansibleRunner: NewAnsibleRunner(client),
+    }
+}
+
+// HobbyFarm marks a Session finished when the user ends it or it expires
+func isSessionFinished(session *unstructured.Unstructured) bool {
+    finished, _, _ := unstructured.NestedBool(session.Object, "status", "finished")
+    return finished || session.GetDeletionTimestamp() != nil
+}
+
+// Watch Sessions and deprovision the VMs of finished or deleted ones
+func (dc *DeprovisionController) WatchSessionsForDeprovisioning() {
+    log.Println("🧹 Starting Session Deprovision Controller...")
+    log.Println("🎯 Watching for finished and deleted Sessions")
+
+    for {
+        // Paused while the API server is away, resumed by the first answer
+        if clusterReachable() {
+            dc.deprovisionSessions()
+        }
+        Heartbeat(dc.client, HeartbeatDeprovision)
+        time.Sleep(10 * time.Second)
+    }
+}
+
+func (dc *DeprovisionController) deprovisionSessions() {
+    sessions, err := dc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        log.Printf("⚠️ Could not list Sessions for deprovisioning: %v", err)
+        recordLastError(HeartbeatDeprovision, "listing Sessions: %v", err)
+        return
+    }
+
+    // A Session that is not listed has been deleted
+    finishedSessions := make(map[string]bool)
+    for i := range sessions.Items {
+        finishedSessions[sessions.Items[i].GetName()] = isSessionFinished(&sessions.Items[i])
+    }
+
+    dc.deprovisionKratixRequests(finishedSessions)
+    dc.releaseAnnotatedRequests()
+    dc.pruneReleasedRequests(finishedSessions)
+    dc.deprovisionTrainingVMs(finishedSessions)
+    dc.enforceMaxAllocationLifetime()
+}
+
+// Release the VMs of VMProvisioningRequests whose Session is finished or gone.
+// Requests of finished Sessions are kept as released so they are not recreated;
+// requests of deleted Sessions are removed, unless they are dead-lettered and
+// kept for the record.
+func (dc *DeprovisionController) deprovisionKratixRequests(finishedSessions map[string]bool) {
+    requests, err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "source=hobbyfarm-integration",
+    })
+    if err != nil {
+        return
+    }
+
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        requestName := request.GetName()
+        sessionName := GetHobbyFarmSessionFromRequest(request)
+        if sessionName == "" || !ownsSession(sessionName) {
+            continue
+        }
+
+        finished, exists := finishedSessions[sessionName]
+        if exists && !finished {
+            continue
+        }
+
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        deadLettered := isDeadLettered(request)
+        if state == provisioner.StateReleased && (exists || deadLettered) {
+            continue
+        }
+
+        log.Printf("🧹 Deprovisioning request %s of %s session %s", requestName, sessionEndReason(exists), sessionName)
+
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        dc.teardownRequestVM(request, sessionName)
+
+        if !exists && !deadLettered {
+            if err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Delete(
+                context.TODO(), requestName, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+                log.Printf("❌ Failed to delete request %s of deleted session %s: %v", requestName, sessionName, err)
+                continue
+            }
+        } else if err := dc.releaseRequest(requestName, provisioner.ReleaseSessionFinished); err != nil {
+            log.Printf("❌ Failed to release request %s of session %s: %v", requestName, sessionName, err)
+            continue
+        }
+
+        log.Printf("✅ Deprovisioned VM %s of session %s", vmIP, sessionName)
+    }
+}
+
+// Delete TrainingVMs of finished or deleted Sessions, which returns their IP to the pool
+func (dc *DeprovisionController) deprovisionTrainingVMs(finishedSessions map[string]bool) {
+    trainingVMs, err := dc.client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "hobbyfarm.io/session",
+    })
+    if err != nil {
+        return // TrainingVM CRD is absent in kratix-only installs
+    }
+
+    for _, tvm := range trainingVMs.Items {
+        name := tvm.GetName()
+        sessionName := objectSession(&tvm)
+        if !ownsSession(sessionName) {
+            continue
+        }
+
+        finished, exists := finishedSessions[sessionName]
+        if exists && !finished {
+            continue
+        }
+
+        log.Printf("🧹 Deprovisioning TrainingVM %s of %s session %s", name, sessionEndReason(exists), sessionName)
+
+        vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+        dc.teardownTrainingVM(&tvm, sessionName)
+
+        if err := dc.client.Resource(trainingVMGVR).Namespace("default").Delete(
+            context.TODO(), name, metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+            log.Printf("❌ Failed to delete TrainingVM %s of session %s: %v", name, sessionName, err)
+            continue
+        }
+
+        log.Printf("✅ Deprovisioned TrainingVM %s (VM %s) of session %s", name, vmIP, sessionName)
+    }
+}
+
+// Give back the VM a request holds: keep its cloud instance for reuse or terminate it,
+// or clean the static VM's session workspace, then remove its DNS record and session Secrets
+func (dc *DeprovisionController) teardownRequestVM(request *unstructured.Unstructured, sessionName string) {
+    requestName := request.GetName()
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+
+    if vmType == vmTypeAdopted {
+        // Not ours to terminate or pool; only the session's workspace goes
+        if vmIP != "" && (state == "provisioning" || state == stateProvisionedUnverified || state == "ready") {
+            dc.cleanupAdoptedVM(getRequestAccessIP(request), sessionName)
+        }
+    } else if vmType == "ec2" || (vmIP != "" && isPublicIP(vmIP)) {
+        if instance, err := findCloudInstanceForRequest(dc.client, requestName); err == nil && instance != nil {
+            if !dc.releaseCloudInstance(instance, sessionName) {
+                dc.terminateCloudInstance(instance.GetName(), sessionName)
+            }
+        }
+    } else if vmIP != "" && (state == "provisioning" || state == stateProvisionedUnverified || state == "ready") {
+        dc.cleanupStaticVM(getRequestAccessIP(request), sessionName)
+    }
+    // Also when the request failed before its instance was launched
+    if len(getRequestExposedPorts(request)) > 0 {
+        deleteSessionSecurityGroup(dc.client, requestName)
+    }
+
+    dc.deleteVMDNSRecord(requestName)
+    dc.deleteSessionSecrets(sessionName)
+}
+
+// Same for a direct-mode TrainingVM; the caller deletes the TrainingVM to free its IP
+func (dc *DeprovisionController) teardownTrainingVM(tvm *unstructured.Unstructured, sessionName string) {
+    vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+    provisioned, _, _ := unstructured.NestedBool(tvm.Object, "status", "provisioned")
+
+    if vmIP != "" && isPublicIP(vmIP) {
+        dc.terminateCloudInstance(ec2InstanceName(tvm.GetName()), sessionName)
+    } else if vmIP != "" && provisioned {
+        dc.cleanupStaticVM(vmIP, sessionName)
+    }
+
+    dc.deleteSessionSecrets(sessionName)
+}
+
+func sessionEndReason(exists bool) string {
+    if exists {
+        return "finished"
+    }
+    return "deleted"
+}
+
+// Remove the session workspace and services so the static VM can be reused.
+// A failed cleanup is logged but does not keep the VM allocated.
+func (dc *DeprovisionController) cleanupStaticVM(vmIP, sessionName string) {
+    recordPoolEvent(dc.client, vmIP, eventTypeNormal, reasonVMReleased, "Session/"+sessionName, "")
+    if !isVMReachable(vmIP) {
+        log.Printf("⚠️ VM %s not reachable, skipping workspace cleanup for session %s", vmIP, sessionName)
+        return
+    }
+    if err := dc.ansibleRunner.CleanupSession(vmIP, sessionName); err != nil {
+        log.Printf("⚠️ Workspace cleanup failed for session %s on VM %s: %v", sessionName, vmIP, err)
+    }
+}
+
+// Same as for a static VM, without the pool Event: the machine is in no pool
+func (dc *DeprovisionController) cleanupAdoptedVM(vmIP, sessionName string) {
+    if !isVMReachable(vmIP) {
+        log.Printf("⚠️ Adopted VM %s not reachable, skipping workspace cleanup for session %s", vmIP, sessionName)
+        return
+    }
+    if err := dc.ansibleRunner.CleanupSession(vmIP, sessionName); err != nil {
+        log.Printf("⚠️ Workspace cleanup failed for session %s on adopted VM %s: %v", sessionName, vmIP, err)
+    }
+}
+
+// Deleting the EC2TrainingVM terminates the instance
+func (dc *DeprovisionController) terminateCloudInstance(name, sessionName string) {
+    err := dc.client.Resource(ec2TrainingVMGVR).Namespace("default").Delete(context.TODO(), name, metav1.DeleteOptions{})
+    if err != nil && !errors.IsNotFound(err) {
+        log.Printf("❌ Failed to terminate cloud instance %s of session %s: %v", name, sessionName, err)
+        return
+    }
+    if err == nil {
+        log.Printf("🗑️ Terminated cloud instance %s of session %s", name, sessionName)
+    }
+}
+
+func (dc *DeprovisionController) deleteVMDNSRecord(requestName string) {
+    err := dc.client.Resource(dnsEndpointGVR).Namespace("default").Delete(
+        context.TODO(), requestName+"-vm-dns", metav1.DeleteOptions{})
+    if err != nil && !errors.IsNotFound(err) {
+        log.Printf("⚠️ Failed to delete DNS record of %s: %v", requestName, err)
+    }
+}
+
+// Delete Secrets created for the session (labelled hobbyfarm.io/session=<name>)
+func (dc *DeprovisionController) deleteSessionSecrets(sessionName string) {
+    err := dc.client.Resource(secretGVR).Namespace("default").DeleteCollection(
+        context.TODO(), metav1.DeleteOptions{}, metav1.ListOptions{
+            LabelSelector: sessionLabelSelector(sessionName),
+        })
+    if err != nil {
+        log.Printf("⚠️ Failed to delete Secrets of session %s: %v", sessionName, err)
+    }
+}
+
+// Mark the request released, and why; only allocated, provisioning and ready
+// requests hold an IP, so clearing vmIP and the state frees it
+func (dc *DeprovisionController) releaseRequest(requestName, reason string) error {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "state":               provisioner.StateReleased,
+            "releaseReason":       reason,
+            "provisioned":         false,
+            "vmIP":                nil,
+            "overlayIP":           nil,
+            "hostname":            nil,
+            "instanceId":          nil,
+            "availabilityZone":    nil,
+            "region":              nil,
+            "consoleURL":          nil,
+            "playbookProgress":    nil,
+            "toolVersions":        nil,
+            "provisioningSummary": nil,
+            "exposedEndpoints":    nil,
+            "releasedAt":          time.Now().Format(time.RFC3339),
+        },
+    })
+    return patchStatus(dc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+}
@@ -0,0 +1,123 @@
+// internal/disk_guard.go - Refuse static VMs that are low on disk or full of session workspaces
+package internal
+
+import (
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Allocation loops run every few seconds; one SSH check per VM per interval is enough
+const diskGuardCacheTTL = 2 * time.Minute
+
+// DISK_GUARD=false allocates static VMs without checking them
+func diskGuardEnabled() bool {
+    return Setting("DISK_GUARD") != "false"
+}
+
+// Free space required in the SSH user's home, where the workspaces live
+func getDiskGuardMinFreeMB() int {
+    if mb, err := strconv.Atoi(Setting("DISK_GUARD_MIN_FREE_MB")); err == nil && mb > 0 {
+        return mb
+    }
+    return 2048
+}
+
+// Session workspaces a static VM may hold before it is considered full
+func getDiskGuardMaxWorkspaces() int {
+    if count, err := strconv.Atoi(Setting("DISK_GUARD_MAX_WORKSPACES")); err == nil && count > 0 {
+        return count
+    }
+    return 10
+}
+
+type diskGuardResult struct {
+    refusal   string
+    checkedAt time.Time
+}
+
+var diskGuardCache = struct {
+    sync.Mutex
+    results map[string]diskGuardResult
+}{results: map[string]diskGuardResult{}}
+
+// Free MB in $HOME and the number of workspace directories under $HOME/workspace
+func (ar *AnsibleRunner) workspaceUsage(vmIP string) (int, int, error) {
+    sshUser, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return 0, 0, err
+    }
+
+    script := `free=$(df -Pm "$HOME" | awk 'NR==2 {print $4}'); ` +
+        `workspaces=$(find "$HOME/workspace" -mindepth 1 -maxdepth 1 -type d 2>/dev/null | wc -l); ` +
+        `echo "$free $workspaces"`
+    output, err := ar.ssh.Output(sshUser, vmIP, 10*time.Second, script)
+    if err != nil {
+        return 0, 0, fmt.Errorf("disk check failed: %v", err)
+    }
+
+    fields := strings.Fields(string(output))
+    if len(fields) != 2 {
+        return 0, 0, fmt.Errorf("unexpected disk check output %q", strings.TrimSpace(string(output)))
+    }
+    freeMB, err := strconv.Atoi(fields[0])
+    if err != nil {
+        return 0, 0, fmt.Errorf("unexpected free space %q", fields[0])
+    }
+    workspaces, _ := strconv.Atoi(fields[1])
+    return freeMB, workspaces, nil
+}
+
+// Why the VM can't take another session, empty when it can, and whether that was
+// checked just now rather than cached. A VM the check can't run on is let through;
+// reachability is already checked and the readiness gates catch a broken VM later.
+func (ar *AnsibleRunner) diskGuardRefusal(vmIP string) (string, bool) {
+    diskGuardCache.Lock()
+    cached, found := diskGuardCache.results[vmIP]
+    diskGuardCache.Unlock()
+    if found && time.Since(cached.checkedAt) < diskGuardCacheTTL {
+        return cached.refusal, false
+    }
+
+    refusal := ""
+    freeMB, workspaces, err := ar.workspaceUsage(vmIP)
+    switch {
+    case err != nil:
+        log.Printf("⚠️ Could not check disk space of static VM %s, allocating anyway: %v", vmIP, err)
+    case freeMB < getDiskGuardMinFreeMB():
+        refusal = fmt.Sprintf("only %d MB free, %d MB required", freeMB, getDiskGuardMinFreeMB())
+    case workspaces >= getDiskGuardMaxWorkspaces():
+        refusal = fmt.Sprintf("%d session workspaces present, limit is %d", workspaces, getDiskGuardMaxWorkspaces())
+    }
+
+    diskGuardCache.Lock()
+    diskGuardCache.results[vmIP] = diskGuardResult{refusal: refusal, checkedAt: time.Now()}
+    diskGuardCache.Unlock()
+    return refusal, true
+}
+
+// Whether the static VM may be allocated to object. A refusal is logged and
+// recorded as a maintenance Event on object, once per check.
+func staticVMHasRoom(client dynamic.Interface, prober SSHProber, vmIP string, object *unstructured.Unstructured) bool {
+    if !diskGuardEnabled() {
+        return true
+    }
+
+    refusal, fresh := prober.DiskGuardRefusal(vmIP)
+    if refusal == "" {
+        return true
+    }
+    if fresh {
+        log.Printf("🔧 Skipping static VM %s for %s: %s", vmIP, object.GetName(), refusal)
+        recordEvent(client, object, eventTypeWarning, "StaticVMNeedsMaintenance",
+            fmt.Sprintf("Static VM %s skipped: %s", vmIP, refusal))
+        recordPoolEvent(client, vmIP, eventTypeWarning, reasonVMSkipped, object.GetKind()+"/"+object.GetName(), "%s", refusal)
+    }
+    return false
+}
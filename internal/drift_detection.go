@@ -0,0 +1,196 @@
+// internal/drift_detection.go - Re-check ready VMs of long-lived sessions and re-run playbooks on drift
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// DRIFT_DETECTION=false leaves ready VMs alone
+func driftDetectionEnabled() bool {
+    return Setting("DRIFT_DETECTION") != "false"
+}
+
+// How often each ready VM is re-checked; multi-day courses don't need it more often
+func getDriftCheckInterval() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("DRIFT_CHECK_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return time.Hour
+}
+
+var driftChecks = struct {
+    sync.Mutex
+    last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+// Whether the request is due for a drift check, marking it checked if so
+func driftCheckDue(requestName string) bool {
+    driftChecks.Lock()
+    defer driftChecks.Unlock()
+    if last, found := driftChecks.last[requestName]; found && time.Since(last) < getDriftCheckInterval() {
+        return false
+    }
+    driftChecks.last[requestName] = time.Now()
+    return true
+}
+
+// Services the requested packages leave running
+func requiredServices(packages []string) []string {
+    var services []string
+    seen := map[string]bool{}
+    for _, pkg := range packages {
+        service := ""
+        switch pkg {
+        case "docker", "docker.io":
+            service = "docker"
+        case "nginx":
+            service = "nginx"
+        }
+        if service != "" && !seen[service] {
+            seen[service] = true
+            services = append(services, service)
+        }
+    }
+    return services
+}
+
+// Check what provisioning left on the VM is still there. Returns the playbook
+// that puts it back along with what was found missing.
+func (ar *AnsibleRunner) detectDrift(vmIP, sshUser string, packages []string) (string, error) {
+    script := []string{
+        `test -d "$HOME/workspace" || { echo "base.yaml: workspace directory missing"; exit 1; }`,
+    }
+    for _, command := range toolchainCommands(packages) {
+        script = append(script, fmt.Sprintf(`command -v %s >/dev/null 2>&1 || { echo "dynamic.yaml: %s not installed"; exit 1; }`, command, command))
+    }
+    for _, service := range requiredServices(packages) {
+        script = append(script, fmt.Sprintf(`systemctl is-active --quiet %s || { echo "dynamic.yaml: %s service not running"; exit 1; }`, service, service))
+    }
+
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, strings.Join(script, "\n"))
+    if err == nil {
+        return "", nil
+    }
+    playbook, reason, found := strings.Cut(strings.TrimSpace(string(output)), ": ")
+    if !found {
+        // SSH itself failed; an unreachable VM is not drift
+        return "", nil
+    }
+    return playbook, fmt.Errorf("%s", reason)
+}
+
+// Check every ready request that is due and re-converge the drifted ones
+func (kc *KratixController) checkDrift() {
+    if !driftDetectionEnabled() {
+        return
+    }
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        if state, _, _ := unstructured.NestedString(request.Object, "status", "state"); state != "ready" || !ownsRequest(request) {
+            continue
+        }
+        if driftCheckDue(request.GetName()) {
+            kc.convergeRequest(request)
+        }
+    }
+}
+
+func (kc *KratixController) convergeRequest(request *unstructured.Unstructured) {
+    requestName := request.GetName()
+    accessIP := getRequestAccessIP(request)
+    packages, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "packages")
+
+    sshUser, err := kc.prober.DetectSSHUser(accessIP)
+    if err != nil {
+        log.Printf("⚠️ Skipping drift check of %s: %v", requestName, err)
+        return
+    }
+    playbook, drift := kc.prober.DetectDrift(accessIP, sshUser, packages)
+    if drift == nil {
+        return
+    }
+
+    log.Printf("🧭 Drift on VM %s of request %s: %v, re-running %s", accessIP, requestName, drift, playbook)
+    recordEvent(kc.client, request, eventTypeWarning, "DriftDetected", fmt.Sprintf("%v, re-running %s", drift, playbook))
+
+    convergence, _, _ := unstructured.NestedMap(request.Object, "status", "convergence")
+    if convergence == nil {
+        convergence = map[string]interface{}{}
+    }
+    convergence["lastDrift"] = drift.Error()
+    convergence["lastDriftAt"] = time.Now().Format(time.RFC3339)
+
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    if err := kc.reconverge(request, accessIP, sshUser, playbook); err != nil {
+        log.Printf("❌ Re-convergence of %s failed: %v", requestName, err)
+        recordEvent(kc.client, request, eventTypeWarning, "ConvergenceFailed", err.Error())
+        conditions = mergeCondition(conditions, conditionVerified, false, "DriftUnresolved", drift.Error())
+    } else {
+        log.Printf("✅ VM %s of request %s converged", accessIP, requestName)
+        recordEvent(kc.client, request, eventTypeNormal, "Converged", fmt.Sprintf("%s re-applied after drift: %v", playbook, drift))
+        count, _, _ := unstructured.NestedInt64(request.Object, "status", "convergence", "count")
+        convergence["count"] = count + 1
+        convergence["lastConvergedAt"] = time.Now().Format(time.RFC3339)
+        conditions = mergeCondition(conditions, conditionVerified, true, "Converged", "drift corrected by "+playbook)
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "convergence": convergence,
+            "conditions":  conditions,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record convergence of %s: %v", requestName, err)
+    }
+}
+
+// Re-run the playbook that corrects the drift, or all of the request's playbooks
+// when it brings its own instead of base.yaml and dynamic.yaml
+func (kc *KratixController) reconverge(request *unstructured.Unstructured, vmIP, sshUser, playbook string) error {
+    session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+    scenario, _, _ := unstructured.NestedString(request.Object, "spec", "scenario")
+
+    config, err := kc.requestProvisioningConfig(session, scenario, request)
+    if err != nil {
+        return err
+    }
+    for _, requested := range config.Playbooks {
+        if requested == playbook {
+            config.Playbooks = []string{playbook}
+            break
+        }
+    }
+    if config.Platform, err = kc.prober.DetectPlatform(vmIP, sshUser); err != nil {
+        return err
+    }
+
+    tmpInventory := fmt.Sprintf("/tmp/drift_inventory_%s", session)
+    if err := kc.writeFile(tmpInventory, kc.ansibleRunner.buildInventory(vmIP, sshUser, session, config)); err != nil {
+        return fmt.Errorf("failed to write inventory: %v", err)
+    }
+    defer kc.removeFile(tmpInventory)
+
+    return withVMLock(kc.client, vmIP, "drift:"+request.GetName(), func() error {
+        for _, playbook := range config.Playbooks {
+            if _, _, err := kc.executor.RunPlaybook(tmpInventory, playbook, session, config); err != nil {
+                return err
+            }
+        }
+        return nil
+    })
+}
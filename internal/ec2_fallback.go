@@ -3,55 +3,46 @@ package internal
 
 import (
     "context"
-    "fmt"
+    "encoding/json"
     "log"
     "time"
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-    "k8s.io/apimachinery/pkg/runtime/schema"
-    "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
 )
 
-// Updated GVR for the new EC2TrainingVM
-var (
-    ec2TrainingVMGVR = schema.GroupVersionResource{
-        Group:    "training.example.com",
-        Version:  "v1",
-        Resource: "ec2trainingvms",
-    }
-)
-
 func HandleEC2Fallback(client dynamic.Interface, name string) {
-    reqName := "ec2-" + name
+    reqName := ec2InstanceName(name)
     
     // Check if EC2TrainingVM already exists
     ec2vm, err := client.Resource(ec2TrainingVMGVR).Namespace("default").Get(context.TODO(), reqName, metav1.GetOptions{})
     if err != nil {
+        // Retried on the next allocation cycle once a token is available
+        if !allowCloudInstanceCreation() {
+            log.Printf("⏳ Cloud instance creation rate limited, %s stays queued", name)
+            return
+        }
+        
         log.Printf("🚀 Creating EC2TrainingVM for %s", name)
         
-        // Create new EC2TrainingVM
-        newEC2VM := &unstructured.Unstructured{
-            Object: map[string]interface{}{
-                "apiVersion": "training.example.com/v1",
-                "kind":       "EC2TrainingVM",
-                "metadata": map[string]interface{}{
-                    "name":      reqName,
-                    "namespace": "default",
-                    "labels": map[string]interface{}{
-                        "session": name,
-                        "type":    "ec2-fallback",
-                    },
-                },
-                "spec": map[string]interface{}{
-                    "user":         name,
-                    "session":      name,
-                    "instanceType": "t3.micro",
-                    "region":       "us-east-1",
-                },
+        // Same template as the Kratix fallback, carrying the TrainingVM's user and passthrough metadata
+        spec := cloudInstanceSpec{
+            Name:    reqName,
+            User:    name,
+            Session: name,
+            Labels: map[string]string{
+                "type": "ec2-fallback",
             },
         }
+        if trainingVM, err := client.Resource(trainingVMGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{}); err == nil {
+            if user, _, _ := unstructured.NestedString(trainingVM.Object, "spec", "user"); user != "" {
+                spec.User = user
+            }
+            spec.Storage = parseCloudStorage(trainingVM.GetAnnotations())
+            spec.Source = trainingVM
+        }
+        newEC2VM := buildCloudInstance(spec)
         
         _, err = client.Resource(ec2TrainingVMGVR).Namespace("default").Create(context.TODO(), newEC2VM, metav1.CreateOptions{})
         if err != nil {
@@ -95,33 +86,33 @@ func HandleEC2Fallback(client dynamic.Interface, name string) {
                     },
                 },
             }
-            _, err = client.Resource(trainingVMGVR).Namespace("default").Create(context.TODO(), newVM, metav1.CreateOptions{})
+            _, _, err = createOrAdopt(client, trainingVMGVR, newVM, nil)
             if err != nil {
                 log.Printf("❌ Failed to create TrainingVM for %s: %v", name, err)
                 return
             }
         }
 
-        // Update TrainingVM with EC2 instance details
-        patch := fmt.Sprintf(`{
-          "status": {
-            "vmIP": "%s",
-            "state": "allocated",
-            "allocatedAt": "%s",
-            "vmType": "ec2",
-            "instanceId": "%s"
-          }
-        }`, vmIP, time.Now().Format(time.RFC3339), instanceId)
+        // Update TrainingVM with EC2 instance details and where to find it in the console
+        status := cloudConsoleStatus(ec2vm)
+        status["vmIP"] = vmIP
+        status["state"] = "allocated"
+        status["allocatedAt"] = time.Now().Format(time.RFC3339)
+        status["vmType"] = "ec2"
+        patch, _ := json.Marshal(map[string]interface{}{"status": status})
 
-        _, err = client.Resource(trainingVMGVR).Namespace("default").Patch(
-            context.TODO(), name, types.MergePatchType,
-            []byte(patch), metav1.PatchOptions{}, "status",
-        )
+        err = patchStatus(client, trainingVMGVR, "default", name, patch)
         if err == nil {
             log.Printf("✅ EC2 VM %s assigned to TrainingVM %s", vmIP, name)
         } else {
             log.Printf("❌ Failed to patch TrainingVM %s: %v", name, err)
         }
+    } else if reason := getCloudCapacityError(client, ec2vm); reason != "" && placementSettled(ec2vm) {
+        if _, ok, err := advanceCloudPlacement(client, ec2vm, reason); err != nil && err != errCloudRateLimited {
+            log.Printf("❌ %v", err)
+        } else if !ok {
+            log.Printf("❌ No placement left for EC2 instance of %s: %s", name, reason)
+        }
     } else {
         log.Printf("⏳ Waiting for EC2 instance for %s (state=%s, ip=%s, ready=%v)", name, state, vmIP, ready)
     }
@@ -129,6 +120,9 @@ func HandleEC2Fallback(client dynamic.Interface, name string) {
 
 // Helper function to check EC2 status and clean up failed instances
 func CleanupFailedEC2Instances(client dynamic.Interface) {
+    if !apiAvailable(apiCrossplane) {
+        return
+    }
     ec2vms, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         return
@@ -19,6 +19,9 @@ func NewEnhancedVMAllocator(client dynamic.Interface) *EnhancedVMAllocator {
 }
 
 func (eva *EnhancedVMAllocator) AllocateTrainingVMs() {
+    if !apiAvailable(apiTraining) || !clusterReachable() {
+        return
+    }
     log.Println("🔄 Enhanced VM Allocator: Starting allocation cycle...")
     
     // ONLY do allocation - NO TrainingVM creation
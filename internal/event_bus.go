@@ -0,0 +1,454 @@
+// internal/event_bus.go - Stage events between detection, allocation, provisioning and status updates, in process or over NATS
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+)
+
+// Stages of the pipeline. An event for a stage says a request has work waiting
+// there, and the stage's handler runs its pass at once instead of on the next
+// loop. The loops still run every stage, so a lost event only costs latency.
+const (
+    stageDetection    = "detection"    // new requests get their initial status
+    stageAllocation   = "allocation"   // pending requests get a VM
+    stageProvisioning = "provisioning" // allocated VMs are provisioned and verified
+    stageStatus       = "status"       // HobbyFarm VirtualMachines and Sessions reflect the outcome
+)
+
+var pipelineStages = []string{stageDetection, stageAllocation, stageProvisioning, stageStatus}
+
+type stageEvent struct {
+    Stage       string    `json:"stage"`
+    Request     string    `json:"request"`
+    Attempt     int       `json:"attempt"`
+    PublishedAt time.Time `json:"publishedAt"`
+    // Why the last attempt failed, on events kept for replay
+    Error string `json:"error,omitempty"`
+}
+
+// Handles one event of a stage; an error has the event retried, then kept for replay
+type stageHandler func(event stageEvent) error
+
+// Carries stage events. publish may block a while for backpressure and fails when
+// the transport can't take the event; deliveries of one stage come one at a time.
+type eventTransport interface {
+    publish(event stageEvent) error
+    subscribe(stage string, deliver func(stageEvent))
+    depth(stage string) int
+    close()
+}
+
+var (
+    errEventBusFull     = errors.New("event queue full")
+    errStageUnavailable = errors.New("API server or Kratix CRDs unavailable")
+)
+
+// memory runs the bus in process, nats shares it between replicas and components;
+// empty leaves the stages to the loops alone
+func getEventBusBackend() string {
+    return strings.ToLower(Setting("EVENT_BUS"))
+}
+
+// Events a stage may have waiting before publishers are held back
+func getEventBusBuffer() int {
+    if buffer, err := strconv.Atoi(Setting("EVENT_BUS_BUFFER")); err == nil && buffer > 0 {
+        return buffer
+    }
+    return 256
+}
+
+// Attempts at an event before it is kept for replay
+func getEventBusMaxAttempts() int {
+    if attempts, err := strconv.Atoi(Setting("EVENT_BUS_MAX_ATTEMPTS")); err == nil && attempts > 0 {
+        return attempts
+    }
+    return 3
+}
+
+// How long a publisher waits for room in a full queue before the event is dropped
+const eventBusPublishTimeout = 2 * time.Second
+
+// Failed events kept for replay, the oldest are dropped past it
+const eventBusFailedLimit = 100
+
+// Whether this process runs stage: those of its COMPONENT, narrowed by
+// EVENT_BUS_STAGES; all of them by default. Over NATS, deployments running
+// different stages split the provisioner further than the components do.
+func runsStage(stage string) bool {
+    if !componentRunsStage(stage) {
+        return false
+    }
+    stages := splitEnvList("EVENT_BUS_STAGES")
+    if len(stages) == 0 || getEventBusBackend() != "nats" {
+        return true
+    }
+    for _, name := range stages {
+        if name == stage {
+            return true
+        }
+    }
+    return false
+}
+
+type stageCounts struct {
+    Published int64 `json:"published"`
+    Handled   int64 `json:"handled"`
+    // Delivered after a pass that started later had already covered them
+    Coalesced int64 `json:"coalesced"`
+    Retried   int64 `json:"retried"`
+    Failed    int64 `json:"failed"`
+    Dropped   int64 `json:"dropped"`
+}
+
+var eventBus = struct {
+    sync.Mutex
+    backend   string
+    transport eventTransport
+    handlers  map[string]stageHandler
+    // Start of each stage's last pass; events published before it are covered
+    lastPass map[string]time.Time
+    counts   map[string]*stageCounts
+    failed   []stageEvent
+}{
+    handlers: map[string]stageHandler{},
+    lastPass: map[string]time.Time{},
+    counts:   map[string]*stageCounts{},
+}
+
+// Called with eventBus held
+func stageCountsOf(stage string) *stageCounts {
+    if eventBus.counts[stage] == nil {
+        eventBus.counts[stage] = &stageCounts{}
+    }
+    return eventBus.counts[stage]
+}
+
+// Start the bus configured with EVENT_BUS and subscribe the handlers registered so far
+func StartEventBus(ctx context.Context) error {
+    var transport eventTransport
+    switch backend := getEventBusBackend(); backend {
+    case "":
+        return nil
+    case "memory":
+        transport = newMemoryTransport(getEventBusBuffer())
+    case "nats":
+        nats, err := newNATSTransport(Setting("EVENT_BUS_NATS_URL"), getEventBusBuffer())
+        if err != nil {
+            return fmt.Errorf("event bus: %v", err)
+        }
+        transport = nats
+    default:
+        return fmt.Errorf("event bus: unknown EVENT_BUS %q, expected memory or nats", backend)
+    }
+
+    eventBus.Lock()
+    eventBus.backend = getEventBusBackend()
+    eventBus.transport = transport
+    for stage := range eventBus.handlers {
+        transport.subscribe(stage, deliverStageEvent)
+    }
+    eventBus.Unlock()
+    log.Printf("🚌 Event bus started (%s), running stages %v", getEventBusBackend(), localStages())
+
+    go func() {
+        <-ctx.Done()
+        transport.close()
+    }()
+    return nil
+}
+
+func localStages() []string {
+    var stages []string
+    for _, stage := range pipelineStages {
+        if runsStage(stage) {
+            stages = append(stages, stage)
+        }
+    }
+    return stages
+}
+
+// Have handler run the events of stage, if this process runs it
+func handleStage(stage string, handler stageHandler) {
+    if !runsStage(stage) {
+        return
+    }
+    eventBus.Lock()
+    defer eventBus.Unlock()
+    eventBus.handlers[stage] = handler
+    if eventBus.transport != nil {
+        eventBus.transport.subscribe(stage, deliverStageEvent)
+    }
+}
+
+// Tell stage that request has work waiting. Without a bus, or when the bus can't
+// take it, the stage's loop picks the request up as before.
+func publishStage(stage, request string) {
+    publishStageEvent(stageEvent{Stage: stage, Request: request, PublishedAt: time.Now()})
+}
+
+func publishStageEvent(event stageEvent) {
+    eventBus.Lock()
+    transport := eventBus.transport
+    eventBus.Unlock()
+    if transport == nil {
+        return
+    }
+    err := transport.publish(event)
+
+    eventBus.Lock()
+    defer eventBus.Unlock()
+    if err != nil {
+        stageCountsOf(event.Stage).Dropped++
+        log.Printf("⚠️ %s event of %s dropped, its loop picks it up: %v", event.Stage, event.Request, err)
+        return
+    }
+    stageCountsOf(event.Stage).Published++
+}
+
+// Run a stage's pass if this process runs the stage, noting when it started so
+// events published before then count as handled
+func stagePass(stage string, pass func()) {
+    if !runsStage(stage) {
+        return
+    }
+    eventBus.Lock()
+    eventBus.lastPass[stage] = time.Now()
+    eventBus.Unlock()
+    pass()
+}
+
+func deliverStageEvent(event stageEvent) {
+    eventBus.Lock()
+    handler := eventBus.handlers[event.Stage]
+    covered := event.PublishedAt.Before(eventBus.lastPass[event.Stage])
+    if handler == nil || covered {
+        stageCountsOf(event.Stage).Coalesced++
+        eventBus.Unlock()
+        return
+    }
+    eventBus.Unlock()
+
+    err := handler(event)
+
+    eventBus.Lock()
+    defer eventBus.Unlock()
+    counts := stageCountsOf(event.Stage)
+    if err == nil {
+        counts.Handled++
+        return
+    }
+    event.Attempt++
+    event.Error = err.Error()
+    if event.Attempt >= getEventBusMaxAttempts() {
+        counts.Failed++
+        eventBus.failed = append(eventBus.failed, event)
+        if len(eventBus.failed) > eventBusFailedLimit {
+            eventBus.failed = eventBus.failed[len(eventBus.failed)-eventBusFailedLimit:]
+        }
+        log.Printf("❌ %s of %s failed %d times, kept for replay: %v", event.Stage, event.Request, event.Attempt, err)
+        return
+    }
+    counts.Retried++
+    delay := time.Duration(1<<event.Attempt) * time.Second
+    time.AfterFunc(delay, func() { publishStageEvent(event) })
+}
+
+// Publish the failed events of stage again, or of every stage when it is empty
+func ReplayStageEvents(stage string) int {
+    eventBus.Lock()
+    var replay, kept []stageEvent
+    for _, event := range eventBus.failed {
+        if stage == "" || event.Stage == stage {
+            replay = append(replay, event)
+        } else {
+            kept = append(kept, event)
+        }
+    }
+    eventBus.failed = kept
+    eventBus.Unlock()
+
+    for _, event := range replay {
+        publishStageEvent(stageEvent{Stage: event.Stage, Request: event.Request, PublishedAt: time.Now()})
+    }
+    if len(replay) > 0 {
+        log.Printf("🔁 Replayed %d failed stage events", len(replay))
+    }
+    return len(replay)
+}
+
+type eventBusStatus struct {
+    Backend string                 `json:"backend"`
+    Stages  []string               `json:"stages"`
+    Counts  map[string]stageCounts `json:"counts"`
+    Depth   map[string]int         `json:"depth"`
+    Failed  []stageEvent           `json:"failed"`
+}
+
+func currentEventBusStatus() eventBusStatus {
+    eventBus.Lock()
+    defer eventBus.Unlock()
+    status := eventBusStatus{
+        Backend: eventBus.backend,
+        Stages:  localStages(),
+        Counts:  map[string]stageCounts{},
+        Depth:   map[string]int{},
+        Failed:  append([]stageEvent{}, eventBus.failed...),
+    }
+    for _, stage := range pipelineStages {
+        status.Counts[stage] = *stageCountsOf(stage)
+        if eventBus.transport != nil {
+            status.Depth[stage] = eventBus.transport.depth(stage)
+        }
+    }
+    return status
+}
+
+// GET /events shows the bus; POST /events?replay=<stage> publishes the failed
+// events of a stage again, of every stage with replay=all. Both only on the
+// admin listener.
+func (ws *WebhookServer) eventsHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(currentEventBusStatus())
+    case http.MethodPost:
+        stage := r.URL.Query().Get("replay")
+        if stage == "" {
+            http.Error(w, "replay=<stage> or replay=all is required", http.StatusBadRequest)
+            return
+        }
+        if stage == "all" {
+            stage = ""
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(map[string]int{"replayed": ReplayStageEvents(stage)})
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+    }
+}
+
+func writeEventBusMetrics(w io.Writer) {
+    status := currentEventBusStatus()
+    if status.Backend == "" {
+        return
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_stage_events_total Stage events by stage and outcome")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_stage_events_total counter")
+    for _, stage := range pipelineStages {
+        counts := status.Counts[stage]
+        for _, outcome := range []struct {
+            name  string
+            count int64
+        }{
+            {"published", counts.Published}, {"handled", counts.Handled}, {"coalesced", counts.Coalesced},
+            {"retried", counts.Retried}, {"failed", counts.Failed}, {"dropped", counts.Dropped},
+        } {
+            fmt.Fprintf(w, "hobbyfarm_provisioner_stage_events_total{stage=%q,outcome=%q} %d\n", stage, outcome.name, outcome.count)
+        }
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_stage_queue_depth Stage events waiting to be handled")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_stage_queue_depth gauge")
+    for _, stage := range pipelineStages {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_stage_queue_depth{stage=%q} %d\n", stage, status.Depth[stage])
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_stage_events_failed Stage events kept for replay")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_stage_events_failed gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_stage_events_failed %d\n", len(status.Failed))
+}
+
+// A stage queue per stage, each drained by one goroutine
+type memoryTransport struct {
+    queues map[string]chan stageEvent
+    done   chan struct{}
+}
+
+func newMemoryTransport(buffer int) *memoryTransport {
+    transport := &memoryTransport{queues: map[string]chan stageEvent{}, done: make(chan struct{})}
+    for _, stage := range pipelineStages {
+        transport.queues[stage] = make(chan stageEvent, buffer)
+    }
+    return transport
+}
+
+func (t *memoryTransport) publish(event stageEvent) error {
+    queue, known := t.queues[event.Stage]
+    if !known {
+        return fmt.Errorf("unknown stage %q", event.Stage)
+    }
+    timer := time.NewTimer(eventBusPublishTimeout)
+    defer timer.Stop()
+    select {
+    case queue <- event:
+        return nil
+    case <-timer.C:
+        return errEventBusFull
+    case <-t.done:
+        return errors.New("event bus stopped")
+    }
+}
+
+func (t *memoryTransport) subscribe(stage string, deliver func(stageEvent)) {
+    queue := t.queues[stage]
+    go func() {
+        for {
+            select {
+            case event := <-queue:
+                deliver(event)
+            case <-t.done:
+                return
+            }
+        }
+    }()
+}
+
+func (t *memoryTransport) depth(stage string) int {
+    return len(t.queues[stage])
+}
+
+func (t *memoryTransport) close() {
+    close(t.done)
+}
+
+// The backend is known and NATS is reachable; stages are ones the pipeline has
+func validateEventBus(check *ConfigCheck) {
+    backend := getEventBusBackend()
+    switch backend {
+    case "":
+        if len(splitEnvList("EVENT_BUS_STAGES")) > 0 {
+            check.warn("EVENT_BUS_STAGES is set without EVENT_BUS=nats, every stage runs here")
+        }
+        return
+    case "memory":
+        if len(splitEnvList("EVENT_BUS_STAGES")) > 0 {
+            check.warn("EVENT_BUS_STAGES only splits stages over NATS, every stage runs here")
+        }
+    case "nats":
+        if Setting("EVENT_BUS_NATS_URL") == "" {
+            check.fail("EVENT_BUS=nats needs EVENT_BUS_NATS_URL")
+        } else if err := probeNATS(Setting("EVENT_BUS_NATS_URL")); err != nil {
+            check.warn("NATS at %s: %v", Setting("EVENT_BUS_NATS_URL"), err)
+        }
+    default:
+        check.fail("EVENT_BUS %q is not memory or nats", backend)
+        return
+    }
+    for _, stage := range splitEnvList("EVENT_BUS_STAGES") {
+        known := false
+        for _, name := range pipelineStages {
+            known = known || name == stage
+        }
+        if !known {
+            check.fail("EVENT_BUS_STAGES: unknown stage %q, expected %s", stage, strings.Join(pipelineStages, ", "))
+        }
+    }
+}
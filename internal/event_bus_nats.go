@@ -0,0 +1,136 @@
+// internal/event_bus_nats.go - Stage events over NATS, through the nats.go client
+package internal
+
+import (
+    "encoding/json"
+    "log"
+    "sync"
+    "time"
+
+    "github.com/nats-io/nats.go"
+)
+
+// Subjects are <prefix>.<stage>, e.g. hobbyfarm.provisioner.allocation
+func getEventBusNATSSubject() string {
+    if prefix := Setting("EVENT_BUS_NATS_SUBJECT"); prefix != "" {
+        return prefix
+    }
+    return "hobbyfarm.provisioner"
+}
+
+// Every replica subscribes to every stage it runs, without a queue group: with
+// sharding only the replica owning a request moves it, and each runs its pass
+// over its own requests.
+type natsTransport struct {
+    conn   *nats.Conn
+    prefix string
+    buffer int
+
+    mu     sync.Mutex
+    queues map[string]chan stageEvent
+}
+
+func newNATSTransport(rawURL string, buffer int) (*natsTransport, error) {
+    conn, err := dialNATS(rawURL, Setting("EVENT_BUS_NATS_TOKEN"))
+    if err != nil {
+        return nil, err
+    }
+    return &natsTransport{conn: conn, prefix: getEventBusNATSSubject(), buffer: buffer, queues: map[string]chan stageEvent{}}, nil
+}
+
+// Fails over max_payload and while reconnecting, nothing is buffered for later;
+// the loops pick up what was not published
+func (t *natsTransport) publish(event stageEvent) error {
+    payload, err := json.Marshal(event)
+    if err != nil {
+        return err
+    }
+    return t.conn.Publish(t.prefix+"."+event.Stage, payload)
+}
+
+// Messages are queued locally so the connection keeps reading while a stage is
+// busy; a stage that falls a buffer behind drops events, which its loop catches up on
+func (t *natsTransport) subscribe(stage string, deliver func(stageEvent)) {
+    queue := make(chan stageEvent, t.buffer)
+    t.mu.Lock()
+    t.queues[stage] = queue
+    t.mu.Unlock()
+
+    go func() {
+        for event := range queue {
+            deliver(event)
+        }
+    }()
+    _, err := t.conn.Subscribe(t.prefix+"."+stage, func(msg *nats.Msg) {
+        var event stageEvent
+        if err := json.Unmarshal(msg.Data, &event); err != nil {
+            log.Printf("⚠️ Ignoring malformed %s event: %v", stage, err)
+            return
+        }
+        select {
+        case queue <- event:
+        default:
+            eventBus.Lock()
+            stageCountsOf(stage).Dropped++
+            eventBus.Unlock()
+        }
+    })
+    if err != nil {
+        log.Printf("⚠️ Could not subscribe to %s events, the loop runs the stage alone: %v", stage, err)
+    }
+}
+
+func (t *natsTransport) depth(stage string) int {
+    t.mu.Lock()
+    defer t.mu.Unlock()
+    return len(t.queues[stage])
+}
+
+func (t *natsTransport) close() {
+    t.conn.Close()
+}
+
+// nats://[user:password@]host[:port] or tls://...; token is sent as auth_token.
+// The client reads the server's INFO, turns to TLS when the server requires it
+// or the URL is tls://, refuses payloads over the server's max_payload and
+// reconnects and subscribes again after a drop, for as long as it is open.
+func dialNATS(rawURL, token string) (*nats.Conn, error) {
+    options := []nats.Option{
+        nats.Name("hobbyfarm-vm-provisioner"),
+        nats.Timeout(5 * time.Second),
+        nats.MaxReconnects(-1),
+        nats.ReconnectWait(time.Second),
+        // Publishing while disconnected fails at once instead of queueing
+        nats.ReconnectBufSize(-1),
+        // A private NATS CA goes in OUTBOUND_CA_BUNDLE_FILE like any other
+        func(o *nats.Options) error {
+            o.TLSConfig = outboundTLSConfig()
+            return nil
+        },
+        nats.DisconnectErrHandler(func(conn *nats.Conn, err error) {
+            if err != nil {
+                log.Printf("🔌 NATS connection to %s lost, reconnecting: %v", conn.ConnectedUrlRedacted(), err)
+            }
+        }),
+        nats.ReconnectHandler(func(conn *nats.Conn) {
+            log.Printf("🔌 NATS connection to %s restored", conn.ConnectedUrlRedacted())
+        }),
+        nats.ErrorHandler(func(_ *nats.Conn, _ *nats.Subscription, err error) {
+            log.Printf("⚠️ NATS: %v", err)
+        }),
+    }
+    if token != "" {
+        options = append(options, nats.Token(token))
+    }
+    return nats.Connect(rawURL, options...)
+}
+
+// Whether a NATS server answers at rawURL, for validate
+func probeNATS(rawURL string) error {
+    conn, err := dialNATS(rawURL, Setting("EVENT_BUS_NATS_TOKEN"))
+    if err != nil {
+        return err
+    }
+    conn.Close()
+    return nil
+}
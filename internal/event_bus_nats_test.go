@@ -0,0 +1,143 @@
+// internal/event_bus_nats_test.go - Stage events over a NATS server sending INFO in plaintext, TLS after it, and the max_payload cap
+package internal
+
+import (
+    "bufio"
+    "crypto/tls"
+    "encoding/pem"
+    "fmt"
+    "io"
+    "net"
+    "net/http/httptest"
+    "os"
+    "strconv"
+    "strings"
+    "testing"
+    "time"
+)
+
+// A NATS server answering one client: INFO in plaintext, TLS when tlsConfig is
+// set, PONG to pings and every publication delivered back to the subscriptions
+// of its subject
+func fakeNATSServer(t *testing.T, info string, tlsConfig *tls.Config) string {
+    t.Helper()
+    listener, err := net.Listen("tcp", "127.0.0.1:0")
+    if err != nil {
+        t.Fatal(err)
+    }
+    t.Cleanup(func() { listener.Close() })
+    go func() {
+        conn, err := listener.Accept()
+        if err != nil {
+            return
+        }
+        defer conn.Close()
+        fmt.Fprintf(conn, "INFO %s\r\n", info)
+        if tlsConfig != nil {
+            tlsConn := tls.Server(conn, tlsConfig)
+            if err := tlsConn.Handshake(); err != nil {
+                return
+            }
+            conn = tlsConn
+        }
+        reader := bufio.NewReader(conn)
+        subs := map[string]string{}
+        for {
+            line, err := reader.ReadString('\n')
+            if err != nil {
+                return
+            }
+            fields := strings.Fields(line)
+            switch {
+            case len(fields) == 0:
+            case fields[0] == "PING":
+                fmt.Fprint(conn, "PONG\r\n")
+            case fields[0] == "SUB" && len(fields) == 3:
+                subs[fields[1]] = fields[2]
+            case fields[0] == "PUB" && len(fields) == 3:
+                size, _ := strconv.Atoi(fields[2])
+                payload := make([]byte, size+2)
+                if _, err := io.ReadFull(reader, payload); err != nil {
+                    return
+                }
+                if sid, found := subs[fields[1]]; found {
+                    fmt.Fprintf(conn, "MSG %s %s %d\r\n%s", fields[1], sid, size, payload)
+                }
+            }
+        }
+    }()
+    return listener.Addr().String()
+}
+
+// Trust the certificate of an httptest TLS server for outbound calls, until the test ends
+func trustTestCertificate(t *testing.T, server *httptest.Server) {
+    t.Helper()
+    bundle := t.TempDir() + "/ca.pem"
+    certPEM := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: server.Certificate().Raw})
+    if err := os.WriteFile(bundle, certPEM, 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("OUTBOUND_CA_BUNDLE_FILE", bundle)
+    resetOutboundTLS := func() {
+        outbound.Lock()
+        outbound.tls = nil
+        outbound.Unlock()
+    }
+    resetOutboundTLS()
+    t.Cleanup(resetOutboundTLS)
+}
+
+func TestNATSStageEventsRoundTrip(t *testing.T) {
+    address := fakeNATSServer(t, `{"server_id":"test","max_payload":1024}`, nil)
+    transport, err := newNATSTransport("nats://"+address, 4)
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer transport.close()
+    if transport.conn.MaxPayload() != 1024 {
+        t.Fatalf("max_payload %d, want the server's 1024", transport.conn.MaxPayload())
+    }
+
+    delivered := make(chan stageEvent, 1)
+    transport.subscribe(stageAllocation, func(event stageEvent) { delivered <- event })
+    if err := transport.publish(stageEvent{Stage: stageAllocation, Request: "req-1"}); err != nil {
+        t.Fatal(err)
+    }
+    select {
+    case event := <-delivered:
+        if event.Request != "req-1" {
+            t.Fatalf("delivered %+v, want req-1's allocation event", event)
+        }
+    case <-time.After(5 * time.Second):
+        t.Fatal("published event never delivered")
+    }
+
+    if err := transport.publish(stageEvent{Stage: stageAllocation, Request: strings.Repeat("x", 2048)}); err == nil {
+        t.Fatal("event over max_payload published")
+    }
+}
+
+func TestNATSUpgradesToTLSAfterInfo(t *testing.T) {
+    certificates := httptest.NewTLSServer(nil)
+    defer certificates.Close()
+    trustTestCertificate(t, certificates)
+    tlsConfig := &tls.Config{Certificates: certificates.TLS.Certificates}
+
+    // The server requires TLS although the URL is nats://
+    address := fakeNATSServer(t, `{"server_id":"test","tls_required":true}`, tlsConfig)
+    conn, err := dialNATS("nats://"+address, "")
+    if err != nil {
+        t.Fatal(err)
+    }
+    defer conn.Close()
+    if _, err := conn.TLSConnectionState(); err != nil {
+        t.Fatalf("connection not upgraded to TLS the server requires: %v", err)
+    }
+
+    // tls:// against a server offering none is refused instead of sent in the clear
+    address = fakeNATSServer(t, `{"server_id":"test"}`, nil)
+    if conn, err := dialNATS("tls://"+address, ""); err == nil {
+        conn.Close()
+        t.Fatal("tls:// connected to a server without TLS")
+    }
+}
@@ -0,0 +1,62 @@
+// internal/events.go - Kubernetes Events on the objects the provisioner acts for
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+const (
+    eventTypeNormal  = "Normal"
+    eventTypeWarning = "Warning"
+)
+
+// Record an Event involving object, visible with kubectl describe. Failures are
+// only logged; an Event is never worth failing the work it reports on.
+func recordEvent(client dynamic.Interface, object *unstructured.Unstructured, eventType, reason, message string) {
+    namespace := object.GetNamespace()
+    if namespace == "" {
+        namespace = "default"
+    }
+    now := time.Now().UTC().Format(time.RFC3339)
+
+    event := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "v1",
+            "kind":       "Event",
+            "metadata": map[string]interface{}{
+                "name":      fmt.Sprintf("%s.%x", object.GetName(), time.Now().UnixNano()),
+                "namespace": namespace,
+                "labels": map[string]interface{}{
+                    "app": "hobbyfarm-provisioner",
+                },
+            },
+            "involvedObject": map[string]interface{}{
+                "apiVersion": object.GetAPIVersion(),
+                "kind":       object.GetKind(),
+                "name":       object.GetName(),
+                "namespace":  namespace,
+                "uid":        string(object.GetUID()),
+            },
+            "type":           eventType,
+            "reason":         reason,
+            "message":        message,
+            "count":          int64(1),
+            "firstTimestamp": now,
+            "lastTimestamp":  now,
+            "source": map[string]interface{}{
+                "component": "hobbyfarm-provisioner",
+            },
+        },
+    }
+
+    if _, err := client.Resource(eventGVR).Namespace(namespace).Create(context.TODO(), event, metav1.CreateOptions{}); err != nil {
+        log.Printf("⚠️ Could not record %s event on %s: %v", reason, object.GetName(), err)
+    }
+}
@@ -0,0 +1,200 @@
+// internal/execution_environment.go - Run ansible-playbook inside execution environment containers or pinned virtualenvs
+package internal
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+const (
+	eeRuntimeLocal  = "local"
+	eeRuntimeVenv   = "venv"
+	eeRuntimePodman = "podman"
+	eeRuntimeDocker = "docker"
+)
+
+// Where playbooks run: local, venv (a virtualenv per Ansible version), podman or docker
+func getEERuntime() string {
+	switch runtime := Setting("ANSIBLE_EE_RUNTIME"); runtime {
+	case eeRuntimeVenv, eeRuntimePodman, eeRuntimeDocker:
+		return runtime
+	case "", eeRuntimeLocal:
+		return eeRuntimeLocal
+	default:
+		log.Printf("⚠️ Unknown ANSIBLE_EE_RUNTIME %s, running ansible-playbook locally", runtime)
+		return eeRuntimeLocal
+	}
+}
+
+// Default execution environment image, overridable per scenario or request
+func getDefaultEEImage() string {
+	if image := Setting("ANSIBLE_EE_IMAGE"); image != "" {
+		return image
+	}
+	return "quay.io/ansible/creator-ee:latest"
+}
+
+// ansible-playbook of the local runtime, a name looked up on PATH or a full path
+func getAnsiblePlaybookBin() string {
+	if bin := Setting("ANSIBLE_PLAYBOOK_BIN"); bin != "" {
+		return bin
+	}
+	return "ansible-playbook"
+}
+
+// Python that creates virtualenvs and, when set, runs the local ansible-playbook
+// instead of whatever its shebang names
+func getAnsiblePython() string {
+	if python := Setting("ANSIBLE_PYTHON"); python != "" {
+		return python
+	}
+	return "python3"
+}
+
+// Virtualenvs are kept here, one per Ansible version, and reused across runs
+func getAnsibleVenvDir() string {
+	if dir := Setting("ANSIBLE_VENV_DIR"); dir != "" {
+		return dir
+	}
+	return filepath.Join(os.TempDir(), "provisioner-ansible-venvs")
+}
+
+// pip requirements of a virtualenv: ansible-core at the scenario's version, or
+// ANSIBLE_VERSION, plus ANSIBLE_VENV_PACKAGES such as jmespath or netaddr
+func ansibleVenvPackages(version string) []string {
+	if version == "" {
+		version = Setting("ANSIBLE_VERSION")
+	}
+	core := "ansible-core"
+	if version != "" {
+		core += "==" + version
+	}
+	return append([]string{core}, splitList(Setting("ANSIBLE_VENV_PACKAGES"))...)
+}
+
+// Virtualenvs built by this process; creation holds the lock so concurrent runs
+// on a new version install it once
+var ansibleVenvs = struct {
+	sync.Mutex
+	ready map[string]bool
+}{ready: map[string]bool{}}
+
+// Create the virtualenv for the requirements unless it exists, returning its
+// directory. Its scripts name the directory in their shebang, so it is built in
+// place; a marker written last keeps an interrupted install from being used.
+func (ar *AnsibleRunner) ensureAnsibleVenv(packages []string) (string, error) {
+	sum := sha256.Sum256([]byte(strings.Join(packages, "\n")))
+	dir := filepath.Join(getAnsibleVenvDir(), hex.EncodeToString(sum[:])[:12])
+	marker := filepath.Join(dir, ".provisioner-complete")
+
+	ansibleVenvs.Lock()
+	defer ansibleVenvs.Unlock()
+	if ansibleVenvs.ready[dir] {
+		return dir, nil
+	}
+	if _, err := os.Stat(marker); err == nil {
+		ansibleVenvs.ready[dir] = true
+		return dir, nil
+	}
+
+	log.Printf("🐍 Creating Ansible virtualenv %s with %s", dir, strings.Join(packages, " "))
+	os.RemoveAll(dir)
+	if err := os.MkdirAll(getAnsibleVenvDir(), 0755); err != nil {
+		return "", fmt.Errorf("failed to create virtualenv dir: %v", err)
+	}
+	if output, err := ar.exec.CombinedOutput(nil, getAnsiblePython(), "-m", "venv", dir); err != nil {
+		return "", fmt.Errorf("failed to create virtualenv: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	// PIP_INDEX_URL or PIP_FIND_LINKS in the environment point pip at a mirror or wheelhouse
+	pipArgs := append([]string{"install", "--disable-pip-version-check", "--quiet"}, packages...)
+	if output, err := ar.exec.CombinedOutput(outboundEnv(), filepath.Join(dir, "bin", "pip"), pipArgs...); err != nil {
+		return "", fmt.Errorf("failed to install %s: %v: %s", strings.Join(packages, " "), err, strings.TrimSpace(string(output)))
+	}
+	if err := os.WriteFile(marker, []byte(strings.Join(packages, "\n")+"\n"), 0644); err != nil {
+		return "", fmt.Errorf("failed to mark virtualenv complete: %v", err)
+	}
+	ansibleVenvs.ready[dir] = true
+	return dir, nil
+}
+
+// Build the ansible-playbook command, either directly, from a virtualenv or wrapped
+// in a container run. Returns the program, its arguments and the variables to add
+// to its environment.
+func (ar *AnsibleRunner) buildPlaybookCommand(inventory, playbookPath string, args, ansibleEnv []string, config *ProvisioningConfig) (string, []string, []string, error) {
+	runtime := getEERuntime()
+	playbookArgs := append([]string{"-i", inventory, playbookPath}, args...)
+	switch runtime {
+	case eeRuntimeLocal:
+		if python := Setting("ANSIBLE_PYTHON"); python != "" {
+			// Python takes a script path, not a name to look up
+			script := getAnsiblePlaybookBin()
+			if path, err := exec.LookPath(script); err == nil {
+				script = path
+			}
+			return python, append([]string{script}, playbookArgs...), append(ansibleEnv, config.secretEnv()...), nil
+		}
+		return getAnsiblePlaybookBin(), playbookArgs, append(ansibleEnv, config.secretEnv()...), nil
+	case eeRuntimeVenv:
+		dir, err := ar.ensureAnsibleVenv(ansibleVenvPackages(config.AnsibleVersion))
+		if err != nil {
+			return "", nil, nil, err
+		}
+		// Ansible starts its own helpers by name, so they have to come from the virtualenv too
+		env := append(ansibleEnv,
+			"VIRTUAL_ENV="+dir,
+			"PATH="+filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"),
+		)
+		return filepath.Join(dir, "bin", "ansible-playbook"), playbookArgs, append(env, config.secretEnv()...), nil
+	}
+
+	image := config.ExecutionEnvironment
+	if image == "" {
+		image = getDefaultEEImage()
+	}
+
+	absPlaybookDir, err := filepath.Abs(filepath.Dir(playbookPath))
+	if err != nil {
+		absPlaybookDir = filepath.Dir(playbookPath)
+	}
+
+	log.Printf("📦 Running %s in execution environment %s (%s)", filepath.Base(playbookPath), image, runtime)
+
+	// Inventory, playbooks and SSH key are mounted read-only; host networking
+	// keeps VM reachability identical to running ansible-playbook locally
+	containerArgs := []string{
+		"run", "--rm",
+		"--network", "host",
+		"-v", fmt.Sprintf("%s:/runner/inventory:ro", inventory),
+		"-v", fmt.Sprintf("%s:/runner/project:ro", absPlaybookDir),
+		"-v", fmt.Sprintf("%s:/runner/ssh_key:ro", ar.sshKeyPath),
+	}
+	// The CA bundle is copied from the controller, so it has to exist in the container too
+	if caBundle := config.Variables["ca_bundle_file"]; caBundle != "" {
+		containerArgs = append(containerArgs, "-v", fmt.Sprintf("%s:%s:ro", caBundle, caBundle))
+	}
+	for _, env := range ansibleEnv {
+		containerArgs = append(containerArgs, "-e", env)
+	}
+	// Secrets by name only, the runtime copies their values from its own environment
+	for variable := range config.ResolvedSecrets {
+		containerArgs = append(containerArgs, "-e", secretEnvName(variable))
+	}
+	containerArgs = append(containerArgs, image,
+		"ansible-playbook",
+		"-i", "/runner/inventory",
+		filepath.Join("/runner/project", filepath.Base(playbookPath)),
+	)
+	containerArgs = append(containerArgs, args...)
+
+	// The inventory points at the host key path, override it with the mounted one
+	containerArgs = append(containerArgs, "-e", "ansible_ssh_private_key_file=/runner/ssh_key")
+
+	return runtime, containerArgs, config.secretEnv(), nil
+}
@@ -0,0 +1,172 @@
+// internal/executors.go - What the Kratix controller does to VMs and clouds, behind interfaces it can be given
+package internal
+
+import (
+    "context"
+    "fmt"
+    "os"
+    "os/exec"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Checks run on a VM over the network and SSH
+type SSHProber interface {
+    Reachable(vmIP string) bool
+    WaitForSSH(vmIP string, timeout time.Duration) error
+    DetectSSHUser(vmIP string) (string, error)
+    DetectPlatform(vmIP, sshUser string) (vmPlatform, error)
+    // The first failing gate, empty when the VM is ready
+    CheckReadinessGates(vmIP string, env vmEnvironment, packages []string) (string, error)
+    // The playbook that corrects drift and what drifted, nil when nothing did
+    DetectDrift(vmIP, sshUser string, packages []string) (string, error)
+    // Versions of the requested toolchain, keyed by command
+    DetectToolVersions(vmIP, sshUser string, packages []string) (map[string]string, error)
+    // Record which session owns the VM on the VM itself
+    WriteVMMetadata(vmIP, sshUser string, metadata vmMetadata) error
+    // When the VM's session ends, for the learner's countdown
+    WriteSessionExpiry(vmIP, sshUser string, expiresAt time.Time, paused bool) error
+    // Why a static VM lacks room for another session, empty when it has room, and
+    // whether the answer was just checked rather than cached
+    DiskGuardRefusal(vmIP string) (string, bool)
+    // Join the VM to the overlay network and return its overlay address
+    JoinOverlay(vmIP, sessionName, mode string, credentials map[string]string, request *unstructured.Unstructured) (string, error)
+}
+
+// Runs one playbook against an inventory file, and other programs such as
+// request hooks
+type AnsibleExecutor interface {
+    Executor
+    RunPlaybook(inventory, playbook, sessionName string, config *ProvisioningConfig) (*PlaybookRecap, []byte, error)
+}
+
+// Launches the cloud instance described by an EC2TrainingVM claim and looks up
+// instances it did not launch
+type CloudProvider interface {
+    CreateInstance(instance *unstructured.Unstructured) error
+    // Address of an instance, empty while it has none
+    LookupInstanceIP(region, instanceID string) (string, error)
+    // Tail of the instance's console output, secrets redacted
+    ConsoleOutput(region, instanceID string) (string, error)
+}
+
+// The real implementations: SSH and ansible-playbook through the AnsibleRunner,
+// instances through Crossplane claims
+type runnerProber struct{ ar *AnsibleRunner }
+
+func (p runnerProber) Reachable(vmIP string) bool { return isVMReachable(vmIP) }
+
+func (p runnerProber) WaitForSSH(vmIP string, timeout time.Duration) error {
+    return p.ar.WaitForSSH(vmIP, timeout)
+}
+
+func (p runnerProber) DetectSSHUser(vmIP string) (string, error) { return p.ar.detectSSHUser(vmIP) }
+
+func (p runnerProber) DetectPlatform(vmIP, sshUser string) (vmPlatform, error) {
+    return p.ar.detectVMPlatform(vmIP, sshUser)
+}
+
+func (p runnerProber) CheckReadinessGates(vmIP string, env vmEnvironment, packages []string) (string, error) {
+    return p.ar.checkReadinessGates(vmIP, env, packages)
+}
+
+func (p runnerProber) DetectDrift(vmIP, sshUser string, packages []string) (string, error) {
+    return p.ar.detectDrift(vmIP, sshUser, packages)
+}
+
+func (p runnerProber) DetectToolVersions(vmIP, sshUser string, packages []string) (map[string]string, error) {
+    return p.ar.detectToolVersions(vmIP, sshUser, packages)
+}
+
+func (p runnerProber) WriteVMMetadata(vmIP, sshUser string, metadata vmMetadata) error {
+    return p.ar.writeVMMetadata(vmIP, sshUser, metadata)
+}
+
+func (p runnerProber) WriteSessionExpiry(vmIP, sshUser string, expiresAt time.Time, paused bool) error {
+    return p.ar.writeSessionExpiry(vmIP, sshUser, expiresAt, paused)
+}
+
+func (p runnerProber) DiskGuardRefusal(vmIP string) (string, bool) { return p.ar.diskGuardRefusal(vmIP) }
+
+func (p runnerProber) JoinOverlay(vmIP, sessionName, mode string, credentials map[string]string, request *unstructured.Unstructured) (string, error) {
+    return p.ar.JoinOverlay(vmIP, sessionName, mode, credentials, request)
+}
+
+type runnerExecutor struct{ ar *AnsibleRunner }
+
+func (e runnerExecutor) RunPlaybook(inventory, playbook, sessionName string, config *ProvisioningConfig) (*PlaybookRecap, []byte, error) {
+    return e.ar.runSinglePlaybookWithRecap(inventory, playbook, sessionName, config)
+}
+
+func (e runnerExecutor) CombinedOutput(env []string, name string, args ...string) ([]byte, error) {
+    return e.ar.exec.CombinedOutput(env, name, args...)
+}
+
+// Claims go through Crossplane, lookups through the aws CLI of the runner
+type crossplaneCloud struct {
+    client dynamic.Interface
+    ar     *AnsibleRunner
+}
+
+func (c crossplaneCloud) CreateInstance(instance *unstructured.Unstructured) error {
+    _, err := c.client.Resource(ec2TrainingVMGVR).Namespace("default").Create(context.TODO(), instance, metav1.CreateOptions{})
+    return err
+}
+
+func (c crossplaneCloud) LookupInstanceIP(region, instanceID string) (string, error) {
+    return c.ar.lookupInstanceIP(region, instanceID)
+}
+
+func (c crossplaneCloud) ConsoleOutput(region, instanceID string) (string, error) {
+    return c.ar.fetchConsoleOutput(region, instanceID)
+}
+
+// Runs a program for the AnsibleRunner. The default runs it on the controller
+// host; a Job- or agent-based one can run ansible-playbook elsewhere.
+type Executor interface {
+    // stdout and stderr of name run with args, env added to the controller's environment
+    CombinedOutput(env []string, name string, args ...string) ([]byte, error)
+}
+
+// Runs a shell command on a VM for the AnsibleRunner
+type SSHClient interface {
+    // stdout of command run on vmIP as user
+    Output(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error)
+    // stdout and stderr of command run on vmIP as user
+    CombinedOutput(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error)
+}
+
+type localExecutor struct{}
+
+func (localExecutor) CombinedOutput(env []string, name string, args ...string) ([]byte, error) {
+    cmd := exec.Command(name, args...)
+    cmd.Env = append(os.Environ(), env...)
+    return cmd.CombinedOutput()
+}
+
+// The ssh binary with the provisioner key, never prompting and ignoring host keys
+// since VMs are recycled under the same addresses
+type opensshClient struct{ keyPath string }
+
+func (c opensshClient) command(user, vmIP string, connectTimeout time.Duration, command []string) *exec.Cmd {
+    args := []string{
+        "-o", "StrictHostKeyChecking=no",
+        "-o", "UserKnownHostsFile=/dev/null",
+        "-o", fmt.Sprintf("ConnectTimeout=%d", int(connectTimeout.Seconds())),
+        "-o", "BatchMode=yes",
+        "-i", c.keyPath,
+        fmt.Sprintf("%s@%s", user, vmIP),
+    }
+    return exec.Command("ssh", append(args, command...)...)
+}
+
+func (c opensshClient) Output(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error) {
+    return c.command(user, vmIP, connectTimeout, command).Output()
+}
+
+func (c opensshClient) CombinedOutput(user, vmIP string, connectTimeout time.Duration, command ...string) ([]byte, error) {
+    return c.command(user, vmIP, connectTimeout, command).CombinedOutput()
+}
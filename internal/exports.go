@@ -1,4 +1,5 @@
 // internal/exports.go - UPDATED VERSION with Kratix Promise GVRs
+// Third-party integrations should use pkg/provisioner; these remain for cmd/
 package internal
 
 import (
@@ -17,28 +18,16 @@ func GetSessionGVR() schema.GroupVersionResource {
 }
 
 func GetVirtualMachineClaimGVR() schema.GroupVersionResource {
-    return schema.GroupVersionResource{
-        Group:    "hobbyfarm.io",
-        Version:  "v1",
-        Resource: "virtualmachineclaims",
-    }
+    return virtualMachineClaimGVR
 }
 
 func GetVirtualMachineGVR() schema.GroupVersionResource {
-    return schema.GroupVersionResource{
-        Group:    "hobbyfarm.io",
-        Version:  "v1",
-        Resource: "virtualmachines",
-    }
+    return virtualMachineGVR
 }
 
 // NEW: Kratix Promise GVRs
 func GetVMProvisioningRequestGVR() schema.GroupVersionResource {
-    return schema.GroupVersionResource{
-        Group:    "platform.kratix.io",
-        Version:  "v1alpha1",
-        Resource: "vm-provisioning-requests",
-    }
+    return vmProvisioningRequestGVR
 }
 
 func GetKratixPromiseGVR() schema.GroupVersionResource {
@@ -51,13 +40,17 @@ func GetKratixPromiseGVR() schema.GroupVersionResource {
 
 // VM Pool and infrastructure
 func GetVMPool() []string {
-    return vmPool
+    return allStaticVMs()
 }
 
 func IsVMReachable(ip string) bool {
     return isVMReachable(ip)
 }
 
+func GetMaintenanceVMs(client dynamic.Interface) map[string]string {
+    return getMaintenanceVMs(client)
+}
+
 // Session and VM management
 func ListSessionsExport(client dynamic.Interface) []unstructured.Unstructured {
     return ListSessions(client)
@@ -105,3 +98,8 @@ func GetGCPTrainingVMGVR() schema.GroupVersionResource {
         Resource: "gcptrainingvms",
     }
 }
+
+// Record a loop's error for /stats
+func RecordLastError(subsystem, format string, args ...interface{}) {
+    recordLastError(subsystem, format, args...)
+}
@@ -0,0 +1,315 @@
+// internal/exposed_ports.go - Ports a scenario's services need: opened on cloud VMs, checked on static ones
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net"
+    "strconv"
+    "strings"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    // Crossplane managed security groups and their ingress rules, cluster scoped
+    securityGroupGVR = schema.GroupVersionResource{
+        Group:    "ec2.aws.upbound.io",
+        Version:  "v1beta1",
+        Resource: "securitygroups",
+    }
+    securityGroupIngressRuleGVR = schema.GroupVersionResource{
+        Group:    "ec2.aws.upbound.io",
+        Version:  "v1beta1",
+        Resource: "securitygroupingressrules",
+    }
+)
+
+// Scenario annotation, e.g. ports: "web=8080,3000,dns=53/udp"; unnamed ports are named port-<n>
+const exposedPortsAnnotation = "provisioning.hobbyfarm.io/ports"
+
+// On cloud instances with a per-request security group, which are never reused
+const exposedPortsLabel = "hobbyfarm.io/exposed-ports"
+
+// A cloud instance launched before its security group has an ID stays queued
+var errSecurityGroupPending = errors.New("security group for exposed ports not ready yet")
+
+// A port a scenario's service listens on
+type exposedPort struct {
+    Name     string
+    Port     int
+    Protocol string
+}
+
+// Addresses allowed to reach exposed ports on cloud VMs
+func getExposedPortsCIDR() string {
+    if cidr := Setting("EXPOSED_PORTS_CIDR"); cidr != "" {
+        return cidr
+    }
+    return "0.0.0.0/0"
+}
+
+// Parse "[name=]port[/tcp|udp]" entries, skipping invalid ones
+func parseExposedPorts(value string) []exposedPort {
+    var ports []exposedPort
+    for _, entry := range splitList(value) {
+        name, spec, named := strings.Cut(entry, "=")
+        if !named {
+            name, spec = "", entry
+        }
+        portText, protocol, _ := strings.Cut(spec, "/")
+        port, err := strconv.Atoi(strings.TrimSpace(portText))
+        protocol = strings.ToLower(strings.TrimSpace(protocol))
+        if protocol == "" {
+            protocol = "tcp"
+        }
+        if err != nil || port < 1 || port > 65535 || (protocol != "tcp" && protocol != "udp") {
+            log.Printf("⚠️ Ignoring exposed port %q: expected [name=]port[/tcp|udp]", entry)
+            continue
+        }
+        if name = strings.TrimSpace(name); name == "" {
+            name = fmt.Sprintf("port-%d", port)
+        }
+        ports = append(ports, exposedPort{Name: name, Port: port, Protocol: protocol})
+    }
+    return ports
+}
+
+// Annotations of a Scenario, looked up in both namespaces like the provisioning config
+func getScenarioAnnotations(client dynamic.Interface, scenario string) map[string]string {
+    if scenario == "" {
+        return nil
+    }
+    if scenarioObj, err := getScenario(client, scenario); err == nil {
+        return scenarioObj.GetAnnotations()
+    }
+    return nil
+}
+
+// spec.ports of a VMProvisioningRequest from the scenario's ports annotation
+func buildExposedPortsSpec(client dynamic.Interface, scenario string) []interface{} {
+    ports := parseExposedPorts(getScenarioAnnotations(client, scenario)[exposedPortsAnnotation])
+    spec := make([]interface{}, len(ports))
+    for i, port := range ports {
+        spec[i] = map[string]interface{}{
+            "name":     port.Name,
+            "port":     int64(port.Port),
+            "protocol": port.Protocol,
+        }
+    }
+    return spec
+}
+
+// Ports recorded in a VMProvisioningRequest's spec.ports
+func getRequestExposedPorts(request *unstructured.Unstructured) []exposedPort {
+    entries, _, _ := unstructured.NestedSlice(request.Object, "spec", "ports")
+    var ports []exposedPort
+    for _, e := range entries {
+        entry, ok := e.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        port, _, _ := unstructured.NestedInt64(entry, "port")
+        if port < 1 || port > 65535 {
+            continue
+        }
+        name, _, _ := unstructured.NestedString(entry, "name")
+        protocol, _, _ := unstructured.NestedString(entry, "protocol")
+        if protocol == "" {
+            protocol = "tcp"
+        }
+        if name == "" {
+            name = fmt.Sprintf("port-%d", port)
+        }
+        ports = append(ports, exposedPort{Name: name, Port: int(port), Protocol: protocol})
+    }
+    return ports
+}
+
+func sessionSecurityGroupName(requestName string) string {
+    return "hf-" + requestName
+}
+
+// Create the request's security group and one ingress rule per port. Returns the
+// group's AWS ID, or errSecurityGroupPending until Crossplane has created it.
+func ensureSessionSecurityGroup(client dynamic.Interface, requestName, region, providerConfig string, ports []exposedPort) (string, error) {
+    if providerConfig == "" {
+        providerConfig = "aws-provider"
+    }
+    name := sessionSecurityGroupName(requestName)
+    labels := map[string]interface{}{
+        "app":            "hobbyfarm-provisioner",
+        "kratix-request": requestName,
+    }
+
+    forProvider := map[string]interface{}{
+        "region":      region,
+        "name":        name,
+        "description": "Ports exposed for VMProvisioningRequest " + requestName,
+        "tags":        map[string]interface{}{"kratix-request": requestName},
+    }
+    if vpcID := Setting("EC2_VPC_ID"); vpcID != "" {
+        forProvider["vpcId"] = vpcID
+    }
+    group := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "ec2.aws.upbound.io/v1beta1",
+            "kind":       "SecurityGroup",
+            "metadata": map[string]interface{}{
+                "name":   name,
+                "labels": labels,
+            },
+            "spec": map[string]interface{}{
+                "forProvider":       forProvider,
+                "providerConfigRef": map[string]interface{}{"name": providerConfig},
+            },
+        },
+    }
+    stampProvenance(group)
+    if _, err := client.Resource(securityGroupGVR).Create(context.TODO(), group, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+        return "", fmt.Errorf("failed to create security group %s: %v", name, err)
+    }
+
+    for _, port := range ports {
+        rule := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "ec2.aws.upbound.io/v1beta1",
+                "kind":       "SecurityGroupIngressRule",
+                "metadata": map[string]interface{}{
+                    "name":   fmt.Sprintf("%s-%s-%d", name, port.Protocol, port.Port),
+                    "labels": labels,
+                },
+                "spec": map[string]interface{}{
+                    "forProvider": map[string]interface{}{
+                        "region":             region,
+                        "securityGroupIdRef": map[string]interface{}{"name": name},
+                        "ipProtocol":         port.Protocol,
+                        "fromPort":           int64(port.Port),
+                        "toPort":             int64(port.Port),
+                        "cidrIpv4":           getExposedPortsCIDR(),
+                        "description":        port.Name,
+                    },
+                    "providerConfigRef": map[string]interface{}{"name": providerConfig},
+                },
+            },
+        }
+        stampProvenance(rule)
+        if _, err := client.Resource(securityGroupIngressRuleGVR).Create(context.TODO(), rule, metav1.CreateOptions{}); err != nil && !apierrors.IsAlreadyExists(err) {
+            return "", fmt.Errorf("failed to open port %d/%s in security group %s: %v", port.Port, port.Protocol, name, err)
+        }
+    }
+
+    created, err := client.Resource(securityGroupGVR).Get(context.TODO(), name, metav1.GetOptions{})
+    if err != nil {
+        return "", fmt.Errorf("failed to read security group %s: %v", name, err)
+    }
+    if id, _, _ := unstructured.NestedString(created.Object, "status", "atProvider", "id"); id != "" {
+        return id, nil
+    }
+    return "", errSecurityGroupPending
+}
+
+// Delete the request's security group and rules. AWS refuses while the instance
+// still uses the group; Crossplane keeps retrying until it is terminated.
+func deleteSessionSecurityGroup(client dynamic.Interface, requestName string) {
+    selector := metav1.ListOptions{LabelSelector: "kratix-request=" + requestName}
+    if rules, err := client.Resource(securityGroupIngressRuleGVR).List(context.TODO(), selector); err == nil {
+        for _, rule := range rules.Items {
+            if err := client.Resource(securityGroupIngressRuleGVR).Delete(context.TODO(), rule.GetName(), metav1.DeleteOptions{}); err != nil && !apierrors.IsNotFound(err) {
+                log.Printf("⚠️ Failed to delete ingress rule %s: %v", rule.GetName(), err)
+            }
+        }
+    }
+    err := client.Resource(securityGroupGVR).Delete(context.TODO(), sessionSecurityGroupName(requestName), metav1.DeleteOptions{})
+    if err != nil && !apierrors.IsNotFound(err) {
+        log.Printf("⚠️ Failed to delete security group of %s: %v", requestName, err)
+    }
+}
+
+// Whether a TCP port accepts connections. UDP can't be checked without a service
+// answering, so it is reported as unknown.
+func probeExposedPort(vmIP string, port exposedPort) (bool, bool) {
+    if port.Protocol != "tcp" {
+        return false, false
+    }
+    conn, err := net.DialTimeout("tcp", net.JoinHostPort(vmIP, strconv.Itoa(port.Port)), 3*time.Second)
+    if err != nil {
+        return false, true
+    }
+    conn.Close()
+    return true, true
+}
+
+// Check the request's exposed ports on its ready VM and write them to
+// status.exposedEndpoints, which the integration copies onto the Session
+func (kc *KratixController) recordExposedEndpoints(request *unstructured.Unstructured) {
+    ports := getRequestExposedPorts(request)
+    if len(ports) == 0 {
+        return
+    }
+    accessIP := getRequestAccessIP(request)
+
+    endpoints := make([]interface{}, 0, len(ports))
+    var unreachable []string
+    for _, port := range ports {
+        endpoint := map[string]interface{}{
+            "name":     port.Name,
+            "port":     int64(port.Port),
+            "protocol": port.Protocol,
+            "endpoint": net.JoinHostPort(accessIP, strconv.Itoa(port.Port)),
+        }
+        if reachable, checked := probeExposedPort(accessIP, port); checked {
+            endpoint["reachable"] = reachable
+            if !reachable {
+                unreachable = append(unreachable, fmt.Sprintf("%s (%d/%s)", port.Name, port.Port, port.Protocol))
+            }
+        }
+        endpoints = append(endpoints, endpoint)
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "exposedEndpoints": endpoints,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", request.GetName(), patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record exposed endpoints of %s: %v", request.GetName(), err)
+        return
+    }
+    if len(unreachable) > 0 {
+        // Nothing listens yet or a firewall is in the way; the VM stays ready
+        recordEvent(kc.client, request, eventTypeWarning, "PortUnreachable",
+            fmt.Sprintf("Exposed ports not reachable on %s: %s", accessIP, strings.Join(unreachable, ", ")))
+    }
+}
+
+// name -> host:port of a request's exposed endpoints as JSON, for the Session
+// annotation scenario content reads; empty without any
+func exposedEndpointsAnnotation(request *unstructured.Unstructured) string {
+    entries, _, _ := unstructured.NestedSlice(request.Object, "status", "exposedEndpoints")
+    endpoints := map[string]string{}
+    for _, e := range entries {
+        entry, ok := e.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        name, _, _ := unstructured.NestedString(entry, "name")
+        endpoint, _, _ := unstructured.NestedString(entry, "endpoint")
+        if name != "" && endpoint != "" {
+            endpoints[name] = endpoint
+        }
+    }
+    if len(endpoints) == 0 {
+        return ""
+    }
+    data, _ := json.Marshal(endpoints)
+    return string(data)
+}
@@ -0,0 +1,111 @@
+// internal/extra_vars.go - Course-specific Ansible variables from scenarios, sessions and requests
+package internal
+
+import (
+    "context"
+    "log"
+    "regexp"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+    // key=value per line, on a Scenario or Session
+    variablesAnnotation = "provisioning.hobbyfarm.io/variables"
+    // One variable per annotation, e.g. vars.provisioning.hobbyfarm.io/wso2_version: "4.2.0"
+    variableAnnotationPrefix = "vars.provisioning.hobbyfarm.io/"
+)
+
+var variableNamePattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// Set by the provisioner itself; a course overriding them would break provisioning
+var reservedVariables = map[string]bool{
+    "session_name":                true,
+    "session_packages":            true,
+    "session_requirements":        true,
+    "os_family":                   true,
+    "os_distribution":             true,
+    "os_version":                  true,
+    "os_arch":                     true,
+    "pkg_manager":                 true,
+    "provisioning_callback_url":   true,
+    "provisioning_callback_token": true,
+    "provisioning_request":        true,
+    "http_proxy":                  true,
+    "https_proxy":                 true,
+    "no_proxy":                    true,
+    "apt_proxy":                   true,
+    "vm_timezone":                 true,
+    "vm_locale":                   true,
+    "ca_bundle_file":              true,
+    "hobbyfarm_user_email_hash":   true,
+    "hobbyfarm_user_access_codes": true,
+    "hobbyfarm_user_groups":       true,
+}
+
+func reservedVariable(name string) bool {
+    return reservedVariables[name] || strings.HasPrefix(name, "ansible_")
+}
+
+// Variables of one object's annotations. Per-variable annotations win over the
+// same name in the multi-line one.
+func variablesFromAnnotations(annotations map[string]string) map[string]string {
+    variables := map[string]string{}
+
+    for _, line := range strings.Split(annotations[variablesAnnotation], "\n") {
+        parts := strings.SplitN(strings.TrimSpace(line), "=", 2)
+        if len(parts) == 2 {
+            variables[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+        }
+    }
+    for key, value := range annotations {
+        if name := strings.TrimPrefix(key, variableAnnotationPrefix); name != key {
+            variables[name] = value
+        }
+    }
+    return variables
+}
+
+// Merge variable layers, later layers winning. Invalid and reserved names are
+// dropped so a course can't take over the connection or the provisioner's own vars.
+func mergeExtraVars(source string, layers ...map[string]string) map[string]string {
+    merged := map[string]string{}
+    for _, layer := range layers {
+        for name, value := range layer {
+            if !variableNamePattern.MatchString(name) {
+                log.Printf("⚠️ Ignoring extra variable %q from %s: not a valid Ansible variable name", name, source)
+                continue
+            }
+            if reservedVariable(name) {
+                log.Printf("⚠️ Ignoring extra variable %s from %s: set by the provisioner", name, source)
+                continue
+            }
+            merged[name] = value
+        }
+    }
+    return merged
+}
+
+// Scenario variables overridden by the Session's
+func (ar *AnsibleRunner) annotationExtraVars(sessionName, scenario string) (scenarioVars, sessionVars map[string]string) {
+    if scenario != "" {
+        if scenarioObj, err := getScenario(ar.client, scenario, "default"); err == nil {
+            scenarioVars = variablesFromAnnotations(scenarioObj.GetAnnotations())
+        }
+    }
+    if sessionName != "" {
+        if session, err := ar.client.Resource(sessionGVR).Namespace("default").Get(
+            context.TODO(), sessionName, metav1.GetOptions{}); err == nil {
+            sessionVars = variablesFromAnnotations(session.GetAnnotations())
+        }
+    }
+    return scenarioVars, sessionVars
+}
+
+// Extra variables of a run: Scenario, then Session annotations, then the
+// request's spec.provisioning.variables, each overriding the one before
+func (ar *AnsibleRunner) extraVars(sessionName, scenario string, requestVars map[string]string) map[string]string {
+    scenarioVars, sessionVars := ar.annotationExtraVars(sessionName, scenario)
+    return mergeExtraVars("session "+sessionName, scenarioVars, sessionVars, requestVars)
+}
@@ -0,0 +1,99 @@
+// internal/failure_reasons.go - Why a request failed, as status.failureReason and a /metrics label
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+const (
+    failureSSHTimeout         = provisioner.FailureSSHTimeout
+    failurePlaybookFailed     = provisioner.FailurePlaybookFailed
+    failureNoCapacity         = provisioner.FailureNoCapacity
+    failureCloudQuota         = provisioner.FailureCloudQuota
+    failureVerificationFailed = provisioner.FailureVerificationFailed
+    failureUnreachable        = provisioner.FailureUnreachable
+    failureCloudError         = provisioner.FailureCloudError
+    failureTenantRefused      = provisioner.FailureTenantRefused
+    failureAdoptionRefused    = provisioner.FailureAdoptionRefused
+)
+
+// Fail a request with one of the failure reasons and the error behind it.
+// vmIP is kept on the request when set, so operators can look at the VM.
+func (kc *KratixController) failRequest(requestName, vmIP, reason, message string) error {
+    status := map[string]interface{}{
+        "state":         provisioner.StateFailed,
+        "provisioned":   false,
+        "failureReason": reason,
+        "lastError":     message,
+        "failedAt":      time.Now().Format(time.RFC3339),
+    }
+    recordLastError(HeartbeatKratixController, "%s %s: %s", requestName, reason, message)
+    if vmIP != "" {
+        status["vmIP"] = vmIP
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        return err
+    }
+    kc.fireRequestHooks(requestName, hookEventFailed)
+    publishStage(stageStatus, requestName)
+    return nil
+}
+
+// AWS limit errors are quota, the rest of the capacity errors mean no room right now
+func cloudFailureReason(awsError string) string {
+    if strings.Contains(awsError, "LimitExceeded") {
+        return failureCloudQuota
+    }
+    return failureNoCapacity
+}
+
+// Failed requests by reason, as gauges so every replica reports the same numbers
+func writeFailureMetrics(w io.Writer, client dynamic.Interface) {
+    counts := make(map[string]int, len(provisioner.FailureReasons))
+    for _, reason := range provisioner.FailureReasons {
+        counts[reason] = 0
+    }
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    unclassified, deadLettered := 0, 0
+    for i, request := range requests.Items {
+        if isDeadLettered(&requests.Items[i]) {
+            deadLettered++
+        }
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state != provisioner.StateFailed {
+            continue
+        }
+        reason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+        if _, known := counts[reason]; known {
+            counts[reason]++
+        } else {
+            unclassified++
+        }
+    }
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_failed_requests VMProvisioningRequests in state failed, by failure reason")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_failed_requests gauge")
+    for _, reason := range provisioner.FailureReasons {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_failed_requests{reason=%q} %d\n", reason, counts[reason])
+    }
+    // Failed before reasons were recorded
+    fmt.Fprintf(w, "hobbyfarm_provisioner_failed_requests{reason=%q} %d\n", "Unknown", unclassified)
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_dead_letter_requests VMProvisioningRequests out of retries, waiting for an operator")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_dead_letter_requests gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_dead_letter_requests %d\n", deadLettered)
+}
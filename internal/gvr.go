@@ -3,28 +3,62 @@ package internal
 import (
     "time"
     "k8s.io/apimachinery/pkg/runtime/schema"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
 )
 
 var (
-    sessionGVR = schema.GroupVersionResource{
-        Group:    "hobbyfarm.io",
+    // Public API resources, defined once in pkg/provisioner
+    sessionGVR               = provisioner.SessionGVR
+    scenarioGVR              = provisioner.ScenarioGVR
+    trainingVMGVR            = provisioner.TrainingVMGVR
+    ec2TrainingVMGVR         = provisioner.EC2TrainingVMGVR
+    vmProvisioningRequestGVR = provisioner.VMProvisioningRequestGVR
+    virtualMachineGVR        = provisioner.VirtualMachineGVR
+    virtualMachineClaimGVR   = provisioner.VirtualMachineClaimGVR
+    courseStatusGVR          = provisioner.CourseProvisioningStatusGVR
+    staticVMPoolGVR          = provisioner.StaticVMPoolGVR
+
+    trainingVMRequestGVR = schema.GroupVersionResource{
+        Group:    "training.example.com",
+        Version:  "v1",
+        Resource: "trainingvmrequests",
+    }
+
+    secretGVR = schema.GroupVersionResource{
+        Group:    "",
+        Version:  "v1",
+        Resource: "secrets",
+    }
+
+    configMapGVR = schema.GroupVersionResource{
+        Group:    "",
+        Version:  "v1",
+        Resource: "configmaps",
+    }
+
+    eventGVR = schema.GroupVersionResource{
+        Group:    "",
         Version:  "v1",
-        Resource: "sessions",
+        Resource: "events",
     }
-    scenarioGVR = schema.GroupVersionResource{
+
+    userGVR = schema.GroupVersionResource{
         Group:    "hobbyfarm.io",
         Version:  "v1",
-        Resource: "scenarios",
+        Resource: "users",
     }
-    trainingVMGVR = schema.GroupVersionResource{
-        Group:    "training.example.com",
+
+    courseGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
         Version:  "v1",
-        Resource: "trainingvms",
+        Resource: "courses",
     }
-    trainingVMRequestGVR = schema.GroupVersionResource{
-        Group:    "training.example.com",
+
+    scheduledEventGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
         Version:  "v1",
-        Resource: "trainingvmrequests",
+        Resource: "scheduledevents",
     }
 
     vmPool = []string{
@@ -34,3 +68,12 @@ var (
 
     allocationTimeout = time.Hour
 )
+
+// Namespace HobbyFarm keeps Sessions, VirtualMachines and Scenarios in; set
+// HOBBYFARM_NAMESPACE when HobbyFarm is installed elsewhere
+func hobbyFarmNamespace() string {
+    if namespace := Setting("HOBBYFARM_NAMESPACE"); namespace != "" {
+        return namespace
+    }
+    return provisioner.HobbyFarmNamespace
+}
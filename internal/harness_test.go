@@ -0,0 +1,435 @@
+// internal/harness_test.go - In-memory harness driving the Kratix controller without VMs, SSH or AWS
+package internal
+
+import (
+    "context"
+    "fmt"
+    "strings"
+    "sync"
+    "testing"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+)
+
+// Every resource the controller lists, with its list kind; the fake client refuses
+// to list anything it was not told about
+func harnessListKinds() map[schema.GroupVersionResource]string {
+    listKinds := map[schema.GroupVersionResource]string{
+        sessionGVR:                  "SessionList",
+        scenarioGVR:                 "ScenarioList",
+        courseGVR:                   "CourseList",
+        trainingVMGVR:               "TrainingVMList",
+        ec2TrainingVMGVR:            "EC2TrainingVMList",
+        vmProvisioningRequestGVR:    "VMProvisioningRequestList",
+        virtualMachineGVR:           "VirtualMachineList",
+        virtualMachineClaimGVR:      "VirtualMachineClaimList",
+        courseStatusGVR:             "CourseProvisioningStatusList",
+        staticVMPoolGVR:             "StaticVMPoolList",
+        trainingVMRequestGVR:        "TrainingVMRequestList",
+        secretGVR:                   "SecretList",
+        configMapGVR:                "ConfigMapList",
+        eventGVR:                    "EventList",
+        userGVR:                     "UserList",
+        scheduledEventGVR:           "ScheduledEventList",
+        leaseGVR:                    "LeaseList",
+        dnsEndpointGVR:              "DNSEndpointList",
+        ec2InstanceGVR:              "InstanceList",
+        ec2KeyPairGVR:               "KeyPairList",
+        webhookVMRequestGVR:         "VMRequestList",
+        awsProviderConfigGVR:        "ProviderConfigList",
+        securityGroupGVR:            "SecurityGroupList",
+        securityGroupIngressRuleGVR: "SecurityGroupIngressRuleList",
+    }
+    return listKinds
+}
+
+// SSH prober answering from maps instead of the network. VMs are reachable,
+// log in as ubuntu and pass every gate unless told otherwise.
+type fakeProber struct {
+    mu          sync.Mutex
+    unreachable map[string]bool
+    gateFailure map[string]string
+    drift       map[string]string
+    versions    map[string]string
+    sshUser     string
+    platform    vmPlatform
+    metadata    map[string]vmMetadata
+    expiries    map[string]time.Time
+    diskFull    map[string]string
+    sshDown     map[string]bool
+}
+
+func newFakeProber() *fakeProber {
+    return &fakeProber{
+        unreachable: map[string]bool{},
+        gateFailure: map[string]string{},
+        drift:       map[string]string{},
+        metadata:    map[string]vmMetadata{},
+        expiries:    map[string]time.Time{},
+        diskFull:    map[string]string{},
+        sshDown:     map[string]bool{},
+        versions:    map[string]string{"docker": "24.0.7", "kubectl": "1.29.0", "helm": "3.14.0", "java": "17.0.9"},
+        sshUser:     "ubuntu",
+        platform: vmPlatform{
+            Family:         "debian",
+            Distribution:   "ubuntu",
+            Version:        "22.04",
+            Arch:           "amd64",
+            PackageManager: "apt",
+        },
+    }
+}
+
+func (p *fakeProber) setReachable(vmIP string, reachable bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.unreachable[vmIP] = !reachable
+}
+
+func (p *fakeProber) Reachable(vmIP string) bool {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return !p.unreachable[vmIP]
+}
+
+// The VM answers pings but SSH never comes up, as when cloud-init hangs
+func (p *fakeProber) setSSHDown(vmIP string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.sshDown[vmIP] = true
+}
+
+func (p *fakeProber) WaitForSSH(vmIP string, timeout time.Duration) error {
+    p.mu.Lock()
+    down := p.sshDown[vmIP]
+    p.mu.Unlock()
+    if down || !p.Reachable(vmIP) {
+        return fmt.Errorf("SSH not available on %s after %v", vmIP, timeout)
+    }
+    return nil
+}
+
+func (p *fakeProber) DetectSSHUser(vmIP string) (string, error) {
+    if !p.Reachable(vmIP) {
+        return "", fmt.Errorf("no SSH user could log in to %s", vmIP)
+    }
+    return p.sshUser, nil
+}
+
+func (p *fakeProber) DetectPlatform(vmIP, sshUser string) (vmPlatform, error) {
+    return p.platform, nil
+}
+
+func (p *fakeProber) setGateFailure(vmIP, gate string) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.gateFailure[vmIP] = gate
+}
+
+func (p *fakeProber) CheckReadinessGates(vmIP string, env vmEnvironment, packages []string) (string, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    if gate := p.gateFailure[vmIP]; gate != "" {
+        return gate, fmt.Errorf("%s gate failed on %s", gate, vmIP)
+    }
+    return "", nil
+}
+
+func (p *fakeProber) DetectDrift(vmIP, sshUser string, packages []string) (string, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.drift[vmIP], nil
+}
+
+// Every VM has the same toolchain versions
+func (p *fakeProber) DetectToolVersions(vmIP, sshUser string, packages []string) (map[string]string, error) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    versions := map[string]string{}
+    for _, command := range toolchainCommands(packages) {
+        if version := p.versions[command]; version != "" {
+            versions[command] = version
+        }
+    }
+    return versions, nil
+}
+
+// Metadata files by VM instead of on the VMs
+func (p *fakeProber) WriteVMMetadata(vmIP, sshUser string, metadata vmMetadata) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.metadata[vmIP] = metadata
+    return nil
+}
+
+func (p *fakeProber) WriteSessionExpiry(vmIP, sshUser string, expiresAt time.Time, paused bool) error {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    p.expiries[vmIP] = expiresAt
+    return nil
+}
+
+// Static VMs have room unless told otherwise
+func (p *fakeProber) DiskGuardRefusal(vmIP string) (string, bool) {
+    p.mu.Lock()
+    defer p.mu.Unlock()
+    return p.diskFull[vmIP], true
+}
+
+// Overlay addresses from 100.64.0.0/16, by the VM's last octets
+func (p *fakeProber) JoinOverlay(vmIP, sessionName, mode string, credentials map[string]string, request *unstructured.Unstructured) (string, error) {
+    if !p.Reachable(vmIP) {
+        return "", fmt.Errorf("failed to detect SSH user: %s unreachable", vmIP)
+    }
+    parts := strings.Split(vmIP, ".")
+    return "100.64." + parts[len(parts)-2] + "." + parts[len(parts)-1], nil
+}
+
+// One playbook run seen by the stub executor
+type playbookRun struct {
+    Playbook  string
+    Session   string
+    Inventory string
+}
+
+// Ansible executor recording runs instead of starting ansible-playbook. Playbooks
+// whose path contains a key of failing fail with that task name.
+type stubExecutor struct {
+    mu      sync.Mutex
+    runs     []playbookRun
+    commands [][]string
+    failing  map[string]string
+}
+
+func newStubExecutor() *stubExecutor {
+    return &stubExecutor{failing: map[string]string{}}
+}
+
+func (e *stubExecutor) RunPlaybook(inventory, playbook, sessionName string, config *ProvisioningConfig) (*PlaybookRecap, []byte, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.runs = append(e.runs, playbookRun{Playbook: playbook, Session: sessionName, Inventory: inventory})
+
+    recap := &PlaybookRecap{Playbook: playbook, Ok: 1}
+    for match, task := range e.failing {
+        if strings.Contains(playbook, match) {
+            recap.Ok = 0
+            recap.Failed = 1
+            recap.FailedTasks = []string{task}
+            return recap, []byte("fatal: " + task), fmt.Errorf("playbook %s failed", playbook)
+        }
+    }
+    return recap, []byte("ok"), nil
+}
+
+// Commands such as request hooks are recorded and succeed
+func (e *stubExecutor) CombinedOutput(env []string, name string, args ...string) ([]byte, error) {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    e.commands = append(e.commands, append([]string{name}, args...))
+    return nil, nil
+}
+
+func (e *stubExecutor) playbookRuns() []playbookRun {
+    e.mu.Lock()
+    defer e.mu.Unlock()
+    return append([]playbookRun(nil), e.runs...)
+}
+
+// Cloud provider storing the claim and, on the next boot call, marking it running
+// with an address from 10.99.0.0/16 as the Crossplane composition would
+type fakeCloud struct {
+    harness  *testHarness
+    mu       sync.Mutex
+    launched int
+    err      error
+}
+
+func (c *fakeCloud) CreateInstance(instance *unstructured.Unstructured) error {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if c.err != nil {
+        return c.err
+    }
+    if _, err := c.harness.client.Resource(ec2TrainingVMGVR).Namespace("default").Create(context.TODO(), instance, metav1.CreateOptions{}); err != nil {
+        return err
+    }
+    c.launched++
+    return nil
+}
+
+// Instances outside the harness are never found
+func (c *fakeCloud) LookupInstanceIP(region, instanceID string) (string, error) {
+    return "", fmt.Errorf("instance %s not found", instanceID)
+}
+
+func (c *fakeCloud) ConsoleOutput(region, instanceID string) (string, error) {
+    return "cloud-init: boot finished", nil
+}
+
+// Report every launched instance as running, what the Crossplane composition
+// does once EC2 answers
+func (c *fakeCloud) boot() error {
+    instances, err := c.harness.client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return err
+    }
+    for i, instance := range instances.Items {
+        if ready, _, _ := unstructured.NestedBool(instance.Object, "status", "ready"); ready {
+            continue
+        }
+        status := map[string]interface{}{
+            "vmIP":       fmt.Sprintf("10.99.%d.%d", i/250, i%250+1),
+            "instanceId": fmt.Sprintf("i-fake%08d", i),
+            "state":      "running",
+            "ready":      true,
+        }
+        if err := unstructured.SetNestedMap(instance.Object, status, "status"); err != nil {
+            return err
+        }
+        if _, err := c.harness.client.Resource(ec2TrainingVMGVR).Namespace("default").Update(context.TODO(), &instance, metav1.UpdateOptions{}); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// A KratixController wired to an in-memory API server and the fakes above, so the
+// allocation and request state machine can be driven pass by pass:
+//
+//	h := newTestHarness(t)
+//	h.submitRequest("req-1", "alice", "session-1")
+//	h.step(1)
+//	h.elapse(time.Minute) // past the boot wait
+//	h.step(1)
+//	state := h.requestState("req-1")
+//
+// The environments file and feature flags still come from the process environment.
+type testHarness struct {
+    client     *dynamicfake.FakeDynamicClient
+    controller *KratixController
+    prober     *fakeProber
+    executor   *stubExecutor
+    cloud      *fakeCloud
+}
+
+func newTestHarness(t *testing.T, objects ...runtime.Object) *testHarness {
+    t.Helper()
+    client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), harnessListKinds(), objects...)
+
+    // The fake client has no status subresources, patch status on the objects
+    // until the test ends
+    statusSubresourcesMu.Lock()
+    previous := make(map[schema.GroupVersionResource]bool, len(statusSubresources))
+    for gvr, has := range statusSubresources {
+        previous[gvr] = has
+    }
+    for _, gvr := range statusPatchedResources {
+        statusSubresources[gvr] = false
+    }
+    statusSubresourcesMu.Unlock()
+    t.Cleanup(func() {
+        statusSubresourcesMu.Lock()
+        defer statusSubresourcesMu.Unlock()
+        statusSubresources = previous
+    })
+
+    h := &testHarness{
+        client:   client,
+        prober:   newFakeProber(),
+        executor: newStubExecutor(),
+    }
+    h.cloud = &fakeCloud{harness: h}
+    h.controller = newKratixControllerWith(client, h.prober, h.executor, h.cloud)
+    return h
+}
+
+// Run n controller passes, booting launched cloud instances between them
+func (h *testHarness) step(n int) error {
+    for i := 0; i < n; i++ {
+        h.controller.reconcile()
+        if err := h.cloud.boot(); err != nil {
+            return err
+        }
+    }
+    return nil
+}
+
+// Create a VMProvisioningRequest as the HobbyFarm integration would
+func (h *testHarness) submitRequest(name, user, session string) error {
+    request := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": vmProvisioningRequestGVR.GroupVersion().String(),
+            "kind":       "VMProvisioningRequest",
+            "metadata": map[string]interface{}{
+                "name":      name,
+                "namespace": "default",
+            },
+            "spec": map[string]interface{}{
+                "user":    user,
+                "session": session,
+            },
+        },
+    }
+    _, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Create(context.TODO(), request, metav1.CreateOptions{})
+    return err
+}
+
+func (h *testHarness) request(name string) (*unstructured.Unstructured, error) {
+    return h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+}
+
+// status.state of the request, empty when it doesn't exist
+func (h *testHarness) requestState(name string) string {
+    request, err := h.request(name)
+    if err != nil {
+        return ""
+    }
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    return state
+}
+
+// status.vmIP of the request, empty before allocation
+func (h *testHarness) requestVM(name string) string {
+    request, err := h.request(name)
+    if err != nil {
+        return ""
+    }
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    return vmIP
+}
+
+// Pretend d has passed since every request was allocated, so boot waits,
+// allocation timeouts and the ready SLO target can be crossed without sleeping
+func (h *testHarness) elapse(d time.Duration) error {
+    requests, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return err
+    }
+    for _, request := range requests.Items {
+        shifted := false
+        for _, field := range []string{"allocatedAt", "firstAllocatedAt"} {
+            value, _, _ := unstructured.NestedString(request.Object, "status", field)
+            t, err := time.Parse(time.RFC3339, value)
+            if err != nil {
+                continue
+            }
+            if err := unstructured.SetNestedField(request.Object, t.Add(-d).Format(time.RFC3339), "status", field); err != nil {
+                return err
+            }
+            shifted = true
+        }
+        if !shifted {
+            continue
+        }
+        if _, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Update(context.TODO(), &request, metav1.UpdateOptions{}); err != nil {
+            return err
+        }
+    }
+    return nil
+}
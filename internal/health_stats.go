@@ -0,0 +1,265 @@
+// internal/health_stats.go - Periodic health check, logged every five minutes and served as JSON on /stats
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Subsystem under which the health check records its own failures
+const healthCheckSubsystem = "health-check"
+
+// Result of one health check
+type healthStats struct {
+    GeneratedAt string                     `json:"generatedAt"`
+    StaticVMs   staticPoolHealth           `json:"staticVMs"`
+    TrainingVMs map[string]int             `json:"trainingVMs"`
+    Requests    map[string]int             `json:"requests"`
+    DeadLetter  int                        `json:"deadLetter"`
+    Heartbeats  map[string]heartbeatHealth `json:"heartbeats"`
+    LastErrors  map[string]subsystemError  `json:"lastErrors"`
+    ReadySLO    readySLOReport             `json:"readySLO"`
+    Shard       *shardStatus               `json:"shard,omitempty"`
+    APIServer   *apiServerOutage           `json:"apiServer,omitempty"`
+    // VirtualMachines whose ssh_username something else keeps resetting
+    SSHUsernameFlapping []sshUsernameFlap `json:"sshUsernameFlapping,omitempty"`
+    // Optional APIs not installed, whose subsystems are idle
+    Unavailable []string `json:"unavailable,omitempty"`
+}
+
+type staticPoolHealth struct {
+    Up          int              `json:"up"`
+    Total       int              `json:"total"`
+    Maintenance int              `json:"maintenance"`
+    VMs         []staticVMHealth `json:"vms"`
+}
+
+// A static VM; VMs in maintenance are not probed and count in neither up nor total
+type staticVMHealth struct {
+    IP          string `json:"ip"`
+    Environment string `json:"environment"`
+    Up          bool   `json:"up"`
+    Maintenance string `json:"maintenance,omitempty"`
+}
+
+type heartbeatHealth struct {
+    AgeSeconds int64 `json:"ageSeconds"`
+    Stale      bool  `json:"stale"`
+}
+
+type subsystemError struct {
+    Message string `json:"message"`
+    At      string `json:"at"`
+}
+
+// Latest error of each subsystem, kept until the process restarts
+var lastErrors = struct {
+    sync.Mutex
+    bySubsystem map[string]subsystemError
+}{bySubsystem: map[string]subsystemError{}}
+
+// Record a subsystem's error for /stats next to logging it
+func recordLastError(subsystem, format string, args ...interface{}) {
+    lastErrors.Lock()
+    defer lastErrors.Unlock()
+    lastErrors.bySubsystem[subsystem] = subsystemError{
+        Message: fmt.Sprintf(format, args...),
+        At:      time.Now().Format(time.RFC3339),
+    }
+}
+
+func currentLastErrors() map[string]subsystemError {
+    lastErrors.Lock()
+    defer lastErrors.Unlock()
+    errors := make(map[string]subsystemError, len(lastErrors.bySubsystem))
+    for subsystem, err := range lastErrors.bySubsystem {
+        errors[subsystem] = err
+    }
+    return errors
+}
+
+func currentHeartbeats() map[string]heartbeatHealth {
+    heartbeats := map[string]heartbeatHealth{}
+    for subsystem, age := range heartbeatAges() {
+        heartbeats[subsystem] = heartbeatHealth{
+            AgeSeconds: int64(age.Seconds()),
+            Stale:      age > getHeartbeatStaleAfter(),
+        }
+    }
+    return heartbeats
+}
+
+// The last check's result, served by /stats so a request doesn't probe every VM
+var latestHealthStats = struct {
+    sync.RWMutex
+    stats *healthStats
+}{}
+
+// Probe the static pool and count TrainingVMs and requests by state. A resource
+// that can't be listed keeps its counts at zero and is recorded as an error.
+func collectHealthStats(client dynamic.Interface) *healthStats {
+    stats := &healthStats{
+        GeneratedAt: time.Now().Format(time.RFC3339),
+        TrainingVMs: map[string]int{
+            "pending":     0,
+            "allocated":   0,
+            "provisioned": 0,
+            "failed":      0,
+        },
+        Requests: map[string]int{
+            "pending":                  0,
+            "allocated":                0,
+            "provisioning":             0,
+            stateProvisionedUnverified: 0,
+            "ready":                    0,
+            "failed":                   0,
+        },
+    }
+
+    // Static VM pool, excluding VMs in maintenance
+    maintenanceVMs := getMaintenanceVMs(client)
+    stats.StaticVMs.Maintenance = len(maintenanceVMs)
+    seen := map[string]bool{}
+    for _, environment := range loadVMEnvironments() {
+        for _, vmIP := range environment.StaticVMs {
+            if seen[vmIP] {
+                continue
+            }
+            seen[vmIP] = true
+            vm := staticVMHealth{IP: vmIP, Environment: environment.Name}
+            if reason, inMaintenance := maintenanceVMs[vmIP]; inMaintenance {
+                vm.Maintenance = reason
+                if vm.Maintenance == "" {
+                    vm.Maintenance = "maintenance"
+                }
+            } else {
+                vm.Up = isVMReachable(vmIP)
+                stats.StaticVMs.Total++
+                if vm.Up {
+                    stats.StaticVMs.Up++
+                }
+            }
+            stats.StaticVMs.VMs = append(stats.StaticVMs.VMs, vm)
+        }
+    }
+    sort.Slice(stats.StaticVMs.VMs, func(i, j int) bool { return stats.StaticVMs.VMs[i].IP < stats.StaticVMs.VMs[j].IP })
+
+    // TrainingVMs, unless their CRD is missing and the counts stay zero
+    if apiAvailable(apiTraining) {
+        trainingVMs, err := client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+        if err != nil {
+            log.Printf("⚠️ Health check failed to list TrainingVMs: %v", err)
+            recordLastError(healthCheckSubsystem, "listing TrainingVMs: %v", err)
+        } else {
+            for _, tvm := range trainingVMs.Items {
+                state, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
+                provisioned, _, _ := unstructured.NestedBool(tvm.Object, "status", "provisioned")
+                switch {
+                case state == "allocated" && provisioned:
+                    stats.TrainingVMs["provisioned"]++
+                case state == "allocated":
+                    stats.TrainingVMs["allocated"]++
+                case state == "failed":
+                    stats.TrainingVMs["failed"]++
+                default:
+                    stats.TrainingVMs["pending"]++
+                }
+            }
+        }
+    }
+
+    // VMProvisioningRequests (Kratix)
+    if apiAvailable(apiKratix) {
+        requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+        if err != nil {
+            log.Printf("⚠️ Health check failed to list VMProvisioningRequests: %v", err)
+            recordLastError(healthCheckSubsystem, "listing VMProvisioningRequests: %v", err)
+        } else {
+            for i, request := range requests.Items {
+                state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+                if state == "" {
+                    state = "pending"
+                }
+                stats.Requests[state]++
+                if isDeadLettered(&requests.Items[i]) {
+                    stats.DeadLetter++
+                }
+            }
+        }
+    }
+
+    stats.Heartbeats = currentHeartbeats()
+    for _, api := range optionalAPIs {
+        if !apiAvailable(api.name) {
+            stats.Unavailable = append(stats.Unavailable, api.name)
+        }
+    }
+    stats.LastErrors = currentLastErrors()
+    stats.ReadySLO = currentReadySLO(client)
+    stats.Shard = currentShardStatus()
+    stats.APIServer = currentAPIServerOutage()
+    stats.SSHUsernameFlapping = currentSSHUsernameFlaps(client)
+    return stats
+}
+
+// Run a health check: warn about stalled loops, keep the stats for /stats and
+// log a summary every five minutes
+func PerformHealthCheck(client dynamic.Interface) {
+    // A controller loop that stopped completing cycles while the process lives on
+    for subsystem, age := range StaleHeartbeats() {
+        log.Printf("🚨 %s loop has not completed a cycle for %s", subsystem, age.Round(time.Second))
+    }
+
+    stats := collectHealthStats(client)
+    latestHealthStats.Lock()
+    latestHealthStats.stats = stats
+    latestHealthStats.Unlock()
+
+    if time.Now().Minute()%5 == 0 {
+        log.Printf("💓 Health Summary:")
+        log.Printf("   📊 Static VMs: %d/%d up, %d in maintenance", stats.StaticVMs.Up, stats.StaticVMs.Total, stats.StaticVMs.Maintenance)
+        log.Printf("   📊 TrainingVMs: pending=%d, allocated=%d, provisioned=%d, failed=%d",
+            stats.TrainingVMs["pending"], stats.TrainingVMs["allocated"], stats.TrainingVMs["provisioned"], stats.TrainingVMs["failed"])
+        log.Printf("   📊 Kratix Requests: pending=%d, allocated=%d, provisioning=%d, unverified=%d, ready=%d, failed=%d",
+            stats.Requests["pending"], stats.Requests["allocated"], stats.Requests["provisioning"], stats.Requests[stateProvisionedUnverified], stats.Requests["ready"], stats.Requests["failed"])
+        if len(stats.ReadySLO.Days) > 0 {
+            today := stats.ReadySLO.Days[0]
+            log.Printf("   📊 Ready SLO %s: %d/%d within %ds (%.1f%%, objective %.1f%%)", today.Day, today.WithinTarget, today.Ready,
+                stats.ReadySLO.TargetSeconds, today.Attainment*100, stats.ReadySLO.Objective*100)
+        }
+    }
+}
+
+// GET /stats: the last health check as JSON, with heartbeats and errors as of
+// now. Before the first check the stats are collected on the spot.
+func (ws *WebhookServer) statsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    latestHealthStats.RLock()
+    stats := latestHealthStats.stats
+    latestHealthStats.RUnlock()
+    if stats == nil {
+        stats = collectHealthStats(ws.client)
+    } else {
+        current := *stats
+        current.Heartbeats = currentHeartbeats()
+        current.LastErrors = currentLastErrors()
+        stats = &current
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(stats)
+}
@@ -0,0 +1,155 @@
+// internal/heartbeats.go - Per-subsystem liveness: every controller loop stamps a heartbeat each cycle
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "os"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    leaseGVR = schema.GroupVersionResource{
+        Group:    "coordination.k8s.io",
+        Version:  "v1",
+        Resource: "leases",
+    }
+)
+
+// Subsystems stamping heartbeats, each into Lease hobbyfarm-provisioner-<subsystem>
+const (
+    HeartbeatKratixController    = "kratix-controller"
+    HeartbeatKratixIntegration   = "kratix-integration"
+    HeartbeatHobbyFarmController = "hobbyfarm-controller"
+    HeartbeatVMAllocator         = "vm-allocator"
+    HeartbeatDeprovision         = "deprovision"
+    HeartbeatClaimBinding        = "claim-binding"
+    HeartbeatCleanup             = "cleanup"
+    HeartbeatPoolDiscovery       = "pool-discovery"
+    HeartbeatNetBoxSync          = "netbox-sync"
+    HeartbeatCourseStatus        = "course-status"
+    HeartbeatProvisioningWorker  = "provisioning-worker"
+)
+
+// Provisioning runs inside the loops, so one cycle can legitimately take several
+// playbook timeouts; the default leaves room for that
+func getHeartbeatStaleAfter() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("HEARTBEAT_STALE_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 15 * time.Minute
+}
+
+var heartbeats = struct {
+    sync.Mutex
+    last map[string]time.Time
+}{last: map[string]time.Time{}}
+
+// Record a completed loop cycle and renew the subsystem's Lease so liveness is
+// visible from the cluster as well (kubectl get lease -l app=hobbyfarm-provisioner)
+func Heartbeat(client dynamic.Interface, subsystem string) {
+    now := time.Now()
+    heartbeats.Lock()
+    heartbeats.last[subsystem] = now
+    heartbeats.Unlock()
+
+    // During an outage the renewal is what notices the API server is back, and its
+    // failure was already logged as the outage
+    if err := renewHeartbeatLease(client, subsystem, now); err != nil && !apiOutage(err) {
+        log.Printf("⚠️ Could not renew heartbeat Lease of %s: %v", subsystem, err)
+    }
+}
+
+func renewHeartbeatLease(client dynamic.Interface, subsystem string, now time.Time) error {
+    name := "hobbyfarm-provisioner-" + subsystem
+    holder, _ := os.Hostname()
+    spec := map[string]interface{}{
+        "holderIdentity":       holder,
+        "leaseDurationSeconds": int64(getHeartbeatStaleAfter().Seconds()),
+        "renewTime":            now.UTC().Format(metav1.RFC3339Micro),
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{"spec": spec})
+    _, err := client.Resource(leaseGVR).Namespace("default").Patch(
+        context.TODO(), name, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsNotFound(err) {
+        lease := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "coordination.k8s.io/v1",
+                "kind":       "Lease",
+                "metadata": map[string]interface{}{
+                    "name":      name,
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app":                                "hobbyfarm-provisioner",
+                        "provisioning.hobbyfarm.io/heartbeat": subsystem,
+                    },
+                },
+                "spec": spec,
+            },
+        }
+        _, err = client.Resource(leaseGVR).Namespace("default").Create(context.TODO(), lease, metav1.CreateOptions{})
+    }
+    return err
+}
+
+// Age of the last heartbeat of every subsystem that has stamped one. Subsystems
+// not started in the current integration mode never appear.
+func heartbeatAges() map[string]time.Duration {
+    heartbeats.Lock()
+    defer heartbeats.Unlock()
+
+    ages := make(map[string]time.Duration, len(heartbeats.last))
+    for subsystem, last := range heartbeats.last {
+        ages[subsystem] = time.Since(last)
+    }
+    return ages
+}
+
+// Subsystems whose loop has not completed a cycle within HEARTBEAT_STALE_MINUTES
+func StaleHeartbeats() map[string]time.Duration {
+    stale := map[string]time.Duration{}
+    for subsystem, age := range heartbeatAges() {
+        if age > getHeartbeatStaleAfter() {
+            stale[subsystem] = age
+        }
+    }
+    return stale
+}
+
+func writeHeartbeatMetrics(w io.Writer) {
+    ages := heartbeatAges()
+    subsystems := make([]string, 0, len(ages))
+    for subsystem := range ages {
+        subsystems = append(subsystems, subsystem)
+    }
+    sort.Strings(subsystems)
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_heartbeat_age_seconds Seconds since the subsystem's loop last completed a cycle")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_heartbeat_age_seconds gauge")
+    for _, subsystem := range subsystems {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_heartbeat_age_seconds{subsystem=%q} %.0f\n", subsystem, ages[subsystem].Seconds())
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_heartbeat_stale 1 when the subsystem's heartbeat is older than HEARTBEAT_STALE_MINUTES")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_heartbeat_stale gauge")
+    for _, subsystem := range subsystems {
+        stale := 0
+        if ages[subsystem] > getHeartbeatStaleAfter() {
+            stale = 1
+        }
+        fmt.Fprintf(w, "hobbyfarm_provisioner_heartbeat_stale{subsystem=%q} %d\n", subsystem, stale)
+    }
+}
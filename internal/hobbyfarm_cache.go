@@ -0,0 +1,209 @@
+// internal/hobbyfarm_cache.go - Informer-backed cache of HobbyFarm Scenarios and Courses shared by every consumer
+package internal
+
+import (
+    "context"
+    "encoding/base64"
+    "log"
+    "strings"
+    "sync"
+    "time"
+    "unicode/utf8"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/dynamic/dynamicinformer"
+    "k8s.io/client-go/tools/cache"
+)
+
+// Namespaces HobbyFarm catalog objects are looked up in, in this order unless a caller says otherwise
+func catalogNamespaces() []string {
+    return []string{hobbyFarmNamespace(), "default"}
+}
+
+// A resource of one namespace the cache follows
+type catalogKey struct {
+    gvr       schema.GroupVersionResource
+    namespace string
+}
+
+// Listers of the started cache and the client they were built for; lookups
+// through any other client, e.g. a fake one, go to the API directly
+var hobbyFarmCache = struct {
+    sync.RWMutex
+    client  dynamic.Interface
+    listers map[catalogKey]cache.GenericLister
+    synced  map[catalogKey]cache.InformerSynced
+}{}
+
+// Watch Scenarios and Courses in the catalog namespaces until ctx is done. A
+// resource that can't be listed, like Courses without HobbyFarm installed, is
+// left out and read from the API on every lookup as before.
+func StartHobbyFarmCache(ctx context.Context, client dynamic.Interface) {
+    listers := map[catalogKey]cache.GenericLister{}
+    synced := map[catalogKey]cache.InformerSynced{}
+
+    for _, ns := range catalogNamespaces() {
+        factory := dynamicinformer.NewFilteredDynamicSharedInformerFactory(client, 10*time.Minute, ns, nil)
+        for _, gvr := range []schema.GroupVersionResource{scenarioGVR, courseGVR} {
+            if _, err := client.Resource(gvr).Namespace(ns).List(context.TODO(), metav1.ListOptions{Limit: 1}); err != nil {
+                log.Printf("⚠️ Not caching %s in %s, reading them directly: %v", gvr.Resource, ns, err)
+                continue
+            }
+            informer := factory.ForResource(gvr)
+            informer.Informer().AddEventHandler(cache.ResourceEventHandlerFuncs{
+                DeleteFunc: forgetCatalogText,
+            })
+            key := catalogKey{gvr: gvr, namespace: ns}
+            listers[key] = informer.Lister()
+            synced[key] = informer.Informer().HasSynced
+        }
+        factory.Start(ctx.Done())
+    }
+
+    hobbyFarmCache.Lock()
+    hobbyFarmCache.client = client
+    hobbyFarmCache.listers = listers
+    hobbyFarmCache.synced = synced
+    hobbyFarmCache.Unlock()
+    log.Printf("📚 Caching HobbyFarm scenarios and courses (%d informers)", len(listers))
+}
+
+// The cached lister of a resource, nil until it has synced or when lookups
+// through client must not use the cache
+func catalogLister(client dynamic.Interface, gvr schema.GroupVersionResource, namespace string) cache.GenericLister {
+    hobbyFarmCache.RLock()
+    defer hobbyFarmCache.RUnlock()
+
+    if hobbyFarmCache.client == nil || hobbyFarmCache.client != client {
+        return nil
+    }
+    key := catalogKey{gvr: gvr, namespace: namespace}
+    if synced := hobbyFarmCache.synced[key]; synced == nil || !synced() {
+        return nil
+    }
+    return hobbyFarmCache.listers[key]
+}
+
+// The named object from the first namespace holding it. A cache miss is read
+// from the API, so an object created moments ago is found before its watch event.
+// Callers get their own copy and may change it.
+func getCatalogObject(client dynamic.Interface, gvr schema.GroupVersionResource, name string, namespaces []string) (*unstructured.Unstructured, error) {
+    if len(namespaces) == 0 {
+        namespaces = catalogNamespaces()
+    }
+    var lastErr error
+    for _, ns := range namespaces {
+        if lister := catalogLister(client, gvr, ns); lister != nil {
+            if obj, err := lister.ByNamespace(ns).Get(name); err == nil {
+                if u, ok := obj.(*unstructured.Unstructured); ok {
+                    return u.DeepCopy(), nil
+                }
+            }
+        }
+        obj, err := client.Resource(gvr).Namespace(ns).Get(context.TODO(), name, metav1.GetOptions{})
+        if err == nil {
+            return obj, nil
+        }
+        lastErr = err
+    }
+    if lastErr == nil {
+        lastErr = apierrors.NewNotFound(gvr.GroupResource(), name)
+    }
+    return nil, lastErr
+}
+
+// A Scenario by name, from hobbyfarm-system then default unless namespaces are given
+func getScenario(client dynamic.Interface, name string, namespaces ...string) (*unstructured.Unstructured, error) {
+    return getCatalogObject(client, scenarioGVR, name, namespaces)
+}
+
+// A Course by name, from hobbyfarm-system then default unless namespaces are given
+func getCourse(client dynamic.Interface, name string, namespaces ...string) (*unstructured.Unstructured, error) {
+    return getCatalogObject(client, courseGVR, name, namespaces)
+}
+
+// Human readable name and description of a Scenario or Course
+type catalogText struct {
+    Name        string
+    Description string
+}
+
+// Decoded texts by object, valid while its resourceVersion is unchanged
+var catalogTexts = struct {
+    sync.Mutex
+    byObject map[string]catalogTextEntry
+}{byObject: map[string]catalogTextEntry{}}
+
+type catalogTextEntry struct {
+    resourceVersion string
+    text            catalogText
+}
+
+func catalogTextKey(obj *unstructured.Unstructured) string {
+    return obj.GetKind() + "/" + obj.GetNamespace() + "/" + obj.GetName()
+}
+
+// HobbyFarm stores spec.name and spec.description base64 encoded; older objects
+// and hand-written ones may hold plain text, which is kept as it is
+func decodeCatalogField(value string) string {
+    decoded, err := base64.StdEncoding.DecodeString(strings.TrimSpace(value))
+    if err != nil || !utf8.Valid(decoded) {
+        return value
+    }
+    return string(decoded)
+}
+
+// Decoded spec.name and spec.description of a Scenario or Course, decoded once per
+// resourceVersion. The name falls back to the object's name.
+func getCatalogText(obj *unstructured.Unstructured) catalogText {
+    key := catalogTextKey(obj)
+    catalogTexts.Lock()
+    entry, found := catalogTexts.byObject[key]
+    catalogTexts.Unlock()
+    if found && entry.resourceVersion == obj.GetResourceVersion() {
+        return entry.text
+    }
+
+    name, _, _ := unstructured.NestedString(obj.Object, "spec", "name")
+    description, _, _ := unstructured.NestedString(obj.Object, "spec", "description")
+    text := catalogText{Name: decodeCatalogField(name), Description: decodeCatalogField(description)}
+    if text.Name == "" {
+        text.Name = obj.GetName()
+    }
+
+    catalogTexts.Lock()
+    catalogTexts.byObject[key] = catalogTextEntry{resourceVersion: obj.GetResourceVersion(), text: text}
+    catalogTexts.Unlock()
+    return text
+}
+
+// Drop the decoded texts of a deleted object
+func forgetCatalogText(obj interface{}) {
+    if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+        obj = tombstone.Obj
+    }
+    if u, ok := obj.(*unstructured.Unstructured); ok {
+        catalogTexts.Lock()
+        delete(catalogTexts.byObject, catalogTextKey(u))
+        catalogTexts.Unlock()
+    }
+}
+
+// Display name of a course label: the Course's, else the Scenario's for requests
+// labelled with their scenario, else the label itself
+func courseDisplayName(client dynamic.Interface, course string) string {
+    if course == "" {
+        return ""
+    }
+    if obj, err := getCourse(client, course); err == nil {
+        return getCatalogText(obj).Name
+    }
+    if obj, err := getScenario(client, course); err == nil {
+        return getCatalogText(obj).Name
+    }
+    return course
+}
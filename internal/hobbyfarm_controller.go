@@ -11,58 +11,46 @@ import (
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-    "k8s.io/apimachinery/pkg/runtime/schema"
     "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
 )
 
-var (
-    // HobbyFarm VirtualMachine GVR - Created by HobbyFarm's VMClaim controller
-    virtualMachineGVR = schema.GroupVersionResource{
-        Group:    "hobbyfarm.io",
-        Version:  "v1",
-        Resource: "virtualmachines",
-    }
-    
-    // HobbyFarm VirtualMachineClaim GVR - Created by Session controller
-    virtualMachineClaimGVR = schema.GroupVersionResource{
-        Group:    "hobbyfarm.io",
-        Version:  "v1",
-        Resource: "virtualmachineclaims",
-    }
-)
-
 type HobbyFarmController struct {
     client        dynamic.Interface
     ansibleRunner *AnsibleRunner
     
     // Track sessions we've already processed
-    processedSessions map[string]bool
+    processedSessions *trackingCache
 }
 
 func NewHobbyFarmController(client dynamic.Interface) *HobbyFarmController {
     return &HobbyFarmController{
         client:            client,
         ansibleRunner:     NewAnsibleRunner(client),
-        processedSessions: make(map[string]bool),
+        processedSessions: newTrackingCache("hobbyfarm-sessions"),
     }
 }
 
 // MAIN ENTRY POINT: Watch for Sessions (what HobbyFarm actually creates)
 func (hfc *HobbyFarmController) WatchHobbyFarmVMs() {
     log.Println("🎓 Starting HobbyFarm Session-based Controller...")
-    log.Println("🎯 PRIMARY: Watching for new Sessions in hobbyfarm-system namespace")
+    log.Printf("🎯 PRIMARY: Watching for new Sessions in %s namespace", hobbyFarmNamespace())
     log.Println("🎯 INTEGRATION: Creating TrainingVMs for provisioning")
     log.Println("🎯 STATUS: Updating HobbyFarm VirtualMachine status")
     log.Println("🚫 DISABLED: Dual session creation prevention active")
     
     for {
-        // PRIMARY: Watch for new Sessions (what triggers everything)
-        hfc.watchSessions()
-        
-        // STATUS UPDATE: Update HobbyFarm VirtualMachine status when TrainingVMs are ready
-        hfc.updateHobbyFarmVMStatus()
+        // Sessions wait for the TrainingVM CRD and the API server rather than failing to create TrainingVMs
+        if apiAvailable(apiTraining) && clusterReachable() {
+            // PRIMARY: Watch for new Sessions (what triggers everything)
+            hfc.watchSessions()
+            
+            // STATUS UPDATE: Update HobbyFarm VirtualMachine status when TrainingVMs are ready
+            hfc.updateHobbyFarmVMStatus()
+        }
+        reportStartupReconciliation(hfc.client)
         
+        Heartbeat(hfc.client, HeartbeatHobbyFarmController)
         time.Sleep(10 * time.Second)
     }
 }
@@ -70,32 +58,45 @@ func (hfc *HobbyFarmController) WatchHobbyFarmVMs() {
 // PRIMARY: Watch for NEW Sessions being created - FIXED to prevent dual sessions
 func (hfc *HobbyFarmController) watchSessions() {
     // ONLY watch hobbyfarm-system namespace to prevent dual session creation
-    sessions, err := hfc.client.Resource(sessionGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    sessions, err := hfc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err != nil {
-        log.Printf("⚠️ Could not list Sessions in namespace hobbyfarm-system: %v", err)
+        log.Printf("⚠️ Could not list Sessions in namespace %s: %v", hobbyFarmNamespace(), err)
+        recordLastError(HeartbeatHobbyFarmController, "listing Sessions: %v", err)
         return
     }
 
     if len(sessions.Items) > 0 {
-        log.Printf("🔍 Found %d Sessions in namespace hobbyfarm-system", len(sessions.Items))
+        log.Printf("🔍 Found %d Sessions in namespace %s", len(sessions.Items), hobbyFarmNamespace())
     }
 
     newSessions := 0
     for _, session := range sessions.Items {
         sessionName := session.GetName()
-        sessionKey := fmt.Sprintf("hobbyfarm-system/%s", sessionName)
+        sessionKey := sessionTrackingKey(hobbyFarmNamespace(), sessionName)
+        
+        // Skip if we've already processed this session or it is another shard's
+        if hfc.processedSessions.has(sessionKey) || !ownsSession(sessionName) {
+            continue
+        }
+        
+        // Finished sessions are being deprovisioned, never create a TrainingVM for them
+        if isSessionFinished(&session) {
+            continue
+        }
         
-        // Skip if we've already processed this session
-        if hfc.processedSessions[sessionKey] {
+        // Sessions claimed by the Kratix integration get no TrainingVM
+        if !claimSessionPathway(hfc.client, &session, pathwayTrainingVM) {
+            hfc.processedSessions.add(sessionKey)
             continue
         }
         
         // Process new session
-        if err := hfc.processNewSession(&session, "hobbyfarm-system"); err != nil {
-            log.Printf("❌ Failed to process new Session %s in hobbyfarm-system: %v", sessionName, err)
+        if err := hfc.processNewSession(&session, hobbyFarmNamespace()); err != nil {
+            log.Printf("❌ Failed to process new Session %s in %s: %v", sessionName, hobbyFarmNamespace(), err)
+            recordLastError(HeartbeatHobbyFarmController, "processing Session %s: %v", sessionName, err)
         } else {
             // Mark as processed
-            hfc.processedSessions[sessionKey] = true
+            hfc.processedSessions.add(sessionKey)
             newSessions++
         }
     }
@@ -127,8 +128,8 @@ func (hfc *HobbyFarmController) processNewSession(session *unstructured.Unstruct
     log.Printf("📝 HobbyFarm session detected - creating TrainingVM directly without duplicating session")
     
     // Create TrainingVM for this session (always in default namespace)
-    trainingVMName := sessionName
-    if err := hfc.ensureTrainingVMExists(trainingVMName, user, sessionName, scenario); err != nil {
+    trainingVMName := trainingVMNameForSession(sessionName)
+    if err := hfc.ensureTrainingVMExists(trainingVMName, user, sessionName, scenario, session); err != nil {
         return fmt.Errorf("failed to create TrainingVM: %v", err)
     }
     
@@ -156,7 +157,7 @@ func (hfc *HobbyFarmController) updateHobbyFarmVMStatus() {
             log.Printf("🔄 TrainingVM %s is ready (IP: %s), updating HobbyFarm VirtualMachine...", tvmName, tvmIP)
             
             // Find corresponding HobbyFarm VirtualMachine
-            err = hfc.updateCorrespondingVirtualMachine(tvmName, tvmIP)
+            err = hfc.updateCorrespondingVirtualMachine(tvmName, tvmIP, getObjectEnvironment(&tvm))
             if err != nil {
                 log.Printf("❌ Failed to update VirtualMachine for %s: %v", tvmName, err)
             }
@@ -165,9 +166,9 @@ func (hfc *HobbyFarmController) updateHobbyFarmVMStatus() {
 }
 
 // Update the corresponding HobbyFarm VirtualMachine - ENHANCED with SSH credentials
-func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, vmIP string) error {
+func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, vmIP, environment string) error {
     // Get the session to extract user information
-    session, err := hfc.client.Resource(sessionGVR).Namespace("hobbyfarm-system").Get(
+    session, err := hfc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(
         context.TODO(), sessionName, metav1.GetOptions{})
     if err != nil {
         log.Printf("❌ Failed to get session %s: %v", sessionName, err)
@@ -178,7 +179,7 @@ func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, v
     log.Printf("🔍 Looking for VirtualMachine for session %s (user: %s)", sessionName, sessionUser)
     
     // Try to find VirtualMachine that matches this session's user
-    virtualMachines, err := hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    virtualMachines, err := hfc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         return err
     }
@@ -199,20 +200,25 @@ func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, v
             
             log.Printf("🔄 Updating VirtualMachine %s with IP %s", vmName, vmIP)
             
-            // ENHANCED: Update status with proper ws_endpoint
+            // SSH credentials and shell endpoint of the TrainingVM's environment
+            env, _ := getVMEnvironment(environment)
+            specUpdate, wsEndpoint := hobbyFarmVMAccess(hfc.client, env, vmIP)
+
+            // Don't hand HobbyFarm a VM it can't open a shell on; retried next loop
+            if failedGate, err := hfc.ansibleRunner.checkReadinessGates(vmIP, env, nil); err != nil {
+                log.Printf("⏳ TrainingVM %s is provisioned-unverified, %s gate failed: %v", sessionName, failedGate, err)
+                return nil
+            }
+
+            // ENHANCED: Update status with proper ws_endpoint; allocated is only
+            // written when VM_STATUS_OWNED_FIELDS claims it from HobbyFarm
             statusUpdate := map[string]interface{}{
                 "status":      "ready",
                 "public_ip":   vmIP,
                 "private_ip":  vmIP,
                 "hostname":    vmIP,
                 "allocated":   true,
-                "ws_endpoint": "ws://shell.192.168.2.47.nip.io", // Force ws:// not wss://
-            }
-            
-            // ENHANCED: Update spec with SSH credentials
-            specUpdate := map[string]interface{}{
-                "secret_name":  "hobbyfarm-vm-ssh-key",
-                "ssh_username": "kube",
+                "ws_endpoint": wsEndpoint, // Force ws:// not wss://
             }
             
             // Update ready label to true
@@ -224,31 +230,25 @@ func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, v
                 },
             }
             
-            // 1. Update spec with SSH credentials
+            // 1. Update spec with SSH credentials, unless ssh_username flaps
+            specUpdate = withoutFlappingSSHUsername(hfc.client, &vm, specUpdate)
             specBytes, err := json.Marshal(map[string]interface{}{"spec": specUpdate})
             if err == nil {
-                _, err = hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").Patch(
+                _, err = hfc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).Patch(
                     context.TODO(), vmName, types.MergePatchType,
-                    specBytes, metav1.PatchOptions{},
+                    specBytes, metav1.PatchOptions{FieldManager: vmFieldManager},
                 )
                 if err != nil {
                     log.Printf("⚠️ Failed to update VM spec with SSH credentials: %v", err)
                 } else {
                     log.Printf("✅ Updated VM spec with SSH credentials")
+                    sshUser, _ := specUpdate["ssh_username"].(string)
+                    recordSSHUsernameFix(hfc.client, &vm, vmIP, sshUser, sshFixSourceHobbyFarmController)
                 }
             }
             
-            // 2. Update status
-            statusBytes, err := json.Marshal(map[string]interface{}{"status": statusUpdate})
-            if err != nil {
-                return err
-            }
-            
-            _, err = hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").Patch(
-                context.TODO(), vmName, types.MergePatchType,
-                statusBytes, metav1.PatchOptions{}, "status",
-            )
-            if err != nil {
+            // 2. Update the status fields the provisioner owns
+            if err := patchVirtualMachineStatus(hfc.client, hobbyFarmNamespace(), vmName, statusUpdate); err != nil {
                 return fmt.Errorf("failed to update status: %v", err)
             }
             
@@ -258,7 +258,7 @@ func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, v
                 return err
             }
             
-            _, err = hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").Patch(
+            _, err = hfc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).Patch(
                 context.TODO(), vmName, types.MergePatchType,
                 labelBytes, metav1.PatchOptions{},
             )
@@ -276,7 +276,7 @@ func (hfc *HobbyFarmController) updateCorrespondingVirtualMachine(sessionName, v
 }
 
 // Ensure TrainingVM exists for session (always in default namespace)
-func (hfc *HobbyFarmController) ensureTrainingVMExists(name, user, session, scenario string) error {
+func (hfc *HobbyFarmController) ensureTrainingVMExists(name, user, session, scenario string, source *unstructured.Unstructured) error {
     // Check if TrainingVM already exists
     existingVM, err := hfc.client.Resource(trainingVMGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
     if err == nil {
@@ -292,6 +292,12 @@ func (hfc *HobbyFarmController) ensureTrainingVMExists(name, user, session, scen
     // Get provisioning config from scenario
     annotations := hfc.getProvisioningAnnotationsForScenario(scenario)
 
+    // The allocator only takes static VMs from the session's environment
+    environment := defaultEnvironmentName
+    if source != nil {
+        environment = resolveSessionEnvironment(hfc.client, source)
+    }
+
     newVM := &unstructured.Unstructured{
         Object: map[string]interface{}{
             "apiVersion": "training.example.com/v1",
@@ -301,9 +307,9 @@ func (hfc *HobbyFarmController) ensureTrainingVMExists(name, user, session, scen
                 "namespace":   "default", // Always create TrainingVMs in default namespace
                 "annotations": annotations,
                 "labels": map[string]interface{}{
-                    "hobbyfarm.io/session":  session,
-                    "hobbyfarm.io/user":     user,
-                    "hobbyfarm.io/scenario": scenario,
+                    "hobbyfarm.io/user":     safeLabelValue(user),
+                    "hobbyfarm.io/scenario": safeLabelValue(scenario),
+                    environmentLabel:        environment,
                     "provisioner":           "hobbyfarm-hybrid",
                     "created-by":            "hybrid-provisioner",
                 },
@@ -314,13 +320,15 @@ func (hfc *HobbyFarmController) ensureTrainingVMExists(name, user, session, scen
             },
         },
     }
+    setSessionLabel(newVM, session)
+    applyPassthroughMetadata(newVM, source)
 
-    _, err = hfc.client.Resource(trainingVMGVR).Namespace("default").Create(context.TODO(), newVM, metav1.CreateOptions{})
-    if err != nil {
+    // Created meanwhile by another pass: keep its spec, the allocator may have acted on it
+    if _, _, err := createOrAdopt(hfc.client, trainingVMGVR, newVM, adoptMetadata); err != nil {
         return fmt.Errorf("failed to create TrainingVM: %v", err)
     }
     
-    log.Printf("✅ Created TrainingVM %s - ready for allocation", name)
+    log.Printf("✅ Created TrainingVM %s - ready for allocation (environment: %s)", name, environment)
     return nil
 }
 
@@ -335,25 +343,14 @@ func (hfc *HobbyFarmController) getProvisioningAnnotationsForScenario(scenario s
     }
 
     // Try to get scenario configuration from both namespaces
-    namespaces := []string{"default", "hobbyfarm-system"}
-    var scenarioObj *unstructured.Unstructured
-    var err error
-    
-    for _, ns := range namespaces {
-        scenarioObj, err = hfc.client.Resource(scenarioGVR).Namespace(ns).Get(
-            context.TODO(), scenario, metav1.GetOptions{})
-        if err == nil {
-            log.Printf("🔍 Found scenario %s in namespace %s", scenario, ns)
-            break
-        }
-    }
-    
+    scenarioObj, err := getScenario(hfc.client, scenario, "default", hobbyFarmNamespace())
     if err != nil {
         log.Printf("⚠️ Could not get scenario %s, using defaults", scenario)
         annotations["provisioning.hobbyfarm.io/playbooks"] = "base.yaml,dynamic.yaml"
         annotations["hobbyfarm.io/integration"] = "hybrid-provisioner"
         return annotations
     }
+    log.Printf("🔍 Found scenario %s in namespace %s", scenario, scenarioObj.GetNamespace())
 
     scenarioAnnotations := scenarioObj.GetAnnotations()
     if scenarioAnnotations != nil {
@@ -383,20 +380,15 @@ func (hfc *HobbyFarmController) CleanupReleasedVMs() {
     // Clean up processed sessions map (keep only active sessions from hobbyfarm-system)
     activeSessions := make(map[string]bool)
     
-    sessions, err := hfc.client.Resource(sessionGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    sessions, err := hfc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err == nil {
         for _, session := range sessions.Items {
-            sessionKey := fmt.Sprintf("hobbyfarm-system/%s", session.GetName())
-            activeSessions[sessionKey] = true
+            activeSessions[sessionTrackingKey(hobbyFarmNamespace(), session.GetName())] = true
         }
     }
     
     // Remove processed sessions that no longer exist
-    for sessionKey := range hfc.processedSessions {
-        if !activeSessions[sessionKey] {
-            delete(hfc.processedSessions, sessionKey)
-        }
-    }
+    hfc.processedSessions.retain(func(sessionKey string) bool { return activeSessions[sessionKey] })
     
-    log.Printf("🧹 Cleaned up processed sessions map, tracking %d active sessions", len(hfc.processedSessions))
+    log.Printf("🧹 Cleaned up processed sessions map, tracking %d active sessions", hfc.processedSessions.len())
 }
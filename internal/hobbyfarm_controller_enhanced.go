@@ -16,7 +16,7 @@ import (
 // Additional function to handle the VM claim mismatch
 func (hfc *HobbyFarmController) updateVirtualMachineStatusesEnhanced() {
     // Get all sessions first to understand the expected VM claims
-    sessions, err := hfc.client.Resource(sessionGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    sessions, err := hfc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         log.Printf("❌ Failed to list sessions: %v", err)
         return
@@ -61,11 +61,15 @@ func (hfc *HobbyFarmController) updateVirtualMachineStatusesEnhanced() {
         log.Printf("🔄 Processing ready TrainingVM %s (IP: %s)", tvmName, tvmIP)
         
         // If this TrainingVM corresponds to a session, find the expected VM
-        if expectedVMClaim, exists := sessionToVMClaim[tvmName]; exists {
-            log.Printf("🎯 Session %s expects VM from claim %s", tvmName, expectedVMClaim)
+        sessionName := objectSession(tvm)
+        if sessionName == "" {
+            sessionName = tvmName
+        }
+        if expectedVMClaim, exists := sessionToVMClaim[sessionName]; exists {
+            log.Printf("🎯 Session %s expects VM from claim %s", sessionName, expectedVMClaim)
             
             // Find all VMs that belong to this claim
-            vms, _ := hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{
+            vms, _ := hfc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{
                 LabelSelector: fmt.Sprintf("vmc=%s", expectedVMClaim),
             })
             
@@ -78,9 +82,9 @@ func (hfc *HobbyFarmController) updateVirtualMachineStatusesEnhanced() {
                     
                     // Only update if not already updated
                     if currentStatus != "ready" || currentIP == "" {
-                        log.Printf("🔄 Updating VirtualMachine %s with IP %s for session %s", vmName, tvmIP, tvmName)
-                        if hfc.updateVMStatus(vmName, "hobbyfarm-system", tvmIP) {
-                            log.Printf("✅ Updated VirtualMachine %s for session %s", vmName, tvmName)
+                        log.Printf("🔄 Updating VirtualMachine %s with IP %s for session %s", vmName, tvmIP, sessionName)
+                        if hfc.updateVMStatus(vmName, hobbyFarmNamespace(), tvmIP) {
+                            log.Printf("✅ Updated VirtualMachine %s for session %s", vmName, sessionName)
                             break
                         }
                     }
@@ -101,7 +105,7 @@ func (hfc *HobbyFarmController) updateVirtualMachinesByDirectMatch(tvm *unstruct
     tvmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
     
     // Try to find a VM with matching name
-    vms, _ := hfc.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    vms, _ := hfc.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     
     for _, vm := range vms.Items {
         vmName := vm.GetName()
@@ -111,7 +115,7 @@ func (hfc *HobbyFarmController) updateVirtualMachinesByDirectMatch(tvm *unstruct
             currentIP, _, _ := unstructured.NestedString(vm.Object, "status", "public_ip")
             if currentIP == "" {
                 log.Printf("🔄 Updating VirtualMachine %s with IP %s (direct match)", vmName, tvmIP)
-                hfc.updateVMStatus(vmName, "hobbyfarm-system", tvmIP)
+                hfc.updateVMStatus(vmName, hobbyFarmNamespace(), tvmIP)
             }
         }
     }
@@ -136,18 +140,8 @@ func (hfc *HobbyFarmController) updateVMStatus(vmName, namespace, vmIP string) b
         },
     }
     
-    // Patch status
-    statusBytes, err := json.Marshal(map[string]interface{}{"status": statusUpdate})
-    if err != nil {
-        log.Printf("❌ Failed to marshal status update: %v", err)
-        return false
-    }
-    
-    _, err = hfc.client.Resource(virtualMachineGVR).Namespace(namespace).Patch(
-        context.TODO(), vmName, types.MergePatchType,
-        statusBytes, metav1.PatchOptions{}, "status",
-    )
-    if err != nil {
+    // Patch the status fields the provisioner owns
+    if err := patchVirtualMachineStatus(hfc.client, namespace, vmName, statusUpdate); err != nil {
         log.Printf("❌ Failed to update VM status: %v", err)
         return false
     }
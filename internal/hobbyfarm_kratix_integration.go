@@ -7,6 +7,7 @@ import (
     "fmt"
     "log"
     "strings"
+    "sync"
     "time"
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -17,15 +18,16 @@ import (
 
 type HobbyFarmKratixIntegration struct {
     client             dynamic.Interface
-    processedSessions  map[string]bool
-    updatedVMs         map[string]bool  // NEW: Track updated VMs to prevent loops
+    processedSessions  *trackingCache
+    updatedVMs         *trackingCache  // NEW: Track updated VMs to prevent loops
+    passMu             sync.Mutex      // Held by a pass, so status events and the loop take turns
 }
 
 func NewHobbyFarmKratixIntegration(client dynamic.Interface) *HobbyFarmKratixIntegration {
     return &HobbyFarmKratixIntegration{
         client:            client,
-        processedSessions: make(map[string]bool),
-        updatedVMs:        make(map[string]bool),  // NEW: Initialize updated VMs tracker
+        processedSessions: newTrackingCache("integration-sessions"),
+        updatedVMs:        newTrackingCache("integration-vm-updates"),  // NEW: Initialize updated VMs tracker
     }
 }
 
@@ -34,26 +36,63 @@ func (hki *HobbyFarmKratixIntegration) WatchSessionsForKratix() {
     log.Println("🔗 Starting HobbyFarm → Kratix Integration Controller...")
     log.Println("🎯 Watching HobbyFarm Sessions → Creating Kratix VMProvisioningRequests")
     
+    hki.RecoverProcessedSessions()
+    
     for {
+        // Sessions wait for the Kratix CRDs and the API server rather than failing to create requests
+        if !apiAvailable(apiKratix) || !clusterReachable() {
+            Heartbeat(hki.client, HeartbeatKratixIntegration)
+            time.Sleep(10 * time.Second)
+            continue
+        }
+        
+        hki.passMu.Lock()
+        
         // Watch for new HobbyFarm sessions
         hki.processHobbyFarmSessions()
         
-        // Update HobbyFarm VMs with Kratix results
-        hki.updateHobbyFarmVMsFromKratix()
+        // Update HobbyFarm VMs with Kratix results and mirror Kratix
+        // provisioning status onto the originating Sessions
+        stagePass(stageStatus, hki.syncStatusFromKratix)
         
         // Cleanup processed sessions and updated VMs
         hki.cleanupProcessedSessions()
         hki.cleanupUpdatedVMs()  // NEW: Cleanup updated VMs tracker
         
+        hki.passMu.Unlock()
+        
+        Heartbeat(hki.client, HeartbeatKratixIntegration)
         time.Sleep(10 * time.Second)
     }
 }
 
+// The status stage: HobbyFarm VMs and Sessions take on the results of Kratix
+// requests, and VMs whose request is lost go back to HobbyFarm
+func (hki *HobbyFarmKratixIntegration) syncStatusFromKratix() {
+    hki.updateHobbyFarmVMsFromKratix()
+    hki.syncSessionStatusFromKratix()
+    hki.propagateRequestLoss()
+}
+
+// Run the status stage when a request's state changes, instead of on the next loop
+func (hki *HobbyFarmKratixIntegration) RegisterStageHandlers() {
+    handleStage(stageStatus, func(event stageEvent) error {
+        if !apiAvailable(apiKratix) || !clusterReachable() {
+            return errStageUnavailable
+        }
+        hki.passMu.Lock()
+        defer hki.passMu.Unlock()
+        stagePass(stageStatus, hki.syncStatusFromKratix)
+        return nil
+    })
+}
+
 // Process HobbyFarm sessions and create corresponding Kratix VMProvisioningRequests
 func (hki *HobbyFarmKratixIntegration) processHobbyFarmSessions() {
-    sessions, err := hki.client.Resource(sessionGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    sessions, err := hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         log.Printf("⚠️ Could not list HobbyFarm Sessions: %v", err)
+        recordLastError(HeartbeatKratixIntegration, "listing Sessions: %v", err)
         return
     }
 
@@ -63,10 +102,21 @@ func (hki *HobbyFarmKratixIntegration) processHobbyFarmSessions() {
 
     for _, session := range sessions.Items {
         sessionName := session.GetName()
-        sessionKey := fmt.Sprintf("hobbyfarm-system/%s", sessionName)
+        sessionKey := sessionTrackingKey(hobbyFarmNamespace(), sessionName)
         
-        // Skip if already processed
-        if hki.processedSessions[sessionKey] {
+        // Skip if already processed or another shard's
+        if hki.processedSessions.has(sessionKey) || !ownsSession(sessionName) {
+            continue
+        }
+        
+        // Finished sessions are being deprovisioned, never request a VM for them
+        if isSessionFinished(&session) {
+            continue
+        }
+        
+        // Sessions claimed by the TrainingVM pathway get no request
+        if !claimSessionPathway(hki.client, &session, pathwayKratix) {
+            hki.processedSessions.add(sessionKey)
             continue
         }
         
@@ -85,21 +135,59 @@ func (hki *HobbyFarmKratixIntegration) processHobbyFarmSessions() {
         log.Printf("🎯 NEW HOBBYFARM SESSION: %s → Creating Kratix VMProvisioningRequest", sessionName)
         
         // Create Kratix VMProvisioningRequest
-        if err := hki.createKratixVMRequest(sessionName, user, scenario); err != nil {
+        if err := hki.createKratixVMRequest(sessionName, user, scenario, &session); err != nil {
             log.Printf("❌ Failed to create Kratix VMProvisioningRequest for session %s: %v", sessionName, err)
+            recordLastError(HeartbeatKratixIntegration, "creating request for Session %s: %v", sessionName, err)
             continue
         }
         
         // Mark as processed
-        hki.processedSessions[sessionKey] = true
+        hki.processedSessions.add(sessionKey)
         log.Printf("✅ Created Kratix VMProvisioningRequest for HobbyFarm session %s", sessionName)
     }
 }
 
 // Create Kratix VMProvisioningRequest based on HobbyFarm session
-func (hki *HobbyFarmKratixIntegration) createKratixVMRequest(sessionName, user, scenario string) error {
+func (hki *HobbyFarmKratixIntegration) createKratixVMRequest(sessionName, user, scenario string, session *unstructured.Unstructured) error {
+    kratixRequest := hki.buildKratixVMRequest(sessionName, user, scenario, session)
+    environment := getObjectEnvironment(kratixRequest)
+    
+    // After a restart the request may already exist, adopt it
+    _, created, err := createOrAdopt(hki.client, vmProvisioningRequestGVR, kratixRequest, adoptRequest)
+    if err != nil {
+        return fmt.Errorf("failed to create Kratix VMProvisioningRequest: %v", err)
+    }
+    if !created {
+        log.Printf("♻️ Kratix VMProvisioningRequest %s already exists for HobbyFarm session, adopted it", kratixRequest.GetName())
+        return nil
+    }
+    
+    log.Printf("✅ Created Kratix VMProvisioningRequest %s for HobbyFarm session %s (environment: %s)", kratixRequest.GetName(), sessionName, environment)
+    publishStage(stageDetection, kratixRequest.GetName())
+    return nil
+}
+
+// The VMProvisioningRequest of a HobbyFarm session, as the integration creates it
+func (hki *HobbyFarmKratixIntegration) buildKratixVMRequest(sessionName, user, scenario string, session *unstructured.Unstructured) *unstructured.Unstructured {
     // Get scenario provisioning configuration
-    provisioningConfig := hki.getScenarioProvisioningConfig(scenario)
+    provisioningConfig := hki.getScenarioProvisioningConfig(sessionName, user, scenario)
+    
+    // Static capacity is only taken from the session's environment
+    environment := resolveSessionEnvironment(hki.client, session)
+    
+    // Requests of one class are aggregated into a CourseProvisioningStatus by this label
+    course, _, _ := unstructured.NestedString(session.Object, "spec", "course")
+    
+    // Allocation only hands the request VMs of its tenant's environments
+    tenantName := resolveSessionTenant(hki.client, session)
+    
+    // Profile of the session's access code or ScheduledEvent, e.g. bigger instances for one event
+    cloudFallback := buildCloudFallbackSpec(getScenarioCloudStorage(hki.client, scenario))
+    profile, profiled := resolveSessionProfile(hki.client, session)
+    if profiled {
+        profile.apply(provisioningConfig, cloudFallback)
+        log.Printf("🎟️ Session %s provisioned with profile %s", sessionName, profile.Name)
+    }
     
     // Create VMProvisioningRequest
     kratixRequest := &unstructured.Unstructured{
@@ -107,12 +195,12 @@ func (hki *HobbyFarmKratixIntegration) createKratixVMRequest(sessionName, user,
             "apiVersion": "platform.kratix.io/v1alpha1",
             "kind":       "VMProvisioningRequest",
             "metadata": map[string]interface{}{
-                "name":      sessionName,
+                "name":      requestNameForSession(sessionName),
                 "namespace": "default",
                 "labels": map[string]interface{}{
-                    "hobbyfarm.io/session":   sessionName,
-                    "hobbyfarm.io/user":      user,
-                    "hobbyfarm.io/scenario":  scenario,
+                    "hobbyfarm.io/user":      safeLabelValue(user),
+                    "hobbyfarm.io/scenario":  safeLabelValue(scenario),
+                    environmentLabel:         environment,
                     "source":                 "hobbyfarm-integration",
                 },
                 "annotations": map[string]interface{}{
@@ -124,31 +212,53 @@ func (hki *HobbyFarmKratixIntegration) createKratixVMRequest(sessionName, user,
                 "user":           user,
                 "session":        sessionName,
                 "scenario":       scenario,
+                "environment":    environment,
                 "vmTemplate":     "hybrid-ubuntu-template",
                 "timeout":        600,
                 "preferStaticVM": true,
                 "provisioning":   provisioningConfig,
-                "cloudFallback": map[string]interface{}{
-                    "enabled":      true,
-                    "provider":     "aws",
-                    "instanceType": "t3.micro",
-                    "region":       "us-east-1",
-                },
+                "cloudFallback":  cloudFallback,
             },
         },
     }
+    setSessionLabel(kratixRequest, sessionName)
     
-    _, err := hki.client.Resource(vmProvisioningRequestGVR).Namespace("default").Create(context.TODO(), kratixRequest, metav1.CreateOptions{})
-    if err != nil {
-        return fmt.Errorf("failed to create Kratix VMProvisioningRequest: %v", err)
+    // Ports the scenario's services listen on, opened on cloud VMs and checked on static ones
+    if ports := buildExposedPortsSpec(hki.client, scenario); len(ports) > 0 {
+        unstructured.SetNestedSlice(kratixRequest.Object, ports, "spec", "ports")
     }
     
-    log.Printf("✅ Created Kratix VMProvisioningRequest %s for HobbyFarm session", sessionName)
-    return nil
+    if course != "" {
+        labels := kratixRequest.GetLabels()
+        labels["hobbyfarm.io/course"] = course
+        kratixRequest.SetLabels(labels)
+    }
+    if tenantName != "" {
+        labels := kratixRequest.GetLabels()
+        labels[tenantLabel] = tenantName
+        kratixRequest.SetLabels(labels)
+    }
+    if profiled {
+        labels := kratixRequest.GetLabels()
+        labels[provisioningProfileLabel] = profile.Name
+        kratixRequest.SetLabels(labels)
+    }
+    
+    // Cohort labels from the HobbyFarm User, e.g. access-code.hobbyfarm.io/<code>
+    if userLabels := lookupUserMetadata(hki.client, user).labels(); len(userLabels) > 0 {
+        labels := kratixRequest.GetLabels()
+        for key, value := range userLabels {
+            labels[key] = value
+        }
+        kratixRequest.SetLabels(labels)
+    }
+    
+    applyPassthroughMetadata(kratixRequest, session)
+    return kratixRequest
 }
 
 // Get provisioning configuration from HobbyFarm scenario
-func (hki *HobbyFarmKratixIntegration) getScenarioProvisioningConfig(scenario string) map[string]interface{} {
+func (hki *HobbyFarmKratixIntegration) getScenarioProvisioningConfig(sessionName, user, scenario string) map[string]interface{} {
     config := map[string]interface{}{
         "playbooks":    []string{"base.yaml", "dynamic.yaml"},
         "packages":     []string{},
@@ -161,23 +271,12 @@ func (hki *HobbyFarmKratixIntegration) getScenarioProvisioningConfig(scenario st
     }
     
     // Try to get scenario from both namespaces
-    namespaces := []string{"hobbyfarm-system", "default"}
-    var scenarioObj *unstructured.Unstructured
-    var err error
-    
-    for _, ns := range namespaces {
-        scenarioObj, err = hki.client.Resource(scenarioGVR).Namespace(ns).Get(
-            context.TODO(), scenario, metav1.GetOptions{})
-        if err == nil {
-            log.Printf("🔍 Found scenario %s in namespace %s", scenario, ns)
-            break
-        }
-    }
-    
+    scenarioObj, err := getScenario(hki.client, scenario)
     if err != nil {
         log.Printf("⚠️ Could not get scenario %s, using defaults", scenario)
         return config
     }
+    log.Printf("🔍 Found scenario %s in namespace %s", scenario, scenarioObj.GetNamespace())
     
     // Extract provisioning configuration from scenario annotations
     annotations := scenarioObj.GetAnnotations()
@@ -198,16 +297,7 @@ func (hki *HobbyFarmKratixIntegration) getScenarioProvisioningConfig(scenario st
     }
     
     // Extract packages
-    if packages, exists := annotations["provisioning.hobbyfarm.io/packages"]; exists {
-        packageList := strings.Split(packages, ",")
-        cleanPackages := make([]string, 0, len(packageList))
-        for _, pkg := range packageList {
-            if trimmed := strings.TrimSpace(pkg); trimmed != "" {
-                cleanPackages = append(cleanPackages, trimmed)
-            }
-        }
-        config["packages"] = cleanPackages
-    }
+    config["packages"] = detectPackages(DetectionInput{Session: sessionName, User: user, Scenario: scenario, Annotations: annotations}).Packages
     
     // Extract requirements
     if requirements, exists := annotations["provisioning.hobbyfarm.io/requirements"]; exists {
@@ -221,6 +311,21 @@ func (hki *HobbyFarmKratixIntegration) getScenarioProvisioningConfig(scenario st
         config["requirements"] = cleanReqs
     }
     
+    // Extract execution environment image
+    if image, exists := annotations["provisioning.hobbyfarm.io/execution-environment"]; exists && strings.TrimSpace(image) != "" {
+        config["executionEnvironment"] = strings.TrimSpace(image)
+    }
+    
+    // Extract Ansible version of the venv runtime
+    if version, exists := annotations["provisioning.hobbyfarm.io/ansible-version"]; exists && strings.TrimSpace(version) != "" {
+        config["ansibleVersion"] = strings.TrimSpace(version)
+    }
+    
+    // Extract variables to keep out of logs and artifacts
+    if secrets, exists := annotations[secretVariablesAnnotation]; exists {
+        config["secretVariables"] = splitList(secrets)
+    }
+    
     // Extract variables
     if variables, exists := annotations["provisioning.hobbyfarm.io/variables"]; exists {
         varMap := make(map[string]string)
@@ -253,9 +358,11 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVMsFromKratix() {
     
     for _, request := range requests.Items {
         state, _, _ := unstructured.NestedString(request.Object, "status", "state")
-        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
         provisioned, _, _ := unstructured.NestedBool(request.Object, "status", "provisioned")
         
+        // Overlay VMs are published to HobbyFarm with their overlay address
+        vmIP := getRequestAccessIP(&request)
+        
         // Only process ready and provisioned VMs
         if state != "ready" || !provisioned || vmIP == "" {
             continue
@@ -267,36 +374,39 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVMsFromKratix() {
             continue
         }
         
-        sessionName := labels["hobbyfarm.io/session"]
-        user := labels["hobbyfarm.io/user"]
+        sessionName := objectSession(&request)
+        user, _, _ := unstructured.NestedString(request.Object, "spec", "user")
         
         if sessionName == "" || user == "" {
             continue
         }
         
+        // DNS name registered for the VM, if DNS naming is enabled
+        hostname, _, _ := unstructured.NestedString(request.Object, "status", "hostname")
+        
         // NEW: Check if we already updated this VM for this session
-        updateKey := fmt.Sprintf("%s-%s", sessionName, vmIP)
-        if hki.updatedVMs[updateKey] {
+        updateKey := vmUpdateTrackingKey(request.GetName(), vmIP, hostname)
+        if hki.updatedVMs.has(updateKey) {
             continue // Already updated, skip to prevent loop
         }
         
         log.Printf("🔄 Updating HobbyFarm VirtualMachine for session %s with Kratix result (IP: %s)", sessionName, vmIP)
         
         // Find corresponding HobbyFarm VirtualMachine
-        if err := hki.updateHobbyFarmVirtualMachine(sessionName, user, vmIP); err != nil {
+        if err := hki.updateHobbyFarmVirtualMachine(request.GetName(), sessionName, user, vmIP, hostname, getObjectEnvironment(&request)); err != nil {
             log.Printf("❌ Failed to update HobbyFarm VirtualMachine for session %s: %v", sessionName, err)
         } else {
             // NEW: Mark this VM as updated to prevent future update attempts
-            hki.updatedVMs[updateKey] = true
+            hki.updatedVMs.add(updateKey)
             log.Printf("✅ Marked VM update as complete for session %s", sessionName)
         }
     }
 }
 
 // FINAL FIXED: Update HobbyFarm VirtualMachine with Kratix results
-func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(sessionName, user, vmIP string) error {
+func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(requestName, sessionName, user, vmIP, hostname, environment string) error {
     // Check if session still exists
-    session, err := hki.client.Resource(sessionGVR).Namespace("hobbyfarm-system").Get(
+    session, err := hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(
         context.TODO(), sessionName, metav1.GetOptions{})
     if err != nil {
         log.Printf("⚠️ Session %s no longer exists, skipping VM update", sessionName)
@@ -306,7 +416,7 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(sessionName
     sessionUser, _, _ := unstructured.NestedString(session.Object, "spec", "user")
     
     // Find VirtualMachine that matches this session's user
-    virtualMachines, err := hki.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    virtualMachines, err := hki.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         return err
     }
@@ -316,6 +426,13 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(sessionName
         vmUser, _, _ := unstructured.NestedString(vm.Object, "spec", "user")
         currentStatus, _, _ := unstructured.NestedString(vm.Object, "status", "status")
         currentPublicIP, _, _ := unstructured.NestedString(vm.Object, "status", "public_ip")
+        currentHostname, _, _ := unstructured.NestedString(vm.Object, "status", "hostname")
+        
+        // Prefer the DNS name so HobbyFarm survives IP changes on stop/start
+        wantHostname := vmIP
+        if hostname != "" {
+            wantHostname = hostname
+        }
         
         // FIXED: Match by user, and either needs provisioning OR is already ready but with different IP
         // This prevents the endless loop while still allowing updates when needed
@@ -323,18 +440,24 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(sessionName
             // Case 1: VM needs initial provisioning
             if currentStatus == "readyforprovisioning" && currentPublicIP == "" {
                 log.Printf("🎯 Found HobbyFarm VirtualMachine %s needing initial provisioning", vmName)
-                return hki.performVMUpdate(vmName, vm, vmIP)
+                return hki.performVMUpdate(requestName, sessionName, vmName, vm, vmIP, wantHostname, environment)
             }
             
-            // Case 2: VM is ready but has different IP (unusual but possible)
-            if currentStatus == "ready" && currentPublicIP != vmIP {
+            // Case 2: VM is ready but has different IP or hostname (unusual but possible)
+            if currentStatus == "ready" && (currentPublicIP != vmIP || currentHostname != wantHostname) {
                 log.Printf("🎯 Found HobbyFarm VirtualMachine %s with different IP, updating", vmName)
-                return hki.performVMUpdate(vmName, vm, vmIP)
+                return hki.performVMUpdate(requestName, sessionName, vmName, vm, vmIP, wantHostname, environment)
             }
             
             // Case 3: VM is already correctly updated
             if currentStatus == "ready" && currentPublicIP == vmIP {
                 log.Printf("✅ HobbyFarm VirtualMachine %s already correctly updated (status: ready, IP: %s)", vmName, vmIP)
+                // Link VMs made ready before the link was recorded, so losing the request hands them back
+                if vm.GetAnnotations()[vmRequestAnnotation] != requestName {
+                    return hki.patchVirtualMachine(vmName, "", map[string]interface{}{
+                        "metadata": map[string]interface{}{"annotations": vmRequestLink(requestName, sessionName)},
+                    })
+                }
                 return nil // Already updated correctly, no action needed
             }
         }
@@ -345,75 +468,55 @@ func (hki *HobbyFarmKratixIntegration) updateHobbyFarmVirtualMachine(sessionName
 }
 
 // NEW: Perform the actual VM update
-func (hki *HobbyFarmKratixIntegration) performVMUpdate(vmName string, vm unstructured.Unstructured, vmIP string) error {
-    // Get current status and update only necessary fields
-    currentStatusObj, exists := vm.Object["status"]
-    if !exists {
-        log.Printf("❌ No status found in VirtualMachine %s", vmName)
-        return fmt.Errorf("no status found in VirtualMachine %s", vmName)
-    }
-    
-    statusMap, ok := currentStatusObj.(map[string]interface{})
-    if !ok {
-        log.Printf("❌ Status is not a map in VirtualMachine %s", vmName)
-        return fmt.Errorf("status is not a map in VirtualMachine %s", vmName)
-    }
-    
-    // Update only the fields we need to change, keep all existing fields
-    statusMap["status"] = "ready"
-    statusMap["public_ip"] = vmIP
-    statusMap["private_ip"] = vmIP
-    statusMap["hostname"] = vmIP
-    // All other fields (allocated, environment_id, tainted, ws_endpoint) remain unchanged
-    
-    statusUpdate := map[string]interface{}{
-        "status": statusMap,
-    }
+func (hki *HobbyFarmKratixIntegration) performVMUpdate(requestName, sessionName, vmName string, vm unstructured.Unstructured, vmIP, hostname, environment string) error {
+    // Only the fields the provisioner owns are patched, one by one; the rest of
+    // status (allocated, environment_id, tainted) stays HobbyFarm's
+    statusFields := map[string]interface{}{
+        "status":     "ready",
+        "public_ip":  vmIP,
+        "private_ip": vmIP,
+        "hostname":   hostname,
+    }
+    
+    // SSH credentials of the VM's environment. Named environments bring their own
+    // shell endpoint; for the default one ws_endpoint stays as HobbyFarm set it.
+    env, configured := getVMEnvironment(environment)
+    sshSpec, wsEndpoint := hobbyFarmVMAccess(hki.client, env, vmIP)
+    if configured && env.Name != defaultEnvironmentName {
+        statusFields["ws_endpoint"] = wsEndpoint
+    }
+    // Where something keeps resetting ssh_username, stop fighting over it
+    sshSpec = withoutFlappingSSHUsername(hki.client, &vm, sshSpec)
     
     // Update spec with SSH credentials
     specUpdate := map[string]interface{}{
-        "spec": map[string]interface{}{
-            "secret_name":  "hobbyfarm-vm-ssh-key",
-            "ssh_username": "kube",
-        },
+        "spec": sshSpec,
     }
     
-    // Update ready label
+    // Update ready label, linking the VM to its request for when the request is lost
     labelUpdate := map[string]interface{}{
         "metadata": map[string]interface{}{
             "labels": map[string]interface{}{
                 "ready": "true",
             },
+            "annotations": vmRequestLink(requestName, sessionName),
         },
     }
     
     // Apply updates with proper error handling
+    sshUser, _ := sshSpec["ssh_username"].(string)
     if err := hki.patchVirtualMachine(vmName, "", specUpdate); err != nil {
         log.Printf("⚠️ Failed to update VM spec: %v", err)
     } else {
         log.Printf("✅ Updated VM spec with SSH credentials")
+        recordSSHUsernameFix(hki.client, &vm, vmIP, sshUser, sshFixSourceKratixIntegration)
     }
     
-    if err := hki.patchVirtualMachine(vmName, "status", statusUpdate); err != nil {
+    if err := patchVirtualMachineStatus(hki.client, hobbyFarmNamespace(), vmName, statusFields); err != nil {
         log.Printf("❌ Failed to update VM status: %v", err)
-        // Try alternative approach - patch the whole object
-        wholeUpdate := map[string]interface{}{
-            "spec": map[string]interface{}{
-                "secret_name":  "hobbyfarm-vm-ssh-key",
-                "ssh_username": "kube",
-            },
-            "status": statusMap,
-        }
-        
-        if err2 := hki.patchVirtualMachine(vmName, "", wholeUpdate); err2 != nil {
-            log.Printf("❌ Failed whole VM update: %v", err2)
-            return fmt.Errorf("failed to update VM: %v", err)
-        } else {
-            log.Printf("✅ Updated VM with alternative method")
-        }
-    } else {
-        log.Printf("✅ Updated VM status: ready, IP=%s", vmIP)
+        return fmt.Errorf("failed to update VM: %v", err)
     }
+    log.Printf("✅ Updated VM status: ready, IP=%s", vmIP)
     
     if err := hki.patchVirtualMachine(vmName, "", labelUpdate); err != nil {
         log.Printf("⚠️ Failed to update VM labels: %v", err)
@@ -432,13 +535,13 @@ func (hki *HobbyFarmKratixIntegration) patchVirtualMachine(vmName, subresource s
         return err
     }
     
-    var patchOptions metav1.PatchOptions
+    patchOptions := metav1.PatchOptions{FieldManager: vmFieldManager}
     if subresource != "" {
-        _, err = hki.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").Patch(
+        _, err = hki.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).Patch(
             context.TODO(), vmName, types.MergePatchType,
             patchBytes, patchOptions, subresource)
     } else {
-        _, err = hki.client.Resource(virtualMachineGVR).Namespace("hobbyfarm-system").Patch(
+        _, err = hki.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).Patch(
             context.TODO(), vmName, types.MergePatchType,
             patchBytes, patchOptions)
     }
@@ -451,20 +554,15 @@ func (hki *HobbyFarmKratixIntegration) cleanupProcessedSessions() {
     // Get active sessions
     activeSessions := make(map[string]bool)
     
-    sessions, err := hki.client.Resource(sessionGVR).Namespace("hobbyfarm-system").List(context.TODO(), metav1.ListOptions{})
+    sessions, err := hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
     if err == nil {
         for _, session := range sessions.Items {
-            sessionKey := fmt.Sprintf("hobbyfarm-system/%s", session.GetName())
-            activeSessions[sessionKey] = true
+            activeSessions[sessionTrackingKey(hobbyFarmNamespace(), session.GetName())] = true
         }
     }
     
     // Remove processed sessions that no longer exist
-    for sessionKey := range hki.processedSessions {
-        if !activeSessions[sessionKey] {
-            delete(hki.processedSessions, sessionKey)
-        }
-    }
+    hki.processedSessions.retain(func(sessionKey string) bool { return activeSessions[sessionKey] })
 }
 
 // NEW: Cleanup updated VMs tracker
@@ -476,33 +574,28 @@ func (hki *HobbyFarmKratixIntegration) cleanupUpdatedVMs() {
     if err == nil {
         for _, request := range requests.Items {
             requestName := request.GetName()
-            vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            vmIP := getRequestAccessIP(&request)
+            hostname, _, _ := unstructured.NestedString(request.Object, "status", "hostname")
             if vmIP != "" {
-                updateKey := fmt.Sprintf("%s-%s", requestName, vmIP)
-                activeRequests[updateKey] = true
+                activeRequests[vmUpdateTrackingKey(requestName, vmIP, hostname)] = true
             }
         }
     }
     
-    // Remove tracked updates for requests that no longer exist
-    for updateKey := range hki.updatedVMs {
-        if !activeRequests[updateKey] {
-            delete(hki.updatedVMs, updateKey)
-        }
-    }
+    // Remove tracked updates for requests that no longer exist or moved VM
+    hki.updatedVMs.retain(func(updateKey string) bool { return activeRequests[updateKey] })
 }
 
 // Additional helper functions
 func (hki *HobbyFarmKratixIntegration) GetProcessedSessionsCount() int {
-    return len(hki.processedSessions)
+    return hki.processedSessions.len()
 }
 
 func (hki *HobbyFarmKratixIntegration) IsSessionProcessed(sessionName string) bool {
-    sessionKey := fmt.Sprintf("hobbyfarm-system/%s", sessionName)
-    return hki.processedSessions[sessionKey]
+    return hki.processedSessions.has(sessionTrackingKey(hobbyFarmNamespace(), sessionName))
 }
 
 // NEW: Get updated VMs count
 func (hki *HobbyFarmKratixIntegration) GetUpdatedVMsCount() int {
-    return len(hki.updatedVMs)
+    return hki.updatedVMs.len()
 }
@@ -0,0 +1,114 @@
+// internal/idempotent_create.go - Create resources so that a restart re-creating them adopts what exists
+package internal
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "log"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+// Brings an existing object in line with the desired one, true when it changed it
+type adoptFunc func(existing, desired *unstructured.Unstructured) bool
+
+// Create desired, or adopt the object of the same name when it already exists:
+// controllers forget what they created when they restart, so AlreadyExists means
+// an earlier run did the work. adopt, when set, reconciles the existing object,
+// which is then updated. Returns the object and whether it was created.
+func createOrAdopt(client dynamic.Interface, gvr schema.GroupVersionResource, desired *unstructured.Unstructured, adopt adoptFunc) (*unstructured.Unstructured, bool, error) {
+    resource := client.Resource(gvr).Namespace(desired.GetNamespace())
+    stampProvenance(desired)
+    var created *unstructured.Unstructured
+    // A create that reached the API server before the retry comes back AlreadyExists and is adopted
+    err := retryAPI(func() (err error) {
+        created, err = resource.Create(context.TODO(), desired, metav1.CreateOptions{})
+        return err
+    })
+    if err == nil {
+        return created, true, nil
+    }
+    if !errors.IsAlreadyExists(err) {
+        return nil, false, err
+    }
+
+    // One retry covers an update racing ours; the next pass adopts again otherwise
+    for attempt := 0; ; attempt++ {
+        existing, err := resource.Get(context.TODO(), desired.GetName(), metav1.GetOptions{})
+        if err != nil {
+            return nil, false, err
+        }
+        if adopt == nil || !adopt(existing, desired) {
+            return existing, false, nil
+        }
+        stampProvenance(existing)
+        updated, err := resource.Update(context.TODO(), existing, metav1.UpdateOptions{})
+        if err == nil {
+            log.Printf("♻️ Adopted existing %s %s and reconciled it", gvr.Resource, desired.GetName())
+            return updated, false, nil
+        }
+        if !errors.IsConflict(err) || attempt > 0 {
+            return nil, false, err
+        }
+    }
+}
+
+// Set the labels and annotations desired sets, keeping any others on existing
+func adoptMetadata(existing, desired *unstructured.Unstructured) bool {
+    changed := false
+    labels := existing.GetLabels()
+    if labels == nil {
+        labels = map[string]string{}
+    }
+    for key, value := range desired.GetLabels() {
+        if labels[key] != value {
+            labels[key] = value
+            changed = true
+        }
+    }
+    annotations := existing.GetAnnotations()
+    if annotations == nil {
+        annotations = map[string]string{}
+    }
+    for key, value := range desired.GetAnnotations() {
+        if annotations[key] != value {
+            annotations[key] = value
+            changed = true
+        }
+    }
+    if changed {
+        existing.SetLabels(labels)
+        existing.SetAnnotations(annotations)
+    }
+    return changed
+}
+
+// Adopt a VMProvisioningRequest: metadata always, the spec only while the request
+// waits for a VM. Once allocated, provisioning has started from the old spec and
+// changing it underneath would not be applied anyway.
+func adoptRequest(existing, desired *unstructured.Unstructured) bool {
+    changed := adoptMetadata(existing, desired)
+
+    state, _, _ := unstructured.NestedString(existing.Object, "status", "state")
+    if state != "" && state != "pending" {
+        return changed
+    }
+    existingSpec, _ := json.Marshal(existing.Object["spec"])
+    desiredSpec, _ := json.Marshal(desired.Object["spec"])
+    if bytes.Equal(existingSpec, desiredSpec) {
+        return changed
+    }
+    // Round-trip so the spec holds the same JSON types as one read from the API
+    var spec map[string]interface{}
+    if err := json.Unmarshal(desiredSpec, &spec); err != nil {
+        return changed
+    }
+    existing.Object["spec"] = spec
+    log.Printf("📝 Session of request %s changed, updating its spec", existing.GetName())
+    return true
+}
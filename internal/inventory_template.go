@@ -0,0 +1,106 @@
+// internal/inventory_template.go - Render Ansible inventories from operator-editable templates
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+    "text/template"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Key of the template used unless a scenario or request names another
+const defaultInventoryTemplateKey = "inventory.tmpl"
+
+// Output of the former string-built inventory; used when the ConfigMap or the
+// named key is missing, and when an operator template fails to render
+const defaultInventoryTemplate = `[target]
+{{ range .Hosts }}{{ .IP }} ansible_user={{ .User }} ansible_ssh_private_key_file={{ $.SSHKeyPath }} ansible_ssh_common_args='-o StrictHostKeyChecking=no -o UserKnownHostsFile=/dev/null'{{ range $key, $value := .Vars }} {{ $key }}={{ $value }}{{ end }}
+{{ end }}
+[all:vars]
+ansible_python_interpreter=/usr/bin/python3
+session_name={{ .SessionName }}
+{{ range $key, $value := .PlatformVars }}{{ $key }}={{ $value }}
+{{ end }}{{ range $key, $value := .Variables }}{{ $key }}={{ $value }}
+{{ end }}{{ with .Packages }}session_packages={{ join . "," }}
+{{ end }}{{ with .Requirements }}session_requirements={{ join . "," }}
+{{ end }}`
+
+// ConfigMap holding inventory templates, one per key
+func getInventoryTemplateConfigMapName() string {
+    if name := Setting("INVENTORY_TEMPLATE_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-inventory"
+}
+
+// One machine of the inventory. Single-VM sessions have exactly one; templates
+// should range over .Hosts so multi-VM scenarios render without changes.
+type inventoryHost struct {
+    Name string
+    IP   string
+    User string
+    Vars map[string]string
+}
+
+// Everything a template can use
+type inventoryData struct {
+    Hosts        []inventoryHost
+    SessionName  string
+    SSHKeyPath   string
+    PlatformVars map[string]string
+    Variables    map[string]string
+    Packages     []string
+    Requirements []string
+}
+
+var inventoryTemplateFuncs = template.FuncMap{
+    "join":  strings.Join,
+    "upper": strings.ToUpper,
+    "lower": strings.ToLower,
+    "quote": func(value string) string { return fmt.Sprintf("%q", value) },
+}
+
+// Template text stored under key in the inventory ConfigMap, or the built-in one
+func (ar *AnsibleRunner) inventoryTemplate(key string) string {
+    if key == "" {
+        key = defaultInventoryTemplateKey
+    }
+    if ar.client == nil {
+        return defaultInventoryTemplate
+    }
+
+    configMap, err := ar.client.Resource(configMapGVR).Namespace("default").Get(
+        context.TODO(), getInventoryTemplateConfigMapName(), metav1.GetOptions{})
+    if err != nil {
+        if !errors.IsNotFound(err) {
+            log.Printf("⚠️ Could not read inventory template ConfigMap: %v", err)
+        }
+        return defaultInventoryTemplate
+    }
+
+    text, found, _ := unstructured.NestedString(configMap.Object, "data", key)
+    if !found {
+        if key != defaultInventoryTemplateKey {
+            log.Printf("⚠️ Inventory template %s not found in ConfigMap %s, using the built-in one", key, getInventoryTemplateConfigMapName())
+        }
+        return defaultInventoryTemplate
+    }
+    return text
+}
+
+func renderInventory(text string, data inventoryData) (string, error) {
+    tmpl, err := template.New("inventory").Funcs(inventoryTemplateFuncs).Option("missingkey=zero").Parse(text)
+    if err != nil {
+        return "", fmt.Errorf("invalid inventory template: %v", err)
+    }
+    var inventory strings.Builder
+    if err := tmpl.Execute(&inventory, data); err != nil {
+        return "", fmt.Errorf("inventory template failed: %v", err)
+    }
+    return inventory.String(), nil
+}
@@ -0,0 +1,481 @@
+// internal/key_rotation.go - Rotate the shared provisioning SSH key across pool VMs, cloud VMs and the EC2 keypair
+package internal
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Phase of the rotation, kept on the SSH key Secret so a restarted provisioner resumes it
+const keyRotationAnnotation = "provisioning.hobbyfarm.io/key-rotation"
+
+// A rotation moves through these phases in order. Until it completes the Secret
+// carries the other key as well, and an ssh config offering it, so Ansible and
+// SSH checks get into VMs holding either key and in-flight provisioning carries on.
+const (
+    // The new key is in the Secret as id_rsa.next
+    keyRotationStaged = "staged"
+    // Every VM authorizes the new key
+    keyRotationDeployed = "deployed"
+    // The new key is id_rsa, the old one id_rsa.previous, the EC2 keypair re-imported
+    keyRotationPromoted = "promoted"
+    // Every VM was reached with the new key alone
+    keyRotationVerified = "verified"
+    // The old key is gone from VMs and the Secret
+    keyRotationComplete = "complete"
+)
+
+// Secret keys besides id_rsa and id_rsa.pub used during a rotation
+const (
+    nextKeyName     = "id_rsa.next"
+    previousKeyName = "id_rsa.previous"
+    sshConfigName   = "config"
+)
+
+// How long to wait for the kubelet to update the mounted Secret after a change
+func getKeyRotationSyncTimeout() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("KEY_ROTATION_SYNC_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 3 * time.Minute
+}
+
+// Progress of the last rotation run by this replica, served by GET /key-rotation
+type KeyRotationResult struct {
+    Phase      string            `json:"phase"`
+    Running    bool              `json:"running"`
+    StartedAt  string            `json:"startedAt,omitempty"`
+    FinishedAt string            `json:"finishedAt,omitempty"`
+    Error      string            `json:"error,omitempty"`
+    // Outcome per VM of the last step that touched VMs: "ok" or the error
+    VMs map[string]string `json:"vms,omitempty"`
+}
+
+var keyRotation = struct {
+    sync.Mutex
+    result KeyRotationResult
+}{}
+
+func setKeyRotationResult(update func(result *KeyRotationResult)) {
+    keyRotation.Lock()
+    defer keyRotation.Unlock()
+    update(&keyRotation.result)
+}
+
+// The SSH key Secret with its data decoded
+func readSSHKeySecret(client dynamic.Interface) (*unstructured.Unstructured, map[string]string, error) {
+    secretName := getSSHKeySecretName()
+    secret, err := client.Resource(secretGVR).Namespace("default").Get(context.TODO(), secretName, metav1.GetOptions{})
+    if err != nil {
+        return nil, nil, fmt.Errorf("failed to read SSH key secret %s: %v", secretName, err)
+    }
+    encoded, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+    data := make(map[string]string, len(encoded))
+    for key, value := range encoded {
+        decoded, err := base64.StdEncoding.DecodeString(value)
+        if err != nil {
+            return nil, nil, fmt.Errorf("SSH key secret %s has malformed %s: %v", secretName, key, err)
+        }
+        data[key] = string(decoded)
+    }
+    return secret, data, nil
+}
+
+// Set or, for empty values, remove keys of the SSH key Secret and record the phase
+func patchSSHKeySecret(client dynamic.Interface, phase string, data map[string]string) error {
+    encoded := make(map[string]interface{}, len(data))
+    for key, value := range data {
+        if value == "" {
+            encoded[key] = nil
+        } else {
+            encoded[key] = base64.StdEncoding.EncodeToString([]byte(value))
+        }
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{
+                keyRotationAnnotation: phase,
+            },
+        },
+        "data": encoded,
+    })
+    _, err := client.Resource(secretGVR).Namespace("default").Patch(
+        context.TODO(), getSSHKeySecretName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if err != nil {
+        return fmt.Errorf("failed to update SSH key secret %s: %v", getSSHKeySecretName(), err)
+    }
+    return nil
+}
+
+// An ssh config offering the given key files besides the one passed with -i
+func sshIdentityConfig(keyNames ...string) string {
+    config := "Host *\n"
+    for _, name := range append([]string{"id_rsa"}, keyNames...) {
+        config += fmt.Sprintf("    IdentityFile ~/.ssh/%s\n", name)
+    }
+    return config
+}
+
+// A fresh RSA key pair, in the format of the one it replaces
+func generateSSHKey() (string, string, error) {
+    dir, err := os.MkdirTemp("", "key-rotation-")
+    if err != nil {
+        return "", "", err
+    }
+    defer os.RemoveAll(dir)
+
+    path := filepath.Join(dir, "id_rsa")
+    comment := "hobbyfarm-provisioner-" + time.Now().UTC().Format("20060102")
+    if output, err := exec.Command("ssh-keygen", "-q", "-t", "rsa", "-b", "4096", "-N", "", "-C", comment, "-f", path).CombinedOutput(); err != nil {
+        return "", "", fmt.Errorf("ssh-keygen failed: %v: %s", err, strings.TrimSpace(string(output)))
+    }
+    privateKey, err := os.ReadFile(path)
+    if err != nil {
+        return "", "", err
+    }
+    publicKey, err := os.ReadFile(path + ".pub")
+    if err != nil {
+        return "", "", err
+    }
+    return string(privateKey), strings.TrimSpace(string(publicKey)), nil
+}
+
+// Where the Secret's key is mounted in this container
+func mountedKeyPath(name string) string {
+    return filepath.Join(filepath.Dir(defaultSSHKeyPath()), name)
+}
+
+// Wait for the kubelet to bring the mounted copy of a Secret key up to date
+func waitForMountedKey(name, publicKey string) error {
+    deadline := time.Now().Add(getKeyRotationSyncTimeout())
+    for {
+        if mounted, err := derivePublicKeyFromFile(mountedKeyPath(name)); err == nil && mounted == normalizePublicKey(publicKey) {
+            return nil
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("%s was not updated in %s within %v", name, filepath.Dir(defaultSSHKeyPath()), getKeyRotationSyncTimeout())
+        }
+        time.Sleep(5 * time.Second)
+    }
+}
+
+// Every VM the provisioning key has to open: the static pools, allocated VMs and
+// cloud instances, including idle ones kept for reuse
+func keyRotationTargets(client dynamic.Interface) []string {
+    seen := map[string]bool{}
+    for _, ip := range allStaticVMs() {
+        seen[ip] = true
+    }
+    if allocated, err := collectAllocatedIPs(client); err == nil {
+        for ip := range allocated {
+            seen[ip] = true
+        }
+    }
+    if instances, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{}); err == nil {
+        for _, instance := range instances.Items {
+            if vmIP, _, _ := unstructured.NestedString(instance.Object, "status", "vmIP"); vmIP != "" {
+                seen[vmIP] = true
+            }
+        }
+    }
+
+    targets := make([]string, 0, len(seen))
+    for ip := range seen {
+        targets = append(targets, ip)
+    }
+    sort.Strings(targets)
+    return targets
+}
+
+// Run step on every target under its VM lock, so no playbook is halfway through a
+// VM while its keys change. Returns the number of VMs the step failed on.
+func keyRotationStep(client dynamic.Interface, targets []string, step func(vmIP string) error) int {
+    outcomes := map[string]string{}
+    failed := 0
+    for _, vmIP := range targets {
+        err := withVMLock(client, vmIP, "key-rotation", func() error { return step(vmIP) })
+        if err != nil {
+            log.Printf("⚠️ Key rotation step failed on VM %s: %v", vmIP, err)
+            outcomes[vmIP] = err.Error()
+            failed++
+        } else {
+            outcomes[vmIP] = "ok"
+        }
+    }
+    setKeyRotationResult(func(result *KeyRotationResult) { result.VMs = outcomes })
+    return failed
+}
+
+// Add publicKey to authorized_keys of the VM's login, through whichever key gets in
+func (ar *AnsibleRunner) authorizeKey(vmIP, publicKey string) error {
+    user, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return err
+    }
+    blob := strings.Fields(publicKey)[1]
+    command := fmt.Sprintf("mkdir -p ~/.ssh && chmod 700 ~/.ssh && touch ~/.ssh/authorized_keys && chmod 600 ~/.ssh/authorized_keys && "+
+        "(grep -qF '%s' ~/.ssh/authorized_keys || echo '%s' >> ~/.ssh/authorized_keys)", blob, publicKey)
+    if output, err := ar.ssh.CombinedOutput(user, vmIP, 10*time.Second, command); err != nil {
+        return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// Log in with the key at keyPath alone, ignoring the ssh config and other keys
+func (ar *AnsibleRunner) verifyKey(vmIP, keyPath string) error {
+    user, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return err
+    }
+    output, err := ar.exec.CombinedOutput(nil, "ssh",
+        "-F", "/dev/null",
+        "-o", "IdentitiesOnly=yes",
+        "-o", "StrictHostKeyChecking=no",
+        "-o", "UserKnownHostsFile=/dev/null",
+        "-o", "BatchMode=yes",
+        "-o", "ConnectTimeout=10",
+        "-i", keyPath,
+        fmt.Sprintf("%s@%s", user, vmIP), "true")
+    if err != nil {
+        return fmt.Errorf("new key refused: %v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// Remove oldKey from authorized_keys, only where newKey is authorized so a VM can't be locked out
+func (ar *AnsibleRunner) revokeKey(vmIP, oldKey, newKey string) error {
+    user, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return err
+    }
+    command := fmt.Sprintf("grep -qF '%s' ~/.ssh/authorized_keys && sed -i '\\#%s#d' ~/.ssh/authorized_keys",
+        strings.Fields(newKey)[1], strings.Fields(oldKey)[1])
+    if output, err := ar.ssh.CombinedOutput(user, vmIP, 10*time.Second, command); err != nil {
+        return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// Replace the EC2 keypair with one imported from the promoted key. Launches in the
+// short window without a keypair fail and are retried like other cloud errors.
+func rotateCloudKeyPair(client dynamic.Interface, publicKey string) error {
+    if Setting("ENABLE_EC2_FALLBACK") == "false" {
+        return nil
+    }
+    keyName := getCloudKeyPairName()
+    existing, err := client.Resource(ec2KeyPairGVR).Get(context.TODO(), keyName, metav1.GetOptions{})
+    if err == nil {
+        // Already replaced by an earlier attempt
+        if current, _, _ := unstructured.NestedString(existing.Object, "spec", "forProvider", "publicKey"); normalizePublicKey(current) == normalizePublicKey(publicKey) {
+            return nil
+        }
+        err = client.Resource(ec2KeyPairGVR).Delete(context.TODO(), keyName, metav1.DeleteOptions{})
+    }
+    if err != nil && !errors.IsNotFound(err) {
+        return fmt.Errorf("failed to delete EC2 keypair %s: %v", keyName, err)
+    }
+    // Crossplane removes the AWS keypair before the object goes away
+    deadline := time.Now().Add(getKeyRotationSyncTimeout())
+    for {
+        if _, err := client.Resource(ec2KeyPairGVR).Get(context.TODO(), keyName, metav1.GetOptions{}); errors.IsNotFound(err) {
+            break
+        }
+        if time.Now().After(deadline) {
+            return fmt.Errorf("EC2 keypair %s still deleting after %v", keyName, getKeyRotationSyncTimeout())
+        }
+        time.Sleep(5 * time.Second)
+    }
+    return EnsureCloudKeyPair(client)
+}
+
+// Rotate the provisioning key, resuming at the phase recorded on the Secret. VMs
+// that can't be given or reached with the new key stop the rotation before the old
+// key is promoted or retired, unless force is set.
+func RotateProvisioningKey(client dynamic.Interface, force bool) error {
+    secret, data, err := readSSHKeySecret(client)
+    if err != nil {
+        return err
+    }
+    phase := secret.GetAnnotations()[keyRotationAnnotation]
+    ar := NewAnsibleRunner(client)
+    setPhase := func(p string) {
+        phase = p
+        setKeyRotationResult(func(result *KeyRotationResult) { result.Phase = p })
+        log.Printf("🔑 Key rotation %s", p)
+    }
+
+    if phase == "" || phase == keyRotationComplete {
+        privateKey, publicKey, err := generateSSHKey()
+        if err != nil {
+            return err
+        }
+        data[nextKeyName], data[nextKeyName+".pub"] = privateKey, publicKey
+        if err := patchSSHKeySecret(client, keyRotationStaged, map[string]string{
+            nextKeyName:          privateKey,
+            nextKeyName + ".pub": publicKey,
+            sshConfigName:        sshIdentityConfig(nextKeyName),
+        }); err != nil {
+            return err
+        }
+        setPhase(keyRotationStaged)
+    }
+
+    if phase == keyRotationStaged {
+        nextKey := data[nextKeyName+".pub"]
+        if err := waitForMountedKey(nextKeyName, nextKey); err != nil {
+            return err
+        }
+        if failed := keyRotationStep(client, keyRotationTargets(client), func(vmIP string) error {
+            return ar.authorizeKey(vmIP, nextKey)
+        }); failed > 0 && !force {
+            return fmt.Errorf("%d VMs could not be given the new key; fix them, or retry with force=true to leave them behind", failed)
+        }
+        if err := patchSSHKeySecret(client, keyRotationDeployed, nil); err != nil {
+            return err
+        }
+        setPhase(keyRotationDeployed)
+    }
+
+    if phase == keyRotationDeployed {
+        oldPrivate := data["id_rsa"]
+        oldPublic, err := derivePublicKey([]byte(oldPrivate))
+        if err != nil {
+            return err
+        }
+        newPrivate, newPublic := data[nextKeyName], data[nextKeyName+".pub"]
+        data[previousKeyName+".pub"] = oldPublic
+        if err := patchSSHKeySecret(client, keyRotationPromoted, map[string]string{
+            "id_rsa":                 newPrivate,
+            "id_rsa.pub":             newPublic,
+            previousKeyName:          oldPrivate,
+            previousKeyName + ".pub": oldPublic,
+            nextKeyName:              "",
+            nextKeyName + ".pub":     "",
+            sshConfigName:            sshIdentityConfig(previousKeyName),
+        }); err != nil {
+            return err
+        }
+        setPhase(keyRotationPromoted)
+    }
+
+    if phase == keyRotationPromoted {
+        _, data, err = readSSHKeySecret(client)
+        if err != nil {
+            return err
+        }
+        newKey := data["id_rsa.pub"]
+        if err := waitForMountedKey("id_rsa", newKey); err != nil {
+            return err
+        }
+        if err := rotateCloudKeyPair(client, newKey); err != nil {
+            return err
+        }
+        // VMs that came up with the old key since it was deployed get the new one now,
+        // through id_rsa.previous
+        if failed := keyRotationStep(client, keyRotationTargets(client), func(vmIP string) error {
+            if err := ar.verifyKey(vmIP, defaultSSHKeyPath()); err == nil {
+                return nil
+            }
+            if err := ar.authorizeKey(vmIP, newKey); err != nil {
+                return err
+            }
+            return ar.verifyKey(vmIP, defaultSSHKeyPath())
+        }); failed > 0 && !force {
+            return fmt.Errorf("%d VMs refuse the new key; fix them, or retry with force=true to retire the old key anyway", failed)
+        }
+        if err := patchSSHKeySecret(client, keyRotationVerified, nil); err != nil {
+            return err
+        }
+        setPhase(keyRotationVerified)
+    }
+
+    if phase == keyRotationVerified {
+        _, data, err = readSSHKeySecret(client)
+        if err != nil {
+            return err
+        }
+        oldKey, newKey := data[previousKeyName+".pub"], data["id_rsa.pub"]
+        if oldKey != "" && normalizePublicKey(oldKey) != normalizePublicKey(newKey) {
+            if failed := keyRotationStep(client, keyRotationTargets(client), func(vmIP string) error {
+                return ar.revokeKey(vmIP, oldKey, newKey)
+            }); failed > 0 {
+                log.Printf("⚠️ The old provisioning key is still authorized on %d VMs", failed)
+            }
+        }
+        if err := patchSSHKeySecret(client, keyRotationComplete, map[string]string{
+            previousKeyName:          "",
+            previousKeyName + ".pub": "",
+            sshConfigName:            "",
+        }); err != nil {
+            return err
+        }
+        setPhase(keyRotationComplete)
+    }
+    return nil
+}
+
+// GET reports the rotation, POST [?force=true] starts one or resumes an interrupted one, on the admin listener
+func (ws *WebhookServer) keyRotationHandler(w http.ResponseWriter, r *http.Request) {
+    accepted := false
+    switch r.Method {
+    case http.MethodGet:
+    case http.MethodPost:
+        keyRotation.Lock()
+        if keyRotation.result.Running {
+            keyRotation.Unlock()
+            http.Error(w, "a key rotation is already running", http.StatusConflict)
+            return
+        }
+        keyRotation.result = KeyRotationResult{Running: true, StartedAt: time.Now().Format(time.RFC3339)}
+        keyRotation.Unlock()
+
+        force := r.URL.Query().Get("force") == "true"
+        go func() {
+            err := RotateProvisioningKey(ws.client, force)
+            if err != nil {
+                log.Printf("❌ Key rotation stopped: %v", err)
+            }
+            setKeyRotationResult(func(result *KeyRotationResult) {
+                result.Running = false
+                result.FinishedAt = time.Now().Format(time.RFC3339)
+                if err != nil {
+                    result.Error = err.Error()
+                }
+            })
+        }()
+        accepted = true
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    keyRotation.Lock()
+    result := keyRotation.result
+    keyRotation.Unlock()
+    if secret, _, err := readSSHKeySecret(ws.client); err == nil && !result.Running {
+        result.Phase = secret.GetAnnotations()[keyRotationAnnotation]
+    }
+    w.Header().Set("Content-Type", "application/json")
+    if accepted {
+        w.WriteHeader(http.StatusAccepted)
+    }
+    json.NewEncoder(w).Encode(result)
+}
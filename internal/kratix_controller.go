@@ -4,72 +4,56 @@ package internal
 import (
     "context"
     "encoding/json"
+    "errors"
     "fmt"
     "log"
     "os"
+    "sync"
     "time"
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-    "k8s.io/apimachinery/pkg/runtime/schema"
-    "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
 )
 
-var (
-    // Kratix Promise VMProvisioningRequest GVR
-    vmProvisioningRequestGVR = schema.GroupVersionResource{
-        Group:    "platform.kratix.io",
-        Version:  "v1alpha1",
-        Resource: "vm-provisioning-requests",
-    }
-)
-
 type KratixController struct {
     client                   dynamic.Interface
     ansibleRunner           *AnsibleRunner
-    processedRequests       map[string]bool
-    staticVMPool           []string
+    prober                  SSHProber
+    executor                AnsibleExecutor
+    cloud                   CloudProvider
+    processedRequests       *trackingCache
     usedIPs                map[string]bool
+    // Held by a pass over the requests, so stage events and the loop take turns
+    passMu                  sync.Mutex
 }
 
 func NewKratixController(client dynamic.Interface) *KratixController {
+    ansibleRunner := NewAnsibleRunner(client)
+    return newKratixControllerWith(client, runnerProber{ansibleRunner}, runnerExecutor{ansibleRunner}, crossplaneCloud{client, ansibleRunner})
+}
+
+// A controller whose VM checks, commands and cloud calls go to the given
+// implementations, e.g. fakes. Its own runner only renders inventories and extra
+// vars and has nothing to run commands with.
+func newKratixControllerWith(client dynamic.Interface, prober SSHProber, executor AnsibleExecutor, cloud CloudProvider) *KratixController {
     return &KratixController{
         client:            client,
-        ansibleRunner:     NewAnsibleRunner(client),
-        processedRequests: make(map[string]bool),
-        staticVMPool:      []string{"192.168.2.37", "192.168.2.38"},
+        ansibleRunner:     newAnsibleRunnerWith(client, nil, nil),
+        prober:            prober,
+        executor:          executor,
+        cloud:             cloud,
+        processedRequests: newTrackingCache("kratix-requests"),
         usedIPs:          make(map[string]bool),
     }
 }
 
-// Main controller loop for Kratix Promise VMProvisioningRequests
-func (kc *KratixController) WatchVMProvisioningRequests() {
-    log.Println("🎯 Starting Kratix Promise VM Provisioning Controller...")
-    log.Println("🔄 Watching for VMProvisioningRequests")
-    
-    for {
-        // Watch for new VMProvisioningRequests
-        kc.processVMProvisioningRequests()
-        
-        // Allocate VMs for pending requests
-        kc.allocateVMs()
-        
-        // Update status for provisioned VMs
-        kc.updateVMStatus()
-        
-        // Cleanup expired allocations
-        kc.cleanupExpiredAllocations()
-        
-        time.Sleep(10 * time.Second)
-    }
-}
-
 // Process new VMProvisioningRequests
 func (kc *KratixController) processVMProvisioningRequests() {
     requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         log.Printf("⚠️ Could not list VMProvisioningRequests: %v", err)
+        recordLastError(HeartbeatKratixController, "listing VMProvisioningRequests: %v", err)
         return
     }
 
@@ -80,8 +64,8 @@ func (kc *KratixController) processVMProvisioningRequests() {
     for _, request := range requests.Items {
         requestName := request.GetName()
         
-        // Skip if already processed
-        if kc.processedRequests[requestName] {
+        // Skip if already processed, another shard's, or until the migration has copied its allocation
+        if kc.processedRequests.has(requestName) || !ownsRequest(&request) || requestMigrating(&request) {
             continue
         }
         
@@ -94,16 +78,23 @@ func (kc *KratixController) processVMProvisioningRequests() {
         log.Printf("🎯 Processing VMProvisioningRequest: %s (user: %s, session: %s, scenario: %s, state: %s)", 
             requestName, user, session, scenario, state)
         
+        // Tenant checks read the label, so it is settled before the request is allocated
+        if _, err := kc.stampRequestTenant(&request); err != nil {
+            log.Printf("❌ Failed to set the tenant of request %s: %v", requestName, err)
+            continue
+        }
+        
         // Initialize status if not set
         if state == "" {
             if err := kc.updateRequestStatus(requestName, "pending", "", "", false); err != nil {
                 log.Printf("❌ Failed to initialize request status: %v", err)
                 continue
             }
+            publishStage(stageAllocation, requestName)
         }
         
         // Mark as processed
-        kc.processedRequests[requestName] = true
+        kc.processedRequests.add(requestName)
         log.Printf("✅ VMProvisioningRequest %s processed", requestName)
     }
 }
@@ -118,20 +109,57 @@ func (kc *KratixController) allocateVMs() {
         return
     }
 
+    // Static VMs each consumer holds, for pools shared between HobbyFarm and others
+    holders := collectPoolHolders(requests.Items)
+    maintenance := getMaintenanceVMs(kc.client)
+    
+    // Tenants and environments are read once, every request and VM of the pass is decided against them
+    tenants := loadTenants()
+    environments := loadVMEnvironments()
+
     for _, request := range requests.Items {
         requestName := request.GetName()
         state, _, _ := unstructured.NestedString(request.Object, "status", "state")
         vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
         
-        // Skip if not pending or already has IP
-        if state != "pending" || vmIP != "" {
+        // Skip if not pending, already has IP, another shard's or being migrated
+        if state != "pending" || vmIP != "" || !ownsRequest(&request) || requestMigrating(&request) {
             continue
         }
         
-        log.Printf("🔄 Allocating VM for request: %s", requestName)
+        // Only the static pool of the request's environment is eligible
+        environment, configured := getVMEnvironment(getObjectEnvironment(&request))
+        if !configured {
+            log.Printf("⚠️ Request %s targets unknown environment %s, no static VMs eligible", requestName, environment.Name)
+        }
         
-        // Try to allocate from static pool first
-        if selectedIP := kc.findAvailableStaticVM(); selectedIP != "" {
+        // A tenant's request never gets another tenant's VMs, whatever it asks for
+        if refusal := tenants.isolationRefusal(&request, environment.Name); refusal != "" {
+            log.Printf("🚫 Request %s refused: %s", requestName, refusal)
+            kc.failRequest(requestName, "", failureTenantRefused, refusal)
+            continue
+        }
+        if refusal := kc.tenantQuotaRefusal(&request, false); refusal != "" {
+            log.Printf("⏳ %s, %s stays queued", refusal, requestName)
+            continue
+        }
+        
+        // A machine supplied with the request is provisioned as it is, nothing is allocated
+        if vm, adopting := getRequestAdoptedVM(&request); adopting {
+            kc.adoptVM(requestName, &request, vm)
+            continue
+        }
+        
+        log.Printf("🔄 Allocating VM for request: %s (environment: %s)", requestName, environment.Name)
+        
+        // Try to allocate from static pool first, within the share the pool leaves this consumer
+        selectedIP := ""
+        if refusal := poolSharingRefusal(environment, &request, holders, maintenance); refusal != "" {
+            log.Printf("⚖️ No static VM for %s: %s", requestName, refusal)
+        } else {
+            selectedIP = kc.findAvailableStaticVM(environment, &request, tenants, environments)
+        }
+        if selectedIP != "" {
             log.Printf("✅ Allocating static VM %s to request %s", selectedIP, requestName)
             
             if err := kc.updateRequestStatus(requestName, "allocated", selectedIP, "static", false); err != nil {
@@ -140,19 +168,36 @@ func (kc *KratixController) allocateVMs() {
             }
             
             kc.usedIPs[selectedIP] = true
+            holders.hold(environment.Name, requestConsumer(&request))
             
             // Set allocated timestamp
-            kc.setAllocatedAt(requestName)
+            kc.setAllocatedAt(&request)
+            kc.recordStaticVMSite(requestName, selectedIP)
+            recordPoolEvent(kc.client, selectedIP, eventTypeNormal, reasonVMAllocated, "VMProvisioningRequest/"+requestName, "")
+            publishStage(stageProvisioning, requestName)
             
         } else {
             // Check if cloud fallback is enabled
             fallbackEnabled, _, _ := unstructured.NestedBool(request.Object, "spec", "cloudFallback", "enabled")
             
-            if fallbackEnabled {
+            if fallbackEnabled && !apiAvailable(apiCrossplane) {
+                log.Printf("⏳ No static VMs available and Crossplane is not installed, %s stays queued", requestName)
+            } else if fallbackEnabled && !environment.cloudFallbackAllowed() {
+                log.Printf("⚠️ No VMs available for %s and environment %s does not allow cloud fallback", requestName, environment.Name)
+            } else if fallbackEnabled {
                 log.Printf("🚀 No static VMs available, trying cloud fallback for %s", requestName)
-                if err := kc.handleCloudFallback(requestName, &request); err != nil {
+                if err := kc.handleCloudFallback(requestName, &request); errors.Is(err, errCloudRateLimited) {
+                    log.Printf("⏳ Cloud instance creation rate limited, %s stays queued", requestName)
+                } else if errors.Is(err, errTenantQuotaReached) || errors.Is(err, errSecurityGroupPending) {
+                    log.Printf("⏳ %v, %s stays queued", err, requestName)
+                } else if err != nil && apiOutage(err) {
+                    log.Printf("⏸️ API server unavailable during cloud fallback, %s stays queued: %v", requestName, err)
+                } else if err != nil {
                     log.Printf("❌ Cloud fallback failed for %s: %v", requestName, err)
-                    kc.updateRequestStatus(requestName, "failed", "", "", false)
+                    kc.failRequest(requestName, "", failureCloudError, fmt.Sprintf("cloud fallback failed: %v", err))
+                } else {
+                    // The instance is the request's from here, booting counts towards time to ready
+                    kc.recordFirstAllocation(&request)
                 }
             } else {
                 log.Printf("⚠️ No VMs available for %s and cloud fallback disabled", requestName)
@@ -174,20 +219,23 @@ func (kc *KratixController) updateVMStatus() {
         vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
         provisioned, _, _ := unstructured.NestedBool(request.Object, "status", "provisioned")
         
-        // Skip if not allocated or already provisioned
-        if state != "allocated" || vmIP == "" || provisioned {
+        // Skip if not allocated, already provisioned or another shard's
+        if state != "allocated" || vmIP == "" || provisioned || !ownsRequest(&request) {
             continue
         }
         
+        // Overlay VMs may only be reachable through their overlay address
+        accessIP := getRequestAccessIP(&request)
+        
         // Check if VM is reachable
-        if !isVMReachable(vmIP) {
-            log.Printf("⚠️ VM %s not reachable, will retry", vmIP)
+        if !kc.prober.Reachable(accessIP) {
+            log.Printf("⚠️ VM %s not reachable, will retry", accessIP)
             continue
         }
         
-        // Check boot wait time
+        // Check boot wait time; adopted machines are already running
         allocatedAt, _, _ := unstructured.NestedString(request.Object, "status", "allocatedAt")
-        if allocatedAt != "" {
+        if allocatedAt != "" && !isAdoptedRequest(&request) {
             if t, err := time.Parse(time.RFC3339, allocatedAt); err == nil {
                 bootWaitTime := getBootWaitTime(vmIP)
                 if time.Since(t) < bootWaitTime {
@@ -207,57 +255,171 @@ func (kc *KratixController) updateVMStatus() {
         log.Printf("🎭 Starting provisioning for VM %s (request: %s)", vmIP, requestName)
         
         // Wait for SSH
-        sshTimeout := getSSHTimeout(vmIP)
-        if err := kc.ansibleRunner.WaitForSSH(vmIP, sshTimeout); err != nil {
-            log.Printf("❌ SSH not ready for VM %s: %v", vmIP, err)
-            kc.updateRequestStatus(requestName, "failed", vmIP, "", false)
+        sshTimeout := getSSHTimeout(accessIP)
+        if err := kc.prober.WaitForSSH(accessIP, sshTimeout); err != nil {
+            log.Printf("❌ SSH not ready for VM %s: %v", accessIP, err)
+            kc.failRequest(requestName, vmIP, failureSSHTimeout, fmt.Sprintf("SSH not ready: %v", err))
+            kc.attachConsoleOutput(requestName, err.Error())
+            recordPoolEvent(kc.client, vmIP, eventTypeWarning, reasonVMProvisioningFailed, "VMProvisioningRequest/"+requestName, "SSH not ready: %v", err)
+            continue
+        }
+        
+        // Join the WireGuard/Tailscale overlay if requested and switch to the overlay address
+        provisionIP, err := kc.ensureOverlayConnectivity(requestName, accessIP, session, &request)
+        if holder, locked := vmLockHeld(err); locked {
+            log.Printf("🔒 VM %s is locked by %s, %s goes back to allocated until the next pass", vmIP, holder, requestName)
+            kc.updateRequestStatus(requestName, "allocated", vmIP, "", false)
+            continue
+        } else if err != nil {
+            log.Printf("❌ Overlay setup failed for VM %s: %v", vmIP, err)
+            kc.failRequest(requestName, vmIP, failureUnreachable, fmt.Sprintf("overlay setup failed: %v", err))
+            recordPoolEvent(kc.client, vmIP, eventTypeWarning, reasonVMProvisioningFailed, "VMProvisioningRequest/"+requestName, "overlay setup failed: %v", err)
             continue
         }
         
         // Run provisioning
-        if err := kc.runProvisioning(vmIP, session, scenario, &request); err != nil {
+        err = kc.runProvisioning(provisionIP, session, scenario, &request)
+        if holder, locked := vmLockHeld(err); locked {
+            // Another run is on the VM: try again on the next pass instead of holding up the others
+            log.Printf("🔒 VM %s is locked by %s, %s goes back to allocated until the next pass", vmIP, holder, requestName)
+            kc.updateRequestStatus(requestName, "allocated", vmIP, "", false)
+            continue
+        } else if err != nil && apiOutage(err) {
+            // Not the VM's fault: provision it again once the API server is back
+            log.Printf("⏸️ API server unavailable while provisioning VM %s, %s goes back to allocated: %v", vmIP, requestName, err)
+            kc.updateRequestStatus(requestName, "allocated", vmIP, "", false)
+            continue
+        } else if err != nil {
             log.Printf("❌ Provisioning failed for VM %s: %v", vmIP, err)
-            kc.updateRequestStatus(requestName, "failed", vmIP, "", false)
+            kc.failRequest(requestName, vmIP, failurePlaybookFailed, fmt.Sprintf("provisioning failed: %v", err))
+            recordPoolEvent(kc.client, vmIP, eventTypeWarning, reasonVMProvisioningFailed, "VMProvisioningRequest/"+requestName, "provisioning failed: %v", err)
             continue
         }
         
-        // Mark as ready
-        kc.updateRequestStatus(requestName, "ready", vmIP, "", true)
-        kc.setReadyAt(requestName)
+        // Register the VM's DNS name before announcing it as ready
+        if _, err := kc.ensureVMDNSRecord(&request, provisionIP); err != nil {
+            log.Printf("⚠️ DNS registration failed for VM %s: %v", vmIP, err)
+        }
+        
+        // Ready only once verification and the shell probe pass
+        kc.markProvisionedUnverified(requestName, vmIP)
+        log.Printf("✅ VM %s provisioned for request %s, checking readiness gates", vmIP, requestName)
         
-        log.Printf("✅ VM %s provisioned successfully for request %s", vmIP, requestName)
+        if gated, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{}); err == nil {
+            kc.gateRequestReadiness(gated)
+        }
     }
 }
 
-// Run Ansible provisioning based on request configuration
-func (kc *KratixController) runProvisioning(vmIP, session, scenario string, request *unstructured.Unstructured) error {
-    // Get provisioning config from request
+// Provisioning config of a request: its spec.provisioning, extra variables from the
+// Scenario and Session, resolved secrets and, on cloud instances, data volumes
+func (kc *KratixController) requestProvisioningConfig(session, scenario string, request *unstructured.Unstructured) (*ProvisioningConfig, error) {
     playbooks, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "playbooks")
     packages, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "packages")
     requirements, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "requirements")
     variables, _, _ := unstructured.NestedStringMap(request.Object, "spec", "provisioning", "variables")
+    executionEnvironment, _, _ := unstructured.NestedString(request.Object, "spec", "provisioning", "executionEnvironment")
+    ansibleVersion, _, _ := unstructured.NestedString(request.Object, "spec", "provisioning", "ansibleVersion")
+    inventoryTemplate, _, _ := unstructured.NestedString(request.Object, "spec", "provisioning", "inventoryTemplate")
+    secretVariables, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "secretVariables")
     
     // Default playbooks if not specified
     if len(playbooks) == 0 {
         playbooks = []string{"base.yaml", "dynamic.yaml"}
     }
     
+    // Course parameters from the Scenario and Session annotations, the request's own win
+    variables = kc.ansibleRunner.extraVars(session, scenario, variables)
+    
+    // Cohort of the HobbyFarm User, set by the provisioner so a course can't fake it
+    user, _, _ := unstructured.NestedString(request.Object, "spec", "user")
+    for name, value := range lookupUserMetadata(kc.client, user).variables() {
+        variables[name] = value
+    }
+    
     log.Printf("🎯 Provisioning config: playbooks=%v, packages=%v, requirements=%v", playbooks, packages, requirements)
     
     // Create provisioning config
     config := &ProvisioningConfig{
-        Playbooks:    playbooks,
-        Packages:     packages,
-        Requirements: requirements,
-        Variables:    variables,
+        Playbooks:            playbooks,
+        Packages:             packages,
+        Requirements:         requirements,
+        Variables:            variables,
+        ExecutionEnvironment: executionEnvironment,
+        AnsibleVersion:       ansibleVersion,
+        InventoryTemplate:    inventoryTemplate,
+        SecretVariables:      secretVariables,
+    }
+    if err := resolveSecretVariables(kc.client, config); err != nil {
+        return nil, err
+    }
+    
+    // Countdown of the session's remaining time on the learner's shell
+    withSessionTimePlaybook(config)
+    
+    // Proxy, timezone, locale and CA bundle of the request's environment
+    env, _ := getVMEnvironment(getObjectEnvironment(request))
+    injectSystemSettings(config, env)
+    
+    // Playbooks of a pinned bundle only run once its digest matched; a changed
+    // bundle goes to the environment's canary sessions first
+    if bundle := selectPlaybookBundle(kc.client, env, session); bundle != nil {
+        dir, err := fetchPlaybookBundle(*bundle)
+        if err != nil {
+            return nil, fmt.Errorf("playbook bundle of environment %s: %v", env.Name, err)
+        }
+        config.PlaybookDir = dir
+        config.PlaybookBundle = bundle
+    }
+    
+    // Only cloud instances get the extra EBS disks, static VMs keep their own layout
+    if cloudInstance, err := findCloudInstanceForRequest(kc.client, request.GetName()); err == nil && cloudInstance != nil {
+        config.DataVolumes = getRequestCloudStorage(request).DataVolumes
+        withDataVolumesPlaybook(config)
+    }
+    return config, nil
+}
+
+// Run Ansible provisioning based on request configuration
+func (kc *KratixController) runProvisioning(vmIP, session, scenario string, request *unstructured.Unstructured) (err error) {
+    config, err := kc.requestProvisioningConfig(session, scenario, request)
+    if err != nil {
+        return err
+    }
+    if config.PlaybookBundle != nil {
+        kc.setPlaybookBundle(request.GetName(), *config.PlaybookBundle)
+        // Outcomes of canary and stable runs settle a bundle on trial; an API
+        // outage says nothing about the playbooks
+        defer func() {
+            if _, locked := vmLockHeld(err); err == nil || (!apiOutage(err) && !locked) {
+                recordPlaybookCanaryRun(kc.client, request, getObjectEnvironment(request), *config.PlaybookBundle, session, err != nil)
+            }
+        }()
+    }
+    
+    // Let the final playbook report completion instead of waiting for the next poll
+    if err := kc.armProvisioningCallback(request.GetName(), config); err != nil {
+        log.Printf("⚠️ Provisioning callback disabled for %s: %v", request.GetName(), err)
     }
+    defer kc.clearProvisioningCallback(request.GetName())
     
     // Detect SSH user
-    sshUser, err := kc.ansibleRunner.detectSSHUser(vmIP)
+    sshUser, err := kc.prober.DetectSSHUser(vmIP)
     if err != nil {
         return fmt.Errorf("failed to detect SSH user: %v", err)
     }
     
+    // Detect OS and architecture so playbooks take the apt or dnf path
+    if config.Platform, err = kc.prober.DetectPlatform(vmIP, sshUser); err != nil {
+        return err
+    }
+    kc.setPlatform(request.GetName(), config.Platform)
+    
+    // Record the owning session on the VM, so it can be told apart and reset safely
+    if err := kc.prober.WriteVMMetadata(vmIP, sshUser, requestVMMetadata(request)); err != nil {
+        log.Printf("⚠️ Could not write metadata of %s to VM %s: %v", request.GetName(), vmIP, err)
+    }
+    
     // Build inventory
     inventoryContent := kc.ansibleRunner.buildInventory(vmIP, sshUser, session, config)
     
@@ -268,21 +430,111 @@ func (kc *KratixController) runProvisioning(vmIP, session, scenario string, requ
     }
     defer kc.removeFile(tmpInventory)
     
-    // Run playbooks
-    for _, playbook := range config.Playbooks {
-        log.Printf("🎭 Running playbook %s for session %s", playbook, session)
-        if err := kc.ansibleRunner.runSinglePlaybook(tmpInventory, playbook, session, config); err != nil {
-            return fmt.Errorf("playbook %s failed: %v", playbook, err)
+    // Collect logs, the redacted inventory and results for post-mortem debugging
+    artifacts := newProvisioningArtifacts(request.GetName())
+    artifacts.addInventory(inventoryContent, config)
+    
+    // Run playbooks, recording a per-playbook recap in the request status
+    var recaps []*PlaybookRecap
+    defer func() {
+        // Nothing ran on a VM another run holds
+        if _, locked := vmLockHeld(err); locked {
+            return
         }
+        kc.setPlaybookResults(request.GetName(), recaps)
+        kc.uploadArtifacts(request.GetName(), artifacts, recaps, err)
+    }()
+    
+    // A retry on the same VM picks up at the first playbook that didn't complete
+    requestIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    playbooks, completed := resumePlaybooks(request, requestIP, config.Playbooks)
+    if len(completed) > 0 {
+        log.Printf("⏭️ Resuming provisioning of %s, skipping completed playbooks %v", request.GetName(), completed)
     }
     
-    return nil
+    // Lock the VM under the address it was allocated by, which an overlay address doesn't match
+    lockIP := requestIP
+    if lockIP == "" {
+        lockIP = vmIP
+    }
+    return withVMLock(kc.client, lockIP, "kratix:"+request.GetName(), func() error {
+        for _, playbook := range playbooks {
+            log.Printf("🎭 Running playbook %s for session %s", playbook, session)
+            recap, output, err := kc.executor.RunPlaybook(tmpInventory, playbook, session, config)
+            artifacts.addPlaybookLog(playbook, output, config)
+            if recap != nil {
+                recaps = append(recaps, recap)
+            }
+            if err != nil {
+                return fmt.Errorf("playbook %s failed: %v", playbook, err)
+            }
+            completed = append(completed, playbook)
+            kc.recordPlaybookProgress(request.GetName(), requestIP, completed)
+        }
+        return nil
+    })
+}
+
+// Upload run artifacts when ARTIFACTS_BUCKET is set and link them in the request status
+func (kc *KratixController) uploadArtifacts(requestName string, artifacts *provisioningArtifacts, recaps []*PlaybookRecap, provisioningErr error) {
+    if !artifactsEnabled() {
+        return
+    }
+    
+    artifacts.addResults(recaps, provisioningErr)
+    url, err := artifacts.upload()
+    if err != nil {
+        log.Printf("⚠️ Failed to upload provisioning artifacts for %s: %v", requestName, err)
+        return
+    }
+    
+    patch := map[string]interface{}{
+        "status": map[string]interface{}{
+            "artifactsURL": url,
+        },
+    }
+    
+    patchBytes, _ := json.Marshal(patch)
+    patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+    
+    log.Printf("📦 Provisioning artifacts for %s uploaded to %s", requestName, url)
+}
+
+// Store per-playbook recaps (ok/changed/failed counts and failed task names) in the request status
+func (kc *KratixController) setPlaybookResults(requestName string, recaps []*PlaybookRecap) {
+    if len(recaps) == 0 {
+        return
+    }
+    
+    patch := map[string]interface{}{
+        "status": map[string]interface{}{
+            "playbookResults": playbookRecapsToStatus(recaps),
+        },
+    }
+    
+    patchBytes, _ := json.Marshal(patch)
+    err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+    if err != nil {
+        log.Printf("⚠️ Failed to record playbook results for %s: %v", requestName, err)
+    }
 }
 
 // Helper functions
-func (kc *KratixController) findAvailableStaticVM() string {
-    for _, ip := range kc.staticVMPool {
-        if !kc.usedIPs[ip] && isVMReachable(ip) {
+func (kc *KratixController) findAvailableStaticVM(environment vmEnvironment, request *unstructured.Unstructured, tenants tenantSet, environments map[string]vmEnvironment) string {
+    maintenance := getMaintenanceVMs(kc.client)
+    pool := getStaticVMPool(kc.client, environment.Name)
+    for _, ip := range environment.StaticVMs {
+        if _, drained := maintenance[ip]; drained {
+            continue
+        }
+        if kc.usedIPs[ip] || !tenants.staticVMAllowed(request, ip, environments) {
+            continue
+        }
+        // Added to the pool config or changed since its last probe
+        if !kc.staticVMVerified(pool, environment, ip) {
+            continue
+        }
+        if kc.prober.Reachable(ip) && staticVMHasRoom(kc.client, kc.prober, ip, request) {
             return ip
         }
     }
@@ -290,21 +542,13 @@ func (kc *KratixController) findAvailableStaticVM() string {
 }
 
 func (kc *KratixController) refreshUsedIPs() {
-    kc.usedIPs = make(map[string]bool)
-    
-    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    // Keep the previous view if the cluster can't be read, an empty map would double-allocate
+    usedIPs, err := collectAllocatedIPs(kc.client)
     if err != nil {
+        log.Printf("⚠️ Could not refresh used IPs, keeping previous state: %v", err)
         return
     }
-    
-    for _, request := range requests.Items {
-        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
-        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
-        
-        if vmIP != "" && (state == "allocated" || state == "provisioning" || state == "ready") {
-            kc.usedIPs[vmIP] = true
-        }
-    }
+    kc.usedIPs = usedIPs
 }
 
 func (kc *KratixController) updateRequestStatus(requestName, state, vmIP, vmType string, provisioned bool) error {
@@ -330,37 +574,22 @@ func (kc *KratixController) updateRequestStatus(requestName, state, vmIP, vmType
         return err
     }
     
-    _, err = kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
-        context.TODO(), requestName, types.MergePatchType,
-        patchBytes, metav1.PatchOptions{}, "status")
+    err = patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
     
     return err
 }
 
-func (kc *KratixController) setAllocatedAt(requestName string) {
-    patch := map[string]interface{}{
-        "status": map[string]interface{}{
-            "allocatedAt": time.Now().Format(time.RFC3339),
-        },
+func (kc *KratixController) setAllocatedAt(request *unstructured.Unstructured) {
+    now := time.Now()
+    status := map[string]interface{}{
+        "allocatedAt": now.Format(time.RFC3339),
     }
-    
-    patchBytes, _ := json.Marshal(patch)
-    kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
-        context.TODO(), requestName, types.MergePatchType,
-        patchBytes, metav1.PatchOptions{}, "status")
-}
-
-func (kc *KratixController) setReadyAt(requestName string) {
-    patch := map[string]interface{}{
-        "status": map[string]interface{}{
-            "readyAt": time.Now().Format(time.RFC3339),
-        },
+    for field, value := range firstAllocationStatus(request, now) {
+        status[field] = value
     }
     
-    patchBytes, _ := json.Marshal(patch)
-    kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
-        context.TODO(), requestName, types.MergePatchType,
-        patchBytes, metav1.PatchOptions{}, "status")
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    patchStatus(kc.client, vmProvisioningRequestGVR, "default", request.GetName(), patchBytes)
 }
 
 func (kc *KratixController) handleCloudFallback(requestName string, request *unstructured.Unstructured) error {
@@ -369,15 +598,16 @@ func (kc *KratixController) handleCloudFallback(requestName string, request *uns
     instanceType, _, _ := unstructured.NestedString(request.Object, "spec", "cloudFallback", "instanceType")
     region, _, _ := unstructured.NestedString(request.Object, "spec", "cloudFallback", "region")
     
-    // Default values
+    // Default values, unset type/region come from the shared instance template
     if provider == "" {
         provider = "aws"
     }
+    template := loadCloudInstanceTemplate()
     if instanceType == "" {
-        instanceType = "t3.micro"
+        instanceType = template.InstanceType
     }
     if region == "" {
-        region = "us-east-1"
+        region = template.Region
     }
     
     log.Printf("🚀 Creating cloud instance: provider=%s, type=%s, region=%s", provider, instanceType, region)
@@ -387,41 +617,77 @@ func (kc *KratixController) handleCloudFallback(requestName string, request *uns
     session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
     
     // Create EC2TrainingVM for cloud fallback
-    return kc.createCloudInstance(requestName, user, session, provider, instanceType, region)
+    return kc.createCloudInstance(requestName, user, session, provider, instanceType, region, request)
 }
 
-func (kc *KratixController) createCloudInstance(requestName, user, session, provider, instanceType, region string) error {
+func (kc *KratixController) createCloudInstance(requestName, user, session, provider, instanceType, region string, source *unstructured.Unstructured) error {
     // For now, only support AWS via existing EC2 fallback
     if provider != "aws" {
         return fmt.Errorf("unsupported cloud provider: %s", provider)
     }
     
-    // Create EC2TrainingVM
-    reqName := "kratix-" + requestName
-    newEC2VM := &unstructured.Unstructured{
-        Object: map[string]interface{}{
-            "apiVersion": "training.example.com/v1",
-            "kind":       "EC2TrainingVM",
-            "metadata": map[string]interface{}{
-                "name":      reqName,
-                "namespace": "default",
-                "labels": map[string]interface{}{
-                    "kratix-request": requestName,
-                    "session":        session,
-                    "type":           "kratix-cloud-fallback",
-                },
-            },
-            "spec": map[string]interface{}{
-                "user":         user,
-                "session":      session,
-                "instanceType": instanceType,
-                "region":       region,
-            },
-        },
+    // Adopt an instance created before a restart instead of creating a second one
+    if existing, err := findCloudInstanceForRequest(kc.client, requestName); err == nil && existing != nil {
+        log.Printf("♻️ Cloud instance %s already exists for Kratix request %s", existing.GetName(), requestName)
+        return nil
     }
     
-    _, err := kc.client.Resource(ec2TrainingVMGVR).Namespace("default").Create(context.TODO(), newEC2VM, metav1.CreateOptions{})
-    if err != nil {
+    // Same template as the TrainingVM fallback; the request may override type and region
+    reqName := requestInstanceName(requestName)
+    labels := map[string]string{
+        "kratix-request": requestName,
+        "type":           "kratix-cloud-fallback",
+        environmentLabel: getObjectEnvironment(source),
+    }
+    // A tenant's instances run in its own AWS account and are only reused for it
+    tenantName := objectTenant(source)
+    tenantConfig, _ := getTenant(tenantName)
+    if tenantName != "" {
+        labels[tenantLabel] = tenantName
+    }
+    ports := getRequestExposedPorts(source)
+    if len(ports) > 0 {
+        labels[exposedPortsLabel] = "true"
+    }
+    scenario, _, _ := unstructured.NestedString(source.Object, "spec", "scenario")
+    newEC2VM := buildCloudInstance(cloudInstanceSpec{
+        Name:           reqName,
+        User:           user,
+        Session:        session,
+        Scenario:       scenario,
+        InstanceType:   instanceType,
+        Region:         region,
+        Labels:         labels,
+        Storage:        getRequestCloudStorage(source),
+        ProviderConfig: tenantConfig.ProviderConfigRef,
+        Source:         source,
+    })
+    
+    // The scenario's ports are opened in a security group of the request's own
+    if len(ports) > 0 {
+        groupID, err := ensureSessionSecurityGroup(kc.client, requestName, region, tenantConfig.ProviderConfigRef, ports)
+        if err != nil {
+            return err
+        }
+        groups, _, _ := unstructured.NestedSlice(newEC2VM.Object, "spec", "securityGroupIds")
+        unstructured.SetNestedSlice(newEC2VM.Object, append(groups, groupID), "spec", "securityGroupIds")
+    }
+    
+    // A released instance built the same way is already running, no creation needed
+    if reused := kc.claimIdleCloudInstance(requestName, user, session, newEC2VM); reused != "" {
+        return nil
+    }
+    
+    if refusal := kc.tenantQuotaRefusal(source, true); refusal != "" {
+        return fmt.Errorf("%w: %s", errTenantQuotaReached, refusal)
+    }
+    
+    // Leave the request pending rather than hit AWS RequestLimitExceeded
+    if !allowCloudInstanceCreation() {
+        return errCloudRateLimited
+    }
+    
+    if err := kc.cloud.CreateInstance(newEC2VM); err != nil {
         return fmt.Errorf("failed to create EC2TrainingVM: %v", err)
     }
     
@@ -441,29 +707,27 @@ func (kc *KratixController) cleanupExpiredAllocations() {
         allocatedAt, _, _ := unstructured.NestedString(request.Object, "status", "allocatedAt")
         
         // Clean up expired allocations
-        if state == "allocated" && allocatedAt != "" {
+        if state == "allocated" && allocatedAt != "" && ownsRequest(&request) {
             if t, err := time.Parse(time.RFC3339, allocatedAt); err == nil {
                 if time.Since(t) > 1*time.Hour {
                     log.Printf("🧹 Cleaning up expired allocation for request %s", requestName)
-                    kc.updateRequestStatus(requestName, "failed", "", "", false)
-                }
-            }
-        }
-        
-        // Clean up processed requests that no longer exist
-        if state == "failed" || state == "released" {
-            if t, err := time.Parse(time.RFC3339, allocatedAt); err == nil {
-                if time.Since(t) > 24*time.Hour {
-                    delete(kc.processedRequests, requestName)
+                    kc.failRequest(requestName, "", failureUnreachable, "allocation expired before VM became ready")
                 }
             }
         }
     }
+    
+    // Forget requests that no longer exist; expired ones age out of the cache
+    existing := make(map[string]bool, len(requests.Items))
+    for _, request := range requests.Items {
+        existing[request.GetName()] = true
+    }
+    kc.processedRequests.retain(func(requestName string) bool { return existing[requestName] })
 }
 
 // File operations helpers
 func (kc *KratixController) writeFile(path, content string) error {
-    return os.WriteFile(path, []byte(content), 0644)
+    return os.WriteFile(path, []byte(content), 0600) // Inventories carry secret variables
 }
 
 func (kc *KratixController) removeFile(path string) {
@@ -477,7 +741,8 @@ func (kc *KratixController) monitorCloudInstances() {
         return
     }
     
-    for _, ec2vm := range ec2vms.Items {
+    for i := range ec2vms.Items {
+        ec2vm := &ec2vms.Items[i]
         labels := ec2vm.GetLabels()
         if labels == nil {
             continue
@@ -491,38 +756,85 @@ func (kc *KratixController) monitorCloudInstances() {
         vmIP, _, _ := unstructured.NestedString(ec2vm.Object, "status", "vmIP")
         state, _, _ := unstructured.NestedString(ec2vm.Object, "status", "state")
         ready, _, _ := unstructured.NestedBool(ec2vm.Object, "status", "ready")
-        instanceId, _, _ := unstructured.NestedString(ec2vm.Object, "status", "instanceId")
         
         // If EC2 instance is ready, update the VMProvisioningRequest
         if vmIP != "" && (state == "running" || ready) {
             log.Printf("✅ EC2 instance %s ready for Kratix request %s", vmIP, kratixRequest)
             kc.updateRequestStatus(kratixRequest, "allocated", vmIP, "ec2", false)
+            publishStage(stageProvisioning, kratixRequest)
             
-            // Update instance ID in status
+            // Instance ID, availability zone and console URL in status
             patch := map[string]interface{}{
-                "status": map[string]interface{}{
-                    "instanceId": instanceId,
-                },
+                "status": cloudConsoleStatus(ec2vm),
             }
             patchBytes, _ := json.Marshal(patch)
-            kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
-                context.TODO(), kratixRequest, types.MergePatchType,
-                patchBytes, metav1.PatchOptions{}, "status")
+            patchStatus(kc.client, vmProvisioningRequestGVR, "default", kratixRequest, patchBytes)
+        } else {
+            // Move to another subnet/AZ or instance type on capacity and quota errors
+            kc.handleCloudCapacityError(kratixRequest, ec2vm)
         }
     }
 }
 
+// Run a stage's pass as soon as one of its events comes in
+func (kc *KratixController) RegisterStageHandlers() {
+    passes := map[string]func(){
+        stageDetection:    kc.processVMProvisioningRequests,
+        stageAllocation:   func() { withAllocationLease(kc.client, kc.allocateVMs) },
+        stageProvisioning: kc.updateVMStatus,
+    }
+    for stage, pass := range passes {
+        stage, pass := stage, pass
+        handleStage(stage, func(event stageEvent) error {
+            if !apiAvailable(apiKratix) || !clusterReachable() {
+                return errStageUnavailable
+            }
+            kc.passMu.Lock()
+            defer kc.passMu.Unlock()
+            stagePass(stage, pass)
+            return nil
+        })
+    }
+}
+
 // Add cloud monitoring to the main loop
 func (kc *KratixController) WatchVMProvisioningRequestsWithCloudMonitoring() {
     log.Println("🎯 Starting Kratix Promise VM Provisioning Controller with Cloud Monitoring...")
     
+    kc.RecoverAllocations()
+    
     for {
-        kc.processVMProvisioningRequests()
-        kc.allocateVMs()
-        kc.monitorCloudInstances()  // Monitor cloud instances
-        kc.updateVMStatus()
-        kc.cleanupExpiredAllocations()
+        kc.reconcile()
+        reportStartupReconciliation(kc.client)
         
+        Heartbeat(kc.client, HeartbeatKratixController)
         time.Sleep(10 * time.Second)
     }
 }
+
+// One pass over every request, the unit tests step through
+func (kc *KratixController) reconcile() {
+    if !apiAvailable(apiKratix) || !clusterReachable() {
+        return
+    }
+    kc.passMu.Lock()
+    defer kc.passMu.Unlock()
+    stagePass(stageDetection, kc.processVMProvisioningRequests)
+    // One shard allocates at a time, so two never pick the same static VM
+    stagePass(stageAllocation, func() { withAllocationLease(kc.client, kc.allocateVMs) })
+    if RunsClusterWideWork() {
+        kc.monitorCloudInstances() // Monitor cloud instances
+        kc.updateRequestQueue()    // Queue position and ETA for requests waiting on capacity
+    }
+    stagePass(stageProvisioning, kc.updateVMStatus)
+    if RunsClusterWideWork() {
+        kc.reconcileVMDNSRecords() // Keep DNS names pointing at current VM addresses
+    }
+    if runsStage(stageProvisioning) {
+        kc.checkReadinessGates() // Promote provisioned VMs once their gates pass
+        kc.checkDrift()          // Re-converge ready VMs that drifted
+    }
+    kc.refreshSessionTimes() // Keep the learner's countdown in line with keepalives
+    kc.retryFailedRequests() // Retry failures with backoff, dead-letter the rest
+    kc.cleanupExpiredAllocations()
+}
@@ -0,0 +1,208 @@
+// internal/kratix_controller_test.go - Request state machine driven through the test harness
+package internal
+
+import (
+    "context"
+    "testing"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// One static VM in the default environment, no environments file
+func useStaticPool(t *testing.T, ips string) {
+    t.Setenv("VM_ENVIRONMENTS_FILE", t.TempDir()+"/environments.json")
+    t.Setenv("STATIC_VM_POOL", ips)
+}
+
+func mustStep(t *testing.T, h *testHarness, n int) {
+    t.Helper()
+    if err := h.step(n); err != nil {
+        t.Fatalf("step: %v", err)
+    }
+}
+
+func expectState(t *testing.T, h *testHarness, name, want string) {
+    t.Helper()
+    if got := h.requestState(name); got != want {
+        request, _ := h.request(name)
+        status := map[string]interface{}{}
+        if request != nil {
+            status, _, _ = unstructured.NestedMap(request.Object, "status")
+        }
+        t.Fatalf("request %s is %q, want %q (status %v)", name, got, want, status)
+    }
+}
+
+func failureReason(h *testHarness, name string) string {
+    request, err := h.request(name)
+    if err != nil {
+        return ""
+    }
+    reason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+    return reason
+}
+
+func TestRequestLifecycleOnStaticVM(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    h := newTestHarness(t)
+    h.prober.setReachable("10.0.0.1", false)
+    h.prober.setGateFailure("10.0.0.1", conditionShellReady)
+
+    if err := h.submitRequest("req-1", "alice", "session-1"); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "pending")
+
+    h.prober.setReachable("10.0.0.1", true)
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "allocated")
+    if vmIP := h.requestVM("req-1"); vmIP != "10.0.0.1" {
+        t.Fatalf("request got VM %q, want 10.0.0.1", vmIP)
+    }
+
+    // Not provisioned before the boot wait has passed
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "allocated")
+    if runs := h.executor.playbookRuns(); len(runs) != 0 {
+        t.Fatalf("playbooks ran during the boot wait: %v", runs)
+    }
+
+    if err := h.elapse(getBootWaitTime("10.0.0.1")); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", stateProvisionedUnverified)
+    runs := h.executor.playbookRuns()
+    if len(runs) == 0 {
+        t.Fatal("no playbook ran")
+    }
+    for _, run := range runs {
+        if run.Session != "session-1" {
+            t.Errorf("playbook %s ran for session %q, want session-1", run.Playbook, run.Session)
+        }
+    }
+
+    h.prober.setGateFailure("10.0.0.1", "")
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "ready")
+}
+
+func TestCloudFallbackWhenPoolIsEmpty(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    h := newTestHarness(t)
+    h.prober.setReachable("10.0.0.1", false)
+
+    if err := h.submitRequest("req-1", "alice", "session-1"); err != nil {
+        t.Fatal(err)
+    }
+    request, err := h.request("req-1")
+    if err != nil {
+        t.Fatal(err)
+    }
+    if err := unstructured.SetNestedField(request.Object, true, "spec", "cloudFallback", "enabled"); err != nil {
+        t.Fatal(err)
+    }
+    if _, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Update(context.TODO(), request, metav1.UpdateOptions{}); err != nil {
+        t.Fatal(err)
+    }
+
+    // Launched on the first pass, provisioned once the instance reports running
+    mustStep(t, h, 1)
+    if h.cloud.launched != 1 {
+        t.Fatalf("%d cloud instances launched, want 1", h.cloud.launched)
+    }
+    if vmIP := h.requestVM("req-1"); vmIP != "" {
+        t.Fatalf("request got VM %q before the instance booted", vmIP)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "ready")
+    vmIP := h.requestVM("req-1")
+    if vmIP == "" || vmIP == "10.0.0.1" {
+        t.Fatalf("request got VM %q, want the cloud instance", vmIP)
+    }
+    if len(h.executor.playbookRuns()) == 0 {
+        t.Fatal("the cloud instance was not provisioned")
+    }
+
+    mustStep(t, h, 1)
+    if h.cloud.launched != 1 {
+        t.Fatalf("%d cloud instances launched, want 1", h.cloud.launched)
+    }
+}
+
+func TestSSHTimeoutFailsRequest(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    h := newTestHarness(t)
+    h.prober.setSSHDown("10.0.0.1")
+
+    if err := h.submitRequest("req-1", "alice", "session-1"); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    if err := h.elapse(getBootWaitTime("10.0.0.1")); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "failed")
+    if reason := failureReason(h, "req-1"); reason != failureSSHTimeout {
+        t.Fatalf("failure reason %q, want %q", reason, failureSSHTimeout)
+    }
+    if runs := h.executor.playbookRuns(); len(runs) != 0 {
+        t.Fatalf("playbooks ran without SSH: %v", runs)
+    }
+}
+
+func TestPlaybookFailureFailsRequest(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    h := newTestHarness(t)
+    h.executor.failing[".yaml"] = "Install packages"
+
+    if err := h.submitRequest("req-1", "alice", "session-1"); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    if err := h.elapse(getBootWaitTime("10.0.0.1")); err != nil {
+        t.Fatal(err)
+    }
+    mustStep(t, h, 1)
+    expectState(t, h, "req-1", "failed")
+    if reason := failureReason(h, "req-1"); reason != failurePlaybookFailed {
+        t.Fatalf("failure reason %q, want %q", reason, failurePlaybookFailed)
+    }
+    if runs := h.executor.playbookRuns(); len(runs) != 1 {
+        t.Fatalf("%d playbooks ran, want the failing one only", len(runs))
+    }
+}
+
+func TestAdoptVMOnlyInsideAllowlistAndOutsidePools(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    t.Setenv("ADOPT_VM_CIDRS", "10.0.0.0/24")
+    h := newTestHarness(t)
+
+    for name, ip := range map[string]string{"pooled": "10.0.0.1", "outside": "10.9.0.5", "adopted": "10.0.0.50"} {
+        if err := h.submitRequest(name, "alice", "session-"+name); err != nil {
+            t.Fatal(err)
+        }
+        request, err := h.request(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        unstructured.SetNestedField(request.Object, ip, "spec", "adoptVM", "ip")
+        if _, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Update(context.TODO(), request, metav1.UpdateOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    mustStep(t, h, 1)
+
+    for _, name := range []string{"pooled", "outside"} {
+        expectState(t, h, name, "failed")
+        if reason := failureReason(h, name); reason != failureAdoptionRefused {
+            t.Fatalf("request %s failure reason %q, want %q", name, reason, failureAdoptionRefused)
+        }
+    }
+    if vmIP := h.requestVM("adopted"); vmIP != "10.0.0.50" {
+        t.Fatalf("request adopted got VM %q, want 10.0.0.50", vmIP)
+    }
+}
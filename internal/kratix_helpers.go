@@ -5,7 +5,6 @@ import (
     "context"
     "fmt"
     "log"
-    "os"
     "strings"
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -80,7 +79,7 @@ func checkKratixAvailability(client dynamic.Interface) bool {
 
 // Get integration mode from environment
 func getIntegrationMode() string {
-    mode := os.Getenv("INTEGRATION_MODE")
+    mode := Setting("INTEGRATION_MODE")
     if mode == "" {
         mode = "hybrid"
     }
@@ -108,12 +107,7 @@ func IsHobbyFarmRequest(request *unstructured.Unstructured) bool {
 
 // Get HobbyFarm session name from VMProvisioningRequest
 func GetHobbyFarmSessionFromRequest(request *unstructured.Unstructured) string {
-    labels := request.GetLabels()
-    if labels == nil {
-        return ""
-    }
-    
-    return labels["hobbyfarm.io/session"]
+    return objectSession(request)
 }
 
 // Get HobbyFarm user from VMProvisioningRequest
@@ -139,6 +133,9 @@ func GetHobbyFarmScenarioFromRequest(request *unstructured.Unstructured) string
 // Create VMProvisioningRequest from HobbyFarm session
 func CreateVMProvisioningRequestFromSession(client dynamic.Interface, session *unstructured.Unstructured) error {
     sessionName := session.GetName()
+    if !claimSessionPathway(client, session, pathwayKratix) {
+        return fmt.Errorf("session %s is claimed by another provisioning pathway", sessionName)
+    }
     user, _, _ := unstructured.NestedString(session.Object, "spec", "user")
     scenario, _, _ := unstructured.NestedString(session.Object, "spec", "scenario")
     
@@ -152,6 +149,7 @@ func CreateVMProvisioningRequestFromSession(client dynamic.Interface, session *u
     
     // Get scenario provisioning configuration
     provisioningConfig := getDefaultProvisioningConfig()
+    environment := resolveSessionEnvironment(client, session)
     
     // Create VMProvisioningRequest
     kratixRequest := &unstructured.Unstructured{
@@ -159,12 +157,12 @@ func CreateVMProvisioningRequestFromSession(client dynamic.Interface, session *u
             "apiVersion": "platform.kratix.io/v1alpha1",
             "kind":       "VMProvisioningRequest",
             "metadata": map[string]interface{}{
-                "name":      sessionName,
+                "name":      requestNameForSession(sessionName),
                 "namespace": "default",
                 "labels": map[string]interface{}{
-                    "hobbyfarm.io/session":  sessionName,
-                    "hobbyfarm.io/user":     user,
-                    "hobbyfarm.io/scenario": scenario,
+                    "hobbyfarm.io/user":     safeLabelValue(user),
+                    "hobbyfarm.io/scenario": safeLabelValue(scenario),
+                    environmentLabel:        environment,
                     "source":                "hobbyfarm-integration",
                 },
                 "annotations": map[string]interface{}{
@@ -176,24 +174,33 @@ func CreateVMProvisioningRequestFromSession(client dynamic.Interface, session *u
                 "user":           user,
                 "session":        sessionName,
                 "scenario":       scenario,
+                "environment":    environment,
                 "vmTemplate":     "hybrid-ubuntu-template",
                 "timeout":        600,
                 "preferStaticVM": true,
                 "provisioning":   provisioningConfig,
-                "cloudFallback": map[string]interface{}{
-                    "enabled":      true,
-                    "provider":     "aws",
-                    "instanceType": "t3.micro",
-                    "region":       "us-east-1",
-                },
+                "cloudFallback":  buildCloudFallbackSpec(getScenarioCloudStorage(client, scenario)),
             },
         },
     }
     
-    _, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Create(context.TODO(), kratixRequest, metav1.CreateOptions{})
+    setSessionLabel(kratixRequest, sessionName)
+    
+    // Ports the scenario's services listen on
+    if ports := buildExposedPortsSpec(client, scenario); len(ports) > 0 {
+        unstructured.SetNestedSlice(kratixRequest.Object, ports, "spec", "ports")
+    }
+    
+    applyPassthroughMetadata(kratixRequest, session)
+    
+    _, created, err := createOrAdopt(client, vmProvisioningRequestGVR, kratixRequest, adoptRequest)
     if err != nil {
         return err
     }
+    if !created {
+        log.Printf("♻️ VMProvisioningRequest %s already exists for HobbyFarm session, adopted it", sessionName)
+        return nil
+    }
     
     log.Printf("✅ Created VMProvisioningRequest %s from HobbyFarm session", sessionName)
     return nil
@@ -239,10 +246,11 @@ func GetVMProvisioningRequestStatusSummary(client dynamic.Interface) map[string]
 func getCloudProviderConfig(provider string) map[string]interface{} {
     switch provider {
     case "aws":
+        template := loadCloudInstanceTemplate()
         return map[string]interface{}{
-            "instanceType": "t3.micro",
-            "region":       "us-east-1",
-            "ami":          "ami-0c02fb55956c7d316", // Ubuntu 20.04
+            "instanceType": template.InstanceType,
+            "region":       template.Region,
+            "ami":          template.AMI,
         }
     case "azure":
         return map[string]interface{}{
@@ -288,7 +296,7 @@ func GetVMTypeFromIP(ip string) string {
 
 // Check if IP is in static VM pool
 func IsStaticVMIP(ip string) bool {
-    for _, staticIP := range vmPool {
+    for _, staticIP := range allStaticVMs() {
         if ip == staticIP {
             return true
         }
@@ -320,15 +328,19 @@ func GetAvailableStaticVMs(client dynamic.Interface) []string {
             vmIP, _, _ := unstructured.NestedString(req.Object, "status", "vmIP")
             state, _, _ := unstructured.NestedString(req.Object, "status", "state")
             
-            if vmIP != "" && (state == "allocated" || state == "provisioning" || state == "ready") {
+            if vmIP != "" && (state == "allocated" || state == "provisioning" || state == stateProvisionedUnverified || state == "ready") {
                 usedIPs[vmIP] = true
             }
         }
     }
     
-    // Find available VMs
+    // Find available VMs, skipping those in maintenance
+    maintenance := getMaintenanceVMs(client)
     var availableVMs []string
-    for _, ip := range vmPool {
+    for _, ip := range allStaticVMs() {
+        if _, drained := maintenance[ip]; drained {
+            continue
+        }
         if !usedIPs[ip] && isVMReachable(ip) {
             availableVMs = append(availableVMs, ip)
         }
@@ -2,23 +2,73 @@ package internal
 
 import (
     "log"
+    "net/http"
     "os"
     "path/filepath"
+    "strconv"
+    "sync"
 
+    "k8s.io/client-go/discovery"
     "k8s.io/client-go/dynamic"
     "k8s.io/client-go/tools/clientcmd"
 )
 
+// Requests per second the provisioner may send the API server, shared by every
+// controller loop. client-go's default of 5 throttles the loops against each other.
+func getKubeClientQPS() float32 {
+    if qps, err := strconv.ParseFloat(Setting("KUBE_CLIENT_QPS"), 32); err == nil && qps > 0 {
+        return float32(qps)
+    }
+    return 20
+}
+
+// Requests allowed above the QPS in a short burst
+func getKubeClientBurst() int {
+    if burst, err := strconv.Atoi(Setting("KUBE_CLIENT_BURST")); err == nil && burst > 0 {
+        return burst
+    }
+    return 40
+}
+
+var kubeClient struct {
+    sync.Once
+    client dynamic.Interface
+}
+
+// The one client of the process: every controller shares its rate limit, so the
+// limit is what the API server sees from the provisioner
 func InitKubeClient() dynamic.Interface {
+    kubeClient.Do(func() {
+        kubeClient.client = newKubeClient()
+    })
+    return kubeClient.client
+}
+
+func newKubeClient() dynamic.Interface {
     kubeconfig := filepath.Join(os.Getenv("HOME"), ".kube", "config")
     config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
     if err != nil {
         log.Fatalf("❌ Could not load kubeconfig: %v", err)
     }
+    config.QPS = getKubeClientQPS()
+    config.Burst = getKubeClientBurst()
+    config.RateLimiter = newMeteredRateLimiter(config.QPS, config.Burst)
+    config.WrapTransport = func(rt http.RoundTripper) http.RoundTripper {
+        return watchClusterAvailability(countServerThrottling(rt))
+    }
+    log.Printf("🚦 Kubernetes client limited to %.0f QPS, burst %d", config.QPS, config.Burst)
 
     client, err := dynamic.NewForConfig(config)
     if err != nil {
         log.Fatalf("❌ Failed to create dynamic client: %v", err)
     }
+
+    discoveryClient, err := discovery.NewDiscoveryClientForConfig(config)
+    if err != nil {
+        log.Fatalf("❌ Failed to create discovery client: %v", err)
+    }
+    detectStatusSubresources(discoveryClient)
+    setAPIDiscovery(discoveryClient)
+
     return client
 }
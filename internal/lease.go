@@ -0,0 +1,171 @@
+// internal/lease.go - coordination.k8s.io Leases held by one replica at a time: VM locks, shards and the allocation Lease
+package internal
+
+import (
+    "context"
+    "log"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// A Lease in the default namespace that one holder has at a time. A holder that
+// stops renewing it for Duration loses it to the next one asking.
+type Lease struct {
+    Client   dynamic.Interface
+    Name     string
+    Holder   string
+    Duration time.Duration
+    // What the Lease guards, for logs, e.g. "lock on VM 10.0.0.7"
+    Description string
+    // Metadata of the Lease when it is created
+    Labels      map[string]interface{}
+    Annotations map[string]interface{}
+    // Delete the Lease on release instead of clearing its holder
+    DeleteOnRelease bool
+}
+
+// Whether the Lease was not renewed within its duration
+func leaseExpired(lease *unstructured.Unstructured, now time.Time) bool {
+    renewTime, _, _ := unstructured.NestedString(lease.Object, "spec", "renewTime")
+    renewed, err := time.Parse(metav1.RFC3339Micro, renewTime)
+    if err != nil {
+        return true
+    }
+    seconds, _, _ := unstructured.NestedInt64(lease.Object, "spec", "leaseDurationSeconds")
+    return now.After(renewed.Add(time.Duration(seconds) * time.Second))
+}
+
+// Stamp the holder and renewal on the Lease, keeping acquireTime while it stays ours
+func (l *Lease) setSpec(lease *unstructured.Unstructured, now time.Time) {
+    acquireTime, _, _ := unstructured.NestedString(lease.Object, "spec", "acquireTime")
+    if current, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity"); current != l.Holder || acquireTime == "" {
+        acquireTime = now.UTC().Format(metav1.RFC3339Micro)
+    }
+    unstructured.SetNestedMap(lease.Object, map[string]interface{}{
+        "holderIdentity":       l.Holder,
+        "leaseDurationSeconds": int64(l.Duration.Seconds()),
+        "acquireTime":          acquireTime,
+        "renewTime":            now.UTC().Format(metav1.RFC3339Micro),
+    }, "spec")
+}
+
+// One attempt: create the Lease, renew it when ours, or take it over when it
+// expired. Returns the current holder when someone else has it. The Update
+// carries the resourceVersion, so of two replicas taking over only one wins.
+func (l *Lease) tryAcquire() (bool, string, error) {
+    leases := l.Client.Resource(leaseGVR).Namespace("default")
+    now := time.Now()
+
+    lease, err := leases.Get(context.TODO(), l.Name, metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        metadata := map[string]interface{}{
+            "name":      l.Name,
+            "namespace": "default",
+        }
+        if len(l.Labels) > 0 {
+            metadata["labels"] = l.Labels
+        }
+        if len(l.Annotations) > 0 {
+            metadata["annotations"] = l.Annotations
+        }
+        lease = &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "coordination.k8s.io/v1",
+                "kind":       "Lease",
+                "metadata":   metadata,
+            },
+        }
+        l.setSpec(lease, now)
+        _, err = leases.Create(context.TODO(), lease, metav1.CreateOptions{})
+        if errors.IsAlreadyExists(err) {
+            return false, "", nil
+        }
+        return err == nil, "", err
+    }
+    if err != nil {
+        return false, "", err
+    }
+
+    current, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity")
+    if current != "" && current != l.Holder && !leaseExpired(lease, now) {
+        return false, current, nil
+    }
+    if current != "" && current != l.Holder {
+        log.Printf("🔓 Taking over expired %s from %s", l.Description, current)
+    }
+    l.setSpec(lease, now)
+    _, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+    if errors.IsConflict(err) {
+        return false, "", nil
+    }
+    return err == nil, "", err
+}
+
+// Push the renewal time of a Lease we hold. Returns the holder that took it over
+// instead, if any.
+func (l *Lease) renew() (string, error) {
+    leases := l.Client.Resource(leaseGVR).Namespace("default")
+    lease, err := leases.Get(context.TODO(), l.Name, metav1.GetOptions{})
+    if err != nil {
+        return "", err
+    }
+    if current, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity"); current != l.Holder {
+        return current, nil
+    }
+    unstructured.SetNestedField(lease.Object, time.Now().UTC().Format(metav1.RFC3339Micro), "spec", "renewTime")
+    _, err = leases.Update(context.TODO(), lease, metav1.UpdateOptions{})
+    return "", err
+}
+
+// Renew the Lease every third of its duration until stop closes; one taken
+// over by someone else is only logged
+func (l *Lease) keepRenewed(stop <-chan struct{}) {
+    ticker := time.NewTicker(l.Duration / 3)
+    defer ticker.Stop()
+    for {
+        select {
+        case <-stop:
+            return
+        case <-ticker.C:
+        }
+        takenBy, err := l.renew()
+        if err != nil {
+            log.Printf("⚠️ Could not renew %s: %v", l.Description, err)
+            continue
+        }
+        if takenBy != "" {
+            log.Printf("⚠️ Lost %s to %s", l.Description, takenBy)
+            return
+        }
+    }
+}
+
+// Free the Lease at once if it is still ours
+func (l *Lease) release() {
+    leases := l.Client.Resource(leaseGVR).Namespace("default")
+    lease, err := leases.Get(context.TODO(), l.Name, metav1.GetOptions{})
+    if err != nil {
+        return
+    }
+    if current, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity"); current != l.Holder {
+        return
+    }
+    if l.DeleteOnRelease {
+        uid, resourceVersion := lease.GetUID(), lease.GetResourceVersion()
+        err = leases.Delete(context.TODO(), lease.GetName(), metav1.DeleteOptions{
+            Preconditions: &metav1.Preconditions{UID: &uid, ResourceVersion: &resourceVersion},
+        })
+        if err != nil && !errors.IsNotFound(err) {
+            log.Printf("⚠️ Could not release %s: %v", l.Description, err)
+        }
+        return
+    }
+    unstructured.SetNestedField(lease.Object, "", "spec", "holderIdentity")
+    if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil && !errors.IsConflict(err) {
+        log.Printf("⚠️ Could not release %s: %v", l.Description, err)
+    }
+}
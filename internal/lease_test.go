@@ -0,0 +1,133 @@
+// internal/lease_test.go - Lease takeover, shards dropped after a lapsed renewal, the renewed allocation Lease and VM locks tried once
+package internal
+
+import (
+    "context"
+    "fmt"
+    "testing"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    k8stesting "k8s.io/client-go/testing"
+)
+
+func newLeaseClient() *dynamicfake.FakeDynamicClient {
+    return dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), harnessListKinds())
+}
+
+func leaseRenewTime(t *testing.T, client *dynamicfake.FakeDynamicClient, name string) string {
+    t.Helper()
+    lease, err := client.Resource(leaseGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+    if err != nil {
+        t.Fatalf("lease %s: %v", name, err)
+    }
+    renewTime, _, _ := unstructured.NestedString(lease.Object, "spec", "renewTime")
+    return renewTime
+}
+
+func TestLeaseTakenOverOnceExpired(t *testing.T) {
+    client := newLeaseClient()
+    first := vmLock(client, "10.0.0.7", "pod-1/kratix:req-1")
+    second := vmLock(client, "10.0.0.7", "pod-2/kratix:req-2")
+
+    if acquired, _, err := first.tryAcquire(); err != nil || !acquired {
+        t.Fatalf("first holder: acquired=%v err=%v", acquired, err)
+    }
+    acquired, current, err := second.tryAcquire()
+    if err != nil || acquired || current != first.Holder {
+        t.Fatalf("second holder while held: acquired=%v current=%q err=%v", acquired, current, err)
+    }
+
+    leases := client.Resource(leaseGVR).Namespace("default")
+    lease, _ := leases.Get(context.TODO(), first.Name, metav1.GetOptions{})
+    stale := time.Now().Add(-2 * first.Duration).UTC().Format(metav1.RFC3339Micro)
+    unstructured.SetNestedField(lease.Object, stale, "spec", "renewTime")
+    if _, err := leases.Update(context.TODO(), lease, metav1.UpdateOptions{}); err != nil {
+        t.Fatal(err)
+    }
+    if acquired, _, err := second.tryAcquire(); err != nil || !acquired {
+        t.Fatalf("second holder after expiry: acquired=%v err=%v", acquired, err)
+    }
+
+    // The former holder neither renews nor releases what it lost
+    if takenBy, err := first.renew(); err != nil || takenBy != second.Holder {
+        t.Fatalf("renew by former holder: takenBy=%q err=%v", takenBy, err)
+    }
+    first.release()
+    if _, err := leases.Get(context.TODO(), first.Name, metav1.GetOptions{}); err != nil {
+        t.Fatalf("lock released by its former holder: %v", err)
+    }
+    second.release()
+    if _, err := leases.Get(context.TODO(), first.Name, metav1.GetOptions{}); err == nil {
+        t.Fatal("lock still there after its holder released it")
+    }
+}
+
+func TestClaimShardDropsShardWhoseRenewalLapsed(t *testing.T) {
+    t.Setenv("SHARD_COUNT", "2")
+    client := newLeaseClient()
+    if shard, ok := claimShard(client, "pod-1", -1, time.Time{}); shard != 0 || !ok {
+        t.Fatalf("first claim: shard=%d ok=%v", shard, ok)
+    }
+
+    client.PrependReactor("*", "leases", func(action k8stesting.Action) (bool, runtime.Object, error) {
+        return true, nil, fmt.Errorf("API server unreachable")
+    })
+    if shard, ok := claimShard(client, "pod-1", 0, time.Now()); shard != 0 || ok {
+        t.Fatalf("renewal failing within the lease: shard=%d ok=%v", shard, ok)
+    }
+    lapsed := time.Now().Add(-getShardLeaseDuration())
+    if shard, ok := claimShard(client, "pod-1", 0, lapsed); shard != -1 || ok {
+        t.Fatalf("renewal failing past the lease: shard=%d ok=%v", shard, ok)
+    }
+}
+
+func TestAllocationLeaseRenewedWhileAllocating(t *testing.T) {
+    t.Setenv("SHARD_COUNT", "2")
+    t.Setenv("SHARD_LEASE_SECONDS", "3")
+    client := newLeaseClient()
+
+    var acquiredAt, laterAt string
+    withAllocationLease(client, func() {
+        acquiredAt = leaseRenewTime(t, client, allocationLeaseName)
+        time.Sleep(1500 * time.Millisecond)
+        laterAt = leaseRenewTime(t, client, allocationLeaseName)
+    })
+    if acquiredAt == laterAt {
+        t.Fatalf("allocation Lease not renewed during the pass, renewTime stayed %s", acquiredAt)
+    }
+
+    lease, _ := client.Resource(leaseGVR).Namespace("default").Get(context.TODO(), allocationLeaseName, metav1.GetOptions{})
+    if holder, _, _ := unstructured.NestedString(lease.Object, "spec", "holderIdentity"); holder != "" {
+        t.Fatalf("allocation Lease still held by %s after the pass", holder)
+    }
+}
+
+func TestWithVMLockReturnsAtOnceWhileHeld(t *testing.T) {
+    client := newLeaseClient()
+    held := vmLock(client, "10.0.0.7", "pod-1/kratix:req-1")
+    if acquired, _, err := held.tryAcquire(); err != nil || !acquired {
+        t.Fatalf("first holder: acquired=%v err=%v", acquired, err)
+    }
+
+    ran := false
+    started := time.Now()
+    err := withVMLock(client, "10.0.0.7", "kratix:req-2", func() error {
+        ran = true
+        return nil
+    })
+    if holder, locked := vmLockHeld(err); !locked || holder != held.Holder {
+        t.Fatalf("run on a held VM returned %v, want it locked by %s", err, held.Holder)
+    }
+    if ran || time.Since(started) > time.Second {
+        t.Fatalf("run on a held VM ran=%v after %v", ran, time.Since(started))
+    }
+
+    held.release()
+    if err := withVMLock(client, "10.0.0.7", "kratix:req-2", func() error { ran = true; return nil }); err != nil || !ran {
+        t.Fatalf("run on a released VM: ran=%v err=%v", ran, err)
+    }
+}
@@ -0,0 +1,125 @@
+// internal/log_redaction.go - Keep SSH key paths, tokens and secret variables out of logs and artifacts
+package internal
+
+import (
+    "regexp"
+    "strings"
+)
+
+// Words of a variable name that make its value secret. Whole words only, so
+// db_password and apiKey are secret but passed_checks, keys and monkey are not.
+var secretNameWords = map[string]bool{
+    "pass": true, "passwd": true, "password": true, "passphrase": true,
+    "secret": true, "token": true, "key": true, "authkey": true, "apikey": true,
+    "credential": true, "credentials": true,
+}
+
+// Word boundaries of snake_case, kebab-case, dotted and camelCase names
+var nameWordBoundary = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+
+// key=value, key: value and "key": "value" pairs, as printed by Ansible -v
+var assignmentPattern = regexp.MustCompile(`("?)([\w.-]+)("?\s*[=:]\s*)("[^"]*"|'[^']*'|[^\s,}\]]+)`)
+
+// Known secret values shorter than this are not searched for in output: a
+// one-character password would redact that character everywhere
+const minRedactedValueLength = 4
+
+var privateKeyBlockPattern = regexp.MustCompile(`(?s)-----BEGIN [A-Z ]*PRIVATE KEY-----.*?-----END [A-Z ]*PRIVATE KEY-----`)
+
+var awsAccessKeyPattern = regexp.MustCompile(`\b(?:AKIA|ASIA)[A-Z0-9]{16}\b`)
+
+// Scenario annotation listing extra variables to treat as secret, e.g. "db_admin,license"
+const secretVariablesAnnotation = "provisioning.hobbyfarm.io/secret-variables"
+
+const redactedPlaceholder = "<redacted>"
+
+// Security review mode (the default) redacts Ansible output before it is logged.
+// SECURITY_REVIEW_MODE=false logs it verbatim, for debugging environments only.
+func securityReviewMode() bool {
+    return Setting("SECURITY_REVIEW_MODE") != "false"
+}
+
+// Whether one of the words of name is a secret one
+func isSecretName(name string) bool {
+    words := strings.FieldsFunc(nameWordBoundary.ReplaceAllString(name, "${1}_${2}"), func(r rune) bool {
+        return r == '_' || r == '-' || r == '.'
+    })
+    for _, word := range words {
+        if secretNameWords[strings.ToLower(word)] {
+            return true
+        }
+    }
+    return false
+}
+
+// Secret by name or because the scenario marked it secret
+func isSecretVariable(name string, config *ProvisioningConfig) bool {
+    if isSecretName(name) {
+        return true
+    }
+    if config == nil {
+        return false
+    }
+    for _, secret := range config.SecretVariables {
+        if secret == name {
+            return true
+        }
+    }
+    return false
+}
+
+// Replace secret variable values, the SSH key path, private keys, AWS access keys and
+// secret-looking assignments with a placeholder
+func redactSecrets(text string, config *ProvisioningConfig, sshKeyPath string) string {
+    if config != nil {
+        for name, value := range config.Variables {
+            if len(value) >= minRedactedValueLength && isSecretVariable(name, config) {
+                text = strings.ReplaceAll(text, value, redactedPlaceholder)
+            }
+        }
+        for _, value := range config.ResolvedSecrets {
+            if len(value) >= minRedactedValueLength {
+                text = strings.ReplaceAll(text, value, redactedPlaceholder)
+            }
+        }
+    }
+    if sshKeyPath != "" {
+        text = strings.ReplaceAll(text, sshKeyPath, "<ssh-key>")
+    }
+
+    text = privateKeyBlockPattern.ReplaceAllString(text, redactedPlaceholder)
+    text = awsAccessKeyPattern.ReplaceAllString(text, redactedPlaceholder)
+    return redactAssignments(text)
+}
+
+// Replace the values of assignments whose key is a secret name. The value of any
+// other assignment is searched too, for chains like opts=token=abc.
+func redactAssignments(text string) string {
+    return assignmentPattern.ReplaceAllStringFunc(text, func(assignment string) string {
+        parts := assignmentPattern.FindStringSubmatch(assignment)
+        if isSecretName(parts[2]) {
+            return parts[1] + parts[2] + parts[3] + redactedPlaceholder
+        }
+        return parts[1] + parts[2] + parts[3] + redactAssignments(parts[4])
+    })
+}
+
+// Replace values of secret inventory variables with a placeholder
+func redactInventory(content string, config *ProvisioningConfig) string {
+    var redacted strings.Builder
+    for _, line := range strings.Split(strings.TrimSuffix(content, "\n"), "\n") {
+        if key, _, found := strings.Cut(line, "="); found && !strings.Contains(key, " ") && isSecretVariable(key, config) {
+            line = key + "=" + redactedPlaceholder
+        }
+        redacted.WriteString(line + "\n")
+    }
+    return redactSecrets(redacted.String(), config, "")
+}
+
+// Command output for the provisioner log, redacted unless security review mode is off
+func (ar *AnsibleRunner) sanitizeForLog(output []byte, config *ProvisioningConfig) string {
+    if !securityReviewMode() {
+        return string(output)
+    }
+    return redactSecrets(string(output), config, ar.sshKeyPath)
+}
@@ -0,0 +1,54 @@
+// internal/log_redaction_test.go - Which names and values redaction hides
+package internal
+
+import (
+    "strings"
+    "testing"
+)
+
+func TestIsSecretName(t *testing.T) {
+    for name, want := range map[string]bool{
+        "db_password":           true,
+        "aws_secret_access_key": true,
+        "apiKey":                true,
+        "tailscale_authkey":     true,
+        "GITHUB_TOKEN":          true,
+        "ssh-pass":              true,
+        "passed":                false,
+        "checks_passed":         false,
+        "keys":                  false,
+        "monkey":                false,
+        "keyboard_layout":       false,
+        "session_name":          false,
+    } {
+        if got := isSecretName(name); got != want {
+            t.Errorf("isSecretName(%q) = %v, want %v", name, got, want)
+        }
+    }
+}
+
+func TestRedactSecrets(t *testing.T) {
+    config := &ProvisioningConfig{
+        Variables:       map[string]string{"db_password": "hunter22", "admin_pass": "x", "user": "alice"},
+        ResolvedSecrets: map[string]string{"license": "L1C3NS3-KEY"},
+    }
+    output := strings.Join([]string{
+        `ok: [session-1] => {"db_password": "hunter22", "monkey": "banana", "keys": 3}`,
+        `connecting with hunter22 and L1C3NS3-KEY`,
+        `tasks passed=12 failed=0 x-ray user=alice`,
+        `opts=token=abc123`,
+    }, "\n")
+
+    redacted := redactSecrets(output, config, "")
+    for _, leaked := range []string{"hunter22", "L1C3NS3-KEY", "abc123"} {
+        if strings.Contains(redacted, leaked) {
+            t.Errorf("%q not redacted:\n%s", leaked, redacted)
+        }
+    }
+    // Look-alike keys and short secret values leave the rest of the output alone
+    for _, kept := range []string{`"monkey": "banana"`, `"keys": 3`, "passed=12", "x-ray", "user=alice"} {
+        if !strings.Contains(redacted, kept) {
+            t.Errorf("%q redacted:\n%s", kept, redacted)
+        }
+    }
+}
@@ -0,0 +1,103 @@
+// internal/max_lifetime.go - Absolute cap on how long a VM stays allocated, whatever its session says
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strconv"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// MAX_ALLOCATION_HOURS, e.g. 12, guards against leaked sessions keeping cloud
+// instances alive indefinitely. 0 or unset disables it.
+func getMaxAllocationLifetime() time.Duration {
+    if hours, err := strconv.Atoi(Setting("MAX_ALLOCATION_HOURS")); err == nil && hours > 0 {
+        return time.Duration(hours) * time.Hour
+    }
+    return 0
+}
+
+// Time since status.allocatedAt, false when the object holds no allocation
+func allocationAge(object *unstructured.Unstructured) (time.Duration, bool) {
+    allocatedAt, _, _ := unstructured.NestedString(object.Object, "status", "allocatedAt")
+    t, err := time.Parse(time.RFC3339, allocatedAt)
+    if err != nil {
+        return 0, false
+    }
+    return time.Since(t), true
+}
+
+// Clean and release every VM allocated for longer than the max lifetime, with a
+// Warning Event on the request or TrainingVM saying so
+func (dc *DeprovisionController) enforceMaxAllocationLifetime() {
+    maxLifetime := getMaxAllocationLifetime()
+    if maxLifetime == 0 {
+        return
+    }
+
+    requests, err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err == nil {
+        for i := range requests.Items {
+            request := &requests.Items[i]
+            if !ownsRequest(request) {
+                continue
+            }
+            state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+            switch state {
+            case provisioner.StateAllocated, provisioner.StateProvisioning, provisioner.StateProvisionedUnverified, provisioner.StateReady:
+            default:
+                continue
+            }
+            age, allocated := allocationAge(request)
+            if !allocated || age < maxLifetime {
+                continue
+            }
+
+            vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            sessionName := GetHobbyFarmSessionFromRequest(request)
+            if sessionName == "" {
+                sessionName, _, _ = unstructured.NestedString(request.Object, "spec", "session")
+            }
+            log.Printf("⏰ Request %s held VM %s for %v, over the %v limit, releasing it", request.GetName(), vmIP, age.Round(time.Minute), maxLifetime)
+
+            dc.teardownRequestVM(request, sessionName)
+            if err := dc.releaseRequest(request.GetName(), provisioner.ReleaseMaxLifetime); err != nil {
+                log.Printf("❌ Failed to release request %s after its max lifetime: %v", request.GetName(), err)
+                continue
+            }
+            recordEvent(dc.client, request, eventTypeWarning, "MaxLifetimeExceeded",
+                fmt.Sprintf("VM %s released after %v, MAX_ALLOCATION_HOURS is %v", vmIP, age.Round(time.Minute), maxLifetime))
+        }
+    }
+
+    trainingVMs, err := dc.client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return // TrainingVM CRD is absent in kratix-only installs
+    }
+    for i := range trainingVMs.Items {
+        tvm := &trainingVMs.Items[i]
+        vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+        age, allocated := allocationAge(tvm)
+        if vmIP == "" || !allocated || age < maxLifetime {
+            continue
+        }
+
+        sessionName := objectSession(tvm)
+        log.Printf("⏰ TrainingVM %s held VM %s for %v, over the %v limit, releasing it", tvm.GetName(), vmIP, age.Round(time.Minute), maxLifetime)
+
+        dc.teardownTrainingVM(tvm, sessionName)
+        recordEvent(dc.client, tvm, eventTypeWarning, "MaxLifetimeExceeded",
+            fmt.Sprintf("VM %s released after %v, MAX_ALLOCATION_HOURS is %v", vmIP, age.Round(time.Minute), maxLifetime))
+        if err := dc.client.Resource(trainingVMGVR).Namespace("default").Delete(
+            context.TODO(), tvm.GetName(), metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+            log.Printf("❌ Failed to delete TrainingVM %s after its max lifetime: %v", tvm.GetName(), err)
+        }
+    }
+}
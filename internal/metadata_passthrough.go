@@ -0,0 +1,93 @@
+// internal/metadata_passthrough.go - Session label/annotation passthrough to derived resources
+package internal
+
+import (
+    "strings"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Parse a comma-separated passthrough policy. Entries are exact keys
+// (cost-center) or prefixes ending in '*' (billing.example.com/*).
+func parsePassthroughPolicy(value string) []string {
+    var policy []string
+    for _, entry := range strings.Split(value, ",") {
+        if trimmed := strings.TrimSpace(entry); trimmed != "" {
+            policy = append(policy, trimmed)
+        }
+    }
+    return policy
+}
+
+func getLabelPassthroughPolicy() []string {
+    return parsePassthroughPolicy(Setting("PASSTHROUGH_LABELS"))
+}
+
+func getAnnotationPassthroughPolicy() []string {
+    return parsePassthroughPolicy(Setting("PASSTHROUGH_ANNOTATIONS"))
+}
+
+func passthroughKeyAllowed(key string, policy []string) bool {
+    for _, entry := range policy {
+        if strings.HasSuffix(entry, "*") {
+            if strings.HasPrefix(key, strings.TrimSuffix(entry, "*")) {
+                return true
+            }
+        } else if key == entry {
+            return true
+        }
+    }
+    return false
+}
+
+// Propagate operator-selected Session metadata (cost-center, event-id, ...):
+// copy the allowed labels and annotations of source onto target. Keys already
+// set on target are kept, so provisioner-owned metadata can't be overridden.
+func applyPassthroughMetadata(target, source *unstructured.Unstructured) {
+    if target == nil || source == nil {
+        return
+    }
+
+    if policy := getLabelPassthroughPolicy(); len(policy) > 0 {
+        target.SetLabels(mergePassthrough(target.GetLabels(), source.GetLabels(), policy))
+    }
+
+    if policy := getAnnotationPassthroughPolicy(); len(policy) > 0 {
+        target.SetAnnotations(mergePassthrough(target.GetAnnotations(), source.GetAnnotations(), policy))
+    }
+}
+
+func mergePassthrough(current, source map[string]string, policy []string) map[string]string {
+    if current == nil {
+        current = make(map[string]string)
+    }
+    for key, value := range source {
+        if _, exists := current[key]; exists {
+            continue
+        }
+        if passthroughKeyAllowed(key, policy) {
+            current[key] = value
+        }
+    }
+    return current
+}
+
+// The allowed labels and annotations of source as EC2 tags, for billing tools
+// that read AWS tags rather than Kubernetes metadata. Labels win over
+// annotations of the same key. AWS refuses keys over 128 characters, values
+// over 256 and the aws: prefix, so those are left out.
+func passthroughTags(source *unstructured.Unstructured) map[string]interface{} {
+    tags := map[string]interface{}{}
+    if source == nil {
+        return tags
+    }
+    allowed := mergePassthrough(nil, source.GetLabels(), getLabelPassthroughPolicy())
+    allowed = mergePassthrough(allowed, source.GetAnnotations(), getAnnotationPassthroughPolicy())
+    for key, value := range allowed {
+        if len(key) > 128 || len(value) > 256 || strings.HasPrefix(strings.ToLower(key), "aws:") {
+            continue
+        }
+        tags[key] = value
+    }
+    return tags
+}
@@ -0,0 +1,42 @@
+// internal/metadata_passthrough_test.go - Passthrough Session metadata tagged on cloud instances for billing
+package internal
+
+import (
+    "strings"
+    "testing"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func TestPassthroughMetadataTaggedOnCloudInstance(t *testing.T) {
+    t.Setenv("PASSTHROUGH_LABELS", "cost-center,billing.example.com/*")
+    t.Setenv("PASSTHROUGH_ANNOTATIONS", "event-id")
+    session := &unstructured.Unstructured{}
+    labels := map[string]string{
+        "cost-center":                 "cc-42",
+        "billing.example.com/project": "k8s-101",
+        "team":                        "not passed through",
+    }
+    labels["billing.example.com/"+strings.Repeat("x", 120)] = "key too long for AWS"
+    session.SetLabels(labels)
+    session.SetAnnotations(map[string]string{"event-id": "kubecon-eu", "cost-center": "from an annotation"})
+
+    instance := buildCloudInstance(cloudInstanceSpec{Name: "req-1", User: "alice", Session: "session-1", Source: session})
+    tags, _, _ := unstructured.NestedStringMap(instance.Object, "spec", "tags")
+    want := map[string]string{
+        "cost-center":                 "cc-42",
+        "billing.example.com/project": "k8s-101",
+        "event-id":                    "kubecon-eu",
+    }
+    if len(tags) != len(want) {
+        t.Fatalf("instance tagged %v, want %v", tags, want)
+    }
+    for key, value := range want {
+        if tags[key] != value {
+            t.Fatalf("tag %s is %q, want %q", key, tags[key], value)
+        }
+    }
+    if instance.GetLabels()["cost-center"] != "cc-42" {
+        t.Fatalf("claim labels %v miss the passthrough label", instance.GetLabels())
+    }
+}
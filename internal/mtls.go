@@ -0,0 +1,252 @@
+// internal/mtls.go - Optional mutual TLS for the provisioner's HTTPS server, with certificate reload
+package internal
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "path/filepath"
+    "strings"
+    "sync"
+    "time"
+)
+
+// CA bundle client certificates must chain to; empty leaves clients unauthenticated
+func getMTLSClientCAFile() string {
+    return Setting("MTLS_CLIENT_CA_FILE")
+}
+
+func mtlsEnabled() bool {
+    return getMTLSClientCAFile() != ""
+}
+
+// SANs a client certificate must carry one of: DNS names, "*.<domain>" for one
+// label under a domain, IP addresses or URIs such as SPIFFE IDs. Empty accepts
+// any certificate the CA signed.
+func getMTLSAllowedSANs() []string {
+    return splitEnvList("MTLS_ALLOWED_SANS")
+}
+
+// Paths served without a client certificate: the API server's admission calls,
+// kubelet probes and the token-authenticated VM callback by default
+func getMTLSExemptPaths() []string {
+    if _, set := lookupSetting("MTLS_EXEMPT_PATHS"); set {
+        return splitEnvList("MTLS_EXEMPT_PATHS")
+    }
+    return []string{"/mutate", "/health", "/callback"}
+}
+
+// How often handshakes check the certificate files for changes
+const mtlsReloadInterval = 10 * time.Second
+
+// Serving certificate and client CA pool, reloaded when cert-manager or an
+// operator replaces the files so rotation needs no restart
+type certReloader struct {
+    certFile, keyFile, caFile string
+
+    mu          sync.Mutex
+    checkedAt   time.Time
+    certModTime time.Time
+    caModTime   time.Time
+    cert        *tls.Certificate
+    clientCAs   *x509.CertPool
+}
+
+func newCertReloader(certDir, caFile string) (*certReloader, error) {
+    reloader := &certReloader{
+        certFile: filepath.Join(certDir, "tls.crt"),
+        keyFile:  filepath.Join(certDir, "tls.key"),
+        caFile:   caFile,
+    }
+    if err := reloader.reload(true); err != nil {
+        return nil, err
+    }
+    return reloader, nil
+}
+
+// Load whichever file changed since the last load. A file that fails to load
+// keeps the previous certificate or pool, so a half-written update isn't fatal.
+func (cr *certReloader) reload(initial bool) error {
+    if info, err := os.Stat(cr.certFile); err == nil && info.ModTime() != cr.certModTime {
+        pair, err := tls.LoadX509KeyPair(cr.certFile, cr.keyFile)
+        if err != nil {
+            return fmt.Errorf("loading %s: %v", cr.certFile, err)
+        }
+        cr.cert = &pair
+        cr.certModTime = info.ModTime()
+        if !initial {
+            log.Printf("🔐 Reloaded serving certificate from %s", cr.certFile)
+        }
+    } else if err != nil && cr.cert == nil {
+        return err
+    }
+
+    if cr.caFile == "" {
+        return nil
+    }
+    if info, err := os.Stat(cr.caFile); err == nil && info.ModTime() != cr.caModTime {
+        pool, err := loadCertPool(cr.caFile)
+        if err != nil {
+            return err
+        }
+        cr.clientCAs = pool
+        cr.caModTime = info.ModTime()
+        if !initial {
+            log.Printf("🔐 Reloaded client CA from %s", cr.caFile)
+        }
+    } else if err != nil && cr.clientCAs == nil {
+        return err
+    }
+    return nil
+}
+
+func loadCertPool(file string) (*x509.CertPool, error) {
+    data, err := os.ReadFile(file)
+    if err != nil {
+        return nil, err
+    }
+    pool := x509.NewCertPool()
+    if !pool.AppendCertsFromPEM(data) {
+        return nil, fmt.Errorf("no PEM certificates in %s", file)
+    }
+    return pool, nil
+}
+
+// Current certificate and CA pool, checking the files every mtlsReloadInterval
+func (cr *certReloader) current() (*tls.Certificate, *x509.CertPool) {
+    cr.mu.Lock()
+    defer cr.mu.Unlock()
+    if time.Since(cr.checkedAt) >= mtlsReloadInterval {
+        cr.checkedAt = time.Now()
+        if err := cr.reload(false); err != nil {
+            log.Printf("⚠️ Keeping the previous certificates: %v", err)
+        }
+    }
+    return cr.cert, cr.clientCAs
+}
+
+// TLS settings of the server. With mTLS, client certificates are verified when
+// presented; requireClientCertificate rejects requests without one.
+func (cr *certReloader) tlsConfig() *tls.Config {
+    config := &tls.Config{
+        MinVersion: tls.VersionTLS12,
+        GetCertificate: func(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+            cert, _ := cr.current()
+            return cert, nil
+        },
+    }
+    if cr.caFile != "" {
+        config.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+            cert, clientCAs := cr.current()
+            return &tls.Config{
+                MinVersion:   tls.VersionTLS12,
+                Certificates: []tls.Certificate{*cert},
+                ClientCAs:    clientCAs,
+                ClientAuth:   tls.VerifyClientCertIfGiven,
+            }, nil
+        }
+    }
+    return config
+}
+
+// Whether the certificate carries one of the allowed SANs
+func clientSANAllowed(cert *x509.Certificate, allowed []string) bool {
+    if len(allowed) == 0 {
+        return true
+    }
+    for _, san := range allowed {
+        if ip := net.ParseIP(san); ip != nil {
+            for _, certIP := range cert.IPAddresses {
+                if certIP.Equal(ip) {
+                    return true
+                }
+            }
+            continue
+        }
+        if strings.Contains(san, "://") {
+            for _, uri := range cert.URIs {
+                if uri.String() == san {
+                    return true
+                }
+            }
+            continue
+        }
+        for _, name := range cert.DNSNames {
+            if dnsSANMatches(strings.ToLower(name), strings.ToLower(san)) {
+                return true
+            }
+        }
+    }
+    return false
+}
+
+// "*.lab.example.com" matches exactly one label under lab.example.com
+func dnsSANMatches(name, pattern string) bool {
+    if !strings.HasPrefix(pattern, "*.") {
+        return name == pattern
+    }
+    label, domain, found := strings.Cut(name, ".")
+    return found && label != "" && "."+domain == pattern[1:]
+}
+
+func mtlsPathExempt(path string, exempt []string) bool {
+    for _, exemptPath := range exempt {
+        if path == exemptPath {
+            return true
+        }
+    }
+    return false
+}
+
+// Answer 401 without a verified client certificate and 403 when none of its
+// SANs is allowed, except on the exempt paths
+func requireClientCertificate(next http.Handler) http.Handler {
+    allowed := getMTLSAllowedSANs()
+    exempt := getMTLSExemptPaths()
+    return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+        if mtlsPathExempt(r.URL.Path, exempt) {
+            next.ServeHTTP(w, r)
+            return
+        }
+        if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 {
+            log.Printf("🔒 Refused %s %s from %s: no client certificate", r.Method, r.URL.Path, r.RemoteAddr)
+            http.Error(w, "client certificate required", http.StatusUnauthorized)
+            return
+        }
+        if cert := r.TLS.VerifiedChains[0][0]; !clientSANAllowed(cert, allowed) {
+            log.Printf("🔒 Refused %s %s from %s: certificate %s carries no allowed SAN", r.Method, r.URL.Path, r.RemoteAddr, cert.Subject.CommonName)
+            http.Error(w, "client certificate not allowed", http.StatusForbidden)
+            return
+        }
+        next.ServeHTTP(w, r)
+    })
+}
+
+// The client CA loads and mTLS has a serving certificate to run on
+func validateMTLS(check *ConfigCheck) {
+    if !mtlsEnabled() {
+        return
+    }
+    if Setting("ENABLE_WEBHOOK") != "true" {
+        check.warn("MTLS_CLIENT_CA_FILE set but ENABLE_WEBHOOK is not true, nothing is served")
+        return
+    }
+    if getWebhookCertDir() == "" {
+        check.fail("MTLS_CLIENT_CA_FILE needs WEBHOOK_CERT_DIR, client certificates are only verified over TLS")
+    }
+    if _, err := loadCertPool(getMTLSClientCAFile()); err != nil {
+        check.fail("MTLS_CLIENT_CA_FILE: %v", err)
+    }
+    if len(getMTLSAllowedSANs()) == 0 {
+        check.warn("MTLS_ALLOWED_SANS not set, any certificate signed by the client CA is accepted")
+    }
+    for _, path := range getMTLSExemptPaths() {
+        if !strings.HasPrefix(path, "/") {
+            check.fail("MTLS_EXEMPT_PATHS: %q is not a path", path)
+        }
+    }
+}
@@ -0,0 +1,277 @@
+// internal/netbox_sync.go - Follow the static pools from NetBox, the lab inventory's source of truth
+package internal
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "net/url"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/client-go/dynamic"
+)
+
+// NetBox custom fields read from each VM or device
+const (
+    netboxSSHUserField     = "ssh_username"
+    netboxEnvironmentField = "hobbyfarm_environment"
+)
+
+// A lab VM as NetBox describes it
+type netboxHost struct {
+    Name        string `json:"name"`
+    IP          string `json:"ip"`
+    SSHUser     string `json:"sshUser,omitempty"`
+    Site        string `json:"site,omitempty"`
+    Environment string `json:"environment"`
+}
+
+func getNetBoxURL() string {
+    return strings.TrimSuffix(Setting("NETBOX_URL"), "/")
+}
+
+func netboxEnabled() bool {
+    return getNetBoxURL() != ""
+}
+
+// virtual-machines (default) or devices
+func getNetBoxObjectsPath() string {
+    if Setting("NETBOX_OBJECTS") == "devices" {
+        return "/api/dcim/devices/"
+    }
+    return "/api/virtualization/virtual-machines/"
+}
+
+// Only active objects with the configured role and tags belong to the pools
+func getNetBoxQuery() url.Values {
+    query := url.Values{"status": {"active"}, "limit": {"250"}}
+    if role := Setting("NETBOX_ROLE"); role != "" {
+        query.Set("role", role)
+    }
+    for _, tag := range splitEnvList("NETBOX_TAG") {
+        query.Add("tag", tag)
+    }
+    return query
+}
+
+// Environment of hosts without the hobbyfarm_environment custom field
+func getNetBoxEnvironment() string {
+    if name := Setting("NETBOX_ENVIRONMENT"); name != "" {
+        return name
+    }
+    return defaultEnvironmentName
+}
+
+func getNetBoxSyncInterval() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("NETBOX_SYNC_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 10 * time.Minute
+}
+
+// Last successful sync, keyed by IP. A failed sync keeps the previous inventory so
+// a NetBox outage never empties the pools.
+var netboxInventory = struct {
+    sync.RWMutex
+    hosts    map[string]netboxHost
+    syncedAt time.Time
+}{hosts: map[string]netboxHost{}}
+
+func netboxHostForIP(ip string) (netboxHost, bool) {
+    netboxInventory.RLock()
+    defer netboxInventory.RUnlock()
+    host, found := netboxInventory.hosts[ip]
+    return host, found
+}
+
+// Synced hosts of an environment, merged into its pool by loadVMEnvironments
+func netboxStaticVMs(environment string) []string {
+    netboxInventory.RLock()
+    defer netboxInventory.RUnlock()
+
+    var ips []string
+    for ip, host := range netboxInventory.hosts {
+        if host.Environment == environment {
+            ips = append(ips, ip)
+        }
+    }
+    sort.Strings(ips)
+    return ips
+}
+
+type netboxPage struct {
+    Next    string `json:"next"`
+    Results []struct {
+        Name       string `json:"name"`
+        PrimaryIP4 *struct {
+            Address string `json:"address"`
+        } `json:"primary_ip4"`
+        Site *struct {
+            Slug string `json:"slug"`
+        } `json:"site"`
+        CustomFields map[string]interface{} `json:"custom_fields"`
+    } `json:"results"`
+}
+
+func netboxGet(pageURL string) (*netboxPage, error) {
+    req, err := http.NewRequest(http.MethodGet, pageURL, nil)
+    if err != nil {
+        return nil, err
+    }
+    req.Header.Set("Accept", "application/json")
+    if token := Setting("NETBOX_TOKEN"); token != "" {
+        req.Header.Set("Authorization", "Token "+token)
+    }
+
+    resp, err := outboundClient(30 * time.Second).Do(req)
+    if err != nil {
+        return nil, err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return nil, fmt.Errorf("NetBox answered %s", resp.Status)
+    }
+
+    var page netboxPage
+    if err := json.NewDecoder(resp.Body).Decode(&page); err != nil {
+        return nil, fmt.Errorf("unreadable NetBox response: %v", err)
+    }
+    return &page, nil
+}
+
+// Every matching host with a primary IPv4, across all result pages
+func fetchNetBoxHosts() ([]netboxHost, error) {
+    var hosts []netboxHost
+    pageURL := getNetBoxURL() + getNetBoxObjectsPath() + "?" + getNetBoxQuery().Encode()
+    for pageURL != "" {
+        page, err := netboxGet(pageURL)
+        if err != nil {
+            return nil, err
+        }
+        for _, result := range page.Results {
+            if result.PrimaryIP4 == nil {
+                log.Printf("⚠️ NetBox host %s has no primary IPv4, skipped", result.Name)
+                continue
+            }
+            ip, _, err := net.ParseCIDR(result.PrimaryIP4.Address)
+            if err != nil {
+                continue
+            }
+
+            host := netboxHost{Name: result.Name, IP: ip.String(), Environment: getNetBoxEnvironment()}
+            if result.Site != nil {
+                host.Site = result.Site.Slug
+            }
+            if user, ok := result.CustomFields[netboxSSHUserField].(string); ok {
+                host.SSHUser = user
+            }
+            if environment, ok := result.CustomFields[netboxEnvironmentField].(string); ok && environment != "" {
+                host.Environment = environment
+            }
+            hosts = append(hosts, host)
+        }
+        pageURL = page.Next
+    }
+    return hosts, nil
+}
+
+type NetBoxSync struct {
+    client dynamic.Interface
+}
+
+func NewNetBoxSync(client dynamic.Interface) *NetBoxSync {
+    return &NetBoxSync{client: client}
+}
+
+func (ns *NetBoxSync) WatchNetBoxInventory() {
+    log.Printf("📇 Starting NetBox inventory sync from %s every %v", getNetBoxURL(), getNetBoxSyncInterval())
+
+    for {
+        ns.sync()
+        Heartbeat(ns.client, HeartbeatNetBoxSync)
+        time.Sleep(getNetBoxSyncInterval())
+    }
+}
+
+func (ns *NetBoxSync) sync() {
+    hosts, err := fetchNetBoxHosts()
+    if err != nil {
+        log.Printf("❌ NetBox sync failed, keeping the previous inventory: %v", err)
+        recordLastError(HeartbeatNetBoxSync, "fetching hosts: %v", err)
+        return
+    }
+
+    configured := loadVMEnvironments()
+    synced := make(map[string]netboxHost, len(hosts))
+    for _, host := range hosts {
+        if _, exists := configured[host.Environment]; !exists {
+            log.Printf("⚠️ NetBox host %s (%s) names unknown environment %s, skipped", host.Name, host.IP, host.Environment)
+            continue
+        }
+        synced[host.IP] = host
+    }
+
+    netboxInventory.Lock()
+    previous := netboxInventory.hosts
+    netboxInventory.hosts = synced
+    netboxInventory.syncedAt = time.Now()
+    netboxInventory.Unlock()
+
+    for ip, host := range synced {
+        if _, known := previous[ip]; !known {
+            log.Printf("📇 NetBox added %s (%s, site %s) to the %s pool", ip, host.Name, host.Site, host.Environment)
+        }
+    }
+    for ip, host := range previous {
+        if _, kept := synced[ip]; !kept {
+            log.Printf("📇 NetBox removed %s (%s) from the %s pool", ip, host.Name, host.Environment)
+        }
+    }
+}
+
+// NetBox site of the static VM allocated to a request, kept on its status
+func (kc *KratixController) recordStaticVMSite(requestName, vmIP string) {
+    host, found := netboxHostForIP(vmIP)
+    if !found || host.Site == "" {
+        return
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{"site": host.Site},
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record site of %s: %v", requestName, err)
+    }
+}
+
+// GET /netbox shows what the last sync brought into the pools
+func (ws *WebhookServer) netboxHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    netboxInventory.RLock()
+    hosts := make([]netboxHost, 0, len(netboxInventory.hosts))
+    for _, host := range netboxInventory.hosts {
+        hosts = append(hosts, host)
+    }
+    syncedAt := netboxInventory.syncedAt
+    netboxInventory.RUnlock()
+    sort.Slice(hosts, func(i, j int) bool { return hosts[i].IP < hosts[j].IP })
+
+    response := map[string]interface{}{
+        "enabled": netboxEnabled(),
+        "hosts":   hosts,
+    }
+    if !syncedAt.IsZero() {
+        response["syncedAt"] = syncedAt.Format(time.RFC3339)
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(response)
+}
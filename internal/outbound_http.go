@@ -0,0 +1,198 @@
+// internal/outbound_http.go - One proxy and CA configuration for every call the provisioner makes outside the cluster
+package internal
+
+import (
+    "crypto/tls"
+    "crypto/x509"
+    "fmt"
+    "log"
+    "net/http"
+    "net/url"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "sync"
+    "time"
+
+    "golang.org/x/net/http/httpproxy"
+)
+
+// Where the system trust store is on the distributions the image is built from
+var systemCABundles = []string{
+    "/etc/ssl/certs/ca-certificates.crt", // Debian, Ubuntu, Alpine
+    "/etc/pki/tls/certs/ca-bundle.crt",   // RHEL, Fedora
+}
+
+// PEM certificates trusted on top of the system roots, for TLS-intercepting proxies
+// and private endpoints such as MinIO or NetBox; empty trusts the system roots alone
+func getOutboundCABundleFile() string {
+    return Setting("OUTBOUND_CA_BUNDLE_FILE")
+}
+
+// Proxies of outbound calls: OUTBOUND_HTTP_PROXY, OUTBOUND_HTTPS_PROXY and
+// OUTBOUND_NO_PROXY, each falling back to the usual HTTP_PROXY variables.
+// These are the provisioner's own, PROVISIONING_*_PROXY configure the VMs, and
+// Crossplane's providers take theirs from their DeploymentRuntimeConfig.
+func getOutboundProxy() *httpproxy.Config {
+    proxy := httpproxy.FromEnvironment()
+    if value := Setting("OUTBOUND_HTTP_PROXY"); value != "" {
+        proxy.HTTPProxy = value
+    }
+    if value := Setting("OUTBOUND_HTTPS_PROXY"); value != "" {
+        proxy.HTTPSProxy = value
+    }
+    if value := Setting("OUTBOUND_NO_PROXY"); value != "" {
+        proxy.NoProxy = value
+    }
+    return proxy
+}
+
+// Built on first use; the CA bundle is read once per process
+var outbound = struct {
+    sync.Mutex
+    transport *http.Transport
+    tls       *tls.Config
+    // System roots and the custom bundle in one file, for CLIs whose CA setting
+    // replaces their trust store rather than adding to it
+    bundlePath string
+}{}
+
+// The TLS configuration of outbound connections: system roots plus OUTBOUND_CA_BUNDLE_FILE
+func outboundTLSConfig() *tls.Config {
+    outbound.Lock()
+    defer outbound.Unlock()
+    if outbound.tls == nil {
+        outbound.tls = &tls.Config{MinVersion: tls.VersionTLS12}
+        if roots, err := outboundRootCAs(); err != nil {
+            log.Printf("⚠️ Outbound calls trust the system roots only: %v", err)
+        } else {
+            outbound.tls.RootCAs = roots
+        }
+    }
+    return outbound.tls.Clone()
+}
+
+func outboundRootCAs() (*x509.CertPool, error) {
+    bundle := getOutboundCABundleFile()
+    if bundle == "" {
+        return nil, nil
+    }
+    pem, err := os.ReadFile(bundle)
+    if err != nil {
+        return nil, err
+    }
+    roots, err := x509.SystemCertPool()
+    if err != nil {
+        roots = x509.NewCertPool()
+    }
+    if !roots.AppendCertsFromPEM(pem) {
+        return nil, fmt.Errorf("no PEM certificates in %s", bundle)
+    }
+    return roots, nil
+}
+
+// An HTTP client of outbound calls through the configured proxy and CAs; timeout 0 has none
+func outboundClient(timeout time.Duration) *http.Client {
+    outbound.Lock()
+    transport := outbound.transport
+    outbound.Unlock()
+    if transport == nil {
+        proxy := getOutboundProxy().ProxyFunc()
+        transport = http.DefaultTransport.(*http.Transport).Clone()
+        transport.Proxy = func(req *http.Request) (*url.URL, error) { return proxy(req.URL) }
+        transport.TLSClientConfig = outboundTLSConfig()
+        outbound.Lock()
+        outbound.transport = transport
+        outbound.Unlock()
+    }
+    return &http.Client{Timeout: timeout, Transport: transport}
+}
+
+// Environment of CLIs making outbound calls, the aws CLI, oras and pip: the same
+// proxies, and a CA file holding the system roots and the custom bundle
+func outboundEnv() []string {
+    proxy := getOutboundProxy()
+    var env []string
+    for _, variable := range []struct {
+        names []string
+        value string
+    }{
+        {[]string{"HTTP_PROXY", "http_proxy"}, proxy.HTTPProxy},
+        {[]string{"HTTPS_PROXY", "https_proxy"}, proxy.HTTPSProxy},
+        {[]string{"NO_PROXY", "no_proxy"}, proxy.NoProxy},
+    } {
+        if variable.value == "" {
+            continue
+        }
+        for _, name := range variable.names {
+            env = append(env, name+"="+variable.value)
+        }
+    }
+    if bundle, err := outboundCABundlePath(); err != nil {
+        log.Printf("⚠️ CLIs trust the system roots only: %v", err)
+    } else if bundle != "" {
+        for _, name := range []string{"AWS_CA_BUNDLE", "SSL_CERT_FILE", "REQUESTS_CA_BUNDLE", "PIP_CERT"} {
+            env = append(env, name+"="+bundle)
+        }
+    }
+    return env
+}
+
+// Write the combined CA file once; empty without OUTBOUND_CA_BUNDLE_FILE
+func outboundCABundlePath() (string, error) {
+    custom := getOutboundCABundleFile()
+    if custom == "" {
+        return "", nil
+    }
+    outbound.Lock()
+    defer outbound.Unlock()
+    if outbound.bundlePath != "" {
+        return outbound.bundlePath, nil
+    }
+
+    var combined []byte
+    for _, path := range systemCABundles {
+        if system, err := os.ReadFile(path); err == nil {
+            combined = append(combined, system...)
+            combined = append(combined, '\n')
+            break
+        }
+    }
+    extra, err := os.ReadFile(custom)
+    if err != nil {
+        return "", err
+    }
+    path := filepath.Join(os.TempDir(), "hobbyfarm-provisioner-ca.pem")
+    if err := os.WriteFile(path, append(combined, extra...), 0644); err != nil {
+        return "", err
+    }
+    outbound.bundlePath = path
+    return path, nil
+}
+
+// A command making outbound calls, run with outboundEnv added to the controller's environment
+func outboundCommand(name string, args ...string) *exec.Cmd {
+    cmd := exec.Command(name, args...)
+    cmd.Env = append(os.Environ(), outboundEnv()...)
+    return cmd
+}
+
+// The CA bundle holds certificates and the proxies are URLs
+func validateOutbound(check *ConfigCheck) {
+    if bundle := getOutboundCABundleFile(); bundle != "" {
+        if _, err := outboundRootCAs(); err != nil {
+            check.fail("OUTBOUND_CA_BUNDLE_FILE: %v", err)
+        }
+    }
+    for name, value := range map[string]string{
+        "OUTBOUND_HTTP_PROXY":  Setting("OUTBOUND_HTTP_PROXY"),
+        "OUTBOUND_HTTPS_PROXY": Setting("OUTBOUND_HTTPS_PROXY"),
+    } {
+        if value == "" {
+            continue
+        }
+        if proxy, err := url.Parse(value); err != nil || proxy.Host == "" {
+            check.fail("%s %q is not a proxy URL such as http://proxy.example.com:3128", name, value)
+        }
+    }
+}
@@ -0,0 +1,212 @@
+// internal/overlay_network.go - WireGuard/Tailscale overlay connectivity for NATed lab VMs
+package internal
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "os"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+const (
+    overlayModeDirect    = "direct"
+    overlayModeTailscale = "tailscale"
+    overlayModeWireGuard = "wireguard"
+)
+
+// Resolve the connectivity mode of a request; spec.connectivity.mode wins over OVERLAY_MODE
+func getOverlayMode(request *unstructured.Unstructured) string {
+    mode, _, _ := unstructured.NestedString(request.Object, "spec", "connectivity", "mode")
+    if mode == "" {
+        mode = Setting("OVERLAY_MODE")
+    }
+
+    switch mode {
+    case overlayModeTailscale, overlayModeWireGuard:
+        return mode
+    case "", overlayModeDirect:
+        return overlayModeDirect
+    default:
+        log.Printf("⚠️ Unknown connectivity mode %s for %s, using direct", mode, request.GetName())
+        return overlayModeDirect
+    }
+}
+
+func getOverlaySecretName() string {
+    if name := Setting("OVERLAY_SECRET_NAME"); name != "" {
+        return name
+    }
+    return "hobbyfarm-overlay-auth"
+}
+
+// Address the provisioner should use to reach the VM: the overlay IP once known,
+// a pre-assigned Tailscale IP for VMs that joined the mesh on their own, else the allocated IP.
+// WireGuard VMs must be reachable on their allocated IP until the tunnel is configured.
+func getRequestAccessIP(request *unstructured.Unstructured) string {
+    if overlayIP, _, _ := unstructured.NestedString(request.Object, "status", "overlayIP"); overlayIP != "" {
+        return overlayIP
+    }
+    if getOverlayMode(request) == overlayModeTailscale {
+        if overlayIP, _, _ := unstructured.NestedString(request.Object, "spec", "connectivity", "overlayIP"); overlayIP != "" {
+            return overlayIP
+        }
+    }
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    return vmIP
+}
+
+// Load overlay credentials (Tailscale auth key or WireGuard peer config) from the overlay Secret
+func loadOverlayCredentials(client dynamic.Interface) (map[string]string, error) {
+    secretName := getOverlaySecretName()
+    secret, err := client.Resource(secretGVR).Namespace("default").Get(context.TODO(), secretName, metav1.GetOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to get overlay secret %s: %v", secretName, err)
+    }
+
+    data, _, _ := unstructured.NestedStringMap(secret.Object, "data")
+    credentials := make(map[string]string, len(data))
+    for key, encoded := range data {
+        decoded, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil {
+            return nil, fmt.Errorf("overlay secret %s key %s is not valid base64: %v", secretName, key, err)
+        }
+        credentials[key] = strings.TrimSpace(string(decoded))
+    }
+
+    return credentials, nil
+}
+
+// Make sure the VM is on the overlay and return the address to use from now on
+func (kc *KratixController) ensureOverlayConnectivity(requestName, vmIP, session string, request *unstructured.Unstructured) (string, error) {
+    mode := getOverlayMode(request)
+    if mode == overlayModeDirect {
+        return vmIP, nil
+    }
+
+    // Already joined in an earlier cycle
+    if overlayIP, _, _ := unstructured.NestedString(request.Object, "status", "overlayIP"); overlayIP != "" {
+        return overlayIP, nil
+    }
+
+    // VM joined the mesh on its own (e.g. cloud-init), just record the address
+    if overlayIP, _, _ := unstructured.NestedString(request.Object, "spec", "connectivity", "overlayIP"); overlayIP != "" && mode == overlayModeTailscale {
+        return overlayIP, kc.setOverlayIP(requestName, overlayIP)
+    }
+
+    credentials, err := loadOverlayCredentials(kc.client)
+    if err != nil {
+        return "", err
+    }
+
+    log.Printf("🔐 Joining VM %s to %s overlay for request %s", vmIP, mode, requestName)
+    overlayIP, err := kc.prober.JoinOverlay(vmIP, session, mode, credentials, request)
+    if err != nil {
+        return "", fmt.Errorf("failed to join %s overlay: %v", mode, err)
+    }
+
+    if err := kc.setOverlayIP(requestName, overlayIP); err != nil {
+        return "", fmt.Errorf("failed to record overlay IP: %v", err)
+    }
+
+    log.Printf("✅ VM %s reachable via %s overlay at %s", vmIP, mode, overlayIP)
+    return overlayIP, nil
+}
+
+func (kc *KratixController) setOverlayIP(requestName, overlayIP string) error {
+    patch := map[string]interface{}{
+        "status": map[string]interface{}{
+            "overlayIP": overlayIP,
+        },
+    }
+
+    patchBytes, err := json.Marshal(patch)
+    if err != nil {
+        return err
+    }
+
+    err = patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+    return err
+}
+
+// Run overlay-join.yaml against the VM and return its overlay address
+func (ar *AnsibleRunner) JoinOverlay(vmIP, sessionName, mode string, credentials map[string]string, request *unstructured.Unstructured) (string, error) {
+    sshUser, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return "", fmt.Errorf("failed to detect SSH user: %v", err)
+    }
+
+    platform, err := ar.detectVMPlatform(vmIP, sshUser)
+    if err != nil {
+        return "", err
+    }
+
+    config := &ProvisioningConfig{
+        Variables: map[string]string{
+            "overlay_mode": mode,
+        },
+        Platform: platform,
+    }
+
+    switch mode {
+    case overlayModeTailscale:
+        authKey := credentials["authkey"]
+        if authKey == "" {
+            return "", fmt.Errorf("overlay secret %s has no authkey", getOverlaySecretName())
+        }
+        config.Variables["tailscale_authkey"] = authKey
+        config.Variables["tailscale_hostname"] = sessionName
+    case overlayModeWireGuard:
+        // WireGuard has no address assignment, the request must carry the VM's overlay IP
+        overlayIP, _, _ := unstructured.NestedString(request.Object, "spec", "connectivity", "overlayIP")
+        if overlayIP == "" || net.ParseIP(overlayIP) == nil {
+            return "", fmt.Errorf("wireguard mode requires a valid spec.connectivity.overlayIP")
+        }
+        for _, key := range []string{"private_key", "peer_public_key", "peer_endpoint", "allowed_ips"} {
+            if credentials[key] == "" {
+                return "", fmt.Errorf("overlay secret %s has no %s", getOverlaySecretName(), key)
+            }
+            config.Variables["wireguard_"+key] = credentials[key]
+        }
+        config.Variables["wireguard_address"] = overlayIP
+    }
+
+    inventoryContent := ar.buildInventory(vmIP, sshUser, sessionName, config)
+    tmpInventory := fmt.Sprintf("/tmp/overlay_inventory_%s", sessionName)
+    if err := os.WriteFile(tmpInventory, []byte(inventoryContent), 0600); err != nil {
+        return "", fmt.Errorf("failed to write inventory: %v", err)
+    }
+    defer os.Remove(tmpInventory)
+
+    err = withVMLock(ar.client, vmIP, "overlay:"+sessionName, func() error {
+        return ar.runSinglePlaybook(tmpInventory, "overlay-join.yaml", sessionName, config)
+    })
+    if err != nil {
+        return "", err
+    }
+
+    if mode == overlayModeWireGuard {
+        return config.Variables["wireguard_address"], nil
+    }
+
+    // Ask tailscale for the address it was assigned
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, "tailscale", "ip", "-4")
+    if err != nil {
+        return "", fmt.Errorf("failed to read tailscale IP: %v", err)
+    }
+
+    overlayIP := strings.TrimSpace(strings.SplitN(string(output), "\n", 2)[0])
+    if net.ParseIP(overlayIP) == nil {
+        return "", fmt.Errorf("unexpected tailscale IP output: %q", string(output))
+    }
+
+    return overlayIP, nil
+}
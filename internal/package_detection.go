@@ -0,0 +1,168 @@
+// internal/package_detection.go - Which packages a scenario's VMs get, and a dry run of it over the whole catalog
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+const packagesAnnotation = provisioner.PackagesAnnotation
+
+// Rules a scenario's packages come from
+const (
+    packageRuleAnnotation = provisioner.PackageRuleAnnotation
+    // No rule matched: the VM only gets the playbooks' base packages
+    packageRuleDefault = provisioner.PackageRuleDefault
+)
+
+type packageDetection = provisioner.PackageDetection
+
+// Packages of a session or scenario: its annotation, else the first detection
+// strategy that matches
+func detectPackages(input DetectionInput) packageDetection {
+    return packageDetector().Detect(input)
+}
+
+// One scenario of a course as the detector sees it
+type PackageDetectionRow struct {
+    Course   string   `json:"course,omitempty"` // empty for scenarios in no course
+    Scenario string   `json:"scenario"`
+    Rule     string   `json:"rule"`
+    Packages []string `json:"packages"`
+    Reason   string   `json:"reason,omitempty"`
+}
+
+type PackageDetectionReport struct {
+    Rows []PackageDetectionRow `json:"rows"`
+    // Courses none of whose scenarios matched a rule
+    DefaultCourses []string `json:"defaultCourses"`
+    // Scenarios, in a course or not, that fell through to the default
+    DefaultScenarios []string `json:"defaultScenarios"`
+}
+
+// Run the detector over every Course and Scenario of the catalog namespaces
+// without provisioning anything, so rule authors see which scenarios would
+// fall through to the default packages
+func RunPackageDetectionSimulation(client dynamic.Interface) (*PackageDetectionReport, error) {
+    scenarios := map[string]*unstructured.Unstructured{}
+    var courses []unstructured.Unstructured
+    for _, ns := range catalogNamespaces() {
+        list, err := client.Resource(scenarioGVR).Namespace(ns).List(context.TODO(), metav1.ListOptions{})
+        if err != nil {
+            return nil, fmt.Errorf("listing Scenarios in %s: %v", ns, err)
+        }
+        for i := range list.Items {
+            // The first namespace wins, as in getScenario
+            if _, seen := scenarios[list.Items[i].GetName()]; !seen {
+                scenarios[list.Items[i].GetName()] = &list.Items[i]
+            }
+        }
+        courseList, err := client.Resource(courseGVR).Namespace(ns).List(context.TODO(), metav1.ListOptions{})
+        if err != nil {
+            return nil, fmt.Errorf("listing Courses in %s: %v", ns, err)
+        }
+        courses = append(courses, courseList.Items...)
+    }
+
+    report := &PackageDetectionReport{Rows: []PackageDetectionRow{}, DefaultCourses: []string{}, DefaultScenarios: []string{}}
+    inCourse := map[string]bool{}
+    defaultScenarios := map[string]bool{}
+    addRow := func(course, scenario string) bool {
+        detection := packageDetection{Rule: packageRuleDefault, Packages: []string{}, Reason: "scenario not found"}
+        if obj, found := scenarios[scenario]; found {
+            detection = detectPackages(DetectionInput{Scenario: scenario, Course: course, Annotations: obj.GetAnnotations()})
+        }
+        report.Rows = append(report.Rows, PackageDetectionRow{
+            Course:   course,
+            Scenario: scenario,
+            Rule:     detection.Rule,
+            Packages: detection.Packages,
+            Reason:   detection.Reason,
+        })
+        if detection.Rule == packageRuleDefault {
+            defaultScenarios[scenario] = true
+            return false
+        }
+        return true
+    }
+
+    for _, course := range courses {
+        courseScenarios, _, _ := unstructured.NestedStringSlice(course.Object, "spec", "scenarios")
+        matched := false
+        for _, scenario := range courseScenarios {
+            inCourse[scenario] = true
+            if addRow(course.GetName(), scenario) {
+                matched = true
+            }
+        }
+        if !matched {
+            report.DefaultCourses = append(report.DefaultCourses, course.GetName())
+        }
+    }
+
+    standalone := make([]string, 0, len(scenarios))
+    for name := range scenarios {
+        if !inCourse[name] {
+            standalone = append(standalone, name)
+        }
+    }
+    sort.Strings(standalone)
+    for _, scenario := range standalone {
+        addRow("", scenario)
+    }
+
+    for scenario := range defaultScenarios {
+        report.DefaultScenarios = append(report.DefaultScenarios, scenario)
+    }
+    sort.Strings(report.DefaultScenarios)
+    sort.Strings(report.DefaultCourses)
+
+    log.Printf("🔬 Package detection: %d courses, %d scenarios, %d scenarios on the default packages",
+        len(courses), len(scenarios), len(report.DefaultScenarios))
+    return report, nil
+}
+
+// The report as a course → rule → packages table, scenarios on the default marked with "!"
+func (report *PackageDetectionReport) Matrix() string {
+    var b strings.Builder
+    rows := [][]string{{"", "COURSE", "SCENARIO", "RULE", "PACKAGES"}}
+    for _, row := range report.Rows {
+        flag, course, packages := "", row.Course, strings.Join(row.Packages, ",")
+        if course == "" {
+            course = "-"
+        }
+        if row.Rule == packageRuleDefault {
+            flag, packages = "!", "("+row.Reason+")"
+        }
+        rows = append(rows, []string{flag, course, row.Scenario, row.Rule, packages})
+    }
+
+    widths := make([]int, len(rows[0]))
+    for _, row := range rows {
+        for i, cell := range row {
+            if len(cell) > widths[i] {
+                widths[i] = len(cell)
+            }
+        }
+    }
+    for _, row := range rows {
+        for i, cell := range row[:len(row)-1] {
+            fmt.Fprintf(&b, "%-*s  ", widths[i], cell)
+        }
+        b.WriteString(row[len(row)-1] + "\n")
+    }
+
+    if len(report.DefaultCourses) > 0 {
+        fmt.Fprintf(&b, "\nCourses entirely on the default packages: %s\n", strings.Join(report.DefaultCourses, ", "))
+    }
+    return b.String()
+}
@@ -0,0 +1,100 @@
+// internal/package_detectors.go - Detection strategies organizations plug into package detection, compiled in or behind an HTTP endpoint
+package internal
+
+import (
+    "fmt"
+    "log"
+    "net/url"
+    "sync"
+    "time"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// What a detection strategy is asked about, and the interface organizations
+// implement, from the public package
+type (
+    DetectionInput    = provisioner.DetectionInput
+    DetectionStrategy = provisioner.DetectionStrategy
+)
+
+// Strategies compiled into the provisioner, asked in registration order
+var detectionStrategies = struct {
+    sync.RWMutex
+    list []DetectionStrategy
+}{}
+
+// Add a strategy to package detection. It is meant for init functions of files
+// added to cmd/, so an organization's logic is built in without forking:
+//
+//    func init() { internal.RegisterDetectionStrategy(lmsDetector{}) }
+//
+// Scenarios with a packages annotation keep it; strategies decide for the
+// others, before the HTTP detector.
+func RegisterDetectionStrategy(strategy DetectionStrategy) {
+    detectionStrategies.Lock()
+    defer detectionStrategies.Unlock()
+    for _, registered := range detectionStrategies.list {
+        if registered.Name() == strategy.Name() {
+            panic(fmt.Sprintf("detection strategy %q registered twice", strategy.Name()))
+        }
+    }
+    detectionStrategies.list = append(detectionStrategies.list, strategy)
+}
+
+// The strategies asked after the annotation rule: the registered ones, then the
+// HTTP detector when PACKAGE_DETECTOR_URL is set
+func packageDetectionStrategies() []DetectionStrategy {
+    detectionStrategies.RLock()
+    strategies := append([]DetectionStrategy{}, detectionStrategies.list...)
+    detectionStrategies.RUnlock()
+    if endpoint := Setting("PACKAGE_DETECTOR_URL"); endpoint != "" {
+        // The token is a Secret, like NETBOX_TOKEN, so only ever an environment variable
+        strategies = append(strategies, provisioner.HTTPDetector{
+            URL:    endpoint,
+            Token:  Setting("PACKAGE_DETECTOR_TOKEN"),
+            Client: outboundClient(0),
+        })
+    }
+    return strategies
+}
+
+// How long one strategy may take before detection moves on without it
+func getPackageDetectorTimeout() time.Duration {
+    if d, err := time.ParseDuration(Setting("PACKAGE_DETECTOR_TIMEOUT")); err == nil && d > 0 {
+        return d
+    }
+    return 5 * time.Second
+}
+
+// The public detection engine with the configured strategies
+func packageDetector() provisioner.Detector {
+    return provisioner.Detector{
+        Strategies: packageDetectionStrategies(),
+        Timeout:    getPackageDetectorTimeout(),
+        OnError: func(strategy DetectionStrategy, input DetectionInput, err error) {
+            log.Printf("⚠️ Package detector %s failed for scenario %s: %v", strategy.Name(), input.Scenario, err)
+        },
+    }
+}
+
+func validatePackageDetectors(check *ConfigCheck) {
+    if endpoint := Setting("PACKAGE_DETECTOR_URL"); endpoint != "" {
+        parsed, err := url.Parse(endpoint)
+        switch {
+        case err != nil || parsed.Host == "":
+            check.fail("PACKAGE_DETECTOR_URL %q is not a URL", endpoint)
+        case parsed.Scheme == "http":
+            if Setting("PACKAGE_DETECTOR_TOKEN") != "" {
+                check.warn("PACKAGE_DETECTOR_TOKEN is sent over plain HTTP to %s", parsed.Host)
+            }
+        case parsed.Scheme != "https":
+            check.fail("PACKAGE_DETECTOR_URL %q is not http:// or https://", endpoint)
+        }
+    }
+    if value := Setting("PACKAGE_DETECTOR_TIMEOUT"); value != "" {
+        if d, err := time.ParseDuration(value); err != nil || d <= 0 {
+            check.fail("PACKAGE_DETECTOR_TIMEOUT %q is not a positive duration", value)
+        }
+    }
+}
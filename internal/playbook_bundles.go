@@ -0,0 +1,324 @@
+// internal/playbook_bundles.go - Versioned playbook tarballs an environment provisions from, verified by digest before they run
+package internal
+
+import (
+    "archive/tar"
+    "compress/gzip"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "net/http"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strings"
+    "time"
+)
+
+// A tarball of playbooks in S3 (s3://), an OCI registry (oci://, pulled with oras)
+// or on a web server, pinned by the SHA-256 of the tarball
+type playbookBundle struct {
+    URL     string `json:"url"`
+    SHA256  string `json:"sha256"`
+    Version string `json:"version,omitempty"`
+}
+
+// Bundles are extracted once per digest under this directory
+func getPlaybookBundleCache() string {
+    if dir := Setting("PLAYBOOK_BUNDLE_CACHE"); dir != "" {
+        return dir
+    }
+    return "/tmp/playbook-bundles"
+}
+
+// Prepend --endpoint-url for bundles in S3-compatible stores such as MinIO
+func bundleCLIArgs(args ...string) []string {
+    if endpoint := Setting("PLAYBOOK_BUNDLE_ENDPOINT"); endpoint != "" {
+        return append([]string{"--endpoint-url", endpoint}, args...)
+    }
+    return args
+}
+
+func (bundle playbookBundle) digest() string {
+    return strings.ToLower(strings.TrimPrefix(bundle.SHA256, "sha256:"))
+}
+
+// What the request status records about the bundle it was provisioned from
+func (bundle playbookBundle) status() map[string]interface{} {
+    return map[string]interface{}{
+        "url":     bundle.URL,
+        "version": bundle.Version,
+        "digest":  "sha256:" + bundle.digest(),
+    }
+}
+
+// Directory of the verified, extracted bundle. A tarball whose digest differs from
+// the pinned one is never extracted, so a swapped or corrupted bundle can't run.
+func fetchPlaybookBundle(bundle playbookBundle) (string, error) {
+    digest := bundle.digest()
+    if !isSHA256(digest) {
+        return "", fmt.Errorf("bundle %s has no valid sha256", bundle.URL)
+    }
+
+    dir := filepath.Join(getPlaybookBundleCache(), digest)
+    if _, err := os.Stat(dir); err == nil {
+        return dir, nil
+    }
+    if err := os.MkdirAll(getPlaybookBundleCache(), 0755); err != nil {
+        return "", err
+    }
+
+    tarball, err := downloadPlaybookBundle(bundle.URL)
+    if err != nil {
+        return "", fmt.Errorf("downloading bundle %s: %v", bundle.URL, err)
+    }
+    defer os.Remove(tarball)
+
+    actual, err := fileSHA256(tarball)
+    if err != nil {
+        return "", err
+    }
+    if actual != digest {
+        return "", fmt.Errorf("bundle %s has digest sha256:%s, expected sha256:%s", bundle.URL, actual, digest)
+    }
+
+    // Extract beside the cache entry and rename, so a half-extracted bundle is never used
+    tmpDir, err := os.MkdirTemp(getPlaybookBundleCache(), "extract-")
+    if err != nil {
+        return "", err
+    }
+    if err := extractTarball(tarball, tmpDir); err != nil {
+        os.RemoveAll(tmpDir)
+        return "", fmt.Errorf("extracting bundle %s: %v", bundle.URL, err)
+    }
+    if err := os.Rename(tmpDir, dir); err != nil {
+        os.RemoveAll(tmpDir)
+        // Another run extracted the same digest first
+        if _, statErr := os.Stat(dir); statErr == nil {
+            return dir, nil
+        }
+        return "", err
+    }
+
+    log.Printf("📦 Playbook bundle %s (%s) verified and extracted to %s", bundle.Version, bundle.URL, dir)
+    return dir, nil
+}
+
+// Fetch the tarball at url into a temporary file and return its path
+func downloadPlaybookBundle(url string) (string, error) {
+    file, err := os.CreateTemp(getPlaybookBundleCache(), "download-")
+    if err != nil {
+        return "", err
+    }
+    path := file.Name()
+    file.Close()
+
+    switch {
+    case strings.HasPrefix(url, "s3://"):
+        err = runBundleCommand(outboundCommand("aws", bundleCLIArgs("s3", "cp", "--only-show-errors", url, path)...))
+    case strings.HasPrefix(url, "oci://"):
+        err = pullOCIBundle(strings.TrimPrefix(url, "oci://"), path)
+    case strings.HasPrefix(url, "https://"), strings.HasPrefix(url, "http://"):
+        err = downloadHTTPBundle(url, path)
+    case strings.HasPrefix(url, "file://"):
+        err = copyFile(strings.TrimPrefix(url, "file://"), path)
+    default:
+        err = fmt.Errorf("unsupported bundle URL, expected s3://, oci://, https:// or file://")
+    }
+    if err != nil {
+        os.Remove(path)
+        return "", err
+    }
+    return path, nil
+}
+
+func runBundleCommand(cmd *exec.Cmd) error {
+    if output, err := cmd.CombinedOutput(); err != nil {
+        return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// The tarball is the single file of the OCI artifact, as pushed by "oras push"
+func pullOCIBundle(ref, path string) error {
+    pullDir, err := os.MkdirTemp(getPlaybookBundleCache(), "oci-")
+    if err != nil {
+        return err
+    }
+    defer os.RemoveAll(pullDir)
+
+    if err := runBundleCommand(outboundCommand("oras", "pull", ref, "-o", pullDir)); err != nil {
+        return err
+    }
+    entries, err := os.ReadDir(pullDir)
+    if err != nil {
+        return err
+    }
+    if len(entries) != 1 || entries[0].IsDir() {
+        return fmt.Errorf("%s should contain exactly one tarball, found %d entries", ref, len(entries))
+    }
+    return copyFile(filepath.Join(pullDir, entries[0].Name()), path)
+}
+
+func downloadHTTPBundle(url, path string) error {
+    client := outboundClient(5 * time.Minute)
+    resp, err := client.Get(url)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode != http.StatusOK {
+        return fmt.Errorf("HTTP %d", resp.StatusCode)
+    }
+
+    file, err := os.Create(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+    _, err = io.Copy(file, resp.Body)
+    return err
+}
+
+func copyFile(source, target string) error {
+    in, err := os.Open(source)
+    if err != nil {
+        return err
+    }
+    defer in.Close()
+    out, err := os.Create(target)
+    if err != nil {
+        return err
+    }
+    defer out.Close()
+    _, err = io.Copy(out, in)
+    return err
+}
+
+func fileSHA256(path string) (string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return "", err
+    }
+    defer file.Close()
+    hash := sha256.New()
+    if _, err := io.Copy(hash, file); err != nil {
+        return "", err
+    }
+    return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func isSHA256(digest string) bool {
+    if len(digest) != 64 {
+        return false
+    }
+    _, err := hex.DecodeString(digest)
+    return err == nil
+}
+
+// Extract a tar or tar.gz into dir. Only directories and regular files are
+// extracted, and no entry may land outside dir.
+func extractTarball(path, dir string) error {
+    file, err := os.Open(path)
+    if err != nil {
+        return err
+    }
+    defer file.Close()
+
+    var reader io.Reader = file
+    magic := make([]byte, 2)
+    if n, _ := io.ReadFull(file, magic); n == 2 && magic[0] == 0x1f && magic[1] == 0x8b {
+        file.Seek(0, io.SeekStart)
+        gz, err := gzip.NewReader(file)
+        if err != nil {
+            return err
+        }
+        defer gz.Close()
+        reader = gz
+    } else {
+        file.Seek(0, io.SeekStart)
+    }
+
+    archive := tar.NewReader(reader)
+    for {
+        header, err := archive.Next()
+        if err == io.EOF {
+            return nil
+        }
+        if err != nil {
+            return err
+        }
+
+        target := filepath.Join(dir, header.Name)
+        if target != dir && !strings.HasPrefix(target, dir+string(os.PathSeparator)) {
+            return fmt.Errorf("entry %s escapes the bundle", header.Name)
+        }
+        switch header.Typeflag {
+        case tar.TypeDir:
+            if err := os.MkdirAll(target, 0755); err != nil {
+                return err
+            }
+        case tar.TypeReg:
+            if err := os.MkdirAll(filepath.Dir(target), 0755); err != nil {
+                return err
+            }
+            out, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, os.FileMode(header.Mode)&0755|0644)
+            if err != nil {
+                return err
+            }
+            _, err = io.Copy(out, archive)
+            out.Close()
+            if err != nil {
+                return err
+            }
+        default:
+            log.Printf("⚠️ Skipping %s in playbook bundle, not a file or directory", header.Name)
+        }
+    }
+}
+
+// Record the bundle a request was provisioned from, so the playbook version behind
+// any VM can be looked up
+func (kc *KratixController) setPlaybookBundle(requestName string, bundle playbookBundle) {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "playbookBundle": bundle.status(),
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record playbook bundle of %s: %v", requestName, err)
+    }
+}
+
+func validatePlaybookBundles(check *ConfigCheck) {
+    for name, environment := range loadVMEnvironments() {
+        bundle := environment.PlaybookBundle
+        if bundle == nil {
+            continue
+        }
+        switch {
+        case strings.HasPrefix(bundle.URL, "s3://"):
+            if _, err := exec.LookPath("aws"); err != nil {
+                check.fail("environment %s: playbook bundle is in S3 but the aws CLI is not on PATH", name)
+            }
+        case strings.HasPrefix(bundle.URL, "oci://"):
+            if _, err := exec.LookPath("oras"); err != nil {
+                check.fail("environment %s: playbook bundle is in an OCI registry but oras is not on PATH", name)
+            }
+        case strings.HasPrefix(bundle.URL, "https://"), strings.HasPrefix(bundle.URL, "file://"):
+        case strings.HasPrefix(bundle.URL, "http://"):
+            check.warn("environment %s: playbook bundle is fetched over plain HTTP", name)
+        default:
+            check.fail("environment %s: playbook bundle url %q is not s3://, oci://, https:// or file://", name, bundle.URL)
+        }
+        if !isSHA256(bundle.digest()) {
+            check.fail("environment %s: playbook bundle sha256 %q is not a SHA-256 digest", name, bundle.SHA256)
+        }
+        if bundle.Version == "" {
+            check.warn("environment %s: playbook bundle has no version, requests record only its digest", name)
+        }
+    }
+}
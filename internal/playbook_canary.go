@@ -0,0 +1,352 @@
+// internal/playbook_canary.go - A changed playbook bundle tried on the first sessions of its environment before every session gets it
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Sessions of an environment provisioned from a newly configured bundle while the
+// others keep the previous one; 0, the default, gives every session the new bundle at once
+func getPlaybookCanarySessions() int {
+    if sessions, err := strconv.Atoi(Setting("PLAYBOOK_CANARY_SESSIONS")); err == nil && sessions > 0 {
+        return sessions
+    }
+    return 0
+}
+
+// How much higher than the previous bundle's the new bundle's failure rate may be
+// and still be promoted, 0.1 allowing 10 points more
+func getPlaybookCanaryMaxFailureIncrease() float64 {
+    if increase, err := strconv.ParseFloat(Setting("PLAYBOOK_CANARY_MAX_FAILURE_INCREASE"), 64); err == nil && increase >= 0 && increase <= 1 {
+        return increase
+    }
+    return 0.1
+}
+
+// Canaries survive restarts in a ConfigMap, one JSON entry per environment
+func getPlaybookCanaryConfigMapName() string {
+    if name := Setting("PLAYBOOK_CANARY_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-playbook-canary"
+}
+
+// States of an environment's canary
+const (
+    canaryTrial      = "trial"       // the first sessions get the candidate
+    canaryPromoted   = "promoted"    // the candidate is the stable bundle now
+    canaryRolledBack = "rolled-back" // sessions stay on the stable bundle until the config changes again
+)
+
+// An environment's stable bundle and the candidate on trial against it. Runs count
+// provisioning outcomes of either bundle since the trial started.
+type playbookCanary struct {
+    Stable            playbookBundle  `json:"stable"`
+    Candidate         *playbookBundle `json:"candidate,omitempty"`
+    State             string          `json:"state,omitempty"`
+    Sessions          []string        `json:"sessions,omitempty"`
+    CandidateRuns     int             `json:"candidateRuns,omitempty"`
+    CandidateFailures int             `json:"candidateFailures,omitempty"`
+    StableRuns        int             `json:"stableRuns,omitempty"`
+    StableFailures    int             `json:"stableFailures,omitempty"`
+    StartedAt         string          `json:"startedAt,omitempty"`
+    DecidedAt         string          `json:"decidedAt,omitempty"`
+    Reason            string          `json:"reason,omitempty"`
+}
+
+func (canary *playbookCanary) routed(session string) bool {
+    for _, routed := range canary.Sessions {
+        if routed == session {
+            return true
+        }
+    }
+    return false
+}
+
+func failureRate(failures, runs int) float64 {
+    if runs == 0 {
+        return 0
+    }
+    return float64(failures) / float64(runs)
+}
+
+// Settle a trial once the candidate ran for all its sessions, or as soon as its
+// failures alone put it past the stable bundle's rate plus the allowed increase
+func (canary *playbookCanary) decide(sessions int) {
+    if canary.State != canaryTrial || canary.Candidate == nil {
+        return
+    }
+    limit := failureRate(canary.StableFailures, canary.StableRuns) + getPlaybookCanaryMaxFailureIncrease()
+    candidateRate := failureRate(canary.CandidateFailures, canary.CandidateRuns)
+    switch {
+    case failureRate(canary.CandidateFailures, sessions) > limit:
+        canary.State = canaryRolledBack
+    case canary.CandidateRuns >= sessions && candidateRate <= limit:
+        canary.State = canaryPromoted
+        canary.Stable = *canary.Candidate
+    case canary.CandidateRuns >= sessions:
+        canary.State = canaryRolledBack
+    default:
+        return
+    }
+    canary.DecidedAt = time.Now().Format(time.RFC3339)
+    canary.Reason = fmt.Sprintf("candidate failed %d of %d runs, stable %d of %d",
+        canary.CandidateFailures, canary.CandidateRuns, canary.StableFailures, canary.StableRuns)
+}
+
+// Every environment's canary
+func loadPlaybookCanaries(client dynamic.Interface) (*unstructured.Unstructured, map[string]*playbookCanary, error) {
+    canaries := map[string]*playbookCanary{}
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(context.TODO(), getPlaybookCanaryConfigMapName(), metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        return nil, canaries, nil
+    }
+    if err != nil {
+        return nil, nil, err
+    }
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    for environment, value := range data {
+        canary := &playbookCanary{}
+        if err := json.Unmarshal([]byte(value), canary); err != nil {
+            log.Printf("⚠️ Ignoring unreadable playbook canary of environment %s: %v", environment, err)
+            continue
+        }
+        canaries[environment] = canary
+    }
+    return configMap, canaries, nil
+}
+
+// Change an environment's canary, retrying when another replica changed the
+// ConfigMap in between. change returns false to leave it as it is.
+func updatePlaybookCanary(client dynamic.Interface, environment string, change func(canary *playbookCanary) bool) (playbookCanary, error) {
+    for attempt := 0; ; attempt++ {
+        configMap, canaries, err := loadPlaybookCanaries(client)
+        if err != nil {
+            return playbookCanary{}, err
+        }
+        canary := canaries[environment]
+        if canary == nil {
+            canary = &playbookCanary{}
+        }
+        if !change(canary) {
+            return *canary, nil
+        }
+        value, _ := json.Marshal(canary)
+
+        if configMap == nil {
+            configMap = &unstructured.Unstructured{
+                Object: map[string]interface{}{
+                    "apiVersion": "v1",
+                    "kind":       "ConfigMap",
+                    "metadata": map[string]interface{}{
+                        "name":      getPlaybookCanaryConfigMapName(),
+                        "namespace": "default",
+                        "labels": map[string]interface{}{
+                            "app": "hobbyfarm-provisioner",
+                        },
+                    },
+                    "data": map[string]interface{}{environment: string(value)},
+                },
+            }
+            _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+        } else {
+            unstructured.SetNestedField(configMap.Object, string(value), "data", environment)
+            _, err = client.Resource(configMapGVR).Namespace("default").Update(context.TODO(), configMap, metav1.UpdateOptions{})
+        }
+        if err == nil {
+            return *canary, nil
+        }
+        if !(errors.IsConflict(err) || errors.IsAlreadyExists(err)) || attempt >= 4 {
+            return playbookCanary{}, err
+        }
+    }
+}
+
+// The bundle a session of environment is provisioned from. The first bundle an
+// environment has becomes its stable one; a changed bundle goes to the first
+// PLAYBOOK_CANARY_SESSIONS sessions while the others keep the stable one, and is
+// promoted or rolled back by their outcome. A session keeps its bundle on retries.
+func selectPlaybookBundle(client dynamic.Interface, environment vmEnvironment, session string) *playbookBundle {
+    configured := environment.PlaybookBundle
+    sessions := getPlaybookCanarySessions()
+    if configured == nil || sessions == 0 {
+        return configured
+    }
+
+    var candidate bool
+    canary, err := updatePlaybookCanary(client, environment.Name, func(canary *playbookCanary) bool {
+        candidate = false
+        switch {
+        case canary.Stable.URL == "":
+            canary.Stable = *configured
+            return true
+        case canary.Stable.digest() == configured.digest():
+            return false
+        case canary.Candidate == nil || canary.Candidate.digest() != configured.digest():
+            // A bundle the trial hasn't seen yet, whatever became of the previous one
+            *canary = playbookCanary{
+                Stable:    canary.Stable,
+                Candidate: configured,
+                State:     canaryTrial,
+                StartedAt: time.Now().Format(time.RFC3339),
+            }
+            log.Printf("🐤 Playbook bundle %s of environment %s on trial with its next %d sessions, the others stay on %s",
+                configured.Version, environment.Name, sessions, canary.Stable.Version)
+        case canary.State != canaryTrial:
+            return false
+        }
+        if canary.routed(session) {
+            candidate = true
+            return false
+        }
+        if len(canary.Sessions) >= sessions {
+            return false
+        }
+        canary.Sessions = append(canary.Sessions, session)
+        candidate = true
+        return true
+    })
+    if err != nil {
+        // The candidate is what the environment asks for, better than provisioning nothing
+        log.Printf("⚠️ Playbook canary of environment %s unavailable, using the configured bundle: %v", environment.Name, err)
+        return configured
+    }
+
+    if candidate {
+        return configured
+    }
+    stable := canary.Stable
+    return &stable
+}
+
+// Count a provisioning run of session from bundle against the environment's trial,
+// settling it when enough candidate runs are in
+func recordPlaybookCanaryRun(client dynamic.Interface, request *unstructured.Unstructured, environment string, bundle playbookBundle, session string, failed bool) {
+    sessions := getPlaybookCanarySessions()
+    if sessions == 0 {
+        return
+    }
+    var settled bool
+    canary, err := updatePlaybookCanary(client, environment, func(canary *playbookCanary) bool {
+        settled = false
+        if canary.State != canaryTrial || canary.Candidate == nil {
+            return false
+        }
+        switch {
+        case canary.routed(session) && bundle.digest() == canary.Candidate.digest():
+            canary.CandidateRuns++
+            if failed {
+                canary.CandidateFailures++
+            }
+        case bundle.digest() == canary.Stable.digest():
+            canary.StableRuns++
+            if failed {
+                canary.StableFailures++
+            }
+        default:
+            return false
+        }
+        canary.decide(sessions)
+        settled = canary.State != canaryTrial
+        return true
+    })
+    if err != nil {
+        log.Printf("⚠️ Could not count the provisioning run of %s towards the playbook canary of %s: %v", request.GetName(), environment, err)
+        return
+    }
+    if !settled {
+        return
+    }
+    switch canary.State {
+    case canaryPromoted:
+        log.Printf("🚀 Playbook bundle %s promoted in environment %s: %s", canary.Candidate.Version, environment, canary.Reason)
+        recordEvent(client, request, eventTypeNormal, "PlaybookCanaryPromoted", fmt.Sprintf(
+            "Playbook bundle %s promoted in environment %s, %s", canary.Candidate.Version, environment, canary.Reason))
+    case canaryRolledBack:
+        log.Printf("⏪ Playbook bundle %s rolled back in environment %s, sessions stay on %s: %s", canary.Candidate.Version, environment, canary.Stable.Version, canary.Reason)
+        recordEvent(client, request, eventTypeWarning, "PlaybookCanaryRolledBack", fmt.Sprintf(
+            "Playbook bundle %s rolled back in environment %s, sessions stay on %s; %s", canary.Candidate.Version, environment, canary.Stable.Version, canary.Reason))
+    }
+}
+
+// GET /playbook-canary lists every environment's canary; POST
+// ?environment=<name>&action=promote|rollback settles a trial by hand. Both
+// only on the admin listener.
+func (ws *WebhookServer) playbookCanaryHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        _, canaries, err := loadPlaybookCanaries(ws.client)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(canaries)
+        return
+    case http.MethodPost:
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    environment := r.URL.Query().Get("environment")
+    action := r.URL.Query().Get("action")
+    if environment == "" || (action != "promote" && action != "rollback") {
+        http.Error(w, "environment and action=promote or action=rollback are required", http.StatusBadRequest)
+        return
+    }
+    onTrial := true
+    canary, err := updatePlaybookCanary(ws.client, environment, func(canary *playbookCanary) bool {
+        if canary.State != canaryTrial || canary.Candidate == nil {
+            onTrial = false
+            return false
+        }
+        canary.State = canaryRolledBack
+        if action == "promote" {
+            canary.State = canaryPromoted
+            canary.Stable = *canary.Candidate
+        }
+        canary.DecidedAt = time.Now().Format(time.RFC3339)
+        canary.Reason = "settled by an operator"
+        return true
+    })
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    if !onTrial {
+        http.Error(w, fmt.Sprintf("environment %s has no playbook bundle on trial", environment), http.StatusConflict)
+        return
+    }
+    log.Printf("🐤 Playbook bundle %s of environment %s %s by an operator", canary.Candidate.Version, environment, canary.State)
+    w.WriteHeader(http.StatusNoContent)
+}
+
+// Canaries need pinned bundles to tell versions apart
+func validatePlaybookCanary(check *ConfigCheck) {
+    if getPlaybookCanarySessions() == 0 {
+        return
+    }
+    var unbundled []string
+    for name, environment := range loadVMEnvironments() {
+        if environment.PlaybookBundle == nil {
+            unbundled = append(unbundled, name)
+        }
+    }
+    sort.Strings(unbundled)
+    if len(unbundled) > 0 {
+        check.warn("PLAYBOOK_CANARY_SESSIONS has no effect in environments without a playbookBundle: %v", unbundled)
+    }
+}
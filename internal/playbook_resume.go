@@ -0,0 +1,137 @@
+// internal/playbook_resume.go - Resume a failed provisioning run at its first incomplete playbook
+package internal
+
+import (
+    "encoding/json"
+    "log"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// PLAYBOOK_RESUME=false makes every retry run all playbooks again
+func playbookResumeEnabled() bool {
+    return Setting("PLAYBOOK_RESUME") != "false"
+}
+
+// Playbooks that run on every retry even when they completed before: ALWAYS_RERUN_PLAYBOOKS,
+// the request's spec.provisioning.alwaysRerun and the callback playbook, which reports
+// the current run
+func alwaysRerunPlaybooks(request *unstructured.Unstructured) map[string]bool {
+    rerun := map[string]bool{callbackPlaybook: true}
+    for _, playbook := range splitEnvList("ALWAYS_RERUN_PLAYBOOKS") {
+        rerun[playbook] = true
+    }
+    requested, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "alwaysRerun")
+    for _, playbook := range requested {
+        rerun[playbook] = true
+    }
+    return rerun
+}
+
+// Playbooks an earlier run of the request completed on vmIP. Progress on another
+// VM doesn't count, a new VM starts from scratch.
+func completedPlaybooks(request *unstructured.Unstructured, vmIP string) map[string]bool {
+    completed := map[string]bool{}
+    progressIP, _, _ := unstructured.NestedString(request.Object, "status", "playbookProgress", "vmIP")
+    if progressIP == "" || progressIP != vmIP {
+        return completed
+    }
+    playbooks, _, _ := unstructured.NestedStringSlice(request.Object, "status", "playbookProgress", "completed")
+    for _, playbook := range playbooks {
+        completed[playbook] = true
+    }
+    return completed
+}
+
+// Split playbooks into those to run and those already done. Everything from the
+// first incomplete playbook on runs, plus earlier ones that must always re-run.
+func resumePlaybooks(request *unstructured.Unstructured, vmIP string, playbooks []string) ([]string, []string) {
+    if !playbookResumeEnabled() {
+        return playbooks, nil
+    }
+    completed := completedPlaybooks(request, vmIP)
+    rerun := alwaysRerunPlaybooks(request)
+
+    var run, skipped []string
+    for i, playbook := range playbooks {
+        if !completed[playbook] {
+            return append(run, playbooks[i:]...), skipped
+        }
+        if rerun[playbook] {
+            run = append(run, playbook)
+        } else {
+            skipped = append(skipped, playbook)
+        }
+    }
+    return run, skipped
+}
+
+// Record the playbooks completed on vmIP so far, after each one so a crash keeps them
+func (kc *KratixController) recordPlaybookProgress(requestName, vmIP string, completed []string) {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "playbookProgress": map[string]interface{}{
+                "vmIP":      vmIP,
+                "completed": completed,
+            },
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record playbook progress of %s: %v", requestName, err)
+    }
+}
+
+// Whether a failed request can go back to its own VM and resume: it failed in a
+// playbook, recorded progress on that VM, and the VM is still its to use
+func resumableOnSameVM(client dynamic.Interface, request *unstructured.Unstructured) bool {
+    failureReason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+    if failureReason != failurePlaybookFailed || vmIP == "" || len(completedPlaybooks(request, vmIP)) == 0 {
+        return false
+    }
+
+    // An adopted machine stays the request's, whatever happened to it
+    if vmType == vmTypeAdopted {
+        return true
+    }
+    if vmType == "ec2" || isPublicIP(vmIP) {
+        instance, err := findCloudInstanceForRequest(client, request.GetName())
+        return err == nil && instance != nil
+    }
+    // A failed request doesn't hold its static VM, another one may have taken it
+    usedIPs, err := collectAllocatedIPs(client)
+    if err != nil || usedIPs[vmIP] {
+        return false
+    }
+    _, drained := getMaintenanceVMs(client)[vmIP]
+    return !drained
+}
+
+// Send a failed request back to allocated on the VM it holds; provisioning then
+// resumes where it stopped. allocatedAt is renewed so the allocation timeout restarts.
+func resetRequestForResume(client dynamic.Interface, requestName string, retryCount int64) error {
+    status := map[string]interface{}{
+        "state":          "allocated",
+        "provisioned":    false,
+        "allocatedAt":    time.Now().Format(time.RFC3339),
+        "readyAt":        nil,
+        "lastError":      nil,
+        "failureReason":  nil,
+        "failedAt":       nil,
+        "deadLetteredAt": nil,
+        "retryCount":     nil,
+        "conditions":     nil,
+    }
+    if retryCount > 0 {
+        status["retryCount"] = retryCount
+    }
+    // Counted as another attempt of the same request
+    for field, value := range retryStatus(client, requestName) {
+        status[field] = value
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    return patchStatus(client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+}
@@ -0,0 +1,253 @@
+// internal/pool_diff.go - Pool config changes applied entry by entry: static VMs added or changed are probed before they are allocated
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "reflect"
+    "sort"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Reasons of pool Events about entries awaiting their probe
+const (
+    reasonVMAwaitingVerification = "VMAwaitingVerification"
+    reasonVMVerified             = "VMVerified"
+    reasonVMVerificationFailed   = "VMVerificationFailed"
+)
+
+// A failed probe is tried again after this long, not on every allocation pass
+const poolVerifyRetryInterval = time.Minute
+
+// How the provisioner logs in to a static VM, as the environments file says. A
+// VM whose entry differs from the one it last passed its probe with is not
+// allocated until it passes again.
+type poolEntry struct {
+    SSHUser   string `json:"sshUser"`
+    SSHSecret string `json:"sshSecret"`
+}
+
+func (env vmEnvironment) poolEntry(ip string) poolEntry {
+    return poolEntry{SSHUser: env.sshUserFor(ip), SSHSecret: env.SSHSecret}
+}
+
+// A configured VM waiting for its probe, as listed in the pool's status.unverified
+type unverifiedVM struct {
+    IP     string `json:"ip"`
+    Reason string `json:"reason"`
+    Since  string `json:"since"`
+}
+
+// status.entries of a pool, the entries its VMs last passed their probe with, and
+// whether the pool has them yet: pools from before entries were recorded have not
+func poolVerifiedEntries(pool *unstructured.Unstructured) (map[string]poolEntry, bool) {
+    if pool == nil {
+        return nil, false
+    }
+    raw, found, _ := unstructured.NestedMap(pool.Object, "status", "entries")
+    if !found {
+        return nil, false
+    }
+    entries := make(map[string]poolEntry, len(raw))
+    for ip, value := range raw {
+        fields, ok := value.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        user, _, _ := unstructured.NestedString(fields, "sshUser")
+        secret, _, _ := unstructured.NestedString(fields, "sshSecret")
+        entries[ip] = poolEntry{SSHUser: user, SSHSecret: secret}
+    }
+    return entries, true
+}
+
+// What changed in the entry of ip since it passed its probe, empty when nothing did
+func poolEntryChange(verified map[string]poolEntry, ip string, current poolEntry) string {
+    previous, known := verified[ip]
+    switch {
+    case !known:
+        return "added"
+    case previous.SSHUser != current.SSHUser:
+        return fmt.Sprintf("SSH user changed from %s to %s", previous.SSHUser, current.SSHUser)
+    case previous.SSHSecret != current.SSHSecret:
+        return fmt.Sprintf("SSH secret changed from %s to %s", previous.SSHSecret, current.SSHSecret)
+    }
+    return ""
+}
+
+// Compare a pool's configured entries with the verified ones: list those awaiting
+// their probe and forget VMs no longer configured, unless they still drain. A
+// pool from before entries were recorded takes its current entries as verified,
+// since they were in service already; a pool created this pass starts with none.
+func reconcilePoolEntries(client dynamic.Interface, pool *unstructured.Unstructured, environment vmEnvironment, created bool, draining []drainingVM) {
+    verified, recorded := poolVerifiedEntries(pool)
+    if !recorded && !created {
+        entries := map[string]interface{}{}
+        for _, ip := range environment.StaticVMs {
+            entries[ip] = environment.poolEntry(ip)
+        }
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "status": map[string]interface{}{"entries": entries},
+        })
+        if err := patchStatus(client, staticVMPoolGVR, "default", pool.GetName(), patchBytes); err != nil {
+            log.Printf("⚠️ Could not record the entries of pool %s: %v", pool.GetName(), err)
+        }
+        return
+    }
+
+    previous := map[string]unverifiedVM{}
+    if items, found, _ := unstructured.NestedSlice(pool.Object, "status", "unverified"); found {
+        for _, item := range items {
+            fields, ok := item.(map[string]interface{})
+            if !ok {
+                continue
+            }
+            ip, _, _ := unstructured.NestedString(fields, "ip")
+            reason, _, _ := unstructured.NestedString(fields, "reason")
+            since, _, _ := unstructured.NestedString(fields, "since")
+            previous[ip] = unverifiedVM{IP: ip, Reason: reason, Since: since}
+        }
+    }
+
+    var unverified []unverifiedVM
+    configured := map[string]bool{}
+    for _, ip := range environment.StaticVMs {
+        configured[ip] = true
+        change := poolEntryChange(verified, ip, environment.poolEntry(ip))
+        if change == "" {
+            continue
+        }
+        entry, listed := previous[ip]
+        if !listed || entry.Reason != change {
+            entry = unverifiedVM{IP: ip, Reason: change, Since: time.Now().Format(time.RFC3339)}
+            log.Printf("🔎 Static VM %s of environment %s %s, probing it before it is allocated", ip, environment.Name, change)
+            recordEvent(client, pool, eventTypeNormal, reasonVMAwaitingVerification, fmt.Sprintf("vm=%s %s, probed before its next allocation", ip, change))
+        }
+        unverified = append(unverified, entry)
+    }
+    sort.Slice(unverified, func(i, j int) bool { return unverified[i].IP < unverified[j].IP })
+    for _, entry := range draining {
+        configured[entry.IP] = true
+    }
+
+    // A VM added back later is probed again
+    forgotten := map[string]interface{}{}
+    for ip := range verified {
+        if !configured[ip] {
+            forgotten[ip] = nil
+        }
+    }
+
+    status := map[string]interface{}{}
+    if created && !recorded {
+        status["entries"] = map[string]interface{}{}
+    } else if len(forgotten) > 0 {
+        status["entries"] = forgotten
+    }
+    // A merge patch replaces the list; null clears it once every VM passed
+    if !reflect.DeepEqual(previousUnverified(previous), unverified) {
+        var list interface{}
+        if len(unverified) > 0 {
+            list = unverified
+        }
+        status["unverified"] = list
+    }
+    if len(status) == 0 {
+        return
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(client, staticVMPoolGVR, "default", pool.GetName(), patchBytes); err != nil {
+        log.Printf("⚠️ Could not record unverified VMs of pool %s: %v", pool.GetName(), err)
+    }
+}
+
+// Previously listed VMs sorted by IP, in the shape reconcilePoolEntries builds
+func previousUnverified(previous map[string]unverifiedVM) []unverifiedVM {
+    var entries []unverifiedVM
+    for _, entry := range previous {
+        entries = append(entries, entry)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+    return entries
+}
+
+// Probes that failed, by IP, so they are retried every poolVerifyRetryInterval
+// and their Event is recorded once per error
+var poolVerifications = struct {
+    sync.Mutex
+    failed map[string]poolVerification
+}{failed: map[string]poolVerification{}}
+
+type poolVerification struct {
+    entry poolEntry
+    at    time.Time
+    err   string
+}
+
+// Whether ip of environment may be allocated: its entry is the one it passed its
+// probe with, or it passes one now, reachable and taking the configured login.
+// Pools whose entries aren't recorded yet allocate as before.
+func (kc *KratixController) staticVMVerified(pool *unstructured.Unstructured, environment vmEnvironment, ip string) bool {
+    verified, recorded := poolVerifiedEntries(pool)
+    if !recorded {
+        return true
+    }
+    entry := environment.poolEntry(ip)
+    change := poolEntryChange(verified, ip, entry)
+    if change == "" {
+        return true
+    }
+
+    poolVerifications.Lock()
+    failure, failed := poolVerifications.failed[ip]
+    poolVerifications.Unlock()
+    if failed && failure.entry == entry && time.Since(failure.at) < poolVerifyRetryInterval {
+        return false
+    }
+
+    var probeErr error
+    if !kc.prober.Reachable(ip) {
+        probeErr = fmt.Errorf("not reachable")
+    } else if _, err := kc.prober.DetectPlatform(ip, entry.SSHUser); err != nil {
+        probeErr = fmt.Errorf("login as %s failed: %v", entry.SSHUser, err)
+    }
+    if probeErr != nil {
+        poolVerifications.Lock()
+        poolVerifications.failed[ip] = poolVerification{entry: entry, at: time.Now(), err: probeErr.Error()}
+        poolVerifications.Unlock()
+        if !failed || failure.err != probeErr.Error() || failure.entry != entry {
+            log.Printf("⚠️ Static VM %s (%s) failed its probe, not allocated: %v", ip, change, probeErr)
+            recordEvent(kc.client, pool, eventTypeWarning, reasonVMVerificationFailed, fmt.Sprintf("vm=%s %s, probe failed: %v", ip, change, probeErr))
+        }
+        return false
+    }
+
+    poolVerifications.Lock()
+    delete(poolVerifications.failed, ip)
+    poolVerifications.Unlock()
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{"entries": map[string]interface{}{ip: entry}},
+    })
+    if err := patchStatus(kc.client, staticVMPoolGVR, "default", pool.GetName(), patchBytes); err != nil {
+        log.Printf("⚠️ Could not record the verified entry of static VM %s: %v", ip, err)
+    }
+    log.Printf("✅ Static VM %s (%s) passed its probe as %s", ip, change, entry.SSHUser)
+    recordEvent(kc.client, pool, eventTypeNormal, reasonVMVerified, fmt.Sprintf("vm=%s %s, probe passed as %s", ip, change, entry.SSHUser))
+    return true
+}
+
+// An environment's pool, nil when it has none yet
+func getStaticVMPool(client dynamic.Interface, environment string) *unstructured.Unstructured {
+    pool, err := client.Resource(staticVMPoolGVR).Namespace("default").Get(context.TODO(), staticVMPoolName(environment), metav1.GetOptions{})
+    if err != nil {
+        return nil
+    }
+    return pool
+}
@@ -0,0 +1,424 @@
+// internal/pool_discovery.go - Find static VM candidates by scanning CIDRs or DHCP leases, pooled after operator approval
+package internal
+
+import (
+    "bufio"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/http"
+    "os"
+    "regexp"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Candidates are listed in a ConfigMap as <ip>: <JSON poolCandidate>. Only approved
+// ones join their environment's static pool; rejected ones are never proposed again.
+const (
+    candidateProposed = "proposed"
+    candidateApproved = "approved"
+    candidateRejected = "rejected"
+)
+
+// Larger ranges are refused, a /20 already takes a while to sweep
+const maxDiscoveryHosts = 4096
+
+type poolCandidate struct {
+    Status       string `json:"status"`
+    Environment  string `json:"environment"`
+    SSHUser      string `json:"sshUser,omitempty"`
+    Source       string `json:"source"`
+    DiscoveredAt string `json:"discoveredAt"`
+}
+
+func getPoolCandidatesConfigMapName() string {
+    if name := Setting("POOL_CANDIDATES_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-pool-candidates"
+}
+
+// Comma-separated CIDRs to sweep, e.g. 192.168.2.0/24
+func getDiscoveryCIDRs() []string {
+    return splitEnvList("POOL_DISCOVERY_CIDRS")
+}
+
+// dnsmasq or ISC dhcpd lease file to take addresses from instead of sweeping
+func getDiscoveryLeasesFile() string {
+    return Setting("POOL_DISCOVERY_LEASES_FILE")
+}
+
+// Environment discovered hosts are proposed for
+func getDiscoveryEnvironment() string {
+    if name := Setting("POOL_DISCOVERY_ENVIRONMENT"); name != "" {
+        return name
+    }
+    return defaultEnvironmentName
+}
+
+func getDiscoveryInterval() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("POOL_DISCOVERY_INTERVAL_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 30 * time.Minute
+}
+
+func poolDiscoveryEnabled() bool {
+    return len(getDiscoveryCIDRs()) > 0 || getDiscoveryLeasesFile() != ""
+}
+
+// Approved candidates by environment, merged into the pools by loadVMEnvironments
+var approvedCandidates = struct {
+    sync.RWMutex
+    byEnvironment map[string][]string
+}{byEnvironment: map[string][]string{}}
+
+func approvedStaticVMs(environment string) []string {
+    approvedCandidates.RLock()
+    defer approvedCandidates.RUnlock()
+    return approvedCandidates.byEnvironment[environment]
+}
+
+func loadPoolCandidates(client dynamic.Interface) (map[string]poolCandidate, error) {
+    candidates := map[string]poolCandidate{}
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(
+        context.TODO(), getPoolCandidatesConfigMapName(), metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        return candidates, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    for ip, value := range data {
+        var candidate poolCandidate
+        if err := json.Unmarshal([]byte(value), &candidate); err != nil {
+            log.Printf("⚠️ Ignoring unreadable pool candidate %s: %v", ip, err)
+            continue
+        }
+        candidates[ip] = candidate
+    }
+    return candidates, nil
+}
+
+func savePoolCandidate(client dynamic.Interface, ip string, candidate poolCandidate) error {
+    value, _ := json.Marshal(candidate)
+    data := map[string]interface{}{ip: string(value)}
+    patchBytes, _ := json.Marshal(map[string]interface{}{"data": data})
+
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), getPoolCandidatesConfigMapName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsNotFound(err) {
+        configMap := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "v1",
+                "kind":       "ConfigMap",
+                "metadata": map[string]interface{}{
+                    "name":      getPoolCandidatesConfigMapName(),
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "data": data,
+            },
+        }
+        _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+    }
+    if err != nil {
+        return fmt.Errorf("failed to record pool candidate %s: %v", ip, err)
+    }
+    return nil
+}
+
+// Pick up approvals, whether made through /pool-candidates or by editing the ConfigMap
+func refreshApprovedCandidates(client dynamic.Interface) {
+    candidates, err := loadPoolCandidates(client)
+    if err != nil {
+        log.Printf("⚠️ Could not read pool candidates: %v", err)
+        return
+    }
+
+    byEnvironment := map[string][]string{}
+    for ip, candidate := range candidates {
+        if candidate.Status == candidateApproved {
+            byEnvironment[candidate.Environment] = append(byEnvironment[candidate.Environment], ip)
+        }
+    }
+
+    approvedCandidates.Lock()
+    approvedCandidates.byEnvironment = byEnvironment
+    approvedCandidates.Unlock()
+}
+
+// Every host address of an IPv4 CIDR, without network and broadcast addresses
+func cidrHosts(cidr string) ([]string, error) {
+    ip, network, err := net.ParseCIDR(cidr)
+    if err != nil || ip.To4() == nil {
+        return nil, fmt.Errorf("%q is not an IPv4 CIDR", cidr)
+    }
+    ones, bits := network.Mask.Size()
+    if size := 1 << (bits - ones); size > maxDiscoveryHosts {
+        return nil, fmt.Errorf("%s has %d addresses, at most %d can be scanned", cidr, size, maxDiscoveryHosts)
+    }
+
+    var hosts []string
+    for current := network.IP.Mask(network.Mask).To4(); network.Contains(current); current = nextIP(current) {
+        hosts = append(hosts, current.String())
+    }
+    if len(hosts) > 2 {
+        hosts = hosts[1 : len(hosts)-1]
+    }
+    return hosts, nil
+}
+
+func nextIP(ip net.IP) net.IP {
+    next := make(net.IP, len(ip))
+    copy(next, ip)
+    for i := len(next) - 1; i >= 0; i-- {
+        next[i]++
+        if next[i] != 0 {
+            break
+        }
+    }
+    return next
+}
+
+var (
+    iscLeasePattern     = regexp.MustCompile(`^lease\s+(\d+\.\d+\.\d+\.\d+)\s*\{`)
+    dnsmasqLeasePattern = regexp.MustCompile(`^\d+\s+\S+\s+(\d+\.\d+\.\d+\.\d+)\s`)
+)
+
+// Leased addresses from an ISC dhcpd.leases or dnsmasq.leases file
+func leaseFileIPs(path string) ([]string, error) {
+    file, err := os.Open(path)
+    if err != nil {
+        return nil, err
+    }
+    defer file.Close()
+
+    seen := map[string]bool{}
+    var ips []string
+    scanner := bufio.NewScanner(file)
+    for scanner.Scan() {
+        line := strings.TrimSpace(scanner.Text())
+        match := iscLeasePattern.FindStringSubmatch(line)
+        if match == nil {
+            match = dnsmasqLeasePattern.FindStringSubmatch(line)
+        }
+        if match != nil && !seen[match[1]] {
+            seen[match[1]] = true
+            ips = append(ips, match[1])
+        }
+    }
+    return ips, scanner.Err()
+}
+
+type PoolDiscovery struct {
+    client        dynamic.Interface
+    ansibleRunner *AnsibleRunner
+    lastScan      time.Time
+}
+
+func NewPoolDiscovery(client dynamic.Interface) *PoolDiscovery {
+    return &PoolDiscovery{
+        client:        client,
+        ansibleRunner: NewAnsibleRunner(client),
+    }
+}
+
+// Keep approved candidates in the pools and, when discovery is configured,
+// propose new hosts every POOL_DISCOVERY_INTERVAL_MINUTES
+func (pd *PoolDiscovery) WatchPoolCandidates() {
+    log.Println("🛰️ Starting static pool discovery...")
+    if poolDiscoveryEnabled() {
+        log.Printf("🎯 Scanning %v and leases %q for environment %s", getDiscoveryCIDRs(), getDiscoveryLeasesFile(), getDiscoveryEnvironment())
+    }
+
+    for {
+        refreshApprovedCandidates(pd.client)
+        if poolDiscoveryEnabled() && time.Since(pd.lastScan) > getDiscoveryInterval() {
+            pd.discover()
+            pd.lastScan = time.Now()
+        }
+        Heartbeat(pd.client, HeartbeatPoolDiscovery)
+        time.Sleep(1 * time.Minute)
+    }
+}
+
+// Propose every host that answers SSH with the provisioner's key and isn't pooled or recorded yet
+func (pd *PoolDiscovery) discover() {
+    candidates, err := loadPoolCandidates(pd.client)
+    if err != nil {
+        log.Printf("⚠️ Could not read pool candidates: %v", err)
+        return
+    }
+    pooled := map[string]bool{}
+    for _, ip := range allStaticVMs() {
+        pooled[ip] = true
+    }
+
+    sources := map[string]string{}
+    for _, cidr := range getDiscoveryCIDRs() {
+        hosts, err := cidrHosts(cidr)
+        if err != nil {
+            log.Printf("⚠️ Skipping discovery range: %v", err)
+            continue
+        }
+        for _, ip := range hosts {
+            sources[ip] = "scan " + cidr
+        }
+    }
+    if path := getDiscoveryLeasesFile(); path != "" {
+        ips, err := leaseFileIPs(path)
+        if err != nil {
+            log.Printf("⚠️ Could not read DHCP leases %s: %v", path, err)
+        }
+        for _, ip := range ips {
+            sources[ip] = "leases " + path
+        }
+    }
+
+    targets := make(chan string)
+    var mu sync.Mutex
+    found := map[string]string{} // ip -> SSH user
+    var wg sync.WaitGroup
+    for worker := 0; worker < 32; worker++ {
+        wg.Add(1)
+        go func() {
+            defer wg.Done()
+            for ip := range targets {
+                conn, err := net.DialTimeout("tcp", ip+":22", time.Second)
+                if err != nil {
+                    continue
+                }
+                conn.Close()
+                if user, err := pd.ansibleRunner.detectSSHUser(ip); err == nil {
+                    mu.Lock()
+                    found[ip] = user
+                    mu.Unlock()
+                }
+            }
+        }()
+    }
+    for ip := range sources {
+        if _, recorded := candidates[ip]; !recorded && !pooled[ip] {
+            targets <- ip
+        }
+    }
+    close(targets)
+    wg.Wait()
+
+    for ip, user := range found {
+        candidate := poolCandidate{
+            Status:       candidateProposed,
+            Environment:  getDiscoveryEnvironment(),
+            SSHUser:      user,
+            Source:       sources[ip],
+            DiscoveredAt: time.Now().Format(time.RFC3339),
+        }
+        if err := savePoolCandidate(pd.client, ip, candidate); err != nil {
+            log.Printf("❌ %v", err)
+            recordLastError(HeartbeatPoolDiscovery, "%v", err)
+            continue
+        }
+        log.Printf("🛰️ Proposed %s (SSH user %s, %s) for the %s pool, approve with POST /pool-candidates?ip=%s&action=approve",
+            ip, user, candidate.Source, candidate.Environment, ip)
+    }
+}
+
+// Why ip may not join environment's pool, empty when it may. As validateTenants
+// requires of configured pools, the environment exists and no environment of
+// another tenant lists the VM already; the candidate's own approval is not counted.
+func candidateApprovalRefusal(ip string, candidate poolCandidate, environment string) string {
+    environments := loadVMEnvironments()
+    if _, configured := environments[environment]; !configured {
+        return fmt.Sprintf("environment %s is not in the environments file", environment)
+    }
+    owner := environmentTenant(environment)
+    for name, listing := range environments {
+        if name == candidate.Environment && candidate.Status == candidateApproved {
+            continue
+        }
+        for _, staticIP := range listing.StaticVMs {
+            if staticIP == ip && environmentTenant(name) != owner {
+                return fmt.Sprintf("static VM %s is in environment %s of tenant %q, environment %s belongs to %q", ip, name, environmentTenant(name), environment, owner)
+            }
+        }
+    }
+    return ""
+}
+
+// GET lists candidates, POST ?ip=&action=approve|reject[&environment=] decides on
+// one, on the admin listener
+func (ws *WebhookServer) poolCandidatesHandler(w http.ResponseWriter, r *http.Request) {
+    switch r.Method {
+    case http.MethodGet:
+        candidates, err := loadPoolCandidates(ws.client)
+        if err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(candidates)
+        return
+    case http.MethodPost:
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := r.URL.Query()
+    ip := query.Get("ip")
+    candidates, err := loadPoolCandidates(ws.client)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    candidate, exists := candidates[ip]
+    if !exists {
+        http.Error(w, fmt.Sprintf("%s is not a pool candidate", ip), http.StatusNotFound)
+        return
+    }
+
+    action := query.Get("action")
+    if action != "approve" && action != "reject" {
+        http.Error(w, "action must be approve or reject", http.StatusBadRequest)
+        return
+    }
+    environment := query.Get("environment")
+    if environment == "" {
+        environment = candidate.Environment
+    }
+    if action == "approve" {
+        if refusal := candidateApprovalRefusal(ip, candidate, environment); refusal != "" {
+            log.Printf("🚫 Pool candidate %s not approved: %s", ip, refusal)
+            http.Error(w, refusal, http.StatusConflict)
+            return
+        }
+        candidate.Status = candidateApproved
+    } else {
+        candidate.Status = candidateRejected
+    }
+    candidate.Environment = environment
+
+    if err := savePoolCandidate(ws.client, ip, candidate); err != nil {
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+    refreshApprovedCandidates(ws.client)
+    log.Printf("🛰️ Pool candidate %s %s for environment %s", ip, candidate.Status, candidate.Environment)
+    w.WriteHeader(http.StatusNoContent)
+}
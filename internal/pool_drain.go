@@ -0,0 +1,268 @@
+// internal/pool_drain.go - Static VMs removed from the pool config while in use drain until released
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "reflect"
+    "sort"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// A VM removed from its environment while a request or TrainingVM held it, kept in
+// the pool's status.draining until released so the allocation keeps being served
+type drainingVM struct {
+    IP     string `json:"ip"`
+    Holder string `json:"holder"`
+    Since  string `json:"since"`
+}
+
+// Draining VMs by IP with their environment, as of the last reconcile. Never
+// allocated again; only looked up for the allocations still using them.
+var drainingStaticVMs = struct {
+    sync.RWMutex
+    byIP map[string]string
+}{byIP: map[string]string{}}
+
+// Environment of a draining VM
+func drainingEnvironment(ip string) (string, bool) {
+    drainingStaticVMs.RLock()
+    defer drainingStaticVMs.RUnlock()
+    environment, draining := drainingStaticVMs.byIP[ip]
+    return environment, draining
+}
+
+// Number of draining VMs of an environment
+func drainingCount(environment string) int {
+    drainingStaticVMs.RLock()
+    defer drainingStaticVMs.RUnlock()
+    count := 0
+    for _, name := range drainingStaticVMs.byIP {
+        if name == environment {
+            count++
+        }
+    }
+    return count
+}
+
+// Object holding each allocated VM: a request in a holding state or a TrainingVM with a state
+func staticVMHolders(client dynamic.Interface) (map[string]string, error) {
+    holders := map[string]string{}
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil && apiAvailable(apiKratix) {
+        return nil, err
+    }
+    if err == nil {
+        for _, request := range requests.Items {
+            vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+            if vmIP != "" && holdsVM(state) {
+                holders[vmIP] = "VMProvisioningRequest/" + request.GetName()
+            }
+        }
+    }
+    trainingVMs, err := client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return nil, err
+    }
+    for _, tvm := range trainingVMs.Items {
+        vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+        state, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
+        if vmIP != "" && state != "" && state != "failed" {
+            holders[vmIP] = "TrainingVM/" + tvm.GetName()
+        }
+    }
+    return holders, nil
+}
+
+// status.draining of a pool by IP
+func poolDrainingVMs(pool *unstructured.Unstructured) map[string]drainingVM {
+    entries, _, _ := unstructured.NestedSlice(pool.Object, "status", "draining")
+    draining := map[string]drainingVM{}
+    for _, e := range entries {
+        entry, ok := e.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        ip, _, _ := unstructured.NestedString(entry, "ip")
+        holder, _, _ := unstructured.NestedString(entry, "holder")
+        since, _, _ := unstructured.NestedString(entry, "since")
+        if ip != "" {
+            draining[ip] = drainingVM{IP: ip, Holder: holder, Since: since}
+        }
+    }
+    return draining
+}
+
+// Compare every pool with the environments file: VMs removed while held start
+// draining, draining VMs are removed once released or return when added back
+func reconcileStaticPoolDrains(client dynamic.Interface) {
+    if !apiAvailable(apiTraining) {
+        return
+    }
+    holders, err := staticVMHolders(client)
+    if err != nil {
+        log.Printf("⚠️ Could not check static pool drains: %v", err)
+        return
+    }
+    pools, err := client.Resource(staticVMPoolGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        log.Printf("⚠️ Could not check static pool drains: %v", err)
+        return
+    }
+
+    environments := loadVMEnvironments()
+    configured := map[string]bool{}
+    for _, environment := range environments {
+        for _, ip := range environment.StaticVMs {
+            configured[ip] = true
+        }
+    }
+
+    // Every environment gets its pool, so a VM removed later is noticed
+    pooled := map[string]bool{}
+    for i := range pools.Items {
+        name, _, _ := unstructured.NestedString(pools.Items[i].Object, "spec", "environment")
+        pooled[name] = true
+    }
+    for name, environment := range environments {
+        if pooled[name] {
+            continue
+        }
+        if pool, err := ensureStaticVMPool(client, environment); err == nil {
+            pools.Items = append(pools.Items, *pool)
+        } else {
+            log.Printf("⚠️ Could not create pool of environment %s: %v", name, err)
+        }
+    }
+
+    draining := map[string]string{}
+    for i := range pools.Items {
+        pool := &pools.Items[i]
+        name, _, _ := unstructured.NestedString(pool.Object, "spec", "environment")
+        environment, exists := environments[name]
+        if !exists {
+            // A removed environment drains all of its VMs
+            environment = vmEnvironment{Name: name}
+        }
+        entries := drainPool(client, pool, environment, configured, holders)
+        for _, entry := range entries {
+            draining[entry.IP] = name
+        }
+        // Added and changed VMs wait for their probe, removed ones are forgotten
+        reconcilePoolEntries(client, pool, environment, !pooled[name], entries)
+    }
+
+    drainingStaticVMs.Lock()
+    drainingStaticVMs.byIP = draining
+    drainingStaticVMs.Unlock()
+}
+
+// Update one pool's drains and its recorded VM list, returning what still drains
+func drainPool(client dynamic.Interface, pool *unstructured.Unstructured, environment vmEnvironment, configured map[string]bool, holders map[string]string) []drainingVM {
+    previous := poolDrainingVMs(pool)
+    recorded, _, _ := unstructured.NestedStringSlice(pool.Object, "spec", "staticVMs")
+    candidates := map[string]bool{}
+    for _, ip := range recorded {
+        candidates[ip] = true
+    }
+    for ip := range previous {
+        candidates[ip] = true
+    }
+    ips := make([]string, 0, len(candidates))
+    for ip := range candidates {
+        ips = append(ips, ip)
+    }
+    sort.Strings(ips)
+
+    var entries []drainingVM
+    for _, ip := range ips {
+        entry, wasDraining := previous[ip]
+        holder := holders[ip]
+        switch {
+        case configured[ip]:
+            // Still in this environment, or moved to another one
+            if wasDraining {
+                log.Printf("♻️ Static VM %s is back in the pool config, no longer draining", ip)
+                recordEvent(client, pool, eventTypeNormal, reasonVMReturnedToService, fmt.Sprintf("vm=%s added back to the pool config, drain cancelled", ip))
+            }
+        case holder != "":
+            if !wasDraining {
+                entry = drainingVM{IP: ip, Since: time.Now().Format(time.RFC3339)}
+                log.Printf("🚰 Static VM %s removed from environment %s while held by %s, draining until released", ip, environment.Name, holder)
+                recordEvent(client, pool, eventTypeWarning, reasonVMDraining,
+                    fmt.Sprintf("vm=%s holder=%s removed from the pool config while in use, draining until released", ip, holder))
+            }
+            entry.Holder = holder
+            entries = append(entries, entry)
+        case wasDraining:
+            log.Printf("🗑️ Draining static VM %s released by %s, removed from environment %s", ip, entry.Holder, environment.Name)
+            recordEvent(client, pool, eventTypeNormal, reasonVMRemoved, fmt.Sprintf("vm=%s holder=%s released, removed from the pool", ip, entry.Holder))
+        default:
+            recordEvent(client, pool, eventTypeNormal, reasonVMRemoved, fmt.Sprintf("vm=%s removed from the pool config", ip))
+        }
+    }
+
+    staticVMs := make([]interface{}, len(environment.StaticVMs))
+    for i, ip := range environment.StaticVMs {
+        staticVMs[i] = ip
+    }
+    if current, _, _ := unstructured.NestedSlice(pool.Object, "spec", "staticVMs"); !reflect.DeepEqual(current, staticVMs) {
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "spec": map[string]interface{}{"staticVMs": staticVMs},
+        })
+        if _, err := client.Resource(staticVMPoolGVR).Namespace("default").Patch(
+            context.TODO(), pool.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+            log.Printf("⚠️ Could not update VMs of pool %s: %v", pool.GetName(), err)
+        }
+    }
+
+    // A merge patch replaces the list; null clears it once nothing drains
+    var status interface{}
+    if len(entries) > 0 {
+        status = entries
+    }
+    if !reflect.DeepEqual(previousEntries(previous), entries) {
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "status": map[string]interface{}{"draining": status},
+        })
+        if err := patchStatus(client, staticVMPoolGVR, "default", pool.GetName(), patchBytes); err != nil {
+            log.Printf("⚠️ Could not record draining VMs of pool %s: %v", pool.GetName(), err)
+        }
+    }
+    return entries
+}
+
+// Previous drains sorted by IP, in the shape drainPool builds
+func previousEntries(previous map[string]drainingVM) []drainingVM {
+    var entries []drainingVM
+    for _, entry := range previous {
+        entries = append(entries, entry)
+    }
+    sort.Slice(entries, func(i, j int) bool { return entries[i].IP < entries[j].IP })
+    return entries
+}
+
+// Reconcile drains every 30 seconds until ctx is done
+func RunStaticPoolDrains(ctx context.Context, client dynamic.Interface) {
+    reconcileStaticPoolDrains(client)
+    ticker := time.NewTicker(30 * time.Second)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            reconcileStaticPoolDrains(client)
+        }
+    }
+}
@@ -0,0 +1,94 @@
+// internal/pool_events.go - Allocation history of static VMs as Events on their environment's StaticVMPool
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Reasons of pool Events, stable so they can be filtered on
+const (
+    reasonVMAllocated          = "VMAllocated"
+    reasonVMReleased           = "VMReleased"
+    reasonVMQuarantined        = "VMQuarantined"
+    reasonVMReturnedToService  = "VMReturnedToService"
+    reasonVMSkipped            = "VMSkipped"
+    reasonVMProvisioningFailed = "VMProvisioningFailed"
+    reasonVMDraining           = "VMDraining"
+    reasonVMRemoved            = "VMRemoved"
+)
+
+// Object name of an environment's pool
+func staticVMPoolName(environment string) string {
+    name := strings.Trim(invalidNameChars.ReplaceAllString(strings.ToLower(environment), "-"), "-")
+    if len(name) > 253 {
+        name = name[:253]
+    }
+    return name
+}
+
+// Get the environment's StaticVMPool, creating it on first use. Its VM list is
+// kept in line with the environments file by the drain reconcile, which needs
+// the previous list to notice VMs removed while in use.
+func ensureStaticVMPool(client dynamic.Interface, environment vmEnvironment) (*unstructured.Unstructured, error) {
+    name := staticVMPoolName(environment.Name)
+    staticVMs := make([]interface{}, len(environment.StaticVMs))
+    for i, ip := range environment.StaticVMs {
+        staticVMs[i] = ip
+    }
+
+    pool, err := client.Resource(staticVMPoolGVR).Namespace("default").Get(context.TODO(), name, metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        pool = &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "training.example.com/v1",
+                "kind":       "StaticVMPool",
+                "metadata": map[string]interface{}{
+                    "name":      name,
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "spec": map[string]interface{}{
+                    "environment": environment.Name,
+                    "staticVMs":   staticVMs,
+                },
+            },
+        }
+        stampProvenance(pool)
+        return client.Resource(staticVMPoolGVR).Namespace("default").Create(context.TODO(), pool, metav1.CreateOptions{})
+    }
+    return pool, err
+}
+
+// Record an Event about the static VM at ip on its pool, so kubectl describe pool
+// shows what happened to each VM. Cloud instances have no pool and are skipped.
+// The message starts with vm=<ip> and holder=<object> for grepping.
+func recordPoolEvent(client dynamic.Interface, ip, eventType, reason, holder, format string, args ...interface{}) {
+    environment, found := environmentForIP(ip)
+    if !found || !apiAvailable(apiTraining) {
+        return
+    }
+    pool, err := ensureStaticVMPool(client, environment)
+    if err != nil {
+        log.Printf("⚠️ Could not record %s for VM %s, pool %s unavailable: %v", reason, ip, environment.Name, err)
+        return
+    }
+
+    message := fmt.Sprintf("vm=%s", ip)
+    if holder != "" {
+        message += " holder=" + holder
+    }
+    if format != "" {
+        message += " " + fmt.Sprintf(format, args...)
+    }
+    recordEvent(client, pool, eventType, reason, message)
+}
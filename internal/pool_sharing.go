@@ -0,0 +1,149 @@
+// internal/pool_sharing.go - Static pools shared between HobbyFarm sessions and other Kratix consumers, by reserved shares per time window
+package internal
+
+import (
+    "fmt"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Who a request is for: a HobbyFarm session, told apart by the integration's
+// source label, or a VMProvisioningRequest created directly by another Kratix consumer
+const (
+    consumerHobbyFarm = "hobbyfarm"
+    consumerExternal  = "external"
+)
+
+// Shares of an environment's static pool reserved while Hours hold, e.g.
+// {"hours": "Mon-Fri 08:00-19:00", "hobbyFarmPercent": 70} during classes. A
+// reserved share is one the other consumer can't take; what neither reserves is
+// first come, first served. Held VMs are never taken back, so a reserve only
+// grows as VMs are released.
+type poolShare struct {
+    // "<days> <HH:MM>-<HH:MM>" like SCALE_DOWN_BUSINESS_HOURS, in the environment's
+    // timezone; empty applies at all times
+    Hours            string `json:"hours,omitempty"`
+    HobbyFarmPercent int    `json:"hobbyFarmPercent,omitempty"`
+    ExternalPercent  int    `json:"externalPercent,omitempty"`
+}
+
+func requestConsumer(request *unstructured.Unstructured) string {
+    if IsHobbyFarmRequest(request) {
+        return consumerHobbyFarm
+    }
+    return consumerExternal
+}
+
+// The share in force at now: the first entry whose hours hold. An entry with
+// hours that don't parse never applies, validate reports it.
+func (env vmEnvironment) activePoolShare(now time.Time) (poolShare, bool) {
+    for _, share := range env.PoolSharing {
+        if share.Hours == "" {
+            return share, true
+        }
+        hours, err := parseBusinessHours(share.Hours)
+        if err != nil {
+            continue
+        }
+        location, err := scaleDownLocation(env.Name)
+        if err != nil {
+            continue
+        }
+        if hours.contains(now.In(location)) {
+            return share, true
+        }
+    }
+    return poolShare{}, false
+}
+
+// Static VMs consumer may hold out of poolSize: all of them but the other
+// consumer's reserve, rounded up in the reserve's favour
+func (share poolShare) limit(consumer string, poolSize int) int {
+    reservedPercent := share.HobbyFarmPercent
+    if consumer == consumerHobbyFarm {
+        reservedPercent = share.ExternalPercent
+    }
+    reserved := (poolSize*reservedPercent + 99) / 100
+    return poolSize - reserved
+}
+
+func (share poolShare) String() string {
+    description := fmt.Sprintf("%d%% reserved for HobbyFarm, %d%% for other consumers", share.HobbyFarmPercent, share.ExternalPercent)
+    if share.Hours != "" {
+        description += " (" + share.Hours + ")"
+    }
+    return description
+}
+
+// Static VMs held by each consumer per environment, from VMProvisioningRequests
+// holding one; TrainingVMs are HobbyFarm's own path and not shared
+type poolHolders map[string]map[string]int
+
+func collectPoolHolders(requests []unstructured.Unstructured) poolHolders {
+    holders := poolHolders{}
+    for i := range requests {
+        request := &requests[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        if vmIP == "" || vmType != "static" || !holdsVM(state) {
+            continue
+        }
+        holders.hold(getObjectEnvironment(request), requestConsumer(request))
+    }
+    return holders
+}
+
+func (holders poolHolders) hold(environment, consumer string) {
+    if holders[environment] == nil {
+        holders[environment] = map[string]int{}
+    }
+    holders[environment][consumer]++
+}
+
+// Why request may not take a static VM of environment now, or "" if it may.
+// Drained VMs don't count towards the pool a share is taken of.
+func poolSharingRefusal(environment vmEnvironment, request *unstructured.Unstructured, holders poolHolders, maintenance map[string]string) string {
+    share, active := environment.activePoolShare(time.Now())
+    if !active {
+        return ""
+    }
+    poolSize := 0
+    for _, ip := range environment.StaticVMs {
+        if _, drained := maintenance[ip]; !drained {
+            poolSize++
+        }
+    }
+    consumer := requestConsumer(request)
+    limit := share.limit(consumer, poolSize)
+    if held := holders[environment.Name][consumer]; held >= limit {
+        return fmt.Sprintf("%s requests hold %d of the %d static VMs of %s they may use, %s",
+            consumer, held, limit, environment.Name, share)
+    }
+    return ""
+}
+
+// Shares parse, fit in 100% and none hides the entries after it
+func validatePoolSharing(check *ConfigCheck) {
+    for name, environment := range loadVMEnvironments() {
+        for i, share := range environment.PoolSharing {
+            if share.HobbyFarmPercent < 0 || share.ExternalPercent < 0 || share.HobbyFarmPercent+share.ExternalPercent > 100 {
+                check.fail("environment %s: poolSharing[%d] reserves %d%% and %d%%, neither may be negative and together at most 100",
+                    name, i, share.HobbyFarmPercent, share.ExternalPercent)
+            }
+            if share.Hours == "" {
+                if i < len(environment.PoolSharing)-1 {
+                    check.warn("environment %s: poolSharing[%d] has no hours and applies at all times, later entries never do", name, i)
+                }
+                continue
+            }
+            if _, err := parseBusinessHours(share.Hours); err != nil {
+                check.fail("environment %s: poolSharing[%d]: %v", name, i, err)
+            }
+        }
+        if len(environment.PoolSharing) > 0 && len(environment.StaticVMs) == 0 {
+            check.warn("environment %s shares a static pool it has no VMs in", name)
+        }
+    }
+}
@@ -0,0 +1,154 @@
+// internal/provenance.go - Which build and configuration last wrote a resource, stamped on it and served on /version
+package internal
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "encoding/json"
+    "log"
+    "net/http"
+    "runtime"
+    "runtime/debug"
+    "sort"
+    "strings"
+    "sync"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Set at build time with -ldflags "-X hobbyfarm-vm-provisioner/internal.provisionerCommit=...",
+// else read from the VCS stamp go build leaves in binaries built from a checkout
+var provisionerCommit = ""
+
+// Annotations naming the build and configuration that last created or updated a resource
+const (
+    provisionerVersionAnnotation = "provisioning.hobbyfarm.io/provisioner-version"
+    provisionerCommitAnnotation  = "provisioning.hobbyfarm.io/provisioner-commit"
+    configHashAnnotation         = "provisioning.hobbyfarm.io/config-hash"
+)
+
+func provisionerGitSHA() string {
+    if provisionerCommit != "" {
+        return provisionerCommit
+    }
+    if info, ok := debug.ReadBuildInfo(); ok {
+        for _, setting := range info.Settings {
+            if setting.Key == "vcs.revision" {
+                return setting.Value
+            }
+        }
+    }
+    return "unknown"
+}
+
+// Hash of the settings in effect, from the environment and the configuration file
+// alike. Only settings that are set count, so a build adding a setting keeps the
+// hash of an unchanged configuration; secrets aren't settings and don't count.
+func configHash() string {
+    var lines []string
+    for _, setting := range configSettings {
+        if value := Setting(setting.env); value != "" {
+            lines = append(lines, setting.env+"="+value)
+        }
+    }
+    sort.Strings(lines)
+    sum := sha256.Sum256([]byte(strings.Join(lines, "\n")))
+    return hex.EncodeToString(sum[:])[:16]
+}
+
+func provenanceAnnotations() map[string]string {
+    return map[string]string{
+        provisionerVersionAnnotation: provisionerVersion,
+        provisionerCommitAnnotation:  provisionerGitSHA(),
+        configHashAnnotation:         configHash(),
+    }
+}
+
+// Stamp an object about to be created
+func stampProvenance(object *unstructured.Unstructured) {
+    annotations := object.GetAnnotations()
+    if annotations == nil {
+        annotations = map[string]string{}
+    }
+    for key, value := range provenanceAnnotations() {
+        annotations[key] = value
+    }
+    object.SetAnnotations(annotations)
+}
+
+// Objects this process stamped after updating them. Build and configuration don't
+// change while it runs, so an object is patched again only once its entry expires,
+// which also corrects a stamp another version wrote in between during a rollout.
+var provenanceStamped struct {
+    once  sync.Once
+    cache *trackingCache
+}
+
+// Stamp an object this process just updated, once per tracking cache TTL
+func markProvenance(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string) {
+    provenanceStamped.once.Do(func() { provenanceStamped.cache = newTrackingCache("provenanceStamped") })
+    key := gvr.Resource + "/" + namespace + "/" + name
+    if provenanceStamped.cache.has(key) {
+        return
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{"annotations": provenanceAnnotations()},
+    })
+    _, err := client.Resource(gvr).Namespace(namespace).Patch(
+        context.TODO(), name, types.MergePatchType, patchBytes, metav1.PatchOptions{FieldManager: vmFieldManager})
+    if err != nil {
+        if !errors.IsNotFound(err) {
+            log.Printf("⚠️ Could not record provisioner version on %s %s: %v", gvr.Resource, name, err)
+        }
+        return
+    }
+    provenanceStamped.cache.add(key)
+}
+
+// Build and configuration of this process
+type versionInfo struct {
+    Version    string `json:"version"`
+    Commit     string `json:"commit"`
+    ConfigHash string `json:"configHash"`
+    ConfigFile string `json:"configFile,omitempty"`
+    Component  string `json:"component"`
+    GoVersion  string `json:"goVersion"`
+}
+
+func currentVersionInfo() versionInfo {
+    configFile.RLock()
+    path := configFile.path
+    configFile.RUnlock()
+    return versionInfo{
+        Version:    provisionerVersion,
+        Commit:     provisionerGitSHA(),
+        ConfigHash: configHash(),
+        ConfigFile: path,
+        Component:  Component(),
+        GoVersion:  runtime.Version(),
+    }
+}
+
+// GET /version: compare with the annotations of a resource to tell which build
+// and configuration produced it
+func (ws *WebhookServer) versionHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(currentVersionInfo())
+}
+
+// Logged at startup, so logs of an incident name the build too
+func VersionSummary() string {
+    info := currentVersionInfo()
+    return "version " + info.Version + ", commit " + info.Commit + ", config " + info.ConfigHash
+}
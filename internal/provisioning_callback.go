@@ -0,0 +1,152 @@
+// internal/provisioning_callback.go - Token-authenticated "provisioning done" callback from the VM
+package internal
+
+import (
+    "context"
+    "crypto/rand"
+    "crypto/sha256"
+    "crypto/subtle"
+    "encoding/hex"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Final playbook that POSTs the completion callback
+const callbackPlaybook = "provisioning-callback.yaml"
+
+// URL of the /callback endpoint as seen from the VMs, e.g.
+// http://provisioner.example.com:8443/callback; empty disables callbacks
+func getCallbackURL() string {
+    return strings.TrimSpace(Setting("PROVISIONING_CALLBACK_URL"))
+}
+
+// Random per-run token; only its SHA256 is stored in the request status
+func newCallbackToken() (string, string, error) {
+    buf := make([]byte, 32)
+    if _, err := rand.Read(buf); err != nil {
+        return "", "", err
+    }
+    token := hex.EncodeToString(buf)
+    return token, hashCallbackToken(token), nil
+}
+
+func hashCallbackToken(token string) string {
+    sum := sha256.Sum256([]byte(token))
+    return hex.EncodeToString(sum[:])
+}
+
+// Issue a token for this provisioning run and append the callback playbook
+func (kc *KratixController) armProvisioningCallback(requestName string, config *ProvisioningConfig) error {
+    callbackURL := getCallbackURL()
+    if callbackURL == "" {
+        return nil
+    }
+
+    token, tokenHash, err := newCallbackToken()
+    if err != nil {
+        return fmt.Errorf("failed to generate callback token: %v", err)
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "callbackTokenHash": tokenHash,
+            "completedVia":      nil,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        return fmt.Errorf("failed to store callback token: %v", err)
+    }
+
+    if config.Variables == nil {
+        config.Variables = map[string]string{}
+    }
+    config.Variables["provisioning_callback_url"] = callbackURL
+    config.Variables["provisioning_callback_token"] = token
+    config.Variables["provisioning_request"] = requestName
+    config.Playbooks = append(config.Playbooks, callbackPlaybook)
+    return nil
+}
+
+// Invalidate the run's token once provisioning has finished either way
+func (kc *KratixController) clearProvisioningCallback(requestName string) {
+    if getCallbackURL() == "" {
+        return
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "callbackTokenHash": nil,
+        },
+    })
+    patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+}
+
+// Mark the request provisioned if the token matches the one issued for its current run;
+// it still has to pass the readiness gates before it is ready
+func completeRequestFromCallback(client dynamic.Interface, requestName, token string) error {
+    request, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        return fmt.Errorf("request %s not found", requestName)
+    }
+
+    tokenHash, _, _ := unstructured.NestedString(request.Object, "status", "callbackTokenHash")
+    if tokenHash == "" || subtle.ConstantTimeCompare([]byte(tokenHash), []byte(hashCallbackToken(token))) != 1 {
+        return fmt.Errorf("invalid callback token for request %s", requestName)
+    }
+
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    if state != "provisioning" {
+        return fmt.Errorf("request %s is %s, not provisioning", requestName, state)
+    }
+
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    conditions = mergeCondition(conditions, conditionProvisioned, true, "CallbackReceived", "VM reported provisioning done")
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "state":             stateProvisionedUnverified,
+            "provisioned":       true,
+            "conditions":        conditions,
+            "completedVia":      "callback",
+            "callbackTokenHash": nil,
+        },
+    })
+    return patchStatus(client, vmProvisioningRequestGVR, "default", requestName, patchBytes)
+}
+
+// POST /callback {"request": "<name>"} with "Authorization: Bearer <token>"
+func (ws *WebhookServer) callbackHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    token := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+    if token == "" || token == r.Header.Get("Authorization") {
+        http.Error(w, "bearer token is required", http.StatusUnauthorized)
+        return
+    }
+
+    var body struct {
+        Request string `json:"request"`
+    }
+    if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&body); err != nil || body.Request == "" {
+        http.Error(w, "request is required", http.StatusBadRequest)
+        return
+    }
+
+    if err := completeRequestFromCallback(ws.client, body.Request, token); err != nil {
+        log.Printf("⚠️ Rejected provisioning callback: %v", err)
+        http.Error(w, "callback rejected", http.StatusForbidden)
+        return
+    }
+
+    log.Printf("📣 VM reported provisioning done for request %s", body.Request)
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,150 @@
+// internal/provisioning_profiles.go - Provisioning changes for the sessions of one access code or ScheduledEvent
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "os"
+    "path/filepath"
+    "sort"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Label on requests naming the profile they were created with
+const provisioningProfileLabel = "hobbyfarm.io/provisioning-profile"
+
+// Changes to the Scenario's provisioning for the sessions of some access codes or
+// ScheduledEvents, e.g. a bigger instance and an extra playbook for one event
+type provisioningProfile struct {
+    Name string `json:"-"`
+    // Sessions started with one of these access codes get the profile
+    AccessCodes []string `json:"accessCodes,omitempty"`
+    // Sessions of one of these ScheduledEvents get the profile
+    ScheduledEvents []string `json:"scheduledEvents,omitempty"`
+    // Cloud instance type, overriding the template's
+    InstanceType string `json:"instanceType,omitempty"`
+    // Run after the Scenario's playbooks
+    Playbooks []string `json:"playbooks,omitempty"`
+    Packages  []string `json:"packages,omitempty"`
+    // Override the Scenario's variables of the same name
+    Variables map[string]string `json:"variables,omitempty"`
+}
+
+// Profiles file mounted from the provisioner ConfigMap, a JSON object keyed by profile name
+func getProvisioningProfilesFile() string {
+    if path := Setting("PROVISIONING_PROFILES_FILE"); path != "" {
+        return path
+    }
+    return "/etc/provisioner/profiles.json"
+}
+
+// Read on every call like the tenants file, so ConfigMap edits apply to the next session
+func loadProvisioningProfiles() map[string]provisioningProfile {
+    profiles := map[string]provisioningProfile{}
+    data, err := os.ReadFile(getProvisioningProfilesFile())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("⚠️ Could not read provisioning profiles file %s: %v", getProvisioningProfilesFile(), err)
+        }
+        return profiles
+    }
+    if err := json.Unmarshal(data, &profiles); err != nil {
+        log.Printf("⚠️ Ignoring invalid provisioning profiles file %s: %v", getProvisioningProfilesFile(), err)
+        return map[string]provisioningProfile{}
+    }
+    for name, profile := range profiles {
+        profile.Name = name
+        profiles[name] = profile
+    }
+    return profiles
+}
+
+// The ScheduledEvent handing out accessCode, empty when none does
+func scheduledEventForAccessCode(client dynamic.Interface, accessCode string) string {
+    if accessCode == "" {
+        return ""
+    }
+    events, err := client.Resource(scheduledEventGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return ""
+    }
+    for _, event := range events.Items {
+        if code, _, _ := unstructured.NestedString(event.Object, "spec", "access_code"); code == accessCode {
+            return event.GetName()
+        }
+    }
+    return ""
+}
+
+// The profile of a Session: one naming its ScheduledEvent wins over one naming its
+// access code, and profiles are tried in name order so the choice is stable
+func resolveSessionProfile(client dynamic.Interface, session *unstructured.Unstructured) (provisioningProfile, bool) {
+    profiles := loadProvisioningProfiles()
+    if len(profiles) == 0 {
+        return provisioningProfile{}, false
+    }
+    accessCode, _, _ := unstructured.NestedString(session.Object, "spec", "access_code")
+    scheduledEvent := scheduledEventForAccessCode(client, accessCode)
+
+    names := make([]string, 0, len(profiles))
+    for name := range profiles {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+
+    for _, keys := range []struct {
+        value string
+        of    func(provisioningProfile) []string
+    }{
+        {scheduledEvent, func(p provisioningProfile) []string { return p.ScheduledEvents }},
+        {accessCode, func(p provisioningProfile) []string { return p.AccessCodes }},
+    } {
+        if keys.value == "" {
+            continue
+        }
+        for _, name := range names {
+            for _, key := range keys.of(profiles[name]) {
+                if key == keys.value {
+                    return profiles[name], true
+                }
+            }
+        }
+    }
+    return provisioningProfile{}, false
+}
+
+// Apply a profile to the spec.provisioning and spec.cloudFallback of a new request
+func (profile provisioningProfile) apply(provisioning, cloudFallback map[string]interface{}) {
+    if playbooks, ok := provisioning["playbooks"].([]string); ok {
+        provisioning["playbooks"] = appendMissing(playbooks, profile.Playbooks)
+    }
+    if packages, ok := provisioning["packages"].([]string); ok {
+        provisioning["packages"] = appendMissing(packages, profile.Packages)
+    }
+    if variables, ok := provisioning["variables"].(map[string]string); ok {
+        for name, value := range profile.Variables {
+            variables[name] = value
+        }
+    }
+    if profile.InstanceType != "" {
+        cloudFallback["instanceType"] = profile.InstanceType
+    }
+}
+
+// Every profile is reachable from some session and runs playbooks that exist
+func validateProvisioningProfiles(check *ConfigCheck) {
+    for name, profile := range loadProvisioningProfiles() {
+        if len(profile.AccessCodes) == 0 && len(profile.ScheduledEvents) == 0 {
+            check.warn("profile %s names no access codes or ScheduledEvents, no session gets it", name)
+        }
+        for _, playbook := range profile.Playbooks {
+            if _, err := os.Stat(filepath.Join(defaultPlaybookPath, playbook)); err != nil {
+                check.fail("profile %s: playbook %s missing from %s", name, playbook, defaultPlaybookPath)
+            }
+        }
+    }
+}
@@ -0,0 +1,115 @@
+// internal/provisioning_summary.go - What a ready VM has installed, in words a learner reads
+package internal
+
+import (
+    "encoding/json"
+    "fmt"
+    "log"
+    "regexp"
+    "strings"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// How each toolchain command reports its version, and how a learner knows it
+var toolVersionCommands = map[string]struct {
+    command string
+    label   string
+}{
+    "docker":  {`docker --version`, "Docker"},
+    "kubectl": {`kubectl version --client 2>/dev/null | head -n 1`, "kubectl"},
+    "helm":    {`helm version --short`, "helm"},
+    "java":    {`java -version 2>&1 | head -n 1`, "Java"},
+}
+
+var toolVersionPattern = regexp.MustCompile(`\d+(\.\d+)+`)
+
+// Versions of the requested toolchain on the VM, keyed by command. Tools without a
+// readable version are left out rather than failing the VM, verification already
+// checked they are installed.
+func (ar *AnsibleRunner) detectToolVersions(vmIP, sshUser string, packages []string) (map[string]string, error) {
+    commands := toolchainCommands(packages)
+    if len(commands) == 0 {
+        return map[string]string{}, nil
+    }
+    var script []string
+    for _, command := range commands {
+        if tool, known := toolVersionCommands[command]; known {
+            script = append(script, fmt.Sprintf(`echo "%s=$(%s)"`, command, tool.command))
+        }
+    }
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, strings.Join(script, "\n"))
+    if err != nil {
+        return nil, fmt.Errorf("could not read tool versions: %v", err)
+    }
+
+    versions := map[string]string{}
+    for _, line := range strings.Split(string(output), "\n") {
+        command, reported, found := strings.Cut(line, "=")
+        if !found {
+            continue
+        }
+        if version := toolVersionPattern.FindString(reported); version != "" {
+            versions[command] = version
+        }
+    }
+    return versions, nil
+}
+
+// "Your VM has Docker 24.0.7, kubectl 1.29.0 and helm 3.14.0 installed", in the
+// order the packages were requested; empty when no toolchain was
+func buildProvisioningSummary(versions map[string]string, packages []string) string {
+    var tools []string
+    for _, command := range toolchainCommands(packages) {
+        label := toolVersionCommands[command].label
+        if label == "" {
+            label = command
+        }
+        if version := versions[command]; version != "" {
+            tools = append(tools, label+" "+version)
+        } else {
+            tools = append(tools, label)
+        }
+    }
+    switch len(tools) {
+    case 0:
+        return ""
+    case 1:
+        return fmt.Sprintf("Your VM has %s installed", tools[0])
+    }
+    return fmt.Sprintf("Your VM has %s and %s installed", strings.Join(tools[:len(tools)-1], ", "), tools[len(tools)-1])
+}
+
+// Record the tool versions and summary of a request that just passed its gates.
+// The integration copies the summary onto the Session for the scenario content.
+func (kc *KratixController) recordProvisioningSummary(request *unstructured.Unstructured) {
+    packages, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "packages")
+    if len(toolchainCommands(packages)) == 0 {
+        return
+    }
+    accessIP := getRequestAccessIP(request)
+    sshUser, err := kc.prober.DetectSSHUser(accessIP)
+    if err != nil {
+        log.Printf("⚠️ No provisioning summary for %s: %v", request.GetName(), err)
+        return
+    }
+    versions, err := kc.prober.DetectToolVersions(accessIP, sshUser, packages)
+    if err != nil {
+        log.Printf("⚠️ No provisioning summary for %s: %v", request.GetName(), err)
+        return
+    }
+
+    summary := buildProvisioningSummary(versions, packages)
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "toolVersions":        versions,
+            "provisioningSummary": summary,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", request.GetName(), patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record provisioning summary of %s: %v", request.GetName(), err)
+        return
+    }
+    log.Printf("📋 %s: %s", request.GetName(), summary)
+}
@@ -0,0 +1,230 @@
+// internal/rbac_check.go - Permissions the enabled features need, checked at startup and printed as RBAC manifests
+package internal
+
+import (
+    "context"
+    "fmt"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+var (
+    selfSubjectAccessReviewGVR = schema.GroupVersionResource{
+        Group:    "authorization.k8s.io",
+        Version:  "v1",
+        Resource: "selfsubjectaccessreviews",
+    }
+)
+
+// One API access a feature depends on. An empty Namespace means a cluster-scoped
+// resource or one used across all namespaces, which needs a ClusterRole.
+type Permission struct {
+    Feature     string
+    Namespace   string
+    GVR         schema.GroupVersionResource
+    Subresource string
+    Verbs       []string
+}
+
+func (permission Permission) resource() string {
+    if permission.Subresource != "" {
+        return permission.GVR.Resource + "/" + permission.Subresource
+    }
+    return permission.GVR.Resource
+}
+
+func (permission Permission) scope() string {
+    if permission.Namespace == "" {
+        return "cluster-wide"
+    }
+    return "in namespace " + permission.Namespace
+}
+
+func requires(feature, namespace string, gvr schema.GroupVersionResource, subresource string, verbs ...string) Permission {
+    return Permission{Feature: feature, Namespace: namespace, GVR: gvr, Subresource: subresource, Verbs: verbs}
+}
+
+// Permissions of the features this deployment's environment enables, following
+// the same switches main.go starts controllers by
+func RequiredPermissions() []Permission {
+    ns, hf := "default", hobbyFarmNamespace()
+
+    permissions := []Permission{
+        requires("core", ns, vmProvisioningRequestGVR, "", "get", "list", "create", "patch", "delete"),
+        requires("core", ns, vmProvisioningRequestGVR, "status", "patch"),
+        requires("core", ns, trainingVMGVR, "", "get", "list", "create", "patch", "delete"),
+        requires("core", ns, scenarioGVR, "", "get", "list", "watch"),
+        requires("core", ns, configMapGVR, "", "get", "create", "patch"),
+        requires("core", ns, secretGVR, "", "get", "delete", "deletecollection"),
+        requires("core", ns, eventGVR, "", "create"),
+        requires("core", ns, leaseGVR, "", "get", "create", "update", "patch", "delete"),
+        requires("core", ns, courseStatusGVR, "", "get", "list", "create", "delete"),
+        requires("core", ns, courseStatusGVR, "status", "patch"),
+        requires("core", ns, staticVMPoolGVR, "", "get", "list", "create", "patch"),
+        requires("core", ns, staticVMPoolGVR, "status", "patch"),
+        requires("core", ns, GetKratixPromiseGVR(), "", "list"),
+    }
+
+    if Setting("INTEGRATION_MODE") != "kratix-only" {
+        permissions = append(permissions,
+            requires("hobbyfarm", hf, sessionGVR, "", "get", "list", "patch"),
+            requires("hobbyfarm", hf, scenarioGVR, "", "get", "list", "watch"),
+            requires("hobbyfarm", hf, courseGVR, "", "get", "list", "watch"),
+            requires("hobbyfarm", hf, virtualMachineGVR, "", "list", "patch"),
+            requires("hobbyfarm", hf, virtualMachineGVR, "status", "patch"),
+        )
+    }
+
+    if Setting("ENABLE_EC2_FALLBACK") != "false" {
+        permissions = append(permissions,
+            requires("cloud", ns, ec2TrainingVMGVR, "", "get", "list", "create", "patch", "delete"),
+            requires("cloud", "", ec2InstanceGVR, "", "list"),
+            requires("cloud", "", ec2KeyPairGVR, "", "get", "create"),
+            requires("cloud", "", awsProviderConfigGVR, "", "get"),
+        )
+    }
+
+    if Setting("ENABLE_WEBHOOK") == "true" {
+        permissions = append(permissions,
+            requires("webhook", "", virtualMachineClaimGVR, "", "list", "patch"),
+            requires("webhook", "", virtualMachineClaimGVR, "status", "patch"),
+            requires("webhook", "", virtualMachineGVR, "", "patch"),
+            requires("webhook", "", webhookVMRequestGVR, "", "create"),
+        )
+        if adminEnabled() {
+            permissions = append(permissions,
+                requires("admin", "", tokenReviewGVR, "", "create"),
+                requires("admin", "", subjectAccessReviewGVR, "", "create"),
+            )
+        }
+    }
+
+    if stateSnapshotsEnabled() {
+        permissions = append(permissions,
+            requires("state", ns, configMapGVR, "", "list"),
+            requires("state", ns, staticVMPoolGVR, "", "list"),
+            requires("state", ns, eventGVR, "", "list"),
+        )
+    }
+
+    if getVMDNSDomain() != "" {
+        permissions = append(permissions,
+            requires("vm-dns", ns, dnsEndpointGVR, "", "get", "create", "patch", "delete"))
+    }
+    return permissions
+}
+
+// Ask the API server whether the provisioner's own identity may do it
+func canI(client dynamic.Interface, permission Permission, verb string) (bool, error) {
+    review := &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": "authorization.k8s.io/v1",
+            "kind":       "SelfSubjectAccessReview",
+            "spec": map[string]interface{}{
+                "resourceAttributes": map[string]interface{}{
+                    "namespace":   permission.Namespace,
+                    "verb":        verb,
+                    "group":       permission.GVR.Group,
+                    "resource":    permission.GVR.Resource,
+                    "subresource": permission.Subresource,
+                },
+            },
+        },
+    }
+    result, err := client.Resource(selfSubjectAccessReviewGVR).Create(context.TODO(), review, metav1.CreateOptions{})
+    if err != nil {
+        return false, err
+    }
+    allowed, _, _ := unstructured.NestedBool(result.Object, "status", "allowed")
+    return allowed, nil
+}
+
+// Every required verb the provisioner is not allowed, one line each
+func validatePermissions(client dynamic.Interface, check *ConfigCheck) {
+    for _, permission := range RequiredPermissions() {
+        var missing []string
+        for _, verb := range permission.Verbs {
+            allowed, err := canI(client, permission, verb)
+            if err != nil {
+                check.warn("could not check permissions: %v", err)
+                return
+            }
+            if !allowed {
+                missing = append(missing, verb)
+            }
+        }
+        if len(missing) > 0 {
+            check.fail("%s: cannot %s %s.%s %s", permission.Feature, strings.Join(missing, ", "),
+                permission.resource(), permission.GVR.Group, permission.scope())
+        }
+    }
+}
+
+// RBAC rule lines of one Role or ClusterRole, verbs merged per group and resource
+func rbacRules(permissions []Permission) string {
+    verbs := map[[2]string]map[string]bool{}
+    var keys [][2]string
+    for _, permission := range permissions {
+        key := [2]string{permission.GVR.Group, permission.resource()}
+        if verbs[key] == nil {
+            verbs[key] = map[string]bool{}
+            keys = append(keys, key)
+        }
+        for _, verb := range permission.Verbs {
+            verbs[key][verb] = true
+        }
+    }
+    sort.Slice(keys, func(i, j int) bool {
+        if keys[i][0] != keys[j][0] {
+            return keys[i][0] < keys[j][0]
+        }
+        return keys[i][1] < keys[j][1]
+    })
+
+    var rules strings.Builder
+    for _, key := range keys {
+        var names []string
+        for verb := range verbs[key] {
+            names = append(names, fmt.Sprintf("%q", verb))
+        }
+        sort.Strings(names)
+        fmt.Fprintf(&rules, "- apiGroups: [%q]\n  resources: [%q]\n  verbs: [%s]\n", key[0], key[1], strings.Join(names, ", "))
+    }
+    return rules.String()
+}
+
+// Minimal RBAC for the enabled features: a Role and RoleBinding per namespace the
+// provisioner works in, and a ClusterRole for cluster-scoped and cross-namespace access
+func GenerateRBACManifests(serviceAccount, serviceAccountNamespace string) string {
+    byNamespace := map[string][]Permission{}
+    for _, permission := range RequiredPermissions() {
+        byNamespace[permission.Namespace] = append(byNamespace[permission.Namespace], permission)
+    }
+    var namespaces []string
+    for namespace := range byNamespace {
+        namespaces = append(namespaces, namespace)
+    }
+    sort.Strings(namespaces)
+
+    var documents []string
+    for _, namespace := range namespaces {
+        kind, metadata := "Role", fmt.Sprintf("  name: hobbyfarm-provisioner\n  namespace: %s\n", namespace)
+        if namespace == "" {
+            kind, metadata = "ClusterRole", "  name: hobbyfarm-provisioner\n"
+        }
+        documents = append(documents, fmt.Sprintf(
+            "apiVersion: rbac.authorization.k8s.io/v1\nkind: %s\nmetadata:\n%s  labels:\n    app: hobbyfarm-provisioner\nrules:\n%s",
+            kind, metadata, rbacRules(byNamespace[namespace])))
+        documents = append(documents, fmt.Sprintf(
+            "apiVersion: rbac.authorization.k8s.io/v1\nkind: %sBinding\nmetadata:\n%s  labels:\n    app: hobbyfarm-provisioner\n"+
+                "roleRef:\n  apiGroup: rbac.authorization.k8s.io\n  kind: %s\n  name: hobbyfarm-provisioner\n"+
+                "subjects:\n- kind: ServiceAccount\n  name: %s\n  namespace: %s\n",
+            kind, metadata, kind, serviceAccount, serviceAccountNamespace))
+    }
+    return strings.Join(documents, "---\n")
+}
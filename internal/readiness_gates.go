@@ -0,0 +1,272 @@
+// internal/readiness_gates.go - Verify a provisioned VM and probe its shell before HobbyFarm sees it as ready
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net"
+    "net/url"
+    "strconv"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Playbooks finished but the VM has not passed its readiness gates yet
+const stateProvisionedUnverified = "provisioned-unverified"
+
+// Condition types written to status.conditions
+const (
+    conditionProvisioned = "Provisioned"
+    conditionVerified    = "Verified"
+    conditionShellReady  = "ShellReady"
+)
+
+// How long a provisioned VM may keep failing its gates before the request fails
+func getReadinessGateTimeout() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("READINESS_GATE_TIMEOUT_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 10 * time.Minute
+}
+
+// Commands the requested special packages must have put on the PATH
+func toolchainCommands(packages []string) []string {
+    var commands []string
+    seen := map[string]bool{}
+    for _, pkg := range packages {
+        command := ""
+        switch {
+        case pkg == "docker" || pkg == "docker.io":
+            command = "docker"
+        case pkg == "kubectl" || pkg == "helm":
+            command = pkg
+        case pkg == "java" || strings.HasPrefix(pkg, "openjdk"):
+            command = "java"
+        }
+        if command != "" && !seen[command] {
+            seen[command] = true
+            commands = append(commands, command)
+        }
+    }
+    return commands
+}
+
+// Check that nothing is still installing: cloud-init is done, no package manager is
+// running, and the requested toolchain is on the PATH. The first failing check is returned.
+func (ar *AnsibleRunner) verifyProvisioning(vmIP, sshUser string, packages []string) error {
+    script := []string{
+        `if command -v cloud-init >/dev/null 2>&1 && cloud-init status 2>/dev/null | grep -q running; then echo "cloud-init still running"; exit 1; fi`,
+        `if pgrep -x 'apt|apt-get|dpkg|dnf|yum|unattended-upgr' >/dev/null 2>&1; then echo "package manager still running"; exit 1; fi`,
+        `test -d "$HOME/workspace" || { echo "workspace directory missing"; exit 1; }`,
+    }
+    for _, command := range toolchainCommands(packages) {
+        script = append(script, fmt.Sprintf(`command -v %s >/dev/null 2>&1 || { echo "%s not installed"; exit 1; }`, command, command))
+    }
+
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, strings.Join(script, "\n"))
+    if err != nil {
+        if reason := strings.TrimSpace(string(output)); reason != "" {
+            return fmt.Errorf("%s", reason)
+        }
+        return fmt.Errorf("verification could not run: %v", err)
+    }
+    return nil
+}
+
+// Log in the way the HobbyFarm shell will, as the ssh_username the VM is given, and
+// check the shell endpoint accepts connections
+func (ar *AnsibleRunner) probeShell(vmIP string, env vmEnvironment) error {
+    sshUser := expectedSSHUser(ar.client, env, vmIP)
+    if _, err := ar.ssh.Output(sshUser, vmIP, 10*time.Second, "true"); err != nil {
+        return fmt.Errorf("SSH login as %s failed: %v", sshUser, err)
+    }
+
+    if env.WSEndpoint == "" {
+        return nil
+    }
+    endpoint, err := url.Parse(env.WSEndpoint)
+    if err != nil {
+        return fmt.Errorf("invalid ws_endpoint %s: %v", env.WSEndpoint, err)
+    }
+    host := endpoint.Host
+    if endpoint.Port() == "" {
+        port := "80"
+        if endpoint.Scheme == "wss" || endpoint.Scheme == "https" {
+            port = "443"
+        }
+        host = net.JoinHostPort(endpoint.Hostname(), port)
+    }
+    conn, err := net.DialTimeout("tcp", host, 5*time.Second)
+    if err != nil {
+        return fmt.Errorf("shell endpoint %s unreachable: %v", env.WSEndpoint, err)
+    }
+    conn.Close()
+    return nil
+}
+
+// Run both gates; returns the condition that failed along with the error
+func (ar *AnsibleRunner) checkReadinessGates(vmIP string, env vmEnvironment, packages []string) (string, error) {
+    if env.SSHUser == "" {
+        env = builtinDefaultEnvironment()
+    }
+
+    sshUser, err := ar.detectSSHUser(vmIP)
+    if err != nil {
+        return conditionVerified, err
+    }
+    if err := ar.verifyProvisioning(vmIP, sshUser, packages); err != nil {
+        return conditionVerified, err
+    }
+    if err := ar.probeShell(vmIP, env); err != nil {
+        return conditionShellReady, err
+    }
+    return "", nil
+}
+
+// Replace the condition of the given type, keeping its transition time if the status is unchanged
+func mergeCondition(conditions []interface{}, conditionType string, ok bool, reason, message string) []interface{} {
+    status := "False"
+    if ok {
+        status = "True"
+    }
+    condition := map[string]interface{}{
+        "type":               conditionType,
+        "status":             status,
+        "reason":             reason,
+        "message":            message,
+        "lastTransitionTime": time.Now().Format(time.RFC3339),
+    }
+
+    merged := make([]interface{}, 0, len(conditions)+1)
+    replaced := false
+    for _, c := range conditions {
+        existing, _ := c.(map[string]interface{})
+        if existing == nil || existing["type"] != conditionType {
+            merged = append(merged, c)
+            continue
+        }
+        if existing["status"] == status {
+            condition["lastTransitionTime"] = existing["lastTransitionTime"]
+        }
+        merged = append(merged, condition)
+        replaced = true
+    }
+    if !replaced {
+        merged = append(merged, condition)
+    }
+    return merged
+}
+
+// When the Provisioned condition last became true
+func provisionedSince(request *unstructured.Unstructured) (time.Time, bool) {
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    for _, c := range conditions {
+        condition, _ := c.(map[string]interface{})
+        if condition != nil && condition["type"] == conditionProvisioned && condition["status"] == "True" {
+            since, err := time.Parse(time.RFC3339, fmt.Sprint(condition["lastTransitionTime"]))
+            return since, err == nil
+        }
+    }
+    return time.Time{}, false
+}
+
+// Playbooks are done: hold the request in provisioned-unverified until the gates pass
+func (kc *KratixController) markProvisionedUnverified(requestName, vmIP string) {
+    request, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        return
+    }
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    conditions = mergeCondition(conditions, conditionProvisioned, true, "PlaybooksSucceeded", "all playbooks completed")
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "state":       stateProvisionedUnverified,
+            "vmIP":        vmIP,
+            "provisioned": true,
+            "conditions":  conditions,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to mark %s provisioned-unverified: %v", requestName, err)
+    }
+}
+
+// Gate every provisioned-unverified request
+func (kc *KratixController) checkReadinessGates() {
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        if state, _, _ := unstructured.NestedString(request.Object, "status", "state"); state == stateProvisionedUnverified && ownsRequest(request) {
+            kc.gateRequestReadiness(request)
+        }
+    }
+}
+
+// Promote a request to ready once its gates pass; fail it if they keep failing past the timeout
+func (kc *KratixController) gateRequestReadiness(request *unstructured.Unstructured) {
+    requestName := request.GetName()
+    accessIP := getRequestAccessIP(request)
+    env, _ := getVMEnvironment(getObjectEnvironment(request))
+    packages, _, _ := unstructured.NestedStringSlice(request.Object, "spec", "provisioning", "packages")
+
+    conditions, _, _ := unstructured.NestedSlice(request.Object, "status", "conditions")
+    failedGate, gateErr := kc.prober.CheckReadinessGates(accessIP, env, packages)
+
+    status := map[string]interface{}{}
+    switch failedGate {
+    case "":
+        conditions = mergeCondition(conditions, conditionVerified, true, "VerificationPassed", "toolchain installed")
+        conditions = mergeCondition(conditions, conditionShellReady, true, "ShellReachable", "shell login succeeded")
+        status["state"] = "ready"
+        for field, value := range kc.readyStatus(request, time.Now()) {
+            status[field] = value
+        }
+        log.Printf("✅ VM %s passed readiness gates for request %s", accessIP, requestName)
+    case conditionVerified:
+        conditions = mergeCondition(conditions, conditionVerified, false, "VerificationFailed", gateErr.Error())
+    default:
+        conditions = mergeCondition(conditions, conditionVerified, true, "VerificationPassed", "toolchain installed")
+        conditions = mergeCondition(conditions, conditionShellReady, false, "ShellUnreachable", gateErr.Error())
+    }
+
+    if failedGate != "" {
+        log.Printf("⏳ Request %s is provisioned-unverified, %s gate failed: %v", requestName, failedGate, gateErr)
+        if since, ok := provisionedSince(request); ok && time.Since(since) > getReadinessGateTimeout() {
+            status["state"] = "failed"
+            status["lastError"] = fmt.Sprintf("readiness gate %s failed: %v", failedGate, gateErr)
+            status["failedAt"] = time.Now().Format(time.RFC3339)
+            status["failureReason"] = failureUnreachable
+            if failedGate == conditionVerified {
+                status["failureReason"] = failureVerificationFailed
+            }
+            log.Printf("❌ Request %s failed its readiness gates for %v", requestName, getReadinessGateTimeout())
+            vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            recordPoolEvent(kc.client, vmIP, eventTypeWarning, reasonVMProvisioningFailed, "VMProvisioningRequest/"+requestName,
+                "readiness gate %s failed: %v", failedGate, gateErr)
+        }
+    }
+    status["conditions"] = conditions
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record readiness gates of %s: %v", requestName, err)
+        return
+    }
+    if state, changed := status["state"].(string); changed {
+        if state == "ready" {
+            kc.recordProvisioningSummary(request)
+            kc.recordExposedEndpoints(request)
+        }
+        kc.fireRequestHooks(requestName, state)
+        publishStage(stageStatus, requestName)
+    }
+}
@@ -0,0 +1,339 @@
+// internal/ready_slo.go - Time to ready across retries and the daily share of VMs ready within the target
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// A VM meets the SLO when it is ready this long after its first allocation
+func getReadySLOTarget() time.Duration {
+    if target, err := time.ParseDuration(Setting("READY_SLO_TARGET")); err == nil && target > 0 {
+        return target
+    }
+    return 3 * time.Minute
+}
+
+// Share of VMs that must meet the target each day
+func getReadySLOObjective() float64 {
+    if objective, err := strconv.ParseFloat(Setting("READY_SLO_OBJECTIVE"), 64); err == nil && objective > 0 && objective <= 1 {
+        return objective
+    }
+    return 0.95
+}
+
+// Days kept in the ConfigMap and reported
+func getReadySLODays() int {
+    if days, err := strconv.Atoi(Setting("READY_SLO_DAYS")); err == nil && days > 0 {
+        return days
+    }
+    return 14
+}
+
+// Daily counts survive restarts and deleted requests in a ConfigMap, keyed
+// <YYYY-MM-DD>.ready and <YYYY-MM-DD>.withinTarget
+func getReadySLOConfigMapName() string {
+    if name := Setting("READY_SLO_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-ready-slo"
+}
+
+type readySLODay struct {
+    Ready        int64 `json:"ready"`
+    WithinTarget int64 `json:"withinTarget"`
+}
+
+var readySLOCounts = struct {
+    sync.Mutex
+    loaded bool
+    days   map[string]*readySLODay
+}{days: map[string]*readySLODay{}}
+
+// Daily counts as stored in the ConfigMap, none before the first ready VM
+func readReadySLODays(client dynamic.Interface) (map[string]*readySLODay, error) {
+    days := map[string]*readySLODay{}
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(
+        context.TODO(), getReadySLOConfigMapName(), metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        return days, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    for dataKey, value := range data {
+        day, field, found := strings.Cut(dataKey, ".")
+        count, err := strconv.ParseInt(value, 10, 64)
+        if !found || err != nil {
+            continue
+        }
+        if days[day] == nil {
+            days[day] = &readySLODay{}
+        }
+        switch field {
+        case "ready":
+            days[day].Ready = count
+        case "withinTarget":
+            days[day].WithinTarget = count
+        }
+    }
+    return days, nil
+}
+
+// Called with readySLOCounts held
+func loadReadySLOCounts(client dynamic.Interface) {
+    if readySLOCounts.loaded {
+        return
+    }
+    days, err := readReadySLODays(client)
+    if err != nil {
+        log.Printf("⚠️ Could not read ready SLO counts: %v", err)
+        return
+    }
+    readySLOCounts.days = days
+    readySLOCounts.loaded = true
+}
+
+// Write a day's counts and drop the days past retention; null removes a key in a merge patch
+func saveReadySLODay(client dynamic.Interface, day string, counts *readySLODay, expired []string) error {
+    data := map[string]interface{}{
+        day + ".ready":        strconv.FormatInt(counts.Ready, 10),
+        day + ".withinTarget": strconv.FormatInt(counts.WithinTarget, 10),
+    }
+    for _, old := range expired {
+        data[old+".ready"] = nil
+        data[old+".withinTarget"] = nil
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"data": data})
+
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), getReadySLOConfigMapName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsNotFound(err) {
+        configMap := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "v1",
+                "kind":       "ConfigMap",
+                "metadata": map[string]interface{}{
+                    "name":      getReadySLOConfigMapName(),
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "data": map[string]interface{}{
+                    day + ".ready":        data[day+".ready"],
+                    day + ".withinTarget": data[day+".withinTarget"],
+                },
+            },
+        }
+        _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+    }
+    return err
+}
+
+// Count a VM that became ready on readyAt's day (UTC)
+func recordReadySLO(client dynamic.Interface, readyAt time.Time, timeToReady time.Duration) {
+    day := readyAt.UTC().Format("2006-01-02")
+    cutoff := readyAt.UTC().AddDate(0, 0, -getReadySLODays()).Format("2006-01-02")
+
+    readySLOCounts.Lock()
+    defer readySLOCounts.Unlock()
+    loadReadySLOCounts(client)
+    counts := readySLOCounts.days[day]
+    if counts == nil {
+        counts = &readySLODay{}
+        readySLOCounts.days[day] = counts
+    }
+    counts.Ready++
+    if timeToReady <= getReadySLOTarget() {
+        counts.WithinTarget++
+    }
+    var expired []string
+    for old := range readySLOCounts.days {
+        if old <= cutoff {
+            expired = append(expired, old)
+            delete(readySLOCounts.days, old)
+        }
+    }
+    if err := saveReadySLODay(client, day, counts, expired); err != nil {
+        log.Printf("⚠️ Could not persist ready SLO counts: %v", err)
+    }
+}
+
+// Status fields of a request's first allocation; nothing once it has one, so
+// retries keep measuring from the first VM
+func firstAllocationStatus(request *unstructured.Unstructured, now time.Time) map[string]interface{} {
+    if firstAllocatedAt, _, _ := unstructured.NestedString(request.Object, "status", "firstAllocatedAt"); firstAllocatedAt != "" {
+        return nil
+    }
+    status := map[string]interface{}{"firstAllocatedAt": now.Format(time.RFC3339)}
+    if attempts, _, _ := unstructured.NestedInt64(request.Object, "status", "attempts"); attempts == 0 {
+        status["attempts"] = 1
+    }
+    return status
+}
+
+// Record the first allocation of a request whose VM is on its way
+func (kc *KratixController) recordFirstAllocation(request *unstructured.Unstructured) {
+    status := firstAllocationStatus(request, time.Now())
+    if status == nil {
+        return
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    patchStatus(kc.client, vmProvisioningRequestGVR, "default", request.GetName(), patchBytes)
+}
+
+// Status fields of a request sent back for another attempt. A request that was
+// ready got its VM; moving it starts a new measurement instead of a retry.
+func retryStatus(client dynamic.Interface, requestName string) map[string]interface{} {
+    request, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        return map[string]interface{}{}
+    }
+    if _, wasReady, _ := unstructured.NestedInt64(request.Object, "status", "timeToReadySeconds"); wasReady {
+        return map[string]interface{}{
+            "firstAllocatedAt":   nil,
+            "attempts":           nil,
+            "timeToReadySeconds": nil,
+        }
+    }
+    attempts, _, _ := unstructured.NestedInt64(request.Object, "status", "attempts")
+    if attempts < 1 {
+        attempts = 1
+    }
+    return map[string]interface{}{"attempts": attempts + 1}
+}
+
+// Time from the first allocation to now, falling back to allocatedAt and the
+// request's creation for requests allocated before firstAllocatedAt was recorded
+func timeToReady(request *unstructured.Unstructured, now time.Time) time.Duration {
+    start := request.GetCreationTimestamp().Time
+    for _, field := range []string{"allocatedAt", "firstAllocatedAt"} {
+        if value, _, _ := unstructured.NestedString(request.Object, "status", field); value != "" {
+            if t, err := time.Parse(time.RFC3339, value); err == nil {
+                start = t
+            }
+        }
+    }
+    if start.IsZero() || now.Before(start) {
+        return 0
+    }
+    return now.Sub(start)
+}
+
+// Status fields of a request turning ready, counted towards the SLO
+func (kc *KratixController) readyStatus(request *unstructured.Unstructured, now time.Time) map[string]interface{} {
+    elapsed := timeToReady(request, now)
+    recordReadySLO(kc.client, now, elapsed)
+    attempts, _, _ := unstructured.NestedInt64(request.Object, "status", "attempts")
+    log.Printf("⏱️ Request %s ready %s after its first allocation (%d attempts)", request.GetName(), elapsed.Round(time.Second), attempts)
+    return map[string]interface{}{
+        "readyAt":            now.Format(time.RFC3339),
+        "timeToReadySeconds": int64(elapsed.Seconds()),
+    }
+}
+
+// SLO attainment by day, newest first, for /stats
+type readySLOReport struct {
+    TargetSeconds int64               `json:"targetSeconds"`
+    Objective     float64             `json:"objective"`
+    Days          []readySLODayReport `json:"days"`
+}
+
+type readySLODayReport struct {
+    Day          string  `json:"day"`
+    Ready        int64   `json:"ready"`
+    WithinTarget int64   `json:"withinTarget"`
+    Attainment   float64 `json:"attainment"`
+    Met          bool    `json:"met"`
+}
+
+func currentReadySLO(client dynamic.Interface) readySLOReport {
+    report := readySLOReport{
+        TargetSeconds: int64(getReadySLOTarget().Seconds()),
+        Objective:     getReadySLOObjective(),
+        Days:          []readySLODayReport{},
+    }
+    // Read from the ConfigMap so every replica reports what the leader counted
+    days, err := readReadySLODays(client)
+    if err != nil {
+        log.Printf("⚠️ Could not read ready SLO counts: %v", err)
+    }
+    for day, counts := range days {
+        if counts.Ready == 0 {
+            continue
+        }
+        attainment := float64(counts.WithinTarget) / float64(counts.Ready)
+        report.Days = append(report.Days, readySLODayReport{
+            Day:          day,
+            Ready:        counts.Ready,
+            WithinTarget: counts.WithinTarget,
+            Attainment:   attainment,
+            Met:          attainment >= report.Objective,
+        })
+    }
+    sort.Slice(report.Days, func(i, j int) bool { return report.Days[i].Day > report.Days[j].Day })
+    return report
+}
+
+// Per-day ready counts and attainment against the target
+func writeReadySLOMetrics(w io.Writer, client dynamic.Interface) {
+    report := currentReadySLO(client)
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ready_slo_target_seconds Time from first allocation to ready a VM must stay under")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ready_slo_target_seconds gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_ready_slo_target_seconds %d\n", report.TargetSeconds)
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ready_slo_objective Share of VMs per day that must be ready within the target")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ready_slo_objective gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_ready_slo_objective %g\n", report.Objective)
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ready_requests VMProvisioningRequests that became ready, by UTC day")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ready_requests gauge")
+    for _, day := range report.Days {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_ready_requests{day=%q} %d\n", day.Day, day.Ready)
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ready_within_target_requests VMProvisioningRequests ready within the SLO target, by UTC day")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ready_within_target_requests gauge")
+    for _, day := range report.Days {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_ready_within_target_requests{day=%q} %d\n", day.Day, day.WithinTarget)
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ready_slo_attainment Share of the day's VMs ready within the SLO target")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ready_slo_attainment gauge")
+    for _, day := range report.Days {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_ready_slo_attainment{day=%q} %g\n", day.Day, day.Attainment)
+    }
+}
+
+// Settings that don't parse fall back to the defaults; say so instead of
+// reporting against a target nobody chose
+func validateReadySLO(check *ConfigCheck) {
+    if value := Setting("READY_SLO_TARGET"); value != "" {
+        if target, err := time.ParseDuration(value); err != nil || target <= 0 {
+            check.warn("READY_SLO_TARGET: %q is not a positive duration such as 3m, using %s", value, getReadySLOTarget())
+        }
+    }
+    if value := Setting("READY_SLO_OBJECTIVE"); value != "" {
+        if objective, err := strconv.ParseFloat(value, 64); err != nil || objective <= 0 || objective > 1 {
+            check.warn("READY_SLO_OBJECTIVE: %q is not a share between 0 and 1 such as 0.95, using %g", value, getReadySLOObjective())
+        }
+    }
+    if value := Setting("READY_SLO_DAYS"); value != "" {
+        if days, err := strconv.Atoi(value); err != nil || days <= 0 {
+            check.warn("READY_SLO_DAYS: %q is not a positive number of days, using %d", value, getReadySLODays())
+        }
+    }
+}
@@ -0,0 +1,146 @@
+// internal/reallocation.go - Release and reallocate a session's VM for live incident handling
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Find the VMProvisioningRequest serving a HobbyFarm session
+func findRequestForSession(client dynamic.Interface, sessionName string) (*unstructured.Unstructured, error) {
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: sessionLabelSelector(sessionName),
+    })
+    if err != nil {
+        return nil, err
+    }
+    if len(requests.Items) > 0 {
+        return &requests.Items[0], nil
+    }
+
+    // Requests created outside the integration are named after the session
+    return client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestNameForSession(sessionName), metav1.GetOptions{})
+}
+
+// Release the session's current VM and send its request back through allocation.
+// The Kratix controller then allocates (static or cloud), reprovisions, and the
+// integration re-points the HobbyFarm VirtualMachine once the new VM is ready.
+// With drain set, a static VM is put into maintenance so it isn't picked again.
+func ReallocateSession(client dynamic.Interface, sessionName string, drain bool) (string, error) {
+    request, err := findRequestForSession(client, sessionName)
+    if err != nil {
+        return "", fmt.Errorf("no VMProvisioningRequest for session %s: %v", sessionName, err)
+    }
+
+    requestName := request.GetName()
+    oldIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+
+    // There is no other machine to move an adopted VM's session to
+    if vmType == vmTypeAdopted {
+        return "", fmt.Errorf("session %s runs on adopted VM %s, which can't be reallocated", sessionName, oldIP)
+    }
+    
+    log.Printf("🚑 Reallocating session %s (request %s, current VM %s)", sessionName, requestName, oldIP)
+
+    if drain && oldIP != "" && IsStaticVMIP(oldIP) {
+        if err := setVMMaintenance(client, oldIP, fmt.Sprintf("drained by reallocation of session %s", sessionName)); err != nil {
+            return "", err
+        }
+    }
+
+    // Cloud instances are not reused; delete so a fresh one can be created
+    if vmType == "ec2" {
+        if instance, err := findCloudInstanceForRequest(client, requestName); err == nil && instance != nil {
+            if err := client.Resource(ec2TrainingVMGVR).Namespace("default").Delete(
+                context.TODO(), instance.GetName(), metav1.DeleteOptions{}); err != nil {
+                return "", fmt.Errorf("failed to delete cloud instance %s: %v", instance.GetName(), err)
+            }
+            log.Printf("🗑️ Deleted cloud instance %s of session %s", instance.GetName(), sessionName)
+        }
+    }
+
+    if err := resetRequestToPending(client, requestName, 0); err != nil {
+        return "", err
+    }
+    clearDeadLetter(client, request)
+
+    log.Printf("✅ Released VM %s from session %s, request %s is pending reallocation", oldIP, sessionName, requestName)
+    return oldIP, nil
+}
+
+// Send a request back through allocation; null removes the field in a merge patch.
+// retryCount is the automatic retries so far, 0 when an operator sends it back.
+func resetRequestToPending(client dynamic.Interface, requestName string, retryCount int64) error {
+    status := map[string]interface{}{
+        "state":               "pending",
+        "provisioned":         false,
+        "vmIP":                nil,
+        "vmType":              nil,
+        "instanceId":          nil,
+        "availabilityZone":    nil,
+        "region":              nil,
+        "consoleURL":          nil,
+        "overlayIP":           nil,
+        "allocatedAt":         nil,
+        "readyAt":             nil,
+        "lastError":           nil,
+        "failureReason":       nil,
+        "failedAt":            nil,
+        "deadLetteredAt":      nil,
+        "retryCount":          nil,
+        "playbookResults":     nil,
+        "playbookProgress":    nil,
+        "toolVersions":        nil,
+        "provisioningSummary": nil,
+        "exposedEndpoints":    nil,
+        "conditions":          nil,
+    }
+    if retryCount > 0 {
+        status["retryCount"] = retryCount
+    }
+    // Counted as another attempt of the same request
+    for field, value := range retryStatus(client, requestName) {
+        status[field] = value
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        return fmt.Errorf("failed to reset request %s: %v", requestName, err)
+    }
+    return nil
+}
+
+// POST /reallocate?session=<name>[&drain=true], on the admin listener
+func (ws *WebhookServer) reallocateHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    sessionName := r.URL.Query().Get("session")
+    if sessionName == "" {
+        http.Error(w, "session is required", http.StatusBadRequest)
+        return
+    }
+
+    releasedIP, err := ReallocateSession(ws.client, sessionName, r.URL.Query().Get("drain") == "true")
+    if err != nil {
+        log.Printf("❌ Reallocation of session %s failed: %v", sessionName, err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "session":    sessionName,
+        "releasedIP": releasedIP,
+        "state":      "pending",
+    })
+}
@@ -0,0 +1,266 @@
+// internal/request_hooks.go - Webhooks and commands fired when a request becomes ready or fails
+package internal
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "strings"
+    "text/template"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+const (
+    hookEventReady  = "ready"
+    hookEventFailed = "failed"
+)
+
+// An external system told about requests, e.g. an LMS, a chat bot or a grader.
+// Either URL or Command is set; Payload and Command arguments are Go templates
+// over requestHookEvent, e.g. {"text": "{{.User}} is ready on {{.VMIP}}"}.
+type requestHook struct {
+    Name string `json:"name"`
+    // ready, failed or both; empty means both
+    Events []string `json:"events,omitempty"`
+    URL    string   `json:"url,omitempty"`
+    Method string   `json:"method,omitempty"`
+    // Values are expanded from the environment, so tokens can come from a Secret
+    Headers map[string]string `json:"headers,omitempty"`
+    // Request body, the event as JSON when empty
+    Payload string   `json:"payload,omitempty"`
+    Command []string `json:"command,omitempty"`
+}
+
+// What a hook is told about a request
+type requestHookEvent struct {
+    Event         string `json:"event"`
+    Request       string `json:"request"`
+    User          string `json:"user"`
+    Session       string `json:"session"`
+    Scenario      string `json:"scenario"`
+    Environment   string `json:"environment"`
+    VMIP          string `json:"vmIP"`
+    FailureReason string `json:"failureReason,omitempty"`
+    Message       string `json:"message,omitempty"`
+    Timestamp     string `json:"timestamp"`
+}
+
+// Hooks file mounted from the provisioner ConfigMap, a JSON list of hooks
+func getRequestHooksFile() string {
+    if path := Setting("REQUEST_HOOKS_FILE"); path != "" {
+        return path
+    }
+    return "/etc/provisioner/hooks.json"
+}
+
+// How long one hook may take before it is abandoned
+func getRequestHookTimeout() time.Duration {
+    if d, err := time.ParseDuration(Setting("REQUEST_HOOK_TIMEOUT")); err == nil && d > 0 {
+        return d
+    }
+    return 10 * time.Second
+}
+
+// Read on every event like the other ConfigMap files, so edits apply without a restart
+func loadRequestHooks() []requestHook {
+    var hooks []requestHook
+    data, err := os.ReadFile(getRequestHooksFile())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("⚠️ Could not read request hooks file %s: %v", getRequestHooksFile(), err)
+        }
+        return nil
+    }
+    if err := json.Unmarshal(data, &hooks); err != nil {
+        log.Printf("⚠️ Ignoring invalid request hooks file %s: %v", getRequestHooksFile(), err)
+        return nil
+    }
+    return hooks
+}
+
+func (hook requestHook) firesOn(event string) bool {
+    if len(hook.Events) == 0 {
+        return true
+    }
+    for _, e := range hook.Events {
+        if e == event {
+            return true
+        }
+    }
+    return false
+}
+
+// Templates may quote values for a JSON payload with {{json .User}}
+var hookTemplateFuncs = template.FuncMap{
+    "json": func(value interface{}) (string, error) {
+        data, err := json.Marshal(value)
+        return string(data), err
+    },
+}
+
+func renderHookTemplate(name, text string, event requestHookEvent) (string, error) {
+    tmpl, err := template.New(name).Funcs(hookTemplateFuncs).Option("missingkey=error").Parse(text)
+    if err != nil {
+        return "", err
+    }
+    var out bytes.Buffer
+    if err := tmpl.Execute(&out, event); err != nil {
+        return "", err
+    }
+    return out.String(), nil
+}
+
+// The event of a request as it is now
+func newRequestHookEvent(event string, request *unstructured.Unstructured) requestHookEvent {
+    user, _, _ := unstructured.NestedString(request.Object, "spec", "user")
+    session := GetHobbyFarmSessionFromRequest(request)
+    if session == "" {
+        session, _, _ = unstructured.NestedString(request.Object, "spec", "session")
+    }
+    scenario, _, _ := unstructured.NestedString(request.Object, "spec", "scenario")
+    failureReason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+    message, _, _ := unstructured.NestedString(request.Object, "status", "lastError")
+    return requestHookEvent{
+        Event:         event,
+        Request:       request.GetName(),
+        User:          user,
+        Session:       session,
+        Scenario:      scenario,
+        Environment:   getObjectEnvironment(request),
+        VMIP:          getRequestAccessIP(request),
+        FailureReason: failureReason,
+        Message:       message,
+        Timestamp:     time.Now().Format(time.RFC3339),
+    }
+}
+
+// Fire the hooks of event for a request in the background, so a slow LMS never
+// holds up provisioning. Failures are logged, hooks are not retried.
+func (kc *KratixController) fireRequestHooks(requestName, event string) {
+    hooks := loadRequestHooks()
+    if len(hooks) == 0 {
+        return
+    }
+    request, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        log.Printf("⚠️ Request hooks of %s not fired: %v", requestName, err)
+        return
+    }
+    hookEvent := newRequestHookEvent(event, request)
+
+    for _, hook := range hooks {
+        if !hook.firesOn(event) {
+            continue
+        }
+        go func(hook requestHook) {
+            var err error
+            if hook.URL != "" {
+                err = postRequestHook(hook, hookEvent)
+            } else {
+                err = kc.runRequestHook(hook, hookEvent)
+            }
+            if err != nil {
+                log.Printf("⚠️ Hook %s failed for %s of request %s: %v", hook.Name, event, requestName, err)
+                return
+            }
+            log.Printf("🪝 Hook %s notified of %s of request %s", hook.Name, event, requestName)
+        }(hook)
+    }
+}
+
+func postRequestHook(hook requestHook, event requestHookEvent) error {
+    var body []byte
+    if hook.Payload == "" {
+        body, _ = json.Marshal(event)
+    } else {
+        payload, err := renderHookTemplate(hook.Name, hook.Payload, event)
+        if err != nil {
+            return err
+        }
+        body = []byte(payload)
+    }
+    method := hook.Method
+    if method == "" {
+        method = http.MethodPost
+    }
+
+    req, err := http.NewRequest(method, hook.URL, bytes.NewReader(body))
+    if err != nil {
+        return err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    for key, value := range hook.Headers {
+        req.Header.Set(key, os.ExpandEnv(value))
+    }
+    resp, err := outboundClient(getRequestHookTimeout()).Do(req)
+    if err != nil {
+        return err
+    }
+    defer resp.Body.Close()
+    if resp.StatusCode >= 300 {
+        return fmt.Errorf("%s answered %s", hook.URL, resp.Status)
+    }
+    return nil
+}
+
+// Run a command hook through the controller's executor, with the event also in
+// HOOK_* environment variables
+func (kc *KratixController) runRequestHook(hook requestHook, event requestHookEvent) error {
+    if len(hook.Command) == 0 {
+        return fmt.Errorf("hook has neither url nor command")
+    }
+    args := make([]string, len(hook.Command))
+    for i, arg := range hook.Command {
+        rendered, err := renderHookTemplate(hook.Name, arg, event)
+        if err != nil {
+            return err
+        }
+        args[i] = rendered
+    }
+    env := []string{
+        "HOOK_EVENT=" + event.Event,
+        "HOOK_REQUEST=" + event.Request,
+        "HOOK_USER=" + event.User,
+        "HOOK_SESSION=" + event.Session,
+        "HOOK_SCENARIO=" + event.Scenario,
+        "HOOK_ENVIRONMENT=" + event.Environment,
+        "HOOK_VM_IP=" + event.VMIP,
+        "HOOK_FAILURE_REASON=" + event.FailureReason,
+    }
+    name := "timeout"
+    args = append([]string{fmt.Sprintf("%ds", int(getRequestHookTimeout().Seconds())), args[0]}, args[1:]...)
+    if output, err := kc.executor.CombinedOutput(env, name, args...); err != nil {
+        return fmt.Errorf("%v: %s", err, strings.TrimSpace(string(output)))
+    }
+    return nil
+}
+
+// Every hook has a target and templates that parse
+func validateRequestHooks(check *ConfigCheck) {
+    for i, hook := range loadRequestHooks() {
+        name := hook.Name
+        if name == "" {
+            name = fmt.Sprintf("#%d", i+1)
+        }
+        if (hook.URL == "") == (len(hook.Command) == 0) {
+            check.fail("hook %s: set exactly one of url and command", name)
+        }
+        for _, event := range hook.Events {
+            if event != hookEventReady && event != hookEventFailed {
+                check.fail("hook %s: unknown event %q, expected ready or failed", name, event)
+            }
+        }
+        for _, text := range append([]string{hook.Payload}, hook.Command...) {
+            if _, err := renderHookTemplate(name, text, requestHookEvent{}); err != nil {
+                check.fail("hook %s: %v", name, err)
+            }
+        }
+    }
+}
@@ -0,0 +1,137 @@
+// internal/request_loss.go - Hand a HobbyFarm VirtualMachine back to HobbyFarm when the request behind it is deleted or dead-lettered
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+)
+
+// The VMProvisioningRequest whose VM a HobbyFarm VirtualMachine was made ready with
+const vmRequestAnnotation = "provisioning.hobbyfarm.io/request"
+
+// What becomes of a ready VirtualMachine that lost its request while its session
+// goes on, from VM_ON_REQUEST_LOSS: reset puts it back to readyforprovisioning so
+// the integration provisions it again, taint has HobbyFarm replace it, off leaves it.
+const (
+    requestLossReset = "reset"
+    requestLossTaint = "taint"
+    requestLossOff   = "off"
+)
+
+func getRequestLossAction() string {
+    switch action := Setting("VM_ON_REQUEST_LOSS"); action {
+    case requestLossTaint, requestLossOff:
+        return action
+    }
+    return requestLossReset
+}
+
+// Metadata linking a VirtualMachine to the request and session it was made ready
+// for, merged into the ready label patch
+func vmRequestLink(requestName, sessionName string) map[string]interface{} {
+    return map[string]interface{}{
+        vmRequestAnnotation: requestName,
+        sessionAnnotation:   sessionName,
+    }
+}
+
+// Find VirtualMachines whose request is gone or dead-lettered and hand them back,
+// so the learner isn't left on a ready entry for a VM nobody provisions any more.
+// VMs of ended sessions are left to HobbyFarm's own teardown.
+func (hki *HobbyFarmKratixIntegration) propagateRequestLoss() {
+    action := getRequestLossAction()
+    if action == requestLossOff {
+        return
+    }
+    vms, err := hki.client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    requests, err := hki.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    sessions, err := hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    byName := make(map[string]*unstructured.Unstructured, len(requests.Items))
+    for i := range requests.Items {
+        byName[requests.Items[i].GetName()] = &requests.Items[i]
+    }
+    activeSessions := make(map[string]bool, len(sessions.Items))
+    for _, session := range sessions.Items {
+        activeSessions[session.GetName()] = true
+    }
+
+    for i := range vms.Items {
+        vm := &vms.Items[i]
+        requestName := vm.GetAnnotations()[vmRequestAnnotation]
+        session := objectSession(vm)
+        if requestName == "" || !activeSessions[session] || !ownsSession(session) {
+            continue
+        }
+        why := ""
+        if request, exists := byName[requestName]; !exists {
+            why = "was deleted"
+        } else if isDeadLettered(request) {
+            why = "failed permanently"
+        } else {
+            continue
+        }
+        if err := hki.handBackVirtualMachine(vm, action); err != nil {
+            log.Printf("⚠️ Could not hand VirtualMachine %s back to HobbyFarm after request %s %s: %v", vm.GetName(), requestName, why, err)
+            continue
+        }
+        // A re-driven request publishes its VM again, even on the same address
+        hki.updatedVMs.retain(func(updateKey string) bool { return !strings.HasPrefix(updateKey, requestName+"/") })
+        // A reset VM of a deleted request gets a new request on the next pass over sessions
+        if action == requestLossReset {
+            hki.processedSessions.remove(sessionTrackingKey(hobbyFarmNamespace(), session))
+        }
+        log.Printf("↩️ Request %s %s, VirtualMachine %s handed back to HobbyFarm (%s)", requestName, why, vm.GetName(), action)
+        recordEvent(hki.client, vm, eventTypeWarning, "RequestLost", fmt.Sprintf(
+            "VMProvisioningRequest %s %s; VirtualMachine handed back to HobbyFarm (%s)", requestName, why, action))
+    }
+}
+
+// Reset or taint vm and drop its request link, so it is handed back once
+func (hki *HobbyFarmKratixIntegration) handBackVirtualMachine(vm *unstructured.Unstructured, action string) error {
+    vmName := vm.GetName()
+    if action == requestLossTaint {
+        // tainted is HobbyFarm's own field, written here on the operator's choice
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "status": map[string]interface{}{"tainted": true},
+        })
+        if err := patchStatus(hki.client, virtualMachineGVR, vm.GetNamespace(), vmName, patchBytes); err != nil {
+            return err
+        }
+    } else {
+        if err := patchVirtualMachineStatus(hki.client, vm.GetNamespace(), vmName, map[string]interface{}{
+            "status":     "readyforprovisioning",
+            "public_ip":  "",
+            "private_ip": "",
+            "hostname":   "",
+        }); err != nil {
+            return err
+        }
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "labels":      map[string]interface{}{"ready": "false"},
+            "annotations": map[string]interface{}{vmRequestAnnotation: nil},
+        },
+    })
+    _, err := hki.client.Resource(virtualMachineGVR).Namespace(vm.GetNamespace()).Patch(
+        context.TODO(), vmName, types.MergePatchType, patchBytes, metav1.PatchOptions{FieldManager: vmFieldManager})
+    return err
+}
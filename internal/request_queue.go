@@ -0,0 +1,195 @@
+// internal/request_queue.go - Queue position and estimated wait for requests waiting on capacity
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Session duration assumed until released requests give a real average
+func getDefaultSessionDuration() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("QUEUE_DEFAULT_SESSION_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 45 * time.Minute
+}
+
+// Average time between two RFC3339 status timestamps over all requests that have both
+func averageStatusInterval(requests []unstructured.Unstructured, from, to string) (time.Duration, bool) {
+    var total time.Duration
+    count := 0
+    for _, request := range requests {
+        fromStr, _, _ := unstructured.NestedString(request.Object, "status", from)
+        toStr, _, _ := unstructured.NestedString(request.Object, "status", to)
+        start, err1 := time.Parse(time.RFC3339, fromStr)
+        end, err2 := time.Parse(time.RFC3339, toStr)
+        if err1 != nil || err2 != nil || !end.After(start) {
+            continue
+        }
+        total += end.Sub(start)
+        count++
+    }
+    if count == 0 {
+        return 0, false
+    }
+    return total / time.Duration(count), true
+}
+
+// Estimate the wait of the request at a 1-based queue position. Every pool VM frees up
+// once per average session and running sessions are on average halfway done, so the
+// n-th waiter gets a VM after ceil(n/poolSize) rounds minus half a session, then
+// still needs the average provisioning time.
+func estimateQueueWait(position, poolSize int, avgSession, avgProvisioning time.Duration) time.Duration {
+    if poolSize < 1 {
+        poolSize = 1
+    }
+    rounds := (position-1)/poolSize + 1
+    return time.Duration(rounds)*avgSession - avgSession/2 + avgProvisioning
+}
+
+// Requests waiting for capacity: pending, no VM and no cloud instance on the way
+func collectQueuedRequests(requests []unstructured.Unstructured, cloudRequests map[string]bool) []*unstructured.Unstructured {
+    var queued []*unstructured.Unstructured
+    for i := range requests {
+        request := &requests[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        if state != "pending" || vmIP != "" || cloudRequests[request.GetName()] {
+            continue
+        }
+        queued = append(queued, request)
+    }
+
+    // First come, first served
+    sort.SliceStable(queued, func(i, j int) bool {
+        return queued[i].GetCreationTimestamp().Time.Before(queued[j].GetCreationTimestamp().Time)
+    })
+    return queued
+}
+
+// Write queuePosition, estimatedWaitSeconds and estimatedReadyAt into waiting requests
+// and clear them from requests that left the queue
+func (kc *KratixController) updateRequestQueue() {
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    cloudRequests := make(map[string]bool)
+    if instances, err := kc.client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: "kratix-request",
+    }); err == nil {
+        for _, instance := range instances.Items {
+            cloudRequests[instance.GetLabels()["kratix-request"]] = true
+        }
+    }
+
+    avgSession, ok := averageStatusInterval(requests.Items, "allocatedAt", "releasedAt")
+    if !ok {
+        avgSession = getDefaultSessionDuration()
+    }
+    avgProvisioning, _ := averageStatusInterval(requests.Items, "allocatedAt", "readyAt")
+
+    // Each environment queues for its own static pool
+    poolSizes := make(map[string]int)
+    maintenance := getMaintenanceVMs(kc.client)
+    for name, environment := range loadVMEnvironments() {
+        for _, ip := range environment.StaticVMs {
+            if _, drained := maintenance[ip]; !drained {
+                poolSizes[name]++
+            }
+        }
+    }
+
+    queued := collectQueuedRequests(requests.Items, cloudRequests)
+    inQueue := make(map[string]bool, len(queued))
+    environmentPositions := make(map[string]int)
+
+    for _, request := range queued {
+        requestName := request.GetName()
+        inQueue[requestName] = true
+
+        environment := getObjectEnvironment(request)
+        environmentPositions[environment]++
+        position := int64(environmentPositions[environment])
+        wait := int64(estimateQueueWait(int(position), poolSizes[environment], avgSession, avgProvisioning).Seconds())
+
+        currentPosition, _, _ := unstructured.NestedInt64(request.Object, "status", "queuePosition")
+        currentWait, _, _ := unstructured.NestedInt64(request.Object, "status", "estimatedWaitSeconds")
+        if currentPosition == position && currentWait == wait {
+            continue
+        }
+
+        kc.patchQueueStatus(requestName, map[string]interface{}{
+            "queuePosition":        position,
+            "estimatedWaitSeconds": wait,
+            "estimatedReadyAt":     time.Now().Add(time.Duration(wait) * time.Second).Format(time.RFC3339),
+        })
+        log.Printf("⏳ Request %s is #%d in queue for environment %s, estimated wait %v", requestName, position, environment, time.Duration(wait)*time.Second)
+    }
+
+    for _, request := range requests.Items {
+        if inQueue[request.GetName()] {
+            continue
+        }
+        if _, found, _ := unstructured.NestedFieldNoCopy(request.Object, "status", "queuePosition"); found {
+            kc.patchQueueStatus(request.GetName(), map[string]interface{}{
+                "queuePosition":        nil,
+                "estimatedWaitSeconds": nil,
+                "estimatedReadyAt":     nil,
+            })
+        }
+    }
+}
+
+func (kc *KratixController) patchQueueStatus(requestName string, fields map[string]interface{}) {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": fields,
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to update queue status of %s: %v", requestName, err)
+    }
+}
+
+// GET /queue?session=<name> reports the session's queue position and ETA for the frontend
+func (ws *WebhookServer) queueHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    sessionName := r.URL.Query().Get("session")
+    if sessionName == "" {
+        http.Error(w, "session is required", http.StatusBadRequest)
+        return
+    }
+
+    request, err := findRequestForSession(ws.client, sessionName)
+    if err != nil {
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    }
+
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    queuePosition, _, _ := unstructured.NestedInt64(request.Object, "status", "queuePosition")
+    estimatedWait, _, _ := unstructured.NestedInt64(request.Object, "status", "estimatedWaitSeconds")
+    estimatedReadyAt, _, _ := unstructured.NestedString(request.Object, "status", "estimatedReadyAt")
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]interface{}{
+        "session":              sessionName,
+        "request":              request.GetName(),
+        "state":                state,
+        "queuePosition":        queuePosition,
+        "estimatedWaitSeconds": estimatedWait,
+        "estimatedReadyAt":     estimatedReadyAt,
+    })
+}
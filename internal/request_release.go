@@ -0,0 +1,179 @@
+// internal/request_release.go - Release one request on demand, and delete released requests once their audit retention is over
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "net/http"
+    "strconv"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// On a request, asks the deprovision controller to release it; the value says why,
+// e.g. kubectl annotate vmprovisioningrequest alice-k8s provisioning.hobbyfarm.io/release="broken disk"
+const releaseAnnotation = "provisioning.hobbyfarm.io/release"
+
+var errAlreadyReleased = errors.New("already released")
+
+// Days a released request is kept for auditing before it is deleted; 0 keeps
+// released requests until their session is deleted
+func getReleasedRetention() time.Duration {
+    if days, err := strconv.Atoi(Setting("RELEASED_RETENTION_DAYS")); err == nil && days >= 0 {
+        return time.Duration(days) * 24 * time.Hour
+    }
+    return 7 * 24 * time.Hour
+}
+
+// Give back the VM of one request, whatever its session's state: the same cleanup
+// as when the session finishes, then the request is kept as released with
+// releasedAt and the IP returns to the pool. The session keeps running without a
+// VM until it is reallocated or ends.
+func ReleaseRequest(client dynamic.Interface, requestName, note string) (string, error) {
+    request, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err != nil {
+        return "", err
+    }
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    if state == provisioner.StateReleased {
+        return "", fmt.Errorf("request %s is %w", requestName, errAlreadyReleased)
+    }
+
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    sessionName := bulkSessionName(request)
+    log.Printf("🔓 Releasing VM %s of request %s (session %s): %s", vmIP, requestName, sessionName, note)
+
+    dc := NewDeprovisionController(client)
+    dc.teardownRequestVM(request, sessionName)
+    if err := dc.releaseRequest(requestName, provisioner.ReleaseRequested); err != nil {
+        return "", fmt.Errorf("failed to release request %s: %v", requestName, err)
+    }
+    message := fmt.Sprintf("VM %s released", vmIP)
+    if note != "" {
+        message += ": " + note
+    }
+    recordEvent(client, request, eventTypeNormal, "Released", message)
+    return vmIP, nil
+}
+
+// Release the requests carrying the release annotation, then drop it so a request
+// sent back through allocation later isn't released again
+func (dc *DeprovisionController) releaseAnnotatedRequests() {
+    requests, err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        note, annotated := request.GetAnnotations()[releaseAnnotation]
+        if !annotated || !ownsRequest(request) {
+            continue
+        }
+        if note == "" {
+            note = "release annotation"
+        }
+        if _, err := ReleaseRequest(dc.client, request.GetName(), note); err != nil && !errors.Is(err, errAlreadyReleased) {
+            log.Printf("❌ Failed to release annotated request %s: %v", request.GetName(), err)
+            continue
+        }
+        patch := []byte(fmt.Sprintf(`{"metadata":{"annotations":{%q:null}}}`, releaseAnnotation))
+        if _, err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
+            context.TODO(), request.GetName(), types.MergePatchType, patch, metav1.PatchOptions{}); err != nil {
+            log.Printf("⚠️ Could not remove the release annotation of %s: %v", request.GetName(), err)
+        }
+    }
+}
+
+// Delete released requests past the retention period. A request whose session is
+// still running is kept: it would be recreated for the session otherwise.
+// Dead-lettered requests stay until an operator deletes them.
+func (dc *DeprovisionController) pruneReleasedRequests(finishedSessions map[string]bool) {
+    retention := getReleasedRetention()
+    if retention == 0 {
+        return
+    }
+    requests, err := dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state != provisioner.StateReleased || isDeadLettered(request) || !ownsRequest(request) {
+            continue
+        }
+        releasedAt, _, _ := unstructured.NestedString(request.Object, "status", "releasedAt")
+        released, err := time.Parse(time.RFC3339, releasedAt)
+        if err != nil || time.Since(released) < retention {
+            continue
+        }
+        sessionName := GetHobbyFarmSessionFromRequest(request)
+        if sessionName == "" {
+            sessionName, _, _ = unstructured.NestedString(request.Object, "spec", "session")
+        }
+        if finished, exists := finishedSessions[sessionName]; exists && !finished {
+            continue
+        }
+
+        err = dc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Delete(context.TODO(), request.GetName(), metav1.DeleteOptions{})
+        if err != nil && !apierrors.IsNotFound(err) {
+            log.Printf("⚠️ Failed to delete request %s released %s: %v", request.GetName(), releasedAt, err)
+            continue
+        }
+        log.Printf("🗑️ Deleted request %s, released %s and past its %v retention", request.GetName(), releasedAt, retention)
+    }
+}
+
+// POST /release?request=<name> or ?session=<name>, with an optional &note=<why>, on the admin listener
+func (ws *WebhookServer) releaseHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    query := r.URL.Query()
+    requestName := query.Get("request")
+    if session := query.Get("session"); requestName == "" && session != "" {
+        request, err := findRequestForSession(ws.client, session)
+        if err != nil {
+            http.Error(w, fmt.Sprintf("no VMProvisioningRequest for session %s: %v", session, err), http.StatusNotFound)
+            return
+        }
+        requestName = request.GetName()
+    }
+    if requestName == "" {
+        http.Error(w, "request or session is required", http.StatusBadRequest)
+        return
+    }
+
+    releasedIP, err := ReleaseRequest(ws.client, requestName, query.Get("note"))
+    switch {
+    case apierrors.IsNotFound(err):
+        http.Error(w, err.Error(), http.StatusNotFound)
+        return
+    case errors.Is(err, errAlreadyReleased):
+        http.Error(w, err.Error(), http.StatusConflict)
+        return
+    case err != nil:
+        log.Printf("❌ Release of request %s failed: %v", requestName, err)
+        http.Error(w, err.Error(), http.StatusInternalServerError)
+        return
+    }
+
+    w.Header().Set("Content-Type", "application/json")
+    json.NewEncoder(w).Encode(map[string]string{
+        "request":    requestName,
+        "releasedIP": releasedIP,
+        "state":      provisioner.StateReleased,
+    })
+}
@@ -0,0 +1,168 @@
+// internal/request_schema.go - VMProvisioningRequest JSON schema with allowed values, for portal-generated forms
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "os"
+    "path/filepath"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Well-known path developer portals (Backstage, custom IDPs) fetch the schema from
+const requestSchemaPath = "/.well-known/vm-provisioning-request.schema.json"
+
+// Promise whose API defines VMProvisioningRequest
+const vmProvisioningPromiseName = "vm-provisioning"
+
+// Playbooks the provisioner runs itself and that scenarios cannot select
+var internalPlaybooks = map[string]bool{
+    dataVolumesPlaybook: true,
+    callbackPlaybook:    true,
+    "overlay-join.yaml": true,
+    sessionTimePlaybook: true,
+}
+
+// Playbooks shipped in the playbook directory that requests may list
+func listRequestablePlaybooks() []string {
+    entries, err := os.ReadDir(defaultPlaybookPath)
+    if err != nil {
+        log.Printf("⚠️ Could not list playbooks in %s: %v", defaultPlaybookPath, err)
+        return []string{}
+    }
+
+    playbooks := []string{}
+    for _, entry := range entries {
+        name := entry.Name()
+        ext := filepath.Ext(name)
+        if entry.IsDir() || (ext != ".yaml" && ext != ".yml") || internalPlaybooks[name] {
+            continue
+        }
+        playbooks = append(playbooks, name)
+    }
+    sort.Strings(playbooks)
+    return playbooks
+}
+
+// Values a request form should offer, taken from the running configuration
+func requestAllowedValues() map[string]interface{} {
+    template := loadCloudInstanceTemplate()
+
+    instanceTypes := []string{template.InstanceType}
+    for _, instanceType := range getFallbackInstanceTypes() {
+        if instanceType != template.InstanceType {
+            instanceTypes = append(instanceTypes, instanceType)
+        }
+    }
+
+    environments := []string{}
+    for name := range loadVMEnvironments() {
+        environments = append(environments, name)
+    }
+    sort.Strings(environments)
+
+    return map[string]interface{}{
+        "playbooks":     listRequestablePlaybooks(),
+        "providers":     []string{"aws"},
+        "instanceTypes": instanceTypes,
+        "regions":       []string{template.Region},
+        "environments":  environments,
+        "maxVolumeGiB":  getCloudMaxVolumeSize(),
+    }
+}
+
+// The openAPIV3Schema of the Promise's served storage version
+func loadRequestSchema(client dynamic.Interface) (map[string]interface{}, error) {
+    promise, err := client.Resource(GetKratixPromiseGVR()).Get(context.TODO(), vmProvisioningPromiseName, metav1.GetOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to read Promise %s: %v", vmProvisioningPromiseName, err)
+    }
+
+    versions, _, _ := unstructured.NestedSlice(promise.Object, "spec", "api", "spec", "versions")
+    for _, v := range versions {
+        version, ok := v.(map[string]interface{})
+        if !ok {
+            continue
+        }
+        if storage, _, _ := unstructured.NestedBool(version, "storage"); !storage {
+            continue
+        }
+        schema, found, _ := unstructured.NestedMap(version, "schema", "openAPIV3Schema")
+        if found {
+            return schema, nil
+        }
+    }
+    return nil, fmt.Errorf("Promise %s has no openAPIV3Schema", vmProvisioningPromiseName)
+}
+
+// Restrict spec fields to the allowed values and drop the provisioner-owned status
+func buildRequestSchema(schema map[string]interface{}, allowed map[string]interface{}) map[string]interface{} {
+    unstructured.RemoveNestedField(schema, "properties", "status")
+
+    setEnum := func(values interface{}, path ...string) {
+        fieldPath := []string{"properties", "spec"}
+        for _, field := range path {
+            fieldPath = append(fieldPath, "properties", field)
+        }
+        if _, found, _ := unstructured.NestedMap(schema, fieldPath...); !found {
+            return
+        }
+        if path[len(path)-1] == "playbooks" {
+            fieldPath = append(fieldPath, "items")
+        }
+        unstructured.SetNestedField(schema, toInterfaceSlice(values), append(fieldPath, "enum")...)
+    }
+    setEnum(allowed["playbooks"], "provisioning", "playbooks")
+    setEnum(allowed["providers"], "cloudFallback", "provider")
+    setEnum(allowed["instanceTypes"], "cloudFallback", "instanceType")
+    setEnum(allowed["regions"], "cloudFallback", "region")
+    setEnum(allowed["environments"], "environment")
+
+    schema["$schema"] = "https://json-schema.org/draft/2020-12/schema"
+    schema["title"] = "VMProvisioningRequest"
+    schema["x-allowed-values"] = allowed
+    return schema
+}
+
+func toInterfaceSlice(values interface{}) []interface{} {
+    strs, _ := values.([]string)
+    out := make([]interface{}, len(strs))
+    for i, value := range strs {
+        out[i] = value
+    }
+    return out
+}
+
+// GET the request schema; ?values=only returns just the allowed values
+func (ws *WebhookServer) requestSchemaHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    allowed := requestAllowedValues()
+    var body interface{} = allowed
+    contentType := "application/json"
+    if !strings.EqualFold(r.URL.Query().Get("values"), "only") {
+        schema, err := loadRequestSchema(ws.client)
+        if err != nil {
+            log.Printf("⚠️ Request schema unavailable: %v", err)
+            http.Error(w, "schema unavailable", http.StatusServiceUnavailable)
+            return
+        }
+        body = buildRequestSchema(schema, allowed)
+        contentType = "application/schema+json"
+    }
+
+    w.Header().Set("Content-Type", contentType)
+    w.Header().Set("Cache-Control", "max-age=60")
+    json.NewEncoder(w).Encode(body)
+}
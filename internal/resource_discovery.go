@@ -0,0 +1,266 @@
+// internal/resource_discovery.go - Periodic discovery of Sessions, VMs and requests, logging only what changed
+package internal
+
+import (
+    "context"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/client-go/dynamic"
+)
+
+// LOG_LEVEL=debug adds per-object detail to logs that otherwise only summarize
+func debugLogging() bool {
+    return strings.EqualFold(Setting("LOG_LEVEL"), "debug")
+}
+
+// Above this many changes in one pass only the counts are logged, unless debugging
+func getDiscoveryLogLimit() int {
+    if limit, err := strconv.Atoi(Setting("DISCOVERY_LOG_LIMIT")); err == nil && limit >= 0 {
+        return limit
+    }
+    return 20
+}
+
+// A kind of resource discovery follows, and the fields that make a change worth logging
+type discoveryKind struct {
+    name      string
+    metric    string
+    gvr       schema.GroupVersionResource
+    namespace string
+    // In HobbyFarm's namespace, resolved when listing since it is configurable
+    hobbyFarm bool
+    summarize func(obj *unstructured.Unstructured) string
+}
+
+func (kind discoveryKind) listNamespace() string {
+    if kind.hobbyFarm {
+        return hobbyFarmNamespace()
+    }
+    return kind.namespace
+}
+
+var discoveryKinds = []discoveryKind{
+    {
+        name: "Session", metric: "sessions", gvr: sessionGVR, hobbyFarm: true,
+        summarize: func(obj *unstructured.Unstructured) string {
+            return discoverySummary(obj, "user", "spec.user", "scenario", "spec.scenario")
+        },
+    },
+    {
+        name: "VirtualMachine", metric: "virtualmachines", gvr: virtualMachineGVR, hobbyFarm: true,
+        summarize: func(obj *unstructured.Unstructured) string {
+            return discoverySummary(obj, "user", "spec.user", "status", "status.status", "ip", "status.public_ip")
+        },
+    },
+    {
+        name: "VMProvisioningRequest", metric: "vmprovisioningrequests", gvr: vmProvisioningRequestGVR, namespace: "default",
+        summarize: func(obj *unstructured.Unstructured) string {
+            return discoverySummary(obj, "user", "spec.user", "session", "spec.session", "state", "status.state", "ip", "status.vmIP")
+        },
+    },
+}
+
+// "label=value" pairs of the given dotted field paths, empty fields left out
+func discoverySummary(obj *unstructured.Unstructured, labelsAndPaths ...string) string {
+    var parts []string
+    for i := 0; i+1 < len(labelsAndPaths); i += 2 {
+        value, _, _ := unstructured.NestedString(obj.Object, strings.Split(labelsAndPaths[i+1], ".")...)
+        if value != "" {
+            parts = append(parts, labelsAndPaths[i]+"="+value)
+        }
+    }
+    return strings.Join(parts, " ")
+}
+
+// Objects of each kind at the last pass, reported on /metrics as gauges
+var discoveredResources = struct {
+    sync.Mutex
+    counts map[string]int
+}{counts: map[string]int{}}
+
+// What changed between two passes, each list sorted by name
+type discoveryDiff struct {
+    added   []string
+    removed []string
+    changed []string
+}
+
+func (d discoveryDiff) empty() bool {
+    return len(d.added) == 0 && len(d.removed) == 0 && len(d.changed) == 0
+}
+
+// Compare name -> summary maps of two passes
+func diffDiscovered(previous, current map[string]string) discoveryDiff {
+    var diff discoveryDiff
+    for name, summary := range current {
+        before, existed := previous[name]
+        switch {
+        case !existed:
+            diff.added = append(diff.added, name)
+        case before != summary:
+            diff.changed = append(diff.changed, name)
+        }
+    }
+    for name := range previous {
+        if _, exists := current[name]; !exists {
+            diff.removed = append(diff.removed, name)
+        }
+    }
+    sort.Strings(diff.added)
+    sort.Strings(diff.removed)
+    sort.Strings(diff.changed)
+    return diff
+}
+
+// Discovery state across passes; only RunResourceDiscovery's goroutine touches it
+type resourceDiscovery struct {
+    client dynamic.Interface
+    last   map[string]map[string]string
+    // Kinds whose last list failed, so a missing CRD is reported once instead of every pass
+    failing map[string]bool
+}
+
+func newResourceDiscovery(client dynamic.Interface) *resourceDiscovery {
+    return &resourceDiscovery{
+        client:  client,
+        last:    map[string]map[string]string{},
+        failing: map[string]bool{},
+    }
+}
+
+// List every kind at once; a kind that can't be listed comes back nil with its error
+func (rd *resourceDiscovery) listAll() ([]map[string]string, []error) {
+    summaries := make([]map[string]string, len(discoveryKinds))
+    errs := make([]error, len(discoveryKinds))
+    var wg sync.WaitGroup
+    for i, kind := range discoveryKinds {
+        wg.Add(1)
+        go func(i int, kind discoveryKind) {
+            defer wg.Done()
+            list, err := rd.client.Resource(kind.gvr).Namespace(kind.listNamespace()).List(context.TODO(), metav1.ListOptions{})
+            if err != nil {
+                errs[i] = err
+                return
+            }
+            current := make(map[string]string, len(list.Items))
+            for j := range list.Items {
+                current[list.Items[j].GetName()] = kind.summarize(&list.Items[j])
+            }
+            summaries[i] = current
+        }(i, kind)
+    }
+    wg.Wait()
+    return summaries, errs
+}
+
+// One pass: list, diff against the previous pass and log the differences
+func (rd *resourceDiscovery) discover() {
+    summaries, errs := rd.listAll()
+    for i, kind := range discoveryKinds {
+        if errs[i] != nil {
+            // Keep the previous view, an empty one would report everything as removed
+            if !rd.failing[kind.name] {
+                log.Printf("⚠️ Could not discover %ss: %v", kind.name, errs[i])
+                rd.failing[kind.name] = true
+            }
+            continue
+        }
+        if rd.failing[kind.name] {
+            log.Printf("🔍 Discovering %ss again", kind.name)
+            delete(rd.failing, kind.name)
+        }
+
+        current := summaries[i]
+        previous, seen := rd.last[kind.name]
+        rd.last[kind.name] = current
+        discoveredResources.Lock()
+        discoveredResources.counts[kind.metric] = len(current)
+        discoveredResources.Unlock()
+
+        if !seen {
+            log.Printf("🔍 Discovered %d %ss", len(current), kind.name)
+            if debugLogging() {
+                rd.logObjects(kind, "found", sortedNames(current), current, nil)
+            }
+            continue
+        }
+        rd.logDiff(kind, diffDiscovered(previous, current), previous, current)
+    }
+}
+
+func (rd *resourceDiscovery) logDiff(kind discoveryKind, diff discoveryDiff, previous, current map[string]string) {
+    if diff.empty() {
+        return
+    }
+    log.Printf("🔍 %ss: %d added, %d removed, %d changed (%d total)",
+        kind.name, len(diff.added), len(diff.removed), len(diff.changed), len(current))
+    if total := len(diff.added) + len(diff.removed) + len(diff.changed); total > getDiscoveryLogLimit() && !debugLogging() {
+        return
+    }
+    rd.logObjects(kind, "added", diff.added, current, nil)
+    rd.logObjects(kind, "removed", diff.removed, previous, nil)
+    rd.logObjects(kind, "changed", diff.changed, current, previous)
+}
+
+// One line per object; changed ones show their summary before and after
+func (rd *resourceDiscovery) logObjects(kind discoveryKind, what string, names []string, summaries, before map[string]string) {
+    for _, name := range names {
+        if before != nil {
+            log.Printf("  📋 %s %s %s: %s -> %s", kind.name, name, what, before[name], summaries[name])
+        } else {
+            log.Printf("  📋 %s %s %s: %s", kind.name, name, what, summaries[name])
+        }
+    }
+}
+
+func sortedNames(summaries map[string]string) []string {
+    names := make([]string, 0, len(summaries))
+    for name := range summaries {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    return names
+}
+
+// Discover every 30 seconds until ctx is done
+func RunResourceDiscovery(ctx context.Context, client dynamic.Interface) {
+    rd := newResourceDiscovery(client)
+    ticker := time.NewTicker(30 * time.Second)
+    defer ticker.Stop()
+
+    for {
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+            rd.discover()
+        }
+    }
+}
+
+func writeDiscoveryMetrics(w io.Writer) {
+    discoveredResources.Lock()
+    defer discoveredResources.Unlock()
+
+    kinds := make([]string, 0, len(discoveredResources.counts))
+    for kind := range discoveredResources.counts {
+        kinds = append(kinds, kind)
+    }
+    sort.Strings(kinds)
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_discovered_resources Objects of each kind seen by the last discovery pass")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_discovered_resources gauge")
+    for _, kind := range kinds {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_discovered_resources{kind=%q} %d\n", kind, discoveredResources.counts[kind])
+    }
+}
@@ -0,0 +1,129 @@
+// internal/resource_names.go - Names and label values derived from session and request names, valid whatever those are called
+package internal
+
+import (
+    "crypto/sha256"
+    "encoding/hex"
+    "fmt"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Label values and the names of Leases, Services and the like are DNS labels, so
+// derived names keep to the shorter of the limits
+const maxDerivedLength = 63
+
+// Annotation with the full session name, which the hobbyfarm.io/session label only
+// holds when the name is a valid label value
+const sessionAnnotation = "hobbyfarm.io/session"
+
+// Hash of the original name appended to a name that had to change, so two names
+// that sanitize alike, e.g. "a_b" and "a.b", still differ
+func nameHash(source string) string {
+    sum := sha256.Sum256([]byte(source))
+    return hex.EncodeToString(sum[:])[:8]
+}
+
+// prefix+source as a valid DNS label: lowercase alphanumerics and dashes, at most
+// 63 characters. A name that already is one is kept as it is, so objects created
+// before keep their names; any other gets the hash of source appended.
+func derivedName(prefix, source string) string {
+    name := prefix + source
+    sanitized := sanitizeName(strings.ToLower(name), func(r rune) bool {
+        return r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-'
+    })
+    if sanitized == name && len(name) <= maxDerivedLength {
+        return name
+    }
+    return withNameHash(sanitized, source)
+}
+
+// A value for a label, which also allows upper case, dots and underscores
+func safeLabelValue(value string) string {
+    sanitized := sanitizeName(value, func(r rune) bool {
+        return r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '-' || r == '_' || r == '.'
+    })
+    if sanitized == value && len(value) <= maxDerivedLength {
+        return value
+    }
+    return withNameHash(sanitized, value)
+}
+
+// Replace disallowed characters with dashes and trim what may not start or end a name
+func sanitizeName(name string, allowed func(rune) bool) string {
+    var b strings.Builder
+    for _, r := range name {
+        if allowed(r) {
+            b.WriteRune(r)
+        } else {
+            b.WriteRune('-')
+        }
+    }
+    return strings.Trim(b.String(), "-_.")
+}
+
+func withNameHash(base, source string) string {
+    hash := nameHash(source)
+    if len(base) > maxDerivedLength-len(hash)-1 {
+        base = strings.TrimRight(base[:maxDerivedLength-len(hash)-1], "-_.")
+    }
+    if base == "" {
+        return hash
+    }
+    return base + "-" + hash
+}
+
+// The VMProvisioningRequest and the TrainingVM of a session
+func requestNameForSession(session string) string {
+    return derivedName("", session)
+}
+
+func trainingVMNameForSession(session string) string {
+    return derivedName("", session)
+}
+
+// The EC2TrainingVM of a TrainingVM, and of a VMProvisioningRequest
+func ec2InstanceName(trainingVM string) string {
+    return derivedName("ec2-", trainingVM)
+}
+
+func requestInstanceName(requestName string) string {
+    return derivedName("kratix-", requestName)
+}
+
+// The VMRequest the webhook creates in place of a session's VirtualMachineClaim
+func vmRequestNameForSession(session string) string {
+    return derivedName("vmreq-", session)
+}
+
+// Selector of what was created for a session, the reverse lookup of derived names
+func sessionLabelSelector(session string) string {
+    return fmt.Sprintf("hobbyfarm.io/session=%s", safeLabelValue(session))
+}
+
+// Label an object created for a session; the annotation keeps the full name
+func setSessionLabel(object *unstructured.Unstructured, session string) {
+    labels := object.GetLabels()
+    if labels == nil {
+        labels = map[string]string{}
+    }
+    labels["hobbyfarm.io/session"] = safeLabelValue(session)
+    object.SetLabels(labels)
+
+    annotations := object.GetAnnotations()
+    if annotations == nil {
+        annotations = map[string]string{}
+    }
+    annotations[sessionAnnotation] = session
+    object.SetAnnotations(annotations)
+}
+
+// Session an object was created for: the annotation, or the label on objects
+// created before it existed
+func objectSession(object *unstructured.Unstructured) string {
+    if session := object.GetAnnotations()[sessionAnnotation]; session != "" {
+        return session
+    }
+    return object.GetLabels()["hobbyfarm.io/session"]
+}
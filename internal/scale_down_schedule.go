@@ -0,0 +1,224 @@
+// internal/scale_down_schedule.go - Shed cloud capacity outside business hours, in each environment's timezone
+package internal
+
+import (
+    "context"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+    "time"
+    _ "time/tzdata" // Timezones resolve without zoneinfo in the image
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// On a Session or VMProvisioningRequest, keeps its cloud VM running through off-hours
+// scale-down, for deliberately long-running sessions
+const keepOvernightLabel = "hobbyfarm.io/keep-overnight"
+
+// When classes run, e.g. "Mon-Fri 08:00-19:00" or "Mon,Wed,Sat 09:00-13:00".
+// Empty means always, and nothing is scaled down.
+func getBusinessHours() string {
+    return strings.TrimSpace(Setting("SCALE_DOWN_BUSINESS_HOURS"))
+}
+
+// Timezone of the business hours for environments that set none
+func getScaleDownTimezone() string {
+    return Setting("SCALE_DOWN_TIMEZONE")
+}
+
+// SCALE_DOWN_ACTIVE_VMS=true also terminates cloud VMs still held by open sessions
+// outside business hours. Off by default: only idle capacity goes.
+func scaleDownActiveVMsEnabled() bool {
+    return Setting("SCALE_DOWN_ACTIVE_VMS") == "true"
+}
+
+type businessHours struct {
+    days       [7]bool // indexed by time.Weekday
+    start, end time.Duration
+}
+
+var weekdays = map[string]time.Weekday{
+    "sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+    "thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+func parseWeekday(name string) (time.Weekday, error) {
+    if day, found := weekdays[strings.ToLower(name)]; found {
+        return day, nil
+    }
+    return 0, fmt.Errorf("unknown weekday %q", name)
+}
+
+// Minutes since midnight of "HH:MM"; 24:00 is allowed as an end
+func parseClock(clock string) (time.Duration, error) {
+    hours, minutes, found := strings.Cut(clock, ":")
+    h, herr := strconv.Atoi(hours)
+    m, merr := strconv.Atoi(minutes)
+    if !found || herr != nil || merr != nil || h < 0 || m < 0 || m > 59 || h*60+m > 24*60 {
+        return 0, fmt.Errorf("invalid time %q, expected HH:MM", clock)
+    }
+    return time.Duration(h)*time.Hour + time.Duration(m)*time.Minute, nil
+}
+
+// Parse "<days> <start>-<end>", days being comma-separated weekdays or ranges
+func parseBusinessHours(spec string) (businessHours, error) {
+    var hours businessHours
+    fields := strings.Fields(spec)
+    if len(fields) != 2 {
+        return hours, fmt.Errorf("expected \"<days> <HH:MM>-<HH:MM>\", got %q", spec)
+    }
+
+    for _, part := range strings.Split(fields[0], ",") {
+        first, last, isRange := strings.Cut(part, "-")
+        from, err := parseWeekday(first)
+        if err != nil {
+            return hours, err
+        }
+        to := from
+        if isRange {
+            if to, err = parseWeekday(last); err != nil {
+                return hours, err
+            }
+        }
+        // Ranges may wrap past Sunday, e.g. Sat-Mon
+        for day := from; ; day = (day + 1) % 7 {
+            hours.days[day] = true
+            if day == to {
+                break
+            }
+        }
+    }
+
+    start, end, found := strings.Cut(fields[1], "-")
+    if !found {
+        return hours, fmt.Errorf("invalid hours %q, expected HH:MM-HH:MM", fields[1])
+    }
+    var err error
+    if hours.start, err = parseClock(start); err != nil {
+        return hours, err
+    }
+    if hours.end, err = parseClock(end); err != nil {
+        return hours, err
+    }
+    if hours.end <= hours.start {
+        return hours, fmt.Errorf("hours %q end before they start", fields[1])
+    }
+    return hours, nil
+}
+
+func (h businessHours) contains(t time.Time) bool {
+    sinceMidnight := time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute
+    return h.days[t.Weekday()] && sinceMidnight >= h.start && sinceMidnight < h.end
+}
+
+// The timezone business hours are read in for an environment: its own timezone,
+// then SCALE_DOWN_TIMEZONE, then the default VM timezone
+func scaleDownLocation(environment string) (*time.Location, error) {
+    env, _ := getVMEnvironment(environment)
+    name := env.Timezone
+    if name == "" {
+        name = getScaleDownTimezone()
+    }
+    if name == "" {
+        name = withSystemSettingDefaults(env).Timezone
+    }
+    return time.LoadLocation(name)
+}
+
+// Whether it is outside business hours where environment's classes run. Without a
+// valid schedule it never is, so a typo can't terminate anything.
+func outsideBusinessHours(environment string) bool {
+    spec := getBusinessHours()
+    if spec == "" {
+        return false
+    }
+    hours, err := parseBusinessHours(spec)
+    if err != nil {
+        return false
+    }
+    location, err := scaleDownLocation(environment)
+    if err != nil {
+        return false
+    }
+    return !hours.contains(time.Now().In(location))
+}
+
+// Environment a cloud instance was launched for
+func cloudInstanceEnvironment(instance *unstructured.Unstructured) string {
+    if environment := instance.GetLabels()[environmentLabel]; environment != "" {
+        return environment
+    }
+    return defaultEnvironmentName
+}
+
+// Outside business hours, terminate the cloud VMs of open sessions unless the
+// request or its Session carries the keep-overnight label. Idle instances kept for
+// reuse are terminated by ReapIdleCloudInstances, which checks the same hours.
+func ScaleDownOffHours(client dynamic.Interface) {
+    if getBusinessHours() == "" || !scaleDownActiveVMsEnabled() {
+        return
+    }
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    dc := NewDeprovisionController(client)
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+        if state == provisioner.StateReleased || state == "pending" || vmType == vmTypeAdopted || (vmType != "ec2" && (vmIP == "" || !isPublicIP(vmIP))) {
+            continue
+        }
+        if request.GetLabels()[keepOvernightLabel] == "true" || !outsideBusinessHours(getObjectEnvironment(request)) {
+            continue
+        }
+        sessionName := GetHobbyFarmSessionFromRequest(request)
+        if sessionName == "" {
+            sessionName, _, _ = unstructured.NestedString(request.Object, "spec", "session")
+        }
+        if session, err := client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(context.TODO(), sessionName, metav1.GetOptions{}); err == nil &&
+            session.GetLabels()[keepOvernightLabel] == "true" {
+            continue
+        }
+
+        log.Printf("🌙 Scaling down cloud VM %s of request %s outside business hours", vmIP, request.GetName())
+        dc.teardownRequestVM(request, sessionName)
+        if err := dc.releaseRequest(request.GetName(), provisioner.ReleaseScaledDown); err != nil {
+            log.Printf("❌ Failed to release request %s after scale-down: %v", request.GetName(), err)
+            continue
+        }
+        recordEvent(client, request, eventTypeWarning, "ScaledDownOffHours",
+            fmt.Sprintf("Cloud VM %s terminated outside business hours (%s)", vmIP, getBusinessHours()))
+    }
+}
+
+// The schedule parses and every environment's timezone is known
+func validateScaleDownSchedule(check *ConfigCheck) {
+    spec := getBusinessHours()
+    if spec == "" {
+        if scaleDownActiveVMsEnabled() {
+            check.warn("SCALE_DOWN_ACTIVE_VMS is set but SCALE_DOWN_BUSINESS_HOURS is empty, nothing is scaled down")
+        }
+        return
+    }
+    if _, err := parseBusinessHours(spec); err != nil {
+        check.fail("SCALE_DOWN_BUSINESS_HOURS: %v", err)
+    }
+    if _, err := scaleDownLocation(defaultEnvironmentName); err != nil {
+        check.fail("default timezone: %v", err)
+    }
+    for name := range loadVMEnvironments() {
+        if _, err := scaleDownLocation(name); err != nil {
+            check.fail("environment %s: %v", name, err)
+        }
+    }
+}
@@ -0,0 +1,112 @@
+// internal/secret_variables.go - Variables valued secretRef:<namespace>/<name>/<key>, resolved at provisioning time
+package internal
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+const secretRefPrefix = "secretRef:"
+
+// Environment variable carrying a resolved secret into ansible-playbook
+const secretEnvPrefix = "HOBBYFARM_SECRET_"
+
+// Namespaces secretRef variables may read from, comma separated; "*" allows any.
+// Limited by default since whoever writes a request could otherwise hand any
+// Secret the provisioner can read to a playbook.
+func getSecretRefNamespaces() []string {
+    if namespaces := splitEnvList("SECRET_REF_NAMESPACES"); len(namespaces) > 0 {
+        return namespaces
+    }
+    return []string{"default"}
+}
+
+func secretRefNamespaceAllowed(namespace string) bool {
+    for _, allowed := range getSecretRefNamespaces() {
+        if allowed == "*" || allowed == namespace {
+            return true
+        }
+    }
+    return false
+}
+
+// Parse secretRef:<namespace>/<name>/<key>
+func parseSecretRef(value string) (namespace, name, key string, err error) {
+    parts := strings.Split(strings.TrimPrefix(value, secretRefPrefix), "/")
+    if len(parts) != 3 || parts[0] == "" || parts[1] == "" || parts[2] == "" {
+        return "", "", "", fmt.Errorf("%q is not secretRef:<namespace>/<name>/<key>", value)
+    }
+    return parts[0], parts[1], parts[2], nil
+}
+
+// Move every secretRef variable out of config.Variables into config.ResolvedSecrets,
+// so it reaches Ansible through the environment and never the on-disk inventory
+func resolveSecretVariables(client dynamic.Interface, config *ProvisioningConfig) error {
+    for variable, value := range config.Variables {
+        if !strings.HasPrefix(value, secretRefPrefix) {
+            continue
+        }
+        namespace, name, key, err := parseSecretRef(value)
+        if err != nil {
+            return fmt.Errorf("variable %s: %v", variable, err)
+        }
+        if !secretRefNamespaceAllowed(namespace) {
+            return fmt.Errorf("variable %s: namespace %s not in SECRET_REF_NAMESPACES", variable, namespace)
+        }
+
+        secret, err := client.Resource(secretGVR).Namespace(namespace).Get(context.TODO(), name, metav1.GetOptions{})
+        if err != nil {
+            return fmt.Errorf("variable %s: failed to get Secret %s/%s: %v", variable, namespace, name, err)
+        }
+        encoded, found, _ := unstructured.NestedString(secret.Object, "data", key)
+        if !found {
+            return fmt.Errorf("variable %s: Secret %s/%s has no key %s", variable, namespace, name, key)
+        }
+        decoded, err := base64.StdEncoding.DecodeString(encoded)
+        if err != nil {
+            return fmt.Errorf("variable %s: Secret %s/%s key %s is not valid base64: %v", variable, namespace, name, key, err)
+        }
+
+        if config.ResolvedSecrets == nil {
+            config.ResolvedSecrets = map[string]string{}
+        }
+        config.ResolvedSecrets[variable] = string(decoded)
+        delete(config.Variables, variable)
+        log.Printf("🔐 Resolved variable %s from Secret %s/%s", variable, namespace, name)
+    }
+    return nil
+}
+
+func secretEnvName(variable string) string {
+    return secretEnvPrefix + strings.ToUpper(variable)
+}
+
+// NAME=value pairs for the ansible-playbook process
+func (config *ProvisioningConfig) secretEnv() []string {
+    env := make([]string, 0, len(config.ResolvedSecrets))
+    for variable, value := range config.ResolvedSecrets {
+        env = append(env, secretEnvName(variable)+"="+value)
+    }
+    sort.Strings(env)
+    return env
+}
+
+// Extra vars looking each secret up from the environment on the controller, so
+// the values show neither in the inventory nor on the command line
+func (config *ProvisioningConfig) secretExtraVars() string {
+    lookups := make(map[string]string, len(config.ResolvedSecrets))
+    for variable := range config.ResolvedSecrets {
+        lookups[variable] = fmt.Sprintf("{{ lookup('env', '%s') }}", secretEnvName(variable))
+    }
+    data, _ := json.Marshal(lookups)
+    return string(data)
+}
@@ -0,0 +1,89 @@
+// internal/session_pathway.go - One provisioning pathway per Session, claimed by annotation
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// On a Session, the pathway provisioning its VM. The first controller to set it
+// owns the session; any other leaves it alone, so a session never gets both a
+// TrainingVM and a VMProvisioningRequest.
+const sessionPathwayAnnotation = "provisioning.hobbyfarm.io/pathway"
+
+const (
+    // HobbyFarmController: Session → TrainingVM → allocator
+    pathwayTrainingVM = "trainingvm"
+    // Integration: Session → VMProvisioningRequest → KratixController
+    pathwayKratix = "kratix"
+)
+
+// The pathway that already provisions a session claimed before the annotation
+// existed, empty when neither resource exists
+func existingSessionPathway(client dynamic.Interface, sessionName string) string {
+    _, requestErr := client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestNameForSession(sessionName), metav1.GetOptions{})
+    _, trainingVMErr := client.Resource(trainingVMGVR).Namespace("default").Get(context.TODO(), trainingVMNameForSession(sessionName), metav1.GetOptions{})
+    switch {
+    case requestErr == nil && trainingVMErr == nil:
+        log.Printf("⚠️ Session %s has both a VMProvisioningRequest and a TrainingVM, keeping the request", sessionName)
+        return pathwayKratix
+    case requestErr == nil:
+        return pathwayKratix
+    case trainingVMErr == nil:
+        return pathwayTrainingVM
+    }
+    return ""
+}
+
+// Claim session for pathway, true when the session is pathway's to provision. The
+// claim carries the Session's resourceVersion, so of two controllers racing for an
+// unclaimed session exactly one wins and the other reads its claim.
+func claimSessionPathway(client dynamic.Interface, session *unstructured.Unstructured, pathway string) bool {
+    if claimed := session.GetAnnotations()[sessionPathwayAnnotation]; claimed != "" {
+        return claimed == pathway
+    }
+
+    claim := pathway
+    if existing := existingSessionPathway(client, session.GetName()); existing != "" {
+        claim = existing
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "resourceVersion": session.GetResourceVersion(),
+            "annotations": map[string]interface{}{
+                sessionPathwayAnnotation: claim,
+            },
+        },
+    })
+    _, err := client.Resource(sessionGVR).Namespace(session.GetNamespace()).Patch(
+        context.TODO(), session.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsConflict(err) {
+        // Changed since listed, possibly claimed by the other pathway: go by what it holds now
+        current, getErr := client.Resource(sessionGVR).Namespace(session.GetNamespace()).Get(context.TODO(), session.GetName(), metav1.GetOptions{})
+        if getErr != nil {
+            return false
+        }
+        if claimed := current.GetAnnotations()[sessionPathwayAnnotation]; claimed != "" {
+            return claimed == pathway
+        }
+        return claimSessionPathway(client, current, pathway)
+    }
+    if err != nil {
+        log.Printf("⚠️ Could not claim Session %s for the %s pathway: %v", session.GetName(), pathway, err)
+        return false
+    }
+
+    if claim != pathway {
+        log.Printf("🔀 Session %s is already provisioned through the %s pathway, not by %s", session.GetName(), claim, pathway)
+        return false
+    }
+    log.Printf("🔒 Session %s claimed for the %s pathway", session.GetName(), pathway)
+    return true
+}
@@ -0,0 +1,157 @@
+// internal/session_status_sync.go - Back-populate Kratix request status onto HobbyFarm Sessions
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "strconv"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+)
+
+// Session annotations carrying a summary of the Kratix provisioning status and, once
+// ready, what the VM has installed and where its services are exposed, for the
+// scenario content to show the learner
+const (
+    sessionStateAnnotation               = "kratix.hobbyfarm.io/state"
+    sessionVMIPAnnotation                = "kratix.hobbyfarm.io/vm-ip"
+    sessionVMTypeAnnotation              = "kratix.hobbyfarm.io/vm-type"
+    sessionReadyAtAnnotation             = "kratix.hobbyfarm.io/ready-at"
+    sessionFailureReasonAnnotation       = "kratix.hobbyfarm.io/failure-reason"
+    sessionFailureMessageAnnotation      = "kratix.hobbyfarm.io/failure-message"
+    sessionQueuePositionAnnotation       = "kratix.hobbyfarm.io/queue-position"
+    sessionEstimatedReadyAnnotation      = "kratix.hobbyfarm.io/estimated-ready-at"
+    sessionProvisioningSummaryAnnotation = "kratix.hobbyfarm.io/provisioning-summary"
+    sessionExposedEndpointsAnnotation    = "kratix.hobbyfarm.io/exposed-endpoints"
+)
+
+// Copy state, vmIP, vmType, readyAt, failureReason and lastError from each HobbyFarm-originated
+// VMProvisioningRequest onto its Session so admins can follow progress there
+func (hki *HobbyFarmKratixIntegration) syncSessionStatusFromKratix() {
+    requests, err := hki.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        if !IsHobbyFarmRequest(request) {
+            continue
+        }
+
+        sessionName := GetHobbyFarmSessionFromRequest(request)
+        if sessionName == "" {
+            continue
+        }
+
+        summary := buildSessionStatusSummary(request)
+
+        session, err := hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(
+            context.TODO(), sessionName, metav1.GetOptions{})
+        if err != nil {
+            continue // Session is gone, orphan cleanup takes care of the request
+        }
+
+        if !sessionSummaryChanged(session.GetAnnotations(), summary) {
+            continue
+        }
+
+        if err := hki.patchSessionAnnotations(sessionName, summary); err != nil {
+            log.Printf("⚠️ Failed to update provisioning status on Session %s: %v", sessionName, err)
+            continue
+        }
+
+        log.Printf("📝 Session %s provisioning status: state=%s, ip=%s", sessionName,
+            summary[sessionStateAnnotation], summary[sessionVMIPAnnotation])
+    }
+}
+
+// Build the annotation set for a request; empty values clear stale annotations
+func buildSessionStatusSummary(request *unstructured.Unstructured) map[string]string {
+    state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+    vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+    vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+    readyAt, _, _ := unstructured.NestedString(request.Object, "status", "readyAt")
+    lastError, _, _ := unstructured.NestedString(request.Object, "status", "lastError")
+    failureReason, _, _ := unstructured.NestedString(request.Object, "status", "failureReason")
+    queuePosition, _, _ := unstructured.NestedInt64(request.Object, "status", "queuePosition")
+    estimatedReadyAt, _, _ := unstructured.NestedString(request.Object, "status", "estimatedReadyAt")
+    provisioningSummary, _, _ := unstructured.NestedString(request.Object, "status", "provisioningSummary")
+
+    if state == "" {
+        state = "pending"
+    }
+
+    // Only report a failure reason while the request is actually failed
+    if state != "failed" {
+        lastError = ""
+        failureReason = ""
+    }
+
+    // The summary and endpoints describe the VM the learner has now
+    exposedEndpoints := exposedEndpointsAnnotation(request)
+    if state != "ready" {
+        provisioningSummary = ""
+        exposedEndpoints = ""
+    }
+
+    // Queue details are only meaningful while waiting for capacity
+    queue := ""
+    if state == "pending" && queuePosition > 0 {
+        queue = strconv.FormatInt(queuePosition, 10)
+    } else {
+        estimatedReadyAt = ""
+    }
+
+    return map[string]string{
+        sessionStateAnnotation:               state,
+        sessionVMIPAnnotation:                vmIP,
+        sessionVMTypeAnnotation:              vmType,
+        sessionReadyAtAnnotation:             readyAt,
+        sessionFailureReasonAnnotation:       failureReason,
+        sessionFailureMessageAnnotation:      lastError,
+        sessionQueuePositionAnnotation:       queue,
+        sessionEstimatedReadyAnnotation:      estimatedReadyAt,
+        sessionProvisioningSummaryAnnotation: provisioningSummary,
+        sessionExposedEndpointsAnnotation:    exposedEndpoints,
+    }
+}
+
+func sessionSummaryChanged(current, summary map[string]string) bool {
+    for key, value := range summary {
+        if current[key] != value {
+            return true
+        }
+    }
+    return false
+}
+
+func (hki *HobbyFarmKratixIntegration) patchSessionAnnotations(sessionName string, summary map[string]string) error {
+    annotations := make(map[string]interface{}, len(summary))
+    for key, value := range summary {
+        if value == "" {
+            annotations[key] = nil // Merge patch null removes the annotation
+        } else {
+            annotations[key] = value
+        }
+    }
+
+    patch := map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": annotations,
+        },
+    }
+
+    patchBytes, err := json.Marshal(patch)
+    if err != nil {
+        return err
+    }
+
+    _, err = hki.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Patch(
+        context.TODO(), sessionName, types.MergePatchType,
+        patchBytes, metav1.PatchOptions{})
+    return err
+}
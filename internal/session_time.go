@@ -0,0 +1,137 @@
+// internal/session_time.go - The session's remaining time written on its VM, refreshed when HobbyFarm keeps the session alive
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Installs lab-time and the login message reading vmSessionExpiryPath
+const sessionTimePlaybook = "session-time.yaml"
+
+// Shell-sourceable expires=<unix seconds> and paused=<true|false>
+const vmSessionExpiryPath = "/etc/hobbyfarm/session-expiry"
+
+func sessionTimeEnabled() bool {
+    return Setting("SESSION_TIME_DISPLAY") != "false"
+}
+
+func withSessionTimePlaybook(config *ProvisioningConfig) {
+    if !sessionTimeEnabled() {
+        return
+    }
+    for _, playbook := range config.Playbooks {
+        if playbook == sessionTimePlaybook {
+            return
+        }
+    }
+    config.Playbooks = append(config.Playbooks, sessionTimePlaybook)
+}
+
+// When a session expires as HobbyFarm records it; keepalives push expiration_time out
+func sessionExpiry(session *unstructured.Unstructured) (time.Time, bool) {
+    value, _, _ := unstructured.NestedString(session.Object, "status", "expiration_time")
+    if value == "" {
+        return time.Time{}, false
+    }
+    for _, layout := range []string{time.RFC3339, time.UnixDate, time.RFC1123} {
+        if expiresAt, err := time.Parse(layout, value); err == nil {
+            return expiresAt, true
+        }
+    }
+    return time.Time{}, false
+}
+
+// What the VM shows, also recorded in the request status to tell when it changed
+func sessionTimeState(session *unstructured.Unstructured) (string, bool) {
+    expiresAt, found := sessionExpiry(session)
+    if !found {
+        return "", false
+    }
+    state := expiresAt.UTC().Format(time.RFC3339)
+    if paused, _, _ := unstructured.NestedBool(session.Object, "status", "paused"); paused {
+        state += " paused"
+    }
+    return state, true
+}
+
+func (ar *AnsibleRunner) writeSessionExpiry(vmIP, sshUser string, expiresAt time.Time, paused bool) error {
+    command := fmt.Sprintf("sudo mkdir -p /etc/hobbyfarm && printf 'expires=%d\\npaused=%t\\n' | sudo tee %s >/dev/null && sudo chmod 644 %s",
+        expiresAt.Unix(), paused, vmSessionExpiryPath, vmSessionExpiryPath)
+    if output, err := ar.ssh.CombinedOutput(sshUser, vmIP, 15*time.Second, command); err != nil {
+        return fmt.Errorf("writing %s failed: %v: %s", vmSessionExpiryPath, err, strings.TrimSpace(ar.sanitizeForLog(output, nil)))
+    }
+    return nil
+}
+
+// Write the expiry of each ready VM's session whenever it differs from what the
+// VM was last given; the countdown itself runs on the VM
+func (kc *KratixController) refreshSessionTimes() {
+    if !sessionTimeEnabled() {
+        return
+    }
+    sessions, err := kc.client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    byName := make(map[string]*unstructured.Unstructured, len(sessions.Items))
+    for i := range sessions.Items {
+        byName[sessions.Items[i].GetName()] = &sessions.Items[i]
+    }
+
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        if state, _, _ := unstructured.NestedString(request.Object, "status", "state"); state != "ready" || !ownsRequest(request) {
+            continue
+        }
+        sessionName, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+        session, found := byName[sessionName]
+        if !found || isSessionFinished(session) {
+            continue
+        }
+        state, known := sessionTimeState(session)
+        if recorded, _, _ := unstructured.NestedString(request.Object, "status", "sessionExpiresAt"); !known || recorded == state {
+            continue
+        }
+        kc.writeSessionTime(request, session, state)
+    }
+}
+
+func (kc *KratixController) writeSessionTime(request, session *unstructured.Unstructured, state string) {
+    requestName := request.GetName()
+    accessIP := getRequestAccessIP(request)
+    expiresAt, _ := sessionExpiry(session)
+    paused, _, _ := unstructured.NestedBool(session.Object, "status", "paused")
+
+    sshUser, err := kc.prober.DetectSSHUser(accessIP)
+    if err != nil {
+        log.Printf("⚠️ Could not refresh session time on %s: %v", requestName, err)
+        return
+    }
+    if err := kc.prober.WriteSessionExpiry(accessIP, sshUser, expiresAt, paused); err != nil {
+        log.Printf("⚠️ Could not refresh session time on %s: %v", requestName, err)
+        return
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "sessionExpiresAt": state,
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record session time of %s: %v", requestName, err)
+        return
+    }
+    log.Printf("⏳ Session %s of %s ends at %s, written to VM %s", session.GetName(), requestName, state, accessIP)
+}
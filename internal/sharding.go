@@ -0,0 +1,265 @@
+// internal/sharding.go - Sessions split by hash across provisioner replicas, each replica claiming one shard by Lease
+package internal
+
+import (
+    "context"
+    "fmt"
+    "hash/fnv"
+    "io"
+    "log"
+    "os"
+    "strconv"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Label on shard and allocation Leases
+const shardLabel = "provisioning.hobbyfarm.io/shard"
+
+// Lease serializing allocation across shards, so two replicas never pick one static VM
+const allocationLeaseName = "hobbyfarm-provisioner-allocation"
+
+// Number of shards sessions are split into; 1 turns sharding off and a single
+// replica does everything. Set it to the Deployment's replica count.
+func getShardCount() int {
+    if count, err := strconv.Atoi(Setting("SHARD_COUNT")); err == nil && count > 1 {
+        return count
+    }
+    return 1
+}
+
+func shardingEnabled() bool {
+    return getShardCount() > 1
+}
+
+// How long a shard outlives its holder's last renewal before another replica takes it
+func getShardLeaseDuration() time.Duration {
+    if seconds, err := strconv.Atoi(Setting("SHARD_LEASE_SECONDS")); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    return 30 * time.Second
+}
+
+// Workers shard apart from the controller, e.g. hobbyfarm-provisioner-worker-shard-0
+func shardLeaseName(shard int) string {
+    if Component() == ComponentWorker {
+        return fmt.Sprintf("hobbyfarm-provisioner-worker-shard-%d", shard)
+    }
+    return fmt.Sprintf("hobbyfarm-provisioner-shard-%d", shard)
+}
+
+// Shard this replica holds, -1 while it holds none and stands by
+var heldShard = struct {
+    sync.RWMutex
+    shard int
+}{shard: -1}
+
+func currentShard() int {
+    heldShard.RLock()
+    defer heldShard.RUnlock()
+    return heldShard.shard
+}
+
+func setCurrentShard(shard int) {
+    heldShard.Lock()
+    defer heldShard.Unlock()
+    if heldShard.shard != shard {
+        if shard < 0 {
+            log.Printf("⚠️ Lost shard %d, standing by", heldShard.shard)
+        } else {
+            log.Printf("🧩 Holding shard %d of %d", shard, getShardCount())
+        }
+    }
+    heldShard.shard = shard
+}
+
+// Shard a session belongs to, the same on every replica
+func sessionShard(session string) int {
+    hash := fnv.New32a()
+    hash.Write([]byte(session))
+    return int(hash.Sum32() % uint32(getShardCount()))
+}
+
+// Whether this replica reconciles the session
+func ownsSession(session string) bool {
+    if !shardingEnabled() {
+        return true
+    }
+    shard := currentShard()
+    return shard >= 0 && sessionShard(session) == shard
+}
+
+// Requests follow their session; one created without a session is sharded by its own name
+func ownsRequest(request *unstructured.Unstructured) bool {
+    session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+    if session == "" {
+        session = GetHobbyFarmSessionFromRequest(request)
+    }
+    if session == "" {
+        session = request.GetName()
+    }
+    return ownsSession(session)
+}
+
+// Whether this replica runs the loops that look at the whole cluster rather than
+// one session's requests: queue positions, DNS records, cloud instance monitoring
+// and cleanup. They run on the holder of shard 0, never on workers.
+func RunsClusterWideWork() bool {
+    if Component() == ComponentWorker {
+        return false
+    }
+    return !shardingEnabled() || currentShard() == 0
+}
+
+// Claim a shard and keep renewing it until ctx ends. A replica whose shard was
+// taken over, or that found none free, retries every renewal period, so a crashed
+// replica's shard moves to a standby within one lease duration.
+func RunShardMembership(ctx context.Context, client dynamic.Interface) {
+    if !shardingEnabled() {
+        return
+    }
+    holder, _ := os.Hostname()
+    log.Printf("🧩 Sharding sessions across %d shards as %s", getShardCount(), holder)
+
+    ticker := time.NewTicker(getShardLeaseDuration() / 3)
+    defer ticker.Stop()
+    var renewed time.Time
+    for {
+        // Taken before the attempt, so the Lease never runs out earlier for the others than for us
+        attempt := time.Now()
+        shard, ok := claimShard(client, holder, currentShard(), renewed)
+        if ok {
+            renewed = attempt
+        }
+        setCurrentShard(shard)
+        select {
+        case <-ctx.Done():
+            if shard := currentShard(); shard >= 0 {
+                shardLease(client, shardLeaseName(shard), holder, getShardLeaseDuration()).release()
+            }
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Renew the held shard, or take the first one that is free or expired. Reports
+// whether a Lease was renewed or taken. A held shard whose renewal fails is kept
+// until its Lease, last renewed at renewed, could have expired for the others.
+func claimShard(client dynamic.Interface, holder string, held int, renewed time.Time) (int, bool) {
+    duration := getShardLeaseDuration()
+    if held >= 0 {
+        acquired, _, err := shardLease(client, shardLeaseName(held), holder, duration).tryAcquire()
+        if err != nil {
+            log.Printf("⚠️ Could not renew shard %d: %v", held, err)
+            if time.Since(renewed) < duration {
+                return held, false
+            }
+            log.Printf("⚠️ Shard %d not renewed for %v, another replica may hold it now", held, duration)
+        }
+        if acquired {
+            return held, true
+        }
+    }
+    for shard := 0; shard < getShardCount(); shard++ {
+        acquired, _, err := shardLease(client, shardLeaseName(shard), holder, duration).tryAcquire()
+        if err != nil {
+            log.Printf("⚠️ Could not claim shard %d: %v", shard, err)
+            continue
+        }
+        if acquired {
+            return shard, true
+        }
+    }
+    return -1, false
+}
+
+// Run the allocation pass holding the allocation Lease, renewed while the pass
+// runs. A pass finding it held by another shard is skipped, the next one comes
+// within seconds.
+func withAllocationLease(client dynamic.Interface, fn func()) {
+    if !shardingEnabled() {
+        fn()
+        return
+    }
+    holder, _ := os.Hostname()
+    lease := shardLease(client, allocationLeaseName, holder, getShardLeaseDuration())
+    acquired, _, err := lease.tryAcquire()
+    if err != nil {
+        log.Printf("⚠️ Could not take the allocation Lease: %v", err)
+        return
+    }
+    if !acquired {
+        return
+    }
+    stop := make(chan struct{})
+    go lease.keepRenewed(stop)
+    defer func() {
+        close(stop)
+        lease.release()
+    }()
+    fn()
+}
+
+// A shard or allocation Lease, freed on release by clearing its holder
+func shardLease(client dynamic.Interface, name, holder string, duration time.Duration) *Lease {
+    return &Lease{
+        Client:      client,
+        Name:        name,
+        Holder:      holder,
+        Duration:    duration,
+        Description: "Lease " + name,
+        Labels: map[string]interface{}{
+            "app":      "hobbyfarm-provisioner",
+            shardLabel: name,
+        },
+    }
+}
+
+// Sharding state of this replica, in /stats
+type shardStatus struct {
+    Count int `json:"count"`
+    // -1 while standing by
+    Held int `json:"held"`
+}
+
+func currentShardStatus() *shardStatus {
+    if !shardingEnabled() {
+        return nil
+    }
+    return &shardStatus{Count: getShardCount(), Held: currentShard()}
+}
+
+func writeShardMetrics(w io.Writer) {
+    if !shardingEnabled() {
+        return
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_shards Number of shards sessions are split into")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_shards gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_shards %d\n", getShardCount())
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_shard_held Shard this replica holds, -1 while standing by")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_shard_held gauge")
+    fmt.Fprintf(w, "hobbyfarm_provisioner_shard_held %d\n", currentShard())
+}
+
+// Only the VMProvisioningRequest pathway allocates under the allocation Lease; the
+// TrainingVM allocator would hand one static VM to two shards
+func validateSharding(check *ConfigCheck) {
+    if value := Setting("SHARD_COUNT"); value != "" {
+        if count, err := strconv.Atoi(value); err != nil || count < 1 {
+            check.fail("SHARD_COUNT %q is not a positive number", value)
+        }
+    }
+    if !shardingEnabled() {
+        return
+    }
+    if Setting("INTEGRATION_MODE") == "hobbyfarm-only" || Setting("HOBBYFARM_DIRECT_MODE") == "true" {
+        check.fail("SHARD_COUNT > 1 needs the Kratix pathway, TrainingVM allocation is not sharded")
+    }
+    if getShardLeaseDuration() < 10*time.Second {
+        check.warn("SHARD_LEASE_SECONDS under 10s, shards may move between replicas on a slow API server")
+    }
+}
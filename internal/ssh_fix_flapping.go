@@ -0,0 +1,171 @@
+// internal/ssh_fix_flapping.go - Stop rewriting ssh_username on VirtualMachines where something keeps resetting it, and name who
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "io"
+    "log"
+    "sort"
+    "strconv"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Field manager of the provisioner's VirtualMachine patches, so its own writes
+// are told apart from those of the manager it is fighting
+const vmFieldManager = "hobbyfarm-vm-provisioner"
+
+const (
+    // Corrections of this VM's ssh_username since it was last flagged
+    sshFixesAnnotation = "provisioning.hobbyfarm.io/ssh-username-fixes"
+    // Set on a VM whose ssh_username is no longer rewritten; removing it resumes corrections
+    sshFlappingLabel = "provisioning.hobbyfarm.io/ssh-username-flapping"
+    // The field manager that last reset ssh_username, from managedFields
+    sshResetByAnnotation = "provisioning.hobbyfarm.io/ssh-username-reset-by"
+)
+
+// Corrections of one VM's ssh_username after which it counts as flapping; 0 rewrites it forever
+func getSSHFixFlapThreshold() int {
+    if threshold, err := strconv.Atoi(Setting("SSH_FIX_FLAP_THRESHOLD")); err == nil && threshold >= 0 {
+        return threshold
+    }
+    return 3
+}
+
+func sshUsernameFixes(vm *unstructured.Unstructured) int {
+    fixes, _ := strconv.Atoi(vm.GetAnnotations()[sshFixesAnnotation])
+    return fixes
+}
+
+// The spec to patch into vm, without ssh_username while it flaps. The correction
+// that would go past the threshold flags the VM instead of being made.
+func withoutFlappingSSHUsername(client dynamic.Interface, vm *unstructured.Unstructured, spec map[string]interface{}) map[string]interface{} {
+    sshUser, _ := spec["ssh_username"].(string)
+    previous, _, _ := unstructured.NestedString(vm.Object, "spec", "ssh_username")
+    if sshUser == "" || previous == sshUser {
+        return spec
+    }
+    flapping := vm.GetLabels()[sshFlappingLabel] == "true"
+    if !flapping {
+        threshold := getSSHFixFlapThreshold()
+        if threshold == 0 || sshUsernameFixes(vm) < threshold {
+            return spec
+        }
+        markSSHUsernameFlapping(client, vm, previous, sshUser)
+    }
+
+    kept := make(map[string]interface{}, len(spec))
+    for field, value := range spec {
+        if field != "ssh_username" {
+            kept[field] = value
+        }
+    }
+    return kept
+}
+
+// The manager that last wrote spec.ssh_username other than the provisioner, e.g.
+// "hobbyfarm-gargantua (Update)"
+func sshUsernameManager(vm *unstructured.Unstructured) string {
+    manager := ""
+    var latest *metav1.Time
+    for _, entry := range vm.GetManagedFields() {
+        if entry.Manager == vmFieldManager || entry.FieldsV1 == nil {
+            continue
+        }
+        var fields map[string]map[string]interface{}
+        if json.Unmarshal(entry.FieldsV1.Raw, &fields) != nil {
+            continue
+        }
+        if _, owns := fields["f:spec"]["f:ssh_username"]; !owns {
+            continue
+        }
+        if latest == nil || entry.Time != nil && latest.Before(entry.Time) {
+            manager = fmt.Sprintf("%s (%s)", entry.Manager, entry.Operation)
+            latest = entry.Time
+        }
+    }
+    if manager == "" {
+        return "unknown"
+    }
+    return manager
+}
+
+// Flag vm, restart its count for when the label is removed, and warn with the
+// manager resetting it
+func markSSHUsernameFlapping(client dynamic.Interface, vm *unstructured.Unstructured, previous, sshUser string) {
+    manager := sshUsernameManager(vm)
+    fixes := sshUsernameFixes(vm)
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "labels": map[string]interface{}{sshFlappingLabel: "true"},
+            "annotations": map[string]interface{}{
+                sshFixesAnnotation:   "0",
+                sshResetByAnnotation: manager,
+            },
+        },
+    })
+    if _, err := client.Resource(virtualMachineGVR).Namespace(vm.GetNamespace()).Patch(
+        context.TODO(), vm.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{FieldManager: vmFieldManager}); err != nil {
+        log.Printf("⚠️ Could not flag ssh_username flapping on VirtualMachine %s: %v", vm.GetName(), err)
+    }
+
+    log.Printf("🚨 ssh_username of VirtualMachine %s was corrected %d times and is %q again, reset by %s; no longer rewriting it",
+        vm.GetName(), fixes, previous, manager)
+    recordEvent(client, vm, eventTypeWarning, "SSHUsernameFlapping", fmt.Sprintf(
+        "ssh_username corrected to %q %d times, %s keeps resetting it to %q; the provisioner stops rewriting it until the %s label is removed",
+        sshUser, fixes, manager, previous, sshFlappingLabel))
+}
+
+// A flagged VM, in /stats
+type sshUsernameFlap struct {
+    VirtualMachine string `json:"virtualMachine"`
+    Namespace      string `json:"namespace"`
+    SSHUsername    string `json:"sshUsername"`
+    ResetBy        string `json:"resetBy"`
+}
+
+func currentSSHUsernameFlaps(client dynamic.Interface) []sshUsernameFlap {
+    vms, err := client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{
+        LabelSelector: sshFlappingLabel + "=true",
+    })
+    if err != nil {
+        return nil
+    }
+    flaps := make([]sshUsernameFlap, 0, len(vms.Items))
+    for _, vm := range vms.Items {
+        sshUser, _, _ := unstructured.NestedString(vm.Object, "spec", "ssh_username")
+        flaps = append(flaps, sshUsernameFlap{
+            VirtualMachine: vm.GetName(),
+            Namespace:      vm.GetNamespace(),
+            SSHUsername:    sshUser,
+            ResetBy:        vm.GetAnnotations()[sshResetByAnnotation],
+        })
+    }
+    sort.Slice(flaps, func(i, j int) bool { return flaps[i].VirtualMachine < flaps[j].VirtualMachine })
+    return flaps
+}
+
+func writeSSHFlapMetrics(w io.Writer, client dynamic.Interface) {
+    resetBy := map[string]int{}
+    for _, flap := range currentSSHUsernameFlaps(client) {
+        manager, _, _ := strings.Cut(flap.ResetBy, " (")
+        resetBy[manager]++
+    }
+    managers := make([]string, 0, len(resetBy))
+    for manager := range resetBy {
+        managers = append(managers, manager)
+    }
+    sort.Strings(managers)
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ssh_username_flapping_vms VirtualMachines whose ssh_username is no longer rewritten, by the manager resetting it")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ssh_username_flapping_vms gauge")
+    for _, manager := range managers {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_ssh_username_flapping_vms{reset_by=%q} %d\n", manager, resetBy[manager])
+    }
+}
@@ -0,0 +1,199 @@
+// internal/ssh_fix_metrics.go - Count ssh_username corrections on HobbyFarm VirtualMachines
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "sort"
+    "strconv"
+    "strings"
+    "sync"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// HobbyFarm creates VirtualMachines with an ssh_username that doesn't match our VMs,
+// and each update path below rewrites it. Counting the corrections shows whether the
+// upstream bug is still there, and which of the paths can be retired once it is gone.
+const (
+    sshFixSourceHobbyFarmController = "hobbyfarm-controller"
+    sshFixSourceKratixIntegration   = "kratix-integration"
+)
+
+// Stamped on a VirtualMachine whenever its ssh_username is corrected
+const (
+    sshFixedAtAnnotation   = "provisioning.hobbyfarm.io/ssh-username-fixed-at"
+    sshFixedFromAnnotation = "provisioning.hobbyfarm.io/ssh-username-fixed-from"
+)
+
+// Counts survive restarts in a ConfigMap, keyed <source>.<vm-type>.<previous value>
+func getSSHFixConfigMapName() string {
+    if name := Setting("SSH_FIX_METRICS_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-ssh-fixes"
+}
+
+type sshFixKey struct {
+    source   string
+    vmType   string
+    previous string
+}
+
+// ConfigMap keys only allow [-._a-zA-Z0-9], an empty ssh_username is stored as "unset"
+func (key sshFixKey) dataKey() string {
+    previous := key.previous
+    if previous == "" {
+        previous = "unset"
+    }
+    return strings.Join([]string{key.source, key.vmType, previous}, ".")
+}
+
+var sshFixCounts = struct {
+    sync.Mutex
+    loaded bool
+    counts map[sshFixKey]int64
+}{counts: map[sshFixKey]int64{}}
+
+// Called with sshFixCounts held
+func loadSSHFixCounts(client dynamic.Interface) {
+    if sshFixCounts.loaded {
+        return
+    }
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(
+        context.TODO(), getSSHFixConfigMapName(), metav1.GetOptions{})
+    if err != nil {
+        if !errors.IsNotFound(err) {
+            log.Printf("⚠️ Could not read ssh_username fix counts: %v", err)
+            return
+        }
+        sshFixCounts.loaded = true
+        return
+    }
+
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    for dataKey, value := range data {
+        parts := strings.SplitN(dataKey, ".", 3)
+        count, err := strconv.ParseInt(value, 10, 64)
+        if len(parts) != 3 || err != nil {
+            continue
+        }
+        key := sshFixKey{source: parts[0], vmType: parts[1], previous: parts[2]}
+        if key.previous == "unset" {
+            key.previous = ""
+        }
+        sshFixCounts.counts[key] += count
+    }
+    sshFixCounts.loaded = true
+}
+
+func saveSSHFixCount(client dynamic.Interface, key sshFixKey, count int64) error {
+    data := map[string]interface{}{key.dataKey(): strconv.FormatInt(count, 10)}
+    patchBytes, _ := json.Marshal(map[string]interface{}{"data": data})
+
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), getSSHFixConfigMapName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsNotFound(err) {
+        configMap := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "v1",
+                "kind":       "ConfigMap",
+                "metadata": map[string]interface{}{
+                    "name":      getSSHFixConfigMapName(),
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "data": data,
+            },
+        }
+        _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+    }
+    return err
+}
+
+// Record that source is about to overwrite vm's ssh_username with sshUser. Nothing is
+// recorded when the VM already has the right value or sshUser was held back
+// because the VM flaps.
+func recordSSHUsernameFix(client dynamic.Interface, vm *unstructured.Unstructured, vmIP, sshUser, source string) {
+    previous, _, _ := unstructured.NestedString(vm.Object, "spec", "ssh_username")
+    if sshUser == "" || previous == sshUser {
+        return
+    }
+    key := sshFixKey{source: source, vmType: strings.ToLower(getVMType(vmIP)), previous: previous}
+
+    sshFixCounts.Lock()
+    loadSSHFixCounts(client)
+    sshFixCounts.counts[key]++
+    count := sshFixCounts.counts[key]
+    if err := saveSSHFixCount(client, key, count); err != nil {
+        log.Printf("⚠️ Could not persist ssh_username fix count: %v", err)
+    }
+    sshFixCounts.Unlock()
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{
+                sshFixedAtAnnotation:   time.Now().Format(time.RFC3339),
+                sshFixedFromAnnotation: previous,
+                sshFixesAnnotation:     strconv.Itoa(sshUsernameFixes(vm) + 1),
+            },
+        },
+    })
+    if _, err := client.Resource(virtualMachineGVR).Namespace(vm.GetNamespace()).Patch(
+        context.TODO(), vm.GetName(), types.MergePatchType, patchBytes, metav1.PatchOptions{FieldManager: vmFieldManager}); err != nil {
+        log.Printf("⚠️ Could not annotate ssh_username fix on VirtualMachine %s: %v", vm.GetName(), err)
+    }
+
+    log.Printf("🩹 %s corrected ssh_username of VirtualMachine %s from %q to %q (%s VM, %d times so far)",
+        source, vm.GetName(), previous, sshUser, key.vmType, count)
+}
+
+// GET /metrics, Prometheus text format: ssh_username fixes and flapping VMs, controller heartbeats, failed requests,
+// the ready SLO, tracking caches and discovered resources
+func (ws *WebhookServer) metricsHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    sshFixCounts.Lock()
+    loadSSHFixCounts(ws.client)
+    counts := make(map[sshFixKey]int64, len(sshFixCounts.counts))
+    keys := make([]sshFixKey, 0, len(sshFixCounts.counts))
+    for key, count := range sshFixCounts.counts {
+        counts[key] = count
+        keys = append(keys, key)
+    }
+    sshFixCounts.Unlock()
+
+    sort.Slice(keys, func(i, j int) bool { return keys[i].dataKey() < keys[j].dataKey() })
+
+    w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_ssh_username_fixes_total ssh_username corrections made on HobbyFarm VirtualMachines")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_ssh_username_fixes_total counter")
+    for _, key := range keys {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_ssh_username_fixes_total{source=%q,vm_type=%q,previous=%q} %d\n",
+            key.source, key.vmType, key.previous, counts[key])
+    }
+    writeSSHFlapMetrics(w, ws.client)
+    writeHeartbeatMetrics(w)
+    writeFailureMetrics(w, ws.client)
+    writeReadySLOMetrics(w, ws.client)
+    writeShardMetrics(w)
+    writeEventBusMetrics(w)
+    writeClientThrottlingMetrics(w)
+    writeClusterAvailabilityMetrics(w)
+    writeTrackingCacheMetrics(w)
+    writeDiscoveryMetrics(w)
+    writeAPIAvailabilityMetrics(w)
+}
@@ -0,0 +1,148 @@
+// internal/ssh_users.go - Login a VM is expected to take, by cloud image, provider and static pool entry
+package internal
+
+import (
+    "context"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Cloud provider of the fallback instances; the only one supported
+const defaultCloudProvider = "aws"
+
+// Logins of stock images: Ubuntu AMIs use ubuntu, Amazon Linux ec2-user, Debian admin
+var builtinCloudSSHUsers = map[string]string{
+    defaultCloudProvider: "ubuntu",
+}
+
+// CLOUD_SSH_USERS maps AMI IDs or providers to the login of their instances,
+// e.g. "ami-0abc=ec2-user,ami-0def=admin,aws=ubuntu". An AMI entry beats its provider's.
+func getCloudSSHUsers() map[string]string {
+    users := map[string]string{}
+    for key, user := range builtinCloudSSHUsers {
+        users[key] = user
+    }
+    for _, entry := range splitEnvList("CLOUD_SSH_USERS") {
+        key, user, found := strings.Cut(entry, "=")
+        if found && strings.TrimSpace(key) != "" && strings.TrimSpace(user) != "" {
+            users[strings.TrimSpace(key)] = strings.TrimSpace(user)
+        }
+    }
+    return users
+}
+
+// Login of instances launched from ami on provider
+func cloudSSHUser(ami, provider string) string {
+    users := getCloudSSHUsers()
+    if user := users[ami]; ami != "" && user != "" {
+        return user
+    }
+    if user := users[provider]; user != "" {
+        return user
+    }
+    return builtinCloudSSHUsers[defaultCloudProvider]
+}
+
+// AMI of the cloud instance holding vmIP; the template's when the instance isn't found
+func cloudInstanceAMI(client dynamic.Interface, vmIP string) string {
+    if client != nil {
+        instances, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+        if err == nil {
+            for _, instance := range instances.Items {
+                instanceIP, _, _ := unstructured.NestedString(instance.Object, "status", "vmIP")
+                if instanceIP != vmIP {
+                    continue
+                }
+                if ami, _, _ := unstructured.NestedString(instance.Object, "spec", "ami"); ami != "" {
+                    return ami
+                }
+            }
+        }
+    }
+    return loadCloudInstanceTemplate().AMI
+}
+
+// Login of a static pool entry: its own, else its environment's
+func (env vmEnvironment) sshUserFor(vmIP string) string {
+    if user := env.SSHUsers[vmIP]; user != "" {
+        return user
+    }
+    return env.SSHUser
+}
+
+// Login a VM should take: NetBox's for the host, else the image's for cloud
+// instances and the pool entry's for static VMs of env
+func expectedSSHUser(client dynamic.Interface, env vmEnvironment, vmIP string) string {
+    if host, found := netboxHostForIP(vmIP); found && host.SSHUser != "" {
+        return host.SSHUser
+    }
+    if isPublicIP(vmIP) {
+        return cloudSSHUser(cloudInstanceAMI(client, vmIP), defaultCloudProvider)
+    }
+    if env.SSHUser == "" {
+        env = builtinDefaultEnvironment()
+    }
+    return env.sshUserFor(vmIP)
+}
+
+// Logins to try on a VM, most specific first: NetBox's, the pool entry's, its
+// tenant's and environment's for static VMs, the image's for cloud instances,
+// then the usual ones
+func sshUserCandidates(client dynamic.Interface, vmIP string) []string {
+    var users []string
+    seen := map[string]bool{}
+    add := func(candidates ...string) {
+        for _, user := range candidates {
+            if user != "" && !seen[user] {
+                seen[user] = true
+                users = append(users, user)
+            }
+        }
+    }
+
+    if host, found := netboxHostForIP(vmIP); found {
+        add(host.SSHUser)
+    }
+    if isPublicIP(vmIP) {
+        add(cloudSSHUser(cloudInstanceAMI(client, vmIP), defaultCloudProvider))
+        add("ubuntu", "ec2-user", "admin")
+        return users
+    }
+    if environment, found := environmentForIP(vmIP); found {
+        add(environment.SSHUsers[vmIP])
+        add(tenantSSHUsers(vmIP)...)
+        add(environment.SSHUser)
+    }
+    add("kube", "ubuntu", "admin")
+    return users
+}
+
+// Every CLOUD_SSH_USERS entry is <ami-or-provider>=<user>, and per-entry logins
+// name VMs of their environment's pool
+func validateSSHUsers(check *ConfigCheck) {
+    for _, entry := range splitEnvList("CLOUD_SSH_USERS") {
+        key, user, found := strings.Cut(entry, "=")
+        if !found || strings.TrimSpace(key) == "" || strings.TrimSpace(user) == "" {
+            check.fail("CLOUD_SSH_USERS: %q is not <ami-or-provider>=<user>", entry)
+        }
+    }
+    for name, environment := range loadVMEnvironments() {
+        for ip := range environment.SSHUsers {
+            if !containsIP(environment.StaticVMs, ip) {
+                check.warn("environment %s: sshUsers names %s, which is not in its staticVMs", name, ip)
+            }
+        }
+    }
+}
+
+func containsIP(pool []string, ip string) bool {
+    for _, poolIP := range pool {
+        if poolIP == ip {
+            return true
+        }
+    }
+    return false
+}
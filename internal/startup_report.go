@@ -0,0 +1,329 @@
+// internal/startup_report.go - One report of what the first reconcile pass after a restart found
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "sort"
+    "sync"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// Key of the report in the provisioner status ConfigMap
+const startupReportKey = "startupReport"
+
+// What the provisioner found after a restart, for auditing what it took over
+type startupReport struct {
+    GeneratedAt     string                 `json:"generatedAt"`
+    Sessions        startupSessionCounts   `json:"sessions"`
+    Requests        map[string]int         `json:"requests"`
+    TrainingVMs     map[string]int         `json:"trainingVMs,omitempty"`
+    Pools           []startupPoolState     `json:"pools"`
+    CloudInstances  startupCloudCounts     `json:"cloudInstances"`
+    Inconsistencies []startupInconsistency `json:"inconsistencies"`
+    Unavailable     []string               `json:"unavailable,omitempty"`
+}
+
+// Active sessions are satisfied once their VM is ready, pending until then
+type startupSessionCounts struct {
+    Discovered int `json:"discovered"`
+    Satisfied  int `json:"satisfied"`
+    Pending    int `json:"pending"`
+    Finished   int `json:"finished"`
+}
+
+type startupPoolState struct {
+    Environment string `json:"environment"`
+    Total       int    `json:"total"`
+    Allocated   int    `json:"allocated"`
+    Maintenance int    `json:"maintenance"`
+    Free        int    `json:"free"`
+    Draining    int    `json:"draining,omitempty"`
+}
+
+type startupCloudCounts struct {
+    Total int `json:"total"`
+    Idle  int `json:"idle"`
+}
+
+// Something the cluster state disagrees with itself about, and what would fix it.
+// Nothing is fixed automatically; the report only proposes.
+type startupInconsistency struct {
+    Kind        string `json:"kind"`
+    Object      string `json:"object"`
+    Detail      string `json:"detail"`
+    ProposedFix string `json:"proposedFix"`
+}
+
+// Request states that hold their VM
+func holdsVM(state string) bool {
+    switch state {
+    case provisioner.StateAllocated, provisioner.StateProvisioning, provisioner.StateProvisionedUnverified, provisioner.StateReady:
+        return true
+    }
+    return false
+}
+
+// Build the report from cluster state. Kinds that can't be listed are named in
+// Unavailable and the checks needing them skipped, so a report always comes out.
+func buildStartupReport(client dynamic.Interface) startupReport {
+    report := startupReport{
+        GeneratedAt:     time.Now().Format(time.RFC3339),
+        Requests:        map[string]int{},
+        Inconsistencies: []startupInconsistency{},
+    }
+    // Items of a kind, or false with the reason it is missing from the report
+    list := func(what, unavailable string, gvrList func() (*unstructured.UnstructuredList, error)) ([]unstructured.Unstructured, bool) {
+        if unavailable != "" {
+            report.Unavailable = append(report.Unavailable, fmt.Sprintf("%s (%s)", what, unavailable))
+            return nil, false
+        }
+        items, err := gvrList()
+        if err != nil {
+            report.Unavailable = append(report.Unavailable, fmt.Sprintf("%s (%v)", what, err))
+            return nil, false
+        }
+        return items.Items, true
+    }
+    missingUnless := func(api string) string {
+        if apiAvailable(api) {
+            return ""
+        }
+        return "CRD not installed"
+    }
+
+    sessionsUnavailable := ""
+    if Setting("INTEGRATION_MODE") == "kratix-only" {
+        sessionsUnavailable = "kratix-only mode"
+    }
+    sessions, sessionsListed := list("Sessions", sessionsUnavailable, func() (*unstructured.UnstructuredList, error) {
+        return client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    })
+    requests, requestsListed := list("VMProvisioningRequests", missingUnless(apiKratix), func() (*unstructured.UnstructuredList, error) {
+        return client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    })
+    trainingVMs, _ := list("TrainingVMs", missingUnless(apiTraining), func() (*unstructured.UnstructuredList, error) {
+        return client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    })
+    instances, _ := list("EC2TrainingVMs", missingUnless(apiCrossplane), func() (*unstructured.UnstructuredList, error) {
+        return client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    })
+
+    // Who holds each VM, to find VMs held twice and sessions already served
+    holders := map[string][]string{}
+    readySessions := map[string]bool{}
+    requestNames := map[string]bool{}
+    for i := range requests {
+        request := &requests[i]
+        requestNames[request.GetName()] = true
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state == "" {
+            state = provisioner.StatePending
+        }
+        report.Requests[state]++
+        session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+        if state == provisioner.StateReady && session != "" {
+            readySessions[session] = true
+        }
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        if vmIP != "" && holdsVM(state) {
+            holders[vmIP] = append(holders[vmIP], "VMProvisioningRequest/"+request.GetName())
+        }
+    }
+    if len(trainingVMs) > 0 {
+        report.TrainingVMs = map[string]int{}
+    }
+    for i := range trainingVMs {
+        tvm := &trainingVMs[i]
+        state, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
+        provisioned, _, _ := unstructured.NestedBool(tvm.Object, "status", "provisioned")
+        switch {
+        case state == "":
+            state = provisioner.StatePending
+        case state == provisioner.StateAllocated && provisioned:
+            state = "provisioned"
+        }
+        report.TrainingVMs[state]++
+        if session, _, _ := unstructured.NestedString(tvm.Object, "spec", "session"); session != "" && provisioned {
+            readySessions[session] = true
+        }
+        vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+        if vmIP != "" && state != provisioner.StatePending && state != provisioner.StateFailed {
+            holders[vmIP] = append(holders[vmIP], "TrainingVM/"+tvm.GetName())
+        }
+    }
+
+    activeSessions := map[string]bool{}
+    for i := range sessions {
+        report.Sessions.Discovered++
+        switch {
+        case isSessionFinished(&sessions[i]):
+            report.Sessions.Finished++
+        case readySessions[sessions[i].GetName()]:
+            report.Sessions.Satisfied++
+            activeSessions[sessions[i].GetName()] = true
+        default:
+            report.Sessions.Pending++
+            activeSessions[sessions[i].GetName()] = true
+        }
+    }
+
+    report.Inconsistencies = append(report.Inconsistencies, sessionlessAllocations(requests, activeSessions, sessionsListed)...)
+    report.Inconsistencies = append(report.Inconsistencies, sharedVMs(holders)...)
+
+    // Static pools, and allocations the pools or maintenance disagree with
+    maintenance := getMaintenanceVMs(client)
+    staticIPs := map[string]bool{}
+    environments := loadVMEnvironments()
+    names := make([]string, 0, len(environments))
+    for name := range environments {
+        names = append(names, name)
+    }
+    sort.Strings(names)
+    for _, name := range names {
+        pool := startupPoolState{Environment: name, Total: len(environments[name].StaticVMs), Draining: drainingCount(name)}
+        for _, ip := range environments[name].StaticVMs {
+            staticIPs[ip] = true
+            _, drained := maintenance[ip]
+            switch {
+            case len(holders[ip]) > 0:
+                pool.Allocated++
+                if drained {
+                    report.Inconsistencies = append(report.Inconsistencies, startupInconsistency{
+                        Kind:        "AllocatedInMaintenance",
+                        Object:      holders[ip][0],
+                        Detail:      fmt.Sprintf("VM %s is in maintenance but still allocated", ip),
+                        ProposedFix: "move the session to another VM with POST /reallocate?session=<session>",
+                    })
+                }
+            case drained:
+                pool.Maintenance++
+            default:
+                pool.Free++
+            }
+        }
+        report.Pools = append(report.Pools, pool)
+    }
+    for i := range requests {
+        request := &requests[i]
+        vmType, _, _ := unstructured.NestedString(request.Object, "status", "vmType")
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if _, draining := drainingEnvironment(vmIP); vmType == "static" && vmIP != "" && holdsVM(state) && !staticIPs[vmIP] && !draining {
+            report.Inconsistencies = append(report.Inconsistencies, startupInconsistency{
+                Kind:        "UnknownStaticVM",
+                Object:      "VMProvisioningRequest/" + request.GetName(),
+                Detail:      fmt.Sprintf("holds static VM %s, which no environment lists", vmIP),
+                ProposedFix: fmt.Sprintf("add %s back to its environment, or reset the request to pending with POST /reallocate", vmIP),
+            })
+        }
+    }
+
+    // Cloud instances, kept or idle, whose request is gone
+    for i := range instances {
+        instance := &instances[i]
+        report.CloudInstances.Total++
+        if instance.GetLabels()[cloudPoolLabel] == cloudPoolIdle {
+            report.CloudInstances.Idle++
+            continue
+        }
+        if owner := instance.GetLabels()["kratix-request"]; owner != "" && requestsListed && !requestNames[owner] {
+            report.Inconsistencies = append(report.Inconsistencies, startupInconsistency{
+                Kind:        "OrphanedCloudInstance",
+                Object:      "EC2TrainingVM/" + instance.GetName(),
+                Detail:      fmt.Sprintf("VMProvisioningRequest %s no longer exists", owner),
+                ProposedFix: fmt.Sprintf("kubectl delete ec2trainingvm %s", instance.GetName()),
+            })
+        }
+    }
+    return report
+}
+
+// Requests of HobbyFarm Sessions that are finished or gone but still hold a VM
+func sessionlessAllocations(requests []unstructured.Unstructured, activeSessions map[string]bool, sessionsListed bool) []startupInconsistency {
+    var found []startupInconsistency
+    if !sessionsListed {
+        return found
+    }
+    for i := range requests {
+        request := &requests[i]
+        session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        if session == "" || request.GetLabels()["source"] != "hobbyfarm-integration" || !holdsVM(state) || activeSessions[session] {
+            continue
+        }
+        found = append(found, startupInconsistency{
+            Kind:        "AllocatedWithoutSession",
+            Object:      "VMProvisioningRequest/" + request.GetName(),
+            Detail:      fmt.Sprintf("holds VM %s for session %s, which is finished or gone", vmIP, session),
+            ProposedFix: fmt.Sprintf("kubectl delete vmprovisioningrequest %s to release the VM", request.GetName()),
+        })
+    }
+    return found
+}
+
+// VMs held by more than one request or TrainingVM, which would overwrite each other
+func sharedVMs(holders map[string][]string) []startupInconsistency {
+    var found []startupInconsistency
+    ips := make([]string, 0, len(holders))
+    for ip := range holders {
+        ips = append(ips, ip)
+    }
+    sort.Strings(ips)
+    for _, ip := range ips {
+        if len(holders[ip]) < 2 {
+            continue
+        }
+        sort.Strings(holders[ip])
+        found = append(found, startupInconsistency{
+            Kind:        "VMHeldTwice",
+            Object:      "VM/" + ip,
+            Detail:      fmt.Sprintf("held by %v", holders[ip]),
+            ProposedFix: fmt.Sprintf("keep %s and move the others with POST /reallocate", holders[ip][0]),
+        })
+    }
+    return found
+}
+
+var startupReportOnce sync.Once
+
+// Log the report and store it in the status ConfigMap, once per process, after
+// whichever controller completes its first pass
+func reportStartupReconciliation(client dynamic.Interface) {
+    startupReportOnce.Do(func() {
+        report := buildStartupReport(client)
+        requests := 0
+        for _, count := range report.Requests {
+            requests += count
+        }
+
+        log.Printf("📋 Startup reconciliation: %d sessions (%d satisfied, %d pending, %d finished), %d requests, %d cloud instances, %d inconsistencies",
+            report.Sessions.Discovered, report.Sessions.Satisfied, report.Sessions.Pending, report.Sessions.Finished,
+            requests, report.CloudInstances.Total, len(report.Inconsistencies))
+        for _, pool := range report.Pools {
+            log.Printf("  📋 Pool %s: %d allocated, %d in maintenance, %d free of %d",
+                pool.Environment, pool.Allocated, pool.Maintenance, pool.Free, pool.Total)
+        }
+        for _, inconsistency := range report.Inconsistencies {
+            log.Printf("  ⚠️ %s %s: %s; proposed fix: %s",
+                inconsistency.Kind, inconsistency.Object, inconsistency.Detail, inconsistency.ProposedFix)
+        }
+        for _, unavailable := range report.Unavailable {
+            log.Printf("  ⚠️ Not in the report: %s", unavailable)
+        }
+
+        data, _ := json.Marshal(report)
+        if err := patchProvisionerStatus(client, map[string]interface{}{startupReportKey: string(data)}); err != nil {
+            log.Printf("⚠️ Could not store startup report in ConfigMap %s: %v", apiStatusConfigMap, err)
+        }
+    })
+}
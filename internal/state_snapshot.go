@@ -0,0 +1,432 @@
+// internal/state_snapshot.go - Periodic provisioner state snapshots in S3/MinIO, imported on bootstrap after losing the cluster
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "os"
+    "os/exec"
+    "path/filepath"
+    "strconv"
+    "strings"
+    "time"
+
+    apierrors "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Bumped when the snapshot layout changes incompatibly
+const stateSnapshotVersion = 1
+
+// Annotation through which Crossplane observes an existing cloud resource instead of creating one
+const externalNameAnnotation = "crossplane.io/external-name"
+
+// A kind of object in the snapshot, in restore order: pools and security groups
+// before the instances and requests that refer to them
+type stateSnapshotKind struct {
+    key        string
+    gvr        schema.GroupVersionResource
+    namespaced bool
+    selector   string
+    restore    bool
+    status     bool
+}
+
+var stateSnapshotKinds = []stateSnapshotKind{
+    {key: "configMaps", gvr: configMapGVR, namespaced: true, restore: true},
+    {key: "staticVMPools", gvr: staticVMPoolGVR, namespaced: true, restore: true},
+    {key: "securityGroups", gvr: securityGroupGVR, selector: "app=hobbyfarm-provisioner", restore: true},
+    {key: "securityGroupIngressRules", gvr: securityGroupIngressRuleGVR, selector: "app=hobbyfarm-provisioner", restore: true},
+    {key: "ec2TrainingVMs", gvr: ec2TrainingVMGVR, namespaced: true, restore: true},
+    {key: "trainingVMs", gvr: trainingVMGVR, namespaced: true, restore: true, status: true},
+    {key: "vmProvisioningRequests", gvr: vmProvisioningRequestGVR, namespaced: true, restore: true, status: true},
+    // Allocation history and provisioning failures; kept as an audit trail, never re-created
+    {key: "events", gvr: eventGVR, namespaced: true, selector: "app=hobbyfarm-provisioner"},
+}
+
+// Provisioner state at one point in time. The SSH key Secret is deliberately not
+// included: restore it from wherever the original came from.
+type stateSnapshot struct {
+    Version   int                                 `json:"version"`
+    TakenAt   string                              `json:"takenAt"`
+    TakenBy   string                              `json:"takenBy"`
+    Resources map[string][]map[string]interface{} `json:"resources"`
+}
+
+// Snapshots are enabled by setting STATE_BUCKET
+func stateSnapshotsEnabled() bool {
+    return Setting("STATE_BUCKET") != ""
+}
+
+func getStatePrefix() string {
+    if prefix := strings.Trim(Setting("STATE_PREFIX"), "/"); prefix != "" {
+        return prefix
+    }
+    return "provisioner-state"
+}
+
+func getStateSnapshotInterval() time.Duration {
+    if minutes, err := strconv.Atoi(Setting("STATE_SNAPSHOT_MINUTES")); err == nil && minutes > 0 {
+        return time.Duration(minutes) * time.Minute
+    }
+    return 15 * time.Minute
+}
+
+func getStateRetentionDays() int {
+    if days, err := strconv.Atoi(Setting("STATE_RETENTION_DAYS")); err == nil && days > 0 {
+        return days
+    }
+    return 7
+}
+
+// Prepend --endpoint-url for an S3-compatible store such as an air-gapped MinIO
+func stateCLIArgs(args ...string) []string {
+    if endpoint := Setting("STATE_ENDPOINT"); endpoint != "" {
+        return append([]string{"--endpoint-url", endpoint}, args...)
+    }
+    return args
+}
+
+func stateBaseURL() string {
+    return fmt.Sprintf("s3://%s/%s/", Setting("STATE_BUCKET"), getStatePrefix())
+}
+
+// Only the ConfigMaps the provisioner owns; others belong to whoever restores the cluster
+func isProvisionerConfigMap(name string) bool {
+    switch name {
+    case getMaintenanceConfigMapName(), getPoolCandidatesConfigMapName(), getSSHFixConfigMapName():
+        return true
+    }
+    return false
+}
+
+// Drop the server-assigned metadata a new cluster would reject or get wrong
+func snapshotObject(item unstructured.Unstructured) map[string]interface{} {
+    object := item.DeepCopy().Object
+    for _, field := range []string{"uid", "resourceVersion", "generation", "creationTimestamp", "managedFields", "ownerReferences", "selfLink"} {
+        unstructured.RemoveNestedField(object, "metadata", field)
+    }
+    unstructured.RemoveNestedField(object, "metadata", "annotations", "kubectl.kubernetes.io/last-applied-configuration")
+    return object
+}
+
+// Collect the snapshot from the cluster. A kind that can't be listed, such as
+// the EC2 ones without Crossplane installed, is left out with a warning.
+func takeStateSnapshot(client dynamic.Interface) *stateSnapshot {
+    hostname, _ := os.Hostname()
+    snapshot := &stateSnapshot{
+        Version:   stateSnapshotVersion,
+        TakenAt:   time.Now().UTC().Format(time.RFC3339),
+        TakenBy:   hostname,
+        Resources: map[string][]map[string]interface{}{},
+    }
+
+    for _, kind := range stateSnapshotKinds {
+        resource := client.Resource(kind.gvr)
+        var list *unstructured.UnstructuredList
+        var err error
+        if kind.namespaced {
+            list, err = resource.Namespace("default").List(context.TODO(), metav1.ListOptions{LabelSelector: kind.selector})
+        } else {
+            list, err = resource.List(context.TODO(), metav1.ListOptions{LabelSelector: kind.selector})
+        }
+        if err != nil {
+            log.Printf("⚠️ State snapshot skips %s: %v", kind.key, err)
+            continue
+        }
+
+        objects := make([]map[string]interface{}, 0, len(list.Items))
+        for _, item := range list.Items {
+            if kind.gvr == configMapGVR && !isProvisionerConfigMap(item.GetName()) {
+                continue
+            }
+            objects = append(objects, snapshotObject(item))
+        }
+        snapshot.Resources[kind.key] = objects
+    }
+    return snapshot
+}
+
+// Upload the snapshot as <prefix>/<date>/<time>.json and as <prefix>/latest.json,
+// which is what a bootstrap imports
+func uploadStateSnapshot(snapshot *stateSnapshot) (string, error) {
+    data, err := json.MarshalIndent(snapshot, "", "  ")
+    if err != nil {
+        return "", fmt.Errorf("failed to encode state snapshot: %v", err)
+    }
+
+    tmpDir, err := os.MkdirTemp("", "provisioner-state-")
+    if err != nil {
+        return "", fmt.Errorf("failed to create snapshot dir: %v", err)
+    }
+    defer os.RemoveAll(tmpDir)
+    path := filepath.Join(tmpDir, "state.json")
+    if err := os.WriteFile(path, data, 0600); err != nil {
+        return "", fmt.Errorf("failed to write state snapshot: %v", err)
+    }
+
+    takenAt, _ := time.Parse(time.RFC3339, snapshot.TakenAt)
+    destination := stateBaseURL() + takenAt.Format("2006-01-02") + "/" + takenAt.Format("150405") + ".json"
+    for _, target := range []string{destination, stateBaseURL() + "latest.json"} {
+        cmd := outboundCommand("aws", stateCLIArgs("s3", "cp", "--only-show-errors", path, target)...)
+        if output, err := cmd.CombinedOutput(); err != nil {
+            return "", fmt.Errorf("state snapshot upload to %s failed: %v: %s", target, err, strings.TrimSpace(string(output)))
+        }
+    }
+    return destination, nil
+}
+
+// Take and upload one snapshot, then sweep snapshots past STATE_RETENTION_DAYS
+func SnapshotState(client dynamic.Interface) {
+    if !stateSnapshotsEnabled() {
+        return
+    }
+    snapshot := takeStateSnapshot(client)
+    destination, err := uploadStateSnapshot(snapshot)
+    if err != nil {
+        log.Printf("❌ %v", err)
+        return
+    }
+    log.Printf("💾 Provisioner state saved to %s (%d requests, %d TrainingVMs, %d cloud instances)", destination,
+        len(snapshot.Resources["vmProvisioningRequests"]), len(snapshot.Resources["trainingVMs"]), len(snapshot.Resources["ec2TrainingVMs"]))
+
+    cutoff := time.Now().UTC().AddDate(0, 0, -getStateRetentionDays())
+    removeExpiredDayPrefixes(stateBaseURL(), cutoff, stateCLIArgs)
+}
+
+// Snapshot every STATE_SNAPSHOT_MINUTES until ctx is done
+func RunStateSnapshots(ctx context.Context, client dynamic.Interface) {
+    if !stateSnapshotsEnabled() {
+        return
+    }
+    log.Printf("💾 Saving provisioner state to %s every %v", stateBaseURL(), getStateSnapshotInterval())
+    ticker := time.NewTicker(getStateSnapshotInterval())
+    defer ticker.Stop()
+
+    for {
+        SnapshotState(client)
+        select {
+        case <-ctx.Done():
+            return
+        case <-ticker.C:
+        }
+    }
+}
+
+// Download a snapshot: STATE_RESTORE_KEY, relative to the prefix, or the latest one
+func downloadStateSnapshot() (*stateSnapshot, string, error) {
+    key := strings.Trim(Setting("STATE_RESTORE_KEY"), "/")
+    if key == "" {
+        key = "latest.json"
+    }
+    source := stateBaseURL() + key
+    output, err := outboundCommand("aws", stateCLIArgs("s3", "cp", "--only-show-errors", source, "-")...).Output()
+    if err != nil {
+        return nil, source, fmt.Errorf("failed to download state snapshot %s: %v", source, err)
+    }
+
+    snapshot := &stateSnapshot{}
+    if err := json.Unmarshal(output, snapshot); err != nil {
+        return nil, source, fmt.Errorf("state snapshot %s is not valid JSON: %v", source, err)
+    }
+    if snapshot.Version != stateSnapshotVersion {
+        return nil, source, fmt.Errorf("state snapshot %s has version %d, expected %d", source, snapshot.Version, stateSnapshotVersion)
+    }
+    return snapshot, source, nil
+}
+
+// Shape a snapshotted object for re-creation. Cloud claims get the instance ID
+// as external name, which the composition hands to the Instance, so Crossplane
+// adopts the running instance; the claim's link to its old composite is dropped,
+// and its status is left to Crossplane.
+func restorableObject(kind stateSnapshotKind, object map[string]interface{}) (*unstructured.Unstructured, map[string]interface{}) {
+    obj := &unstructured.Unstructured{Object: object}
+    status, _, _ := unstructured.NestedMap(obj.Object, "status")
+    unstructured.RemoveNestedField(obj.Object, "status")
+
+    if kind.gvr == ec2TrainingVMGVR {
+        unstructured.RemoveNestedField(obj.Object, "spec", "resourceRef")
+        if instanceID, _, _ := unstructured.NestedString(status, "instanceId"); instanceID != "" {
+            annotations := obj.GetAnnotations()
+            if annotations == nil {
+                annotations = map[string]string{}
+            }
+            annotations[externalNameAnnotation] = instanceID
+            obj.SetAnnotations(annotations)
+        }
+    }
+    if !kind.status {
+        status = nil
+    }
+    return obj, status
+}
+
+// Whether the cluster already holds provisioner state, which an import must never overwrite
+func clusterHasProvisionerState(client dynamic.Interface) (bool, error) {
+    for _, gvr := range []schema.GroupVersionResource{vmProvisioningRequestGVR, trainingVMGVR} {
+        list, err := client.Resource(gvr).Namespace("default").List(context.TODO(), metav1.ListOptions{Limit: 1})
+        if err != nil {
+            return false, err
+        }
+        if len(list.Items) > 0 {
+            return true, nil
+        }
+    }
+    return false, nil
+}
+
+// Re-create the snapshotted objects with their status, so the controllers started
+// afterwards recover allocations and carry on with the VMs still running
+func restoreStateSnapshot(client dynamic.Interface, snapshot *stateSnapshot) (int, int) {
+    restored, failed := 0, 0
+    for _, kind := range stateSnapshotKinds {
+        if !kind.restore {
+            continue
+        }
+        for _, object := range snapshot.Resources[kind.key] {
+            obj, status := restorableObject(kind, object)
+            namespace := ""
+            if kind.namespaced {
+                namespace = obj.GetNamespace()
+                if namespace == "" {
+                    namespace = "default"
+                }
+                obj.SetNamespace(namespace)
+            }
+
+            _, err := client.Resource(kind.gvr).Namespace(namespace).Create(context.TODO(), obj, metav1.CreateOptions{})
+            if apierrors.IsAlreadyExists(err) {
+                continue
+            }
+            if err != nil {
+                log.Printf("❌ Failed to restore %s %s: %v", kind.key, obj.GetName(), err)
+                failed++
+                continue
+            }
+            if len(status) > 0 {
+                patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+                if err := patchStatus(client, kind.gvr, namespace, obj.GetName(), patchBytes); err != nil {
+                    log.Printf("❌ Failed to restore status of %s %s: %v", kind.key, obj.GetName(), err)
+                    failed++
+                    continue
+                }
+            }
+            restored++
+        }
+    }
+    return restored, failed
+}
+
+// Records an import in progress or done, so a restart resumes an interrupted
+// import and never repeats a finished one
+const stateImportConfigMap = "hobbyfarm-provisioner-state-import"
+
+func getStateImport(client dynamic.Interface) (map[string]string, error) {
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(context.TODO(), stateImportConfigMap, metav1.GetOptions{})
+    if apierrors.IsNotFound(err) {
+        return nil, nil
+    }
+    if err != nil {
+        return nil, err
+    }
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    return data, nil
+}
+
+func setStateImport(client dynamic.Interface, source, phase string) error {
+    data := map[string]interface{}{
+        "source":    source,
+        "phase":     phase,
+        "updatedAt": time.Now().UTC().Format(time.RFC3339),
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"data": data})
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), stateImportConfigMap, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if apierrors.IsNotFound(err) {
+        configMap := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "v1",
+                "kind":       "ConfigMap",
+                "metadata": map[string]interface{}{
+                    "name":      stateImportConfigMap,
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "data": data,
+            },
+        }
+        _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+    }
+    return err
+}
+
+// With STATE_RESTORE=true, import a snapshot before the controllers start. Only a
+// cluster without requests or TrainingVMs is imported into, or one whose import
+// was interrupted, so leaving the flag on after the rebuild can't bring back
+// requests deleted since.
+func RestoreStateOnBootstrap(client dynamic.Interface) error {
+    if Setting("STATE_RESTORE") != "true" {
+        return nil
+    }
+    if !stateSnapshotsEnabled() {
+        return fmt.Errorf("STATE_RESTORE=true needs STATE_BUCKET")
+    }
+
+    previous, err := getStateImport(client)
+    if err != nil {
+        return fmt.Errorf("could not read state import record: %v", err)
+    }
+    if previous["phase"] == "complete" {
+        log.Printf("💾 Provisioner state was already imported from %s, skipping", previous["source"])
+        return nil
+    }
+    if previous == nil {
+        populated, err := clusterHasProvisionerState(client)
+        if err != nil {
+            return fmt.Errorf("could not check for existing provisioner state: %v", err)
+        }
+        if populated {
+            log.Println("💾 Cluster already holds provisioner state, skipping state import")
+            return nil
+        }
+    }
+
+    snapshot, source, err := downloadStateSnapshot()
+    if err != nil {
+        return err
+    }
+    if err := setStateImport(client, source, "importing"); err != nil {
+        return fmt.Errorf("could not record state import: %v", err)
+    }
+    log.Printf("💾 Importing provisioner state from %s, taken %s by %s", source, snapshot.TakenAt, snapshot.TakenBy)
+    restored, failed := restoreStateSnapshot(client, snapshot)
+    log.Printf("💾 Restored %d objects from %s, %d failed", restored, source, failed)
+    if failed > 0 {
+        return fmt.Errorf("%d objects could not be restored from %s", failed, source)
+    }
+    return setStateImport(client, source, "complete")
+}
+
+// The aws CLI is there to upload snapshots, and a restore has somewhere to read from
+func validateStateSnapshots(check *ConfigCheck) {
+    if !stateSnapshotsEnabled() {
+        if Setting("STATE_RESTORE") == "true" {
+            check.fail("STATE_RESTORE=true but STATE_BUCKET is not set")
+        }
+        return
+    }
+    if _, err := exec.LookPath("aws"); err != nil {
+        check.fail("STATE_BUCKET is set but the aws CLI is not installed")
+    }
+    if endpoint := Setting("STATE_ENDPOINT"); endpoint != "" && !strings.HasPrefix(endpoint, "http://") && !strings.HasPrefix(endpoint, "https://") {
+        check.fail("STATE_ENDPOINT %q is not an http(s) URL", endpoint)
+    }
+}
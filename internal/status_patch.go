@@ -0,0 +1,92 @@
+// internal/status_patch.go - Status patching that matches the installed CRD shape
+package internal
+
+import (
+    "context"
+    "log"
+    "sync"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/discovery"
+    "k8s.io/client-go/dynamic"
+)
+
+// Whether each resource serves a status subresource, detected once at startup.
+// Resources that were not discovered are assumed to have one, as the shipped CRDs do.
+var (
+    statusSubresources   = make(map[schema.GroupVersionResource]bool)
+    statusSubresourcesMu sync.RWMutex
+)
+
+// Resources whose status the provisioner writes
+var statusPatchedResources = []schema.GroupVersionResource{
+    trainingVMGVR,
+    vmProvisioningRequestGVR,
+    virtualMachineClaimGVR,
+    staticVMPoolGVR,
+    virtualMachineGVR,
+}
+
+// Ask the API server which resources expose <resource>/status
+func detectStatusSubresources(discoveryClient discovery.DiscoveryInterface) {
+    statusSubresourcesMu.Lock()
+    defer statusSubresourcesMu.Unlock()
+
+    for _, gvr := range statusPatchedResources {
+        resources, err := discoveryClient.ServerResourcesForGroupVersion(gvr.GroupVersion().String())
+        if err != nil {
+            log.Printf("⚠️ Could not discover %s, assuming status subresource: %v", gvr.GroupVersion(), err)
+            continue
+        }
+
+        hasStatus := false
+        for _, resource := range resources.APIResources {
+            if resource.Name == gvr.Resource+"/status" {
+                hasStatus = true
+                break
+            }
+        }
+        statusSubresources[gvr] = hasStatus
+
+        if !hasStatus {
+            log.Printf("⚠️ %s has no status subresource, status will be patched on the main resource", gvr.Resource)
+        }
+    }
+}
+
+func hasStatusSubresource(gvr schema.GroupVersionResource) bool {
+    statusSubresourcesMu.RLock()
+    defer statusSubresourcesMu.RUnlock()
+
+    hasStatus, detected := statusSubresources[gvr]
+    return !detected || hasStatus
+}
+
+// Merge-patch an object's status through the one endpoint that accepts it.
+// With the subresource enabled, main-resource patches silently drop status
+// and status patches drop everything else, so there is no fallback.
+func patchStatus(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name string, patch []byte) error {
+    return patchStatusAs(client, gvr, namespace, name, "", patch)
+}
+
+// patchStatus recording fieldManager as the owner of the patched fields, for
+// objects other controllers write as well
+func patchStatusAs(client dynamic.Interface, gvr schema.GroupVersionResource, namespace, name, fieldManager string, patch []byte) error {
+    var subresources []string
+    if hasStatusSubresource(gvr) {
+        subresources = []string{"status"}
+    }
+
+    // A merge patch applies the same twice, so retrying one the API server dropped is safe
+    err := retryAPI(func() error {
+        _, err := client.Resource(gvr).Namespace(namespace).Patch(
+            context.TODO(), name, types.MergePatchType, patch, metav1.PatchOptions{FieldManager: fieldManager}, subresources...)
+        return err
+    })
+    if err == nil {
+        markProvenance(client, gvr, namespace, name)
+    }
+    return err
+}
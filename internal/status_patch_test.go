@@ -0,0 +1,115 @@
+// internal/status_patch_test.go - Which endpoint status patches go to, by the discovered CRD shape
+package internal
+
+import (
+    "bytes"
+    "context"
+    "testing"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    fakediscovery "k8s.io/client-go/discovery/fake"
+    dynamicfake "k8s.io/client-go/dynamic/fake"
+    clienttesting "k8s.io/client-go/testing"
+)
+
+// Discovery serving trainingvms with a status subresource and
+// vmprovisioningrequests without one
+func statusDiscovery() *fakediscovery.FakeDiscovery {
+    return &fakediscovery.FakeDiscovery{Fake: &clienttesting.Fake{Resources: []*metav1.APIResourceList{
+        {
+            GroupVersion: trainingVMGVR.GroupVersion().String(),
+            APIResources: []metav1.APIResource{
+                {Name: trainingVMGVR.Resource, Namespaced: true, Kind: "TrainingVM"},
+                {Name: trainingVMGVR.Resource + "/status", Namespaced: true, Kind: "TrainingVM"},
+            },
+        },
+        {
+            GroupVersion: vmProvisioningRequestGVR.GroupVersion().String(),
+            APIResources: []metav1.APIResource{
+                {Name: vmProvisioningRequestGVR.Resource, Namespaced: true, Kind: "VMProvisioningRequest"},
+            },
+        },
+    }}}
+}
+
+// Forget what was detected once the test ends
+func resetStatusSubresources(t *testing.T) {
+    statusSubresourcesMu.Lock()
+    previous := statusSubresources
+    statusSubresources = make(map[schema.GroupVersionResource]bool)
+    statusSubresourcesMu.Unlock()
+    t.Cleanup(func() {
+        statusSubresourcesMu.Lock()
+        defer statusSubresourcesMu.Unlock()
+        statusSubresources = previous
+    })
+}
+
+func statusPatchObject(gvr schema.GroupVersionResource, kind, name string) *unstructured.Unstructured {
+    return &unstructured.Unstructured{Object: map[string]interface{}{
+        "apiVersion": gvr.GroupVersion().String(),
+        "kind":       kind,
+        "metadata":   map[string]interface{}{"name": name, "namespace": "default"},
+    }}
+}
+
+// The subresource of every status patch the client received, by resource
+func patchedSubresources(client *dynamicfake.FakeDynamicClient) map[string][]string {
+    patched := map[string][]string{}
+    for _, action := range client.Actions() {
+        if patch, ok := action.(clienttesting.PatchAction); ok && bytes.Contains(patch.GetPatch(), []byte(`"status"`)) {
+            patched[patch.GetResource().Resource] = append(patched[patch.GetResource().Resource], patch.GetSubresource())
+        }
+    }
+    return patched
+}
+
+func TestDetectStatusSubresources(t *testing.T) {
+    resetStatusSubresources(t)
+    detectStatusSubresources(statusDiscovery())
+
+    if !hasStatusSubresource(trainingVMGVR) {
+        t.Error("trainingvms serves /status but was detected without it")
+    }
+    if hasStatusSubresource(vmProvisioningRequestGVR) {
+        t.Error("vmprovisioningrequests has no /status but was detected with it")
+    }
+    // Not served at all: assumed to have one, as the shipped CRDs do
+    if !hasStatusSubresource(virtualMachineClaimGVR) {
+        t.Error("an undiscovered resource should be assumed to have /status")
+    }
+}
+
+func TestPatchStatusEndpoint(t *testing.T) {
+    resetStatusSubresources(t)
+    detectStatusSubresources(statusDiscovery())
+
+    client := dynamicfake.NewSimpleDynamicClientWithCustomListKinds(runtime.NewScheme(), harnessListKinds())
+    for gvr, object := range map[schema.GroupVersionResource]*unstructured.Unstructured{
+        trainingVMGVR:            statusPatchObject(trainingVMGVR, "TrainingVM", "vm-1"),
+        vmProvisioningRequestGVR: statusPatchObject(vmProvisioningRequestGVR, "VMProvisioningRequest", "req-1"),
+    } {
+        if _, err := client.Resource(gvr).Namespace("default").Create(context.TODO(), object, metav1.CreateOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    patch := []byte(`{"status":{"state":"ready"}}`)
+
+    if err := patchStatus(client, trainingVMGVR, "default", "vm-1", patch); err != nil {
+        t.Fatalf("patching trainingvm status: %v", err)
+    }
+    if err := patchStatus(client, vmProvisioningRequestGVR, "default", "req-1", patch); err != nil {
+        t.Fatalf("patching request status: %v", err)
+    }
+
+    patched := patchedSubresources(client)
+    if got := patched[trainingVMGVR.Resource]; len(got) == 0 || got[0] != "status" {
+        t.Errorf("trainingvm status patched through %q, want the status subresource", got)
+    }
+    if got := patched[vmProvisioningRequestGVR.Resource]; len(got) == 0 || got[0] != "" {
+        t.Errorf("request status patched through %q, want the main resource", got)
+    }
+}
@@ -0,0 +1,63 @@
+// internal/system_settings.go - Proxy, timezone, locale and CA bundle injected into every provisioning run
+package internal
+
+import (
+)
+
+// Set once on the deployment; an environment in the environments file overrides
+// any of them for its own VMs, e.g. a lab behind a different corporate proxy
+func withSystemSettingDefaults(env vmEnvironment) vmEnvironment {
+    defaults := map[*string]string{
+        &env.HTTPProxy:    Setting("PROVISIONING_HTTP_PROXY"),
+        &env.HTTPSProxy:   Setting("PROVISIONING_HTTPS_PROXY"),
+        &env.NoProxy:      Setting("PROVISIONING_NO_PROXY"),
+        &env.APTProxy:     Setting("PROVISIONING_APT_PROXY"),
+        &env.Timezone:     Setting("PROVISIONING_TIMEZONE"),
+        &env.Locale:       Setting("PROVISIONING_LOCALE"),
+        &env.CABundleFile: Setting("PROVISIONING_CA_BUNDLE_FILE"),
+    }
+    for field, value := range defaults {
+        if *field == "" {
+            *field = value
+        }
+    }
+    if env.Timezone == "" {
+        env.Timezone = "UTC"
+    }
+    if env.HTTPSProxy == "" {
+        env.HTTPSProxy = env.HTTPProxy
+    }
+    return env
+}
+
+// Inventory variables base.yaml and dynamic.yaml apply; unset settings are left out
+func (env vmEnvironment) systemSettingVars() map[string]string {
+    env = withSystemSettingDefaults(env)
+    settings := map[string]string{
+        "http_proxy":     env.HTTPProxy,
+        "https_proxy":    env.HTTPSProxy,
+        "no_proxy":       env.NoProxy,
+        "apt_proxy":      env.APTProxy,
+        "vm_timezone":    env.Timezone,
+        "vm_locale":      env.Locale,
+        "ca_bundle_file": env.CABundleFile,
+    }
+    vars := map[string]string{}
+    for name, value := range settings {
+        if value != "" {
+            vars[name] = value
+        }
+    }
+    return vars
+}
+
+// Add the environment's settings to a run. They are reserved variables, so a
+// course can't point VMs at another proxy or CA.
+func injectSystemSettings(config *ProvisioningConfig, env vmEnvironment) {
+    if config.Variables == nil {
+        config.Variables = map[string]string{}
+    }
+    for name, value := range env.systemSettingVars() {
+        config.Variables[name] = value
+    }
+}
@@ -0,0 +1,369 @@
+// internal/tenants.go - Tenants sharing one provisioner, each with its own environments, quotas and cloud account
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "errors"
+    "fmt"
+    "log"
+    "os"
+    "sort"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+
+    "hobbyfarm-vm-provisioner/pkg/provisioner"
+)
+
+// Returned when creating a cloud instance would exceed the tenant's quota; the request stays pending
+var errTenantQuotaReached = errors.New("tenant quota reached")
+
+// Label on Sessions, Scenarios, requests and cloud instances naming their tenant
+const tenantLabel = provisioner.TenantLabel
+
+// A team or customer served by this provisioner. Its environments, and so their
+// static VMs, are never handed to a request of another tenant or of no tenant.
+type tenant struct {
+    Name string `json:"-"`
+    // HobbyFarm namespaces whose Sessions, Scenarios and claims belong to the tenant
+    Namespaces []string `json:"namespaces"`
+    // Environments the tenant owns; the first one replaces "default" for its sessions
+    Environments []string `json:"environments"`
+    // VMs, static or cloud, the tenant may hold at once; 0 is unlimited
+    MaxVMs int `json:"maxVMs,omitempty"`
+    // Cloud instances the tenant may run at once, idle ones kept for reuse included
+    MaxCloudInstances int `json:"maxCloudInstances,omitempty"`
+    // Crossplane ProviderConfig holding the tenant's AWS credentials
+    ProviderConfigRef string `json:"providerConfigRef,omitempty"`
+    // SSH users tried first when detecting the login of the tenant's VMs
+    SSHUsers []string `json:"sshUsers,omitempty"`
+}
+
+// Tenants file mounted from the provisioner ConfigMap, a JSON object keyed by tenant name.
+// Without it the provisioner is single-tenant and nothing here applies.
+func getTenantsFile() string {
+    if path := Setting("TENANTS_FILE"); path != "" {
+        return path
+    }
+    return "/etc/provisioner/tenants.json"
+}
+
+// Tenants as the tenants file held when it was read, keyed by name. An
+// allocation pass reads the file once and decides every request and VM against
+// that, so an edit landing mid-pass can't give one pass two answers.
+type tenantSet map[string]tenant
+
+// Read on every call like the environments file, so ConfigMap edits apply without a restart
+func loadTenants() tenantSet {
+    tenants := tenantSet{}
+    data, err := os.ReadFile(getTenantsFile())
+    if err != nil {
+        if !os.IsNotExist(err) {
+            log.Printf("⚠️ Could not read tenants file %s: %v", getTenantsFile(), err)
+        }
+        return tenants
+    }
+    if err := json.Unmarshal(data, &tenants); err != nil {
+        log.Printf("⚠️ Ignoring invalid tenants file %s: %v", getTenantsFile(), err)
+        return tenantSet{}
+    }
+    for name, t := range tenants {
+        t.Name = name
+        tenants[name] = t
+    }
+    return tenants
+}
+
+func (tenants tenantSet) get(name string) (tenant, bool) {
+    if name == "" {
+        return tenant{}, false
+    }
+    t, found := tenants[name]
+    return t, found
+}
+
+func getTenant(name string) (tenant, bool) {
+    return loadTenants().get(name)
+}
+
+// The tenant owning a HobbyFarm namespace, empty for namespaces of no tenant
+func (tenants tenantSet) namespaceTenant(namespace string) string {
+    for name, t := range tenants {
+        for _, owned := range t.Namespaces {
+            if owned == namespace {
+                return name
+            }
+        }
+    }
+    return ""
+}
+
+func namespaceTenant(namespace string) string {
+    return loadTenants().namespaceTenant(namespace)
+}
+
+// The tenant of a request, TrainingVM or cloud instance: the tenant label the
+// provisioner stamped on it, see stampRequestTenant
+func (tenants tenantSet) objectTenant(object *unstructured.Unstructured) string {
+    if name := object.GetLabels()[tenantLabel]; name != "" {
+        return name
+    }
+    return tenants.namespaceTenant(object.GetNamespace())
+}
+
+func objectTenant(object *unstructured.Unstructured) string {
+    return loadTenants().objectTenant(object)
+}
+
+// The tenant of a HobbyFarm Session: the tenant owning its namespace, else its
+// Scenario's. Labels on Sessions and Scenarios are not trusted, anyone able to
+// create one could name any tenant.
+func resolveSessionTenant(client dynamic.Interface, session *unstructured.Unstructured) string {
+    if name := namespaceTenant(session.GetNamespace()); name != "" {
+        return name
+    }
+    scenario, _, _ := unstructured.NestedString(session.Object, "spec", "scenario")
+    return scenarioTenant(client, scenario)
+}
+
+// The tenant owning the namespace of a Scenario, looked up in tenant namespaces too
+func scenarioTenant(client dynamic.Interface, scenario string) string {
+    if scenario == "" {
+        return ""
+    }
+    if scenarioObj, err := getScenario(client, scenario, tenantLookupNamespaces()...); err == nil {
+        return namespaceTenant(scenarioObj.GetNamespace())
+    }
+    return ""
+}
+
+// Tenant namespaces, then HobbyFarm's own
+func tenantLookupNamespaces() []string {
+    var namespaces []string
+    for _, t := range loadTenants() {
+        namespaces = append(namespaces, t.Namespaces...)
+    }
+    sort.Strings(namespaces)
+    return append(namespaces, catalogNamespaces()...)
+}
+
+// The tenant a request belongs to, from the namespace of the Session or Scenario it
+// was created for. A request of neither belongs to no tenant.
+func requestTenant(client dynamic.Interface, request *unstructured.Unstructured) string {
+    session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+    if session == "" {
+        session = GetHobbyFarmSessionFromRequest(request)
+    }
+    if session != "" {
+        if sessionObj, err := getCatalogObject(client, sessionGVR, session, tenantLookupNamespaces()); err == nil {
+            return resolveSessionTenant(client, sessionObj)
+        }
+    }
+    scenario, _, _ := unstructured.NestedString(request.Object, "spec", "scenario")
+    return scenarioTenant(client, scenario)
+}
+
+// Set the request's tenant label to the tenant it derives, replacing or removing
+// whatever its creator put there, before anything reads it. Callers of
+// pkg/provisioner pass their own labels, so the label on a new request is not
+// trusted. Returns the request as patched.
+func (kc *KratixController) stampRequestTenant(request *unstructured.Unstructured) (*unstructured.Unstructured, error) {
+    derived := requestTenant(kc.client, request)
+    supplied := request.GetLabels()[tenantLabel]
+    if supplied == derived {
+        return request, nil
+    }
+    var value interface{}
+    if derived != "" {
+        value = derived
+    }
+    patch, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{"labels": map[string]interface{}{tenantLabel: value}},
+    })
+    patched, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
+        context.TODO(), request.GetName(), types.MergePatchType, patch, metav1.PatchOptions{})
+    if err != nil {
+        return nil, err
+    }
+    if supplied != "" {
+        log.Printf("🚫 Request %s named tenant %q, its session belongs to %q", request.GetName(), supplied, derived)
+        recordEvent(kc.client, request, eventTypeWarning, "TenantOverridden", fmt.Sprintf("tenant label %q replaced by %q, derived from the request's session", supplied, derived))
+    }
+    return patched, nil
+}
+
+// The tenant owning an environment, empty for environments shared by untenanted requests
+func (tenants tenantSet) environmentTenant(environment string) string {
+    for name, t := range tenants {
+        for _, owned := range t.Environments {
+            if owned == environment {
+                return name
+            }
+        }
+    }
+    return ""
+}
+
+func environmentTenant(environment string) string {
+    return loadTenants().environmentTenant(environment)
+}
+
+// Environment a tenant's request gets when nothing named one
+func tenantDefaultEnvironment(tenantName string) string {
+    if t, found := getTenant(tenantName); found && len(t.Environments) > 0 {
+        return t.Environments[0]
+    }
+    return defaultEnvironmentName
+}
+
+// Why the request may not take VMs from environment, empty when it may. Checked
+// against the VM's own environment too, so a static VM listed in two environments
+// can't cross over.
+func (tenants tenantSet) isolationRefusal(request *unstructured.Unstructured, environment string) string {
+    requestTenant := tenants.objectTenant(request)
+    owner := tenants.environmentTenant(environment)
+    switch {
+    case requestTenant != "" && owner != requestTenant:
+        return fmt.Sprintf("environment %s does not belong to tenant %s", environment, requestTenant)
+    case requestTenant == "" && owner != "":
+        return fmt.Sprintf("environment %s belongs to tenant %s", environment, owner)
+    }
+    return ""
+}
+
+func tenantIsolationRefusal(request *unstructured.Unstructured, environment string) string {
+    return loadTenants().isolationRefusal(request, environment)
+}
+
+// Whether the static VM at ip may go to the request, whichever of environments list it
+func (tenants tenantSet) staticVMAllowed(request *unstructured.Unstructured, ip string, environments map[string]vmEnvironment) bool {
+    for _, environment := range environments {
+        for _, staticIP := range environment.StaticVMs {
+            if staticIP == ip && tenants.isolationRefusal(request, environment.Name) != "" {
+                return false
+            }
+        }
+    }
+    return true
+}
+
+func staticVMAllowedForTenant(request *unstructured.Unstructured, ip string) bool {
+    return loadTenants().staticVMAllowed(request, ip, loadVMEnvironments())
+}
+
+// Why the tenant can't get another VM right now, empty when it can. The request
+// stays pending until one of the tenant's VMs is released.
+func (kc *KratixController) tenantQuotaRefusal(request *unstructured.Unstructured, cloud bool) string {
+    t, found := getTenant(objectTenant(request))
+    if !found || (t.MaxVMs == 0 && (!cloud || t.MaxCloudInstances == 0)) {
+        return ""
+    }
+
+    if t.MaxVMs > 0 {
+        held, err := tenantHeldVMs(kc.client, t.Name)
+        if err != nil {
+            return "could not count the tenant's VMs"
+        }
+        if held >= t.MaxVMs {
+            return fmt.Sprintf("tenant %s holds %d of %d VMs", t.Name, held, t.MaxVMs)
+        }
+    }
+
+    if cloud && t.MaxCloudInstances > 0 {
+        instances, err := tenantCloudInstances(kc.client, t.Name)
+        if err != nil {
+            return "could not count the tenant's cloud instances"
+        }
+        if instances >= t.MaxCloudInstances {
+            return fmt.Sprintf("tenant %s runs %d of %d cloud instances", t.Name, instances, t.MaxCloudInstances)
+        }
+    }
+    return ""
+}
+
+// VMs, static or cloud, held by the tenant's live requests
+func tenantHeldVMs(client dynamic.Interface, tenantName string) (int, error) {
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("%s=%s", tenantLabel, tenantName),
+    })
+    if err != nil {
+        return 0, err
+    }
+    held := 0
+    for _, other := range requests.Items {
+        vmIP, _, _ := unstructured.NestedString(other.Object, "status", "vmIP")
+        state, _, _ := unstructured.NestedString(other.Object, "status", "state")
+        if vmIP != "" && state != provisioner.StateReleased && state != provisioner.StateFailed {
+            held++
+        }
+    }
+    return held, nil
+}
+
+// Cloud instances the tenant runs, idle ones kept for reuse included
+func tenantCloudInstances(client dynamic.Interface, tenantName string) (int, error) {
+    instances, err := client.Resource(ec2TrainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{
+        LabelSelector: fmt.Sprintf("%s=%s", tenantLabel, tenantName),
+    })
+    if err != nil {
+        return 0, err
+    }
+    return len(instances.Items), nil
+}
+
+// SSH users of the tenant owning the environment the VM at ip is in
+func tenantSSHUsers(ip string) []string {
+    environment, found := environmentForIP(ip)
+    if !found {
+        return nil
+    }
+    t, _ := getTenant(environmentTenant(environment.Name))
+    return t.SSHUsers
+}
+
+// Environments owned twice, static VMs reachable from two tenants and unknown
+// environments would all break isolation, so they fail the startup check
+func validateTenants(check *ConfigCheck) {
+    tenants := loadTenants()
+    if len(tenants) == 0 {
+        return
+    }
+    environments := loadVMEnvironments()
+
+    owners := map[string]string{}
+    namespaces := map[string]string{}
+    for name, t := range tenants {
+        if len(t.Environments) == 0 {
+            check.warn("tenant %s owns no environments, its requests only get cloud instances", name)
+        }
+        for _, environment := range t.Environments {
+            if other, taken := owners[environment]; taken {
+                check.fail("environment %s is owned by tenants %s and %s", environment, other, name)
+            }
+            owners[environment] = name
+            if _, configured := environments[environment]; !configured {
+                check.fail("tenant %s: environment %s is not in the environments file", name, environment)
+            }
+        }
+        for _, namespace := range t.Namespaces {
+            if other, taken := namespaces[namespace]; taken {
+                check.fail("namespace %s is assigned to tenants %s and %s", namespace, other, name)
+            }
+            namespaces[namespace] = name
+        }
+    }
+
+    ipOwners := map[string]string{}
+    for name, environment := range environments {
+        owner := owners[name]
+        for _, ip := range environment.StaticVMs {
+            if other, seen := ipOwners[ip]; seen && other != owner {
+                check.fail("static VM %s is in environments of tenants %q and %q", ip, other, owner)
+            }
+            ipOwners[ip] = owner
+        }
+    }
+}
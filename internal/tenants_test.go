@@ -0,0 +1,133 @@
+// internal/tenants_test.go - Tenant of a request derived from its session's namespace, whatever label it came with
+package internal
+
+import (
+    "context"
+    "net/http"
+    "net/http/httptest"
+    "os"
+    "testing"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+func useTenants(t *testing.T, tenants string) {
+    t.Helper()
+    path := t.TempDir() + "/tenants.json"
+    if err := os.WriteFile(path, []byte(tenants), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("TENANTS_FILE", path)
+}
+
+func hobbyFarmSession(name, namespace string) *unstructured.Unstructured {
+    return &unstructured.Unstructured{
+        Object: map[string]interface{}{
+            "apiVersion": sessionGVR.GroupVersion().String(),
+            "kind":       "Session",
+            "metadata": map[string]interface{}{
+                "name":      name,
+                "namespace": namespace,
+                // Whoever creates a Session picks its labels, they name no tenant
+                "labels": map[string]interface{}{tenantLabel: "team-b"},
+            },
+            "spec": map[string]interface{}{"user": "alice"},
+        },
+    }
+}
+
+func TestRequestTenantStampedFromSessionNamespace(t *testing.T) {
+    useStaticPool(t, "10.0.0.1")
+    useTenants(t, `{"team-a": {"namespaces": ["hobbyfarm-a"]}, "team-b": {"namespaces": ["hobbyfarm-b"]}}`)
+    h := newTestHarness(t, hobbyFarmSession("session-1", "hobbyfarm-a"))
+
+    for _, name := range []string{"req-1", "req-2"} {
+        session := "session-1"
+        if name == "req-2" {
+            session = "no-such-session"
+        }
+        if err := h.submitRequest(name, "alice", session); err != nil {
+            t.Fatal(err)
+        }
+        request, err := h.request(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        request.SetLabels(map[string]string{tenantLabel: "team-b"})
+        if _, err := h.client.Resource(vmProvisioningRequestGVR).Namespace("default").Update(context.TODO(), request, metav1.UpdateOptions{}); err != nil {
+            t.Fatal(err)
+        }
+    }
+    mustStep(t, h, 1)
+
+    for name, want := range map[string]string{"req-1": "team-a", "req-2": ""} {
+        request, err := h.request(name)
+        if err != nil {
+            t.Fatal(err)
+        }
+        if got := request.GetLabels()[tenantLabel]; got != want {
+            t.Fatalf("request %s has tenant %q, want %q", name, got, want)
+        }
+    }
+}
+
+func TestPoolCandidateNotApprovedIntoAnotherTenantsEnvironment(t *testing.T) {
+    environments := t.TempDir() + "/environments.json"
+    if err := os.WriteFile(environments, []byte(`{"paris-lab": {"staticVMs": ["10.0.0.9"]}, "aws-east": {"staticVMs": []}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("VM_ENVIRONMENTS_FILE", environments)
+    useTenants(t, `{"team-paris": {"environments": ["paris-lab"]}, "team-aws": {"environments": ["aws-east"]}}`)
+    h := newTestHarness(t)
+    ws := &WebhookServer{client: h.client}
+    for _, ip := range []string{"10.0.0.9", "10.0.0.20"} {
+        if err := savePoolCandidate(h.client, ip, poolCandidate{Status: candidateProposed, Environment: "aws-east"}); err != nil {
+            t.Fatal(err)
+        }
+    }
+
+    for path, want := range map[string]int{
+        "/pool-candidates?ip=10.0.0.9&action=approve":                     http.StatusConflict,
+        "/pool-candidates?ip=10.0.0.20&action=approve&environment=nowhere": http.StatusConflict,
+        "/pool-candidates?ip=10.0.0.20&action=approve":                    http.StatusNoContent,
+    } {
+        recorder := httptest.NewRecorder()
+        ws.poolCandidatesHandler(recorder, httptest.NewRequest(http.MethodPost, path, nil))
+        if recorder.Code != want {
+            t.Fatalf("POST %s answered %d, want %d: %s", path, recorder.Code, want, recorder.Body.String())
+        }
+    }
+
+    candidates, err := loadPoolCandidates(h.client)
+    if err != nil {
+        t.Fatal(err)
+    }
+    if status := candidates["10.0.0.9"].Status; status != candidateProposed {
+        t.Fatalf("candidate 10.0.0.9 of team-paris's environment is %s for team-aws", status)
+    }
+}
+
+func TestAllocationDecidesAgainstOneTenantsSnapshot(t *testing.T) {
+    environments := t.TempDir() + "/environments.json"
+    if err := os.WriteFile(environments, []byte(`{"paris-lab": {"staticVMs": ["10.0.0.9"]}}`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    t.Setenv("VM_ENVIRONMENTS_FILE", environments)
+    useTenants(t, `{"team-paris": {"environments": ["paris-lab"]}}`)
+    tenants := loadTenants()
+    untenanted := &unstructured.Unstructured{Object: map[string]interface{}{
+        "metadata": map[string]interface{}{"name": "req-1", "namespace": "default"},
+    }}
+
+    // The ConfigMap update lands halfway through the pass
+    if err := os.WriteFile(Setting("TENANTS_FILE"), []byte(`{"team-paris": {"envir`), 0o644); err != nil {
+        t.Fatal(err)
+    }
+    if !staticVMAllowedForTenant(untenanted, "10.0.0.9") {
+        t.Fatal("a half-written tenants file still names the owner, the test proves nothing")
+    }
+    if tenants.staticVMAllowed(untenanted, "10.0.0.9", loadVMEnvironments()) {
+        t.Fatal("static VM of team-paris allowed to an untenanted request by the pass's snapshot")
+    }
+}
@@ -0,0 +1,173 @@
+// internal/tracking_cache.go - Bounded, expiring sets of what the controllers already handled
+package internal
+
+import (
+    "fmt"
+    "io"
+    "sort"
+    "strconv"
+    "sync"
+    "time"
+)
+
+// Why an entry left a tracking cache, the eviction metric's reason label
+const (
+    evictionExpired = "expired" // untouched for longer than the TTL
+    evictionSize    = "size"    // oldest entry dropped to stay within the bound
+    evictionStale   = "stale"   // its session, request or VM is gone
+)
+
+// How long an entry lives without being touched. Forgetting is safe, the handlers
+// behind every cache adopt what they created before, it only costs a repeat pass.
+func getTrackingCacheTTL() time.Duration {
+    if d, err := time.ParseDuration(Setting("TRACKING_CACHE_TTL")); err == nil && d > 0 {
+        return d
+    }
+    return 24 * time.Hour
+}
+
+func getTrackingCacheMaxEntries() int {
+    if count, err := strconv.Atoi(Setting("TRACKING_CACHE_MAX_ENTRIES")); err == nil && count > 0 {
+        return count
+    }
+    return 10000
+}
+
+// A set of keys, each forgotten once untouched for ttl or when the set would
+// outgrow maxEntries. Safe for the controller loop and the metrics handler at once.
+type trackingCache struct {
+    name       string
+    ttl        time.Duration
+    maxEntries int
+
+    mu        sync.Mutex
+    touched   map[string]time.Time
+    evictions map[string]int64
+}
+
+// By name; a controller built again replaces its caches
+var trackingCaches = struct {
+    sync.Mutex
+    byName map[string]*trackingCache
+}{byName: map[string]*trackingCache{}}
+
+// A cache with the configured bounds, reported on /metrics under name
+func newTrackingCache(name string) *trackingCache {
+    cache := &trackingCache{
+        name:       name,
+        ttl:        getTrackingCacheTTL(),
+        maxEntries: getTrackingCacheMaxEntries(),
+        touched:    map[string]time.Time{},
+        evictions:  map[string]int64{},
+    }
+    trackingCaches.Lock()
+    trackingCaches.byName[name] = cache
+    trackingCaches.Unlock()
+    return cache
+}
+
+// Whether key is tracked and not yet expired
+func (c *trackingCache) has(key string) bool {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    touched, found := c.touched[key]
+    if !found {
+        return false
+    }
+    if time.Since(touched) > c.ttl {
+        delete(c.touched, key)
+        c.evictions[evictionExpired]++
+        return false
+    }
+    return true
+}
+
+// Track key, dropping expired entries and then the oldest when the cache is full
+func (c *trackingCache) add(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    if _, found := c.touched[key]; !found && len(c.touched) >= c.maxEntries {
+        c.expireLocked()
+        for len(c.touched) >= c.maxEntries {
+            oldest, oldestAt := "", time.Time{}
+            for k, at := range c.touched {
+                if oldest == "" || at.Before(oldestAt) {
+                    oldest, oldestAt = k, at
+                }
+            }
+            delete(c.touched, oldest)
+            c.evictions[evictionSize]++
+        }
+    }
+    c.touched[key] = time.Now()
+}
+
+func (c *trackingCache) remove(key string) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    delete(c.touched, key)
+}
+
+func (c *trackingCache) len() int {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    return len(c.touched)
+}
+
+// Drop expired entries and those keep rejects, e.g. keys of sessions that are gone
+func (c *trackingCache) retain(keep func(key string) bool) {
+    c.mu.Lock()
+    defer c.mu.Unlock()
+    c.expireLocked()
+    for key := range c.touched {
+        if !keep(key) {
+            delete(c.touched, key)
+            c.evictions[evictionStale]++
+        }
+    }
+}
+
+func (c *trackingCache) expireLocked() {
+    for key, touched := range c.touched {
+        if time.Since(touched) > c.ttl {
+            delete(c.touched, key)
+            c.evictions[evictionExpired]++
+        }
+    }
+}
+
+// Keys shared by the code filling a cache and the code cleaning it, so the two can't drift apart
+func sessionTrackingKey(namespace, sessionName string) string {
+    return namespace + "/" + sessionName
+}
+
+// A request's VM as published to HobbyFarm; a new address or hostname is a new key
+func vmUpdateTrackingKey(requestName, vmIP, hostname string) string {
+    return fmt.Sprintf("%s/%s/%s", requestName, vmIP, hostname)
+}
+
+// Size and evictions of every tracking cache
+func writeTrackingCacheMetrics(w io.Writer) {
+    trackingCaches.Lock()
+    caches := make([]*trackingCache, 0, len(trackingCaches.byName))
+    for _, cache := range trackingCaches.byName {
+        caches = append(caches, cache)
+    }
+    trackingCaches.Unlock()
+    sort.Slice(caches, func(i, j int) bool { return caches[i].name < caches[j].name })
+
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_tracking_cache_entries Entries in the controllers' in-memory tracking caches")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_tracking_cache_entries gauge")
+    for _, cache := range caches {
+        fmt.Fprintf(w, "hobbyfarm_provisioner_tracking_cache_entries{cache=%q} %d\n", cache.name, cache.len())
+    }
+    fmt.Fprintln(w, "# HELP hobbyfarm_provisioner_tracking_cache_evictions_total Entries dropped from the tracking caches, by reason")
+    fmt.Fprintln(w, "# TYPE hobbyfarm_provisioner_tracking_cache_evictions_total counter")
+    for _, cache := range caches {
+        cache.mu.Lock()
+        for _, reason := range []string{evictionExpired, evictionSize, evictionStale} {
+            fmt.Fprintf(w, "hobbyfarm_provisioner_tracking_cache_evictions_total{cache=%q,reason=%q} %d\n", cache.name, reason, cache.evictions[reason])
+        }
+        cache.mu.Unlock()
+    }
+}
@@ -0,0 +1,244 @@
+// internal/trainingvm_migration.go - Move in-flight TrainingVMs to VMProvisioningRequests when leaving direct mode
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// On a request being created by the migration: the Kratix controller leaves it
+// alone until its allocation has been copied over, so it can't allocate a second VM
+const migratingAnnotation = "provisioning.hobbyfarm.io/migrating"
+
+// On a migrated request, the TrainingVM it replaced
+const migratedFromAnnotation = "provisioning.hobbyfarm.io/migrated-from"
+
+func requestMigrating(request *unstructured.Unstructured) bool {
+    return request.GetAnnotations()[migratingAnnotation] == "true"
+}
+
+type MigrationResult struct {
+    DryRun   bool              `json:"dryRun"`
+    Migrated []MigratedSession `json:"migrated"`
+    Skipped  []string          `json:"skipped,omitempty"` // <TrainingVM>: <reason>
+    Errors   []string          `json:"errors,omitempty"`
+}
+
+type MigratedSession struct {
+    TrainingVM string `json:"trainingVM"`
+    Request    string `json:"request"`
+    State      string `json:"state"`
+    VMIP       string `json:"vmIP,omitempty"`
+}
+
+// Convert every TrainingVM of a running session into the VMProvisioningRequest the
+// integration would have created, keeping its VM and provisioned state, then
+// hand the session to the Kratix pathway and delete the TrainingVM without
+// touching the VM. Run before switching HOBBYFARM_DIRECT_MODE to false; a rerun
+// finishes what an interrupted one left.
+func RunTrainingVMMigration(client dynamic.Interface, dryRun bool) (*MigrationResult, error) {
+    trainingVMs, err := client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("listing TrainingVMs: %v", err)
+    }
+
+    result := &MigrationResult{DryRun: dryRun, Migrated: []MigratedSession{}}
+    hki := &HobbyFarmKratixIntegration{client: client}
+    log.Printf("🚚 Migrating %d TrainingVMs to VMProvisioningRequests (dry run: %v)", len(trainingVMs.Items), dryRun)
+
+    for i := range trainingVMs.Items {
+        tvm := &trainingVMs.Items[i]
+        migrated, skipped, err := migrateTrainingVM(client, hki, tvm, dryRun)
+        switch {
+        case err != nil:
+            result.Errors = append(result.Errors, fmt.Sprintf("%s: %v", tvm.GetName(), err))
+        case skipped != "":
+            result.Skipped = append(result.Skipped, fmt.Sprintf("%s: %s", tvm.GetName(), skipped))
+        default:
+            result.Migrated = append(result.Migrated, *migrated)
+        }
+    }
+
+    log.Printf("✅ Migration: %d migrated, %d skipped, %d errors", len(result.Migrated), len(result.Skipped), len(result.Errors))
+    return result, nil
+}
+
+// Request state carrying over the TrainingVM's progress: a provisioned VM is ready,
+// an allocated one is provisioned again by the Kratix controller, anything else
+// goes through allocation
+func migratedRequestState(tvm *unstructured.Unstructured) (state, vmIP string) {
+    tvmState, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
+    provisioned, _, _ := unstructured.NestedBool(tvm.Object, "status", "provisioned")
+    vmIP, _, _ = unstructured.NestedString(tvm.Object, "status", "vmIP")
+    switch {
+    case tvmState == "allocated" && vmIP != "" && provisioned:
+        return "ready", vmIP
+    case tvmState == "allocated" && vmIP != "":
+        return "allocated", vmIP
+    }
+    return "pending", ""
+}
+
+func migrateTrainingVM(client dynamic.Interface, hki *HobbyFarmKratixIntegration, tvm *unstructured.Unstructured, dryRun bool) (*MigratedSession, string, error) {
+    name := tvm.GetName()
+    sessionName, _, _ := unstructured.NestedString(tvm.Object, "spec", "session")
+    if sessionName == "" {
+        sessionName = name
+    }
+    session, err := client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(context.TODO(), sessionName, metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        return nil, "session deleted, left to deprovisioning", nil
+    }
+    if err != nil {
+        return nil, "", err
+    }
+    if isSessionFinished(session) {
+        return nil, "session finished, left to deprovisioning", nil
+    }
+
+    state, vmIP := migratedRequestState(tvm)
+    requestName := requestNameForSession(sessionName)
+    migrated := &MigratedSession{TrainingVM: name, Request: requestName, State: state, VMIP: vmIP}
+
+    // A request left by an interrupted run is finished; any other one is a conflict
+    existing, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Get(context.TODO(), requestName, metav1.GetOptions{})
+    if err == nil && existing.GetAnnotations()[migratedFromAnnotation] != "TrainingVM/"+name {
+        return nil, fmt.Sprintf("session already has VMProvisioningRequest %s", requestName), nil
+    }
+    if err != nil && !errors.IsNotFound(err) {
+        return nil, "", err
+    }
+    if dryRun {
+        return migrated, "", nil
+    }
+
+    if errors.IsNotFound(err) {
+        if err := createMigratedRequest(client, hki, tvm, session, state, vmIP); err != nil {
+            return nil, "", err
+        }
+    } else if requestMigrating(existing) {
+        if err := copyMigratedStatus(client, tvm, requestName, state, vmIP); err != nil {
+            return nil, "", err
+        }
+    }
+
+    // The cloud instance is the request's from now on, torn down or reused with it
+    if vmIP != "" && isPublicIP(vmIP) {
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "metadata": map[string]interface{}{
+                "labels": map[string]interface{}{
+                    "kratix-request": requestName,
+                    "type":           "kratix-cloud-fallback",
+                },
+            },
+        })
+        _, err := client.Resource(ec2TrainingVMGVR).Namespace("default").Patch(
+            context.TODO(), ec2InstanceName(name), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+        if err != nil && !errors.IsNotFound(err) {
+            return nil, "", fmt.Errorf("relabelling cloud instance %s: %v", ec2InstanceName(name), err)
+        }
+    }
+
+    // The HobbyFarm controller no longer recreates the TrainingVM once the session is Kratix's
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{sessionPathwayAnnotation: pathwayKratix},
+        },
+    })
+    if _, err := client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Patch(
+        context.TODO(), sessionName, types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+        return nil, "", fmt.Errorf("handing session %s to the Kratix pathway: %v", sessionName, err)
+    }
+
+    // Only the resource goes; its VM stays allocated, now to the request
+    err = client.Resource(trainingVMGVR).Namespace("default").Delete(context.TODO(), name, metav1.DeleteOptions{})
+    if err != nil && !errors.IsNotFound(err) {
+        return nil, "", fmt.Errorf("deleting TrainingVM: %v", err)
+    }
+
+    log.Printf("🚚 Migrated TrainingVM %s to VMProvisioningRequest %s (%s %s)", name, requestName, state, vmIP)
+    if vmIP != "" && !isPublicIP(vmIP) {
+        recordPoolEvent(client, vmIP, eventTypeNormal, reasonVMAllocated, "VMProvisioningRequest/"+requestName, "migrated from TrainingVM/%s", name)
+    }
+    return migrated, "", nil
+}
+
+// Create the request held back from the Kratix controller, then copy the allocation
+func createMigratedRequest(client dynamic.Interface, hki *HobbyFarmKratixIntegration, tvm, session *unstructured.Unstructured, state, vmIP string) error {
+    sessionName := session.GetName()
+    user, _, _ := unstructured.NestedString(session.Object, "spec", "user")
+    scenario, _, _ := unstructured.NestedString(session.Object, "spec", "scenario")
+    if user == "" {
+        user = "student"
+    }
+    if scenario == "" {
+        scenario = "hybrid-training"
+    }
+
+    request := hki.buildKratixVMRequest(sessionName, user, scenario, session)
+    // A static VM stays in the environment it was allocated from
+    if vmIP != "" && !isPublicIP(vmIP) {
+        if environment, found := environmentForIP(vmIP); found {
+            unstructured.SetNestedField(request.Object, environment.Name, "spec", "environment")
+            labels := request.GetLabels()
+            labels[environmentLabel] = environment.Name
+            request.SetLabels(labels)
+        }
+    }
+    annotations := request.GetAnnotations()
+    annotations[migratingAnnotation] = "true"
+    annotations[migratedFromAnnotation] = "TrainingVM/" + tvm.GetName()
+    request.SetAnnotations(annotations)
+
+    stampProvenance(request)
+    if _, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Create(context.TODO(), request, metav1.CreateOptions{}); err != nil {
+        return fmt.Errorf("creating VMProvisioningRequest: %v", err)
+    }
+    return copyMigratedStatus(client, tvm, request.GetName(), state, vmIP)
+}
+
+// Give the request the TrainingVM's allocation, then release it to the Kratix controller
+func copyMigratedStatus(client dynamic.Interface, tvm *unstructured.Unstructured, requestName, state, vmIP string) error {
+    status := map[string]interface{}{
+        "state":       state,
+        "provisioned": state == "ready",
+    }
+    if vmIP != "" {
+        vmType, _, _ := unstructured.NestedString(tvm.Object, "status", "vmType")
+        if vmType == "" {
+            vmType = getVMType(vmIP)
+        }
+        status["vmIP"] = vmIP
+        status["vmType"] = vmType
+        allocatedAt, _, _ := unstructured.NestedString(tvm.Object, "status", "allocatedAt")
+        if allocatedAt == "" {
+            allocatedAt = time.Now().Format(time.RFC3339)
+        }
+        status["allocatedAt"] = allocatedAt
+    }
+    if state == "ready" {
+        status["readyAt"] = time.Now().Format(time.RFC3339)
+    }
+    patchBytes, _ := json.Marshal(map[string]interface{}{"status": status})
+    if err := patchStatus(client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        return fmt.Errorf("copying allocation: %v", err)
+    }
+
+    patchBytes, _ = json.Marshal(map[string]interface{}{
+        "metadata": map[string]interface{}{
+            "annotations": map[string]interface{}{migratingAnnotation: nil},
+        },
+    })
+    _, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").Patch(
+        context.TODO(), requestName, types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    return err
+}
@@ -0,0 +1,132 @@
+// internal/user_metadata.go - HobbyFarm User fields as Ansible variables and request labels, for per-cohort provisioning
+package internal
+
+import (
+    "context"
+    "crypto/sha256"
+    "encoding/hex"
+    "log"
+    "sort"
+    "strings"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/util/validation"
+    "k8s.io/client-go/dynamic"
+)
+
+const (
+    userFieldEmailHash   = "email_hash"
+    userFieldAccessCodes = "access_codes"
+    userFieldGroups      = "groups"
+
+    userEmailHashLabel        = "hobbyfarm.io/user-email-hash"
+    userAccessCodeLabelPrefix = "access-code.hobbyfarm.io/"
+    userGroupLabelPrefix      = "group.hobbyfarm.io/"
+)
+
+var userMetadataFields = []string{userFieldEmailHash, userFieldAccessCodes, userFieldGroups}
+
+// USER_METADATA_FIELDS, e.g. email_hash,access_codes,groups, picks the User fields
+// handed to provisioning. Empty disables the lookup.
+func getUserMetadataFields() map[string]bool {
+    fields := map[string]bool{}
+    for _, field := range splitEnvList("USER_METADATA_FIELDS") {
+        fields[field] = true
+    }
+    return fields
+}
+
+// The selected fields of a HobbyFarm User. The email itself never leaves the
+// cluster, only a hash that still tells users apart.
+type userMetadata struct {
+    EmailHash   string
+    AccessCodes []string
+    Groups      []string
+}
+
+// First 32 hex digits of the SHA-256 of the normalized email, short enough for a label value
+func hashUserEmail(email string) string {
+    sum := sha256.Sum256([]byte(strings.ToLower(strings.TrimSpace(email))))
+    return hex.EncodeToString(sum[:])[:32]
+}
+
+// Look up the User a session belongs to. Nil when enrichment is off or the user
+// is not a HobbyFarm User, e.g. the "student" default.
+func lookupUserMetadata(client dynamic.Interface, user string) *userMetadata {
+    fields := getUserMetadataFields()
+    if len(fields) == 0 || user == "" {
+        return nil
+    }
+    userObj, err := client.Resource(userGVR).Namespace(hobbyFarmNamespace()).Get(context.TODO(), user, metav1.GetOptions{})
+    if err != nil {
+        log.Printf("⚠️ No HobbyFarm User %s to enrich provisioning with: %v", user, err)
+        return nil
+    }
+
+    metadata := &userMetadata{}
+    if fields[userFieldEmailHash] {
+        if email, _, _ := unstructured.NestedString(userObj.Object, "spec", "email"); email != "" {
+            metadata.EmailHash = hashUserEmail(email)
+        }
+    }
+    if fields[userFieldAccessCodes] {
+        metadata.AccessCodes, _, _ = unstructured.NestedStringSlice(userObj.Object, "spec", "access_codes")
+        sort.Strings(metadata.AccessCodes)
+    }
+    if fields[userFieldGroups] {
+        metadata.Groups, _, _ = unstructured.NestedStringSlice(userObj.Object, "spec", "groups")
+        sort.Strings(metadata.Groups)
+    }
+    return metadata
+}
+
+// Ansible variables of the user; lists are comma-separated like every other variable
+func (m *userMetadata) variables() map[string]string {
+    if m == nil {
+        return nil
+    }
+    return map[string]string{
+        "hobbyfarm_user_email_hash":   m.EmailHash,
+        "hobbyfarm_user_access_codes": strings.Join(m.AccessCodes, ","),
+        "hobbyfarm_user_groups":       strings.Join(m.Groups, ","),
+    }
+}
+
+// Labels selecting requests by cohort, e.g. access-code.hobbyfarm.io/spring-class=true.
+// Codes and groups that are not valid label names are left out.
+func (m *userMetadata) labels() map[string]string {
+    labels := map[string]string{}
+    if m == nil {
+        return labels
+    }
+    if m.EmailHash != "" {
+        labels[userEmailHashLabel] = m.EmailHash
+    }
+    addFlags := func(prefix string, names []string) {
+        for _, name := range names {
+            key := prefix + strings.ToLower(name)
+            if errs := validation.IsQualifiedName(key); len(errs) > 0 {
+                log.Printf("⚠️ Not labelling request with %s: %s", key, strings.Join(errs, "; "))
+                continue
+            }
+            labels[key] = "true"
+        }
+    }
+    addFlags(userAccessCodeLabelPrefix, m.AccessCodes)
+    addFlags(userGroupLabelPrefix, m.Groups)
+    return labels
+}
+
+// Only known fields can be selected
+func validateUserMetadata(check *ConfigCheck) {
+    for field := range getUserMetadataFields() {
+        known := false
+        for _, name := range userMetadataFields {
+            known = known || field == name
+        }
+        if !known {
+            check.fail("USER_METADATA_FIELDS: unknown field %q, expected one of %s", field, strings.Join(userMetadataFields, ","))
+        }
+    }
+}
@@ -8,7 +8,6 @@ import (
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-    "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
 )
 
@@ -17,6 +16,7 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
     trainingVMs, err := client.Resource(trainingVMGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
     if err != nil {
         log.Printf("❌ Failed to list TrainingVMs: %v", err)
+        recordLastError(HeartbeatVMAllocator, "listing TrainingVMs: %v", err)
         return
     }
 
@@ -61,11 +61,14 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
                     log.Printf("🎯 VM %s is ready for provisioning", ip)
                     
                     // Get session details to determine scenario
-                    sessionName := name // TrainingVM name should match session name
-                    session, err := client.Resource(sessionGVR).Namespace("hobbyfarm-system").Get(
+                    sessionName := objectSession(&tvm)
+                    if sessionName == "" {
+                        sessionName = name // TrainingVMs are named after their session
+                    }
+                    session, err := client.Resource(sessionGVR).Namespace(hobbyFarmNamespace()).Get(
                         context.TODO(), sessionName, metav1.GetOptions{})
                     if err != nil {
-                        log.Printf("❌ Failed to get session %s from hobbyfarm-system: %v", sessionName, err)
+                        log.Printf("❌ Failed to get session %s from %s: %v", sessionName, hobbyFarmNamespace(), err)
                         continue
                     }
                     
@@ -89,14 +92,13 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
                     log.Printf("🚀 Starting Ansible provisioning for VM %s", ip)
                     if err := ansibleRunner.RunPlaybook(ip, name, scenario); err != nil {
                         log.Printf("❌ Ansible provisioning failed for VM %s: %v", ip, err)
+                        recordLastError(HeartbeatVMAllocator, "provisioning %s on %s: %v", name, ip, err)
                         continue
                     }
                     
-                    // Mark as provisioned - Use status subresource after CRD update
+                    // Mark as provisioned
                     patch := `{"status":{"provisioned":true}}`
-                    _, err = client.Resource(trainingVMGVR).Namespace("default").Patch(
-                        context.TODO(), name, types.MergePatchType,
-                        []byte(patch), metav1.PatchOptions{}, "status")
+                    err = patchStatus(client, trainingVMGVR, "default", name, []byte(patch))
                     if err != nil {
                         log.Printf("❌ Failed to mark VM as provisioned: %v", err)
                     } else {
@@ -123,9 +125,9 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
                 
                 log.Printf("⚠️ Releasing unreachable %s VM %s", vmType, ip)
                 patch := `{"status":{"vmIP":"","state":"","allocatedAt":"","provisioned":false}}`
-                client.Resource(trainingVMGVR).Namespace("default").Patch(
-                    context.TODO(), name, types.MergePatchType,
-                    []byte(patch), metav1.PatchOptions{}, "status")
+                if err := patchStatus(client, trainingVMGVR, "default", name, []byte(patch)); err != nil {
+                    log.Printf("❌ Failed to release VM %s from TrainingVM %s: %v", ip, name, err)
+                }
                 continue
             }
         }
@@ -133,8 +135,13 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
         // If no VM allocated, try to allocate one from static pool
         log.Printf("🔍 TrainingVM %s needs allocation", name)
         var selectedIP string
-        for _, candidateIP := range vmPool {
-            if !usedIPs[candidateIP] && isVMReachable(candidateIP) {
+        environment, _ := getVMEnvironment(getObjectEnvironment(&tvm))
+        maintenance := getMaintenanceVMs(client)
+        for _, candidateIP := range environment.StaticVMs {
+            if _, drained := maintenance[candidateIP]; drained {
+                continue
+            }
+            if !usedIPs[candidateIP] && isVMReachable(candidateIP) && staticVMHasRoom(client, runnerProber{ansibleRunner}, candidateIP, &tvm) {
                 selectedIP = candidateIP
                 break
             }
@@ -152,28 +159,18 @@ func AllocateTrainingVMs(client dynamic.Interface, usedIPs map[string]bool, ansi
 
             log.Printf("🔧 Attempting to patch TrainingVM %s with IP %s", name, selectedIP)
             
-            // Use status subresource after CRD update
-            _, err := client.Resource(trainingVMGVR).Namespace("default").Patch(
-                context.TODO(), name, types.MergePatchType,
-                []byte(patch), metav1.PatchOptions{}, "status")
-            if err == nil {
+            if err := patchStatus(client, trainingVMGVR, "default", name, []byte(patch)); err == nil {
                 log.Printf("✅ Allocated static VM %s to TrainingVM %s", selectedIP, name)
+                recordPoolEvent(client, selectedIP, eventTypeNormal, reasonVMAllocated, "TrainingVM/"+name, "")
                 usedIPs[selectedIP] = true
             } else {
                 log.Printf("❌ Failed to allocate VM %s to TrainingVM %s: %v", selectedIP, name, err)
-                log.Printf("🔧 Retrying without status subresource...")
-                
-                // Fallback to patching without status subresource
-                _, fallbackErr := client.Resource(trainingVMGVR).Namespace("default").Patch(
-                    context.TODO(), name, types.MergePatchType,
-                    []byte(patch), metav1.PatchOptions{})
-                if fallbackErr == nil {
-                    log.Printf("✅ Allocated static VM %s to TrainingVM %s (fallback method)", selectedIP, name)
-                    usedIPs[selectedIP] = true
-                } else {
-                    log.Printf("❌ Both allocation methods failed for %s: %v", name, fallbackErr)
-                }
+                recordLastError(HeartbeatVMAllocator, "allocating %s to %s: %v", selectedIP, name, err)
             }
+        } else if !environment.cloudFallbackAllowed() {
+            log.Printf("⚠️ No static VMs available in environment %s for %s and cloud fallback disabled", environment.Name, name)
+        } else if !apiAvailable(apiCrossplane) {
+            log.Printf("⏳ No static VMs available and Crossplane is not installed, %s stays pending", name)
         } else {
             log.Printf("🚀 No static VMs available, trying EC2 fallback for %s", name)
             HandleEC2Fallback(client, name)
@@ -0,0 +1,171 @@
+// internal/vm_dns.go - DNS naming for provisioned VMs via external-dns DNSEndpoint records
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strconv"
+    "strings"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime/schema"
+    "k8s.io/apimachinery/pkg/types"
+)
+
+var (
+    // external-dns CRD source
+    dnsEndpointGVR = schema.GroupVersionResource{
+        Group:    "externaldns.k8s.io",
+        Version:  "v1alpha1",
+        Resource: "dnsendpoints",
+    }
+)
+
+// DNS naming is enabled by setting VM_DNS_DOMAIN (e.g. labs.example.com)
+func getVMDNSDomain() string {
+    return strings.Trim(Setting("VM_DNS_DOMAIN"), ".")
+}
+
+func getVMDNSTTL() int64 {
+    if ttl, err := strconv.ParseInt(Setting("VM_DNS_TTL"), 10, 64); err == nil && ttl > 0 {
+        return ttl
+    }
+    return 60
+}
+
+// Build <session>.<domain>, or "" when DNS naming is disabled. A session name
+// that isn't a valid DNS label gets one derived from it.
+func buildVMHostname(session string) string {
+    domain := getVMDNSDomain()
+    if domain == "" || session == "" {
+        return ""
+    }
+    return fmt.Sprintf("%s.%s", derivedName("", session), domain)
+}
+
+// Create or update the DNSEndpoint for a request and record the hostname in its status.
+// The DNSEndpoint is owned by the request so it is garbage collected with it.
+func (kc *KratixController) ensureVMDNSRecord(request *unstructured.Unstructured, targetIP string) (string, error) {
+    session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+    hostname := buildVMHostname(session)
+    if hostname == "" || targetIP == "" {
+        return "", nil
+    }
+
+    requestName := request.GetName()
+    endpointName := requestName + "-vm-dns"
+    endpoints := []interface{}{
+        map[string]interface{}{
+            "dnsName":    hostname,
+            "recordType": "A",
+            "recordTTL":  getVMDNSTTL(),
+            "targets":    []interface{}{targetIP},
+        },
+    }
+
+    existing, err := kc.client.Resource(dnsEndpointGVR).Namespace("default").Get(context.TODO(), endpointName, metav1.GetOptions{})
+    if errors.IsNotFound(err) {
+        dnsEndpoint := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "externaldns.k8s.io/v1alpha1",
+                "kind":       "DNSEndpoint",
+                "metadata": map[string]interface{}{
+                    "name":      endpointName,
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "kratix-request": requestName,
+                        "session":        session,
+                    },
+                    "ownerReferences": []interface{}{
+                        map[string]interface{}{
+                            "apiVersion": request.GetAPIVersion(),
+                            "kind":       request.GetKind(),
+                            "name":       requestName,
+                            "uid":        string(request.GetUID()),
+                        },
+                    },
+                },
+                "spec": map[string]interface{}{
+                    "endpoints": endpoints,
+                },
+            },
+        }
+
+        stampProvenance(dnsEndpoint)
+        if _, err := kc.client.Resource(dnsEndpointGVR).Namespace("default").Create(context.TODO(), dnsEndpoint, metav1.CreateOptions{}); err != nil {
+            return "", fmt.Errorf("failed to create DNSEndpoint %s: %v", endpointName, err)
+        }
+        log.Printf("🌍 Registered DNS record %s → %s", hostname, targetIP)
+    } else if err != nil {
+        return "", fmt.Errorf("failed to get DNSEndpoint %s: %v", endpointName, err)
+    } else {
+        currentTargets, _, _ := unstructured.NestedSlice(existing.Object, "spec", "endpoints")
+        if !dnsEndpointPointsTo(currentTargets, hostname, targetIP) {
+            patchBytes, _ := json.Marshal(map[string]interface{}{
+                "metadata": map[string]interface{}{"annotations": provenanceAnnotations()},
+                "spec": map[string]interface{}{
+                    "endpoints": endpoints,
+                },
+            })
+            if _, err := kc.client.Resource(dnsEndpointGVR).Namespace("default").Patch(
+                context.TODO(), endpointName, types.MergePatchType, patchBytes, metav1.PatchOptions{}); err != nil {
+                return "", fmt.Errorf("failed to update DNSEndpoint %s: %v", endpointName, err)
+            }
+            log.Printf("🌍 Updated DNS record %s → %s", hostname, targetIP)
+        }
+    }
+
+    currentHostname, _, _ := unstructured.NestedString(request.Object, "status", "hostname")
+    if currentHostname != hostname {
+        patchBytes, _ := json.Marshal(map[string]interface{}{
+            "status": map[string]interface{}{
+                "hostname": hostname,
+            },
+        })
+        if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+            return "", fmt.Errorf("failed to record hostname: %v", err)
+        }
+    }
+
+    return hostname, nil
+}
+
+func dnsEndpointPointsTo(endpoints []interface{}, hostname, targetIP string) bool {
+    if len(endpoints) != 1 {
+        return false
+    }
+    endpoint, ok := endpoints[0].(map[string]interface{})
+    if !ok || endpoint["dnsName"] != hostname {
+        return false
+    }
+    targets, ok := endpoint["targets"].([]interface{})
+    return ok && len(targets) == 1 && targets[0] == targetIP
+}
+
+// Keep DNS records of ready VMs pointing at their current address (e.g. after stop/start)
+func (kc *KratixController) reconcileVMDNSRecords() {
+    if getVMDNSDomain() == "" {
+        return
+    }
+
+    requests, err := kc.client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return
+    }
+
+    for i := range requests.Items {
+        request := &requests.Items[i]
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if state != "ready" {
+            continue
+        }
+
+        if _, err := kc.ensureVMDNSRecord(request, getRequestAccessIP(request)); err != nil {
+            log.Printf("⚠️ DNS reconcile failed for %s: %v", request.GetName(), err)
+        }
+    }
+}
@@ -0,0 +1,272 @@
+// internal/vm_environments.go - Named environments, each with its own static pool, SSH settings and shell endpoint
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "log"
+    "os"
+    "sort"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Label on Sessions, Scenarios, TrainingVMs and requests naming the environment to allocate from
+const environmentLabel = "hobbyfarm.io/environment"
+
+// Scenario annotation mapping every session of the scenario to an environment
+const environmentAnnotation = "provisioning.hobbyfarm.io/environment"
+
+const defaultEnvironmentName = "default"
+
+// A set of static VMs sessions can be mapped to, e.g. "paris-lab" or "aws-east".
+// An environment with no static VMs only ever gets cloud instances.
+type vmEnvironment struct {
+    Name          string            `json:"-"`
+    StaticVMs     []string          `json:"staticVMs"`
+    SSHUser       string            `json:"sshUser"`
+    // Logins of pool entries that differ from SSHUser, e.g. {"10.0.0.7": "admin"} for a Debian host
+    SSHUsers      map[string]string `json:"sshUsers,omitempty"`
+    SSHSecret     string            `json:"sshSecret"`
+    WSEndpoint    string            `json:"wsEndpoint"`
+    CloudFallback *bool             `json:"cloudFallback,omitempty"`
+    // Shares of StaticVMs reserved for HobbyFarm or other consumers, first matching window wins
+    PoolSharing []poolShare `json:"poolSharing,omitempty"`
+
+    // Versioned playbooks to provision from instead of the ones in the provisioner image
+    PlaybookBundle *playbookBundle `json:"playbookBundle,omitempty"`
+
+    // System settings of provisioned VMs, defaulting to the PROVISIONING_* variables
+    HTTPProxy    string `json:"httpProxy,omitempty"`
+    HTTPSProxy   string `json:"httpsProxy,omitempty"`
+    NoProxy      string `json:"noProxy,omitempty"`
+    APTProxy     string `json:"aptProxy,omitempty"`
+    Timezone     string `json:"timezone,omitempty"`
+    Locale       string `json:"locale,omitempty"`
+    CABundleFile string `json:"caBundleFile,omitempty"`
+}
+
+// Environments file mounted from the provisioner ConfigMap, a JSON object keyed by environment name
+func getEnvironmentsFile() string {
+    if path := Setting("VM_ENVIRONMENTS_FILE"); path != "" {
+        return path
+    }
+    return "/etc/provisioner/environments.json"
+}
+
+// The environment used when nothing maps a session elsewhere; its pool comes from STATIC_VM_POOL
+func builtinDefaultEnvironment() vmEnvironment {
+    pool := splitEnvList("STATIC_VM_POOL")
+    if len(pool) == 0 {
+        pool = vmPool
+    }
+    return vmEnvironment{
+        Name:       defaultEnvironmentName,
+        StaticVMs:  pool,
+        SSHUser:    "kube",
+        SSHSecret:  "hobbyfarm-vm-ssh-key",
+        WSEndpoint: "ws://shell.192.168.2.47.nip.io",
+    }
+}
+
+// Read the environments file on every call so ConfigMap edits apply without a restart.
+// A missing or invalid file leaves only the default environment.
+func loadVMEnvironments() map[string]vmEnvironment {
+    environments := map[string]vmEnvironment{}
+
+    data, err := os.ReadFile(getEnvironmentsFile())
+    if err == nil {
+        if err := json.Unmarshal(data, &environments); err != nil {
+            log.Printf("⚠️ Ignoring invalid environments file %s: %v", getEnvironmentsFile(), err)
+            environments = map[string]vmEnvironment{}
+        }
+    } else if !os.IsNotExist(err) {
+        log.Printf("⚠️ Could not read environments file %s: %v", getEnvironmentsFile(), err)
+    }
+
+    builtin := builtinDefaultEnvironment()
+    for name, environment := range environments {
+        environment.Name = name
+        if environment.SSHUser == "" {
+            environment.SSHUser = builtin.SSHUser
+        }
+        if environment.SSHSecret == "" {
+            environment.SSHSecret = builtin.SSHSecret
+        }
+        if environment.WSEndpoint == "" {
+            environment.WSEndpoint = builtin.WSEndpoint
+        }
+        environments[name] = environment
+    }
+    if _, exists := environments[defaultEnvironmentName]; !exists {
+        environments[defaultEnvironmentName] = builtin
+    }
+
+    // Hosts found by pool discovery join once an operator approved them; hosts
+    // synced from NetBox join as they are
+    for name, environment := range environments {
+        environment.StaticVMs = appendMissing(environment.StaticVMs, approvedStaticVMs(name))
+        environment.StaticVMs = appendMissing(environment.StaticVMs, netboxStaticVMs(name))
+        environments[name] = environment
+    }
+    return environments
+}
+
+func appendMissing(pool, ips []string) []string {
+    present := make(map[string]bool, len(pool))
+    for _, ip := range pool {
+        present[ip] = true
+    }
+    for _, ip := range ips {
+        if !present[ip] {
+            pool = append(pool, ip)
+        }
+    }
+    return pool
+}
+
+// Look up an environment by name. An unknown name yields an empty pool rather than
+// the default one, so a mistyped label never takes capacity from another environment.
+func getVMEnvironment(name string) (vmEnvironment, bool) {
+    if name == "" {
+        name = defaultEnvironmentName
+    }
+    environment, exists := loadVMEnvironments()[name]
+    if !exists {
+        return vmEnvironment{Name: name}, false
+    }
+    return environment, true
+}
+
+func (env vmEnvironment) cloudFallbackAllowed() bool {
+    return env.CloudFallback == nil || *env.CloudFallback
+}
+
+// Every configured static VM across environments, sorted
+func allStaticVMs() []string {
+    seen := map[string]bool{}
+    var ips []string
+    for _, environment := range loadVMEnvironments() {
+        for _, ip := range environment.StaticVMs {
+            if !seen[ip] {
+                seen[ip] = true
+                ips = append(ips, ip)
+            }
+        }
+    }
+    sort.Strings(ips)
+    return ips
+}
+
+// The environment whose static pool contains ip
+func environmentForIP(ip string) (vmEnvironment, bool) {
+    environments := loadVMEnvironments()
+    for _, environment := range environments {
+        for _, staticIP := range environment.StaticVMs {
+            if staticIP == ip {
+                return environment, true
+            }
+        }
+    }
+    // Removed from the config while in use: the allocation is served as before
+    if name, draining := drainingEnvironment(ip); draining {
+        environment, exists := environments[name]
+        if !exists {
+            environment = builtinDefaultEnvironment()
+            environment.Name = name
+            environment.StaticVMs = nil
+        }
+        return environment, true
+    }
+    return vmEnvironment{}, false
+}
+
+// Map a Session to an environment: the Session label, then the environment of the
+// HobbyFarm VirtualMachines created for it, then the Scenario, then its tenant's
+// first environment or the default
+func resolveSessionEnvironment(client dynamic.Interface, session *unstructured.Unstructured) string {
+    if name := session.GetLabels()[environmentLabel]; name != "" {
+        return name
+    }
+
+    environments := loadVMEnvironments()
+    if name := hobbyFarmSessionEnvironment(client, session); name != "" {
+        if _, configured := environments[name]; configured {
+            return name
+        }
+    }
+
+    scenario, _, _ := unstructured.NestedString(session.Object, "spec", "scenario")
+    if name := scenarioEnvironment(client, scenario); name != "" {
+        return name
+    }
+
+    return tenantDefaultEnvironment(resolveSessionTenant(client, session))
+}
+
+// Environment a Scenario names by label or annotation, empty when it names none
+func scenarioEnvironment(client dynamic.Interface, scenario string) string {
+    if scenario == "" {
+        return ""
+    }
+    scenarioObj, err := getScenario(client, scenario)
+    if err != nil {
+        return ""
+    }
+    if name := scenarioObj.GetLabels()[environmentLabel]; name != "" {
+        return name
+    }
+    return scenarioObj.GetAnnotations()[environmentAnnotation]
+}
+
+// HobbyFarm records the Environment a VirtualMachine was scheduled in as its
+// "environment" label and status.environment_id
+func hobbyFarmSessionEnvironment(client dynamic.Interface, session *unstructured.Unstructured) string {
+    sessionUser, _, _ := unstructured.NestedString(session.Object, "spec", "user")
+    if sessionUser == "" {
+        return ""
+    }
+
+    vms, err := client.Resource(virtualMachineGVR).Namespace(hobbyFarmNamespace()).List(context.TODO(), metav1.ListOptions{})
+    if err != nil {
+        return ""
+    }
+    for _, vm := range vms.Items {
+        if vmUser, _, _ := unstructured.NestedString(vm.Object, "spec", "user"); vmUser != sessionUser {
+            continue
+        }
+        if name := vm.GetLabels()["environment"]; name != "" {
+            return name
+        }
+        if name, _, _ := unstructured.NestedString(vm.Object, "status", "environment_id"); name != "" {
+            return name
+        }
+    }
+    return ""
+}
+
+// Environment recorded on a VMProvisioningRequest or TrainingVM
+func getObjectEnvironment(object *unstructured.Unstructured) string {
+    if name, _, _ := unstructured.NestedString(object.Object, "spec", "environment"); name != "" {
+        return name
+    }
+    if name := object.GetLabels()[environmentLabel]; name != "" {
+        return name
+    }
+    return tenantDefaultEnvironment(objectTenant(object))
+}
+
+// SSH user, key secret and shell endpoint HobbyFarm should use for the VM at vmIP in
+// env. A login recorded in NetBox for that host wins over the environment's.
+func hobbyFarmVMAccess(client dynamic.Interface, env vmEnvironment, vmIP string) (map[string]interface{}, string) {
+    sshUser := expectedSSHUser(client, env, vmIP)
+    if env.SSHUser == "" {
+        env = builtinDefaultEnvironment()
+    }
+    return map[string]interface{}{
+        "secret_name":  env.SSHSecret,
+        "ssh_username": sshUser,
+    }, env.WSEndpoint
+}
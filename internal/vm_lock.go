@@ -0,0 +1,100 @@
+// internal/vm_lock.go - Per-VM Lease held while Ansible runs, so two controllers never provision one VM at once
+package internal
+
+import (
+    "errors"
+    "fmt"
+    "os"
+    "strconv"
+    "strings"
+    "time"
+
+    "k8s.io/client-go/dynamic"
+)
+
+// Label on VM lock Leases, valued with the lock name's address part
+const vmLockLabel = "provisioning.hobbyfarm.io/vm-lock"
+
+// How long a lock outlives its last renewal, so a crashed holder doesn't block the VM forever
+func getVMLockLeaseDuration() time.Duration {
+    if seconds, err := strconv.Atoi(Setting("VM_LOCK_LEASE_SECONDS")); err == nil && seconds > 0 {
+        return time.Duration(seconds) * time.Second
+    }
+    return 2 * time.Minute
+}
+
+// Lease vm-lock-<address>, dots and colons turned into dashes
+func vmLockName(vmIP string) string {
+    return "vm-lock-" + strings.NewReplacer(".", "-", ":", "-").Replace(strings.ToLower(vmIP))
+}
+
+// Identity of a lock holder: this replica and what it runs, e.g. "pod-1/kratix:req-1"
+func vmLockHolder(owner string) string {
+    hostname, _ := os.Hostname()
+    return hostname + "/" + owner
+}
+
+// The lock on vmIP as held by holder, deleted on release
+func vmLock(client dynamic.Interface, vmIP, holder string) *Lease {
+    name := vmLockName(vmIP)
+    return &Lease{
+        Client:      client,
+        Name:        name,
+        Holder:      holder,
+        Duration:    getVMLockLeaseDuration(),
+        Description: "lock on VM " + vmIP,
+        Labels: map[string]interface{}{
+            "app":       "hobbyfarm-provisioner",
+            vmLockLabel: strings.TrimPrefix(name, "vm-lock-"),
+        },
+        Annotations: map[string]interface{}{
+            "provisioning.hobbyfarm.io/vm-ip": vmIP,
+        },
+        DeleteOnRelease: true,
+    }
+}
+
+// Another run holds the VM; the caller leaves its work for the next pass
+type vmLockedError struct {
+    vmIP   string
+    holder string
+}
+
+func (e *vmLockedError) Error() string {
+    return fmt.Sprintf("VM %s is locked by %s", e.vmIP, e.holder)
+}
+
+// Whether err is a VM held by another run, and who holds it
+func vmLockHeld(err error) (string, bool) {
+    var locked *vmLockedError
+    if errors.As(err, &locked) {
+        return locked.holder, true
+    }
+    return "", false
+}
+
+// Run fn holding vmIP's lock. Every Ansible run against a VM goes through here,
+// whichever controller starts it, so apt and dpkg never see two runs at once.
+// The lock is tried once: while another run holds it a *vmLockedError comes back
+// at once, so a serial reconcile pass never waits on one busy VM.
+func withVMLock(client dynamic.Interface, vmIP, owner string, fn func() error) error {
+    lock := vmLock(client, vmIP, vmLockHolder(owner))
+    acquired, current, err := lock.tryAcquire()
+    if err != nil {
+        return fmt.Errorf("failed to lock VM %s: %v", vmIP, err)
+    }
+    if !acquired {
+        if current == "" {
+            current = "another run"
+        }
+        return &vmLockedError{vmIP: vmIP, holder: current}
+    }
+
+    stop := make(chan struct{})
+    go lock.keepRenewed(stop)
+    defer func() {
+        close(stop)
+        lock.release()
+    }()
+    return fn()
+}
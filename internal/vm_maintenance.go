@@ -0,0 +1,142 @@
+// internal/vm_maintenance.go - Static VM maintenance mode
+package internal
+
+import (
+    "context"
+    "encoding/json"
+    "fmt"
+    "log"
+    "net/http"
+    "time"
+
+    "k8s.io/apimachinery/pkg/api/errors"
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/types"
+    "k8s.io/client-go/dynamic"
+)
+
+// Static VMs in maintenance are listed in a ConfigMap as <ip>: <reason>.
+// Admins can edit it directly or use the /maintenance endpoint.
+func getMaintenanceConfigMapName() string {
+    if name := Setting("MAINTENANCE_CONFIGMAP"); name != "" {
+        return name
+    }
+    return "hobbyfarm-provisioner-maintenance"
+}
+
+// Return the static VMs currently in maintenance, keyed by IP
+func getMaintenanceVMs(client dynamic.Interface) map[string]string {
+    configMap, err := client.Resource(configMapGVR).Namespace("default").Get(
+        context.TODO(), getMaintenanceConfigMapName(), metav1.GetOptions{})
+    if err != nil {
+        if !errors.IsNotFound(err) {
+            log.Printf("⚠️ Could not read maintenance ConfigMap: %v", err)
+        }
+        return map[string]string{}
+    }
+
+    data, _, _ := unstructured.NestedStringMap(configMap.Object, "data")
+    if data == nil {
+        return map[string]string{}
+    }
+    return data
+}
+
+func isVMInMaintenance(client dynamic.Interface, ip string) bool {
+    _, inMaintenance := getMaintenanceVMs(client)[ip]
+    return inMaintenance
+}
+
+// Put a static VM into maintenance. New allocations skip it; a session already
+// using it is allowed to finish.
+func setVMMaintenance(client dynamic.Interface, ip, reason string) error {
+    if !IsStaticVMIP(ip) {
+        return fmt.Errorf("%s is not a static pool VM", ip)
+    }
+    if reason == "" {
+        reason = fmt.Sprintf("maintenance since %s", time.Now().Format(time.RFC3339))
+    }
+
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "data": map[string]interface{}{ip: reason},
+    })
+
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), getMaintenanceConfigMapName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if errors.IsNotFound(err) {
+        configMap := &unstructured.Unstructured{
+            Object: map[string]interface{}{
+                "apiVersion": "v1",
+                "kind":       "ConfigMap",
+                "metadata": map[string]interface{}{
+                    "name":      getMaintenanceConfigMapName(),
+                    "namespace": "default",
+                    "labels": map[string]interface{}{
+                        "app": "hobbyfarm-provisioner",
+                    },
+                },
+                "data": map[string]interface{}{ip: reason},
+            },
+        }
+        _, err = client.Resource(configMapGVR).Namespace("default").Create(context.TODO(), configMap, metav1.CreateOptions{})
+    }
+    if err != nil {
+        return fmt.Errorf("failed to put %s into maintenance: %v", ip, err)
+    }
+
+    log.Printf("🔧 Static VM %s entered maintenance: %s", ip, reason)
+    recordPoolEvent(client, ip, eventTypeWarning, reasonVMQuarantined, "", "%s", reason)
+    return nil
+}
+
+func clearVMMaintenance(client dynamic.Interface, ip string) error {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "data": map[string]interface{}{ip: nil},
+    })
+
+    _, err := client.Resource(configMapGVR).Namespace("default").Patch(
+        context.TODO(), getMaintenanceConfigMapName(), types.MergePatchType, patchBytes, metav1.PatchOptions{})
+    if err != nil && !errors.IsNotFound(err) {
+        return fmt.Errorf("failed to clear maintenance for %s: %v", ip, err)
+    }
+
+    log.Printf("✅ Static VM %s left maintenance", ip)
+    recordPoolEvent(client, ip, eventTypeNormal, reasonVMReturnedToService, "", "")
+    return nil
+}
+
+// GET lists VMs in maintenance, POST ?ip=&reason= enables, DELETE ?ip= clears, on the admin listener
+func (ws *WebhookServer) maintenanceHandler(w http.ResponseWriter, r *http.Request) {
+    ip := r.URL.Query().Get("ip")
+
+    switch r.Method {
+    case http.MethodGet:
+        w.Header().Set("Content-Type", "application/json")
+        json.NewEncoder(w).Encode(getMaintenanceVMs(ws.client))
+        return
+    case http.MethodPost:
+        if ip == "" {
+            http.Error(w, "ip is required", http.StatusBadRequest)
+            return
+        }
+        if err := setVMMaintenance(ws.client, ip, r.URL.Query().Get("reason")); err != nil {
+            http.Error(w, err.Error(), http.StatusBadRequest)
+            return
+        }
+    case http.MethodDelete:
+        if ip == "" {
+            http.Error(w, "ip is required", http.StatusBadRequest)
+            return
+        }
+        if err := clearVMMaintenance(ws.client, ip); err != nil {
+            http.Error(w, err.Error(), http.StatusInternalServerError)
+            return
+        }
+    default:
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,100 @@
+// internal/vm_metadata.go - Which session owns a VM, written on the machine itself
+package internal
+
+import (
+    "context"
+    "encoding/base64"
+    "encoding/json"
+    "fmt"
+    "strings"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+)
+
+// Set at build time with -ldflags "-X hobbyfarm-vm-provisioner/internal.provisionerVersion=..."
+var provisionerVersion = "dev"
+
+// Where the metadata is written on the VM, readable by anyone logged in
+const vmMetadataPath = "/etc/hobbyfarm/metadata.json"
+
+// Owner of a VM as written to vmMetadataPath during provisioning
+type vmMetadata struct {
+    Session            string `json:"session"`
+    User               string `json:"user,omitempty"`
+    Scenario           string `json:"scenario,omitempty"`
+    Request            string `json:"request,omitempty"`
+    ProvisionerVersion string `json:"provisionerVersion"`
+    ProvisionedAt      string `json:"provisionedAt"`
+}
+
+func newVMMetadata(session, user, scenario, request string) vmMetadata {
+    return vmMetadata{
+        Session:            session,
+        User:               user,
+        Scenario:           scenario,
+        Request:            request,
+        ProvisionerVersion: provisionerVersion,
+        ProvisionedAt:      time.Now().Format(time.RFC3339),
+    }
+}
+
+// Metadata of a VM provisioned for a Kratix request
+func requestVMMetadata(request *unstructured.Unstructured) vmMetadata {
+    session, _, _ := unstructured.NestedString(request.Object, "spec", "session")
+    user, _, _ := unstructured.NestedString(request.Object, "spec", "user")
+    scenario, _, _ := unstructured.NestedString(request.Object, "spec", "scenario")
+    return newVMMetadata(session, user, scenario, request.GetName())
+}
+
+// Metadata of a VM provisioned for a direct-mode TrainingVM, named after its session
+func (ar *AnsibleRunner) trainingVMMetadata(sessionName, scenario string) vmMetadata {
+    user := ""
+    if trainingVM, err := ar.client.Resource(trainingVMGVR).Namespace("default").Get(context.TODO(), trainingVMNameForSession(sessionName), metav1.GetOptions{}); err == nil {
+        user, _, _ = unstructured.NestedString(trainingVM.Object, "spec", "user")
+    }
+    return newVMMetadata(sessionName, user, scenario, "")
+}
+
+// Write the metadata file, replacing the previous session's. Passed base64 encoded
+// so no value reaches the remote shell unquoted.
+func (ar *AnsibleRunner) writeVMMetadata(vmIP, sshUser string, metadata vmMetadata) error {
+    data, _ := json.MarshalIndent(metadata, "", "  ")
+    command := fmt.Sprintf("sudo mkdir -p /etc/hobbyfarm && echo %s | base64 -d | sudo tee %s >/dev/null && sudo chmod 644 %s",
+        base64.StdEncoding.EncodeToString(append(data, '\n')), vmMetadataPath, vmMetadataPath)
+    if output, err := ar.ssh.CombinedOutput(sshUser, vmIP, 15*time.Second, command); err != nil {
+        return fmt.Errorf("writing %s failed: %v: %s", vmMetadataPath, err, strings.TrimSpace(ar.sanitizeForLog(output, nil)))
+    }
+    return nil
+}
+
+// The metadata file of a VM, nil when there is none, as on VMs provisioned
+// before it was written
+func (ar *AnsibleRunner) readVMMetadata(vmIP, sshUser string) (*vmMetadata, error) {
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, fmt.Sprintf("cat %s 2>/dev/null || true", vmMetadataPath))
+    if err != nil {
+        return nil, err
+    }
+    if strings.TrimSpace(string(output)) == "" {
+        return nil, nil
+    }
+    var metadata vmMetadata
+    if err := json.Unmarshal(output, &metadata); err != nil {
+        return nil, fmt.Errorf("invalid %s: %v", vmMetadataPath, err)
+    }
+    return &metadata, nil
+}
+
+// Refuse to clean a VM whose metadata names another session; it was reallocated
+// or the address now points at a different machine
+func (ar *AnsibleRunner) verifyVMOwner(vmIP, sshUser, sessionName string) error {
+    metadata, err := ar.readVMMetadata(vmIP, sshUser)
+    if err != nil {
+        return fmt.Errorf("could not read VM metadata: %v", err)
+    }
+    if metadata != nil && metadata.Session != "" && metadata.Session != sessionName {
+        return fmt.Errorf("VM %s belongs to session %s according to %s, not %s", vmIP, metadata.Session, vmMetadataPath, sessionName)
+    }
+    return nil
+}
@@ -0,0 +1,115 @@
+// internal/vm_platform.go - Detect the OS family, version and architecture of a VM before provisioning
+package internal
+
+import (
+    "bufio"
+    "encoding/json"
+    "fmt"
+    "log"
+    "strings"
+    "time"
+)
+
+// What the playbooks need to pick apt or dnf tasks and the right download architecture
+type vmPlatform struct {
+    Family         string // debian, redhat
+    Distribution   string // ubuntu, debian, rocky, almalinux, rhel, ...
+    Version        string // VERSION_ID from /etc/os-release
+    Arch           string // amd64, arm64
+    PackageManager string // apt, dnf
+}
+
+func (p vmPlatform) String() string {
+    return fmt.Sprintf("%s %s %s", p.Distribution, p.Version, p.Arch)
+}
+
+// Inventory variables the playbooks branch on
+func (p vmPlatform) inventoryVars() map[string]string {
+    if p.Family == "" {
+        return nil
+    }
+    return map[string]string{
+        "os_family":       p.Family,
+        "os_distribution": p.Distribution,
+        "os_version":      p.Version,
+        "os_arch":         p.Arch,
+        "pkg_manager":     p.PackageManager,
+    }
+}
+
+// Read uname and /etc/os-release over SSH. Distributions the playbooks have no
+// task path for fail here with their name instead of halfway through base.yaml.
+func (ar *AnsibleRunner) detectVMPlatform(vmIP, sshUser string) (vmPlatform, error) {
+    output, err := ar.ssh.Output(sshUser, vmIP, 15*time.Second, "uname -m; cat /etc/os-release")
+    if err != nil {
+        return vmPlatform{}, fmt.Errorf("failed to read OS release of %s: %v", vmIP, err)
+    }
+
+    platform, err := parseVMPlatform(string(output))
+    if err != nil {
+        return vmPlatform{}, fmt.Errorf("%s: %v", vmIP, err)
+    }
+    log.Printf("🔍 Detected %s (%s family, %s) on %s", platform, platform.Family, platform.PackageManager, vmIP)
+    return platform, nil
+}
+
+// Parse "uname -m" followed by /etc/os-release
+func parseVMPlatform(output string) (vmPlatform, error) {
+    var platform vmPlatform
+    release := map[string]string{}
+
+    scanner := bufio.NewScanner(strings.NewReader(output))
+    for first := true; scanner.Scan(); first = false {
+        line := strings.TrimSpace(scanner.Text())
+        if first {
+            platform.Arch = normalizeArch(line)
+            continue
+        }
+        if key, value, found := strings.Cut(line, "="); found {
+            release[key] = strings.Trim(value, `"'`)
+        }
+    }
+
+    platform.Distribution = strings.ToLower(release["ID"])
+    platform.Version = release["VERSION_ID"]
+    if platform.Distribution == "" {
+        return vmPlatform{}, fmt.Errorf("no ID in /etc/os-release")
+    }
+
+    family := " " + platform.Distribution + " " + strings.ToLower(release["ID_LIKE"]) + " "
+    switch {
+    case strings.Contains(family, " debian ") || strings.Contains(family, " ubuntu "):
+        platform.Family = "debian"
+        platform.PackageManager = "apt"
+    case strings.Contains(family, " rhel ") || strings.Contains(family, " fedora ") || strings.Contains(family, " centos "):
+        platform.Family = "redhat"
+        platform.PackageManager = "dnf"
+    default:
+        return vmPlatform{}, fmt.Errorf("unsupported OS %s %s, playbooks support Debian and Red Hat families", platform.Distribution, platform.Version)
+    }
+    return platform, nil
+}
+
+// Map uname -m to the names release downloads use
+func normalizeArch(machine string) string {
+    switch machine {
+    case "x86_64", "amd64":
+        return "amd64"
+    case "aarch64", "arm64":
+        return "arm64"
+    default:
+        return machine
+    }
+}
+
+// Record the detected platform, e.g. "rocky 9.3 arm64", in the request status
+func (kc *KratixController) setPlatform(requestName string, platform vmPlatform) {
+    patchBytes, _ := json.Marshal(map[string]interface{}{
+        "status": map[string]interface{}{
+            "platform": platform.String(),
+        },
+    })
+    if err := patchStatus(kc.client, vmProvisioningRequestGVR, "default", requestName, patchBytes); err != nil {
+        log.Printf("⚠️ Failed to record platform of %s: %v", requestName, err)
+    }
+}
@@ -7,7 +7,6 @@ import (
 
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
     "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
-    "k8s.io/apimachinery/pkg/types"
     "k8s.io/client-go/dynamic"
 )
 
@@ -26,10 +25,9 @@ func CleanupVMStatuses(client dynamic.Interface) map[string]bool {
                 if err == nil && time.Since(t) > allocationTimeout {
                     log.Printf("♻️ Releasing expired VM %s", ip)
                     patch := `{"status":{"vmIP":"","state":"","allocatedAt":""}}`
-                    client.Resource(trainingVMGVR).Namespace("default").Patch(
-                        context.TODO(), tvm.GetName(), types.MergePatchType,
-                        []byte(patch), metav1.PatchOptions{}, "status",
-                    )
+                    if err := patchStatus(client, trainingVMGVR, "default", tvm.GetName(), []byte(patch)); err != nil {
+                        log.Printf("❌ Failed to release expired VM %s: %v", ip, err)
+                    }
                     continue
                 }
             }
@@ -40,5 +38,17 @@ func CleanupVMStatuses(client dynamic.Interface) map[string]bool {
         }
     }
 
+    // Static VMs held by Kratix requests are not available to TrainingVMs either
+    requests, err := client.Resource(vmProvisioningRequestGVR).Namespace("default").List(context.TODO(), metav1.ListOptions{})
+    if err == nil {
+        for _, request := range requests.Items {
+            ip, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+            state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+            if ip != "" && (state == "allocated" || state == "provisioning" || state == stateProvisionedUnverified || state == "ready") {
+                usedIPs[ip] = true
+            }
+        }
+    }
+
     return usedIPs
 }
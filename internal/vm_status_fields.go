@@ -0,0 +1,69 @@
+// internal/vm_status_fields.go - Field-level patches of HobbyFarm VirtualMachine status, limited to the fields the provisioner owns
+package internal
+
+import (
+    "encoding/json"
+    "log"
+    "sort"
+
+    "k8s.io/client-go/dynamic"
+)
+
+// Status fields HobbyFarm's own controllers keep; the provisioner writing them
+// races those controllers, e.g. a VM reported untainted after HobbyFarm tainted it
+var hobbyFarmVMStatusFields = []string{"allocated", "tainted", "environment_id", "vm_set_id"}
+
+// VirtualMachine status fields the provisioner writes, from VM_STATUS_OWNED_FIELDS.
+// The default is what a provisioned VM needs to open a shell on it.
+func getVMStatusOwnedFields() map[string]bool {
+    fields := splitEnvList("VM_STATUS_OWNED_FIELDS")
+    if len(fields) == 0 {
+        fields = []string{"status", "public_ip", "private_ip", "hostname", "ws_endpoint"}
+    }
+    owned := make(map[string]bool, len(fields))
+    for _, field := range fields {
+        owned[field] = true
+    }
+    return owned
+}
+
+// Patch the owned ones of fields into the VirtualMachine's status, leaving every
+// other status field as whoever set it left it
+func patchVirtualMachineStatus(client dynamic.Interface, namespace, vmName string, fields map[string]interface{}) error {
+    owned := getVMStatusOwnedFields()
+    status := map[string]interface{}{}
+    var skipped []string
+    for field, value := range fields {
+        if owned[field] {
+            status[field] = value
+        } else {
+            skipped = append(skipped, field)
+        }
+    }
+    if len(skipped) > 0 {
+        sort.Strings(skipped)
+        log.Printf("🔍 Leaving status fields %v of VirtualMachine %s to HobbyFarm", skipped, vmName)
+    }
+    if len(status) == 0 {
+        return nil
+    }
+
+    patchBytes, err := json.Marshal(map[string]interface{}{"status": status})
+    if err != nil {
+        return err
+    }
+    return patchStatusAs(client, virtualMachineGVR, namespace, vmName, vmFieldManager, patchBytes)
+}
+
+// Owning a HobbyFarm field works, but fights HobbyFarm's controllers over it
+func validateVMStatusFields(check *ConfigCheck) {
+    owned := getVMStatusOwnedFields()
+    for _, field := range hobbyFarmVMStatusFields {
+        if owned[field] {
+            check.warn("VM_STATUS_OWNED_FIELDS includes %s, which HobbyFarm's controllers also write", field)
+        }
+    }
+    if !owned["status"] || !owned["public_ip"] {
+        check.warn("VM_STATUS_OWNED_FIELDS leaves out status or public_ip, HobbyFarm won't see provisioned VMs as ready")
+    }
+}
@@ -7,8 +7,11 @@ import (
     "fmt"
     "io"
     "log"
+    "mime"
     "net/http"
+    "sort"
     "strings"
+    "time"
 
     admissionv1 "k8s.io/api/admission/v1"
     metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -26,9 +29,23 @@ var (
     }
 )
 
+// Admission reviews must answer within the API server's webhook timeout (10s by
+// default), so slow or stalled clients are cut off well before the pod is killed
+const (
+    webhookReadHeaderTimeout = 5 * time.Second
+    webhookReadTimeout       = 15 * time.Second
+    webhookWriteTimeout      = 30 * time.Second
+    webhookIdleTimeout       = 60 * time.Second
+    webhookMaxHeaderBytes    = 64 << 10
+    webhookMaxBodyBytes      = 4 << 20 // AdmissionReviews carry whole objects (etcd caps them at 1.5MB)
+    webhookShutdownTimeout   = 20 * time.Second
+)
+
 type WebhookServer struct {
     client dynamic.Interface
     server *http.Server
+    // Admin endpoints, nil when ADMIN_PORT is 0
+    admin *http.Server
 }
 
 func NewWebhookServer(client dynamic.Interface, port string) *WebhookServer {
@@ -37,33 +54,154 @@ func NewWebhookServer(client dynamic.Interface, port string) *WebhookServer {
     }
 
     mux := http.NewServeMux()
-    mux.HandleFunc("/mutate", ws.mutateHandler)
-    mux.HandleFunc("/health", ws.healthHandler)
+    for path, handler := range ws.webhookRoutes() {
+        mux.HandleFunc(path, handler)
+    }
+
+    // With mTLS only the exempt paths answer clients without a certificate
+    var handler http.Handler = mux
+    if mtlsEnabled() {
+        handler = requireClientCertificate(mux)
+    }
 
     ws.server = &http.Server{
-        Addr:    ":" + port,
-        Handler: mux,
+        Addr:              ":" + port,
+        Handler:           handler,
+        ReadHeaderTimeout: webhookReadHeaderTimeout,
+        ReadTimeout:       webhookReadTimeout,
+        WriteTimeout:      webhookWriteTimeout,
+        IdleTimeout:       webhookIdleTimeout,
+        MaxHeaderBytes:    webhookMaxHeaderBytes,
+    }
+    if adminEnabled() {
+        ws.admin = &http.Server{
+            Addr:              ":" + getAdminPort(),
+            Handler:           requireAdmin(client, ws.adminMux()),
+            ReadHeaderTimeout: webhookReadHeaderTimeout,
+            ReadTimeout:       webhookReadTimeout,
+            WriteTimeout:      webhookWriteTimeout,
+            IdleTimeout:       webhookIdleTimeout,
+            MaxHeaderBytes:    webhookMaxHeaderBytes,
+        }
     }
 
     return ws
 }
 
+// Paths of the webhook listener, reachable by anyone who reaches the Service.
+// Besides /mutate and /callback, which check their callers themselves, they only
+// answer GET; whatever changes pools or requests goes in adminMux.
+func (ws *WebhookServer) webhookRoutes() map[string]http.HandlerFunc {
+    return map[string]http.HandlerFunc{
+        "/mutate":         ws.mutateHandler,
+        "/health":         ws.healthHandler,
+        "/metrics":        ws.metricsHandler,
+        "/stats":          ws.statsHandler,
+        "/version":        ws.versionHandler,
+        "/netbox":         ws.netboxHandler,
+        "/queue":          ws.queueHandler,
+        "/capacity":       ws.capacityHandler,
+        "/callback":       ws.callbackHandler,
+        requestSchemaPath: ws.requestSchemaHandler,
+    }
+}
+
 func (ws *WebhookServer) Start() error {
+    if certDir := getWebhookCertDir(); certDir != "" {
+        // Certificates replaced on disk are picked up without a restart
+        reloader, err := newCertReloader(certDir, getMTLSClientCAFile())
+        if err != nil {
+            return err
+        }
+        ws.server.TLSConfig = reloader.tlsConfig()
+        if mtlsEnabled() {
+            log.Printf("🌐 Starting webhook server on %s with TLS from %s, client certificates from %s required", ws.server.Addr, certDir, getMTLSClientCAFile())
+        } else {
+            log.Printf("🌐 Starting webhook server on %s with TLS from %s", ws.server.Addr, certDir)
+        }
+        return ws.server.ListenAndServeTLS("", "")
+    }
     log.Printf("🌐 Starting webhook server on %s", ws.server.Addr)
     return ws.server.ListenAndServe()
 }
 
+// Serve the admin endpoints, over TLS with the webhook's certificate when it has
+// one. Callers authenticate with bearer tokens, not client certificates.
+func (ws *WebhookServer) StartAdmin() error {
+    if certDir := getWebhookCertDir(); certDir != "" {
+        reloader, err := newCertReloader(certDir, "")
+        if err != nil {
+            return err
+        }
+        ws.admin.TLSConfig = reloader.tlsConfig()
+        log.Printf("🛡️ Starting admin server on %s with TLS from %s", ws.admin.Addr, certDir)
+        return ws.admin.ListenAndServeTLS("", "")
+    }
+    log.Printf("🛡️ Starting admin server on %s", ws.admin.Addr)
+    return ws.admin.ListenAndServe()
+}
+
+// Stop accepting connections and wait for in-flight requests, up to webhookShutdownTimeout
+func (ws *WebhookServer) Shutdown() error {
+    ctx, cancel := context.WithTimeout(context.Background(), webhookShutdownTimeout)
+    defer cancel()
+    if ws.admin != nil {
+        if err := ws.admin.Shutdown(ctx); err != nil {
+            log.Printf("⚠️ Admin server shutdown: %v", err)
+        }
+    }
+    return ws.server.Shutdown(ctx)
+}
+
+// 503 while any controller loop has a stale heartbeat, so a loop that silently
+// stopped fails the liveness probe even though the process is still alive
 func (ws *WebhookServer) healthHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodGet && r.Method != http.MethodHead {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+    if stale := StaleHeartbeats(); len(stale) > 0 {
+        var lines []string
+        for subsystem, age := range stale {
+            lines = append(lines, fmt.Sprintf("%s: no heartbeat for %s", subsystem, age.Round(time.Second)))
+        }
+        sort.Strings(lines)
+        http.Error(w, strings.Join(lines, "\n"), http.StatusServiceUnavailable)
+        return
+    }
     w.WriteHeader(http.StatusOK)
     w.Write([]byte("OK"))
 }
 
+// AdmissionReview versions we answer. v1beta1 has the same wire format as v1,
+// so both decode into admissionv1 types and the response echoes the request's version.
+var supportedAdmissionReviewVersions = map[string]bool{
+    "admission.k8s.io/v1":      true,
+    "admission.k8s.io/v1beta1": true,
+}
+
 func (ws *WebhookServer) mutateHandler(w http.ResponseWriter, r *http.Request) {
+    if r.Method != http.MethodPost {
+        http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+        return
+    }
+
+    contentType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+    if err != nil || contentType != "application/json" {
+        log.Printf("❌ Rejected admission review with content type %q", r.Header.Get("Content-Type"))
+        http.Error(w, "expected Content-Type application/json", http.StatusUnsupportedMediaType)
+        return
+    }
+
     var body []byte
     if r.Body != nil {
-        if data, err := io.ReadAll(r.Body); err == nil {
-            body = data
+        data, err := io.ReadAll(http.MaxBytesReader(w, r.Body, webhookMaxBodyBytes))
+        if err != nil {
+            log.Printf("❌ Could not read admission review: %v", err)
+            http.Error(w, err.Error(), http.StatusRequestEntityTooLarge)
+            return
         }
+        body = data
     }
 
     var review admissionv1.AdmissionReview
@@ -73,6 +211,15 @@ func (ws *WebhookServer) mutateHandler(w http.ResponseWriter, r *http.Request) {
         return
     }
 
+    if review.APIVersion == "" {
+        review.APIVersion = "admission.k8s.io/v1"
+    }
+    if !supportedAdmissionReviewVersions[review.APIVersion] || review.Request == nil {
+        log.Printf("❌ Unsupported admission review %s (request present: %v)", review.APIVersion, review.Request != nil)
+        http.Error(w, fmt.Sprintf("unsupported AdmissionReview %s", review.APIVersion), http.StatusBadRequest)
+        return
+    }
+
     response := ws.processAdmissionReview(&review)
     
     respBytes, err := json.Marshal(response)
@@ -104,31 +251,50 @@ func (ws *WebhookServer) processAdmissionReview(review *admissionv1.AdmissionRev
             response.Result = &metav1.Status{
                 Message: fmt.Sprintf("Could not unmarshal object: %v", err),
             }
-            return &admissionv1.AdmissionReview{Response: response}
+            return admissionReviewResponse(review, response)
         }
 
-        // Create VMRequest instead of allowing the VirtualMachineClaim
-        if err := ws.createVMRequestFromClaim(&vmClaim); err != nil {
+        // Create VMRequest for the hybrid provisioner to fulfil the claim
+        vmRequestName, err := ws.createVMRequestFromClaim(&vmClaim)
+        if err != nil {
             log.Printf("❌ Failed to create VMRequest: %v", err)
             response.Allowed = false
             response.Result = &metav1.Status{
                 Message: fmt.Sprintf("Failed to create VMRequest: %v", err),
             }
-        } else {
-            log.Printf("✅ Successfully created VMRequest from VirtualMachineClaim")
-            // Deny the original VirtualMachineClaim since we've created a VMRequest instead
-            response.Allowed = false
-            response.Result = &metav1.Status{
-                Code:    http.StatusOK,
-                Message: "Redirected to hybrid provisioner VMRequest",
-            }
+            return admissionReviewResponse(review, response)
+        }
+        log.Printf("✅ Successfully created VMRequest from VirtualMachineClaim")
+        
+        // Admit the claim marked as redirected, so the binding controller can trace it
+        // to its VM and bind it once ready instead of HobbyFarm timing out on it
+        patch, err := claimRedirectPatch(&vmClaim, vmRequestName)
+        if err != nil {
+            log.Printf("❌ Failed to build claim patch: %v", err)
+            return admissionReviewResponse(review, response)
         }
+        patchType := admissionv1.PatchTypeJSONPatch
+        response.Patch = patch
+        response.PatchType = &patchType
     }
 
-    return &admissionv1.AdmissionReview{Response: response}
+    return admissionReviewResponse(review, response)
 }
 
-func (ws *WebhookServer) createVMRequestFromClaim(vmClaim *unstructured.Unstructured) error {
+// Wrap a response in an AdmissionReview of the request's version; API servers reject
+// responses without apiVersion/kind or whose uid differs from the request
+func admissionReviewResponse(review *admissionv1.AdmissionReview, response *admissionv1.AdmissionResponse) *admissionv1.AdmissionReview {
+    response.UID = review.Request.UID
+    return &admissionv1.AdmissionReview{
+        TypeMeta: metav1.TypeMeta{
+            APIVersion: review.APIVersion,
+            Kind:       "AdmissionReview",
+        },
+        Response: response,
+    }
+}
+
+func (ws *WebhookServer) createVMRequestFromClaim(vmClaim *unstructured.Unstructured) (string, error) {
     // Extract information from VirtualMachineClaim
     claimName := vmClaim.GetName()
     namespace := vmClaim.GetNamespace()
@@ -166,7 +332,7 @@ func (ws *WebhookServer) createVMRequestFromClaim(vmClaim *unstructured.Unstruct
     provisioningConfig := ws.getProvisioningConfigFromScenario(scenario)
 
     // Create VMRequest
-    vmRequestName := fmt.Sprintf("vmreq-%s", session)
+    vmRequestName := vmRequestNameForSession(session)
     vmRequest := &unstructured.Unstructured{
         Object: map[string]interface{}{
             "apiVersion": "vm.hobbyfarm.io/v1",
@@ -175,13 +341,12 @@ func (ws *WebhookServer) createVMRequestFromClaim(vmClaim *unstructured.Unstruct
                 "name":      vmRequestName,
                 "namespace": namespace,
                 "labels": map[string]interface{}{
-                    "hobbyfarm.io/user":       user,
-                    "hobbyfarm.io/session":    session,
-                    "hobbyfarm.io/scenario":   scenario,
-                    "hobbyfarm.io/environment": environment,
-                    "hobbyfarm.io/vmtemplate": vmTemplate,
-                    "hobbyfarm.io/claim":      claimName,
-                    "provisioner":             "hybrid-provisioner",
+                    "hobbyfarm.io/user":        safeLabelValue(user),
+                    "hobbyfarm.io/scenario":    safeLabelValue(scenario),
+                    "hobbyfarm.io/environment": safeLabelValue(environment),
+                    "hobbyfarm.io/vmtemplate":  safeLabelValue(vmTemplate),
+                    "hobbyfarm.io/claim":       safeLabelValue(claimName),
+                    "provisioner":              "hybrid-provisioner",
                 },
                 "annotations": map[string]interface{}{
                     "hobbyfarm.io/original-claim": claimName,
@@ -199,16 +364,17 @@ func (ws *WebhookServer) createVMRequestFromClaim(vmClaim *unstructured.Unstruct
             },
         },
     }
+    setSessionLabel(vmRequest, session)
 
     _, err := ws.client.Resource(webhookVMRequestGVR).Namespace(namespace).Create(
         context.TODO(), vmRequest, metav1.CreateOptions{})
     
     if err != nil {
-        return fmt.Errorf("failed to create VMRequest: %v", err)
+        return "", fmt.Errorf("failed to create VMRequest: %v", err)
     }
 
     log.Printf("✅ Created VMRequest %s for user %s, session %s", vmRequestName, user, session)
-    return nil
+    return vmRequestName, nil
 }
 
 func (ws *WebhookServer) getProvisioningConfigFromScenario(scenarioName string) map[string]interface{} {
@@ -216,17 +382,11 @@ func (ws *WebhookServer) getProvisioningConfigFromScenario(scenarioName string)
         return ws.getDefaultProvisioningConfig()
     }
 
-    // Try to get scenario from cluster
-    scenario, err := ws.client.Resource(scenarioGVR).Namespace("default").Get(
-        context.TODO(), scenarioName, metav1.GetOptions{})
+    // Try to get scenario from cluster, default before hobbyfarm-system
+    scenario, err := getScenario(ws.client, scenarioName, "default", hobbyFarmNamespace())
     if err != nil {
-        // Try hobbyfarm-system namespace
-        scenario, err = ws.client.Resource(scenarioGVR).Namespace("hobbyfarm-system").Get(
-            context.TODO(), scenarioName, metav1.GetOptions{})
-        if err != nil {
-            log.Printf("⚠️ Could not get scenario %s, using defaults: %v", scenarioName, err)
-            return ws.getDefaultProvisioningConfig()
-        }
+        log.Printf("⚠️ Could not get scenario %s, using defaults: %v", scenarioName, err)
+        return ws.getDefaultProvisioningConfig()
     }
 
     annotations := scenario.GetAnnotations()
@@ -304,15 +464,38 @@ func (ws *WebhookServer) getDefaultProvisioningConfig() map[string]interface{} {
     }
 }
 
-// Start webhook server in a goroutine
-func StartWebhookServer(client dynamic.Interface, port string) {
+// Run the webhook server until ctx is cancelled, then shut it down gracefully so
+// in-flight admission reviews are answered. Returns once the server has stopped.
+func RunWebhookServer(ctx context.Context, client dynamic.Interface, port string) error {
     webhookServer := NewWebhookServer(client, port)
     
+    errChan := make(chan error, 2)
     go func() {
-        if err := webhookServer.Start(); err != nil && err != http.ErrServerClosed {
-            log.Fatalf("❌ Webhook server failed to start: %v", err)
-        }
+        errChan <- webhookServer.Start()
     }()
+    if webhookServer.admin != nil {
+        go func() {
+            errChan <- webhookServer.StartAdmin()
+        }()
+    }
     
     log.Printf("🌐 Webhook server started on port %s", port)
+    
+    select {
+    case err := <-errChan:
+        if err != http.ErrServerClosed {
+            // The other listener stops with the one that failed
+            webhookServer.Shutdown()
+            return fmt.Errorf("webhook server failed: %v", err)
+        }
+        return nil
+    case <-ctx.Done():
+    }
+    
+    log.Println("🛑 Shutting down webhook server...")
+    if err := webhookServer.Shutdown(); err != nil {
+        return fmt.Errorf("webhook server shutdown: %v", err)
+    }
+    log.Println("✅ Webhook server stopped")
+    return nil
 }
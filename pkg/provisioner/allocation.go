@@ -0,0 +1,60 @@
+// pkg/provisioner/allocation.go - Which VM addresses are currently held by a request or TrainingVM
+package provisioner
+
+import (
+    "context"
+    "fmt"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/client-go/dynamic"
+)
+
+// Collect every IP held by a VMProvisioningRequest or a TrainingVM, so both
+// flows sharing the static pool see each other's allocations
+func AllocatedIPs(ctx context.Context, client dynamic.Interface) (map[string]bool, error) {
+    usedIPs := make(map[string]bool)
+
+    requests, err := client.Resource(VMProvisioningRequestGVR).Namespace(DefaultNamespace).List(ctx, metav1.ListOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to list VMProvisioningRequests: %v", err)
+    }
+    for _, request := range requests.Items {
+        vmIP, _, _ := unstructured.NestedString(request.Object, "status", "vmIP")
+        state, _, _ := unstructured.NestedString(request.Object, "status", "state")
+        if vmIP != "" && (state == StateAllocated || state == StateProvisioning || state == StateProvisionedUnverified || state == StateReady) {
+            usedIPs[vmIP] = true
+        }
+    }
+
+    // TrainingVMs are optional (CRD may be absent in kratix-only installs)
+    trainingVMs, err := client.Resource(TrainingVMGVR).Namespace(DefaultNamespace).List(ctx, metav1.ListOptions{})
+    if err == nil {
+        for _, tvm := range trainingVMs.Items {
+            vmIP, _, _ := unstructured.NestedString(tvm.Object, "status", "vmIP")
+            state, _, _ := unstructured.NestedString(tvm.Object, "status", "state")
+            if vmIP != "" && state != "" {
+                usedIPs[vmIP] = true
+            }
+        }
+    }
+
+    return usedIPs, nil
+}
+
+// Static VMs of pool that are neither allocated nor in maintenance
+func FreeStaticVMs(ctx context.Context, client dynamic.Interface, pool []string, maintenance map[string]string) ([]string, error) {
+    usedIPs, err := AllocatedIPs(ctx, client)
+    if err != nil {
+        return nil, err
+    }
+
+    var free []string
+    for _, ip := range pool {
+        if _, drained := maintenance[ip]; drained || usedIPs[ip] {
+            continue
+        }
+        free = append(free, ip)
+    }
+    return free, nil
+}
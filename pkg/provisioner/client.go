@@ -0,0 +1,172 @@
+// pkg/provisioner/client.go - Create VMProvisioningRequests and follow them to ready
+package provisioner
+
+import (
+    "context"
+    "fmt"
+    "time"
+
+    metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+    "k8s.io/client-go/dynamic"
+    "k8s.io/client-go/rest"
+)
+
+// Typed client for VMProvisioningRequests over the dynamic client
+type Client struct {
+    dynamic dynamic.Interface
+}
+
+func NewClient(client dynamic.Interface) *Client {
+    return &Client{dynamic: client}
+}
+
+func NewForConfig(config *rest.Config) (*Client, error) {
+    client, err := dynamic.NewForConfig(config)
+    if err != nil {
+        return nil, err
+    }
+    return NewClient(client), nil
+}
+
+// What a caller needs to ask for a VM; everything else gets the provisioner's defaults
+type VMRequestOptions struct {
+    Name          string // Defaults to Session
+    User          string
+    Session       string
+    Scenario      string
+    Environment   string
+    Playbooks     []string
+    Variables     map[string]string
+    CloudFallback bool
+    Labels        map[string]string // TenantLabel is refused, the provisioner derives it from the session
+}
+
+// Build and create a request the way the HobbyFarm integration does
+func (c *Client) RequestVM(ctx context.Context, options VMRequestOptions) (*VMProvisioningRequest, error) {
+    if options.User == "" || options.Session == "" {
+        return nil, fmt.Errorf("user and session are required")
+    }
+    if _, set := options.Labels[TenantLabel]; set {
+        return nil, fmt.Errorf("label %s is set by the provisioner from the session's namespace", TenantLabel)
+    }
+    name := options.Name
+    if name == "" {
+        name = options.Session
+    }
+
+    labels := map[string]string{
+        SessionLabel: options.Session,
+        UserLabel:    options.User,
+    }
+    if options.Scenario != "" {
+        labels[ScenarioLabel] = options.Scenario
+    }
+    if options.Environment != "" {
+        labels[EnvironmentLabel] = options.Environment
+    }
+    for key, value := range options.Labels {
+        labels[key] = value
+    }
+
+    preferStatic := true
+    request := &VMProvisioningRequest{
+        Name:   name,
+        Labels: labels,
+        Spec: VMProvisioningRequestSpec{
+            User:           options.User,
+            Session:        options.Session,
+            Scenario:       options.Scenario,
+            Environment:    options.Environment,
+            PreferStaticVM: &preferStatic,
+            CloudFallback:  &CloudFallbackSpec{Enabled: options.CloudFallback, Provider: "aws"},
+        },
+    }
+    if len(options.Playbooks) > 0 || len(options.Variables) > 0 {
+        request.Spec.Provisioning = &ProvisioningSpec{
+            Playbooks: options.Playbooks,
+            Variables: options.Variables,
+        }
+    }
+    return c.Create(ctx, request)
+}
+
+func (c *Client) Create(ctx context.Context, request *VMProvisioningRequest) (*VMProvisioningRequest, error) {
+    object, err := request.ToUnstructured()
+    if err != nil {
+        return nil, err
+    }
+    created, err := c.dynamic.Resource(VMProvisioningRequestGVR).Namespace(DefaultNamespace).Create(ctx, object, metav1.CreateOptions{})
+    if err != nil {
+        return nil, fmt.Errorf("failed to create VMProvisioningRequest %s: %v", request.Name, err)
+    }
+    return FromUnstructured(created)
+}
+
+func (c *Client) Get(ctx context.Context, name string) (*VMProvisioningRequest, error) {
+    object, err := c.dynamic.Resource(VMProvisioningRequestGVR).Namespace(DefaultNamespace).Get(ctx, name, metav1.GetOptions{})
+    if err != nil {
+        return nil, err
+    }
+    return FromUnstructured(object)
+}
+
+// List requests, optionally filtered by a label selector such as "hobbyfarm.io/user=alice"
+func (c *Client) List(ctx context.Context, labelSelector string) ([]*VMProvisioningRequest, error) {
+    objects, err := c.dynamic.Resource(VMProvisioningRequestGVR).Namespace(DefaultNamespace).List(ctx, metav1.ListOptions{
+        LabelSelector: labelSelector,
+    })
+    if err != nil {
+        return nil, err
+    }
+
+    requests := make([]*VMProvisioningRequest, 0, len(objects.Items))
+    for i := range objects.Items {
+        request, err := FromUnstructured(&objects.Items[i])
+        if err != nil {
+            return nil, err
+        }
+        requests = append(requests, request)
+    }
+    return requests, nil
+}
+
+// The request serving a session, whatever it was named
+func (c *Client) ForSession(ctx context.Context, session string) (*VMProvisioningRequest, error) {
+    requests, err := c.List(ctx, SessionLabel+"="+session)
+    if err != nil {
+        return nil, err
+    }
+    if len(requests) == 0 {
+        return c.Get(ctx, session)
+    }
+    return requests[0], nil
+}
+
+// Deleting a request releases its VM; cloud instances are torn down by the provisioner
+func (c *Client) Delete(ctx context.Context, name string) error {
+    return c.dynamic.Resource(VMProvisioningRequestGVR).Namespace(DefaultNamespace).Delete(ctx, name, metav1.DeleteOptions{})
+}
+
+// Poll until the request is ready or failed, or ctx is done
+func (c *Client) WaitForReady(ctx context.Context, name string, interval time.Duration) (*VMProvisioningRequest, error) {
+    ticker := time.NewTicker(interval)
+    defer ticker.Stop()
+
+    for {
+        request, err := c.Get(ctx, name)
+        if err == nil {
+            if request.IsReady() {
+                return request, nil
+            }
+            if request.IsFailed() {
+                return request, fmt.Errorf("request %s failed: %s", name, request.Status.LastError)
+            }
+        }
+
+        select {
+        case <-ctx.Done():
+            return nil, fmt.Errorf("request %s not ready: %v", name, ctx.Err())
+        case <-ticker.C:
+        }
+    }
+}
@@ -0,0 +1,67 @@
+// pkg/provisioner/detection.go - Tell static pool VMs from cloud instances and probe SSH reachability
+package provisioner
+
+import (
+    "net"
+    "time"
+)
+
+// Cloud instances have public IPs; static pool VMs live in private (RFC 1918 or
+// ULA) or loopback ranges. Anything else, including a malformed address, counts
+// as public.
+func IsPublicIP(ip string) bool {
+    parsed := net.ParseIP(ip)
+    return !parsed.IsPrivate() && !parsed.IsLoopback()
+}
+
+// "EC2" for cloud instances, "static" for pool VMs
+func VMType(ip string) string {
+    if IsPublicIP(ip) {
+        return "EC2"
+    }
+    return "static"
+}
+
+// How long a freshly allocated VM needs before provisioning can start
+func BootWaitTime(ip string) time.Duration {
+    if IsPublicIP(ip) {
+        return 2 * time.Minute // EC2 instances need more time
+    }
+    return 30 * time.Second // Static VMs boot faster
+}
+
+// How long to keep retrying SSH before giving up on a VM
+func SSHTimeout(ip string) time.Duration {
+    if IsPublicIP(ip) {
+        return 5 * time.Minute // EC2 instances need more time for SSH
+    }
+    return 2 * time.Minute // Static VMs should be ready faster
+}
+
+// Whether port 22 accepts connections. Cloud instances get three attempts with
+// longer timeouts since sshd comes up late on first boot.
+func IsReachable(ip string) bool {
+    if !IsPublicIP(ip) {
+        return dialSSH(ip, 5*time.Second)
+    }
+
+    maxAttempts := 3
+    for attempt := 1; attempt <= maxAttempts; attempt++ {
+        if dialSSH(ip, 15*time.Second) {
+            return true
+        }
+        if attempt < maxAttempts {
+            time.Sleep(10 * time.Second)
+        }
+    }
+    return false
+}
+
+func dialSSH(ip string, timeout time.Duration) bool {
+    conn, err := net.DialTimeout("tcp", ip+":22", timeout)
+    if err != nil {
+        return false
+    }
+    conn.Close()
+    return true
+}
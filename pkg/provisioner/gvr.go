@@ -0,0 +1,86 @@
+// pkg/provisioner/gvr.go - Resources the provisioner reads and writes
+//
+// Package provisioner is the public client library of the HobbyFarm VM provisioner.
+// Platform tools (a Backstage plugin, a custom portal) use it to request VMs through
+// VMProvisioningRequests and follow them to ready without depending on the
+// controller internals.
+package provisioner
+
+import (
+    "k8s.io/apimachinery/pkg/runtime/schema"
+)
+
+// Namespace the provisioner watches for VMProvisioningRequests, TrainingVMs and cloud instances
+const DefaultNamespace = "default"
+
+// Namespace HobbyFarm keeps Sessions and VirtualMachines in
+const HobbyFarmNamespace = "hobbyfarm-system"
+
+var (
+    // Kratix Promise VMProvisioningRequest
+    VMProvisioningRequestGVR = schema.GroupVersionResource{
+        Group:    "platform.kratix.io",
+        Version:  "v1alpha1",
+        Resource: "vm-provisioning-requests",
+    }
+
+    // Direct-mode allocation record and its Crossplane EC2 claim
+    TrainingVMGVR = schema.GroupVersionResource{
+        Group:    "training.example.com",
+        Version:  "v1",
+        Resource: "trainingvms",
+    }
+    EC2TrainingVMGVR = schema.GroupVersionResource{
+        Group:    "training.example.com",
+        Version:  "v1",
+        Resource: "ec2trainingvms",
+    }
+
+    // Per-course aggregate of VMProvisioningRequests, one object per class
+    CourseProvisioningStatusGVR = schema.GroupVersionResource{
+        Group:    "training.example.com",
+        Version:  "v1",
+        Resource: "courseprovisioningstatuses",
+    }
+
+    // One object per environment's static pool, the Events of its VMs are attached to it
+    StaticVMPoolGVR = schema.GroupVersionResource{
+        Group:    "training.example.com",
+        Version:  "v1",
+        Resource: "staticvmpools",
+    }
+
+    // HobbyFarm resources
+    SessionGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
+        Version:  "v1",
+        Resource: "sessions",
+    }
+    ScenarioGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
+        Version:  "v1",
+        Resource: "scenarios",
+    }
+    VirtualMachineGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
+        Version:  "v1",
+        Resource: "virtualmachines",
+    }
+    VirtualMachineClaimGVR = schema.GroupVersionResource{
+        Group:    "hobbyfarm.io",
+        Version:  "v1",
+        Resource: "virtualmachineclaims",
+    }
+)
+
+// Labels the provisioner sets on VMProvisioningRequests
+const (
+    SessionLabel     = "hobbyfarm.io/session"
+    UserLabel        = "hobbyfarm.io/user"
+    ScenarioLabel    = "hobbyfarm.io/scenario"
+    CourseLabel      = "hobbyfarm.io/course"
+    EnvironmentLabel = "hobbyfarm.io/environment"
+    TenantLabel      = "hobbyfarm.io/tenant"
+    // "true" on failed requests that are no longer retried, until they are re-driven
+    DeadLetterLabel = "provisioning.hobbyfarm.io/dead-letter"
+)
@@ -0,0 +1,154 @@
+// pkg/provisioner/packages.go - Package detection: which packages a scenario's VMs get, from its annotation or pluggable strategies
+package provisioner
+
+import (
+    "bytes"
+    "context"
+    "encoding/json"
+    "fmt"
+    "net/http"
+    "strings"
+    "time"
+)
+
+// Scenario or Session annotation listing packages, comma-separated
+const PackagesAnnotation = "provisioning.hobbyfarm.io/packages"
+
+// Rules a scenario's packages come from, besides the names of strategies
+const (
+    PackageRuleAnnotation = "scenario-annotation"
+    // No rule matched: the VM only gets the playbooks' base packages
+    PackageRuleDefault = "default"
+    // Rule name of packages an HTTPDetector picked
+    PackageRuleHTTPDetector = "http-detector"
+)
+
+// What a detection strategy is asked about: the scenario a VM is provisioned
+// for and, where known, the session, its user and the course being simulated
+type DetectionInput struct {
+    Session     string            `json:"session,omitempty"`
+    User        string            `json:"user,omitempty"`
+    Scenario    string            `json:"scenario,omitempty"`
+    Course      string            `json:"course,omitempty"`
+    Annotations map[string]string `json:"annotations"`
+}
+
+// Package detection logic of an organization, e.g. asking its LMS which tools a
+// course needs. Detect reports matched=false to leave the decision to the next
+// strategy; an error is treated the same. Name is the rule recorded for the
+// packages it picks, so it should be short and stable.
+type DetectionStrategy interface {
+    Name() string
+    Detect(ctx context.Context, input DetectionInput) (packages []string, matched bool, err error)
+}
+
+// The packages picked for one input and the rule that picked them
+type PackageDetection struct {
+    Rule     string
+    Packages []string
+    // Why the default applied
+    Reason string
+}
+
+// Asks the annotation rule, then each strategy in turn; the first that matches decides
+type Detector struct {
+    Strategies []DetectionStrategy
+    // How long one strategy may take before detection moves on without it, 5s when zero
+    Timeout time.Duration
+    // Told about each strategy that failed, optional
+    OnError func(strategy DetectionStrategy, input DetectionInput, err error)
+}
+
+func (d Detector) Detect(input DetectionInput) PackageDetection {
+    value, annotated := input.Annotations[PackagesAnnotation]
+    if packages := splitPackages(value); len(packages) > 0 {
+        return PackageDetection{Rule: PackageRuleAnnotation, Packages: packages}
+    }
+
+    timeout := d.Timeout
+    if timeout <= 0 {
+        timeout = 5 * time.Second
+    }
+    for _, strategy := range d.Strategies {
+        ctx, cancel := context.WithTimeout(context.Background(), timeout)
+        packages, matched, err := strategy.Detect(ctx, input)
+        cancel()
+        if err != nil {
+            if d.OnError != nil {
+                d.OnError(strategy, input, err)
+            }
+            continue
+        }
+        if matched {
+            if packages == nil {
+                packages = []string{}
+            }
+            return PackageDetection{Rule: strategy.Name(), Packages: packages}
+        }
+    }
+
+    if !annotated {
+        return PackageDetection{Rule: PackageRuleDefault, Packages: []string{}, Reason: "no " + PackagesAnnotation + " annotation"}
+    }
+    return PackageDetection{Rule: PackageRuleDefault, Packages: []string{}, Reason: PackagesAnnotation + " annotation is empty"}
+}
+
+func splitPackages(value string) []string {
+    var packages []string
+    for _, item := range strings.Split(value, ",") {
+        if trimmed := strings.TrimSpace(item); trimmed != "" {
+            packages = append(packages, trimmed)
+        }
+    }
+    return packages
+}
+
+// A detector behind an HTTP endpoint, for logic that is easier to run beside the
+// provisioner than to compile into it. It is POSTed the DetectionInput as JSON
+// and answers {"packages": [...]}; 204, 404 or no packages mean no match.
+type HTTPDetector struct {
+    URL string
+    // Sent as a bearer token when set
+    Token string
+    // http.DefaultClient when nil
+    Client *http.Client
+}
+
+func (detector HTTPDetector) Name() string {
+    return PackageRuleHTTPDetector
+}
+
+func (detector HTTPDetector) Detect(ctx context.Context, input DetectionInput) ([]string, bool, error) {
+    body, _ := json.Marshal(input)
+    req, err := http.NewRequestWithContext(ctx, http.MethodPost, detector.URL, bytes.NewReader(body))
+    if err != nil {
+        return nil, false, err
+    }
+    req.Header.Set("Content-Type", "application/json")
+    if detector.Token != "" {
+        req.Header.Set("Authorization", "Bearer "+detector.Token)
+    }
+    client := detector.Client
+    if client == nil {
+        client = http.DefaultClient
+    }
+    resp, err := client.Do(req)
+    if err != nil {
+        return nil, false, err
+    }
+    defer resp.Body.Close()
+
+    switch {
+    case resp.StatusCode == http.StatusNoContent, resp.StatusCode == http.StatusNotFound:
+        return nil, false, nil
+    case resp.StatusCode != http.StatusOK:
+        return nil, false, fmt.Errorf("%s answered %s", detector.URL, resp.Status)
+    }
+    var answer struct {
+        Packages []string `json:"packages"`
+    }
+    if err := json.NewDecoder(resp.Body).Decode(&answer); err != nil {
+        return nil, false, fmt.Errorf("invalid answer from %s: %v", detector.URL, err)
+    }
+    return answer.Packages, len(answer.Packages) > 0, nil
+}
@@ -0,0 +1,281 @@
+// pkg/provisioner/types.go - Typed view of the VMProvisioningRequest API
+package provisioner
+
+import (
+    "k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+    "k8s.io/apimachinery/pkg/runtime"
+)
+
+// Values of status.state
+const (
+    StatePending      = "pending"
+    StateAllocated    = "allocated"
+    StateProvisioning = "provisioning"
+    // Playbooks done, waiting on verification and the shell probe
+    StateProvisionedUnverified = "provisioned-unverified"
+    StateReady                 = "ready"
+    StateFailed                = "failed"
+    // The VM was given back; the request is kept for auditing, then deleted
+    StateReleased = "released"
+)
+
+// Values of status.releaseReason, set together with StateReleased
+const (
+    // The HobbyFarm Session finished or expired
+    ReleaseSessionFinished = "SessionFinished"
+    // The VM was held longer than MAX_ALLOCATION_HOURS
+    ReleaseMaxLifetime = "MaxLifetimeExceeded"
+    // Its cloud VM was terminated outside business hours
+    ReleaseScaledDown = "ScaledDownOffHours"
+    // A bulk release of the request's event or course
+    ReleaseBulk = "BulkRelease"
+    // The request alone, through /release, the release subcommand or the release annotation
+    ReleaseRequested = "Requested"
+)
+
+// Values of status.failureReason, set together with StateFailed
+const (
+    // The VM never accepted SSH logins
+    FailureSSHTimeout = "SSHTimeout"
+    // A playbook, or the SSH user and platform detection before it, failed
+    FailurePlaybookFailed = "PlaybookFailed"
+    // No static VM was free and no cloud placement had capacity
+    FailureNoCapacity = "NoCapacity"
+    // An AWS limit or a tenant's cloud quota was hit
+    FailureCloudQuota = "CloudQuota"
+    // The toolchain the scenario needs is missing after provisioning
+    FailureVerificationFailed = "VerificationFailed"
+    // The VM stopped answering, or its shell or overlay address can't be reached
+    FailureUnreachable = "Unreachable"
+    // Creating the cloud instance failed for another reason
+    FailureCloudError = "CloudError"
+    // The request asked for an environment its tenant does not own
+    FailureTenantRefused = "TenantRefused"
+    // The machine in spec.adoptVM could not be found or is held by another request
+    FailureAdoptionRefused = "AdoptionRefused"
+)
+
+// Every failure reason, in the order /metrics lists them
+var FailureReasons = []string{
+    FailureSSHTimeout, FailurePlaybookFailed, FailureNoCapacity, FailureCloudQuota,
+    FailureVerificationFailed, FailureUnreachable, FailureCloudError, FailureTenantRefused,
+    FailureAdoptionRefused,
+}
+
+// A VMProvisioningRequest, see kratix/promises/vm-provisioning-promise.yaml for the schema
+type VMProvisioningRequest struct {
+    Name        string                      `json:"-"`
+    Labels      map[string]string           `json:"-"`
+    Annotations map[string]string           `json:"-"`
+    Spec        VMProvisioningRequestSpec   `json:"spec"`
+    Status      VMProvisioningRequestStatus `json:"status,omitempty"`
+}
+
+type VMProvisioningRequestSpec struct {
+    User           string             `json:"user"`
+    Session        string             `json:"session"`
+    Scenario       string             `json:"scenario,omitempty"`
+    Environment    string             `json:"environment,omitempty"`
+    VMTemplate     string             `json:"vmTemplate,omitempty"`
+    Timeout        int64              `json:"timeout,omitempty"`
+    PreferStaticVM *bool              `json:"preferStaticVM,omitempty"`
+    Provisioning   *ProvisioningSpec  `json:"provisioning,omitempty"`
+    CloudFallback  *CloudFallbackSpec `json:"cloudFallback,omitempty"`
+    Ports          []PortSpec         `json:"ports,omitempty"`
+    Connectivity   *ConnectivitySpec  `json:"connectivity,omitempty"`
+    AdoptVM        *AdoptVMSpec       `json:"adoptVM,omitempty"`
+}
+
+type ProvisioningSpec struct {
+    Playbooks            []string          `json:"playbooks,omitempty"`
+    Packages             []string          `json:"packages,omitempty"`
+    Requirements         []string          `json:"requirements,omitempty"`
+    Variables            map[string]string `json:"variables,omitempty"`
+    ExecutionEnvironment string            `json:"executionEnvironment,omitempty"`
+    AnsibleVersion       string            `json:"ansibleVersion,omitempty"`
+    InventoryTemplate    string            `json:"inventoryTemplate,omitempty"`
+    SecretVariables      []string          `json:"secretVariables,omitempty"`
+    // Playbooks re-run on retry even when an earlier run completed them
+    AlwaysRerun          []string          `json:"alwaysRerun,omitempty"`
+}
+
+// Instance type and region default to the provisioner's cloud instance template
+type CloudFallbackSpec struct {
+    Enabled        bool             `json:"enabled"`
+    Provider       string           `json:"provider,omitempty"`
+    InstanceType   string           `json:"instanceType,omitempty"`
+    Region         string           `json:"region,omitempty"`
+    RootVolumeSize int64            `json:"rootVolumeSize,omitempty"`
+    DataVolumes    []DataVolumeSpec `json:"dataVolumes,omitempty"`
+}
+
+// An extra EBS volume in GiB
+type DataVolumeSpec struct {
+    Size       int64  `json:"size"`
+    MountPoint string `json:"mountPoint,omitempty"`
+    VolumeType string `json:"volumeType,omitempty"`
+}
+
+// A machine supplied out of band to provision instead of allocating one: an
+// address, or an EC2 instance whose address is looked up
+type AdoptVMSpec struct {
+    IP         string `json:"ip,omitempty"`
+    InstanceID string `json:"instanceId,omitempty"`
+    Region     string `json:"region,omitempty"`
+}
+
+// A port the scenario's services listen on
+type PortSpec struct {
+    Name     string `json:"name,omitempty"`
+    Port     int64  `json:"port"`
+    Protocol string `json:"protocol,omitempty"`
+}
+
+type ConnectivitySpec struct {
+    Mode      string `json:"mode,omitempty"`
+    OverlayIP string `json:"overlayIP,omitempty"`
+}
+
+// Written by the provisioner only
+type VMProvisioningRequestStatus struct {
+    State                string            `json:"state,omitempty"`
+    VMIP                 string            `json:"vmIP,omitempty"`
+    VMType               string            `json:"vmType,omitempty"`
+    OverlayIP            string            `json:"overlayIP,omitempty"`
+    Platform             string            `json:"platform,omitempty"`
+    Hostname             string            `json:"hostname,omitempty"`
+    InstanceID           string            `json:"instanceId,omitempty"`
+    AvailabilityZone     string            `json:"availabilityZone,omitempty"`
+    Region               string            `json:"region,omitempty"`
+    ConsoleURL           string            `json:"consoleURL,omitempty"`
+    Site                 string            `json:"site,omitempty"`
+    Provisioned          bool              `json:"provisioned,omitempty"`
+    AllocatedAt          string            `json:"allocatedAt,omitempty"`
+    ReadyAt              string            `json:"readyAt,omitempty"`
+    ReleasedAt           string            `json:"releasedAt,omitempty"`
+    ReleaseReason        string            `json:"releaseReason,omitempty"`
+    LastError            string            `json:"lastError,omitempty"`
+    FailureReason        string            `json:"failureReason,omitempty"`
+    FailedAt             string            `json:"failedAt,omitempty"`
+    DeadLetteredAt       string            `json:"deadLetteredAt,omitempty"`
+    CompletedVia         string            `json:"completedVia,omitempty"`
+    QueuePosition        int64             `json:"queuePosition,omitempty"`
+    EstimatedWaitSeconds int64             `json:"estimatedWaitSeconds,omitempty"`
+    EstimatedReadyAt     string            `json:"estimatedReadyAt,omitempty"`
+    RetryCount           int64             `json:"retryCount,omitempty"`
+    ArtifactsURL         string            `json:"artifactsURL,omitempty"`
+    Convergence          *Convergence      `json:"convergence,omitempty"`
+    PlaybookProgress     *PlaybookProgress `json:"playbookProgress,omitempty"`
+    ToolVersions         map[string]string `json:"toolVersions,omitempty"`
+    ProvisioningSummary  string            `json:"provisioningSummary,omitempty"`
+    ExposedEndpoints     []ExposedEndpoint `json:"exposedEndpoints,omitempty"`
+    Conditions           []Condition       `json:"conditions,omitempty"`
+}
+
+// Playbooks a provisioning run completed on a VM, skipped when a retry resumes there
+type PlaybookProgress struct {
+    VMIP      string   `json:"vmIP,omitempty"`
+    Completed []string `json:"completed,omitempty"`
+}
+
+// Where a requested port can be reached; Reachable is nil for UDP, which isn't checked
+type ExposedEndpoint struct {
+    Name      string `json:"name"`
+    Port      int64  `json:"port"`
+    Protocol  string `json:"protocol"`
+    Endpoint  string `json:"endpoint"`
+    Reachable *bool  `json:"reachable,omitempty"`
+}
+
+// Drift found on a ready VM and the playbook runs that corrected it
+type Convergence struct {
+    Count           int64  `json:"count,omitempty"`
+    LastDrift       string `json:"lastDrift,omitempty"`
+    LastDriftAt     string `json:"lastDriftAt,omitempty"`
+    LastConvergedAt string `json:"lastConvergedAt,omitempty"`
+}
+
+// Provisioned, Verified and ShellReady readiness gates
+type Condition struct {
+    Type               string `json:"type"`
+    Status             string `json:"status"`
+    Reason             string `json:"reason,omitempty"`
+    Message            string `json:"message,omitempty"`
+    LastTransitionTime string `json:"lastTransitionTime,omitempty"`
+}
+
+// Address to reach the VM at, the overlay address for overlay-connected VMs
+func (r *VMProvisioningRequest) Address() string {
+    if r.Status.OverlayIP != "" {
+        return r.Status.OverlayIP
+    }
+    return r.Status.VMIP
+}
+
+func (r *VMProvisioningRequest) IsReady() bool {
+    return r.Status.State == StateReady && r.Status.Provisioned
+}
+
+func (r *VMProvisioningRequest) IsFailed() bool {
+    return r.Status.State == StateFailed
+}
+
+// Failed for good: out of retries, or failed for a reason retrying can't fix
+func (r *VMProvisioningRequest) IsDeadLettered() bool {
+    return r.Labels[DeadLetterLabel] == "true"
+}
+
+func (r *VMProvisioningRequest) IsReleased() bool {
+    return r.Status.State == StateReleased
+}
+
+// Convert to the unstructured object the dynamic client sends
+func (r *VMProvisioningRequest) ToUnstructured() (*unstructured.Unstructured, error) {
+    content, err := runtime.DefaultUnstructuredConverter.ToUnstructured(r)
+    if err != nil {
+        return nil, err
+    }
+    delete(content, "status") // Status is owned by the provisioner
+
+    object := &unstructured.Unstructured{Object: content}
+    object.SetAPIVersion(VMProvisioningRequestGVR.GroupVersion().String())
+    object.SetKind("VMProvisioningRequest")
+    object.SetName(r.Name)
+    object.SetNamespace(DefaultNamespace)
+    object.SetLabels(r.Labels)
+    object.SetAnnotations(r.Annotations)
+    return object, nil
+}
+
+// Convert an object returned by the dynamic client
+func FromUnstructured(object *unstructured.Unstructured) (*VMProvisioningRequest, error) {
+    request := &VMProvisioningRequest{}
+    if err := runtime.DefaultUnstructuredConverter.FromUnstructured(object.Object, request); err != nil {
+        return nil, err
+    }
+    request.Name = object.GetName()
+    request.Labels = object.GetLabels()
+    request.Annotations = object.GetAnnotations()
+    return request, nil
+}
+
+// Status of a CourseProvisioningStatus, aggregated from the course's requests
+type CourseProvisioningStatus struct {
+    DisplayName         string                `json:"displayName,omitempty"`
+    Total               int64                 `json:"total"`
+    Ready               int64                 `json:"ready"`
+    Pending             int64                 `json:"pending"`
+    Failed              int64                 `json:"failed"`
+    Released            int64                 `json:"released"`
+    AverageReadySeconds int64                 `json:"averageReadySeconds,omitempty"`
+    FailedSessions      []CourseFailedSession `json:"failedSessions,omitempty"`
+    UpdatedAt           string                `json:"updatedAt,omitempty"`
+}
+
+type CourseFailedSession struct {
+    Session   string `json:"session"`
+    Request   string `json:"request"`
+    User      string `json:"user,omitempty"`
+    LastError     string `json:"lastError,omitempty"`
+    FailureReason string `json:"failureReason,omitempty"`
+}